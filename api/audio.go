@@ -0,0 +1,53 @@
+package api
+
+import (
+    "fmt"
+    "net/http"
+
+    "ffwebapi/ffmpeg"
+    "github.com/gin-gonic/gin"
+)
+
+type AudioExtractRequest struct {
+    InputMedia   string `json:"inputMedia" binding:"required"`
+    Format       string `json:"format"`     // mp3 (default), aac (written as .m4a), opus or flac
+    Bitrate      int    `json:"bitrate"`    // kbit/s, e.g. 128; 192 for mp3, 160 for aac and 96 for opus if 0. Not for flac
+    SampleRate   int    `json:"sampleRate"` // Hz, e.g. 44100; the input's if 0
+    Channels     int    `json:"channels"`   // 1 for mono or 2 for stereo; the input's if 0
+    Track        int    `json:"track"`      // Audio stream of the input, from 0
+    Priority     string `json:"priority"`
+    Queue        string `json:"queue"`
+    MaxRetries   int    `json:"maxRetries"`
+    RetryBackoff string `json:"retryBackoff"`
+    CallbackURL  string `json:"callbackUrl"`
+}
+
+// handleCreateAudioExtract writes the audio of a video into an audio-only
+// file, e.g. for podcasts.
+func (h *Handler) handleCreateAudioExtract(c *gin.Context) {
+    var req AudioExtractRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+
+    job, err := ffmpeg.BuildAudioExtractCommand(ffmpeg.AudioExtractSpec{
+        Format:     req.Format,
+        Bitrate:    req.Bitrate,
+        SampleRate: req.SampleRate,
+        Channels:   req.Channels,
+        Track:      req.Track,
+    })
+    if err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid audio extract request: %v", err))
+        return
+    }
+    h.submitConversion(c, job, TaskRequest{
+        InputMedia:   req.InputMedia,
+        Priority:     req.Priority,
+        Queue:        req.Queue,
+        MaxRetries:   req.MaxRetries,
+        RetryBackoff: req.RetryBackoff,
+        CallbackURL:  req.CallbackURL,
+    })
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "ffwebapi/task"
+    "github.com/gin-gonic/gin"
+)
+
+// Billing export formats.
+const (
+    billingCSV    = "csv"
+    billingNDJSON = "ndjson"
+)
+
+// billingColumns are the header of CSV billing exports.
+var billingColumns = []string{"task_id", "key_id", "status", "preset", "queue", "created_at", "completed_at",
+    "encode_seconds", "cpu_seconds", "gb_stored", "gb_egressed"}
+
+// BillingLineItem is one task of a billing export, with its storage and
+// egress also in GB (10^9 bytes) as invoiced.
+type BillingLineItem struct {
+    task.LineItem
+    GBStored   float64 `json:"gbStored"`
+    GBEgressed float64 `json:"gbEgressed"`
+}
+
+func newBillingLineItem(item task.LineItem) BillingLineItem {
+    return BillingLineItem{LineItem: item, GBStored: float64(item.StoredBytes) / 1e9, GBEgressed: float64(item.EgressBytes) / 1e9}
+}
+
+func (b BillingLineItem) record() []string {
+    float := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+    return []string{b.TaskID, b.Owner, string(b.Status), b.Preset, b.Queue,
+        b.CreatedAt.UTC().Format(time.RFC3339), b.CompletedAt.UTC().Format(time.RFC3339),
+        float(b.EncodeSeconds), float(b.CPUSeconds), float(b.GBStored), float(b.GBEgressed)}
+}
+
+// handleExportBilling exports the line items of billed tasks that finished
+// in [from, to) as CSV or NDJSON, for invoicing. "from" and "to" are RFC 3339
+// times or dates (UTC); "to" defaults to now and "from" to the start of its
+// month. "keyId" limits the export to one API key. The format is "format",
+// or text/csv if the Accept header asks for it, and NDJSON otherwise.
+func (h *Handler) handleExportBilling(c *gin.Context) {
+    to, err := parseBillingTime(c.Query("to"), time.Now().UTC())
+    if err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid to: %v", err))
+        return
+    }
+    from, err := parseBillingTime(c.Query("from"), time.Date(to.Year(), to.Month(), 1, 0, 0, 0, 0, time.UTC))
+    if err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid from: %v", err))
+        return
+    }
+    if !from.Before(to) {
+        respondError(c, http.StatusBadRequest, "invalid_request", "from must be before to")
+        return
+    }
+    format := c.Query("format")
+    if format == "" {
+        format = billingNDJSON
+        if strings.Contains(c.GetHeader("Accept"), "text/csv") {
+            format = billingCSV
+        }
+    }
+
+    items := h.taskManager.LineItems(c.Query("keyId"), from, to)
+    filename := fmt.Sprintf("billing-%s-%s", from.UTC().Format("20060102T150405Z"), to.UTC().Format("20060102T150405Z"))
+    switch format {
+    case billingCSV:
+        c.Header("Content-Type", "text/csv; charset=utf-8")
+        c.Header("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+        w := csv.NewWriter(c.Writer)
+        w.Write(billingColumns)
+        for _, item := range items {
+            w.Write(newBillingLineItem(item).record())
+        }
+        w.Flush()
+    case billingNDJSON:
+        c.Header("Content-Type", "application/x-ndjson")
+        c.Header("Content-Disposition", `attachment; filename="`+filename+`.ndjson"`)
+        enc := json.NewEncoder(c.Writer)
+        for _, item := range items {
+            enc.Encode(newBillingLineItem(item))
+        }
+    default:
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("format must be %q or %q", billingCSV, billingNDJSON))
+    }
+}
+
+// parseBillingTime reads an RFC 3339 time or a date, taken as UTC midnight.
+func parseBillingTime(value string, fallback time.Time) (time.Time, error) {
+    if value == "" {
+        return fallback, nil
+    }
+    if t, err := time.Parse("2006-01-02", value); err == nil {
+        return t, nil
+    }
+    return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+)
+
+// handleGetTaskCallbacks lists the webhook delivery attempts of a task.
+func (h *Handler) handleGetTaskCallbacks(c *gin.Context) {
+    t, found := h.findTask(c)
+    if !found {
+        return
+    }
+    attempts, found := h.taskManager.CallbackAttempts(t.ID)
+    if !found {
+        respondError(c, http.StatusNotFound, "not_found", "Task not found")
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"taskId": t.ID, "attempts": attempts})
+}
+
+// handleListFailingCallbacks lists the callback endpoints whose latest
+// delivery failed, the ones with the most consecutive failures first.
+func (h *Handler) handleListFailingCallbacks(c *gin.Context) {
+    c.JSON(http.StatusOK, gin.H{"endpoints": h.taskManager.FailingCallbackEndpoints()})
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+    "fmt"
+    "net/http"
+    "time"
+
+    "ffwebapi/ffmpeg"
+    "github.com/gin-gonic/gin"
+)
+
+type ClipRequest struct {
+    InputMedia   string `json:"inputMedia" binding:"required"`
+    Start        string `json:"start"`     // Seconds or HH:MM:SS.mmm, e.g. "90.5" or "00:01:30.5"; the beginning if empty
+    End          string `json:"end"`       // Position the clip ends at; the end of the input if neither it nor duration is set
+    Duration     string `json:"duration"`  // Length of the clip, instead of end
+    Mode         string `json:"mode"`      // "encode" (default) for exact cuts or "copy" to cut at keyframes without re-encoding
+    OutputExt    string `json:"outputExt"` // mp4 (default), mov or mkv
+    Priority     string `json:"priority"`
+    Queue        string `json:"queue"`
+    MaxRetries   int    `json:"maxRetries"`
+    RetryBackoff string `json:"retryBackoff"`
+    CallbackURL  string `json:"callbackUrl"`
+}
+
+// handleCreateClip cuts a clip out of an input, building the seek options so
+// callers don't have to know how their placement before or after -i changes
+// accuracy and timestamps.
+func (h *Handler) handleCreateClip(c *gin.Context) {
+    var req ClipRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+
+    spec := ffmpeg.ClipSpec{Mode: req.Mode, OutputExt: req.OutputExt}
+    var err error
+    parse := func(name, value string, dst *time.Duration) {
+        if value != "" && err == nil {
+            if *dst, err = ffmpeg.ParseClipTime(value); err != nil {
+                err = fmt.Errorf("%s: %w", name, err)
+            }
+        }
+    }
+    parse("start", req.Start, &spec.Start)
+    parse("end", req.End, &spec.End)
+    parse("duration", req.Duration, &spec.Duration)
+    var job *ffmpeg.ConversionJob
+    if err == nil {
+        job, err = ffmpeg.BuildClipCommand(spec)
+    }
+    if err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid clip request: %v", err))
+        return
+    }
+    h.submitConversion(c, job, TaskRequest{
+        InputMedia:   req.InputMedia,
+        Priority:     req.Priority,
+        Queue:        req.Queue,
+        MaxRetries:   req.MaxRetries,
+        RetryBackoff: req.RetryBackoff,
+        CallbackURL:  req.CallbackURL,
+    })
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+    "fmt"
+    "net/http"
+
+    "ffwebapi/ffmpeg"
+    "github.com/gin-gonic/gin"
+)
+
+type ComposeRequest struct {
+    InputMedia      string `json:"inputMedia" binding:"required"` // Source with an alpha channel, e.g. ProRes 4444, VP9 WebM or APNG
+    BackgroundMedia string `json:"backgroundMedia"`               // Video or image to overlay onto, looped as needed
+    BackgroundColor string `json:"backgroundColor"`               // Used without backgroundMedia, e.g. "#00b140"; black if empty
+    X               *int   `json:"x"`                             // Position of the source on the background; centered if unset
+    Y               *int   `json:"y"`
+    OutputExt       string `json:"outputExt"`                     // mov (default), mp4 or mkv
+    Priority        string `json:"priority"`
+    Queue           string `json:"queue"`
+    MaxRetries      int    `json:"maxRetries"`
+    RetryBackoff    string `json:"retryBackoff"`
+    CallbackURL     string `json:"callbackUrl"`
+}
+
+// handleCreateCompose overlays a source with transparency onto a background
+// video, image or color.
+func (h *Handler) handleCreateCompose(c *gin.Context) {
+    var req ComposeRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+
+    job, err := ffmpeg.BuildComposeCommand(ffmpeg.ComposeSpec{
+        BackgroundMedia: req.BackgroundMedia != "",
+        BackgroundColor: req.BackgroundColor,
+        X:               req.X,
+        Y:               req.Y,
+        OutputExt:       req.OutputExt,
+    })
+    if err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid compose request: %v", err))
+        return
+    }
+    var extraInputs []string
+    if req.BackgroundMedia != "" {
+        extraInputs = append(extraInputs, req.BackgroundMedia)
+    }
+    h.submitConversion(c, job, TaskRequest{
+        InputMedia:   req.InputMedia,
+        Priority:     req.Priority,
+        Queue:        req.Queue,
+        MaxRetries:   req.MaxRetries,
+        RetryBackoff: req.RetryBackoff,
+        CallbackURL:  req.CallbackURL,
+    }, extraInputs...)
+}
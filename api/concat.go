@@ -0,0 +1,45 @@
+package api
+
+import (
+    "fmt"
+    "net/http"
+
+    "ffwebapi/ffmpeg"
+    "github.com/gin-gonic/gin"
+)
+
+type ConcatRequest struct {
+    Inputs       []string `json:"inputs" binding:"required"` // Joined in this order; URLs, uploaded inputs or local paths like inputMedia
+    OutputExt    string   `json:"outputExt"`                 // mp4 (default), mov or mkv
+    Priority     string   `json:"priority"`
+    Queue        string   `json:"queue"`
+    MaxRetries   int      `json:"maxRetries"`
+    RetryBackoff string   `json:"retryBackoff"`
+    CallbackURL  string   `json:"callbackUrl"`
+}
+
+// handleCreateConcat joins inputs end to end into one output. Whether they
+// can be stream-copied with the concat demuxer or need re-encoding with the
+// concat filter is decided once they are fetched and probed; the task's
+// concatMethod tells which it was.
+func (h *Handler) handleCreateConcat(c *gin.Context) {
+    var req ConcatRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+
+    job, err := ffmpeg.BuildConcatCommand(len(req.Inputs), req.OutputExt)
+    if err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid concat request: %v", err))
+        return
+    }
+    h.submitConversion(c, job, TaskRequest{
+        InputMedia:   req.Inputs[0],
+        Priority:     req.Priority,
+        Queue:        req.Queue,
+        MaxRetries:   req.MaxRetries,
+        RetryBackoff: req.RetryBackoff,
+        CallbackURL:  req.CallbackURL,
+    }, req.Inputs[1:]...)
+}
@@ -0,0 +1,184 @@
+// FFwebAPI API reference: renders ../openapi.json grouped by tag, with the
+// parameters, bodies and responses of every operation, and sends requests
+// with the API key entered in the header. URLs are relative to the page, so
+// it also works when the server is mounted under a path prefix.
+"use strict";
+
+const $ = (id) => document.getElementById(id);
+
+const state = {
+  spec: null,
+  base: "", // URL of the API version the document describes
+};
+
+// el creates an element with the given properties and children.
+function el(tag, props, ...children) {
+  const node = Object.assign(document.createElement(tag), props);
+  node.append(...children.filter((c) => c !== null && c !== undefined));
+  return node;
+}
+
+async function load() {
+  try {
+    const resp = await fetch("../openapi.json");
+    if (!resp.ok) throw new Error(resp.status + " " + resp.statusText);
+    state.spec = await resp.json();
+  } catch (err) {
+    $("error").textContent = "Could not load the API description: " + err.message;
+    return;
+  }
+  const server = ((state.spec.servers || [])[0] || {}).url || "";
+  state.base = server.startsWith("/") ? ".." + server : server;
+  $("version").textContent = state.spec.info.version;
+  render();
+}
+
+// --- Operations ---
+
+function render() {
+  const byTag = new Map();
+  for (const [path, item] of Object.entries(state.spec.paths)) {
+    for (const [method, op] of Object.entries(item)) {
+      const tag = (op.tags || ["other"])[0];
+      if (!byTag.has(tag)) byTag.set(tag, []);
+      byTag.get(tag).push({ path, method, op });
+    }
+  }
+  const tags = [...byTag.keys()].sort();
+  $("tags").replaceChildren(...tags.map((tag) => el("section", {}, el("h2", { textContent: tag }), ...byTag.get(tag).map(operation))));
+}
+
+function operation({ path, method, op }) {
+  const details = el("details", {},
+    el("summary", {},
+      el("span", { className: "method " + method, textContent: method.toUpperCase() }),
+      el("span", { className: "path", textContent: path }),
+      el("span", { className: "muted", textContent: op.summary || "" })));
+  // The body is built when first opened; the document has many operations.
+  details.addEventListener("toggle", () => {
+    if (details.open && details.childElementCount === 1) details.append(operationBody(path, method, op));
+  });
+  return details;
+}
+
+function operationBody(path, method, op) {
+  const div = el("div", { className: "operation" });
+  const inputs = {};
+  const params = op.parameters || [];
+  if (params.length > 0) {
+    const rows = params.map((p) => {
+      inputs[p.in + ":" + p.name] = el("input", { placeholder: p.required ? "required" : "" });
+      return el("tr", {}, el("td", { className: "path", textContent: p.name }), el("td", { className: "muted", textContent: p.in }),
+        el("td", {}, inputs[p.in + ":" + p.name]));
+    });
+    div.append(el("h3", { textContent: "Parameters" }), el("table", {}, el("tbody", {}, ...rows)));
+  }
+
+  const json = op.requestBody && op.requestBody.content["application/json"];
+  let body = null;
+  if (op.requestBody) {
+    div.append(el("h3", { textContent: "Request body" }));
+    for (const [type, content] of Object.entries(op.requestBody.content)) {
+      div.append(el("p", { className: "muted", textContent: type }), el("pre", { textContent: outline(content.schema) }));
+    }
+    if (json) {
+      body = el("textarea", { value: JSON.stringify(example(json.schema), null, 2) });
+      div.append(body);
+    }
+  }
+
+  div.append(el("h3", { textContent: "Responses" }));
+  for (const [status, resp] of Object.entries(op.responses)) {
+    div.append(el("p", {}, el("strong", { textContent: status }), " " + resp.description));
+    for (const [type, content] of Object.entries(resp.content || {})) {
+      div.append(el("p", { className: "muted", textContent: type }), el("pre", { textContent: outline(content.schema) }));
+    }
+  }
+
+  // Operations with a file body are left to real clients.
+  if (!op.requestBody || json) {
+    const result = el("pre", { hidden: true });
+    const send = el("button", { textContent: "Send request" });
+    send.addEventListener("click", () => tryOut(path, method, inputs, body, result));
+    div.append(el("h3", { textContent: "Try it" }), send, result);
+  }
+  return div;
+}
+
+// tryOut sends the operation with the entered parameters and shows the answer.
+async function tryOut(path, method, inputs, body, result) {
+  let url = state.base + path;
+  const query = new URLSearchParams();
+  for (const [name, input] of Object.entries(inputs)) {
+    const [where, param] = name.split(":");
+    if (where === "path") url = url.replace("{" + param + "}", encodeURIComponent(input.value));
+    else if (input.value !== "") query.set(param, input.value);
+  }
+  if ([...query].length > 0) url += "?" + query;
+  const headers = {};
+  const key = $("key").value;
+  if (key) headers.Authorization = "Bearer " + key;
+  if (body) headers["Content-Type"] = "application/json";
+  result.hidden = false;
+  result.textContent = method.toUpperCase() + " " + url + "\n…";
+  try {
+    const resp = await fetch(url, { method: method.toUpperCase(), headers, body: body ? body.value : undefined });
+    let text = await resp.text();
+    try {
+      text = JSON.stringify(JSON.parse(text), null, 2);
+    } catch {
+      // Not JSON; shown as is
+    }
+    result.textContent = method.toUpperCase() + " " + url + "\n" + resp.status + " " + resp.statusText + "\n\n" + text;
+  } catch (err) {
+    result.textContent = method.toUpperCase() + " " + url + "\n" + err.message;
+  }
+}
+
+// --- Schemas ---
+
+// resolve follows a $ref into the document's components.
+function resolve(schema) {
+  if (!schema || !schema.$ref) return [schema || {}, ""];
+  const name = schema.$ref.split("/").pop();
+  return [state.spec.components.schemas[name] || {}, name];
+}
+
+// outline describes a schema in a compact TypeScript-like notation, e.g.
+// "{ id: string, tags?: [string] }". Components are expanded once per branch.
+function outline(schema, indent = "", seen = new Set()) {
+  const [s, name] = resolve(schema);
+  if (name && seen.has(name)) return name;
+  const inner = name ? new Set(seen).add(name) : seen;
+  if (s.enum) return s.enum.map((v) => JSON.stringify(v)).join(" | ");
+  if (s.oneOf || s.anyOf) return (s.oneOf || s.anyOf).map((v) => outline(v, indent, inner)).join(" | ");
+  if (s.type === "array") return "[" + outline(s.items, indent, inner) + "]";
+  if (s.type === "object" || s.properties) {
+    const props = Object.entries(s.properties || {});
+    if (props.length === 0) {
+      return s.additionalProperties ? "{ [key]: " + outline(s.additionalProperties, indent, inner) + " }" : "{}";
+    }
+    const required = new Set(s.required || []);
+    const lines = props.map(([prop, v]) => indent + "  " + prop + (required.has(prop) ? "" : "?") + ": " + outline(v, indent + "  ", inner));
+    return (name ? name + " " : "") + "{\n" + lines.join("\n") + "\n" + indent + "}";
+  }
+  return (s.format ? s.type + " (" + s.format + ")" : s.type) || "any";
+}
+
+// example builds a request body to start from, with the required fields of
+// objects.
+function example(schema, seen = new Set()) {
+  const [s, name] = resolve(schema);
+  if (name && seen.has(name)) return null;
+  const inner = name ? new Set(seen).add(name) : seen;
+  if (s.enum) return s.enum[0];
+  if (s.type === "array") return [];
+  if (s.type === "object" || s.properties) {
+    const out = {};
+    for (const prop of s.required || []) out[prop] = example((s.properties || {})[prop], inner);
+    return out;
+  }
+  return { string: "", integer: 0, number: 0, boolean: false }[s.type] ?? null;
+}
+
+load();
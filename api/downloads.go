@@ -0,0 +1,186 @@
+package api
+
+import (
+    "archive/zip"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "os"
+    "path"
+    "strconv"
+    "strings"
+    "time"
+
+    "ffwebapi/logging"
+    "ffwebapi/task"
+    "ffwebapi/utils"
+    "github.com/gin-gonic/gin"
+)
+
+// defaultDownloadURLTTL is the validity of signed download URLs without "expiresIn".
+const defaultDownloadURLTTL = time.Hour
+
+// DownloadURLRequest asks for a signed URL of one of a task's artifacts.
+type DownloadURLRequest struct {
+    Artifact  string `json:"artifact"`  // Artifact name; "output" if empty
+    ExpiresIn string `json:"expiresIn"` // Go duration, up to DOWNLOAD_URL_MAX_TTL; 1h if empty
+}
+
+// SignedDownloadURL can be handed to end users: it needs no API key.
+type SignedDownloadURL struct {
+    URL       string    `json:"url"`
+    ExpiresAt time.Time `json:"expiresAt"` // Never after the artifact itself expires
+}
+
+// handleCreateDownloadURL signs a URL for an artifact of a task. The signature
+// is part of the path and covers a whole output directory, so relative
+// references such as the segments of an HLS playlist resolve with it.
+func (h *Handler) handleCreateDownloadURL(c *gin.Context) {
+    var req DownloadURLRequest
+    if c.Request.ContentLength != 0 {
+        if err := c.ShouldBindJSON(&req); err != nil {
+            respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+            return
+        }
+    }
+    t, found := h.findTask(c)
+    if !found {
+        return
+    }
+    t = t.Snapshot()
+
+    ttl := defaultDownloadURLTTL
+    if req.ExpiresIn != "" {
+        d, err := time.ParseDuration(req.ExpiresIn)
+        if err != nil || d <= 0 {
+            respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid expiresIn %q", req.ExpiresIn))
+            return
+        }
+        ttl = d
+    }
+    if max := h.cfg.DownloadURLMaxTTL; max > 0 && ttl > max {
+        if req.ExpiresIn != "" {
+            respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("expiresIn must be at most %s", max))
+            return
+        }
+        ttl = max
+    }
+
+    name := req.Artifact
+    if name == "" {
+        name = task.ArtifactOutput
+    }
+    var artifact *task.Artifact
+    for _, a := range t.Artifacts {
+        if a.Name == name {
+            artifact = a
+        }
+    }
+    if artifact == nil {
+        respondError(c, http.StatusNotFound, "not_found", fmt.Sprintf("Task has no artifact %q", name))
+        return
+    }
+
+    expires := time.Now().Add(ttl).Truncate(time.Second)
+    if !artifact.ExpiresAt.IsZero() && artifact.ExpiresAt.Before(expires) {
+        expires = artifact.ExpiresAt.Truncate(time.Second)
+    }
+    signature := utils.SignRequest(h.signingKey, http.MethodGet, "/files/"+h.taskManager.ArtifactScope(artifact), expires)
+    u := fmt.Sprintf("%s%s/downloads/%d/%s/%s", h.baseURL(c), versionOf(c).basePath(), expires.Unix(), signature, h.taskManager.ArtifactPath(artifact))
+    if artifact.Path == t.OutputPath && t.OutputName != "" {
+        u += "?name=" + url.QueryEscape(t.OutputName)
+    }
+    c.JSON(http.StatusCreated, SignedDownloadURL{URL: u, ExpiresAt: expires})
+}
+
+// handleSignedDownload serves a file to the holder of a signed download URL,
+// without an API key.
+func (h *Handler) handleSignedDownload(c *gin.Context) {
+    filename := strings.TrimPrefix(c.Param("filepath"), "/")
+    scope, ok := h.taskManager.FileScope(filename)
+    if !ok || utils.VerifyRequest(h.signingKey, http.MethodGet, "/files/"+scope, c.Param("expires"), c.Param("signature"), time.Now()) != nil {
+        respondError(c, http.StatusForbidden, "invalid_signature", utils.ErrInvalidSignature.Error())
+        return
+    }
+    filePath, err := h.taskManager.GetFilePath(filename)
+    if err != nil {
+        respondError(c, http.StatusNotFound, "not_found", err.Error())
+        return
+    }
+    // Let caches keep the file no longer than the URL is valid.
+    if unix, err := strconv.ParseInt(c.Param("expires"), 10, 64); err == nil {
+        c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", unix-time.Now().Unix()))
+    }
+    h.serveFile(c, filename, filePath)
+}
+
+// handleDownloadTask sends all outputs of a task as one archive, e.g. the
+// playlists and segments of an HLS output or the files of several
+// ${OUTPUT_n}. The zip is written while it is sent, file by file, so its size
+// is not limited by memory; files are stored uncompressed, as media is
+// compressed already.
+func (h *Handler) handleDownloadTask(c *gin.Context) {
+    if format := c.Query("format"); format != "zip" {
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Unsupported format %q (want zip)", format))
+        return
+    }
+    t, found := h.findTask(c)
+    if !found {
+        return
+    }
+    t = t.Snapshot()
+    if !t.Status.Succeeded() {
+        respondError(c, http.StatusConflict, "task_not_completed", fmt.Sprintf("Task is %s; outputs are only bundled once it completed", t.Status))
+        return
+    }
+    files := t.BundleFiles()
+    if len(files) == 0 {
+        respondError(c, http.StatusNotFound, "not_found", "Task has no outputs left")
+        return
+    }
+
+    c.Header("Content-Type", "application/zip")
+    name := t.ID + ".zip"
+    if t.OutputName != "" {
+        name = strings.TrimSuffix(t.OutputName, path.Ext(t.OutputName)) + ".zip"
+    }
+    c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+    c.Status(http.StatusOK)
+    zw := zip.NewWriter(c.Writer)
+    for _, f := range files {
+        if err := addToZip(zw, f); err != nil {
+            // The status is sent already; a truncated archive fails to open.
+            logging.FromContext(c.Request.Context()).Error("Could not bundle outputs", "task_id", t.ID, "file", f.Name, "error", err)
+            return
+        }
+    }
+    if err := zw.Close(); err != nil {
+        logging.FromContext(c.Request.Context()).Error("Could not bundle outputs", "task_id", t.ID, "error", err)
+    }
+    h.taskManager.RecordEgress(t.ID, int64(c.Writer.Size()))
+}
+
+// addToZip copies a file into a zip archive.
+func addToZip(zw *zip.Writer, f task.BundleFile) error {
+    in, err := os.Open(f.Path)
+    if err != nil {
+        return err
+    }
+    defer in.Close()
+    info, err := in.Stat()
+    if err != nil {
+        return err
+    }
+    header, err := zip.FileInfoHeader(info)
+    if err != nil {
+        return err
+    }
+    header.Name, header.Method = f.Name, zip.Store
+    w, err := zw.CreateHeader(header)
+    if err != nil {
+        return err
+    }
+    _, err = io.Copy(w, in)
+    return err
+}
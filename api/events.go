@@ -0,0 +1,95 @@
+package api
+
+import (
+    "fmt"
+    "time"
+
+    "ffwebapi/task"
+    "github.com/gin-gonic/gin"
+)
+
+// eventInterval is how often the event stream of a running task reports it.
+const eventInterval = time.Second
+
+// handleTaskEvents streams a task as server-sent "status" events: once a
+// second while it is unfinished, and a last time once it is terminal. Each
+// event carries the task as GET /tasks/:taskId returns it, including the
+// latest process metrics, so clients can follow a task without polling.
+func (h *Handler) handleTaskEvents(c *gin.Context) {
+    t, found := h.findTask(c)
+    if !found {
+        return
+    }
+    c.Header("Cache-Control", "no-cache")
+    c.Header("X-Accel-Buffering", "no") // Keep reverse proxies from buffering the stream
+
+    ticker := time.NewTicker(eventInterval)
+    defer ticker.Stop()
+    for {
+        v := h.taskView(c, t)
+        c.SSEvent("status", versionOf(c).mapper.Task(v))
+        c.Writer.Flush()
+        if v.Status.IsTerminal() {
+            return
+        }
+        select {
+        case <-c.Request.Context().Done():
+            return
+        case <-t.Done():
+        case <-ticker.C:
+        }
+    }
+}
+
+// handleTasksEvents streams changes of the caller's tasks as server-sent
+// events, so a dashboard follows all of them over one connection: "status"
+// with the task whenever its status or progress changed, starting with
+// every unfinished task, and "deleted" with its ID once a task is gone.
+func (h *Handler) handleTasksEvents(c *gin.Context) {
+    c.Header("Cache-Control", "no-cache")
+    c.Header("X-Accel-Buffering", "no")
+
+    seen := make(map[string]string) // Fingerprints of the tasks sent, by ID
+    for _, t := range h.taskManager.List() {
+        if v := t.Snapshot(); canSee(c, v.Owner) && v.Status.IsTerminal() {
+            seen[v.ID] = taskFingerprint(v)
+        }
+    }
+    ticker := time.NewTicker(eventInterval)
+    defer ticker.Stop()
+    for {
+        present := make(map[string]bool, len(seen))
+        for _, t := range h.taskManager.List() {
+            if !canSee(c, t.Owner) {
+                continue
+            }
+            present[t.ID] = true
+            v := t.Snapshot()
+            fp := taskFingerprint(v)
+            if seen[t.ID] == fp {
+                continue
+            }
+            seen[t.ID] = fp
+            h.buildDownloadURL(c, v)
+            v.QueuePosition = h.taskManager.QueuePosition(v.ID)
+            c.SSEvent("status", versionOf(c).mapper.Task(v))
+        }
+        for id := range seen {
+            if !present[id] {
+                delete(seen, id)
+                c.SSEvent("deleted", gin.H{"id": id})
+            }
+        }
+        c.Writer.Flush()
+        select {
+        case <-c.Request.Context().Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// taskFingerprint changes whenever a task's status or progress does.
+func taskFingerprint(t *task.Task) string {
+    return fmt.Sprintf("%s/%d/%.1f", t.Status, t.Attempt, t.Percent)
+}
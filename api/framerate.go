@@ -0,0 +1,120 @@
+package api
+
+import (
+    "fmt"
+    "net/http"
+
+    "ffwebapi/ffmpeg"
+    "ffwebapi/task"
+    "github.com/gin-gonic/gin"
+)
+
+type FrameRateRequest struct {
+    InputMedia      string `json:"inputMedia" binding:"required"`
+    FrameRate       string `json:"frameRate" binding:"required"` // e.g. "25", "30000/1001" or "29.97"
+    Method          string `json:"method"`                       // "fps" (default), "interpolate" or "speed"
+    SourceFrameRate string `json:"sourceFrameRate"`              // The input's rate; required for "speed"
+    Timecode        string `json:"timecode"`                     // Start timecode, e.g. "01:00:00:00"; the input's is kept if empty
+    OutputExt       string `json:"outputExt"`                    // mov (default), mp4 or mkv
+    Priority        string `json:"priority"`
+    Queue           string `json:"queue"`
+    MaxRetries      int    `json:"maxRetries"`
+    RetryBackoff    string `json:"retryBackoff"`
+    CallbackURL     string `json:"callbackUrl"`
+}
+
+type TimecodeRequest struct {
+    InputMedia   string `json:"inputMedia" binding:"required"`
+    Timecode     string `json:"timecode" binding:"required"`  // e.g. "01:00:00:00", or "00:59:59;29" in drop-frame
+    FrameRate    string `json:"frameRate" binding:"required"` // The input's rate the timecode counts in
+    OutputExt    string `json:"outputExt"`                    // mov (default), mp4 or mkv
+    Priority     string `json:"priority"`
+    Queue        string `json:"queue"`
+    MaxRetries   int    `json:"maxRetries"`
+    RetryBackoff string `json:"retryBackoff"`
+    CallbackURL  string `json:"callbackUrl"`
+}
+
+// handleCreateFrameRate converts an input to an exact frame rate, by dropping
+// or repeating frames, interpolating new ones, or changing the speed.
+func (h *Handler) handleCreateFrameRate(c *gin.Context) {
+    var req FrameRateRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+
+    spec := ffmpeg.FrameRateSpec{Method: req.Method, Timecode: req.Timecode, OutputExt: req.OutputExt}
+    var err error
+    if spec.Rate, err = ffmpeg.ParseFrameRate(req.FrameRate); err == nil && req.SourceFrameRate != "" {
+        spec.SourceRate, err = ffmpeg.ParseFrameRate(req.SourceFrameRate)
+    }
+    var job *ffmpeg.ConversionJob
+    if err == nil {
+        job, err = ffmpeg.BuildFrameRateCommand(spec)
+    }
+    if err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid frame rate request: %v", err))
+        return
+    }
+    h.submitConversion(c, job, TaskRequest{
+        InputMedia:   req.InputMedia,
+        Priority:     req.Priority,
+        Queue:        req.Queue,
+        MaxRetries:   req.MaxRetries,
+        RetryBackoff: req.RetryBackoff,
+        CallbackURL:  req.CallbackURL,
+    })
+}
+
+// handleCreateTimecode rewraps an input with a new start timecode, without
+// re-encoding it.
+func (h *Handler) handleCreateTimecode(c *gin.Context) {
+    var req TimecodeRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+
+    rate, err := ffmpeg.ParseFrameRate(req.FrameRate)
+    var job *ffmpeg.ConversionJob
+    if err == nil {
+        job, err = ffmpeg.BuildTimecodeCommand(req.Timecode, rate, req.OutputExt)
+    }
+    if err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid timecode request: %v", err))
+        return
+    }
+    h.submitConversion(c, job, TaskRequest{
+        InputMedia:   req.InputMedia,
+        Priority:     req.Priority,
+        Queue:        req.Queue,
+        MaxRetries:   req.MaxRetries,
+        RetryBackoff: req.RetryBackoff,
+        CallbackURL:  req.CallbackURL,
+    })
+}
+
+// submitConversion submits a command the server built, reading extraInputs
+// at ${INPUT_MEDIA_1} and up. Only the request's options and the inputs need
+// checking.
+func (h *Handler) submitConversion(c *gin.Context, job *ffmpeg.ConversionJob, optsReq TaskRequest, extraInputs ...string) {
+    var opts task.SubmitOptions
+    if !h.validateSubmitOptions(c, &optsReq, &opts) {
+        return
+    }
+    for _, input := range extraInputs {
+        if !h.checkInput(c, input) {
+            return
+        }
+    }
+    opts.ExtraInputs = extraInputs
+    opts.Concat = job.Concat
+    t, err := h.taskManager.SubmitWithOptions(job.Command, optsReq.InputMedia, job.OutputExt, opts)
+    if err != nil {
+        respondSubmitError(c, "Failed to create task", err)
+        return
+    }
+    h.setPollHints(c)
+    c.JSON(http.StatusAccepted, acceptedTask(t))
+}
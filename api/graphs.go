@@ -0,0 +1,88 @@
+package api
+
+import (
+    "fmt"
+    "net/http"
+
+    "ffwebapi/task"
+    "github.com/gin-gonic/gin"
+)
+
+// GraphTaskRequest is one task of a graph: the fields of TaskRequest and a
+// ref naming it within the graph. Its dependsOn lists the refs of other
+// tasks of the graph, or IDs of tasks submitted earlier.
+type GraphTaskRequest struct {
+    TaskRequest
+    Ref string `json:"ref" binding:"required"`
+}
+
+// GraphRequest submits a set of tasks with dependencies between them.
+type GraphRequest struct {
+    Tasks []GraphTaskRequest `json:"tasks" binding:"required,min=1,dive"`
+}
+
+// handleCreateGraph submits the tasks of a dependency graph as one batch.
+// Each task starts once all its dependencies completed, and is skipped if
+// one of them does not. The batch can be listed and canceled like that of an
+// import.
+func (h *Handler) handleCreateGraph(c *gin.Context) {
+    var req GraphRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+
+    refs := make(map[string]bool, len(req.Tasks))
+    for _, t := range req.Tasks {
+        if refs[t.Ref] {
+            respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Duplicate ref %q", t.Ref))
+            return
+        }
+        refs[t.Ref] = true
+    }
+    nodes := make([]task.GraphNode, 0, len(req.Tasks))
+    for i := range req.Tasks {
+        t := &req.Tasks[i]
+        _, opts, ok := h.validateTaskRequest(c, &t.TaskRequest)
+        if !ok || !h.checkDependencies(c, &t.TaskRequest, refs) {
+            return
+        }
+        nodes = append(nodes, task.GraphNode{Ref: t.Ref, Command: t.Command, InputMedia: t.InputMedia, OutputExt: t.OutputExt,
+            DependsOn: t.DependsOn, Options: opts})
+    }
+
+    batchID, tasks, err := h.taskManager.SubmitGraph(nodes)
+    if err != nil {
+        respondSubmitError(c, "Failed to create graph", err)
+        return
+    }
+    taskIDs := make(map[string]string, len(tasks))
+    for i, t := range tasks {
+        taskIDs[nodes[i].Ref] = t.ID
+    }
+    h.setPollHints(c)
+    c.JSON(http.StatusAccepted, gin.H{"batchId": batchID, "taskIds": taskIDs})
+}
+
+// checkDependencies checks the dependsOn of a request: refs of other tasks
+// of its graph, or tasks the caller can see. On failure it writes a 400
+// response and returns false.
+func (h *Handler) checkDependencies(c *gin.Context, req *TaskRequest, refs map[string]bool) bool {
+    if len(req.DependsOn) == 0 {
+        return true
+    }
+    if req.RunAt != "" || req.Delay != "" {
+        respondError(c, http.StatusBadRequest, "invalid_request", "Tasks with dependsOn start once their dependencies completed; runAt and delay are not supported")
+        return false
+    }
+    for _, dep := range req.DependsOn {
+        if refs[dep] {
+            continue
+        }
+        if t, ok := h.taskManager.Get(dep); !ok || !canSee(c, t.Owner) {
+            respondError(c, http.StatusBadRequest, "invalid_dependencies", fmt.Sprintf("Unknown dependency %q", dep))
+            return false
+        }
+    }
+    return true
+}
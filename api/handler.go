@@ -1,77 +1,802 @@
 package api
 
 import (
+    "context"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
     "fmt"
-    "log"
     "net/http"
+    "net/url"
     "path/filepath"
+    "strconv"
     "strings"
+    "time"
 
+    "ffwebapi/auth"
     "ffwebapi/config"
     "ffwebapi/ffmpeg"
+    "ffwebapi/logging"
+    "ffwebapi/netguard"
+    "ffwebapi/preset"
     "ffwebapi/task"
+    "ffwebapi/tracing"
+    "ffwebapi/utils"
     "github.com/gin-gonic/gin"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/trace"
 )
 
 type Handler struct {
     taskManager *task.Manager
+    keys        *auth.Store
     cfg         *config.Config
+    signingKey  []byte    // Signs upload URLs of local input storage and download URLs
+    started     time.Time // For the uptime in health reports
 }
 
-func NewHandler(tm *task.Manager, cfg *config.Config) *Handler {
+func NewHandler(tm *task.Manager, keys *auth.Store, cfg *config.Config) *Handler {
+    signingKey := []byte(cfg.UploadSigningKey)
+    if len(signingKey) == 0 {
+        signingKey = make([]byte, 32)
+        rand.Read(signingKey)
+    }
     return &Handler{
         taskManager: tm,
+        keys:        keys,
         cfg:         cfg,
+        signingKey:  signingKey,
+        started:     time.Now(),
     }
 }
 
 type TaskRequest struct {
-    Command    string `json:"command" form:"command" binding:"required"`
-    InputMedia string `json:"inputMedia" form:"inputMedia"`
-    OutputExt  string `json:"outputExt" form:"outputExt" binding:"required"`
+    Command          string   `json:"command" form:"command" binding:"required_without=Target"`
+    InputMedia       string   `json:"inputMedia" form:"inputMedia"`
+    InputID          string   `json:"inputId" form:"inputId"`                   // Uploaded input (POST /inputs) instead of inputMedia
+    OutputExt        string   `json:"outputExt" form:"outputExt" binding:"required_without_all=Outputs Target"`
+    Outputs          []string `json:"outputs" form:"outputs"`                   // Extensions for ${OUTPUT_0}, ${OUTPUT_1}, ...
+    OutputMode       string   `json:"outputMode" form:"outputMode"`             // "file" (default) or "directory", e.g. for HLS
+    OutputName       string   `json:"outputName" form:"outputName"`             // Download file name or template, e.g. "{{.InputBasename}}_720p"
+    Priority         string   `json:"priority" form:"priority"`                 // low, normal (default) or high
+    MaxRetries       int      `json:"maxRetries" form:"maxRetries"`             // Retries after non-cancellation failures
+    RetryBackoff     string   `json:"retryBackoff" form:"retryBackoff"`         // Go duration, e.g. "30s"; doubled per retry
+    RunAt            string   `json:"runAt" form:"runAt"`                       // RFC 3339 time before which the task is not queued
+    Delay            string   `json:"delay" form:"delay"`                       // Go duration to hold the task back for, instead of runAt
+    OutputTTL        string   `json:"outputTtl" form:"outputTtl"`               // Go duration the files are kept, up to MAX_OUTPUT_TTL
+    CallbackURL      string   `json:"callbackUrl" form:"callbackUrl"`           // Receives the task as JSON once it is terminal
+    Subtitles        string   `json:"subtitles" form:"subtitles"`               // "srt" or "vtt" to also transcribe the audio
+    SubtitleLanguage string   `json:"subtitleLanguage" form:"subtitleLanguage"` // e.g. "en"; detected if empty
+    InlineResult     bool     `json:"inlineResult" form:"inlineResult"`         // Embed a small output as a data URI in "resultData"
+    Billing          bool     `json:"billing" form:"billing"`                   // Record a billing line item (GET /admin/billing) once the task finishes
+    QC               string   `json:"qc" form:"qc"`                             // "warn" or "fail" to verify the output with ffprobe
+    Target           string   `json:"target" form:"target"`                     // Conformance target (GET /targets) deriving the command; implies qc "warn"
+    Queue            string   `json:"queue" form:"queue"`                       // Named queue; "default" if empty
+    Tool             string   `json:"tool" form:"tool"`                         // Enabled tool (GET /tools) running the command; "ffmpeg" if empty
+    ResourceClass    string   `json:"resourceClass" form:"resourceClass"`       // RESOURCE_CLASSES entry capping CPU and memory; the defaults if empty
+    Requires         []string `json:"requires" form:"requires"`                 // Node labels the task needs (GET /nodes), e.g. ["gpu", "region:eu"]
+    MaxOutputSize    int64    `json:"maxOutputSize" form:"maxOutputSize"`       // Bytes the outputs may grow to, up to MAX_OUTPUT_SIZE
+    StreamLabels     []task.StreamLabel   `json:"streamLabels" form:"-"`       // Language, title and disposition overrides of output streams
+    PreserveStreamLabels *bool            `json:"preserveStreamLabels" form:"preserveStreamLabels"` // Carry the input's stream labels over; true if unset
+    OutputUpload     *OutputUploadRequest `json:"outputUpload" form:"-"`       // Upload the output to the caller's storage once done
+    OutputStore      string               `json:"outputStore" form:"outputStore"` // Output store the files land in ("local", "s3", ...); OUTPUT_STORE if empty
+    Notify           *NotifyRequest       `json:"notify" form:"-"`             // Slack, Discord and email notifications once the task ends; the NOTIFY_* settings if unset
+    Pipe             *PipeRequest         `json:"pipe" form:"-"`               // Second ffmpeg reading the command's stdout and writing the output
+    DependsOn        []string             `json:"dependsOn" form:"-"`          // Tasks that must complete before this one starts; it is skipped if one fails
+}
+
+// PipeRequest is the second stage of a piped task. The command writes to
+// stdout in the format it sets with "-f"; the second stage reads that at
+// ${INPUT_MEDIA} and writes the output. Each stage may run another
+// FF_BUILDS build, e.g. one with a proprietary decoder.
+type PipeRequest struct {
+    Command    string `json:"command"`
+    Build      string `json:"build"`      // FF_BUILDS entry of the second stage; FF_BIN if empty
+    FirstBuild string `json:"firstBuild"` // FF_BUILDS entry of the command; FF_BIN if empty
+}
+
+// OutputUploadRequest names where the runner sends a task's output, e.g. a
+// presigned S3 URL. URL and headers are never shown in task responses.
+type OutputUploadRequest struct {
+    URL     string            `json:"url"`
+    Method  string            `json:"method"`  // PUT (default) or POST
+    Headers map[string]string `json:"headers"` // e.g. {"x-amz-acl": "private"}
+}
+
+// NotifyRequest names who hears of a task's end, e.g. a Slack channel for an
+// overnight encode. Webhook URLs are never shown in task responses.
+type NotifyRequest struct {
+    Slack   string   `json:"slack"`   // Slack incoming webhook URL
+    Discord string   `json:"discord"` // Discord webhook URL
+    Email   []string `json:"email"`   // Recipients in NOTIFY_EMAIL_DOMAINS
+    On      []string `json:"on"`      // Final statuses notified of, e.g. ["failed"]; all but "interrupted" if empty
+}
+
+// reservedUploadHeaders are set by the HTTP client and may not be overridden.
+var reservedUploadHeaders = map[string]bool{
+    "Host":              true,
+    "Content-Length":    true,
+    "Transfer-Encoding": true,
+    "Connection":        true,
 }
 
 // handleCreateTask handles asynchronous task creation.
 func (h *Handler) handleCreateTask(c *gin.Context) {
     var req TaskRequest
     if err := c.ShouldBindJSON(&req); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+    // Hashed before validation rewrites the request.
+    idemKey, idemHash := c.GetHeader("Idempotency-Key"), requestHash(&req)
+    if len(idemKey) > maxIdempotencyKeyLen {
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Idempotency-Key exceeds %d characters", maxIdempotencyKeyLen))
         return
     }
 
-    // Sanitize and validate before accepting the task
-    splitArgs, err := ffmpeg.SplitCommand(req.Command)
+    estimate, opts, ok := h.validateTaskRequest(c, &req)
+    if !ok || !h.checkDependencies(c, &req, nil) {
+        return
+    }
+    opts.DependsOn = req.DependsOn
+
+    var t *task.Task
+    var err error
+    if idemKey != "" {
+        var replayed bool
+        t, replayed, err = h.taskManager.SubmitIdempotent(idemKey, idemHash, req.Command, req.InputMedia, req.OutputExt, opts)
+        if replayed {
+            c.Header("Idempotent-Replayed", "true")
+        }
+    } else {
+        t, err = h.taskManager.SubmitWithOptions(req.Command, req.InputMedia, req.OutputExt, opts)
+    }
     if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid command syntax: %v", err)})
+        respondSubmitError(c, "Failed to create task", err)
         return
     }
 
-    if err := ffmpeg.SanitizeAndValidateArgs(splitArgs); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid command: %v", err)})
+    resp := acceptedTask(t)
+    if estimate.Size > 0 {
+        resp["estimatedOutputSize"] = estimate.Size
+    }
+    warnings := estimate.Warnings
+    if t.Tool == "" && len(t.OutputExts) == 0 && t.OutputMode != task.OutputModeDirectory {
+        warnings = append(warnings, ffmpeg.CheckAlpha(t.Command, t.OutputExt)...)
+    }
+    if len(warnings) > 0 {
+        resp["warnings"] = warnings
+    }
+    h.setPollHints(c)
+    c.JSON(http.StatusAccepted, resp)
+}
+
+// maxIdempotencyKeyLen is the longest Idempotency-Key accepted.
+const maxIdempotencyKeyLen = 255
+
+// requestHash identifies a submission, so that an Idempotency-Key reused for
+// a different one is told apart from a retry.
+func requestHash(req *TaskRequest) string {
+    body, _ := json.Marshal(req)
+    sum := sha256.Sum256(body)
+    return hex.EncodeToString(sum[:])
+}
+
+// acceptedTask is the body of a 202 response for a submitted task. The trace
+// ID lets callers find the task's spans when tracing is enabled.
+func acceptedTask(t *task.Task) gin.H {
+    resp := gin.H{"taskId": t.ID}
+    if t.TraceID != "" {
+        resp["traceId"] = t.TraceID
+    }
+    return resp
+}
+
+// outputCheck is what validateTaskRequest found out about a task's output:
+// its estimated size, and the limits it would exceed that were only warned
+// about.
+type outputCheck struct {
+    Size     int64 // 0 if unknown
+    Warnings []task.Warning
+}
+
+// sizeLimit rejects a submission for exceeding a size limit, or with
+// OUTPUT_SIZE_WARN_ONLY records a warning with the error's code instead. It
+// returns false once it wrote the response.
+func (h *Handler) sizeLimit(c *gin.Context, check *outputCheck, status int, code, msg string) bool {
+    if !h.cfg.OutputSizeWarnOnly {
+        respondError(c, status, code, msg)
+        return false
+    }
+    check.Warnings = append(check.Warnings, task.Warning{Code: code, Message: msg, Count: 1})
+    return true
+}
+
+// allowlistExts returns the output extensions CheckAllowlist derives the
+// formats of a task's command from. A piped command writes to the pipe and
+// a directory's files are named in the command, so neither has any.
+func allowlistExts(outputExt string, outputs []string, outputMode string, piped bool) []string {
+    switch {
+    case piped || outputMode == task.OutputModeDirectory:
+        return nil
+    case len(outputs) > 0:
+        return outputs
+    }
+    return []string{outputExt}
+}
+
+// validateTaskRequest sanitizes the command and checks the request's options.
+// On failure it writes a 400 response and returns ok=false.
+func (h *Handler) validateTaskRequest(c *gin.Context, req *TaskRequest) (*outputCheck, task.SubmitOptions, bool) {
+    var opts task.SubmitOptions
+    if req.Target != "" && !applyTarget(c, req, &opts) {
+        return nil, opts, false
+    }
+    splitArgs, err := ffmpeg.SplitCommand(req.Command)
+    if err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_command", fmt.Sprintf("Invalid command syntax: %v", err))
+        return nil, opts, false
+    }
+
+    tool, ok := ffmpeg.LookupTool(h.cfg, req.Tool)
+    if !ok {
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Unknown or disabled tool %q", req.Tool))
+        return nil, opts, false
+    }
+    if err := tool.ValidateArgs(splitArgs); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_command", fmt.Sprintf("Invalid command: %v", err))
+        return nil, opts, false
+    }
+    if tool.Name == ffmpeg.ToolFFmpeg {
+        exts := allowlistExts(req.OutputExt, req.Outputs, req.OutputMode, req.Pipe != nil)
+        if err := ffmpeg.CheckAllowlist(h.cfg, splitArgs, exts); err != nil {
+            respondError(c, http.StatusBadRequest, "command_not_allowed", fmt.Sprintf("Command not allowed: %v", err))
+            return nil, opts, false
+        }
+    }
+    if tool.Name != ffmpeg.ToolFFmpeg {
+        if len(req.Outputs) > 0 || req.OutputMode == task.OutputModeDirectory || req.Subtitles != "" || req.Target != "" {
+            respondError(c, http.StatusBadRequest, "invalid_request",
+                fmt.Sprintf("Tool %q supports a single output file only, without subtitles or targets", tool.Name))
+            return nil, opts, false
+        }
+        opts.Tool = tool.Name
+    }
+
+    exts := req.Outputs
+    if len(exts) == 0 {
+        exts = []string{req.OutputExt}
+    }
+    for _, ext := range exts {
+        if err := ffmpeg.ValidateOutputExt(ext); err != nil {
+            respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+            return nil, opts, false
+        }
+    }
+    if err := ffmpeg.ValidateOutputPlaceholders(splitArgs, len(req.Outputs)); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_command", fmt.Sprintf("Invalid command: %v", err))
+        return nil, opts, false
+    }
+    opts.Outputs = req.Outputs
+
+    switch req.OutputMode {
+    case "", task.OutputModeFile:
+    case task.OutputModeDirectory:
+        if len(req.Outputs) > 0 {
+            respondError(c, http.StatusBadRequest, "invalid_request", "outputMode \"directory\" cannot be combined with outputs")
+            return nil, opts, false
+        }
+    default:
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid outputMode %q", req.OutputMode))
+        return nil, opts, false
+    }
+    if err := ffmpeg.ValidateOutputDirPlaceholder(splitArgs, req.OutputMode == task.OutputModeDirectory); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_command", fmt.Sprintf("Invalid command: %v", err))
+        return nil, opts, false
+    }
+    opts.OutputMode = req.OutputMode
+
+    if len(req.StreamLabels) > 0 && (tool.Name != ffmpeg.ToolFFmpeg || len(req.Outputs) > 0 || req.OutputMode == task.OutputModeDirectory) {
+        respondError(c, http.StatusBadRequest, "invalid_request", "streamLabels are only supported for ffmpeg commands with a single output file")
+        return nil, opts, false
+    }
+    if err := ffmpeg.ValidateStreamLabels(req.StreamLabels); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+        return nil, opts, false
+    }
+    opts.StreamLabels = req.StreamLabels
+    opts.SkipStreamLabels = req.PreserveStreamLabels != nil && !*req.PreserveStreamLabels
+
+    // The second stage of a piped task writes the output, so it is what
+    // gets estimated.
+    outputArgs := splitArgs
+    if req.Pipe != nil {
+        if outputArgs, ok = h.validatePipe(c, req, tool, splitArgs, &opts); !ok {
+            return nil, opts, false
+        }
+    }
+
+    // Estimate the output size up front so we don't burn CPU on an encode
+    // that would be rejected anyway. Other tools' outputs are not estimated.
+    estimate := &ffmpeg.OutputEstimate{}
+    if tool.Name == ffmpeg.ToolFFmpeg {
+        estimate, err = ffmpeg.EstimateOutput(outputArgs)
+    }
+    check := &outputCheck{Size: estimate.Size}
+    if err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_command", fmt.Sprintf("Invalid command: %v", err))
+        return nil, opts, false
+    }
+    if req.MaxOutputSize < 0 {
+        respondError(c, http.StatusBadRequest, "invalid_request", "maxOutputSize must not be negative")
+        return nil, opts, false
+    }
+    if h.cfg.MaxOutputSize > 0 && req.MaxOutputSize > h.cfg.MaxOutputSize {
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("maxOutputSize may be at most %d bytes", h.cfg.MaxOutputSize))
+        return nil, opts, false
+    }
+    opts.MaxOutputSize = req.MaxOutputSize
+    maxOutputSize := h.cfg.MaxOutputSize
+    if req.MaxOutputSize > 0 {
+        maxOutputSize = req.MaxOutputSize
+    }
+    if maxOutputSize > 0 && estimate.Size > maxOutputSize && !h.sizeLimit(c, check, http.StatusBadRequest, "output_too_large",
+        fmt.Sprintf("Estimated output size %d bytes exceeds limit of %d bytes", estimate.Size, maxOutputSize)) {
+        return nil, opts, false
+    }
+
+    if !h.validateSubmitOptions(c, req, &opts) {
+        return nil, opts, false
+    }
+    // The output must also fit in what is left of the key's storage quota.
+    if v, ok := c.Get(apiKeyKey); ok && estimate.Size > 0 {
+        key := v.(*auth.Key)
+        if quota := h.keys.QuotaOf(key); quota.MaxStorage > 0 {
+            used := h.taskManager.Usage(key.ID, time.Now()).StorageBytes
+            if used+estimate.Size > quota.MaxStorage && !h.sizeLimit(c, check, http.StatusTooManyRequests, "quota_exceeded",
+                fmt.Sprintf("Estimated output size %d bytes exceeds the %d bytes left of the storage quota", estimate.Size, max(quota.MaxStorage-used, 0))) {
+                return nil, opts, false
+            }
+        }
+    }
+    return check, opts, true
+}
+
+// validateSubmitOptions checks the input and scheduling options of a request
+// and fills them into opts. On failure it writes a 400 response and returns false.
+func (h *Handler) validateSubmitOptions(c *gin.Context, req *TaskRequest, opts *task.SubmitOptions) bool {
+    var err error
+    opts.TraceParent = trace.SpanContextFromContext(c.Request.Context())
+    opts.RequestID = c.GetString("requestId")
+    opts.Queue = req.Queue
+    if v, ok := c.Get(apiKeyKey); ok {
+        key := v.(*auth.Key)
+        opts.Owner = key.ID
+        if key.Queue != "" {
+            if req.Queue != "" && req.Queue != key.Queue {
+                respondError(c, http.StatusForbidden, "forbidden", fmt.Sprintf("API key is limited to queue %q", key.Queue))
+                return false
+            }
+            opts.Queue = key.Queue
+        }
+        if !h.checkQuota(c, key, opts) {
+            return false
+        }
+    }
+    if opts.Queue != "" && !h.hasQueue(opts.Queue) {
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Unknown queue %q", opts.Queue))
+        return false
+    }
+    for _, label := range req.Requires {
+        if err := task.ValidateLabel(label); err != nil {
+            respondError(c, http.StatusBadRequest, "invalid_request", "requires: "+err.Error())
+            return false
+        }
+    }
+    opts.Requires = req.Requires
+    if req.InputID != "" {
+        if req.InputMedia != "" {
+            respondError(c, http.StatusBadRequest, "invalid_request", "inputId and inputMedia are mutually exclusive")
+            return false
+        }
+        req.InputMedia = task.InputRef(req.InputID)
+    }
+    if !h.checkInput(c, req.InputMedia) {
+        return false
+    }
+    if req.OutputName != "" {
+        ext := req.OutputExt
+        if len(req.Outputs) > 0 {
+            ext = req.Outputs[0]
+        }
+        if opts.OutputName, err = renderOutputName(req.OutputName, newOutputNameData(req.InputMedia, ext, ""), ext); err != nil {
+            respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+            return false
+        }
+    }
+
+    if req.CallbackURL != "" {
+        if !strings.HasPrefix(req.CallbackURL, "http://") && !strings.HasPrefix(req.CallbackURL, "https://") {
+            respondError(c, http.StatusBadRequest, "invalid_request", "callbackUrl must be an http(s) URL")
+            return false
+        }
+        if err := netguard.InputPolicy(h.cfg).CheckURL(req.CallbackURL); err != nil {
+            respondError(c, http.StatusBadRequest, "callback_egress_denied", err.Error())
+            return false
+        }
+        opts.CallbackURL = req.CallbackURL
+    }
+
+    if req.Notify != nil {
+        n := &task.Notify{Slack: req.Notify.Slack, Discord: req.Notify.Discord, Email: req.Notify.Email}
+        for _, s := range req.Notify.On {
+            n.On = append(n.On, task.Status(s))
+        }
+        if err := task.ValidateNotify(h.cfg, n); err != nil {
+            respondError(c, http.StatusBadRequest, "invalid_request", "notify: "+err.Error())
+            return false
+        }
+        opts.Notify = n
+    }
+
+    if req.OutputUpload != nil && !h.validateOutputUpload(c, req, opts) {
+        return false
+    }
+
+    if req.OutputStore != "" {
+        if _, ok := task.LookupOutputStore(req.OutputStore); !ok && !strings.EqualFold(req.OutputStore, task.OutputStoreLocal) {
+            respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Unknown output store %q", req.OutputStore))
+            return false
+        }
+        opts.OutputStore = req.OutputStore
+    }
+
+    if req.Subtitles != "" {
+        if !h.validateSubtitles(c, req.Subtitles, req.SubtitleLanguage) {
+            return false
+        }
+        opts.Subtitles, opts.SubtitleLanguage = req.Subtitles, req.SubtitleLanguage
+    }
+
+    opts.Billing = req.Billing
+
+    if req.InlineResult {
+        if h.cfg.InlineResultMaxSize <= 0 {
+            respondError(c, http.StatusBadRequest, "invalid_request", "inlineResult is disabled on this server")
+            return false
+        }
+        opts.InlineResult = true
+    }
+
+    switch req.QC {
+    case "":
+        if opts.Target != nil {
+            opts.QC = task.QCModeWarn
+        }
+    case task.QCModeWarn, task.QCModeFail:
+        opts.QC = req.QC
+    default:
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid qc %q (want \"warn\" or \"fail\")", req.QC))
+        return false
+    }
+
+    if opts.Priority, err = task.ParsePriority(req.Priority); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+        return false
+    }
+    if req.ResourceClass != "" {
+        var ok bool
+        if opts.ResourceClass, ok = ffmpeg.LookupResourceClass(h.cfg, req.ResourceClass); !ok {
+            respondError(c, http.StatusBadRequest, "invalid_request",
+                fmt.Sprintf("Unknown resource class %q (available: %s)", req.ResourceClass, strings.Join(ffmpeg.ResourceClasses(h.cfg), ", ")))
+            return false
+        }
+    }
+
+    if req.MaxRetries < 0 || req.MaxRetries > h.cfg.MaxRetries {
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("maxRetries must be between 0 and %d", h.cfg.MaxRetries))
+        return false
+    }
+    opts.MaxRetries = req.MaxRetries
+    if req.RetryBackoff != "" {
+        if opts.RetryBackoff, err = time.ParseDuration(req.RetryBackoff); err != nil || opts.RetryBackoff < 0 {
+            respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid retryBackoff %q", req.RetryBackoff))
+            return false
+        }
+        if h.cfg.MaxRetryBackoff > 0 && opts.RetryBackoff > h.cfg.MaxRetryBackoff {
+            respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("retryBackoff must be at most %s", h.cfg.MaxRetryBackoff))
+            return false
+        }
+    }
+    switch {
+    case req.RunAt != "" && req.Delay != "":
+        respondError(c, http.StatusBadRequest, "invalid_request", "runAt and delay are mutually exclusive")
+        return false
+    case req.RunAt != "":
+        if opts.RunAt, err = time.Parse(time.RFC3339, req.RunAt); err != nil {
+            respondError(c, http.StatusBadRequest, "invalid_request", "runAt must be an RFC 3339 time")
+            return false
+        }
+    case req.Delay != "":
+        delay, err := time.ParseDuration(req.Delay)
+        if err != nil || delay < 0 {
+            respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid delay %q", req.Delay))
+            return false
+        }
+        opts.RunAt = time.Now().Add(delay)
+    }
+    if req.OutputTTL != "" {
+        opts.OutputTTL, err = time.ParseDuration(req.OutputTTL)
+        if err != nil || opts.OutputTTL <= 0 || (h.cfg.MaxOutputTTL > 0 && opts.OutputTTL > h.cfg.MaxOutputTTL) {
+            respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("outputTtl must be a duration up to %s", h.cfg.MaxOutputTTL))
+            return false
+        }
+    }
+    return true
+}
+
+// checkInput checks that an input may be read: an uploaded input the caller
+// owns, a known source, one a registered input provider accepts, a URL the
+// input policy allows, or a local path, which callers without the admin
+// scope may only name below LOCAL_INPUT_DIRS. On failure it writes an error
+// response and returns false.
+func (h *Handler) checkInput(c *gin.Context, inputMedia string) bool {
+    if inputID, ok := task.InputRefID(inputMedia); ok {
+        return h.resolveInput(c, inputID)
+    }
+    if netguard.IsSourceRef(inputMedia) {
+        // Resolved again by the runner; the URL itself is never stored.
+        if _, err := netguard.ResolveSource(h.cfg, inputMedia); err != nil {
+            respondError(c, http.StatusBadRequest, "invalid_input_source", err.Error())
+            return false
+        }
+    } else if ok, err := ffmpeg.CheckRegisteredInput(inputMedia); ok {
+        if err != nil {
+            respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+            return false
+        }
+    } else if netguard.IsURL(inputMedia) {
+        if err := netguard.InputPolicy(h.cfg).CheckURL(inputMedia); err != nil {
+            respondError(c, http.StatusBadRequest, "input_egress_denied", err.Error())
+            return false
+        }
+    } else if _, scoped := tenantOf(c); scoped && inputMedia != "" && ffmpeg.InputScheme(inputMedia) == "" {
+        if err := ffmpeg.CheckLocalInput(h.cfg, inputMedia); err != nil {
+            respondError(c, http.StatusBadRequest, "input_path_denied", err.Error())
+            return false
+        }
+    }
+    return true
+}
+
+// validatePipe checks the second stage of a piped task and fills it into
+// opts, returning its split arguments. On failure it writes a 400 response
+// and returns ok=false.
+func (h *Handler) validatePipe(c *gin.Context, req *TaskRequest, tool ffmpeg.Tool, firstArgs []string, opts *task.SubmitOptions) ([]string, bool) {
+    if tool.Name != ffmpeg.ToolFFmpeg || len(req.Outputs) > 0 || req.OutputMode == task.OutputModeDirectory || len(req.StreamLabels) > 0 || req.Subtitles != "" {
+        respondError(c, http.StatusBadRequest, "invalid_request", "pipe supports ffmpeg commands with a single output file only, without streamLabels or subtitles")
+        return nil, false
+    }
+    if err := ffmpeg.CheckPipe(firstArgs); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_command", fmt.Sprintf("Invalid command: %v", err))
+        return nil, false
+    }
+    args, err := ffmpeg.SplitCommand(req.Pipe.Command)
+    if err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_command", fmt.Sprintf("Invalid pipe.command syntax: %v", err))
+        return nil, false
+    }
+    if err := tool.ValidateArgs(args); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_command", fmt.Sprintf("Invalid pipe.command: %v", err))
+        return nil, false
+    }
+    if _, err := ffmpeg.PipeArgs(req.Pipe.Command); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_command", fmt.Sprintf("Invalid pipe.command: %v", err))
+        return nil, false
+    }
+    if err := ffmpeg.ValidateOutputPlaceholders(args, 0); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_command", fmt.Sprintf("Invalid pipe.command: %v", err))
+        return nil, false
+    }
+    if err := ffmpeg.ValidateOutputDirPlaceholder(args, false); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_command", fmt.Sprintf("Invalid pipe.command: %v", err))
+        return nil, false
+    }
+    if err := ffmpeg.CheckAllowlist(h.cfg, args, []string{req.OutputExt}); err != nil {
+        respondError(c, http.StatusBadRequest, "command_not_allowed", fmt.Sprintf("pipe.command not allowed: %v", err))
+        return nil, false
+    }
+    for _, build := range []string{req.Pipe.Build, req.Pipe.FirstBuild} {
+        if _, ok := ffmpeg.LookupBuild(h.cfg, build); !ok {
+            respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Unknown ffmpeg build %q", build))
+            return nil, false
+        }
+    }
+    opts.Pipe = &task.Pipe{Command: req.Pipe.Command, Build: req.Pipe.Build, FirstBuild: req.Pipe.FirstBuild}
+    return args, true
+}
+
+// validateOutputUpload checks the upload destination of a request and fills
+// it into opts. On failure it writes a 400 response and returns false.
+func (h *Handler) validateOutputUpload(c *gin.Context, req *TaskRequest, opts *task.SubmitOptions) bool {
+    up := req.OutputUpload
+    if len(req.Outputs) > 0 || req.OutputMode == task.OutputModeDirectory {
+        respondError(c, http.StatusBadRequest, "invalid_request", "outputUpload supports a single output file only")
+        return false
+    }
+    if !strings.HasPrefix(up.URL, "http://") && !strings.HasPrefix(up.URL, "https://") {
+        respondError(c, http.StatusBadRequest, "invalid_request", "outputUpload.url must be an http(s) URL")
+        return false
+    }
+    if err := netguard.InputPolicy(h.cfg).CheckURL(up.URL); err != nil {
+        respondError(c, http.StatusBadRequest, "upload_egress_denied", err.Error())
+        return false
+    }
+    method := strings.ToUpper(up.Method)
+    switch method {
+    case "":
+        method = http.MethodPut
+    case http.MethodPut, http.MethodPost:
+    default:
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid outputUpload.method %q (want PUT or POST)", up.Method))
+        return false
+    }
+    headers := make(map[string]string, len(up.Headers))
+    for name, value := range up.Headers {
+        name = http.CanonicalHeaderKey(name)
+        if reservedUploadHeaders[name] {
+            respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("outputUpload.headers may not set %s", name))
+            return false
+        }
+        headers[name] = value
+    }
+    opts.OutputUpload = &task.OutputUpload{URL: up.URL, Method: method, Headers: headers, Status: task.UploadPending}
+    return true
+}
+
+func (h *Handler) hasQueue(name string) bool {
+    for _, q := range h.taskManager.Queues() {
+        if q == name {
+            return true
+        }
+    }
+    return false
+}
+
+// respondSubmitError reports why the task manager rejected a submission: a
+// full queue is the caller's cue to back off and retry later, anything else
+// is our fault.
+func respondSubmitError(c *gin.Context, message string, err error) {
+    var full *task.QueueFullError
+    if errors.As(err, &full) {
+        c.Header("Retry-After", strconv.Itoa(int(queueFullRetryAfter.Seconds())))
+        body := versionOf(c).mapper.Error(http.StatusTooManyRequests, "queue_full", err.Error())
+        body["queue"], body["queueDepth"], body["queueCapacity"] = full.Queue, full.Depth, full.Capacity
+        c.AbortWithStatusJSON(http.StatusTooManyRequests, body)
+        return
+    }
+    var unplaceable *task.PlacementError
+    if errors.As(err, &unplaceable) {
+        body := versionOf(c).mapper.Error(http.StatusUnprocessableEntity, "unsatisfiable_requirements", err.Error())
+        body["missing"] = unplaceable.Missing
+        c.AbortWithStatusJSON(http.StatusUnprocessableEntity, body)
+        return
+    }
+    if errors.Is(err, task.ErrStandby) {
+        c.Header("Retry-After", strconv.Itoa(int(maxPollInterval.Seconds())))
+        respondError(c, http.StatusServiceUnavailable, "standby", "This node is a standby; submit tasks to the primary")
         return
     }
+    if errors.Is(err, task.ErrDependencyNotFound) || errors.Is(err, task.ErrDependencyCycle) {
+        respondError(c, http.StatusBadRequest, "invalid_dependencies", err.Error())
+        return
+    }
+    if errors.Is(err, task.ErrIdempotencyKeyReused) {
+        respondError(c, http.StatusUnprocessableEntity, "idempotency_key_reused", err.Error())
+        return
+    }
+    if errors.Is(err, task.ErrQueueDraining) {
+        c.Header("Retry-After", strconv.Itoa(int(maxPollInterval.Seconds())))
+        respondError(c, http.StatusServiceUnavailable, "queue_draining", err.Error())
+        return
+    }
+    respondErrorDetails(c, http.StatusInternalServerError, "internal_error", message, err.Error())
+}
 
-    t, err := h.taskManager.Submit(req.Command, req.InputMedia, req.OutputExt)
+// handleListTasks lists the caller's tasks one page at a time; admins see all
+// of them and may filter by "owner". See parseTaskQuery for the other filters.
+func (h *Handler) handleListTasks(c *gin.Context) {
+    v := versionOf(c)
+    q, err := parseTaskQuery(c, v.PageSize)
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create task", "details": err.Error()})
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
         return
     }
+    batchID, scheduleID := c.Query("batchId"), c.Query("scheduleId")
+    owner, ownerSet := c.GetQuery("owner")
+    var tasks []*task.Task
+    for _, t := range h.taskManager.List() {
+        t = t.Snapshot()
+        if (batchID != "" && t.BatchID != batchID) || (scheduleID != "" && t.ScheduleID != scheduleID) {
+            continue
+        }
+        if !canSee(c, t.Owner) || (ownerSet && t.Owner != owner) || !q.matches(t) {
+            continue
+        }
+        tasks = append(tasks, t)
+    }
 
-    c.JSON(http.StatusAccepted, gin.H{"taskId": t.ID})
+    tasks, nextCursor := q.page(tasks)
+    resp := make([]interface{}, 0, len(tasks))
+    for _, t := range tasks {
+        resp = append(resp, v.mapper.Task(t))
+    }
+    if nextCursor != "" {
+        c.Header("X-Next-Cursor", nextCursor)
+    }
+    c.JSON(http.StatusOK, v.mapper.TaskList(resp, nextCursor))
 }
 
-// handleListTasks lists all tasks.
-func (h *Handler) handleListTasks(c *gin.Context) {
-    tasks := h.taskManager.List()
-    c.JSON(http.StatusOK, tasks)
+// taskView returns a snapshot of a task for a response, with the download URLs
+// and queue position filled in. Stored tasks are shared with the workers and
+// other requests, so they are never changed for a response.
+func (h *Handler) taskView(c *gin.Context, t *task.Task) *task.Task {
+    v := t.Snapshot()
+    h.buildDownloadURL(c, v)
+    v.QueuePosition = h.taskManager.QueuePosition(v.ID)
+    return v
 }
 
-// buildDownloadURL constructs the full URL for a completed task's file.
+// buildDownloadURL constructs the full URLs for a completed task's files.
 func (h *Handler) buildDownloadURL(c *gin.Context, t *task.Task) {
-    if t.Status != task.StatusCompleted || t.OutputPath == "" {
+    if !t.Status.IsTerminal() {
+        return
+    }
+
+    // Download links stay within the API version the client is using.
+    filesURL := h.baseURL(c) + versionOf(c).basePath() + "/files"
+    // Failed tasks still have their log.
+    for _, a := range t.Artifacts {
+        a.DownloadURL = filesURL + "/" + h.taskManager.ArtifactPath(a)
+    }
+    if !t.Status.Succeeded() || t.OutputPath == "" {
         return
     }
 
+    // Directory outputs are addressed as <taskId>/<dir>/<entry>, so relative
+    // references in e.g. an HLS playlist resolve to sibling files.
+    t.DownloadURL = h.fileURL(c, t, filesURL, t.OutputPath)
+    if t.OutputName != "" && t.OutputStore == "" {
+        t.DownloadURL += "?name=" + url.QueryEscape(t.OutputName)
+    }
+
+    if t.SubtitlePath != "" && t.SubtitlePath != t.OutputPath {
+        t.SubtitleURL = h.fileURL(c, t, filesURL, t.SubtitlePath)
+    }
+
+    t.DownloadURLs = nil
+    for _, path := range t.OutputPaths {
+        t.DownloadURLs = append(t.DownloadURLs, h.fileURL(c, t, filesURL, path))
+    }
+}
+
+// fileURL returns the download URL of an output file: generated by the
+// task's output store, or below the files endpoint for the local one.
+func (h *Handler) fileURL(c *gin.Context, t *task.Task, filesURL, path string) string {
+    u, ok, err := h.taskManager.StoredURL(t, path)
+    if err != nil {
+        logging.FromContext(c.Request.Context()).Warn("Could not get the URL of a stored output; linking the local copy", "task_id", t.ID, "output_store", t.OutputStore, "error", err)
+    }
+    if ok {
+        return u
+    }
+    return fmt.Sprintf("%s/%s", filesURL, h.taskManager.URLPath(path))
+}
+
+// baseURL is the externally visible URL of the server, without trailing slash.
+func (h *Handler) baseURL(c *gin.Context) string {
     baseURL := h.cfg.BaseURL
     if baseURL == "" {
         scheme := "http"
@@ -80,54 +805,356 @@ func (h *Handler) buildDownloadURL(c *gin.Context, t *task.Task) {
         }
         baseURL = fmt.Sprintf("%s://%s", scheme, c.Request.Host)
     }
-    baseURL = strings.TrimSuffix(baseURL, "/")
+    return strings.TrimSuffix(baseURL, "/")
+}
+
+// handleListPresets lists the presets usable in manifests.
+func (h *Handler) handleListPresets(c *gin.Context) {
+    c.JSON(http.StatusOK, preset.List())
+}
+
+// handleListTargets lists the conformance targets tasks can be submitted for.
+func (h *Handler) handleListTargets(c *gin.Context) {
+    c.JSON(http.StatusOK, preset.Targets())
+}
+
+// handleListTools lists the tools tasks may name in "tool".
+func (h *Handler) handleListTools(c *gin.Context) {
+    c.JSON(http.StatusOK, ffmpeg.EnabledTools(h.cfg))
+}
+
+// handleListNodes lists the nodes and their labels, which tasks may name in
+// "requires".
+func (h *Handler) handleListNodes(c *gin.Context) {
+    c.JSON(http.StatusOK, h.taskManager.Nodes())
+}
+
+// applyTarget replaces the command and output of a request for a conformance
+// target with the target's. On failure it writes a 400 response and returns false.
+func applyTarget(c *gin.Context, req *TaskRequest, opts *task.SubmitOptions) bool {
+    target, ok := preset.LookupTarget(req.Target)
+    switch {
+    case !ok:
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Unknown target %q", req.Target))
+        return false
+    case req.Command != "" || len(req.Outputs) > 0 || req.OutputMode == task.OutputModeDirectory:
+        respondError(c, http.StatusBadRequest, "invalid_request", "target cannot be combined with command, outputs or outputMode \"directory\"")
+        return false
+    case req.OutputExt != "" && req.OutputExt != target.OutputExt:
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Target %q produces %s files", target.Name, target.OutputExt))
+        return false
+    }
+    req.Command, req.OutputExt = target.Command, target.OutputExt
+    opts.Target = &target.ConformanceTarget
+    return true
+}
 
-    filename := filepath.Base(t.OutputPath)
-    t.DownloadURL = fmt.Sprintf("%s/api/v1/files/%s", baseURL, filename)
+// findTask looks up the task named in the path. Tasks of other tenants are
+// reported as missing, so their IDs cannot be probed. On failure it writes a
+// 404 response and returns false.
+func (h *Handler) findTask(c *gin.Context) (*task.Task, bool) {
+    t, found := h.taskManager.Get(c.Param("taskId"))
+    if !found || !canSee(c, t.Owner) {
+        respondError(c, http.StatusNotFound, "not_found", "Task not found")
+        return nil, false
+    }
+    return t, true
 }
 
 // handleGetTaskStatus retrieves the status of a single task.
 func (h *Handler) handleGetTaskStatus(c *gin.Context) {
-    taskID := c.Param("taskId")
-    t, found := h.taskManager.Get(taskID)
+    t, found := h.findTask(c)
     if !found {
-        c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
         return
     }
 
-    h.buildDownloadURL(c, t)
-    c.JSON(http.StatusOK, t)
+    t = h.taskView(c, t)
+    if !t.Status.IsTerminal() {
+        h.setPollHints(c)
+    }
+    respondTask(c, http.StatusOK, t)
+}
+
+const (
+    minPollInterval = 1 * time.Second
+    maxPollInterval = 60 * time.Second
+    // How long clients should wait before submitting to a full queue again:
+    // long enough for some of its tasks to finish, short enough not to leave
+    // slots idle once they have.
+    queueFullRetryAfter = 30 * time.Second
+)
+
+// setPollHints tells clients how long to wait before polling a task again.
+// The interval grows with the number of queued tasks per processing slot, so
+// well-behaved clients back off when the server is busy.
+func (h *Handler) setPollHints(c *gin.Context) {
+    depth := h.taskManager.QueueDepth()
+    slots := h.cfg.MaxConcurrency
+    if slots < 1 {
+        slots = 1
+    }
+
+    interval := minPollInterval * time.Duration(1+depth/slots)
+    if interval > maxPollInterval {
+        interval = maxPollInterval
+    }
+    seconds := strconv.Itoa(int(interval.Seconds()))
+    c.Header("Retry-After", seconds)
+    c.Header("X-Poll-After", seconds)
+    c.Header("X-Queue-Depth", strconv.Itoa(depth))
 }
 
 // handleCancelTask cancels a task.
 func (h *Handler) handleCancelTask(c *gin.Context) {
-    taskID := c.Param("taskId")
-    err := h.taskManager.Cancel(taskID)
+    t, found := h.findTask(c)
+    if !found {
+        return
+    }
+    err := h.taskManager.Cancel(t.ID)
     if err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
         return
     }
     c.JSON(http.StatusOK, gin.H{"message": "Task cancellation requested"})
 }
 
-// handleGetFile serves a completed output file.
+// handleRetryTask submits a failed, canceled or skipped task again as a new
+// task, which the caller owns.
+func (h *Handler) handleRetryTask(c *gin.Context) {
+    t, found := h.findTask(c)
+    if !found {
+        return
+    }
+    ro := task.RetryOptions{RequestID: c.GetString("requestId")}
+    if !h.checkRetry(c, t, &ro) {
+        return
+    }
+    retry, err := h.taskManager.Retry(t.ID, ro)
+    if errors.Is(err, task.ErrNotRetryable) || (err != nil && t.PipelineID != "") {
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+    if err != nil {
+        respondSubmitError(c, "Failed to retry task", err)
+        return
+    }
+    h.setPollHints(c)
+    c.JSON(http.StatusAccepted, acceptedTask(retry))
+}
+
+// checkRetry applies the checks of a submission to the task a retry runs
+// again, since the caller's key, its quota and the server's policies may
+// differ from when the original was submitted: the key's queue and quota,
+// the tool and command, and the inputs and URLs the task reaches out to. On
+// failure it writes an error response and returns false.
+func (h *Handler) checkRetry(c *gin.Context, t *task.Task, ro *task.RetryOptions) bool {
+    if v, ok := c.Get(apiKeyKey); ok {
+        key := v.(*auth.Key)
+        ro.Owner = key.ID
+        if key.Queue != "" && t.Queue != key.Queue {
+            respondError(c, http.StatusForbidden, "forbidden", fmt.Sprintf("API key is limited to queue %q", key.Queue))
+            return false
+        }
+        var opts task.SubmitOptions
+        if !h.checkQuota(c, key, &opts) {
+            return false
+        }
+        ro.MaxRunning = opts.MaxRunning
+    }
+
+    // Commands with further inputs are built by the server, not by clients.
+    if len(t.ExtraInputs) == 0 {
+        tool, ok := ffmpeg.LookupTool(h.cfg, t.Tool)
+        if !ok {
+            respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Unknown or disabled tool %q", t.Tool))
+            return false
+        }
+        commands := []string{t.Command}
+        if t.Pipe != nil { // Only ffmpeg commands are piped
+            commands = append(commands, t.Pipe.Command)
+            for _, build := range []string{t.Pipe.Build, t.Pipe.FirstBuild} {
+                if _, ok := ffmpeg.LookupBuild(h.cfg, build); !ok {
+                    respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Unknown ffmpeg build %q", build))
+                    return false
+                }
+            }
+        }
+        exts := [][]string{allowlistExts(t.OutputExt, t.OutputExts, t.OutputMode, t.Pipe != nil), {t.OutputExt}}
+        for i, command := range commands {
+            args, err := ffmpeg.SplitCommand(command)
+            if err == nil {
+                err = tool.ValidateArgs(args)
+            }
+            if err != nil {
+                respondError(c, http.StatusBadRequest, "invalid_command", fmt.Sprintf("Invalid command: %v", err))
+                return false
+            }
+            if tool.Name == ffmpeg.ToolFFmpeg {
+                if err := ffmpeg.CheckAllowlist(h.cfg, args, exts[i]); err != nil {
+                    respondError(c, http.StatusBadRequest, "command_not_allowed", fmt.Sprintf("Command not allowed: %v", err))
+                    return false
+                }
+            }
+        }
+    }
+
+    for _, input := range append([]string{t.InputMedia}, t.ExtraInputs...) {
+        if !h.checkInput(c, input) {
+            return false
+        }
+    }
+    if t.CallbackURL != "" {
+        if err := netguard.InputPolicy(h.cfg).CheckURL(t.CallbackURL); err != nil {
+            respondError(c, http.StatusBadRequest, "callback_egress_denied", err.Error())
+            return false
+        }
+    }
+    if t.OutputUpload != nil {
+        if err := netguard.InputPolicy(h.cfg).CheckURL(t.OutputUpload.URL); err != nil {
+            respondError(c, http.StatusBadRequest, "upload_egress_denied", err.Error())
+            return false
+        }
+    }
+    if t.Notify != nil {
+        if err := task.ValidateNotify(h.cfg, t.Notify); err != nil {
+            respondError(c, http.StatusBadRequest, "invalid_request", "notify: "+err.Error())
+            return false
+        }
+    }
+    return true
+}
+
+// CancelReport is the response of canceling a group of tasks.
+type CancelReport struct {
+    Canceled int                 `json:"canceled"` // Members whose cancellation was requested
+    Results  []task.CancelResult `json:"results"`
+}
+
+// cancelGroup cancels the members of a batch or pipeline and reports the
+// outcome per member.
+func (h *Handler) cancelGroup(c *gin.Context, members []*task.Task) {
+    report := CancelReport{Results: h.taskManager.CancelAll(members)}
+    for _, r := range report.Results {
+        if r.Canceled {
+            report.Canceled++
+        }
+    }
+    c.JSON(http.StatusOK, report)
+}
+
+// handleDeleteTask removes a task and its files. Processing tasks are only
+// deleted with ?force=true, which cancels them first.
+func (h *Handler) handleDeleteTask(c *gin.Context) {
+    t, found := h.findTask(c)
+    if !found {
+        return
+    }
+    err := h.taskManager.Delete(c.Request.Context(), t.ID, c.Query("force") == "true")
+    switch {
+    case errors.Is(err, task.ErrTaskProcessing):
+        respondError(c, http.StatusConflict, "task_processing", "Task is still processing; use force=true to cancel and delete it")
+        return
+    case errors.Is(err, task.ErrTaskInUse):
+        respondError(c, http.StatusConflict, "task_in_use", err.Error())
+        return
+    case err != nil:
+        respondErrorDetails(c, http.StatusInternalServerError, "internal_error", "Could not delete task", err.Error())
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Task deleted"})
+}
+
+// handleGetFile serves a completed output file, or a file inside a task's
+// output directory.
 func (h *Handler) handleGetFile(c *gin.Context) {
-    filename := c.Param("filename")
+    filename := strings.TrimPrefix(c.Param("filepath"), "/")
     filePath, err := h.taskManager.GetFilePath(filename)
     if err != nil {
-        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        respondError(c, http.StatusNotFound, "not_found", err.Error())
+        return
+    }
+    owner, ok := h.taskManager.FileTask(filename)
+    if _, scoped := tenantOf(c); scoped && (!ok || !canSee(c, owner.Owner)) {
+        respondError(c, http.StatusNotFound, "not_found", "file not found")
         return
     }
-    c.File(filePath)
+    h.serveFile(c, filename, filePath)
+}
+
+// serveFile sends a file of the files endpoint, resolved by GetFilePath.
+func (h *Handler) serveFile(c *gin.Context, filename, filePath string) {
+    if owner, ok := h.taskManager.FileTask(filename); ok {
+        // Serving belongs to the task's trace; the request's own span is linked.
+        ctx := trace.ContextWithSpanContext(c.Request.Context(), owner.SpanContext())
+        _, span := tracing.Tracer().Start(ctx, "output.serve",
+            trace.WithLinks(trace.LinkFromContext(c.Request.Context())),
+            trace.WithAttributes(attribute.String("file.name", filename)))
+        defer span.End()
+    }
+    if contentType := utils.ContentTypeOf(filePath); contentType != "" {
+        c.Header("Content-Type", contentType)
+    }
+    // Tasks with an output name link here with ?name=, which turns the
+    // response into a download under that name.
+    if name := c.Query("name"); name != "" {
+        c.FileAttachment(filePath, filepath.Base(name))
+    } else {
+        c.File(filePath)
+    }
+    if owner, ok := h.taskManager.FileTask(filename); ok {
+        h.taskManager.RecordEgress(owner.ID, int64(c.Writer.Size()))
+    }
 }
 
-// handleSyncCall is a placeholder for the sync call logic.
-// This is more complex because it bypasses the main queue. For simplicity,
-// this example will reject sync calls if the server is already at capacity.
+// handleSyncCall runs a task and answers with the output file itself.
+// Short audio-only and image operations are served by a dedicated low-latency
+// pool; other tasks wait in the regular queue for at most SYNC_TIMEOUT, after
+// which the caller gets a 202 with the task ID to poll instead. Image and
+// audio outputs follow the Accept header, like for /transform.
 func (h *Handler) handleSyncCall(c *gin.Context) {
-    // This endpoint is complex to implement correctly without blocking.
-    // A robust implementation might use a separate, smaller queue or a different mechanism.
-    // For this example, we will simply return a "not implemented" or "use async" message.
-    log.Println("Sync call endpoint is not fully implemented. Please use the async /tasks endpoint.")
-    c.JSON(http.StatusNotImplemented, gin.H{"error": "Synchronous calls are not recommended. Please use the /api/v1/tasks endpoint for better performance and reliability."})
+    var req TaskRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+
+    if len(req.DependsOn) > 0 {
+        respondError(c, http.StatusBadRequest, "invalid_request", "Sync calls run right away; dependsOn is not supported")
+        return
+    }
+    if req.RunAt != "" || req.Delay != "" {
+        respondError(c, http.StatusBadRequest, "invalid_request", "Sync calls run right away; runAt and delay are not supported")
+        return
+    }
+    negotiateOutput(c, &req)
+    _, opts, ok := h.validateTaskRequest(c, &req)
+    if !ok {
+        return
+    }
+
+    ctx, cancel := context.WithTimeout(c.Request.Context(), h.cfg.SyncTimeout)
+    defer cancel()
+
+    t, err := h.taskManager.RunSync(ctx, req.Command, req.InputMedia, req.OutputExt, opts)
+    if err != nil {
+        respondSubmitError(c, "Failed to run task", err)
+        return
+    }
+
+    t = t.Snapshot() // Still running if the call timed out
+    c.Header("X-Task-Id", t.ID)
+    switch t.Status {
+    case task.StatusCompleted, task.StatusCompletedWithWarnings:
+        c.FileAttachment(t.OutputPath, filepath.Base(t.OutputPath))
+        h.taskManager.RecordEgress(t.ID, int64(c.Writer.Size()))
+    case task.StatusFailed, task.StatusCanceled, task.StatusInterrupted:
+        body := versionOf(c).mapper.Error(http.StatusUnprocessableEntity, "task_failed", t.Error)
+        body["task"] = versionOf(c).mapper.Task(t)
+        c.JSON(http.StatusUnprocessableEntity, body)
+    default:
+        logging.FromContext(c.Request.Context()).Info("Sync call did not finish in time, continuing asynchronously", "task_id", t.ID, "timeout", h.cfg.SyncTimeout)
+        resp := acceptedTask(t)
+        resp["message"] = "Task is still running, poll " + versionOf(c).basePath() + "/tasks/" + t.ID
+        c.JSON(http.StatusAccepted, resp)
+    }
 }
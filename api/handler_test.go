@@ -2,19 +2,33 @@
 package api
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
+	"ffwebapi/auth"
 	"ffwebapi/config"
+	"ffwebapi/ffmpeg"
 	"ffwebapi/task"
+	"ffwebapi/utils"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type mockRunner struct{}
@@ -35,10 +49,67 @@ func setupTestRouter() (*gin.Engine, *config.Config, *task.Manager) {
 	runner := &mockRunner{}
 	// FIX: The call to NewManager now correctly expects only one return value.
 	tm, _ := task.NewManager(cfg, runner)
-	router := SetupRouter(tm, cfg)
+	keys, _ := auth.NewStore(cfg)
+	router := SetupRouter(tm, keys, cfg)
 	return router, cfg, tm
 }
 
+// localInput creates test.mkv in a directory of LOCAL_INPUT_DIRS, where keys
+// without the admin scope may name local inputs, and returns its path.
+func localInput(t *testing.T, cfg *config.Config) string {
+	dir := t.TempDir()
+	cfg.LocalInputDirs = append(cfg.LocalInputDirs, dir)
+	path := filepath.Join(dir, "test.mkv")
+	require.NoError(t, os.WriteFile(path, []byte("media"), 0o600))
+	return path
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	ffmpegBin := filepath.Join(dir, "ffmpeg")
+	require.NoError(t, os.WriteFile(ffmpegBin, []byte("#!/bin/sh\necho 'ffmpeg version 6.1.1 Copyright (c) 2000-2023'\n"), 0o755))
+	cfg := &config.Config{MaxConcurrency: 1, QueueCapacity: 1, TempDir: dir, FFBin: ffmpegBin}
+	tm, _ := task.NewManager(cfg, &mockRunner{})
+	keys, _ := auth.NewStore(cfg)
+	router := SetupRouter(tm, keys, cfg)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/health")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var report HealthReport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, "ok", report.Status)
+	assert.Equal(t, "6.1.1", report.FFmpegVersion)
+	assert.Greater(t, report.UptimeSeconds, 0.0)
+	assert.Greater(t, report.Resources.DiskFree, uint64(0))
+	assert.Equal(t, http.StatusOK, get("/readyz").Code)
+
+	// A full queue makes the server unready, but not unhealthy.
+	_, err := tm.Submit("-i ${INPUT_MEDIA}", "a.mp4", "mp4")
+	require.NoError(t, err)
+	w = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `queue \"default\" is full`)
+	assert.Equal(t, http.StatusOK, get("/healthz").Code)
+
+	w = get("/health")
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, "degraded", report.Status)
+	assert.Equal(t, 1, report.QueueDepth)
+	assert.Len(t, report.Problems, 1)
+
+	cfg.ThrottleFreeDisk = 1 << 62
+	w = get("/readyz")
+	assert.Contains(t, w.Body.String(), "not enough free disk space")
+}
+
 func TestHandleCreateTask(t *testing.T) {
 	router, _, tm := setupTestRouter()
 
@@ -50,6 +121,8 @@ func TestHandleCreateTask(t *testing.T) {
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.NotEmpty(t, w.Header().Get("X-Poll-After"))
 
 	var resp map[string]string
 	err := json.Unmarshal(w.Body.Bytes(), &resp)
@@ -60,6 +133,439 @@ func TestHandleCreateTask(t *testing.T) {
 	assert.True(t, found)
 }
 
+func TestHandleCreateTask_ExtraInputs(t *testing.T) {
+	router, _, tm := setupTestRouter()
+	submit := func(command string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"command": command, "inputMedia": "test.mkv", "outputExt": "mp4"})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// ffmpeg would fetch these itself, past the input policy.
+	for _, command := range []string{
+		"-i http://169.254.169.254/latest/meta-data/ -i ${INPUT_MEDIA} -map 0",
+		"-i ${INPUT_MEDIA} -i concat:/etc/passwd -map 1",
+		"-i ${INPUT_MEDIA} -f mpegts tcp:127.0.0.1:9000",
+	} {
+		w := submit(command)
+		assert.Equal(t, http.StatusBadRequest, w.Code, command)
+	}
+	assert.Empty(t, tm.List())
+}
+
+func TestHandleCreateTask_Requires(t *testing.T) {
+	router, cfg, tm := setupTestRouter()
+	cfg.NodeName, cfg.NodeLabels = "encoder-1", []string{"gpu", "region:eu"}
+	submit := func(requires string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		reqBody := `{"command": "-i ${INPUT_MEDIA} -vcodec copy", "inputMedia": "test.mkv", "outputExt": "mp4", "requires": ` + requires + `}`
+		req, _ := http.NewRequest("POST", "/api/v2/tasks", bytes.NewBufferString(reqBody))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v2/nodes", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"name": "encoder-1", "labels": ["gpu", "region:eu"]}]`, w.Body.String())
+
+	w = submit(`["gpu", "region:eu"]`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var resp map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	created, found := tm.Get(resp["taskId"])
+	require.True(t, found)
+	assert.Equal(t, []string{"gpu", "region:eu"}, created.Requires)
+
+	// Requirements no node meets fail right away rather than queueing forever.
+	w = submit(`["gpu", "region:us"]`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "unsatisfiable_requirements", body["error"].(map[string]interface{})["code"])
+	assert.Equal(t, []interface{}{"region:us"}, body["missing"])
+
+	assert.Equal(t, http.StatusBadRequest, submit(`["two words"]`).Code)
+}
+
+func TestCheckScheduledRun(t *testing.T) {
+	cfg := &config.Config{AuthEnable: true, AuthKey: "admin-secret", MaxConcurrency: 1}
+	tm, _ := task.NewManager(cfg, &mockRunner{})
+	keys, _ := auth.NewStore(cfg)
+	h := NewHandler(tm, keys, cfg)
+	key, _, err := keys.Create(auth.Key{Scopes: []string{auth.ScopeSubmit}, Quota: &auth.Quota{MaxRunning: 1}})
+	require.NoError(t, err)
+	input := localInput(t, cfg)
+	var opts task.SubmitOptions
+	check := func(owner string) error {
+		opts = task.SubmitOptions{Owner: owner}
+		return h.checkScheduledRun(&task.Schedule{Owner: owner, InputMedia: input, Options: opts}, &opts)
+	}
+
+	// The quota applies to the runs of a schedule like to submissions.
+	assert.NoError(t, check(key.ID))
+	assert.Equal(t, 1, opts.MaxRunning)
+	assert.NoError(t, check(auth.LegacyKeyID))
+
+	// Runs of a token's schedule stop once that token expires.
+	jwt := func(scopes []string, expiresAt time.Time) error {
+		opts = task.SubmitOptions{Owner: auth.JWTKeyPrefix + "alice"}
+		return h.checkScheduledRun(&task.Schedule{Owner: opts.Owner, InputMedia: input, Options: opts, OwnerScopes: scopes, OwnerExpiresAt: expiresAt}, &opts)
+	}
+	assert.NoError(t, jwt([]string{auth.ScopeSubmit}, time.Now().Add(time.Hour)))
+	assert.NoError(t, jwt([]string{auth.ScopeSubmit}, time.Time{}))
+	assert.ErrorContains(t, jwt([]string{auth.ScopeSubmit}, time.Now().Add(-time.Minute)), "has expired")
+	assert.ErrorContains(t, jwt(nil, time.Now().Add(time.Hour)), "has expired", "saved without the token's scopes")
+
+	// Local inputs are checked against today's LOCAL_INPUT_DIRS.
+	cfg.LocalInputDirs = nil
+	assert.ErrorContains(t, check(key.ID), "LOCAL_INPUT_DIRS")
+	assert.NoError(t, check(auth.LegacyKeyID))
+
+	// Runs stop once the key that created the schedule is revoked.
+	require.NoError(t, keys.Revoke(key.ID))
+	assert.ErrorContains(t, check(key.ID), "no longer exists")
+}
+
+func TestHandleCreateTask_Schedule(t *testing.T) {
+	router, _, tm := setupTestRouter()
+	submit := func(schedule string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		reqBody := `{"command": "-i ${INPUT_MEDIA} -vcodec copy", "inputMedia": "test.mkv", "outputExt": "mp4", ` + schedule + `}`
+		req, _ := http.NewRequest("POST", "/api/v2/tasks", bytes.NewBufferString(reqBody))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	runAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	w := submit(`"runAt": "` + runAt.Format(time.RFC3339) + `"`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var resp map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	created, found := tm.Get(resp["taskId"])
+	require.True(t, found)
+	assert.Equal(t, task.StatusScheduled, created.Status)
+	assert.True(t, runAt.Equal(created.ScheduledFor))
+
+	w = submit(`"delay": "30m"`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	// Scheduled tasks are listed with the time they are due.
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v2/tasks?status=scheduled&sort=scheduledFor", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var page struct {
+		Tasks []task.Task `json:"tasks"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	require.Len(t, page.Tasks, 2)
+	assert.WithinDuration(t, time.Now().Add(30*time.Minute), page.Tasks[0].ScheduledFor, time.Minute)
+	assert.Equal(t, created.ID, page.Tasks[1].ID)
+
+	// They can be canceled before they start.
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PATCH", "/api/v2/tasks/"+created.ID+"/cancel", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, task.StatusCanceled, created.Status)
+
+	assert.Equal(t, http.StatusBadRequest, submit(`"runAt": "tomorrow"`).Code)
+	assert.Equal(t, http.StatusBadRequest, submit(`"delay": "-1m"`).Code)
+	assert.Equal(t, http.StatusBadRequest, submit(`"delay": "1m", "runAt": "`+runAt.Format(time.RFC3339)+`"`).Code)
+
+	// Sync calls run right away.
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v2/call", bytes.NewBufferString(`{"command": "-i ${INPUT_MEDIA} -vn", "inputMedia": "test.mkv", "outputExt": "mp3", "delay": "1m"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "runAt and delay are not supported")
+}
+
+func TestSchedules(t *testing.T) {
+	router, _, tm := setupTestRouter()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/api/v1/schedules", `{"name": "nightly", "cron": "0 2 * * *", "timezone": "America/New_York",
+		"command": "-i ${INPUT_MEDIA} -vcodec copy", "inputMedia": "https://example.com/live.ts", "outputExt": "mp4", "priority": "low"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created task.Schedule
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.NotEmpty(t, created.ID)
+	assert.Equal(t, "0 2 * * *", created.Cron)
+	ny, _ := time.LoadLocation("America/New_York")
+	assert.Equal(t, 2, created.NextRunAt.In(ny).Hour())
+
+	w = do("GET", "/api/v2/schedules", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Schedules []task.Schedule `json:"schedules"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Schedules, 1)
+	assert.Equal(t, "nightly", list.Schedules[0].Name)
+
+	w = do("PUT", "/api/v2/schedules/"+created.ID, `{"cron": "@hourly", "paused": true,
+		"command": "-i ${INPUT_MEDIA} -vcodec copy", "inputMedia": "https://example.com/live.ts", "outputExt": "mp4"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	s, ok := tm.GetSchedule(created.ID)
+	require.True(t, ok)
+	assert.True(t, s.Paused)
+	assert.True(t, s.NextRunAt.IsZero())
+	assert.Equal(t, task.PriorityNormal, s.Options.Priority)
+
+	for name, body := range map[string]string{
+		"bad cron":      `{"cron": "sometimes", "command": "-i ${INPUT_MEDIA} out.mp4", "inputMedia": "in.mp4", "outputExt": "mp4"}`,
+		"bad time zone": `{"cron": "@daily", "timezone": "Nowhere", "command": "-i ${INPUT_MEDIA} out.mp4", "inputMedia": "in.mp4", "outputExt": "mp4"}`,
+		"no cron":       `{"command": "-i ${INPUT_MEDIA} out.mp4", "inputMedia": "in.mp4", "outputExt": "mp4"}`,
+		"upload input":  `{"cron": "@daily", "command": "-i ${INPUT_MEDIA} out.mp4", "inputId": "abc", "outputExt": "mp4"}`,
+		"delayed":       `{"cron": "@daily", "delay": "1m", "command": "-i ${INPUT_MEDIA} out.mp4", "inputMedia": "in.mp4", "outputExt": "mp4"}`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, do("POST", "/api/v2/schedules", body).Code, name)
+	}
+
+	assert.Equal(t, http.StatusOK, do("DELETE", "/api/v2/schedules/"+created.ID, "").Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/api/v2/schedules/"+created.ID, "").Code)
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/api/v2/schedules/"+created.ID, "").Code)
+}
+
+func TestHandleCreateTask_TraceParent(t *testing.T) {
+	router, _, tm := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	reqBody := `{"command": "-i ${INPUT_MEDIA} -vcodec copy", "inputMedia": "test.mkv", "outputExt": "mp4"}`
+	req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	// The task joins the caller's trace.
+	var resp map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", resp["traceId"])
+	created, found := tm.Get(resp["taskId"])
+	assert.True(t, found)
+	assert.Equal(t, resp["traceId"], created.TraceID)
+}
+
+func TestRequestID(t *testing.T) {
+	router, _, tm := setupTestRouter()
+
+	submit := func(requestID string) (*httptest.ResponseRecorder, *task.Task) {
+		w := httptest.NewRecorder()
+		reqBody := `{"command": "-i ${INPUT_MEDIA} -vcodec copy", "inputMedia": "test.mkv", "outputExt": "mp4"}`
+		req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(reqBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Request-ID", requestID)
+		router.ServeHTTP(w, req)
+		var resp map[string]string
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		created, _ := tm.Get(resp["taskId"])
+		return w, created
+	}
+
+	// The caller's ID is kept and stored with the task.
+	w, created := submit("req-42")
+	assert.Equal(t, "req-42", w.Header().Get("X-Request-ID"))
+	assert.Equal(t, "req-42", created.RequestID)
+
+	// Unusable IDs are replaced.
+	w, created = submit("bad id\n")
+	assert.NotEmpty(t, w.Header().Get("X-Request-ID"))
+	assert.NotEqual(t, "bad id\n", w.Header().Get("X-Request-ID"))
+	assert.Equal(t, w.Header().Get("X-Request-ID"), created.RequestID)
+}
+
+func TestCORS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{MaxConcurrency: 1, AuthEnable: true, AuthKey: "admin-secret",
+		CORSAllowedOrigins: []string{"https://app.example.com", "https://*.example.org"},
+		CORSAllowedMethods: []string{"GET", "POST"}, CORSAllowedHeaders: []string{"Authorization", "Content-Type"},
+		CORSExposedHeaders: []string{"X-Task-Id"}, CORSMaxAge: 10 * time.Minute}
+	tm, _ := task.NewManager(cfg, &mockRunner{})
+	keys, _ := auth.NewStore(cfg)
+	router := SetupRouter(tm, keys, cfg)
+	do := func(method, origin string, headers map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "/api/v2/tasks", nil)
+		req.Header.Set("Origin", origin)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+	preflight := map[string]string{"Access-Control-Request-Method": "POST", "Access-Control-Request-Headers": "authorization,content-type"}
+
+	// Preflights are answered without the API key.
+	w := do("OPTIONS", "https://app.example.com", preflight)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Authorization, Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, http.StatusNoContent, do("OPTIONS", "https://eu.example.org", preflight).Code)
+	for _, origin := range []string{"https://evil.com", "http://app.example.com", "https://example.org", "https://app.example.com.evil.com"} {
+		w = do("OPTIONS", origin, preflight)
+		assert.Equal(t, http.StatusForbidden, w.Code, origin)
+		assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"), origin)
+	}
+
+	// Actual requests, errors included, can be read by the frontend.
+	w = do("GET", "https://app.example.com", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Task-Id", w.Header().Get("Access-Control-Expose-Headers"))
+	assert.Equal(t, "Origin", w.Header().Get("Vary"))
+	w = do("GET", "https://app.example.com", map[string]string{"Authorization": "Bearer admin-secret"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, do("GET", "https://evil.com", nil).Header().Get("Access-Control-Allow-Origin"))
+
+	// "*" allows any origin; credentials only listed ones.
+	cfg.CORSAllowedOrigins = []string{"*"}
+	router = SetupRouter(tm, keys, cfg)
+	assert.Equal(t, "*", do("OPTIONS", "https://any.example.net", preflight).Header().Get("Access-Control-Allow-Origin"))
+	cfg.CORSAllowedOrigins = []string{"https://app.example.com"}
+	cfg.CORSAllowCredentials = true
+	router = SetupRouter(tm, keys, cfg)
+	w = do("OPTIONS", "https://app.example.com", preflight)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+
+	// Without allowed origins there are no CORS headers.
+	cfg.CORSAllowedOrigins = nil
+	router = SetupRouter(tm, keys, cfg)
+	assert.Empty(t, do("GET", "https://app.example.com", nil).Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{MaxConcurrency: 1, RateLimitRequests: 3, RateLimitSubmissions: 1}
+	tm, _ := task.NewManager(cfg, &mockRunner{})
+	keys, _ := auth.NewStore(cfg)
+	router := SetupRouter(tm, keys, cfg)
+
+	do := func(method, path, body, ip string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = ip + ":1234"
+		router.ServeHTTP(w, req)
+		return w
+	}
+	submit := `{"command": "-i ${INPUT_MEDIA}", "inputMedia": "test.mkv", "outputExt": "mp4"}`
+
+	assert.Equal(t, http.StatusAccepted, do("POST", "/api/v1/tasks", submit, "192.0.2.1").Code)
+	w := do("POST", "/api/v2/tasks", submit, "192.0.2.1") // Limits span API versions
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), "rate_limited")
+	assert.Equal(t, "3600", w.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/tasks", "", "192.0.2.1").Code)
+	w = do("GET", "/api/v1/tasks", "", "192.0.2.1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "20", w.Header().Get("Retry-After"))
+
+	// Other clients have their own budget.
+	assert.Equal(t, http.StatusAccepted, do("POST", "/api/v1/tasks", submit, "192.0.2.2").Code)
+}
+
+func TestRateLimiter_Refill(t *testing.T) {
+	limit := rateLimit{rate: 2}
+	l := newRateLimiter("X-RateLimit", time.Minute, limit, nil)
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		ok, _ := l.allow("a", limit, now)
+		assert.True(t, ok)
+	}
+	ok, state := l.allow("a", limit, now)
+	assert.False(t, ok)
+	assert.Equal(t, 30*time.Second, state.retryAfter)
+
+	ok, _ = l.allow("a", limit, now.Add(30*time.Second))
+	assert.True(t, ok)
+
+	// Idle clients are forgotten once their bucket is full again.
+	l.allow("b", limit, now.Add(5*time.Minute))
+	assert.Len(t, l.buckets, 1)
+	assert.Contains(t, l.buckets, "b")
+}
+
+func TestRateLimiter_Burst(t *testing.T) {
+	// Sustained 10/min with bursts of 50.
+	limit := rateLimit{rate: 10, burst: 50}
+	l := newRateLimiter("X-RateLimit", time.Minute, limit, nil)
+	now := time.Now()
+	for i := 0; i < 50; i++ {
+		ok, _ := l.allow("a", limit, now)
+		require.True(t, ok, i)
+	}
+	ok, state := l.allow("a", limit, now)
+	assert.False(t, ok)
+	assert.Equal(t, 6*time.Second, state.retryAfter)
+	assert.Equal(t, 5*time.Minute, state.reset)
+
+	// After a minute the sustained rate is available again, not the burst.
+	for i := 0; i < 10; i++ {
+		ok, _ := l.allow("a", limit, now.Add(time.Minute))
+		require.True(t, ok, i)
+	}
+	ok, _ = l.allow("a", limit, now.Add(time.Minute))
+	assert.False(t, ok)
+}
+
+func TestRateLimit_Headers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{MaxConcurrency: 1, AuthEnable: true, AuthKey: "admin-secret", RateLimitRequests: 10, RateLimitRequestsBurst: 20}
+	tm, _ := task.NewManager(cfg, &mockRunner{})
+	keys, _ := auth.NewStore(cfg)
+	router := SetupRouter(tm, keys, cfg)
+	_, batch, err := keys.Create(auth.Key{Scopes: []string{auth.ScopeRead}, RateLimit: &auth.RateLimit{RequestsPerMinute: 2, RequestsBurst: 3}})
+	require.NoError(t, err)
+
+	get := func(secret string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v2/tasks", nil)
+		req.Header.Set("Authorization", "Bearer "+secret)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("admin-secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "10", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "20", w.Header().Get("X-RateLimit-Burst"))
+	assert.Equal(t, "19", w.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "6", w.Header().Get("X-RateLimit-Reset"))
+
+	// Keys may have their own rate and burst.
+	for i := 0; i < 3; i++ {
+		w = get(batch)
+		require.Equal(t, http.StatusOK, w.Code, i)
+	}
+	assert.Equal(t, "2", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "3", w.Header().Get("X-RateLimit-Burst"))
+	w = get(batch)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+}
+
 func TestHandleGetTaskStatus(t *testing.T) {
 	router, _, tm := setupTestRouter()
 
@@ -91,6 +597,135 @@ func TestHandleGetTaskStatus(t *testing.T) {
 	assert.Equal(t, http.StatusNotFound, w.Code)
 }
 
+func TestHandleTaskEvents(t *testing.T) {
+	router, _, tm := setupTestRouter()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tm.Start(ctx)
+
+	testTask, err := tm.Submit("-i ${INPUT_MEDIA} -vcodec copy", "test.mp4", "mp4")
+	require.NoError(t, err)
+	select {
+	case <-testTask.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("task did not finish")
+	}
+	testTask.SetMetrics(&task.ProcessMetrics{Processes: 2, CPUPercent: 150.5, RSSBytes: 1 << 20, PeakRSSBytes: 2 << 20})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/tasks/"+testTask.ID+"/events", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	body := w.Body.String()
+	assert.Equal(t, 1, strings.Count(body, "event:status"), "a terminal task is reported once")
+	assert.Contains(t, body, `"status":"completed"`)
+	assert.Contains(t, body, `"cpuPercent":150.5`)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/tasks/nonexistent/events", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleTasksEvents(t *testing.T) {
+	router, _, tm := setupTestRouter()
+	done, err := tm.Submit("-i ${INPUT_MEDIA} -vcodec copy", "done.mp4", "mp4")
+	require.NoError(t, err)
+	require.NoError(t, tm.Cancel(done.ID))
+	queued, err := tm.Submit("-i ${INPUT_MEDIA} -vcodec copy", "queued.mp4", "mp4")
+	require.NoError(t, err)
+
+	// Unfinished tasks are sent on connect, ended ones only once they change.
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		tm.Cancel(queued.ID)
+	}()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "/api/v2/tasks/events", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	body := w.Body.String()
+	assert.NotContains(t, body, done.ID)
+	assert.Equal(t, 2, strings.Count(body, `"id":"`+queued.ID+`"`), body)
+	assert.Contains(t, body, `"status":"canceled"`)
+}
+
+func TestHandleRetryTask(t *testing.T) {
+	router, _, tm := setupTestRouter()
+	retry := func(id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v2/tasks/"+id+"/retry", nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	original, err := tm.Submit("-i ${INPUT_MEDIA} -vcodec copy", "test.mp4", "mp4")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, retry(original.ID).Code, "queued tasks are not retried")
+	require.NoError(t, tm.Cancel(original.ID))
+
+	w := retry(original.ID)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var accepted struct{ TaskID string }
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &accepted))
+	assert.NotEqual(t, original.ID, accepted.TaskID)
+	got, ok := tm.Get(accepted.TaskID)
+	require.True(t, ok)
+	assert.Equal(t, original.ID, got.RetryOf)
+	assert.Equal(t, task.StatusQueued, got.Status)
+	assert.Equal(t, original.Command, got.Command)
+	assert.Equal(t, original.InputMedia, got.InputMedia)
+
+	assert.Equal(t, http.StatusNotFound, retry("nonexistent").Code)
+
+	// Retries are checked like submissions, against today's policies.
+	internal, err := tm.Submit("-i ${INPUT_MEDIA} -vcodec copy", "http://169.254.169.254/latest/meta-data", "mp4")
+	require.NoError(t, err)
+	require.NoError(t, tm.Cancel(internal.ID))
+	w = retry(internal.ID)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "input_egress_denied")
+	tool, err := tm.SubmitWithOptions("${INPUT_MEDIA} -resize 50%", "test.png", "png", task.SubmitOptions{Tool: "magick"})
+	require.NoError(t, err)
+	require.NoError(t, tm.Cancel(tool.ID))
+	w = retry(tool.ID)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "disabled tool")
+}
+
+func TestUI(t *testing.T) {
+	router, cfg, _ := setupTestRouter()
+	cfg.AuthEnable, cfg.AuthKey = true, "secret"
+	get := func(router *gin.Engine, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusNotFound, get(router, "/ui/").Code)
+
+	cfg.UIEnable = true
+	tm, _ := task.NewManager(cfg, &mockRunner{})
+	keys, _ := auth.NewStore(cfg)
+	router = SetupRouter(tm, keys, cfg)
+	w := get(router, "/ui")
+	assert.Equal(t, http.StatusMovedPermanently, w.Code)
+	assert.Equal(t, "ui/", w.Header().Get("Location"))
+	w = get(router, "/ui/") // The page itself needs no API key
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<script src="app.js"`)
+	w = get(router, "/ui/app.js")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "/tasks/events")
+	assert.Equal(t, http.StatusNotFound, get(router, "/ui/missing.js").Code)
+}
+
 func TestAuthMiddleware(t *testing.T) {
 	router, cfg, _ := setupTestRouter()
 
@@ -131,3 +766,2376 @@ func TestAuthMiddleware(t *testing.T) {
 		assert.Equal(t, http.StatusOK, w.Code)
 	})
 }
+
+func TestAPIKeys(t *testing.T) {
+	router, cfg, _ := setupTestRouter()
+	cfg.AuthEnable = true
+	cfg.AuthKey = "admin-secret"
+
+	do := func(method, path, body, key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+key)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/api/v2/admin/keys", `{"name": "viewer", "scopes": ["invalid"]}`, "admin-secret")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = do("POST", "/api/v2/admin/keys", `{"name": "viewer", "scopes": ["read"]}`, "admin-secret")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var created struct {
+		ID     string   `json:"id"`
+		Scopes []string `json:"scopes"`
+		Secret string   `json:"secret"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.NotEmpty(t, created.Secret)
+
+	// The key may read but nothing else.
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v2/tasks", "", created.Secret).Code)
+	w = do("POST", "/api/v2/tasks", `{"command": "-i ${INPUT_MEDIA}", "inputMedia": "test.mkv", "outputExt": "mp4"}`, created.Secret)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), `the \"submit\" scope`)
+	assert.Equal(t, http.StatusForbidden, do("GET", "/api/v2/admin/keys", "", created.Secret).Code)
+
+	w = do("GET", "/api/v2/admin/keys", "", "admin-secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), created.ID)
+	assert.NotContains(t, w.Body.String(), created.Secret)
+
+	assert.Equal(t, http.StatusOK, do("DELETE", "/api/v2/admin/keys/"+created.ID, "", "admin-secret").Code)
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/api/v2/admin/keys/"+created.ID, "", "admin-secret").Code)
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v2/tasks", "", created.Secret).Code)
+}
+
+func TestJWTAuth(t *testing.T) {
+	cfg := &config.Config{MaxConcurrency: 1, AuthEnable: true, JWTSecret: "idp-secret"}
+	tm, _ := task.NewManager(cfg, &mockRunner{})
+	keys, _ := auth.NewStore(cfg)
+	router := SetupRouter(tm, keys, cfg)
+	input := localInput(t, cfg)
+
+	token := func(scope string) string {
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+		claims := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"sub":"alice","scope":%q,"exp":%d}`, scope, time.Now().Add(time.Hour).Unix())))
+		mac := hmac.New(sha256.New, []byte("idp-secret"))
+		mac.Write([]byte(header + "." + claims))
+		return header + "." + claims + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+	submit := func(bearer string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v2/tasks", bytes.NewBufferString(`{"command": "-i ${INPUT_MEDIA}", "inputMedia": "`+input+`", "outputExt": "mp4"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusForbidden, submit(token("read")).Code)
+	w := submit(token("submit read"))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var resp struct {
+		TaskID string `json:"taskId"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	created, found := tm.Get(resp.TaskID)
+	assert.True(t, found)
+	assert.Equal(t, "jwt:alice", created.Owner)
+
+	// Schedules keep the token's scopes and expiry, as the token is not stored.
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v2/schedules", bytes.NewBufferString(`{"cron": "@daily", "command": "-i ${INPUT_MEDIA}", "inputMedia": "`+input+`", "outputExt": "mp4"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token("submit read"))
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var schedule task.Schedule
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &schedule))
+	assert.Equal(t, []string{auth.ScopeSubmit, auth.ScopeRead}, schedule.OwnerScopes)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), schedule.OwnerExpiresAt, 2*time.Minute)
+
+	assert.Equal(t, http.StatusUnauthorized, submit(token("submit")+"x").Code)
+}
+
+func TestHandleCreateTask_Queues(t *testing.T) {
+	cfg := &config.Config{AuthEnable: true, AuthKey: "admin-secret", QueueConcurrency: map[string]int{"bulk": 1}, QueueMaxBacklog: map[string]int{"bulk": 1}}
+	tm, _ := task.NewManager(cfg, &mockRunner{})
+	keys, _ := auth.NewStore(cfg)
+	router := SetupRouter(tm, keys, cfg)
+	input := localInput(t, cfg)
+	do := func(method, path, body, key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+key)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	submit := func(queue, key string) *httptest.ResponseRecorder {
+		return do("POST", "/api/v2/tasks", fmt.Sprintf(`{"command": "-i ${INPUT_MEDIA}", "inputMedia": %q, "outputExt": "mp4", "queue": %q}`, input, queue), key)
+	}
+
+	// The manager is not started, so tasks stay queued.
+	assert.Equal(t, http.StatusAccepted, submit("bulk", "admin-secret").Code)
+	w := submit("bulk", "admin-secret")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"queue_full"`)
+	assert.Contains(t, w.Body.String(), `"queueDepth":1`)
+	assert.Contains(t, w.Body.String(), `"queueCapacity":1`)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusBadRequest, submit("nightly", "admin-secret").Code)
+	assert.Equal(t, http.StatusAccepted, submit("", "admin-secret").Code)
+
+	// Keys can be pinned to a queue.
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/api/v2/admin/keys", `{"scopes": ["submit"], "queue": "nightly"}`, "admin-secret").Code)
+	w = do("POST", "/api/v2/admin/keys", `{"scopes": ["submit"], "queue": "bulk"}`, "admin-secret")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var created CreatedKey
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, http.StatusForbidden, submit(task.DefaultQueue, created.Secret).Code)
+	assert.Equal(t, http.StatusTooManyRequests, submit("", created.Secret).Code) // Lands in the full bulk queue
+}
+
+// storingRunner produces a 2 KiB output per task in the files directory
+// below dir, the temp dir.
+type storingRunner struct{ dir string }
+
+func (r *storingRunner) Run(ctx context.Context, t *task.Task) (string, error) {
+	filesDir := task.FilesDir(&config.Config{TempDir: r.dir}, t.ID)
+	path := filepath.Join(filesDir, "output.mp4")
+	if err := os.MkdirAll(filesDir, 0o700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, make([]byte, 2048), 0o600); err != nil {
+		return "", err
+	}
+	t.AddArtifact(task.ArtifactOutput, task.ArtifactOutput, path)
+	return "ok", nil
+}
+
+func TestHandleUsage_Quota(t *testing.T) {
+	cfg := &config.Config{AuthEnable: true, AuthKey: "admin-secret", MaxConcurrency: 1, OutputLocalLifetime: time.Hour}
+	tm, _ := task.NewManager(cfg, &storingRunner{dir: t.TempDir()})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tm.Start(ctx)
+	keys, _ := auth.NewStore(cfg)
+	router := SetupRouter(tm, keys, cfg)
+	input := localInput(t, cfg)
+	do := func(method, path, body, key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+key)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	submit := func(key string) *httptest.ResponseRecorder {
+		return do("POST", "/api/v2/tasks", `{"command": "-i ${INPUT_MEDIA}", "inputMedia": "`+input+`", "outputExt": "mp4"}`, key)
+	}
+
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/api/v2/admin/keys", `{"scopes": ["submit"], "quota": {"maxStorage": -1}}`, "admin-secret").Code)
+	w := do("POST", "/api/v2/admin/keys", `{"scopes": ["submit", "read"], "quota": {"maxStorage": 1000}}`, "admin-secret")
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created CreatedKey
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+
+	w = submit(created.Secret)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var accepted struct{ TaskID string }
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &accepted))
+	tk, ok := tm.Get(accepted.TaskID)
+	require.True(t, ok)
+	select {
+	case <-tk.Done():
+	case <-time.After(time.Second):
+		t.Fatal("task did not finish")
+	}
+
+	// The output fills the storage quota; admin keys have none.
+	w = submit(created.Secret)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"quota_exceeded"`)
+	assert.Equal(t, http.StatusAccepted, submit("admin-secret").Code)
+	// Retries count against the quota as well.
+	canceled, err := tm.SubmitWithOptions("-i ${INPUT_MEDIA}", input, "mp4", task.SubmitOptions{Owner: created.ID, RunAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	require.NoError(t, tm.Cancel(canceled.ID))
+	assert.Equal(t, http.StatusTooManyRequests, do("POST", "/api/v2/tasks/"+canceled.ID+"/retry", "", created.Secret).Code)
+
+	w = do("GET", "/api/v2/usage", "", created.Secret)
+	require.Equal(t, http.StatusOK, w.Code)
+	var report UsageReport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, created.ID, report.Owner)
+	assert.Equal(t, int64(2048), report.StorageBytes)
+	assert.Equal(t, int64(1000), report.Quota.MaxStorage)
+
+	// Only admins see the usage of other keys.
+	assert.Equal(t, http.StatusForbidden, do("GET", "/api/v2/usage?keyId=other", "", created.Secret).Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/api/v2/usage?keyId=other", "", "admin-secret").Code)
+	w = do("GET", "/api/v2/usage?keyId="+created.ID, "", "admin-secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"storageBytes":2048`)
+}
+
+func TestTenantIsolation(t *testing.T) {
+	cfg := &config.Config{AuthEnable: true, AuthKey: "admin-secret", MaxConcurrency: 1, OutputLocalLifetime: time.Hour, TempDir: t.TempDir()}
+	tm, _ := task.NewManager(cfg, &storingRunner{dir: cfg.TempDir})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tm.Start(ctx)
+	keys, _ := auth.NewStore(cfg)
+	router := SetupRouter(tm, keys, cfg)
+	do := func(method, path, body, key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+key)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	newKey := func() CreatedKey {
+		w := do("POST", "/api/v2/admin/keys", `{"scopes": ["submit", "read", "cancel", "download"]}`, "admin-secret")
+		require.Equal(t, http.StatusCreated, w.Code)
+		var created CreatedKey
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+		return created
+	}
+	alice, bob := newKey(), newKey()
+
+	w := do("POST", "/api/v2/tasks", `{"command": "-i ${INPUT_MEDIA}", "inputMedia": "`+localInput(t, cfg)+`", "outputExt": "mp4"}`, alice.Secret)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var accepted struct{ TaskID string }
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &accepted))
+	tk, _ := tm.Get(accepted.TaskID)
+	select {
+	case <-tk.Done():
+	case <-time.After(time.Second):
+		t.Fatal("task did not finish")
+	}
+	file := "/api/v2/files/" + tk.ID + "/output.mp4"
+
+	// Alice sees her task and its output.
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v2/tasks/"+tk.ID, "", alice.Secret).Code)
+	assert.Equal(t, http.StatusOK, do("GET", file, "", alice.Secret).Code)
+	assert.Contains(t, do("GET", "/api/v2/tasks", "", alice.Secret).Body.String(), tk.ID)
+
+	// To bob they do not exist.
+	assert.JSONEq(t, `{"tasks":[],"nextCursor":null}`, do("GET", "/api/v2/tasks", "", bob.Secret).Body.String())
+	assert.Equal(t, http.StatusNotFound, do("GET", "/api/v2/tasks/"+tk.ID, "", bob.Secret).Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/api/v2/tasks/"+tk.ID+"/callbacks", "", bob.Secret).Code)
+	assert.Equal(t, http.StatusNotFound, do("PATCH", "/api/v2/tasks/"+tk.ID+"/cancel", "", bob.Secret).Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", file, "", bob.Secret).Code)
+
+	// Admins see everything and can filter by owner.
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v2/tasks/"+tk.ID, "", "admin-secret").Code)
+	assert.Equal(t, http.StatusOK, do("GET", file, "", "admin-secret").Code)
+	assert.Contains(t, do("GET", "/api/v2/tasks?owner="+alice.ID, "", "admin-secret").Body.String(), tk.ID)
+	assert.JSONEq(t, `{"tasks":[],"nextCursor":null}`, do("GET", "/api/v2/tasks?owner="+bob.ID, "", "admin-secret").Body.String())
+}
+
+func TestLocalInputDirs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{AuthEnable: true, AuthKey: "admin-secret", MaxConcurrency: 1, MaxInputSize: 1 << 20, MaxImportRows: 10}
+	tm, _ := task.NewManager(cfg, &mockRunner{})
+	keys, _ := auth.NewStore(cfg)
+	router := SetupRouter(tm, keys, cfg)
+	_, secret, err := keys.Create(auth.Key{Scopes: []string{auth.ScopeSubmit}})
+	require.NoError(t, err)
+	allowed := localInput(t, cfg)
+	escape := filepath.Join(filepath.Dir(allowed), "escape.mkv")
+	require.NoError(t, os.Symlink("/etc/passwd", escape))
+	do := func(path, body, key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+key)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The server would read these with its own permissions.
+	for name, req := range map[string][2]string{
+		"inputMedia":      {"/api/v2/tasks", `{"command": "-i ${INPUT_MEDIA}", "inputMedia": "/etc/passwd", "outputExt": "mp4"}`},
+		"symlink out":     {"/api/v2/tasks", `{"command": "-i ${INPUT_MEDIA}", "inputMedia": "` + escape + `", "outputExt": "mp4"}`},
+		"concat inputs":   {"/api/v2/concat", `{"inputs": ["` + allowed + `", "/etc/passwd"], "outputExt": "mp4"}`},
+		"backgroundMedia": {"/api/v2/compose", `{"inputMedia": "` + allowed + `", "backgroundMedia": "/etc/passwd"}`},
+		"schedules":       {"/api/v2/schedules", `{"cron": "@daily", "command": "-i ${INPUT_MEDIA}", "inputMedia": "/etc/passwd", "outputExt": "mp4"}`},
+	} {
+		w := do(req[0], req[1], secret)
+		assert.Equal(t, http.StatusBadRequest, w.Code, name)
+		assert.Contains(t, w.Body.String(), `"code":"input_path_denied"`, name)
+	}
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v2/jobs/import", bytes.NewBufferString("input,preset\n/etc/passwd,mp3-192k\n"))
+	req.Header.Set("Content-Type", "text/csv")
+	req.Header.Set("Authorization", "Bearer "+secret)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), "LOCAL_INPUT_DIRS")
+	assert.Empty(t, tm.List())
+
+	// Inputs below LOCAL_INPUT_DIRS are fine, and admins are not limited.
+	assert.Equal(t, http.StatusAccepted, do("/api/v2/tasks", `{"command": "-i ${INPUT_MEDIA}", "inputMedia": "`+allowed+`", "outputExt": "mp4"}`, secret).Code)
+	assert.Equal(t, http.StatusAccepted, do("/api/v2/tasks", `{"command": "-i ${INPUT_MEDIA}", "inputMedia": "/etc/passwd", "outputExt": "mp4"}`, "admin-secret").Code)
+}
+
+// outputRunner writes a small primary output per task.
+type outputRunner struct{ dir string }
+
+func (r *outputRunner) Run(ctx context.Context, t *task.Task) (string, error) {
+	t.OutputPath = filepath.Join(r.dir, t.ID+"_output."+t.OutputExt)
+	return "ok", os.WriteFile(t.OutputPath, []byte("image"), 0o600)
+}
+
+// logRunner logs one line per progress update.
+type logRunner struct{}
+
+func (r *logRunner) Run(ctx context.Context, t *task.Task) (string, error) {
+	return "Input #0\nframe=1\rframe=2\rframe=3\nvideo:1kB\n", nil
+}
+
+func TestHandleGetTaskLogs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{MaxConcurrency: 1, OutputLocalLifetime: time.Hour, TempDir: t.TempDir()}
+	tm, _ := task.NewManager(cfg, &logRunner{})
+	keys, _ := auth.NewStore(cfg)
+	router := SetupRouter(tm, keys, cfg)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	queued, _ := tm.Submit("-i ${INPUT_MEDIA}", "a.mp4", "mp4")
+	w := get("/api/v2/tasks/" + queued.ID + "/logs")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "log_not_found")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tm.Start(ctx)
+	<-queued.Done()
+
+	var page task.LogPage
+	w = get("/api/v2/tasks/" + queued.ID + "/logs")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, task.LogPage{Lines: []string{"Input #0", "frame=1", "frame=2", "frame=3", "video:1kB"}, TotalLines: 5}, page)
+
+	w = get("/api/v1/tasks/" + queued.ID + "/logs?tail=2")
+	require.Equal(t, http.StatusOK, w.Code)
+	page = task.LogPage{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, task.LogPage{Lines: []string{"frame=3", "video:1kB"}, Offset: 3, TotalLines: 5}, page)
+
+	w = get("/api/v2/tasks/" + queued.ID + "/logs?offset=1&limit=2")
+	require.Equal(t, http.StatusOK, w.Code)
+	page = task.LogPage{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, task.LogPage{Lines: []string{"frame=1", "frame=2"}, Offset: 1, NextOffset: 3, TotalLines: 5}, page)
+
+	for _, query := range []string{"tail=0", "tail=2&offset=1", "offset=-1", "limit=100000", "stage=2", "stage=3"} {
+		assert.Equal(t, http.StatusBadRequest, get("/api/v2/tasks/"+queued.ID+"/logs?"+query).Code, query)
+	}
+	assert.Equal(t, http.StatusNotFound, get("/api/v2/tasks/nope/logs").Code)
+}
+
+func TestHandleTransform(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{MaxConcurrency: 1, OutputLocalLifetime: time.Hour, SyncTimeout: 5 * time.Second,
+		TempDir: t.TempDir(), TransformCacheTTL: time.Hour}
+	tm, _ := task.NewManager(cfg, &outputRunner{dir: cfg.TempDir})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tm.Start(ctx)
+	keys, _ := auth.NewStore(cfg)
+	router := SetupRouter(tm, keys, cfg)
+	accept := ""
+	get := func(path, etag string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		req.Header.Set("Accept", accept)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, get("/api/v1/transform?src=/etc/passwd&preset=thumbnail-jpg", "").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/api/v1/transform?src=https://example.com/a.mp4&preset=nope", "").Code)
+
+	path := "/api/v1/transform?src=https://example.com/a.mp4&preset=thumbnail-jpg"
+	w := get(path, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image", w.Body.String())
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Equal(t, "public, max-age=3600", w.Header().Get("Cache-Control"))
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	w = get(path, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+	assert.Equal(t, http.StatusNotModified, get(path, etag).Code)
+
+	// Browsers accepting WebP get it instead of the preset's JPEG.
+	accept = "image/webp,image/*,*/*;q=0.8"
+	w = get(path, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Equal(t, "image/webp", w.Header().Get("Content-Type"))
+	assert.Equal(t, "Accept", w.Header().Get("Vary"))
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+}
+
+func TestHandleQueueAdmin(t *testing.T) {
+	router, _, _ := setupTestRouter()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusNotFound, do("POST", "/api/v1/admin/queue/pause?queue=nightly", "").Code)
+	w := do("POST", "/api/v1/admin/queue/drain", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"draining":true`)
+
+	w = do("POST", "/api/v1/tasks", `{"command": "-i ${INPUT_MEDIA}", "inputMedia": "test.mkv", "outputExt": "mp4"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"queue_draining"`)
+
+	assert.Equal(t, http.StatusOK, do("POST", "/api/v1/admin/queue/resume", "").Code)
+	assert.Equal(t, http.StatusAccepted, do("POST", "/api/v1/tasks", `{"command": "-i ${INPUT_MEDIA}", "inputMedia": "test.mkv", "outputExt": "mp4"}`).Code)
+	w = do("GET", "/api/v1/admin/queue", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"depth":1`)
+}
+
+func TestHandleCreateTask_EstimatedOutputTooLarge(t *testing.T) {
+	router, cfg, _ := setupTestRouter()
+	cfg.MaxOutputSize = 1024 * 1024
+
+	w := httptest.NewRecorder()
+	reqBody := `{"command": "-i ${INPUT_MEDIA} -b:v 4M -t 60", "inputMedia": "test.mkv", "outputExt": "mp4"}`
+	req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "exceeds limit")
+
+	// In warn-only mode the task is accepted with a warning.
+	cfg.OutputSizeWarnOnly = true
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var resp acceptedTaskDoc
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Warnings, 1)
+	assert.Equal(t, "output_too_large", resp.Warnings[0].Code)
+	assert.Contains(t, resp.Warnings[0].Message, "exceeds limit")
+}
+
+func TestHandleCreateTask_EstimatedOutputQuota(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{AuthEnable: true, AuthKey: "admin-secret", MaxConcurrency: 1}
+	tm, _ := task.NewManager(cfg, &mockRunner{})
+	keys, _ := auth.NewStore(cfg)
+	router := SetupRouter(tm, keys, cfg)
+	_, secret, err := keys.Create(auth.Key{Scopes: []string{auth.ScopeSubmit}, Quota: &auth.Quota{MaxStorage: 1024 * 1024}})
+	require.NoError(t, err)
+	input := localInput(t, cfg)
+	submit := func(command, key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body := `{"command": "` + command + `", "inputMedia": "` + input + `", "outputExt": "mp4"}`
+		req, _ := http.NewRequest("POST", "/api/v2/tasks", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+key)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// About 30MB do not fit in the 1MB quota, about 125KB do.
+	w := submit("-i ${INPUT_MEDIA} -b:v 4M -t 60", secret)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"quota_exceeded"`)
+	assert.Equal(t, http.StatusAccepted, submit("-i ${INPUT_MEDIA} -b:v 100k -t 10", secret).Code)
+	assert.Equal(t, http.StatusAccepted, submit("-i ${INPUT_MEDIA} -b:v 4M -t 60", "admin-secret").Code)
+
+	cfg.OutputSizeWarnOnly = true
+	w = submit("-i ${INPUT_MEDIA} -b:v 4M -t 60", secret)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"quota_exceeded"`)
+}
+
+func TestHandleCreateTask_MaxOutputSize(t *testing.T) {
+	router, cfg, tm := setupTestRouter()
+	cfg.MaxOutputSize = 100 * 1024 * 1024
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"command": "-i ${INPUT_MEDIA} -vcodec copy", "inputMedia": "test.mkv", "outputExt": "mp4", "maxOutputSize": 1048576}`)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var resp map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	tk, _ := tm.Get(resp["taskId"])
+	assert.Equal(t, int64(1048576), tk.MaxOutputSize)
+
+	// The task's own limit applies to the estimate too.
+	w = post(`{"command": "-i ${INPUT_MEDIA} -b:v 4M -t 60", "inputMedia": "test.mkv", "outputExt": "mp4", "maxOutputSize": 1048576}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "exceeds limit of 1048576 bytes")
+
+	for _, size := range []string{"-1", "209715200"} {
+		w = post(`{"command": "-i ${INPUT_MEDIA} -vcodec copy", "inputMedia": "test.mkv", "outputExt": "mp4", "maxOutputSize": ` + size + `}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code, size)
+	}
+}
+
+func TestHandleCreateTask_StreamLabels(t *testing.T) {
+	router, _, tm := setupTestRouter()
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v2/tasks", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"command": "-i ${INPUT_MEDIA} -map 0 -c copy", "inputMedia": "test.mkv", "outputExt": "mkv",
+		"preserveStreamLabels": false, "streamLabels": [{"stream": "a:1", "language": "spa", "default": true}]}`)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var resp map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	tk, _ := tm.Get(resp["taskId"])
+	assert.True(t, tk.SkipStreamLabels)
+	require.Len(t, tk.StreamLabels, 1)
+	assert.Equal(t, "spa", tk.StreamLabels[0].Language)
+	assert.True(t, *tk.StreamLabels[0].Default)
+
+	w = post(`{"command": "-i ${INPUT_MEDIA} -map 0 -c copy", "inputMedia": "test.mkv", "outputExt": "mkv", "streamLabels": [{"stream": "a:x", "language": "spa"}]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid stream")
+
+	w = post(`{"command": "-i ${INPUT_MEDIA} -map 0:v ${OUTPUT_0} -map 0:a ${OUTPUT_1}", "inputMedia": "test.mkv", "outputs": ["mp4", "m4a"],
+		"streamLabels": [{"stream": "0", "title": "Main"}]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "single output file")
+}
+
+func TestHandleCreateTask_EgressDenied(t *testing.T) {
+	router, cfg, _ := setupTestRouter()
+	cfg.InputAllowedSchemes = []string{"https"}
+
+	w := httptest.NewRecorder()
+	reqBody := `{"command": "-i ${INPUT_MEDIA}", "inputMedia": "http://example.com/a.mkv", "outputExt": "mp4"}`
+	req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"input_egress_denied"`)
+}
+
+// vaultInputs serves the "vault" scheme, refusing inputs of the "sealed" box.
+type vaultInputs struct{}
+
+func (vaultInputs) Fetch(ctx context.Context, inputMedia string, dst io.Writer, maxSize int64) error {
+	_, err := io.WriteString(dst, "media")
+	return err
+}
+
+func (vaultInputs) CheckInput(inputMedia string) error {
+	if strings.HasPrefix(inputMedia, "vault://sealed/") {
+		return fmt.Errorf("box %q is sealed", "sealed")
+	}
+	return nil
+}
+
+func TestHandleCreateTask_InputProvider(t *testing.T) {
+	router, cfg, _ := setupTestRouter()
+	cfg.InputAllowedSchemes = []string{"https"} // Registered schemes skip the URL policy
+	ffmpeg.RegisterInputProvider("vault", vaultInputs{})
+	post := func(input string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v2/tasks", bytes.NewBufferString(`{"command": "-i ${INPUT_MEDIA}", "inputMedia": "`+input+`", "outputExt": "mp4"}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusAccepted, post("vault://open/a.mkv").Code)
+	w := post("vault://sealed/a.mkv")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "is sealed")
+	assert.Contains(t, post("sftp://host/a.mkv").Body.String(), `"code":"input_egress_denied"`)
+}
+
+// bucketOutputs is an output store serving keys from a fake bucket.
+type bucketOutputs struct{}
+
+func (bucketOutputs) Put(ctx context.Context, key, path string) error { return nil }
+
+func (bucketOutputs) URL(key string, expires time.Time) (string, error) {
+	return "https://bucket.example.com/" + key, nil
+}
+
+// fileRunner writes output.mp4 into the task's files directory.
+type fileRunner struct{ tempDir string }
+
+func (r *fileRunner) Run(ctx context.Context, t *task.Task) (string, error) {
+	filesDir := task.FilesDir(&config.Config{TempDir: r.tempDir}, t.ID)
+	if err := os.MkdirAll(filesDir, 0o700); err != nil {
+		return "", err
+	}
+	t.OutputPath = filepath.Join(filesDir, "output.mp4")
+	t.AddArtifact(task.ArtifactOutput, task.ArtifactOutput, t.OutputPath)
+	return "ok", os.WriteFile(t.OutputPath, []byte("video"), 0o600)
+}
+
+func TestHandleCreateTask_OutputStore(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	task.RegisterOutputStore("bucket", bucketOutputs{})
+	cfg := &config.Config{MaxConcurrency: 1, TempDir: t.TempDir(), OutputLocalLifetime: time.Hour}
+	tm, _ := task.NewManager(cfg, &fileRunner{tempDir: cfg.TempDir})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tm.Start(ctx)
+	keys, _ := auth.NewStore(cfg)
+	router := SetupRouter(tm, keys, cfg)
+	post := func(store string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		reqBody := `{"command": "-i ${INPUT_MEDIA}", "inputMedia": "test.mkv", "outputExt": "mp4", "outputStore": "` + store + `"}`
+		req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(reqBody))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("ftp")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `Unknown output store \"ftp\"`)
+
+	w = post("bucket")
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var resp map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	created, found := tm.Get(resp["taskId"])
+	require.True(t, found)
+	select {
+	case <-created.Done():
+	case <-time.After(time.Second):
+		t.Fatal("task did not finish")
+	}
+	require.Equal(t, task.StatusCompleted, created.Status)
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/tasks/"+created.ID, nil)
+	router.ServeHTTP(w, req)
+	var respTask task.Task
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &respTask))
+	assert.Equal(t, "bucket", respTask.OutputStore)
+	assert.Equal(t, "https://bucket.example.com/"+created.ID+"/output.mp4", respTask.DownloadURL)
+}
+
+func TestHandleCreateTask_Notify(t *testing.T) {
+	router, cfg, tm := setupTestRouter()
+	cfg.SMTPHost = "smtp.example.com"
+	cfg.NotifyEmailDomains = []string{"example.com"}
+	post := func(notify string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		reqBody := `{"command": "-i ${INPUT_MEDIA}", "inputMedia": "test.mkv", "outputExt": "mp4", "notify": ` + notify + `}`
+		req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(reqBody))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"slack": "https://hooks.slack.com/services/T0/B0/secret", "email": ["me@example.com"], "on": ["failed"]}`)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var resp map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	created, found := tm.Get(resp["taskId"])
+	require.True(t, found)
+	assert.Equal(t, "https://hooks.slack.com/services/T0/B0/secret", created.Notify.Slack)
+	assert.Equal(t, []task.Status{task.StatusFailed}, created.Notify.On)
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/tasks/"+created.ID, nil)
+	router.ServeHTTP(w, req)
+	assert.NotContains(t, w.Body.String(), "secret") // Webhook URLs carry their token
+	assert.Contains(t, w.Body.String(), `"notify":{"email":["me@example.com"],"on":["failed"]}`)
+
+	for notify, want := range map[string]string{
+		`{"email": ["me@elsewhere.com"]}`:           "not in NOTIFY_EMAIL_DOMAINS",
+		`{"on": ["processing"]}`:                    "want a final one",
+		`{"discord": "http://127.0.0.1:8080/hook"}`: "notify: ",
+	} {
+		w = post(notify)
+		assert.Equal(t, http.StatusBadRequest, w.Code, notify)
+		assert.Contains(t, w.Body.String(), want, notify)
+	}
+}
+
+func TestHandleCreateTask_InlineResult(t *testing.T) {
+	router, cfg, tm := setupTestRouter()
+	reqBody := `{"command": "-i ${INPUT_MEDIA} -frames:v 1", "inputMedia": "test.mkv", "outputExt": "png", "inlineResult": true}`
+
+	// Disabled unless INLINE_RESULT_MAX_SIZE is set.
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "inlineResult is disabled")
+
+	cfg.InlineResultMaxSize = 1024
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	created, found := tm.Get(resp["taskId"])
+	assert.True(t, found)
+	assert.True(t, created.InlineResult)
+}
+
+func TestHandleCreateTask_InvalidQC(t *testing.T) {
+	router, _, _ := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	reqBody := `{"command": "-i ${INPUT_MEDIA}", "inputMedia": "test.mkv", "outputExt": "mp4", "qc": "strict"}`
+	req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Invalid qc")
+}
+
+func TestHandleCreateTask_OutputTTL(t *testing.T) {
+	router, cfg, tm := setupTestRouter()
+	cfg.MaxOutputTTL = 24 * time.Hour
+
+	submit := func(ttl string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		reqBody := fmt.Sprintf(`{"command": "-i ${INPUT_MEDIA}", "inputMedia": "test.mkv", "outputExt": "mp4", "outputTtl": %q}`, ttl)
+		req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(reqBody))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := submit("2h")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var resp map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	tk, _ := tm.Get(resp["taskId"])
+	assert.Equal(t, 2*time.Hour, tk.OutputTTL)
+
+	for _, ttl := range []string{"48h", "-1h", "0s", "soon"} {
+		w = submit(ttl)
+		assert.Equal(t, http.StatusBadRequest, w.Code, ttl)
+		assert.Contains(t, w.Body.String(), "outputTtl")
+	}
+}
+
+func TestHandleCreateTask_Tool(t *testing.T) {
+	router, cfg, tm := setupTestRouter()
+	cfg.Tools = map[string]string{"mkvmerge": "/usr/bin/mkvmerge"}
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"tool": "mkvmerge", "command": "${INPUT_MEDIA} --language 0:eng", "inputMedia": "test.mp4", "outputExt": "mkv"}`)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var resp map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	tk, _ := tm.Get(resp["taskId"])
+	assert.Equal(t, "mkvmerge", tk.Tool)
+
+	for _, body := range []string{
+		`{"tool": "magick", "command": "${INPUT_MEDIA}", "inputMedia": "test.png", "outputExt": "jpg"}`,
+		`{"tool": "mkvmerge", "command": "${INPUT_MEDIA} -o /tmp/x.mkv", "inputMedia": "test.mp4", "outputExt": "mkv"}`,
+		`{"tool": "mkvmerge", "command": "${INPUT_MEDIA}", "inputMedia": "test.mp4", "outputMode": "directory", "outputExt": "mkv"}`,
+	} {
+		w = post(body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/tools", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `["ffmpeg", "mkvmerge"]`, w.Body.String())
+}
+
+func TestHandleCreateTask_ResourceClass(t *testing.T) {
+	router, cfg, tm := setupTestRouter()
+	cfg.ResourceClasses = map[string]string{"4k": "cpu:8 memory:16GB"}
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"command": "-i ${INPUT_MEDIA} -vcodec copy", "inputMedia": "test.mp4", "outputExt": "mp4", "resourceClass": "4K"}`)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var resp map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	tk, _ := tm.Get(resp["taskId"])
+	assert.Equal(t, "4k", tk.ResourceClass)
+
+	w = post(`{"command": "-i ${INPUT_MEDIA} -vcodec copy", "inputMedia": "test.mp4", "outputExt": "mp4", "resourceClass": "8k"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "available: 4k")
+}
+
+func TestHandleCreateTask_OutputUpload(t *testing.T) {
+	router, cfg, tm := setupTestRouter()
+	cfg.InputAllowedSchemes = []string{"https"}
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"command": "-i ${INPUT_MEDIA} -vcodec copy", "inputMedia": "test.mp4", "outputExt": "mp4",
+		"outputUpload": {"url": "https://bucket.example.com/out.mp4?X-Amz-Signature=secret", "headers": {"x-amz-acl": "private"}}}`)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var resp map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	tk, _ := tm.Get(resp["taskId"])
+	require.NotNil(t, tk.OutputUpload)
+	assert.Equal(t, http.MethodPut, tk.OutputUpload.Method)
+	assert.Equal(t, task.UploadPending, tk.OutputUpload.Status)
+	assert.Equal(t, map[string]string{"X-Amz-Acl": "private"}, tk.OutputUpload.Headers)
+
+	// The destination may carry credentials and is never shown.
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/tasks/"+tk.ID, nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "secret")
+	assert.Contains(t, w.Body.String(), `"outputUpload":{"method":"PUT","status":"pending"`)
+
+	for code, body := range map[string]string{
+		"invalid_request":      `{"command": "-i ${INPUT_MEDIA} -vcodec copy", "inputMedia": "test.mp4", "outputExt": "mp4", "outputUpload": {"url": "ftp://example.com/out.mp4"}}`,
+		"upload_egress_denied": `{"command": "-i ${INPUT_MEDIA} -vcodec copy", "inputMedia": "test.mp4", "outputExt": "mp4", "outputUpload": {"url": "http://example.com/out.mp4"}}`,
+	} {
+		w = post(body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		assert.Contains(t, w.Body.String(), code, body)
+	}
+	for _, body := range []string{
+		`{"command": "-i ${INPUT_MEDIA} -vcodec copy", "inputMedia": "test.mp4", "outputExt": "mp4", "outputUpload": {"url": "https://example.com/o", "method": "PATCH"}}`,
+		`{"command": "-i ${INPUT_MEDIA} -vcodec copy", "inputMedia": "test.mp4", "outputExt": "mp4", "outputUpload": {"url": "https://example.com/o", "headers": {"host": "evil.example.com"}}}`,
+		`{"command": "-i ${INPUT_MEDIA} -f hls ${OUTPUT_DIR}/index.m3u8", "inputMedia": "test.mp4", "outputMode": "directory", "outputExt": "m3u8", "outputUpload": {"url": "https://example.com/o"}}`,
+	} {
+		w = post(body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+}
+
+func TestHandleCreateTask_Pipe(t *testing.T) {
+	router, cfg, tm := setupTestRouter()
+	cfg.FFBuilds = map[string]string{"proprietary": "/opt/ffmpeg-proprietary/bin/ffmpeg"}
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"command": "-i ${INPUT_MEDIA} -f nut -c:v rawvideo", "inputMedia": "test.mp4", "outputExt": "mp4",
+		"pipe": {"command": "-i ${INPUT_MEDIA} -c:v libx264", "firstBuild": "proprietary"}}`)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var resp map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	tk, _ := tm.Get(resp["taskId"])
+	require.NotNil(t, tk.Pipe)
+	assert.Equal(t, "-i ${INPUT_MEDIA} -c:v libx264", tk.Pipe.Command)
+	assert.Equal(t, "proprietary", tk.Pipe.FirstBuild)
+
+	for code, body := range map[string]string{
+		"invalid_request": `{"command": "-i ${INPUT_MEDIA} -f nut", "inputMedia": "test.mp4", "outputExt": "mp4", "pipe": {"command": "-i ${INPUT_MEDIA}", "build": "missing"}}`,
+		"invalid_command": `{"command": "-i ${INPUT_MEDIA} -c:v rawvideo", "inputMedia": "test.mp4", "outputExt": "mp4", "pipe": {"command": "-i ${INPUT_MEDIA}"}}`,
+	} {
+		w = post(body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		assert.Contains(t, w.Body.String(), code, body)
+	}
+	for _, body := range []string{
+		`{"command": "-i ${INPUT_MEDIA} -f nut", "inputMedia": "test.mp4", "outputExt": "mp4", "pipe": {"command": "-i other.mp4"}}`,
+		`{"command": "-i ${INPUT_MEDIA} -f nut", "inputMedia": "test.mp4", "outputExt": "mp4", "pipe": {"command": "-i ${INPUT_MEDIA} -vf a;b"}}`,
+		`{"command": "-i ${INPUT_MEDIA} -f nut", "inputMedia": "test.mp4", "outputs": ["mp4", "webm"], "pipe": {"command": "-i ${INPUT_MEDIA}"}}`,
+	} {
+		w = post(body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+}
+
+func TestHandleCreateGraph(t *testing.T) {
+	router, _, tm := setupTestRouter()
+	post := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("/api/v1/graphs", `{"tasks": [
+		{"ref": "proxy", "command": "-i ${INPUT_MEDIA} -vf scale=640:-2", "inputMedia": "a.mp4", "outputExt": "mp4", "dependsOn": ["audio", "video"]},
+		{"ref": "audio", "command": "-i ${INPUT_MEDIA} -vn", "inputMedia": "a.mp4", "outputExt": "m4a"},
+		{"ref": "video", "command": "-i ${INPUT_MEDIA} -an", "inputMedia": "a.mp4", "outputExt": "mp4"}]}`)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var resp struct {
+		BatchID string            `json:"batchId"`
+		TaskIDs map[string]string `json:"taskIds"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.TaskIDs, 3)
+	proxy, _ := tm.Get(resp.TaskIDs["proxy"])
+	assert.Equal(t, task.StatusWaiting, proxy.Status)
+	assert.Equal(t, []string{resp.TaskIDs["audio"], resp.TaskIDs["video"]}, proxy.DependsOn)
+	assert.Equal(t, resp.BatchID, proxy.BatchID)
+
+	// Single tasks may depend on tasks submitted earlier.
+	w = post("/api/v1/tasks", `{"command": "-i ${INPUT_MEDIA}", "inputMedia": "a.mp4", "outputExt": "mp4", "dependsOn": ["`+proxy.ID+`"]}`)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	for path, body := range map[string]string{
+		"/api/v1/tasks": `{"command": "-i ${INPUT_MEDIA}", "inputMedia": "a.mp4", "outputExt": "mp4", "dependsOn": ["nope"]}`,
+		"/api/v1/graphs": `{"tasks": [{"ref": "a", "command": "-i ${INPUT_MEDIA}", "inputMedia": "a.mp4", "outputExt": "mp4", "dependsOn": ["b"]},
+			{"ref": "b", "command": "-i ${INPUT_MEDIA}", "inputMedia": "a.mp4", "outputExt": "mp4", "dependsOn": ["a"]}]}`,
+	} {
+		w = post(path, body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		assert.Contains(t, w.Body.String(), "invalid_dependencies", body)
+	}
+	for _, body := range []string{
+		`{"tasks": []}`,
+		`{"tasks": [{"command": "-i ${INPUT_MEDIA}", "inputMedia": "a.mp4", "outputExt": "mp4"}]}`,
+		`{"tasks": [{"ref": "a", "command": "-i ${INPUT_MEDIA}", "inputMedia": "a.mp4", "outputExt": "mp4"}, {"ref": "a", "command": "-i ${INPUT_MEDIA}", "inputMedia": "a.mp4", "outputExt": "mp4"}]}`,
+		`{"tasks": [{"ref": "a", "command": "-i ${INPUT_MEDIA}", "inputMedia": "a.mp4", "outputExt": "mp4", "delay": "1m", "dependsOn": ["` + proxy.ID + `"]}]}`,
+	} {
+		w = post("/api/v1/graphs", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+}
+
+func TestHandleGetPolicy(t *testing.T) {
+	router, cfg, _ := setupTestRouter()
+	cfg.MaxInputSize = 1 << 30
+	cfg.FFTimeout = 10 * time.Minute
+	cfg.InputAllowedSchemes = []string{"https"}
+	cfg.InputDeniedHosts = []string{"10.0.0.0/8"}
+	cfg.InputSources = map[string]string{"archive": "https://archive.example.com/{path}?token={secret:archive}"}
+	get := func() PolicyResponse {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/policy", nil)
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "10.0.0.0")
+		assert.NotContains(t, w.Body.String(), "archive.example.com")
+		var policy PolicyResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &policy))
+		return policy
+	}
+
+	policy := get()
+	assert.Equal(t, "${INPUT_MEDIA}", policy.Command.InputPlaceholder)
+	assert.Contains(t, policy.Command.DisallowedCharacters, ";")
+	assert.Equal(t, []ffmpeg.ToolPolicy{{Name: "ffmpeg"}}, policy.Command.Tools)
+	assert.Nil(t, policy.Command.Allowlist)
+	assert.Equal(t, []string{"https"}, policy.Inputs.AllowedSchemes)
+	assert.Equal(t, []string{"archive"}, policy.Inputs.Sources)
+	assert.Equal(t, int64(1<<30), policy.Limits.MaxInputSize)
+	assert.Equal(t, 600.0, policy.Limits.MaxRunSeconds)
+
+	cfg.CommandAllowlist = true
+	cfg.AllowedVideoCodecs = []string{"libx264"}
+	cfg.Tools = map[string]string{"mkvmerge": "mkvmerge"}
+	policy = get()
+	require.NotNil(t, policy.Command.Allowlist)
+	assert.Equal(t, []string{"libx264"}, policy.Command.Allowlist.VideoCodecs)
+	assert.Equal(t, []string{}, policy.Command.Allowlist.Filters)
+	require.Len(t, policy.Command.Tools, 2)
+	assert.Equal(t, "mkvmerge", policy.Command.Tools[1].Name)
+	assert.Contains(t, policy.Command.Tools[1].AllowedOptions, "--language")
+	assert.NotContains(t, policy.Command.Tools[1].AllowedOptions, "--output")
+}
+
+func TestHandleCreateTask_CommandAllowlist(t *testing.T) {
+	router, cfg, _ := setupTestRouter()
+	cfg.CommandAllowlist = true
+	cfg.AllowedVideoCodecs = []string{"libx264"}
+	cfg.AllowedAudioCodecs = []string{"aac"}
+	cfg.AllowedFilters = []string{"scale"}
+	cfg.AllowedFormats = []string{"mp4"}
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"command": "-i ${INPUT_MEDIA} -vf scale=640:-2 -c:v libx264 -c:a aac", "inputMedia": "test.mkv", "outputExt": "mp4"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	w = post(`{"command": "-i ${INPUT_MEDIA} -vf scale=640:-2,drawtext=text=hi -c:v libx264", "inputMedia": "test.mkv", "outputExt": "mp4"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"code": "command_not_allowed", "error": "Command not allowed: filter \"drawtext\" is not allowed (-vf)"}`, w.Body.String())
+
+	// The output extension implies a format as much as -f does.
+	w = post(`{"command": "-i ${INPUT_MEDIA} -c:v libx264", "inputMedia": "test.mkv", "outputExt": "mkv"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"code": "command_not_allowed", "error": "Command not allowed: output format \"matroska\" is not allowed (.mkv)"}`, w.Body.String())
+}
+
+func TestHandleCreateTask_Target(t *testing.T) {
+	router, _, tm := setupTestRouter()
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"target": "instagram_reel", "inputMedia": "test.mkv"}`)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	created, found := tm.Get(resp["taskId"])
+	require.True(t, found)
+	assert.Equal(t, "mp4", created.OutputExt)
+	assert.Contains(t, created.Command, "-c:v libx264")
+	assert.Equal(t, task.QCModeWarn, created.QC)
+	require.NotNil(t, created.Target)
+	assert.Equal(t, 1920, created.Target.Height)
+
+	w = post(`{"target": "broadcast", "inputMedia": "test.mkv"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Unknown target")
+	w = post(`{"target": "podcast", "command": "-i ${INPUT_MEDIA}", "inputMedia": "test.mkv"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = post(`{"target": "podcast", "inputMedia": "test.mkv", "outputExt": "wav"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = post(`{"inputMedia": "test.mkv", "outputExt": "mp4"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v2/targets", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"name":"ebu_r128_broadcast"`)
+}
+
+func TestHandleCreateTask_MultipleOutputs(t *testing.T) {
+	router, _, tm := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	reqBody := `{"command": "-i ${INPUT_MEDIA} ${OUTPUT_0} -vframes 1 ${OUTPUT_1}", "inputMedia": "test.mkv", "outputs": ["mp4", "jpg"]}`
+	req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	var resp map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	created, found := tm.Get(resp["taskId"])
+	assert.True(t, found)
+	assert.Equal(t, []string{"mp4", "jpg"}, created.OutputExts)
+
+	// Completed multi-output tasks list one download URL per output.
+	created.Status = task.StatusCompleted
+	created.OutputPath = "/tmp/x_output_0.mp4"
+	created.OutputPaths = []string{"/tmp/x_output_0.mp4", "/tmp/x_output_1.jpg"}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/tasks/"+created.ID, nil)
+	router.ServeHTTP(w, req)
+
+	var respTask task.Task
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &respTask))
+	assert.Len(t, respTask.DownloadURLs, 2)
+	assert.Contains(t, respTask.DownloadURLs[1], "/api/v1/files/x_output_1.jpg")
+
+	// A placeholder without a matching output is rejected.
+	w = httptest.NewRecorder()
+	reqBody = `{"command": "-i ${INPUT_MEDIA} ${OUTPUT_0} ${OUTPUT_1}", "inputMedia": "test.mkv", "outputs": ["mp4"]}`
+	req, _ = http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAPIVersions(t *testing.T) {
+	router, _, _ := setupTestRouter()
+
+	t.Run("v1 is deprecated with a successor link", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/tasks/nonexistent", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, "true", w.Header().Get("Deprecation"))
+		assert.Contains(t, w.Header().Get("Link"), "/api/v2")
+
+		var resp map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "Task not found", resp["error"])
+	})
+
+	t.Run("v2 uses the structured error envelope", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v2/tasks/nonexistent", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Empty(t, w.Header().Get("Deprecation"))
+
+		var resp struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "not_found", resp.Error.Code)
+		assert.Equal(t, "Task not found", resp.Error.Message)
+	})
+}
+
+// hlsRunner publishes a fixed HLS playlist and segment as a directory output.
+type hlsRunner struct{ tempDir string }
+
+func (r *hlsRunner) Run(ctx context.Context, t *task.Task) (string, error) {
+	outDir := filepath.Join(task.FilesDir(&config.Config{TempDir: r.tempDir}, t.ID), "output")
+	if err := os.MkdirAll(outDir, 0o700); err != nil {
+		return "", err
+	}
+	os.WriteFile(filepath.Join(outDir, "index.m3u8"), []byte("#EXTM3U\n"), 0o600)
+	os.WriteFile(filepath.Join(outDir, "seg_000.ts"), []byte{0x47}, 0o600)
+	t.OutputDir = outDir
+	t.OutputPath = filepath.Join(outDir, "index.m3u8")
+	t.AddArtifact(task.ArtifactOutput, task.ArtifactOutput, t.OutputPath).Dir = outDir
+	return "ok", nil
+}
+
+func TestHandleCreateTask_DirectoryOutput(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{MaxConcurrency: 1, TempDir: t.TempDir(), OutputLocalLifetime: time.Hour}
+	tm, _ := task.NewManager(cfg, &hlsRunner{tempDir: cfg.TempDir})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tm.Start(ctx)
+	keys, _ := auth.NewStore(cfg)
+	router := SetupRouter(tm, keys, cfg)
+
+	w := httptest.NewRecorder()
+	reqBody := `{"command": "-i ${INPUT_MEDIA} -f hls ${OUTPUT_DIR}/index.m3u8", "inputMedia": "test.mkv", "outputExt": "m3u8", "outputMode": "directory"}`
+	req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	var resp map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	created, found := tm.Get(resp["taskId"])
+	assert.True(t, found)
+	assert.Equal(t, task.OutputModeDirectory, created.OutputMode)
+	select {
+	case <-created.Done():
+	case <-time.After(time.Second):
+		t.Fatal("task did not finish")
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/tasks/"+created.ID, nil)
+	router.ServeHTTP(w, req)
+	var respTask task.Task
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &respTask))
+	assert.Contains(t, respTask.DownloadURL, "/api/v1/files/"+created.ID+"/output/index.m3u8")
+	if assert.Len(t, respTask.Artifacts, 2) {
+		assert.Equal(t, respTask.DownloadURL, respTask.Artifacts[0].DownloadURL)
+		assert.Equal(t, "application/vnd.apple.mpegurl", respTask.Artifacts[0].ContentType)
+		assert.Equal(t, int64(9), respTask.Artifacts[0].Size)
+		assert.Equal(t, task.ArtifactLog, respTask.Artifacts[1].Kind)
+		assert.Contains(t, respTask.Artifacts[1].DownloadURL, "/api/v1/files/"+created.ID+"/ffmpeg.log")
+	}
+
+	for name, contentType := range map[string]string{"index.m3u8": "application/vnd.apple.mpegurl", "seg_000.ts": "video/mp2t"} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/api/v1/files/"+created.ID+"/output/"+name, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, contentType, w.Header().Get("Content-Type"))
+	}
+
+	// The directory itself and paths outside task output directories are not served.
+	for _, path := range []string{created.ID, created.ID + "/output", "../work/" + created.ID, created.ID + "/output/../secret"} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/api/v1/files/"+path, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+	}
+
+	// ${OUTPUT_DIR} needs directory mode.
+	w = httptest.NewRecorder()
+	reqBody = `{"command": "-i ${INPUT_MEDIA} ${OUTPUT_DIR}/index.m3u8", "inputMedia": "test.mkv", "outputExt": "m3u8"}`
+	req, _ = http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandleCreateTask_OutputName(t *testing.T) {
+	router, _, tm := setupTestRouter()
+	submit := func(inputMedia, outputName string) (*httptest.ResponseRecorder, *task.Task) {
+		body, _ := json.Marshal(map[string]string{"command": "-i ${INPUT_MEDIA} -c:v libx264", "inputMedia": inputMedia, "outputExt": "mp4", "outputName": outputName})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v2/tasks", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		var resp struct{ TaskID string }
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		created, _ := tm.Get(resp.TaskID)
+		return w, created
+	}
+
+	w, created := submit("https://example.com/media/My%20Clip.final.mov?token=x", "{{.InputBasename}}_720p")
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.Equal(t, "My Clip.final_720p.mp4", created.OutputName)
+	_, created = submit("https://example.com/a.mov", "{{.InputBasename}}-{{.InputExt}}.{{.OutputExt}}")
+	assert.Equal(t, "a-mov.mp4", created.OutputName)
+	_, created = submit("https://example.com/a.mov", "clip.mp4")
+	assert.Equal(t, "clip.mp4", created.OutputName)
+
+	// Values are sanitized; what the client writes itself must be valid.
+	_, created = submit(`https://example.com/..%2F"evil"%0D.mov`, "{{.InputBasename}}")
+	assert.Equal(t, "_evil__.mp4", created.OutputName)
+	_, created = submit("https://example.com/a.mov", "{{ .Date }}_{{.InputBasename}}")
+	assert.Equal(t, time.Now().UTC().Format("2006-01-02")+"_a.mp4", created.OutputName)
+	for _, name := range []string{"{{.Nope}}", "{{.InputBasename", "a/{{.InputBasename}}", "{{if false}}x{{end}}",
+		`{{printf "%01000000d" 1}}`, strings.Repeat("{{.InputBasename}}", 300)} {
+		w, _ = submit("https://example.com/a.mov", name)
+		assert.Equal(t, http.StatusBadRequest, w.Code, name)
+	}
+}
+
+func TestHandleDownloadTask_Zip(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{MaxConcurrency: 1, TempDir: t.TempDir(), OutputLocalLifetime: time.Hour}
+	tm, _ := task.NewManager(cfg, &hlsRunner{tempDir: cfg.TempDir})
+	keys, _ := auth.NewStore(cfg)
+	router := SetupRouter(tm, keys, cfg)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	created, err := tm.SubmitWithOptions("-i ${INPUT_MEDIA} -f hls ${OUTPUT_DIR}/index.m3u8", "test.mkv", "m3u8", task.SubmitOptions{OutputMode: task.OutputModeDirectory})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, get("/api/v1/tasks/"+created.ID+"/download?format=zip").Code)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tm.Start(ctx)
+	select {
+	case <-created.Done():
+	case <-time.After(time.Second):
+		t.Fatal("task did not finish")
+	}
+
+	assert.Equal(t, http.StatusBadRequest, get("/api/v1/tasks/"+created.ID+"/download?format=tar").Code)
+	assert.Equal(t, http.StatusNotFound, get("/api/v1/tasks/nope/download?format=zip").Code)
+	w := get("/api/v1/tasks/" + created.ID + "/download?format=zip")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), created.ID+".zip")
+
+	// The whole output directory is bundled, the log is not.
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	require.NoError(t, err)
+	contents := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		contents[f.Name] = string(data)
+	}
+	assert.Equal(t, map[string]string{"index.m3u8": "#EXTM3U\n", "seg_000.ts": "\x47"}, contents)
+}
+
+func TestHandleCallbacks(t *testing.T) {
+	router, cfg, tm := setupTestRouter()
+	created, _ := tm.SubmitWithOptions("-i ${INPUT_MEDIA}", "test.mkv", "mp4", task.SubmitOptions{CallbackURL: "https://example.com/hook"})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/tasks/"+created.ID+"/callbacks", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"attempts":[]`)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/tasks/nonexistent/callbacks", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/admin/callbacks", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"endpoints":[]`)
+
+	// Callback URLs must be http(s) and pass the same egress policy as inputs.
+	w = httptest.NewRecorder()
+	reqBody := `{"command": "-i ${INPUT_MEDIA}", "inputMedia": "test.mkv", "outputExt": "mp4", "callbackUrl": "ftp://example.com/hook"}`
+	req, _ = http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	cfg.InputAllowedPorts = []int{443}
+	w = httptest.NewRecorder()
+	reqBody = `{"command": "-i ${INPUT_MEDIA}", "inputMedia": "test.mkv", "outputExt": "mp4", "callbackUrl": "http://example.com:8081/hook"}`
+	req, _ = http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"callback_egress_denied"`)
+}
+
+func TestHandleGetStats(t *testing.T) {
+	router, _, _ := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/stats?interval=day&from=2024-05-01T00:00:00Z&to=2024-05-08T00:00:00Z", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var report task.StatsReport
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, "day", report.Interval)
+	assert.Len(t, report.Buckets, 7)
+	assert.Equal(t, time.Date(2024, 5, 7, 0, 0, 0, 0, time.UTC), report.Buckets[6].Start)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/stats", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"interval":"hour"`)
+
+	for _, query := range []string{"from=yesterday", "interval=week", "from=2024-05-08T00:00:00Z&to=2024-05-01T00:00:00Z"} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/api/v1/stats?"+query, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestHandleExportBilling(t *testing.T) {
+	router, _, tm := setupTestRouter()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tm.Start(ctx)
+	get := func(path string, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", accept)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(`{"command": "-i ${INPUT_MEDIA} out.mp4", "inputMedia": "in.mp4", "outputExt": "mp4", "billing": true}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var resp map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	created, _ := tm.Get(resp["taskId"])
+	select {
+	case <-created.Done():
+	case <-time.After(time.Second):
+		t.Fatal("task did not finish")
+	}
+
+	w = get("/api/v2/admin/billing", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	var item BillingLineItem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &item))
+	assert.Equal(t, created.ID, item.TaskID)
+	assert.Equal(t, task.StatusCompleted, item.Status)
+
+	w = get("/api/v2/admin/billing?to="+time.Now().Add(time.Minute).Format(time.RFC3339), "text/csv")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), ".csv")
+	records, err := csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, billingColumns, records[0])
+	assert.Equal(t, created.ID, records[1][0])
+	assert.Equal(t, "completed", records[1][2])
+
+	// Another key's or another period's export is empty.
+	w = get("/api/v2/admin/billing?format=csv&keyId=other", "")
+	assert.Equal(t, "task_id,key_id,status,preset,queue,created_at,completed_at,encode_seconds,cpu_seconds,gb_stored,gb_egressed\n", w.Body.String())
+	w = get("/api/v2/admin/billing?from=2024-05-01&to=2024-06-01", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	for _, query := range []string{"format=xml", "from=yesterday", "from=2024-06-01&to=2024-05-01"} {
+		assert.Equal(t, http.StatusBadRequest, get("/api/v2/admin/billing?"+query, "").Code, query)
+	}
+}
+
+func TestHandleCreateABR(t *testing.T) {
+	router, _, tm := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	reqBody := `{"inputMedia": "test.mkv", "renditions": [{"name": "720p", "height": 720, "videoBitrate": "2800k"}, {"name": "360p", "height": 360, "videoBitrate": "800k"}]}`
+	req, _ := http.NewRequest("POST", "/api/v1/transcode/abr", bytes.NewBufferString(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	var resp map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	created, found := tm.Get(resp["taskId"])
+	assert.True(t, found)
+	assert.Equal(t, task.OutputModeDirectory, created.OutputMode)
+	assert.Equal(t, "master.m3u8", created.OutputEntry)
+	assert.Len(t, created.Renditions, 2)
+
+	w = httptest.NewRecorder()
+	reqBody = `{"inputMedia": "test.mkv", "format": "smooth", "renditions": [{"name": "720p", "height": 720, "videoBitrate": "2800k"}]}`
+	req, _ = http.NewRequest("POST", "/api/v1/transcode/abr", bytes.NewBufferString(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandleImportJobs(t *testing.T) {
+	router, cfg, tm := setupTestRouter()
+	cfg.MaxInputSize = 1 << 20
+	cfg.MaxImportRows = 10
+
+	t.Run("csv upload with per-row report", func(t *testing.T) {
+		manifest := "input,preset,output_name\n" +
+			"https://example.com/a.mov,h264-720p,a\n" +
+			"https://example.com/b.mov,nope,b.mp4\n" +
+			",mp3-192k,\n"
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/jobs/import?priority=low", bytes.NewBufferString(manifest))
+		req.Header.Set("Content-Type", "text/csv")
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+
+		var report ImportReport
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		assert.Equal(t, 3, report.Total)
+		assert.Equal(t, 1, report.Accepted)
+		assert.Equal(t, 2, report.Rejected)
+		assert.Equal(t, 2, report.Rows[0].Line)
+		assert.Contains(t, report.Rows[1].Error, "unknown preset")
+
+		created, found := tm.Get(report.Rows[0].TaskID)
+		assert.True(t, found)
+		assert.Equal(t, report.BatchID, created.BatchID)
+		assert.Equal(t, "a.mp4", created.OutputName)
+		assert.Equal(t, task.PriorityLow, created.Priority)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/api/v1/tasks?batchId="+report.BatchID, nil)
+		router.ServeHTTP(w, req)
+		var listed []task.Task
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+		assert.Len(t, listed, 1)
+	})
+
+	t.Run("remote jsonl manifest", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintln(w, `{"input": "https://example.com/a.wav", "preset": "opus-96k"}`)
+			fmt.Fprintln(w, `not json`)
+		}))
+		defer srv.Close()
+		reqBody := fmt.Sprintf(`{"manifestUrl": "%s/jobs.jsonl"}`, srv.URL)
+
+		// Loopback addresses are off limits by default.
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/jobs/import", bytes.NewBufferString(reqBody))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "loopback")
+
+		cfg.InputAllowPrivate = true
+		defer func() { cfg.InputAllowPrivate = false }()
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("POST", "/api/v1/jobs/import", bytes.NewBufferString(reqBody))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+
+		var report ImportReport
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		assert.Equal(t, 1, report.Accepted)
+		assert.Contains(t, report.Rows[1].Error, "invalid JSON")
+	})
+
+	t.Run("too many rows", func(t *testing.T) {
+		manifest := "input,preset\n" + strings.Repeat("x.mov,mp3-192k\n", 11)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/jobs/import", bytes.NewBufferString(manifest))
+		req.Header.Set("Content-Type", "text/csv")
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid_manifest")
+	})
+}
+
+func TestHandleCancelGroups(t *testing.T) {
+	router, cfg, tm := setupTestRouter()
+	cfg.MaxInputSize = 1 << 20
+	post := func(path, contentType, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", contentType)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("batch", func(t *testing.T) {
+		w := post("/api/v2/jobs/import", "text/csv", "input,preset\na.mov,mp3-192k\nb.mov,mp3-192k\n")
+		require.Equal(t, http.StatusAccepted, w.Code)
+		var imported ImportReport
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &imported))
+		require.NoError(t, tm.Cancel(imported.Rows[0].TaskID))
+
+		w = post("/api/v2/batches/"+imported.BatchID+"/cancel", "application/json", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var report CancelReport
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		assert.Equal(t, 1, report.Canceled)
+		require.Len(t, report.Results, 2)
+		assert.False(t, report.Results[0].Canceled)
+		assert.Contains(t, report.Results[0].Error, "cannot cancel")
+		assert.True(t, report.Results[1].Canceled)
+		assert.Equal(t, task.StatusCanceled, report.Results[1].Status)
+
+		assert.Equal(t, http.StatusNotFound, post("/api/v2/batches/nope/cancel", "application/json", "").Code)
+	})
+
+	t.Run("pipeline", func(t *testing.T) {
+		w := post("/api/v2/pipelines", "application/json", `{"inputMedia": "test.mkv", "steps": [
+			{"command": "-i ${INPUT_MEDIA} -vcodec copy", "outputExt": "mp4"},
+			{"command": "-i ${INPUT_MEDIA} -vcodec libvpx", "outputExt": "webm"}]}`)
+		require.Equal(t, http.StatusAccepted, w.Code)
+		var created struct {
+			PipelineID string `json:"pipelineId"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+
+		w = post("/api/v2/pipelines/"+created.PipelineID+"/cancel", "application/json", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var report CancelReport
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		assert.Equal(t, 2, report.Canceled)
+		for _, r := range report.Results {
+			assert.Equal(t, task.StatusCanceled, r.Status) // Not skipped
+		}
+
+		assert.Equal(t, http.StatusNotFound, post("/api/v2/pipelines/nope/cancel", "application/json", "").Code)
+	})
+}
+
+func TestHandleCreateSubtitles(t *testing.T) {
+	router, cfg, tm := setupTestRouter()
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Without a speech-to-text backend subtitles can't be requested.
+	w := post("/api/v1/subtitles", `{"inputMedia": "talk.mp4"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "stt_unavailable")
+
+	cfg.STTURL = "http://whisper.local/inference"
+	w = post("/api/v1/subtitles", `{"inputMedia": "talk.mp4", "format": "vtt", "language": "de"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var resp map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	created, _ := tm.Get(resp["taskId"])
+	assert.True(t, created.SubtitlesOnly)
+	assert.Equal(t, "vtt", created.Subtitles)
+
+	// Alongside a regular transcode.
+	w = post("/api/v1/tasks", `{"command": "-i ${INPUT_MEDIA}", "inputMedia": "talk.mp4", "outputExt": "mp4", "subtitles": "srt"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	w = post("/api/v1/tasks", `{"command": "-i ${INPUT_MEDIA}", "inputMedia": "talk.mp4", "outputExt": "mp4", "subtitles": "ass"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandleCreateThumbnails(t *testing.T) {
+	router, _, tm := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	reqBody := `{"inputMedia": "movie.mp4", "interval": 10, "count": 12, "sprite": true}`
+	req, _ := http.NewRequest("POST", "/api/v1/thumbnails", bytes.NewBufferString(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	var resp map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	created, _ := tm.Get(resp["taskId"])
+	assert.Equal(t, task.OutputModeDirectory, created.OutputMode)
+	assert.Equal(t, "sprite.jpg", created.OutputEntry)
+	assert.Contains(t, created.ExtraFiles, "thumbnails.vtt")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/thumbnails", bytes.NewBufferString(`{"inputMedia": "movie.mp4"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandleFrameRateAndTimecode(t *testing.T) {
+	router, _, tm := setupTestRouter()
+	post := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("/api/v2/framerate", `{"inputMedia": "movie.mp4", "frameRate": "25", "method": "speed", "sourceFrameRate": "23.976", "timecode": "10:00:00:00"}`)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var resp map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	created, _ := tm.Get(resp["taskId"])
+	assert.Equal(t, "mov", created.OutputExt)
+	assert.Contains(t, created.Command, "setpts=PTS*24000*1/(1001*25),fps=25")
+	assert.Contains(t, created.Command, "-timecode 10:00:00:00")
+
+	w = post("/api/v2/timecode", `{"inputMedia": "movie.mp4", "timecode": "01:00:00;00", "frameRate": "29.97", "outputExt": "mp4"}`)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	created, _ = tm.Get(resp["taskId"])
+	assert.Equal(t, "mp4", created.OutputExt)
+
+	for path, body := range map[string]string{
+		"/api/v2/framerate": `{"inputMedia": "movie.mp4", "frameRate": "29.9"}`,
+		"/api/v2/timecode":  `{"inputMedia": "movie.mp4", "timecode": "01:00:00;00", "frameRate": "25"}`,
+	} {
+		w = post(path, body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+}
+
+func TestHandleCreateClip(t *testing.T) {
+	router, _, tm := setupTestRouter()
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/clip", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"inputMedia": "movie.mp4", "start": "00:01:30", "end": "100.5"}`)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var resp map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	created, _ := tm.Get(resp["taskId"])
+	assert.Equal(t, "mp4", created.OutputExt)
+	assert.True(t, strings.HasPrefix(created.Command, "-ss 90.000 -i ${INPUT_MEDIA} -t 10.500 "), created.Command)
+	assert.Contains(t, created.Command, "-c:v libx264")
+
+	w = post(`{"inputMedia": "movie.mkv", "start": "5", "duration": "30", "mode": "copy", "outputExt": "mkv"}`)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	created, _ = tm.Get(resp["taskId"])
+	assert.Equal(t, "mkv", created.OutputExt)
+	assert.Contains(t, created.Command, "-t 30.000 -map 0:v? -map 0:a? -c copy")
+
+	for body, msg := range map[string]string{
+		`{"inputMedia": "movie.mp4"}`:                                "start, end or duration is required",
+		`{"inputMedia": "movie.mp4", "start": "1:75"}`:               "start: invalid time",
+		`{"inputMedia": "movie.mp4", "start": "20", "end": "10"}`:    "not after start",
+		`{"inputMedia": "movie.mp4", "end": "10", "duration": "10"}`: "mutually exclusive",
+		`{"start": "10"}`: "InputMedia",
+	} {
+		w = post(body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		assert.Contains(t, w.Body.String(), msg, body)
+	}
+}
+
+func TestHandleCreateConcat(t *testing.T) {
+	router, _, tm := setupTestRouter()
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/concat", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"inputs": ["intro.mp4", "https://example.com/main.mov", "outro.mp4"], "outputExt": "mkv"}`)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var resp map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	created, _ := tm.Get(resp["taskId"])
+	assert.True(t, created.Concat)
+	assert.Equal(t, "mkv", created.OutputExt)
+	assert.Equal(t, "intro.mp4", created.InputMedia)
+	assert.Equal(t, []string{"https://example.com/main.mov", "outro.mp4"}, created.ExtraInputs)
+	assert.Contains(t, created.Command, "concat=n=3")
+
+	for body, msg := range map[string]string{
+		`{}`:                       "Inputs",
+		`{"inputs": ["only.mp4"]}`: "between 2 and 100 inputs",
+		`{"inputs": ["a.mp4", "b.mp4"], "outputExt": "avi"}`: "unsupported output format",
+	} {
+		w = post(body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		assert.Contains(t, w.Body.String(), msg, body)
+	}
+}
+
+func TestHandleCreateAudioExtract(t *testing.T) {
+	router, _, tm := setupTestRouter()
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/audio/extract", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"inputMedia": "episode.mp4", "format": "aac", "bitrate": 96, "channels": 1}`)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var resp map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	created, _ := tm.Get(resp["taskId"])
+	assert.Equal(t, "m4a", created.OutputExt)
+	assert.Equal(t, "-i ${INPUT_MEDIA} -map 0:a:0 -vn -sn -dn -c:a aac -movflags +faststart -b:a 96k -ac 1", created.Command)
+
+	for body, msg := range map[string]string{
+		`{"format": "mp3"}`: "InputMedia",
+		`{"inputMedia": "episode.mp4", "format": "wma"}`:                   `unsupported format \"wma\"`,
+		`{"inputMedia": "episode.mp4", "format": "opus", "bitrate": 1000}`: "6 to 510 kbit/s",
+	} {
+		w = post(body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		assert.Contains(t, w.Body.String(), msg, body)
+	}
+}
+
+func TestHandleCreateCompose(t *testing.T) {
+	router, _, tm := setupTestRouter()
+	post := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("/api/v2/compose", `{"inputMedia": "logo.mov", "backgroundMedia": "https://example.com/bg.mp4", "x": 16, "y": 16, "outputExt": "mp4"}`)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var resp map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	created, _ := tm.Get(resp["taskId"])
+	assert.Equal(t, []string{"https://example.com/bg.mp4"}, created.ExtraInputs)
+	assert.Contains(t, created.Command, "overlay=x=16:y=16")
+
+	w = post("/api/v2/compose", `{"inputMedia": "logo.mov", "backgroundColor": "#00b140"}`)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	created, _ = tm.Get(resp["taskId"])
+	assert.Empty(t, created.ExtraInputs)
+
+	w = post("/api/v2/compose", `{"inputMedia": "logo.mov", "backgroundColor": "black", "backgroundMedia": "bg.mp4"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = post("/api/v2/compose", `{"inputMedia": "logo.mov", "backgroundMedia": "http://127.0.0.1/bg.mp4"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "input_egress_denied")
+}
+
+func TestHandleCreateTask_AlphaWarning(t *testing.T) {
+	router, _, _ := setupTestRouter()
+	submit := func(command, ext string) map[string]interface{} {
+		body, _ := json.Marshal(TaskRequest{Command: command, InputMedia: "logo.mov", OutputExt: ext})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v2/tasks", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+		var resp map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	resp := submit("-i ${INPUT_MEDIA} -c:v libx264 -pix_fmt yuva420p", "mp4")
+	warnings, _ := resp["warnings"].([]interface{})
+	require.Len(t, warnings, 1)
+	assert.Equal(t, "alpha_dropped", warnings[0].(map[string]interface{})["code"])
+
+	resp = submit("-i ${INPUT_MEDIA} -c:v libvpx-vp9 -pix_fmt yuva420p", "webm")
+	assert.NotContains(t, resp, "warnings")
+}
+
+func TestHandleInputs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{MaxConcurrency: 1, TempDir: t.TempDir(), MaxInputSize: 1024, UploadURLTTL: time.Minute}
+	tm, err := task.NewManager(cfg, &mockRunner{})
+	assert.NoError(t, err)
+	keys, _ := auth.NewStore(cfg)
+	router := SetupRouter(tm, keys, cfg)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v2/inputs", bytes.NewBufferString(`{"size": 5, "contentType": "video/mp4"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var reservation struct {
+		InputID      string `json:"inputId"`
+		Status       string `json:"status"`
+		UploadURL    string `json:"uploadUrl"`
+		UploadMethod string `json:"uploadMethod"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &reservation))
+	assert.Equal(t, "reserved", reservation.Status)
+	assert.Equal(t, "PUT", reservation.UploadMethod)
+	assert.Contains(t, reservation.UploadURL, "/api/v2/inputs/"+reservation.InputID+"/content?expires=")
+
+	// Tasks can't use the input before it is uploaded.
+	submit := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v2/tasks", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+	taskBody := `{"command": "-i ${INPUT_MEDIA} -c copy", "inputId": "` + reservation.InputID + `", "outputExt": "mp4"}`
+	assert.Equal(t, http.StatusConflict, submit(taskBody).Code)
+
+	upload := func(url, body string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", strings.TrimPrefix(url, "http://"), bytes.NewBufferString(body))
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	uploadPath := reservation.UploadURL[strings.Index(reservation.UploadURL, "/api/"):]
+	assert.Equal(t, http.StatusForbidden, upload(strings.Replace(uploadPath, "signature=", "signature=0", 1), "video"))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, upload(uploadPath, "too large"))
+	assert.Equal(t, http.StatusOK, upload(uploadPath, "video"))
+	assert.Equal(t, http.StatusConflict, upload(uploadPath, "video"))
+
+	w = submit(taskBody)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var resp map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	created, found := tm.Get(resp["taskId"])
+	assert.True(t, found)
+	assert.Equal(t, reservation.InputID, created.InputID)
+	assert.Equal(t, task.InputRef(reservation.InputID), created.InputMedia)
+
+	// Referencing both, or an unknown input, is rejected.
+	assert.Equal(t, http.StatusBadRequest, submit(`{"command": "-i ${INPUT_MEDIA}", "inputId": "x", "inputMedia": "a.mp4", "outputExt": "mp4"}`).Code)
+	assert.Equal(t, http.StatusNotFound, submit(`{"command": "-i ${INPUT_MEDIA}", "inputMedia": "input://nope", "outputExt": "mp4"}`).Code)
+}
+
+func TestHandleUploads(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{MaxConcurrency: 1, TempDir: t.TempDir(), MaxInputSize: 1024, ResumableUploadTTL: time.Hour, InputTTL: time.Hour}
+	tm, err := task.NewManager(cfg, &mockRunner{})
+	require.NoError(t, err)
+	keys, _ := auth.NewStore(cfg)
+	router := SetupRouter(tm, keys, cfg)
+
+	tus := func(method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := tus("OPTIONS", "/api/v2/uploads", "", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "1.0.0", w.Header().Get("Tus-Version"))
+	assert.Equal(t, "1024", w.Header().Get("Tus-Max-Size"))
+
+	w = tus("POST", "/api/v2/uploads", "", map[string]string{"Upload-Length": "10", "Tus-Resumable": "0.2.2"})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	w = tus("POST", "/api/v2/uploads", "", map[string]string{"Upload-Length": "2048"})
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	w = tus("POST", "/api/v2/uploads", "", map[string]string{"Upload-Length": "10", "Upload-Metadata": "filename bW92aWUubXA0,filetype dmlkZW8vbXA0"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	location := w.Header().Get("Location")
+	path := location[strings.Index(location, "/api/"):]
+	id := strings.TrimPrefix(path, "/api/v2/uploads/")
+	in, ok := tm.GetInput(id)
+	require.True(t, ok)
+	assert.Equal(t, "video/mp4", in.ContentType)
+	assert.NotEmpty(t, w.Header().Get("Upload-Expires"))
+
+	chunk := func(offset, body string) *httptest.ResponseRecorder {
+		return tus("PATCH", path, body, map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": offset})
+	}
+	assert.Equal(t, http.StatusUnsupportedMediaType, tus("PATCH", path, "01234", map[string]string{"Upload-Offset": "0"}).Code)
+	w = chunk("0", "01234")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Upload-Offset"))
+	assert.Equal(t, http.StatusConflict, chunk("0", "01234").Code)
+
+	// An interrupted client asks where to resume.
+	w = tus("HEAD", path, "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Upload-Offset"))
+	assert.Equal(t, "10", w.Header().Get("Upload-Length"))
+
+	// The input can't be used before the upload is complete.
+	submit := func() int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v2/tasks", bytes.NewBufferString(`{"command": "-i ${INPUT_MEDIA} -c copy", "inputId": "`+id+`", "outputExt": "mp4"}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusConflict, submit())
+	assert.Equal(t, http.StatusRequestEntityTooLarge, chunk("5", "56789-too-long").Code)
+	w = chunk("5", "56789")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "10", w.Header().Get("Upload-Offset"))
+	assert.Equal(t, task.InputUploaded, in.Status)
+	assert.Equal(t, http.StatusAccepted, submit())
+
+	// Uploads used by a task can't be terminated; others can.
+	assert.Equal(t, http.StatusConflict, tus("DELETE", path, "", nil).Code)
+	w = tus("POST", "/api/v2/uploads", "", map[string]string{"Upload-Length": "10"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	location = w.Header().Get("Location")
+	other := location[strings.Index(location, "/api/"):]
+	assert.Equal(t, http.StatusNoContent, tus("DELETE", other, "", nil).Code)
+	assert.Equal(t, http.StatusNotFound, tus("HEAD", other, "", nil).Code)
+}
+
+func TestOpenAPI(t *testing.T) {
+	router, _, _ := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/openapi.json", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var spec struct {
+		OpenAPI    string                                `json:"openapi"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+				Required   []string                   `json:"required"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &spec))
+	assert.Equal(t, "3.0.3", spec.OpenAPI)
+
+	// Every v1 route is documented.
+	for _, route := range router.Routes() {
+		path, ok := strings.CutPrefix(route.Path, "/api/v1")
+		if !ok {
+			continue
+		}
+		path, _ = openAPIPath(path)
+		assert.Contains(t, spec.Paths[path], strings.ToLower(route.Method), route.Method+" "+route.Path)
+	}
+
+	taskRequest := spec.Components.Schemas["TaskRequest"]
+	assert.Empty(t, taskRequest.Required) // "command" unless a target is given
+	assert.Contains(t, taskRequest.Properties, "inputMedia")
+	assert.Contains(t, spec.Components.Schemas["Task"].Properties, "artifacts")
+	assert.NotContains(t, spec.Components.Schemas["Task"].Properties, "Command")
+	assert.JSONEq(t, `{"type":"string","enum":["queued","processing","completed","completed_with_warnings","failed","canceled","scheduled","waiting","waiting_resources","skipped","interrupted"]}`,
+		string(spec.Components.Schemas["Task"].Properties["status"]))
+	assert.Contains(t, spec.Components.Schemas["InputReservation"].Properties, "uploadUrl")
+	assert.Contains(t, spec.Components.Schemas["InputReservation"].Properties, "inputId")
+
+	// The reference page and its assets are served by the server itself.
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	w = get("/docs")
+	assert.Equal(t, http.StatusMovedPermanently, w.Code)
+	assert.Equal(t, "docs/", w.Header().Get("Location"))
+	w = get("/docs/")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "docs.js")
+	assert.NotContains(t, w.Body.String(), "https://")
+	assert.Contains(t, w.Header().Get("Content-Security-Policy"), "default-src 'self'")
+	w = get("/docs/docs.js")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "../openapi.json")
+}
+
+func TestGetSchema(t *testing.T) {
+	router, _, _ := setupTestRouter()
+
+	get := func(path string) map[string]json.RawMessage {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/schema+json", w.Header().Get("Content-Type"))
+		var doc struct {
+			Schema  string                     `json:"$schema"`
+			ID      string                     `json:"$id"`
+			Version string                     `json:"version"`
+			Defs    map[string]json.RawMessage `json:"$defs"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+		assert.Equal(t, "https://json-schema.org/draft/2020-12/schema", doc.Schema)
+		assert.Equal(t, path, doc.ID)
+		assert.Equal(t, schemaVersion, doc.Version)
+		for _, name := range []string{"Task", "TaskRequest", "TaskCompletedEvent", "Error", "TaskStatus", "Priority"} {
+			assert.Contains(t, doc.Defs, name)
+		}
+		return doc.Defs
+	}
+
+	v1 := get("/api/v1/schema")
+	assert.Contains(t, string(v1["Task"]), `"$ref":"#/$defs/QCReport"`)
+	assert.Contains(t, string(v1["TaskStatus"]), `"interrupted"`)
+	assert.Contains(t, string(v1["Error"]), `"error":{"type":"string"}`)
+
+	// Each version describes its own error envelope.
+	v2 := get("/api/v2/schema")
+	assert.Contains(t, string(v2["Error"]), `"error":{"$ref":"#/$defs/ErrorBody"}`)
+	assert.Contains(t, v2, "ErrorBody")
+}
+
+func TestHandleListTasks_Pagination(t *testing.T) {
+	router, _, tm := setupTestRouter()
+	var ids []string
+	for i := 0; i < 5; i++ {
+		tk, err := tm.Submit("-i ${INPUT_MEDIA}", fmt.Sprintf("in%d.mp4", i), "mp4")
+		assert.NoError(t, err)
+		ids = append(ids, tk.ID)
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Pages of two, oldest first, until nextCursor is null.
+	var seen []string
+	cursor := ""
+	for pages := 0; pages < 5; pages++ {
+		w := get("/api/v2/tasks?sort=createdAt&limit=2&cursor=" + cursor)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var page struct {
+			Tasks      []*task.Task `json:"tasks"`
+			NextCursor *string      `json:"nextCursor"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		for _, tk := range page.Tasks {
+			seen = append(seen, tk.ID)
+		}
+		if page.NextCursor == nil {
+			break
+		}
+		cursor = *page.NextCursor
+	}
+	assert.Len(t, seen, 5)
+	assert.ElementsMatch(t, ids, seen)
+	for i := 1; i < len(seen); i++ {
+		prev, _ := tm.Get(seen[i-1])
+		cur, _ := tm.Get(seen[i])
+		assert.False(t, cur.CreatedAt.Before(prev.CreatedAt))
+	}
+
+	// v1 keeps the bare array and reports the next page in a header.
+	w := get("/api/v1/tasks?limit=3")
+	var list []*task.Task
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Len(t, list, 3)
+	assert.NotEmpty(t, w.Header().Get("X-Next-Cursor"))
+	assert.NoError(t, json.Unmarshal(get("/api/v1/tasks").Body.Bytes(), &list))
+	assert.Len(t, list, 5)
+
+	// Filters
+	assert.NoError(t, json.Unmarshal(get("/api/v1/tasks?status=canceled").Body.Bytes(), &list))
+	assert.Empty(t, list)
+	future := url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339))
+	assert.NoError(t, json.Unmarshal(get("/api/v1/tasks?createdAfter="+future).Body.Bytes(), &list))
+	assert.Empty(t, list)
+
+	for _, query := range []string{"status=done", "createdAfter=yesterday", "limit=0", "limit=5000", "sort=name", "cursor=bogus", "sort=completedAt&cursor=" + cursor} {
+		assert.Equal(t, http.StatusBadRequest, get("/api/v2/tasks?"+query).Code, query)
+	}
+}
+
+func TestHandleDeleteTask(t *testing.T) {
+	router, _, tm := setupTestRouter()
+	tk, err := tm.Submit("-i ${INPUT_MEDIA}", "test.mkv", "mp4")
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/api/v1/tasks/"+tk.ID, nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, ok := tm.Get(tk.ID)
+	assert.False(t, ok)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/api/v1/tasks/"+tk.ID, nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleCreateTask_InputSource(t *testing.T) {
+	router, cfg, tm := setupTestRouter()
+	cfg.InputSources = map[string]string{"cdn": "https://cdn.example.com/{path}?token={secret:cdn_token}"}
+	cfg.InputSecrets = map[string]string{"cdn_token": "s3cr3t"}
+
+	submit := func(input string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body := fmt.Sprintf(`{"command": "-i ${INPUT_MEDIA}", "inputMedia": %q, "outputExt": "mp4"}`, input)
+		req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := submit("source://cdn/videos/a.mp4")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	var resp map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	tk, ok := tm.Get(resp["taskId"])
+	assert.True(t, ok)
+	assert.Equal(t, "source://cdn/videos/a.mp4", tk.InputMedia) // Resolved at download time only
+
+	w = submit("source://other/a.mp4")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid_input_source")
+	assert.Equal(t, http.StatusBadRequest, submit("source://cdn/../a.mp4").Code)
+	assert.NotContains(t, w.Body.String(), "s3cr3t")
+}
+
+func TestSignedDownloadURL(t *testing.T) {
+	cfg := &config.Config{AuthEnable: true, AuthKey: "admin-secret", MaxConcurrency: 1, OutputLocalLifetime: time.Hour,
+		TempDir: t.TempDir(), UploadSigningKey: "signing-key", DownloadURLMaxTTL: 24 * time.Hour, BaseURL: "https://media.example.com"}
+	tm, _ := task.NewManager(cfg, &storingRunner{dir: cfg.TempDir})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tm.Start(ctx)
+	keys, _ := auth.NewStore(cfg)
+	router := SetupRouter(tm, keys, cfg)
+	do := func(method, path, body, key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/api/v2/tasks", `{"command": "-i ${INPUT_MEDIA}", "inputMedia": "test.mkv", "outputExt": "mp4"}`, "admin-secret")
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var accepted struct{ TaskID string }
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &accepted))
+	tk, _ := tm.Get(accepted.TaskID)
+	select {
+	case <-tk.Done():
+	case <-time.After(time.Second):
+		t.Fatal("task did not finish")
+	}
+
+	signURL := "/api/v2/tasks/" + tk.ID + "/download-url"
+	assert.Equal(t, http.StatusUnauthorized, do("POST", signURL, "", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do("POST", signURL, `{"expiresIn": "48h"}`, "admin-secret").Code)
+	assert.Equal(t, http.StatusNotFound, do("POST", signURL, `{"artifact": "nope"}`, "admin-secret").Code)
+
+	w = do("POST", signURL, "", "admin-secret")
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var signed SignedDownloadURL
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &signed))
+	assert.WithinDuration(t, time.Now().Add(time.Hour), signed.ExpiresAt, 2*time.Second)
+	u, err := url.Parse(signed.URL)
+	require.NoError(t, err)
+
+	// The URL works without an API key, unlike /files.
+	w = do("GET", u.Path, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2048, w.Body.Len())
+	assert.Contains(t, w.Header().Get("Cache-Control"), "max-age=")
+	filename := strings.SplitN(u.Path, "/", 7)[6]
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/api/v2/files/"+filename, "", "").Code)
+
+	// Tampered and expired URLs are refused.
+	assert.Equal(t, http.StatusForbidden, do("GET", strings.Replace(u.Path, "/downloads/", "/downloads/1", 1), "", "").Code)
+	past := time.Now().Add(-time.Minute)
+	expired := fmt.Sprintf("/api/v2/downloads/%d/%s/%s", past.Unix(), utils.SignRequest([]byte("signing-key"), http.MethodGet, "/files/"+filename, past), filename)
+	assert.Equal(t, http.StatusForbidden, do("GET", expired, "", "").Code)
+}
+
+func TestStandbyFailover(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	primaryCfg := &config.Config{MaxConcurrency: 1, DataDir: t.TempDir()}
+	primary, err := task.NewManager(primaryCfg, &mockRunner{})
+	require.NoError(t, err)
+	keys, _ := auth.NewStore(primaryCfg)
+	srv := httptest.NewServer(SetupRouter(primary, keys, primaryCfg))
+	defer srv.Close()
+
+	standbyCfg := &config.Config{MaxConcurrency: 1, DataDir: t.TempDir(), StandbyOf: srv.URL, StandbyFailoverAfter: 100 * time.Millisecond, RequeueInterrupted: true}
+	standby, err := task.NewManager(standbyCfg, &mockRunner{})
+	require.NoError(t, err)
+	standbyKeys, _ := auth.NewStore(standbyCfg)
+	router := SetupRouter(standby, standbyKeys, standbyCfg)
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The standby takes no submissions and is not ready.
+	assert.True(t, standby.IsStandby())
+	w := request("POST", "/api/v2/tasks", `{"command": "-i ${INPUT_MEDIA} -c:v libx264", "inputMedia": "https://example.com/a.mp4", "outputExt": "mp4"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `"standby"`)
+	assert.Equal(t, http.StatusServiceUnavailable, request("GET", "/readyz", "").Code)
+
+	// Tasks queued on the primary are copied as it saves them.
+	require.NoError(t, primary.PauseQueue(""))
+	queued, err := primary.Submit("-i ${INPUT_MEDIA}", "https://example.com/a.mp4", "mp4")
+	require.NoError(t, err)
+	require.NoError(t, primary.Shutdown(context.Background()))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tookOver := make(chan bool)
+	go func() { tookOver <- standby.Follow(ctx) }()
+	assert.Eventually(t, func() bool {
+		data, err := os.ReadFile(filepath.Join(standbyCfg.DataDir, "unfinished_tasks.json"))
+		return err == nil && strings.Contains(string(data), queued.ID)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Once the primary is gone, the standby restores its tasks and takes over.
+	srv.Listener.Close()
+	srv.CloseClientConnections()
+	select {
+	case ok := <-tookOver:
+		assert.True(t, ok)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the standby did not take over")
+	}
+	assert.False(t, standby.IsStandby())
+	restored, ok := standby.Get(queued.ID)
+	require.True(t, ok)
+	assert.Equal(t, task.StatusQueued, restored.Status)
+	assert.Equal(t, http.StatusOK, request("GET", "/readyz", "").Code)
+}
+
+func TestHandleCreateTask_IdempotencyKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{MaxConcurrency: 1, IdempotencyWindow: time.Hour}
+	tm, _ := task.NewManager(cfg, &mockRunner{})
+	keys, _ := auth.NewStore(cfg)
+	router := SetupRouter(tm, keys, cfg)
+	submit := func(key, outputExt string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"command":"-i ${INPUT_MEDIA} -c:v libx264","inputMedia":"test.mkv","outputExt":%q}`, outputExt)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/tasks", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+	taskID := func(w *httptest.ResponseRecorder) string {
+		var resp map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp["taskId"].(string)
+	}
+
+	w := submit("retry-1", "mp4")
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+	first := taskID(w)
+
+	w = submit("retry-1", "mp4")
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first, taskID(w))
+
+	w = submit("retry-1", "mkv")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "idempotency_key_reused")
+
+	w = submit("", "mp4")
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.NotEqual(t, first, taskID(w))
+
+	assert.Equal(t, http.StatusBadRequest, submit(strings.Repeat("k", 256), "mp4").Code)
+}
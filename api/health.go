@@ -0,0 +1,80 @@
+package api
+
+import (
+    "fmt"
+    "net/http"
+    "time"
+
+    "ffwebapi/ffmpeg"
+    "ffwebapi/task"
+    "github.com/gin-gonic/gin"
+)
+
+// HealthReport is the response of GET /health.
+type HealthReport struct {
+    Status        string                  `json:"status"`                // "ok", or "degraded" if the server is not ready
+    Problems      []string                `json:"problems,omitempty"`    // Why the server is not ready
+    UptimeSeconds float64                 `json:"uptimeSeconds"`
+    FFmpegVersion string                  `json:"ffmpegVersion,omitempty"`
+    QueueDepth    int                     `json:"queueDepth"`            // Tasks waiting in all queues
+    InFlight      int                     `json:"inFlight"`              // Tasks running in all queues
+    Queues        []task.QueueStatus      `json:"queues"`
+    Concurrency   *task.ConcurrencyStatus `json:"concurrency,omitempty"` // With ADAPTIVE_CONCURRENCY
+    Resources     ffmpeg.ResourceUsage    `json:"resources"`
+}
+
+// handleHealth reports the state of the queues and host resources. It
+// always answers 200; load balancers should probe /readyz instead.
+func (h *Handler) handleHealth(c *gin.Context) {
+    report := HealthReport{
+        Status:        "ok",
+        UptimeSeconds: time.Since(h.started).Seconds(),
+        FFmpegVersion: ffmpeg.Version(h.cfg),
+        Queues:        h.taskManager.QueueStatus(),
+        Concurrency:   h.taskManager.ConcurrencyStatus(),
+        Resources:     ffmpeg.SampleResources(h.cfg, 0),
+    }
+    for _, q := range report.Queues {
+        report.QueueDepth += q.Depth
+        report.InFlight += q.InFlight
+    }
+    report.Problems = h.readinessProblems(report.Queues, report.Resources)
+    if report.Problems != nil {
+        report.Status = "degraded"
+    }
+    c.JSON(http.StatusOK, report)
+}
+
+// handleLiveness answers as long as the server handles requests.
+func handleLiveness(c *gin.Context) {
+    c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// handleReadiness answers 503 while the server should get no new work: a
+// queue is full, TEMP_DIR is short of disk space or it is a standby.
+func (h *Handler) handleReadiness(c *gin.Context) {
+    problems := h.readinessProblems(h.taskManager.QueueStatus(), ffmpeg.SampleResources(h.cfg, 0))
+    if problems != nil {
+        c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "problems": problems})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readinessProblems lists why the server cannot take new tasks. CPU and
+// memory pressure only delay tasks, so they don't count.
+func (h *Handler) readinessProblems(queues []task.QueueStatus, resources ffmpeg.ResourceUsage) []string {
+    var problems []string
+    for _, q := range queues {
+        if q.Capacity > 0 && q.Depth >= q.Capacity {
+            problems = append(problems, fmt.Sprintf("queue %q is full (%d tasks waiting)", q.Name, q.Depth))
+        }
+    }
+    if h.taskManager.IsStandby() {
+        problems = append(problems, "standby of "+h.cfg.StandbyOf+", which has not failed")
+    }
+    if err := resources.CheckDisk(h.cfg); err != nil {
+        problems = append(problems, err.Error())
+    }
+    return problems
+}
@@ -0,0 +1,355 @@
+package api
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "path"
+    "sort"
+    "strings"
+    "time"
+
+    "ffwebapi/ffmpeg"
+    "ffwebapi/netguard"
+    "ffwebapi/preset"
+    "ffwebapi/task"
+    "ffwebapi/tracing"
+    "github.com/gin-gonic/gin"
+    "github.com/lithammer/shortuuid/v4"
+)
+
+// Manifest formats accepted by the import endpoint.
+const (
+    manifestCSV   = "csv"
+    manifestJSONL = "jsonl"
+)
+
+// ImportRequest references a remote manifest. Manifests can also be uploaded
+// directly as text/csv or application/x-ndjson, with the options below given
+// as query parameters.
+type ImportRequest struct {
+    ManifestURL  string `json:"manifestUrl" form:"manifestUrl"`
+    Format       string `json:"format" form:"format"` // "csv" or "jsonl"; derived from the URL or Content-Type if empty
+    Priority     string `json:"priority" form:"priority"`
+    Queue        string `json:"queue" form:"queue"`
+    MaxRetries   int    `json:"maxRetries" form:"maxRetries"`
+    RetryBackoff string `json:"retryBackoff" form:"retryBackoff"`
+    CallbackURL  string `json:"callbackUrl" form:"callbackUrl"`
+    Billing      bool   `json:"billing" form:"billing"` // Record a billing line item for every task
+}
+
+// manifestRow is one task of a manifest.
+type manifestRow struct {
+    Line       int    `json:"-"`
+    Input      string `json:"input"`
+    Preset     string `json:"preset"`
+    OutputName string `json:"outputName"`
+    invalid    error
+}
+
+// ImportRowResult reports whether one manifest row was enqueued.
+type ImportRowResult struct {
+    Line   int    `json:"line"` // Line of the row in the manifest
+    Input  string `json:"input,omitempty"`
+    TaskID string `json:"taskId,omitempty"`
+    Error  string `json:"error,omitempty"`
+}
+
+// ImportReport is the response of a manifest import.
+type ImportReport struct {
+    BatchID  string            `json:"batchId"`
+    TraceID  string            `json:"traceId,omitempty"` // Shared by the tasks of the batch
+    Total    int               `json:"total"`
+    Accepted int               `json:"accepted"`
+    Rejected int               `json:"rejected"`
+    Rows     []ImportRowResult `json:"rows"`
+}
+
+// handleImportJobs enqueues every valid row of a CSV or JSONL manifest as a
+// task of one batch. Invalid rows are rejected individually and listed in the
+// report; the batch's tasks can be listed via GET /tasks?batchId=...
+func (h *Handler) handleImportJobs(c *gin.Context) {
+    var req ImportRequest
+    var manifest io.Reader
+    contentType := c.ContentType()
+    if contentType == "application/json" {
+        if err := c.ShouldBindJSON(&req); err != nil {
+            respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+            return
+        }
+        if req.ManifestURL == "" {
+            respondError(c, http.StatusBadRequest, "invalid_request", "manifestUrl is required")
+            return
+        }
+    } else {
+        if err := c.ShouldBindQuery(&req); err != nil {
+            respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+            return
+        }
+        body, err := h.readManifest(c.Request.Body)
+        if err != nil {
+            respondError(c, http.StatusBadRequest, "invalid_manifest", err.Error())
+            return
+        }
+        manifest = body
+    }
+
+    // Options apply to every task of the batch.
+    var opts task.SubmitOptions
+    optsReq := TaskRequest{
+        Priority:     req.Priority,
+        Queue:        req.Queue,
+        MaxRetries:   req.MaxRetries,
+        RetryBackoff: req.RetryBackoff,
+        CallbackURL:  req.CallbackURL,
+        Billing:      req.Billing,
+    }
+    if !h.validateSubmitOptions(c, &optsReq, &opts) {
+        return
+    }
+
+    format := req.Format
+    if manifest == nil {
+        if err := netguard.InputPolicy(h.cfg).CheckURL(req.ManifestURL); err != nil {
+            respondError(c, http.StatusBadRequest, "input_egress_denied", err.Error())
+            return
+        }
+        body, err := h.fetchManifest(c, req.ManifestURL)
+        if err != nil {
+            respondError(c, http.StatusBadRequest, "invalid_manifest", err.Error())
+            return
+        }
+        manifest = body
+        if format == "" {
+            format = manifestFormatOf(path.Ext(strings.SplitN(req.ManifestURL, "?", 2)[0]))
+        }
+    } else if format == "" {
+        format = manifestFormatOf(contentType)
+    }
+
+    rows, err := parseManifest(manifest, format, h.cfg.MaxImportRows)
+    if err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_manifest", err.Error())
+        return
+    }
+
+    report := ImportReport{
+        BatchID: fmt.Sprintf("batch_%s_%d", shortuuid.New(), time.Now().Unix()),
+        Total:   len(rows),
+        Rows:    make([]ImportRowResult, 0, len(rows)),
+        TraceID: tracing.TraceID(opts.TraceParent),
+    }
+    _, scoped := tenantOf(c)
+    for _, row := range rows {
+        result := ImportRowResult{Line: row.Line, Input: row.Input}
+        if t, err := h.submitManifestRow(row, report.BatchID, opts, scoped); err != nil {
+            result.Error = err.Error()
+            report.Rejected++
+        } else {
+            result.TaskID = t.ID
+            report.Accepted++
+        }
+        report.Rows = append(report.Rows, result)
+    }
+
+    status := http.StatusAccepted
+    if report.Accepted == 0 {
+        status = http.StatusUnprocessableEntity
+    }
+    c.JSON(status, report)
+}
+
+// submitManifestRow validates one row and enqueues it. Local inputs of
+// scoped callers must lie below LOCAL_INPUT_DIRS.
+func (h *Handler) submitManifestRow(row manifestRow, batchID string, opts task.SubmitOptions, scoped bool) (*task.Task, error) {
+    if row.invalid != nil {
+        return nil, row.invalid
+    }
+    if row.Input == "" {
+        return nil, fmt.Errorf("input is required")
+    }
+    if netguard.IsSourceRef(row.Input) {
+        if _, err := netguard.ResolveSource(h.cfg, row.Input); err != nil {
+            return nil, err
+        }
+    } else if ok, err := ffmpeg.CheckRegisteredInput(row.Input); ok {
+        if err != nil {
+            return nil, err
+        }
+    } else if netguard.IsURL(row.Input) {
+        if err := netguard.InputPolicy(h.cfg).CheckURL(row.Input); err != nil {
+            return nil, err
+        }
+    } else if scoped && ffmpeg.InputScheme(row.Input) == "" {
+        if err := ffmpeg.CheckLocalInput(h.cfg, row.Input); err != nil {
+            return nil, err
+        }
+    }
+    p, ok := preset.Lookup(row.Preset)
+    if !ok {
+        return nil, fmt.Errorf("unknown preset %q", row.Preset)
+    }
+    if row.OutputName != "" {
+        name, err := renderOutputName(row.OutputName, newOutputNameData(row.Input, p.OutputExt, p.Name), p.OutputExt)
+        if err != nil {
+            return nil, err
+        }
+        opts.OutputName = name
+    }
+    opts.BatchID = batchID
+    opts.Preset = p.Name
+    return h.taskManager.SubmitWithOptions(p.Command, row.Input, p.OutputExt, opts)
+}
+
+// cleanOutputName checks a client-chosen download file name and adds the
+// preset's extension if it has none.
+func cleanOutputName(name, ext string) (string, error) {
+    if len(name) > maxOutputNameLen || strings.ContainsAny(name, "/\\\x00\r\n\"") || name == "." || name == ".." {
+        return "", fmt.Errorf("invalid output name %q", name)
+    }
+    if path.Ext(name) == "" {
+        name += "." + ext
+    }
+    return name, nil
+}
+
+func (h *Handler) fetchManifest(c *gin.Context, manifestURL string) (io.Reader, error) {
+    req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, manifestURL, nil)
+    if err != nil {
+        return nil, err
+    }
+    client := netguard.InputPolicy(h.cfg).Client(0)
+    defer client.CloseIdleConnections()
+    resp, err := client.Do(req)
+    if err != nil {
+        return nil, fmt.Errorf("failed to download manifest: %w", err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("failed to download manifest, status: %s", resp.Status)
+    }
+    return h.readManifest(resp.Body)
+}
+
+// readManifest buffers a manifest, enforcing MAX_INPUT_SIZE.
+func (h *Handler) readManifest(r io.Reader) (io.Reader, error) {
+    body, err := io.ReadAll(io.LimitReader(r, h.cfg.MaxInputSize+1))
+    if err != nil {
+        return nil, fmt.Errorf("failed to read manifest: %w", err)
+    }
+    if int64(len(body)) > h.cfg.MaxInputSize {
+        return nil, fmt.Errorf("manifest size exceeds limit of %d bytes", h.cfg.MaxInputSize)
+    }
+    return bytes.NewReader(body), nil
+}
+
+// manifestFormatOf maps a file extension or content type to a manifest format.
+func manifestFormatOf(s string) string {
+    switch strings.ToLower(s) {
+    case ".csv", "text/csv":
+        return manifestCSV
+    case ".jsonl", ".ndjson", "application/x-ndjson", "application/jsonl":
+        return manifestJSONL
+    }
+    return ""
+}
+
+// parseManifest reads the rows of a manifest. CSV manifests need a header
+// naming the columns input, preset and (optionally) outputName.
+func parseManifest(r io.Reader, format string, maxRows int) ([]manifestRow, error) {
+    var rows []manifestRow
+    tooMany := fmt.Errorf("manifest has more than %d rows", maxRows)
+
+    switch format {
+    case manifestCSV:
+        cr := csv.NewReader(r)
+        cr.FieldsPerRecord = -1
+        header, err := cr.Read()
+        if err != nil {
+            return nil, fmt.Errorf("could not read CSV header: %w", err)
+        }
+        cols := map[string]int{}
+        for i, name := range header {
+            key := strings.ToLower(strings.TrimSpace(strings.ReplaceAll(name, "_", "")))
+            cols[key] = i
+        }
+        if _, ok := cols["input"]; !ok {
+            return nil, fmt.Errorf("CSV header must name an input column")
+        }
+        field := func(record []string, name string) string {
+            if i, ok := cols[name]; ok && i < len(record) {
+                return strings.TrimSpace(record[i])
+            }
+            return ""
+        }
+        for {
+            record, err := cr.Read()
+            if err == io.EOF {
+                break
+            }
+            if err != nil {
+                return nil, fmt.Errorf("invalid CSV: %w", err)
+            }
+            if maxRows > 0 && len(rows) == maxRows {
+                return nil, tooMany
+            }
+            line, _ := cr.FieldPos(0)
+            rows = append(rows, manifestRow{
+                Line:       line,
+                Input:      field(record, "input"),
+                Preset:     field(record, "preset"),
+                OutputName: field(record, "outputname"),
+            })
+        }
+    case manifestJSONL:
+        scanner := bufio.NewScanner(r)
+        scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+        for line := 1; scanner.Scan(); line++ {
+            text := strings.TrimSpace(scanner.Text())
+            if text == "" {
+                continue
+            }
+            if maxRows > 0 && len(rows) == maxRows {
+                return nil, tooMany
+            }
+            row := manifestRow{Line: line}
+            if err := json.Unmarshal([]byte(text), &row); err != nil {
+                // Keep the row so it shows up as rejected in the report.
+                row = manifestRow{Line: line, invalid: fmt.Errorf("invalid JSON: %v", err)}
+            }
+            rows = append(rows, row)
+        }
+        if err := scanner.Err(); err != nil {
+            return nil, fmt.Errorf("invalid JSONL: %w", err)
+        }
+    default:
+        return nil, fmt.Errorf("unknown manifest format %q (want %q or %q)", format, manifestCSV, manifestJSONL)
+    }
+
+    if len(rows) == 0 {
+        return nil, fmt.Errorf("manifest has no rows")
+    }
+    return rows, nil
+}
+
+// handleCancelBatch cancels every non-terminal task of a batch the caller can
+// see.
+func (h *Handler) handleCancelBatch(c *gin.Context) {
+    batchID := c.Param("batchId")
+    var members []*task.Task
+    for _, t := range h.taskManager.List() {
+        if t.BatchID == batchID && canSee(c, t.Owner) {
+            members = append(members, t)
+        }
+    }
+    if len(members) == 0 {
+        respondError(c, http.StatusNotFound, "not_found", "Batch not found")
+        return
+    }
+    sort.Slice(members, func(i, j int) bool { return members[i].CreatedAt.Before(members[j].CreatedAt) })
+    h.cancelGroup(c, members)
+}
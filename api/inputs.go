@@ -0,0 +1,131 @@
+package api
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "time"
+
+    "ffwebapi/task"
+    "ffwebapi/utils"
+    "github.com/gin-gonic/gin"
+)
+
+type InputRequest struct {
+    Size        int64  `json:"size"`        // Bytes; MAX_INPUT_SIZE if omitted
+    ContentType string `json:"contentType"` // Informational, e.g. "video/mp4"
+}
+
+// InputReservation tells the client where to upload a reserved input.
+type InputReservation struct {
+    *task.Input
+    UploadURL    string `json:"uploadUrl"`
+    UploadMethod string `json:"uploadMethod"`
+}
+
+// handleCreateInput reserves an input slot and returns a signed URL to PUT
+// the file to, either this API (local storage) or the S3 bucket directly.
+// Tasks then reference the input with "inputId" (or "inputMedia":
+// "input://<id>") once the upload is done.
+func (h *Handler) handleCreateInput(c *gin.Context) {
+    var req InputRequest
+    if c.Request.ContentLength != 0 {
+        if err := c.ShouldBindJSON(&req); err != nil {
+            respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+            return
+        }
+    }
+
+    in, uploadURL, err := h.taskManager.ReserveInput(clientOf(c), req.Size, req.ContentType)
+    if errors.Is(err, task.ErrQuotaExceeded) {
+        respondError(c, http.StatusTooManyRequests, "quota_exceeded", err.Error())
+        return
+    }
+    if err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+    if uploadURL == "" {
+        path := inputContentPath(in.ID)
+        signature := utils.SignRequest(h.signingKey, http.MethodPut, path, in.ExpiresAt)
+        uploadURL = fmt.Sprintf("%s%s%s?expires=%d&signature=%s", h.baseURL(c), versionOf(c).basePath(), path, in.ExpiresAt.Unix(), signature)
+    }
+    c.JSON(http.StatusCreated, InputReservation{Input: in, UploadURL: uploadURL, UploadMethod: http.MethodPut})
+}
+
+// inputContentPath is the path of an input's upload endpoint below the
+// version prefix; it is what upload URLs sign.
+func inputContentPath(id string) string {
+    return "/inputs/" + id + "/content"
+}
+
+// handleUploadInput receives the upload of a reserved input. It is
+// authorized by the URL's signature rather than the API key, so browsers can
+// upload directly.
+func (h *Handler) handleUploadInput(c *gin.Context) {
+    id := c.Param("inputId")
+    if err := utils.VerifyRequest(h.signingKey, http.MethodPut, inputContentPath(id), c.Query("expires"), c.Query("signature"), time.Now()); err != nil {
+        respondError(c, http.StatusForbidden, "invalid_signature", err.Error())
+        return
+    }
+    if in, ok := h.taskManager.GetInput(id); ok && c.Request.ContentLength > in.MaxSize {
+        respondError(c, http.StatusRequestEntityTooLarge, "input_too_large", "Content-Length "+strconv.FormatInt(c.Request.ContentLength, 10)+" exceeds the reserved size")
+        return
+    }
+
+    in, err := h.taskManager.UploadInput(c.Request.Context(), id, c.Request.Body)
+    if err != nil {
+        respondInputError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, in)
+}
+
+// handleGetInput reports whether an input has been uploaded.
+func (h *Handler) handleGetInput(c *gin.Context) {
+    in, ok := h.taskManager.GetInput(c.Param("inputId"))
+    if !ok || !h.ownsInput(c, in) {
+        respondError(c, http.StatusNotFound, "not_found", "Input not found")
+        return
+    }
+    c.JSON(http.StatusOK, in)
+}
+
+// resolveInput checks that a task's uploaded input is ready. On failure it
+// writes an error response and returns false.
+func (h *Handler) resolveInput(c *gin.Context, id string) bool {
+    if in, ok := h.taskManager.GetInput(id); ok && !h.ownsInput(c, in) {
+        respondInputError(c, task.ErrInputNotFound)
+        return false
+    }
+    if _, err := h.taskManager.ResolveInput(c.Request.Context(), id); err != nil {
+        respondInputError(c, err)
+        return false
+    }
+    return true
+}
+
+// ownsInput tells whether the request may see and use an uploaded input.
+// Other tenants' uploads are reported as missing.
+func (h *Handler) ownsInput(c *gin.Context, in *task.Input) bool {
+    _, scoped := tenantOf(c)
+    return !scoped || in.Owner == clientOf(c)
+}
+
+func respondInputError(c *gin.Context, err error) {
+    switch {
+    case errors.Is(err, task.ErrInputNotFound):
+        respondError(c, http.StatusNotFound, "input_not_found", err.Error())
+    case errors.Is(err, task.ErrInputNotUploaded):
+        respondError(c, http.StatusConflict, "input_not_uploaded", err.Error())
+    case errors.Is(err, task.ErrInputConflict):
+        respondError(c, http.StatusConflict, "input_already_uploaded", err.Error())
+    case errors.Is(err, task.ErrInputExpired):
+        respondError(c, http.StatusGone, "upload_expired", err.Error())
+    case errors.Is(err, task.ErrInputTooLarge):
+        respondError(c, http.StatusRequestEntityTooLarge, "input_too_large", err.Error())
+    default:
+        respondErrorDetails(c, http.StatusInternalServerError, "internal_error", "Input storage failed", err.Error())
+    }
+}
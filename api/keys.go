@@ -0,0 +1,71 @@
+package api
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "time"
+
+    "ffwebapi/auth"
+    "github.com/gin-gonic/gin"
+)
+
+type KeyRequest struct {
+    Name      string      `json:"name"`
+    Scopes    []string    `json:"scopes" binding:"required,min=1"` // submit, read, cancel, download, admin
+    Queue     string      `json:"queue"`                           // Pins the key's tasks to a named queue
+    Quota     *auth.Quota `json:"quota"`                           // Overrides the QUOTA_* defaults
+    RateLimit *auth.RateLimit `json:"rateLimit"`                   // Overrides the RATE_LIMIT_* defaults, e.g. to allow bursts
+    ExpiresAt time.Time   `json:"expiresAt"`                       // RFC 3339; the key does not expire if omitted
+}
+
+// CreatedKey is the response of key creation, the only time the secret is shown.
+type CreatedKey struct {
+    *auth.Key
+    Secret string `json:"secret"`
+}
+
+// handleCreateKey creates an API key with the requested scopes.
+func (h *Handler) handleCreateKey(c *gin.Context) {
+    var req KeyRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+    if req.Queue != "" && !h.hasQueue(req.Queue) {
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Unknown queue %q", req.Queue))
+        return
+    }
+    key, secret, err := h.keys.Create(auth.Key{
+        Name:      req.Name,
+        Scopes:    req.Scopes,
+        Queue:     req.Queue,
+        Quota:     req.Quota,
+        RateLimit: req.RateLimit,
+        ExpiresAt: req.ExpiresAt,
+    })
+    if err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+    c.JSON(http.StatusCreated, CreatedKey{Key: key, Secret: secret})
+}
+
+// handleListKeys lists the API keys, without their secrets.
+func (h *Handler) handleListKeys(c *gin.Context) {
+    c.JSON(http.StatusOK, gin.H{"keys": h.keys.List()})
+}
+
+// handleRevokeKey revokes an API key.
+func (h *Handler) handleRevokeKey(c *gin.Context) {
+    err := h.keys.Revoke(c.Param("keyId"))
+    if errors.Is(err, auth.ErrKeyNotFound) {
+        respondError(c, http.StatusNotFound, "not_found", "API key not found")
+        return
+    }
+    if err != nil {
+        respondErrorDetails(c, http.StatusInternalServerError, "internal_error", "Failed to revoke API key", err.Error())
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
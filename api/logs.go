@@ -0,0 +1,79 @@
+package api
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+
+    "ffwebapi/task"
+    "github.com/gin-gonic/gin"
+)
+
+const (
+    defaultLogPageSize = 1000  // Lines of a log page without "limit"
+    maxLogPageSize     = 10000 // Cap of "limit" and "tail"
+)
+
+// handleGetTaskLogs returns the log of a task's last finished attempt, read
+// from disk: the last "tail" lines, or "limit" lines from line "offset".
+// "stage=2" reads the log of the second stage of a piped task.
+func (h *Handler) handleGetTaskLogs(c *gin.Context) {
+    t, found := h.findTask(c)
+    if !found {
+        return
+    }
+    if c.Query("tail") != "" && c.Query("offset") != "" {
+        respondError(c, http.StatusBadRequest, "invalid_request", "tail and offset are mutually exclusive")
+        return
+    }
+
+    stage := 1
+    switch c.Query("stage") {
+    case "", "1":
+    case "2":
+        if t.Pipe == nil {
+            respondError(c, http.StatusBadRequest, "invalid_request", "stage 2 only exists for piped tasks")
+            return
+        }
+        stage = 2
+    default:
+        respondError(c, http.StatusBadRequest, "invalid_request", "stage must be 1 or 2")
+        return
+    }
+
+    var page *task.LogPage
+    var err error
+    if s := c.Query("tail"); s != "" {
+        n, convErr := strconv.Atoi(s)
+        if convErr != nil || n < 1 || n > maxLogPageSize {
+            respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("tail must be between 1 and %d", maxLogPageSize))
+            return
+        }
+        page, err = h.taskManager.TailLog(t, stage, n)
+    } else {
+        offset, convErr := strconv.Atoi(c.DefaultQuery("offset", "0"))
+        if convErr != nil || offset < 0 {
+            respondError(c, http.StatusBadRequest, "invalid_request", "offset must be a non-negative line number")
+            return
+        }
+        limit, convErr := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultLogPageSize)))
+        if convErr != nil || limit < 1 || limit > maxLogPageSize {
+            respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("limit must be between 1 and %d", maxLogPageSize))
+            return
+        }
+        page, err = h.taskManager.ReadLog(t, stage, offset, limit)
+    }
+
+    switch {
+    case errors.Is(err, task.ErrLogNotFound):
+        if !t.Snapshot().Status.IsTerminal() {
+            h.setPollHints(c)
+        }
+        respondError(c, http.StatusNotFound, "log_not_found", "No log for this task yet, or it has expired")
+    case err != nil:
+        respondErrorDetails(c, http.StatusInternalServerError, "internal_error", "Could not read the task log", err.Error())
+    default:
+        c.JSON(http.StatusOK, page)
+    }
+}
@@ -1,14 +1,35 @@
 package api
 
 import (
+    "errors"
+    "fmt"
+    "log/slog"
+    "math"
     "net/http"
+    "regexp"
+    "strconv"
     "strings"
+    "sync"
+    "time"
 
+    "ffwebapi/auth"
     "ffwebapi/config"
+    "ffwebapi/logging"
+    "ffwebapi/tracing"
     "github.com/gin-gonic/gin"
+    "github.com/lithammer/shortuuid/v4"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/propagation"
+    "go.opentelemetry.io/otel/trace"
 )
 
-func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
+// apiKeyKey is the context key of the authenticated *auth.Key.
+const apiKeyKey = "apiKey"
+
+// AuthMiddleware authenticates the request's bearer token against the key
+// store and records the key for RequireScope.
+func AuthMiddleware(cfg *config.Config, keys *auth.Store) gin.HandlerFunc {
     return func(c *gin.Context) {
         if !cfg.AuthEnable {
             c.Next()
@@ -17,21 +38,333 @@ func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
 
         authHeader := c.GetHeader("Authorization")
         if authHeader == "" {
-            c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+            respondError(c, http.StatusUnauthorized, "unauthorized", "Authorization header required")
             return
         }
 
         parts := strings.Split(authHeader, " ")
         if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-            c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid Authorization header format"})
+            respondError(c, http.StatusUnauthorized, "unauthorized", "Invalid Authorization header format")
             return
         }
 
-        if parts[1] != cfg.AuthKey {
-            c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+        key, err := keys.Authenticate(parts[1], time.Now())
+        if errors.Is(err, auth.ErrKeyExpired) {
+            respondError(c, http.StatusUnauthorized, "unauthorized", "API key expired")
+            return
+        }
+        if err != nil {
+            respondError(c, http.StatusUnauthorized, "unauthorized", "Invalid token")
+            return
+        }
+        c.Set(apiKeyKey, key)
+
+        c.Next()
+    }
+}
+
+// RequireScope rejects requests whose API key lacks scope. It runs after
+// AuthMiddleware and lets everything through when authentication is off.
+func RequireScope(cfg *config.Config, scope string) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if !cfg.AuthEnable {
+            c.Next()
             return
         }
+        key, ok := c.Get(apiKeyKey)
+        if !ok || !key.(*auth.Key).HasScope(scope) {
+            respondError(c, http.StatusForbidden, "forbidden", fmt.Sprintf("API key lacks the %q scope", scope))
+            return
+        }
+        c.Next()
+    }
+}
+
+// TracingMiddleware opens a server span per request, continuing the caller's
+// trace if it sent a traceparent header. Tasks submitted by the request
+// become children of this span.
+func TracingMiddleware() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        ctx := tracing.Propagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+        route := c.FullPath()
+        if route == "" {
+            route = "unmatched"
+        }
+        ctx, span := tracing.Tracer().Start(ctx, c.Request.Method+" "+route,
+            trace.WithSpanKind(trace.SpanKindServer),
+            trace.WithAttributes(attribute.String("http.method", c.Request.Method), attribute.String("http.route", route)))
+        defer span.End()
+        c.Request = c.Request.WithContext(ctx)
+
+        c.Next()
+
+        status := c.Writer.Status()
+        span.SetAttributes(attribute.Int("http.status_code", status))
+        if status >= http.StatusInternalServerError {
+            span.SetStatus(codes.Error, http.StatusText(status))
+        }
+    }
+}
+
+// requestIDHeader carries the correlation ID of a request, chosen by the
+// caller or generated here.
+const requestIDHeader = "X-Request-ID"
+
+var requestIDRe = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestIDMiddleware assigns every request an ID, echoes it in the response,
+// puts a logger tagged with it into the request context, and writes one
+// structured access log line per request.
+func RequestIDMiddleware() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        requestID := c.GetHeader(requestIDHeader)
+        if !requestIDRe.MatchString(requestID) {
+            requestID = shortuuid.New()
+        }
+        c.Set("requestId", requestID)
+        c.Header(requestIDHeader, requestID)
+        logger := slog.Default().With("request_id", requestID)
+        c.Request = c.Request.WithContext(logging.WithLogger(c.Request.Context(), logger))
 
+        start := time.Now()
         c.Next()
+
+        level := slog.LevelInfo
+        if c.Writer.Status() >= http.StatusInternalServerError {
+            level = slog.LevelError
+        }
+        logger.Log(c.Request.Context(), level, "Request",
+            "method", c.Request.Method,
+            "path", c.Request.URL.Path,
+            "status", c.Writer.Status(),
+            "duration", time.Since(start),
+            "client_ip", c.ClientIP(),
+        )
+    }
+}
+
+// clientOf identifies the client a request is accounted to, for rate limits
+// and upload quotas: the API key when authentication is on, the client
+// address otherwise.
+func clientOf(c *gin.Context) string {
+    if key, ok := c.Get(apiKeyKey); ok {
+        return "key:" + key.(*auth.Key).ID
+    }
+    return "ip:" + c.ClientIP()
+}
+
+// tenantOf returns the API key whose tasks, files and inputs a request is
+// limited to, and false if it may see everyone's: when authentication is off
+// and for admin keys.
+func tenantOf(c *gin.Context) (*auth.Key, bool) {
+    v, ok := c.Get(apiKeyKey)
+    if !ok || v.(*auth.Key).HasScope(auth.ScopeAdmin) {
+        return nil, false
+    }
+    return v.(*auth.Key), true
+}
+
+// canSee tells whether the request may see a task submitted by owner.
+func canSee(c *gin.Context, owner string) bool {
+    key, scoped := tenantOf(c)
+    return !scoped || key.ID == owner
+}
+
+// rateLimit is a sustained rate and the burst a client may send above it.
+type rateLimit struct {
+    rate  int // Tokens per period; 0 = unlimited
+    burst int // Bucket size; the rate if 0
+}
+
+// rateLimiter is a token bucket per client: each holds up to burst tokens and
+// refills at rate per period, so a client may send a burst after being idle
+// and then continue at the sustained rate.
+type rateLimiter struct {
+    header    string // Prefix of the headers reporting the bucket, e.g. "X-RateLimit"
+    period    time.Duration
+    limit     rateLimit                       // Server default
+    keyLimit  func(*auth.RateLimit) rateLimit // Per-key overrides; zero fields keep the default
+    mu        sync.Mutex
+    buckets   map[string]*tokenBucket
+    lastSweep time.Time
+}
+
+type tokenBucket struct {
+    tokens float64
+    size   float64
+    rate   float64 // Tokens per second
+    last   time.Time
+}
+
+// bucketState is what a client is told about its bucket after a request.
+type bucketState struct {
+    remaining  int
+    reset      time.Duration // Until the bucket is full again
+    retryAfter time.Duration // Until the next token if the request was refused
+}
+
+// newRateLimiter returns a limiter allowing limit per period by default.
+// keyLimit picks a key's overrides from its RateLimit.
+func newRateLimiter(header string, period time.Duration, limit rateLimit, keyLimit func(*auth.RateLimit) rateLimit) *rateLimiter {
+    return &rateLimiter{header: header, period: period, limit: limit, keyLimit: keyLimit, buckets: make(map[string]*tokenBucket)}
+}
+
+// limitOf returns the limit applying to a request: the server's default with
+// the overrides of the request's API key.
+func (l *rateLimiter) limitOf(c *gin.Context) rateLimit {
+    limit := l.limit
+    if v, ok := c.Get(apiKeyKey); ok && v.(*auth.Key).RateLimit != nil && l.keyLimit != nil {
+        own := l.keyLimit(v.(*auth.Key).RateLimit)
+        if own.rate > 0 {
+            limit.rate = own.rate
+        }
+        if own.burst > 0 {
+            limit.burst = own.burst
+        }
+    }
+    if limit.burst < limit.rate {
+        limit.burst = limit.rate
+    }
+    return limit
+}
+
+// allow takes a token from the client's bucket. If the bucket is empty it
+// returns false; the state tells how long until the next token.
+func (l *rateLimiter) allow(client string, limit rateLimit, now time.Time) (bool, bucketState) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    if now.Sub(l.lastSweep) > l.period {
+        // Buckets that refilled completely are indistinguishable from new ones.
+        for key, b := range l.buckets {
+            if b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.size {
+                delete(l.buckets, key)
+            }
+        }
+        l.lastSweep = now
+    }
+
+    size, rate := math.Max(float64(limit.burst), float64(limit.rate)), float64(limit.rate)/l.period.Seconds()
+    b, ok := l.buckets[client]
+    if !ok {
+        b = &tokenBucket{tokens: size, last: now}
+        l.buckets[client] = b
+    }
+    b.size, b.rate = size, rate // The key's limits may have changed
+    b.tokens = math.Min(b.size, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+    b.last = now
+
+    allowed := b.tokens >= 1
+    var state bucketState
+    if allowed {
+        b.tokens--
+    } else {
+        state.retryAfter = secondsOf((1 - b.tokens) / b.rate)
+    }
+    state.remaining = int(b.tokens)
+    state.reset = secondsOf((b.size - b.tokens) / b.rate)
+    return allowed, state
+}
+
+func secondsOf(s float64) time.Duration {
+    return time.Duration(s * float64(time.Second))
+}
+
+// RateLimitMiddleware rejects requests beyond the client's budget with 429
+// and a Retry-After header. Every limited response reports the bucket in
+// <header>-Limit (tokens per period), -Burst, -Remaining and -Reset (seconds
+// until the bucket is full).
+func RateLimitMiddleware(l *rateLimiter) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        limit := l.limitOf(c)
+        if limit.rate <= 0 {
+            c.Next()
+            return
+        }
+        ok, state := l.allow(clientOf(c), limit, time.Now())
+        c.Header(l.header+"-Limit", strconv.Itoa(limit.rate))
+        c.Header(l.header+"-Burst", strconv.Itoa(limit.burst))
+        c.Header(l.header+"-Remaining", strconv.Itoa(state.remaining))
+        c.Header(l.header+"-Reset", strconv.Itoa(int(math.Ceil(state.reset.Seconds()))))
+        if !ok {
+            c.Header("Retry-After", strconv.Itoa(int(math.Ceil(state.retryAfter.Seconds()))))
+            respondError(c, http.StatusTooManyRequests, "rate_limited", "Rate limit exceeded, retry later")
+            return
+        }
+        c.Next()
+    }
+}
+
+// CORSMiddleware lets browser frontends on CORS_ALLOWED_ORIGINS call the API
+// directly. It answers preflight requests itself, before authentication, as
+// browsers send them without credentials. Without allowed origins, responses
+// carry no CORS headers and browsers keep blocking cross-origin calls.
+func CORSMiddleware(cfg *config.Config) gin.HandlerFunc {
+    allowMethods := strings.Join(cfg.CORSAllowedMethods, ", ")
+    allowHeaders := strings.Join(cfg.CORSAllowedHeaders, ", ")
+    exposeHeaders := strings.Join(cfg.CORSExposedHeaders, ", ")
+    return func(c *gin.Context) {
+        origin := c.GetHeader("Origin")
+        if origin == "" || len(cfg.CORSAllowedOrigins) == 0 {
+            c.Next()
+            return
+        }
+        c.Writer.Header().Add("Vary", "Origin")
+        preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+        allowed, anyOrigin := corsAllows(cfg.CORSAllowedOrigins, origin)
+        if !allowed {
+            if preflight {
+                respondError(c, http.StatusForbidden, "origin_not_allowed", fmt.Sprintf("Origin %q is not allowed", origin))
+                return
+            }
+            c.Next()
+            return
+        }
+
+        // The config never allows credentials together with "*".
+        if anyOrigin {
+            c.Header("Access-Control-Allow-Origin", "*")
+        } else {
+            c.Header("Access-Control-Allow-Origin", origin)
+        }
+        if cfg.CORSAllowCredentials {
+            c.Header("Access-Control-Allow-Credentials", "true")
+        }
+        if preflight {
+            c.Header("Access-Control-Allow-Methods", allowMethods)
+            c.Header("Access-Control-Allow-Headers", allowHeaders)
+            if cfg.CORSMaxAge > 0 {
+                c.Header("Access-Control-Max-Age", strconv.Itoa(int(cfg.CORSMaxAge.Seconds())))
+            }
+            c.AbortWithStatus(http.StatusNoContent)
+            return
+        }
+        if exposeHeaders != "" {
+            c.Header("Access-Control-Expose-Headers", exposeHeaders)
+        }
+        c.Next()
+    }
+}
+
+// corsAllows matches an origin against CORS_ALLOWED_ORIGINS entries: "*",
+// exact origins, and subdomain wildcards such as "https://*.example.com".
+// anyOrigin reports a match by "*".
+func corsAllows(allowed []string, origin string) (ok, anyOrigin bool) {
+    scheme, host, found := strings.Cut(strings.ToLower(origin), "://")
+    if !found {
+        return false, false
+    }
+    for _, entry := range allowed {
+        entry = strings.ToLower(strings.TrimSuffix(entry, "/"))
+        if entry == "*" {
+            return true, true
+        }
+        if entry == scheme+"://"+host {
+            return true, false
+        }
+        if entryScheme, suffix, ok := strings.Cut(entry, "://*."); ok && entryScheme == scheme && strings.HasSuffix(host, "."+suffix) {
+            return true, false
+        }
     }
+    return false, false
 }
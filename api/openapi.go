@@ -0,0 +1,442 @@
+package api
+
+import (
+    "embed"
+    "net/http"
+    "reflect"
+    "strconv"
+    "strings"
+    "time"
+
+    "ffwebapi/auth"
+    "ffwebapi/config"
+    "ffwebapi/preset"
+    "ffwebapi/task"
+    "github.com/gin-gonic/gin"
+)
+
+// docsFiles is the browsable API reference: a single page rendering
+// /openapi.json, with its assets embedded rather than loaded from a CDN.
+//
+//go:embed docs
+var docsFiles embed.FS
+
+// openAPIOperation documents one route of registerRoutes. Request and response
+// schemas are derived from the Go types the handlers bind and return.
+type openAPIOperation struct {
+    Method     string
+    Path       string // Relative to the version base path, in gin syntax
+    Summary    string
+    Tag        string
+    Query      []string            // Optional query parameters
+    Request    interface{}         // JSON request body; nil if none
+    RawRequest []string            // Content types of non-JSON request bodies
+    Responses  map[int]interface{} // Success responses; binaryBody for files, nil for none
+}
+
+// binaryBody marks a response that is a file rather than JSON.
+type binaryBody struct{}
+
+// eventStreamBody marks a response of server-sent events.
+type eventStreamBody struct{}
+
+// Response shapes the handlers build as gin.H, spelled out for the document.
+type acceptedTaskDoc struct {
+    TaskID              string         `json:"taskId"`
+    TraceID             string         `json:"traceId,omitempty"`
+    EstimatedOutputSize int64          `json:"estimatedOutputSize,omitempty"`
+    Warnings            []task.Warning `json:"warnings,omitempty"`
+    Message             string         `json:"message,omitempty"`
+}
+
+type acceptedPipelineDoc struct {
+    PipelineID string   `json:"pipelineId"`
+    TaskIDs    []string `json:"taskIds"`
+    TraceID    string   `json:"traceId,omitempty"`
+}
+
+type acceptedGraphDoc struct {
+    BatchID string            `json:"batchId"`
+    TaskIDs map[string]string `json:"taskIds"` // By ref
+}
+
+type callbackAttemptsDoc struct {
+    TaskID   string                 `json:"taskId"`
+    Attempts []task.CallbackAttempt `json:"attempts"`
+}
+
+type failingCallbacksDoc struct {
+    Endpoints []task.CallbackEndpoint `json:"endpoints"`
+}
+
+type keysDoc struct {
+    Keys []*auth.Key `json:"keys"`
+}
+
+type queuesDoc struct {
+    Queues []task.QueueStatus `json:"queues"`
+}
+
+type messageDoc struct {
+    Message string `json:"message"`
+}
+
+type errorDoc struct {
+    Error   string `json:"error"`
+    Code    string `json:"code,omitempty"`
+    Details string `json:"details,omitempty"`
+}
+
+// openAPIOperations lists every route of registerRoutes plus the signed upload
+// route. Keep it in sync when adding routes; a test checks the two match.
+var openAPIOperations = []openAPIOperation{
+    {Method: "POST", Path: "/call", Summary: "Run a task and wait for its output", Tag: "tasks",
+        Request: TaskRequest{}, Responses: map[int]interface{}{200: binaryBody{}, 202: acceptedTaskDoc{}}},
+    {Method: "GET", Path: "/transform", Summary: "Fetch, transform and return media with a preset", Tag: "operations",
+        Query: []string{"src", "preset"}, Responses: map[int]interface{}{200: binaryBody{}}},
+    {Method: "POST", Path: "/tasks", Summary: "Submit a task", Tag: "tasks",
+        Request: TaskRequest{}, Responses: map[int]interface{}{202: acceptedTaskDoc{}}},
+    {Method: "GET", Path: "/tasks", Summary: "List tasks", Tag: "tasks",
+        Query: []string{"batchId", "scheduleId", "owner", "status", "createdAfter", "limit", "cursor", "sort"},
+        Responses: map[int]interface{}{200: []*task.Task{}}},
+    {Method: "GET", Path: "/tasks/events", Summary: "Stream changes of the caller's tasks as server-sent events", Tag: "tasks",
+        Responses: map[int]interface{}{200: eventStreamBody{}}},
+    {Method: "GET", Path: "/tasks/:taskId", Summary: "Get a task", Tag: "tasks",
+        Responses: map[int]interface{}{200: task.Task{}}},
+    {Method: "GET", Path: "/tasks/:taskId/events", Summary: "Stream a task's status and process metrics as server-sent events", Tag: "tasks",
+        Responses: map[int]interface{}{200: eventStreamBody{}}},
+    {Method: "GET", Path: "/tasks/:taskId/logs", Summary: "Read a page or the tail of a task's log", Tag: "tasks",
+        Query: []string{"tail", "offset", "limit", "stage"}, Responses: map[int]interface{}{200: task.LogPage{}}},
+    {Method: "DELETE", Path: "/tasks/:taskId", Summary: "Delete a task and its files", Tag: "tasks",
+        Query: []string{"force"}, Responses: map[int]interface{}{200: messageDoc{}}},
+    {Method: "PATCH", Path: "/tasks/:taskId/cancel", Summary: "Cancel a task", Tag: "tasks",
+        Responses: map[int]interface{}{200: messageDoc{}}},
+    {Method: "POST", Path: "/tasks/:taskId/retry", Summary: "Run a failed, canceled or skipped task again as a new task", Tag: "tasks",
+        Responses: map[int]interface{}{202: acceptedTaskDoc{}}},
+    {Method: "GET", Path: "/tasks/:taskId/callbacks", Summary: "List the callback deliveries of a task", Tag: "callbacks",
+        Responses: map[int]interface{}{200: callbackAttemptsDoc{}}},
+    {Method: "GET", Path: "/admin/callbacks", Summary: "List failing callback endpoints", Tag: "admin",
+        Responses: map[int]interface{}{200: failingCallbacksDoc{}}},
+    {Method: "GET", Path: "/usage", Summary: "Get the usage and quota of an API key", Tag: "tasks",
+        Query: []string{"keyId"}, Responses: map[int]interface{}{200: UsageReport{}}},
+    {Method: "GET", Path: "/stats", Summary: "Get task throughput stats", Tag: "admin",
+        Query: []string{"from", "to", "interval"}, Responses: map[int]interface{}{200: task.StatsReport{}}},
+    {Method: "GET", Path: "/admin/billing", Summary: "Export billing line items as CSV or NDJSON", Tag: "admin",
+        Query: []string{"from", "to", "keyId", "format"}, Responses: map[int]interface{}{200: binaryBody{}}},
+    {Method: "GET", Path: "/admin/queue", Summary: "Get the state of the queues", Tag: "admin",
+        Responses: map[int]interface{}{200: queuesDoc{}}},
+    {Method: "POST", Path: "/admin/queue/pause", Summary: "Stop dispatching queued tasks", Tag: "admin",
+        Query: []string{"queue"}, Responses: map[int]interface{}{200: queuesDoc{}}},
+    {Method: "POST", Path: "/admin/queue/resume", Summary: "Resume a paused or draining queue", Tag: "admin",
+        Query: []string{"queue"}, Responses: map[int]interface{}{200: queuesDoc{}}},
+    {Method: "POST", Path: "/admin/queue/drain", Summary: "Reject new tasks and work off the queue", Tag: "admin",
+        Query: []string{"queue"}, Responses: map[int]interface{}{200: queuesDoc{}}},
+    {Method: "GET", Path: "/admin/replication/tasks", Summary: "Wait for a newer copy of the unfinished tasks, for standbys", Tag: "admin",
+        Query: []string{"after"}, Responses: map[int]interface{}{200: binaryBody{}}},
+    {Method: "POST", Path: "/admin/keys", Summary: "Create an API key", Tag: "admin",
+        Request: KeyRequest{}, Responses: map[int]interface{}{201: CreatedKey{}}},
+    {Method: "GET", Path: "/admin/keys", Summary: "List API keys", Tag: "admin",
+        Responses: map[int]interface{}{200: keysDoc{}}},
+    {Method: "DELETE", Path: "/admin/keys/:keyId", Summary: "Revoke an API key", Tag: "admin",
+        Responses: map[int]interface{}{200: messageDoc{}}},
+    {Method: "POST", Path: "/transcode/abr", Summary: "Transcode into an HLS or DASH bitrate ladder", Tag: "operations",
+        Request: ABRRequest{}, Responses: map[int]interface{}{202: acceptedTaskDoc{}}},
+    {Method: "POST", Path: "/subtitles", Summary: "Transcribe an input into subtitles", Tag: "operations",
+        Request: SubtitleRequest{}, Responses: map[int]interface{}{202: acceptedTaskDoc{}}},
+    {Method: "POST", Path: "/thumbnails", Summary: "Extract thumbnails or a sprite sheet", Tag: "operations",
+        Request: ThumbnailRequest{}, Responses: map[int]interface{}{202: acceptedTaskDoc{}}},
+    {Method: "POST", Path: "/framerate", Summary: "Convert an input to an exact frame rate", Tag: "operations",
+        Request: FrameRateRequest{}, Responses: map[int]interface{}{202: acceptedTaskDoc{}}},
+    {Method: "POST", Path: "/timecode", Summary: "Set the start timecode of an input without re-encoding", Tag: "operations",
+        Request: TimecodeRequest{}, Responses: map[int]interface{}{202: acceptedTaskDoc{}}},
+    {Method: "POST", Path: "/compose", Summary: "Overlay a source with transparency onto a background", Tag: "operations",
+        Request: ComposeRequest{}, Responses: map[int]interface{}{202: acceptedTaskDoc{}}},
+    {Method: "POST", Path: "/clip", Summary: "Cut a clip out of an input", Tag: "operations",
+        Request: ClipRequest{}, Responses: map[int]interface{}{202: acceptedTaskDoc{}}},
+    {Method: "POST", Path: "/concat", Summary: "Join inputs end to end, stream-copying when they match", Tag: "operations",
+        Request: ConcatRequest{}, Responses: map[int]interface{}{202: acceptedTaskDoc{}}},
+    {Method: "POST", Path: "/audio/extract", Summary: "Extract an input's audio into mp3, aac, opus or flac", Tag: "operations",
+        Request: AudioExtractRequest{}, Responses: map[int]interface{}{202: acceptedTaskDoc{}}},
+    {Method: "POST", Path: "/inputs", Summary: "Reserve an uploaded input", Tag: "inputs",
+        Request: InputRequest{}, Responses: map[int]interface{}{201: InputReservation{}}},
+    {Method: "GET", Path: "/inputs/:inputId", Summary: "Get an uploaded input", Tag: "inputs",
+        Responses: map[int]interface{}{200: task.Input{}}},
+    {Method: "PUT", Path: "/inputs/:inputId/content", Summary: "Upload an input to its signed URL", Tag: "inputs",
+        Query: []string{"expires", "signature"}, RawRequest: []string{"application/octet-stream"},
+        Responses: map[int]interface{}{200: task.Input{}}},
+    {Method: "OPTIONS", Path: "/uploads", Summary: "Discover the tus resumable upload capabilities", Tag: "inputs",
+        Responses: map[int]interface{}{204: nil}},
+    {Method: "POST", Path: "/uploads", Summary: "Create a resumable upload (tus)", Tag: "inputs",
+        Responses: map[int]interface{}{201: task.Input{}}},
+    {Method: "HEAD", Path: "/uploads/:uploadId", Summary: "Get the offset of a resumable upload", Tag: "inputs",
+        Responses: map[int]interface{}{200: nil}},
+    {Method: "PATCH", Path: "/uploads/:uploadId", Summary: "Append a chunk to a resumable upload", Tag: "inputs",
+        RawRequest: []string{"application/offset+octet-stream"}, Responses: map[int]interface{}{204: nil}},
+    {Method: "DELETE", Path: "/uploads/:uploadId", Summary: "Terminate a resumable upload", Tag: "inputs",
+        Responses: map[int]interface{}{204: nil}},
+    {Method: "POST", Path: "/jobs/import", Summary: "Import a CSV or JSONL manifest as a batch", Tag: "batches",
+        Query: []string{"format", "priority", "queue", "maxRetries", "retryBackoff", "callbackUrl"},
+        Request: ImportRequest{}, RawRequest: []string{"text/csv", "application/x-ndjson"},
+        Responses: map[int]interface{}{202: ImportReport{}}},
+    {Method: "POST", Path: "/batches/:batchId/cancel", Summary: "Cancel the unfinished tasks of a batch", Tag: "batches",
+        Responses: map[int]interface{}{200: CancelReport{}}},
+    {Method: "GET", Path: "/presets", Summary: "List presets", Tag: "batches",
+        Responses: map[int]interface{}{200: []preset.Preset{}}},
+    {Method: "GET", Path: "/targets", Summary: "List conformance targets", Tag: "tasks",
+        Responses: map[int]interface{}{200: []preset.Target{}}},
+    {Method: "GET", Path: "/tools", Summary: "List the tools tasks may run", Tag: "tasks",
+        Responses: map[int]interface{}{200: []string{}}},
+    {Method: "GET", Path: "/policy", Summary: "Get the command policy, input rules and limits submissions are checked against", Tag: "tasks",
+        Responses: map[int]interface{}{200: PolicyResponse{}}},
+    {Method: "GET", Path: "/nodes", Summary: "List the nodes and the labels tasks may require", Tag: "tasks",
+        Responses: map[int]interface{}{200: []task.NodeInfo{}}},
+    {Method: "POST", Path: "/schedules", Summary: "Create a recurring schedule submitting a task on a cron expression", Tag: "schedules",
+        Request: ScheduleRequest{}, Responses: map[int]interface{}{201: task.Schedule{}}},
+    {Method: "GET", Path: "/schedules", Summary: "List recurring schedules", Tag: "schedules",
+        Responses: map[int]interface{}{200: schedulesDoc{}}},
+    {Method: "GET", Path: "/schedules/:scheduleId", Summary: "Get a recurring schedule", Tag: "schedules",
+        Responses: map[int]interface{}{200: task.Schedule{}}},
+    {Method: "PUT", Path: "/schedules/:scheduleId", Summary: "Replace or pause a recurring schedule", Tag: "schedules",
+        Request: ScheduleRequest{}, Responses: map[int]interface{}{200: task.Schedule{}}},
+    {Method: "DELETE", Path: "/schedules/:scheduleId", Summary: "Delete a recurring schedule", Tag: "schedules",
+        Responses: map[int]interface{}{200: messageDoc{}}},
+    {Method: "POST", Path: "/graphs", Summary: "Submit tasks with dependencies between them", Tag: "tasks",
+        Request: GraphRequest{}, Responses: map[int]interface{}{202: acceptedGraphDoc{}}},
+    {Method: "POST", Path: "/pipelines", Summary: "Submit a pipeline of chained tasks", Tag: "pipelines",
+        Request: PipelineRequest{}, Responses: map[int]interface{}{202: acceptedPipelineDoc{}}},
+    {Method: "GET", Path: "/pipelines/:pipelineId", Summary: "Get a pipeline", Tag: "pipelines",
+        Responses: map[int]interface{}{200: task.Pipeline{}}},
+    {Method: "POST", Path: "/pipelines/:pipelineId/cancel", Summary: "Cancel the unfinished steps of a pipeline", Tag: "pipelines",
+        Responses: map[int]interface{}{200: CancelReport{}}},
+    {Method: "GET", Path: "/schema", Summary: "Get the JSON Schemas of tasks, requests, events and errors", Tag: "meta",
+        Responses: map[int]interface{}{200: map[string]interface{}{}}},
+    {Method: "GET", Path: "/files/*filepath", Summary: "Download an output file", Tag: "files",
+        Responses: map[int]interface{}{200: binaryBody{}}},
+    {Method: "POST", Path: "/tasks/:taskId/download-url", Summary: "Create a signed, expiring download URL for an artifact", Tag: "files",
+        Request: DownloadURLRequest{}, Responses: map[int]interface{}{201: SignedDownloadURL{}}},
+    {Method: "GET", Path: "/tasks/:taskId/download", Summary: "Download all outputs of a task as a zip archive", Tag: "files",
+        Query: []string{"format"}, Responses: map[int]interface{}{200: binaryBody{}}},
+    {Method: "GET", Path: "/downloads/:expires/:signature/*filepath", Summary: "Download a file with a signed URL", Tag: "files",
+        Responses: map[int]interface{}{200: binaryBody{}}},
+}
+
+// Enumerations of named string types, which reflection cannot discover.
+var openAPIEnums = map[reflect.Type][]string{
+    reflect.TypeOf(task.Status("")): {
+        string(task.StatusQueued), string(task.StatusProcessing), string(task.StatusCompleted), string(task.StatusCompletedWithWarnings),
+        string(task.StatusFailed), string(task.StatusCanceled), string(task.StatusScheduled), string(task.StatusWaiting), string(task.StatusWaitingResources),
+        string(task.StatusSkipped), string(task.StatusInterrupted),
+    },
+    reflect.TypeOf(task.Priority("")):    {string(task.PriorityLow), string(task.PriorityNormal), string(task.PriorityHigh)},
+    reflect.TypeOf(task.InputStatus("")): {string(task.InputReserved), string(task.InputUploaded)},
+}
+
+// openAPISpec builds the OpenAPI 3 document of the /api/v1 routes.
+func openAPISpec(cfg *config.Config) gin.H {
+    g := &schemaGenerator{schemas: gin.H{}, refPrefix: "#/components/schemas/"}
+    paths := gin.H{}
+    for _, op := range openAPIOperations {
+        path, params := openAPIPath(op.Path)
+        for _, name := range op.Query {
+            params = append(params, gin.H{"name": name, "in": "query", "schema": gin.H{"type": "string"}})
+        }
+
+        responses := gin.H{
+            "default": gin.H{"description": "Error", "content": gin.H{"application/json": gin.H{"schema": g.schema(reflect.TypeOf(errorDoc{}))}}},
+        }
+        for status, body := range op.Responses {
+            response := gin.H{"description": http.StatusText(status)}
+            if body != nil {
+                response["content"] = g.content(body)
+            }
+            responses[strconv.Itoa(status)] = response
+        }
+
+        operation := gin.H{
+            "operationId": operationID(op),
+            "summary":     op.Summary,
+            "tags":        []string{op.Tag},
+            "responses":   responses,
+        }
+        if len(params) > 0 {
+            operation["parameters"] = params
+        }
+        if op.Request != nil || len(op.RawRequest) > 0 {
+            content := gin.H{}
+            if op.Request != nil {
+                content["application/json"] = gin.H{"schema": g.schema(reflect.TypeOf(op.Request))}
+            }
+            for _, contentType := range op.RawRequest {
+                content[contentType] = gin.H{"schema": gin.H{"type": "string", "format": "binary"}}
+            }
+            operation["requestBody"] = gin.H{"required": true, "content": content}
+        }
+        if op.Path == "/inputs/:inputId/content" || op.Path == "/downloads/:expires/:signature/*filepath" {
+            operation["security"] = []gin.H{} // Signed URLs carry their own authorization
+        }
+
+        item, _ := paths[path].(gin.H)
+        if item == nil {
+            item = gin.H{}
+            paths[path] = item
+        }
+        item[strings.ToLower(op.Method)] = operation
+    }
+
+    components := gin.H{"schemas": g.schemas}
+    spec := gin.H{
+        "openapi": "3.0.3",
+        "info": gin.H{
+            "title":   "FFwebAPI",
+            "version": "v1",
+        },
+        "servers":    []gin.H{{"url": strings.TrimSuffix(cfg.BaseURL, "/") + "/api/v1"}},
+        "paths":      paths,
+        "components": components,
+    }
+    if cfg.AuthEnable {
+        components["securitySchemes"] = gin.H{"bearerAuth": gin.H{"type": "http", "scheme": "bearer"}}
+        spec["security"] = []gin.H{{"bearerAuth": []string{}}}
+    }
+    return spec
+}
+
+// openAPIPath turns a gin route into an OpenAPI path plus its path parameters.
+func openAPIPath(route string) (string, []gin.H) {
+    var params []gin.H
+    segments := strings.Split(route, "/")
+    for i, s := range segments {
+        if s == "" || (s[0] != ':' && s[0] != '*') {
+            continue
+        }
+        name := s[1:]
+        segments[i] = "{" + name + "}"
+        params = append(params, gin.H{"name": name, "in": "path", "required": true, "schema": gin.H{"type": "string"}})
+    }
+    return strings.Join(segments, "/"), params
+}
+
+// operationID derives a stable identifier for SDK generators, e.g. "getTasksTaskId".
+func operationID(op openAPIOperation) string {
+    id := strings.ToLower(op.Method)
+    for _, s := range strings.FieldsFunc(op.Path, func(r rune) bool { return r == '/' || r == ':' || r == '*' }) {
+        id += strings.ToUpper(s[:1]) + s[1:]
+    }
+    return id
+}
+
+// schemaGenerator converts Go types into JSON schemas following encoding/json
+// rules. Named structs become components referenced by $ref.
+type schemaGenerator struct {
+    schemas   gin.H
+    refPrefix string // Where the components live, e.g. "#/components/schemas/"
+}
+
+func (g *schemaGenerator) content(body interface{}) gin.H {
+    if _, ok := body.(binaryBody); ok {
+        return gin.H{"application/octet-stream": gin.H{"schema": gin.H{"type": "string", "format": "binary"}}}
+    }
+    if _, ok := body.(eventStreamBody); ok {
+        return gin.H{"text/event-stream": gin.H{"schema": gin.H{"type": "string"}}}
+    }
+    return gin.H{"application/json": gin.H{"schema": g.schema(reflect.TypeOf(body))}}
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) gin.H {
+    if enum, ok := openAPIEnums[t]; ok {
+        return gin.H{"type": "string", "enum": enum}
+    }
+    switch t {
+    case reflect.TypeOf(time.Time{}):
+        return gin.H{"type": "string", "format": "date-time"}
+    case reflect.TypeOf(time.Duration(0)):
+        return gin.H{"type": "integer", "format": "int64", "description": "Nanoseconds"}
+    }
+
+    switch t.Kind() {
+    case reflect.Ptr:
+        return g.schema(t.Elem())
+    case reflect.Bool:
+        return gin.H{"type": "boolean"}
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+        return gin.H{"type": "integer"}
+    case reflect.Int64, reflect.Uint64:
+        return gin.H{"type": "integer", "format": "int64"}
+    case reflect.Float32, reflect.Float64:
+        return gin.H{"type": "number"}
+    case reflect.String:
+        return gin.H{"type": "string"}
+    case reflect.Slice, reflect.Array:
+        if t.Elem().Kind() == reflect.Uint8 {
+            return gin.H{"type": "string", "format": "byte"}
+        }
+        return gin.H{"type": "array", "items": g.schema(t.Elem())}
+    case reflect.Map:
+        return gin.H{"type": "object", "additionalProperties": g.schema(t.Elem())}
+    case reflect.Struct:
+        name := strings.TrimSuffix(t.Name(), "Doc")
+        name = strings.ToUpper(name[:1]) + name[1:]
+        return g.define(name, t)
+    }
+    return gin.H{}
+}
+
+// define adds the schema of struct type t as the component name and returns
+// a reference to it.
+func (g *schemaGenerator) define(name string, t reflect.Type) gin.H {
+    ref := gin.H{"$ref": g.refPrefix + name}
+    if _, ok := g.schemas[name]; ok {
+        return ref
+    }
+    g.schemas[name] = gin.H{} // Reserved first so recursive types terminate
+    properties, required := gin.H{}, []string{}
+    g.addFields(t, properties, &required)
+    s := gin.H{"type": "object", "properties": properties}
+    if len(required) > 0 {
+        s["required"] = required
+    }
+    g.schemas[name] = s
+    return ref
+}
+
+// addFields adds the JSON fields of a struct, flattening embedded structs the
+// way encoding/json does. Fields with binding:"required" are required.
+func (g *schemaGenerator) addFields(t reflect.Type, properties gin.H, required *[]string) {
+    for i := 0; i < t.NumField(); i++ {
+        f := t.Field(i)
+        tag := f.Tag.Get("json")
+        name, _, _ := strings.Cut(tag, ",")
+        if f.Anonymous && name == "" {
+            ft := f.Type
+            if ft.Kind() == reflect.Ptr {
+                ft = ft.Elem()
+            }
+            g.addFields(ft, properties, required)
+            continue
+        }
+        if !f.IsExported() || name == "-" {
+            continue
+        }
+        if name == "" {
+            name = f.Name
+        }
+        properties[name] = g.schema(f.Type)
+        if strings.Contains(f.Tag.Get("binding"), "required") && !strings.Contains(f.Tag.Get("binding"), "required_") {
+            *required = append(*required, name)
+        }
+    }
+}
+
+// handleOpenAPI serves the OpenAPI document of the v1 API.
+func handleOpenAPI(spec gin.H) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        c.JSON(http.StatusOK, spec)
+    }
+}
+
+// handleDocs serves the API reference's files under /docs/.
+func handleDocs() gin.HandlerFunc {
+    // Requests go to the server of the document, whose BASE_URL may be
+    // another origin than the page's.
+    return serveEmbedded(docsFiles, "docs", "default-src 'self'; connect-src *; frame-ancestors 'none'")
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+    "fmt"
+    "net/url"
+    "path"
+    "regexp"
+    "strings"
+    "time"
+    "unicode"
+
+    "ffwebapi/netguard"
+    "ffwebapi/task"
+)
+
+// outputNameData is what an outputName template can refer to, e.g.
+// "{{.InputBasename}}_720p". Values are sanitized, so they can't smuggle
+// path separators or quotes into the name.
+type outputNameData struct {
+    InputBasename string // Input file name without its extension
+    InputExt      string // Extension of the input file, without the dot
+    OutputExt     string
+    Preset        string // Preset name, for manifest imports
+    Date          string // Submission date (UTC), e.g. "2024-05-01"
+}
+
+// newOutputNameData describes the input of a task for its outputName
+// template. Uploaded inputs are named by their input ID.
+func newOutputNameData(inputMedia, outputExt, presetName string) outputNameData {
+    name := inputMedia
+    if id, ok := task.InputRefID(inputMedia); ok {
+        name = id
+    } else if netguard.IsURL(inputMedia) {
+        if u, err := url.Parse(inputMedia); err == nil {
+            name = u.Path
+        }
+    }
+    name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+    if name == "." || name == "/" {
+        name = ""
+    }
+    ext := path.Ext(name)
+    return outputNameData{
+        InputBasename: sanitizeNamePart(strings.TrimSuffix(name, ext)),
+        InputExt:      sanitizeNamePart(strings.TrimPrefix(ext, ".")),
+        OutputExt:     outputExt,
+        Preset:        sanitizeNamePart(presetName),
+        Date:          time.Now().UTC().Format("2006-01-02"),
+    }
+}
+
+// sanitizeNamePart keeps letters, digits, spaces, dots, dashes and
+// underscores of a value placed into a file name, replacing the rest.
+func sanitizeNamePart(s string) string {
+    s = strings.Map(func(r rune) rune {
+        if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(" .-_", r) {
+            return r
+        }
+        return '_'
+    }, s)
+    return strings.TrimLeft(s, ".")
+}
+
+// maxOutputNameLen bounds output names, as file systems commonly do.
+const maxOutputNameLen = 255
+
+// outputNameFieldRe matches the placeholders of an outputName template, e.g.
+// "{{.InputBasename}}" or "{{ .Date }}".
+var outputNameFieldRe = regexp.MustCompile(`\{\{\s*\.(\w+)\s*\}\}`)
+
+// field returns the value of an outputName placeholder.
+func (d outputNameData) field(name string) (string, bool) {
+    switch name {
+    case "InputBasename":
+        return d.InputBasename, true
+    case "InputExt":
+        return d.InputExt, true
+    case "OutputExt":
+        return d.OutputExt, true
+    case "Preset":
+        return d.Preset, true
+    case "Date":
+        return d.Date, true
+    }
+    return "", false
+}
+
+// renderOutputName expands an outputName template, adding ext unless the
+// template ends with it, and checks the result like a literal name; see
+// cleanOutputName. Templates only substitute the fields of outputNameData,
+// and expansion stops once the name is too long, so a short template can't
+// render an arbitrarily large name.
+func renderOutputName(name string, data outputNameData, ext string) (string, error) {
+    if strings.Contains(name, "{{") {
+        var b strings.Builder
+        last := 0
+        for _, m := range outputNameFieldRe.FindAllStringSubmatchIndex(name, -1) {
+            value, ok := data.field(name[m[2]:m[3]])
+            if !ok {
+                return "", fmt.Errorf("invalid outputName template: unknown field %q", name[m[2]:m[3]])
+            }
+            b.WriteString(name[last:m[0]])
+            b.WriteString(value)
+            last = m[1]
+            if b.Len() > maxOutputNameLen {
+                return "", fmt.Errorf("outputName is longer than %d bytes", maxOutputNameLen)
+            }
+        }
+        b.WriteString(name[last:])
+        if strings.Contains(b.String(), "{{") || strings.Contains(b.String(), "}}") {
+            return "", fmt.Errorf("invalid outputName template: only {{.Field}} placeholders are supported")
+        }
+        name = strings.TrimSpace(b.String())
+        // Input names often have dots of their own, e.g. "v1.2_final".
+        if name != "" && !strings.HasSuffix(name, "."+ext) {
+            name += "." + ext
+        }
+    }
+    if name == "" {
+        return "", fmt.Errorf("outputName is empty")
+    }
+    return cleanOutputName(name, ext)
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+    "encoding/base64"
+    "fmt"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "ffwebapi/task"
+    "github.com/gin-gonic/gin"
+)
+
+const (
+    defaultTaskPageSize = 100  // For API versions that paginate by default
+    maxTaskPageSize     = 1000 // Cap of the "limit" of a task list page
+)
+
+// taskSortKeys are the fields tasks can be sorted by, with "-" for descending.
+var taskSortKeys = map[string]func(t *task.Task) time.Time{
+    "createdAt":    func(t *task.Task) time.Time { return t.CreatedAt },
+    "completedAt":  func(t *task.Task) time.Time { return t.CompletedAt },
+    "scheduledFor": func(t *task.Task) time.Time { return t.ScheduledFor },
+}
+
+// taskQuery holds the filters and page of a task list request.
+type taskQuery struct {
+    statuses     map[task.Status]bool // Empty for any status
+    createdAfter time.Time
+    limit        int    // 0 for no limit
+    sort         string // A key of taskSortKeys, "-" prefixed when descending
+    cursor       *taskCursor
+}
+
+// taskCursor is the position after which the next page starts: the sort key
+// and ID of the last task of the previous page. IDs break ties.
+type taskCursor struct {
+    sort string
+    key  time.Time
+    id   string
+}
+
+// parseTaskQuery reads status, createdAfter, limit, cursor and sort.
+// defaultLimit applies when no limit is given.
+func parseTaskQuery(c *gin.Context, defaultLimit int) (*taskQuery, error) {
+    q := &taskQuery{limit: defaultLimit, sort: c.DefaultQuery("sort", "-createdAt")}
+    if _, ok := taskSortKeys[strings.TrimPrefix(q.sort, "-")]; !ok {
+        return nil, fmt.Errorf("sort must be createdAt, completedAt or scheduledFor, optionally prefixed with \"-\"")
+    }
+
+    if s := c.Query("status"); s != "" {
+        q.statuses = make(map[task.Status]bool)
+        for _, status := range strings.Split(s, ",") {
+            if !isTaskStatus(status) {
+                return nil, fmt.Errorf("unknown status %q", status)
+            }
+            q.statuses[task.Status(status)] = true
+        }
+    }
+
+    if s := c.Query("createdAfter"); s != "" {
+        after, err := time.Parse(time.RFC3339, s)
+        if err != nil {
+            return nil, fmt.Errorf("createdAfter must be an RFC 3339 time")
+        }
+        q.createdAfter = after
+    }
+
+    if s := c.Query("limit"); s != "" {
+        limit, err := strconv.Atoi(s)
+        if err != nil || limit < 1 || limit > maxTaskPageSize {
+            return nil, fmt.Errorf("limit must be between 1 and %d", maxTaskPageSize)
+        }
+        q.limit = limit
+    }
+
+    if s := c.Query("cursor"); s != "" {
+        cursor, err := decodeTaskCursor(s)
+        if err != nil || cursor.sort != q.sort {
+            return nil, fmt.Errorf("invalid cursor for sort %q", q.sort)
+        }
+        q.cursor = cursor
+    }
+    return q, nil
+}
+
+// isTaskStatus reports whether s names a task status.
+func isTaskStatus(s string) bool {
+    for _, status := range openAPIEnums[reflect.TypeOf(task.Status(""))] {
+        if status == s {
+            return true
+        }
+    }
+    return false
+}
+
+// matches reports whether t passes the status and creation time filters.
+func (q *taskQuery) matches(t *task.Task) bool {
+    if len(q.statuses) > 0 && !q.statuses[t.Status] {
+        return false
+    }
+    return q.createdAfter.IsZero() || t.CreatedAt.After(q.createdAfter)
+}
+
+// page sorts the tasks and returns those after the cursor, up to the limit,
+// plus the cursor of the next page, or "" on the last page.
+func (q *taskQuery) page(tasks []*task.Task) ([]*task.Task, string) {
+    field := strings.TrimPrefix(q.sort, "-")
+    desc := field != q.sort
+    keyOf := taskSortKeys[field]
+    less := func(ka time.Time, ida string, kb time.Time, idb string) bool {
+        if !ka.Equal(kb) {
+            return ka.Before(kb) != desc
+        }
+        return ida < idb
+    }
+    sort.Slice(tasks, func(i, j int) bool {
+        return less(keyOf(tasks[i]), tasks[i].ID, keyOf(tasks[j]), tasks[j].ID)
+    })
+
+    if q.cursor != nil {
+        start := sort.Search(len(tasks), func(i int) bool {
+            return less(q.cursor.key, q.cursor.id, keyOf(tasks[i]), tasks[i].ID)
+        })
+        tasks = tasks[start:]
+    }
+    if q.limit == 0 || len(tasks) <= q.limit {
+        return tasks, ""
+    }
+    tasks = tasks[:q.limit]
+    last := tasks[len(tasks)-1]
+    return tasks, encodeTaskCursor(&taskCursor{sort: q.sort, key: keyOf(last), id: last.ID})
+}
+
+// Cursors are opaque to clients: base64 of "sort|unix nanos|task ID", with
+// no nanos for unset times such as the completion of a running task.
+func encodeTaskCursor(cur *taskCursor) string {
+    nanos := ""
+    if !cur.key.IsZero() {
+        nanos = strconv.FormatInt(cur.key.UnixNano(), 10)
+    }
+    raw := cur.sort + "|" + nanos + "|" + cur.id
+    return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeTaskCursor(s string) (*taskCursor, error) {
+    raw, err := base64.RawURLEncoding.DecodeString(s)
+    if err != nil {
+        return nil, err
+    }
+    parts := strings.SplitN(string(raw), "|", 3)
+    if len(parts) != 3 {
+        return nil, fmt.Errorf("malformed cursor")
+    }
+    cur := &taskCursor{sort: parts[0], id: parts[2]}
+    if parts[1] != "" {
+        nanos, err := strconv.ParseInt(parts[1], 10, 64)
+        if err != nil {
+            return nil, err
+        }
+        cur.key = time.Unix(0, nanos)
+    }
+    return cur, nil
+}
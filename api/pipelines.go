@@ -0,0 +1,105 @@
+package api
+
+import (
+    "net/http"
+
+    "ffwebapi/task"
+    "github.com/gin-gonic/gin"
+)
+
+type PipelineStepRequest struct {
+    Command   string `json:"command" binding:"required"`
+    OutputExt string `json:"outputExt" binding:"required"`
+}
+
+type PipelineRequest struct {
+    InputMedia   string                `json:"inputMedia"`
+    InputID      string                `json:"inputId"` // Uploaded input instead of inputMedia
+    Steps        []PipelineStepRequest `json:"steps" binding:"required,min=1,dive"`
+    Priority     string                `json:"priority"`
+    Queue        string                `json:"queue"`
+    MaxRetries   int                   `json:"maxRetries"`
+    RetryBackoff string                `json:"retryBackoff"`
+}
+
+// handleCreatePipeline submits a chain of tasks where each step's output is
+// the next step's ${INPUT_MEDIA}.
+func (h *Handler) handleCreatePipeline(c *gin.Context) {
+    var req PipelineRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+
+    var opts task.SubmitOptions
+    inputMedia := req.InputMedia
+    steps := make([]task.PipelineStep, 0, len(req.Steps))
+    for i, step := range req.Steps {
+        // Only the first step reads the caller's input; later ones read a local file.
+        stepReq := TaskRequest{
+            Command:      step.Command,
+            OutputExt:    step.OutputExt,
+            Priority:     req.Priority,
+            Queue:        req.Queue,
+            MaxRetries:   req.MaxRetries,
+            RetryBackoff: req.RetryBackoff,
+        }
+        if i == 0 {
+            stepReq.InputMedia, stepReq.InputID = req.InputMedia, req.InputID
+        }
+        var ok bool
+        if _, opts, ok = h.validateTaskRequest(c, &stepReq); !ok {
+            return
+        }
+        if i == 0 {
+            inputMedia = stepReq.InputMedia // An inputId turns into an input reference
+        }
+        steps = append(steps, task.PipelineStep{Command: step.Command, OutputExt: step.OutputExt})
+    }
+
+    p, err := h.taskManager.SubmitPipeline(inputMedia, steps, opts)
+    if err != nil {
+        respondSubmitError(c, "Failed to create pipeline", err)
+        return
+    }
+
+    stepIDs := make([]string, len(p.Steps))
+    for i, t := range p.Steps {
+        stepIDs[i] = t.ID
+    }
+    h.setPollHints(c)
+    resp := gin.H{"pipelineId": p.ID, "taskIds": stepIDs}
+    if traceID := p.Steps[0].TraceID; traceID != "" {
+        resp["traceId"] = traceID
+    }
+    c.JSON(http.StatusAccepted, resp)
+}
+
+// handleGetPipeline reports the pipeline status together with every step.
+func (h *Handler) handleGetPipeline(c *gin.Context) {
+    p, found := h.taskManager.GetPipeline(c.Param("pipelineId"))
+    if !found || !canSee(c, p.Steps[0].Owner) {
+        respondError(c, http.StatusNotFound, "not_found", "Pipeline not found")
+        return
+    }
+
+    // The steps are the stored tasks; the response gets snapshots of them.
+    resp := &task.Pipeline{ID: p.ID, Status: p.Status, CreatedAt: p.CreatedAt}
+    for _, t := range p.Steps {
+        resp.Steps = append(resp.Steps, h.taskView(c, t))
+    }
+    if !p.Status.IsTerminal() {
+        h.setPollHints(c)
+    }
+    c.JSON(http.StatusOK, resp)
+}
+
+// handleCancelPipeline cancels every step of a pipeline that has not finished.
+func (h *Handler) handleCancelPipeline(c *gin.Context) {
+    p, found := h.taskManager.GetPipeline(c.Param("pipelineId"))
+    if !found || !canSee(c, p.Steps[0].Owner) {
+        respondError(c, http.StatusNotFound, "not_found", "Pipeline not found")
+        return
+    }
+    h.cancelGroup(c, p.Steps)
+}
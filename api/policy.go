@@ -0,0 +1,78 @@
+package api
+
+import (
+    "net/http"
+    "sort"
+
+    "ffwebapi/ffmpeg"
+    "github.com/gin-gonic/gin"
+)
+
+// PolicyResponse is the policy submissions are checked against, so client
+// apps can validate user input before submitting it rather than learn of
+// the restrictions from 400s.
+type PolicyResponse struct {
+    Command ffmpeg.CommandPolicy `json:"command"`
+    Inputs  InputPolicyDoc       `json:"inputs"`
+    Limits  LimitsDoc            `json:"limits"`
+}
+
+// InputPolicyDoc is what inputs may be fetched from. Hosts denied by
+// INPUT_DENIED_HOSTS are not listed, as they name internal networks.
+type InputPolicyDoc struct {
+    AllowedSchemes []string `json:"allowedSchemes"`
+    AllowedPorts   []int    `json:"allowedPorts"` // Any if empty
+    AllowedHosts   []string `json:"allowedHosts"` // Any not denied if empty
+    AllowPrivate   bool     `json:"allowPrivate"` // Loopback, private and link-local addresses
+    MaxRedirects   int      `json:"maxRedirects"`
+    Sources        []string `json:"sources,omitempty"` // Names usable as "source://<name>/<path>"
+}
+
+// LimitsDoc are the size and duration limits of tasks; 0 means unlimited.
+type LimitsDoc struct {
+    MaxInputSize        int64   `json:"maxInputSize"`        // Bytes
+    MaxOutputSize       int64   `json:"maxOutputSize"`       // Bytes, also the largest "maxOutputSize"
+    MaxRunSeconds       float64 `json:"maxRunSeconds"`       // A task is killed after running this long (FF_TIMEOUT)
+    MaxOutputTTLSeconds float64 `json:"maxOutputTtlSeconds"` // Largest "outputTtl"
+    MaxRetries          int     `json:"maxRetries"`          // Largest "maxRetries"
+    InlineResultMaxSize int64   `json:"inlineResultMaxSize"` // Largest output embedded for "inlineResult"; 0 disables it
+}
+
+// handleGetPolicy returns the command policy, input egress rules and limits
+// in effect.
+func (h *Handler) handleGetPolicy(c *gin.Context) {
+    resp := PolicyResponse{
+        Command: ffmpeg.EffectivePolicy(h.cfg),
+        Inputs: InputPolicyDoc{
+            AllowedSchemes: orEmpty(h.cfg.InputAllowedSchemes),
+            AllowedPorts:   h.cfg.InputAllowedPorts,
+            AllowedHosts:   orEmpty(h.cfg.InputAllowedHosts),
+            AllowPrivate:   h.cfg.InputAllowPrivate,
+            MaxRedirects:   h.cfg.InputMaxRedirects,
+        },
+        Limits: LimitsDoc{
+            MaxInputSize:        h.cfg.MaxInputSize,
+            MaxOutputSize:       h.cfg.MaxOutputSize,
+            MaxRunSeconds:       h.cfg.FFTimeout.Seconds(),
+            MaxOutputTTLSeconds: h.cfg.MaxOutputTTL.Seconds(),
+            MaxRetries:          h.cfg.MaxRetries,
+            InlineResultMaxSize: h.cfg.InlineResultMaxSize,
+        },
+    }
+    if resp.Inputs.AllowedPorts == nil {
+        resp.Inputs.AllowedPorts = []int{}
+    }
+    for name := range h.cfg.InputSources {
+        resp.Inputs.Sources = append(resp.Inputs.Sources, name)
+    }
+    sort.Strings(resp.Inputs.Sources)
+    c.JSON(http.StatusOK, resp)
+}
+
+// orEmpty makes empty lists encode as [] rather than null.
+func orEmpty(list []string) []string {
+    if list == nil {
+        return []string{}
+    }
+    return list
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+)
+
+// handleGetQueueStatus reports whether each queue is paused or draining,
+// with its depth and running tasks.
+func (h *Handler) handleGetQueueStatus(c *gin.Context) {
+    c.JSON(http.StatusOK, gin.H{"queues": h.taskManager.QueueStatus()})
+}
+
+// handleQueueAction applies a pause, resume or drain to the queue named by
+// "queue", or to all queues, and answers with the resulting state.
+func (h *Handler) handleQueueAction(action func(name string) error) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if err := action(c.Query("queue")); err != nil {
+            respondError(c, http.StatusNotFound, "not_found", err.Error())
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"queues": h.taskManager.QueueStatus()})
+    }
+}
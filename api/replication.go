@@ -0,0 +1,35 @@
+package api
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "strconv"
+
+    "ffwebapi/task"
+    "github.com/gin-gonic/gin"
+)
+
+// handleReplicateTasks serves the saved unfinished tasks to a standby
+// (STANDBY_OF). The request is held until they differ from version "after",
+// for up to task.ReplicationWait, and answered 304 if they don't.
+func (h *Handler) handleReplicateTasks(c *gin.Context) {
+    after, err := strconv.ParseInt(c.DefaultQuery("after", "0"), 10, 64)
+    if err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", "after must be a version")
+        return
+    }
+    ctx, cancel := context.WithTimeout(c.Request.Context(), task.ReplicationWait)
+    defer cancel()
+    data, version, err := h.taskManager.ReplicationSnapshot(ctx, after)
+    if errors.Is(err, task.ErrNoTaskStore) {
+        respondError(c, http.StatusConflict, "no_task_store", err.Error())
+        return
+    }
+    c.Header(task.ReplicationVersionHeader, strconv.FormatInt(version, 10))
+    if data == nil {
+        c.Status(http.StatusNotModified)
+        return
+    }
+    c.Data(http.StatusOK, "application/json", data)
+}
@@ -1,35 +1,161 @@
 package api
 
 import (
+    "time"
+
+    "ffwebapi/auth"
     "ffwebapi/config"
     "ffwebapi/task"
     "github.com/gin-gonic/gin"
 )
 
-func SetupRouter(tm *task.Manager, cfg *config.Config) *gin.Engine {
-    r := gin.Default()
-    h := NewHandler(tm, cfg)
+func SetupRouter(tm *task.Manager, keys *auth.Store, cfg *config.Config) *gin.Engine {
+    r := gin.New()
+    r.Use(gin.Recovery(), RequestIDMiddleware(), TracingMiddleware(), CORSMiddleware(cfg))
+    h := NewHandler(tm, keys, cfg)
+    tm.SetScheduleCheck(h.checkScheduledRun)
     
-    // Health check
-    r.GET("/health", func(c *gin.Context) {
-        c.JSON(200, gin.H{"status": "ok"})
-    })
-
-    v1 := r.Group("/api/v1")
-    v1.Use(AuthMiddleware(cfg))
-    {
-        // Sync endpoint (with limitations)
-        v1.POST("/call", h.handleSyncCall)
-
-        // Async task endpoints
-        v1.POST("/tasks", h.handleCreateTask)
-        v1.GET("/tasks", h.handleListTasks)
-        v1.GET("/tasks/:taskId", h.handleGetTaskStatus)
-        v1.PATCH("/tasks/:taskId/cancel", h.handleCancelTask)
-
-        // File download endpoint (does not need auth if URLs are unguessable)
-        // but we put it here for consistency.
-        v1.GET("/files/:filename", h.handleGetFile)
+    // Health checks: a diagnostic report, liveness and readiness
+    r.GET("/health", h.handleHealth)
+    r.GET("/healthz", handleLiveness)
+    r.GET("/readyz", h.handleReadiness)
+
+    // API description for client generators, and a browsable version of it
+    r.GET("/openapi.json", handleOpenAPI(openAPISpec(cfg)))
+    r.GET("/docs", redirectToDir("docs"))
+    r.GET("/docs/*filepath", handleDocs())
+
+    // Web dashboard on top of the v2 API
+    if cfg.UIEnable {
+        r.GET("/ui", redirectToDir("ui"))
+        r.GET("/ui/*filepath", handleUI())
+    }
+
+    // Limits are shared by all API versions.
+    requestLimit := RateLimitMiddleware(newRateLimiter("X-RateLimit", time.Minute,
+        rateLimit{rate: cfg.RateLimitRequests, burst: cfg.RateLimitRequestsBurst},
+        func(k *auth.RateLimit) rateLimit { return rateLimit{rate: k.RequestsPerMinute, burst: k.RequestsBurst} }))
+    submitLimit := RateLimitMiddleware(newRateLimiter("X-Submit-RateLimit", time.Hour,
+        rateLimit{rate: cfg.RateLimitSubmissions, burst: cfg.RateLimitSubmissionsBurst},
+        func(k *auth.RateLimit) rateLimit { return rateLimit{rate: k.SubmissionsPerHour, burst: k.SubmissionsBurst} }))
+
+    // Every API version serves the same routes; versions differ only in how
+    // requests and responses are mapped (see version.go).
+    for _, v := range apiVersions(cfg) {
+        g := r.Group(v.basePath())
+        g.Use(versionMiddleware(v), AuthMiddleware(cfg, keys), requestLimit)
+        registerRoutes(g, h, submitLimit)
+
+        // Signed URLs carry their own authorization.
+        signed := r.Group(v.basePath())
+        signed.Use(versionMiddleware(v), requestLimit)
+        signed.PUT("/inputs/:inputId/content", h.handleUploadInput)
+        signed.GET("/downloads/:expires/:signature/*filepath", h.handleSignedDownload)
     }
     return r
 }
+
+// apiVersions lists the served API versions, oldest first.
+func apiVersions(cfg *config.Config) []*apiVersion {
+    return []*apiVersion{
+        {Name: "v1", Deprecated: true, Sunset: cfg.APIV1Sunset, Successor: "v2", mapper: v1Mapper{}},
+        {Name: "v2", PageSize: defaultTaskPageSize, mapper: v2Mapper{}},
+    }
+}
+
+// registerRoutes adds the routes of one API version, each behind the API key
+// scope it needs. submit runs before every route that creates tasks.
+func registerRoutes(g *gin.RouterGroup, h *Handler, submit gin.HandlerFunc) {
+    uploader := g.Group("", RequireScope(h.cfg, auth.ScopeSubmit)) // Not counted as task submissions
+    submitter := uploader.Group("", submit)
+    reader := g.Group("", RequireScope(h.cfg, auth.ScopeRead))
+    canceler := g.Group("", RequireScope(h.cfg, auth.ScopeCancel))
+    downloader := g.Group("", RequireScope(h.cfg, auth.ScopeDownload))
+    admin := g.Group("", RequireScope(h.cfg, auth.ScopeAdmin))
+
+    // Sync endpoint (with limitations)
+    submitter.POST("/call", h.handleSyncCall)
+    submitter.GET("/transform", h.handleTransform) // Read-through proxy, cached
+
+    // Async task endpoints
+    submitter.POST("/tasks", h.handleCreateTask)
+    reader.GET("/tasks", h.handleListTasks)
+    reader.GET("/tasks/events", h.handleTasksEvents) // Changes of all the caller's tasks, for dashboards
+    reader.GET("/tasks/:taskId", h.handleGetTaskStatus)
+    reader.GET("/tasks/:taskId/events", h.handleTaskEvents)
+    reader.GET("/tasks/:taskId/logs", h.handleGetTaskLogs)
+    canceler.PATCH("/tasks/:taskId/cancel", h.handleCancelTask)
+    submitter.POST("/tasks/:taskId/retry", h.handleRetryTask)
+    canceler.DELETE("/tasks/:taskId", h.handleDeleteTask)
+    reader.GET("/tasks/:taskId/callbacks", h.handleGetTaskCallbacks)
+    reader.GET("/usage", h.handleGetUsage)
+
+    // Admin views, throughput stats and API key management
+    admin.GET("/admin/callbacks", h.handleListFailingCallbacks)
+    admin.GET("/stats", h.handleGetStats)
+    admin.GET("/admin/billing", h.handleExportBilling)
+    admin.GET("/admin/queue", h.handleGetQueueStatus)
+    admin.POST("/admin/queue/pause", h.handleQueueAction(h.taskManager.PauseQueue))
+    admin.POST("/admin/queue/resume", h.handleQueueAction(h.taskManager.ResumeQueue))
+    admin.POST("/admin/queue/drain", h.handleQueueAction(h.taskManager.DrainQueue))
+    admin.GET("/admin/replication/tasks", h.handleReplicateTasks) // Followed by standbys
+    admin.POST("/admin/keys", h.handleCreateKey)
+    admin.GET("/admin/keys", h.handleListKeys)
+    admin.DELETE("/admin/keys/:keyId", h.handleRevokeKey)
+
+    // High-level operations that build the ffmpeg command for the caller
+    submitter.POST("/transcode/abr", h.handleCreateABR)
+    submitter.POST("/subtitles", h.handleCreateSubtitles)
+    submitter.POST("/thumbnails", h.handleCreateThumbnails)
+    submitter.POST("/framerate", h.handleCreateFrameRate)
+    submitter.POST("/timecode", h.handleCreateTimecode)
+    submitter.POST("/compose", h.handleCreateCompose)
+    submitter.POST("/clip", h.handleCreateClip)
+    submitter.POST("/concat", h.handleCreateConcat)
+    submitter.POST("/audio/extract", h.handleCreateAudioExtract)
+
+    // Uploaded inputs; the upload itself goes to a signed URL
+    uploader.POST("/inputs", h.handleCreateInput)
+    reader.GET("/inputs/:inputId", h.handleGetInput)
+
+    // Resumable uploads of inputs (tus)
+    uploads := uploader.Group("/uploads", tusMiddleware)
+    uploads.OPTIONS("", h.handleTusOptions)
+    uploads.POST("", h.handleCreateUpload)
+    uploads.HEAD("/:uploadId", h.handleHeadUpload)
+    uploads.PATCH("/:uploadId", h.handlePatchUpload)
+    uploads.DELETE("/:uploadId", h.handleDeleteUpload)
+
+    // Batches and presets
+    submitter.POST("/jobs/import", h.handleImportJobs)
+    canceler.POST("/batches/:batchId/cancel", h.handleCancelBatch)
+    reader.GET("/presets", h.handleListPresets)
+    reader.GET("/targets", h.handleListTargets)
+    reader.GET("/tools", h.handleListTools)
+    reader.GET("/policy", h.handleGetPolicy)
+    reader.GET("/nodes", h.handleListNodes)
+
+    // Machine-readable schemas of the payloads, for SDK generators
+    reader.GET("/schema", h.handleGetSchema)
+
+    // Recurring schedules, each run submitting a task
+    submitter.POST("/schedules", h.handleCreateSchedule)
+    reader.GET("/schedules", h.handleListSchedules)
+    reader.GET("/schedules/:scheduleId", h.handleGetSchedule)
+    submitter.PUT("/schedules/:scheduleId", h.handleUpdateSchedule)
+    canceler.DELETE("/schedules/:scheduleId", h.handleDeleteSchedule)
+
+    // Graphs: tasks that start once the tasks they depend on completed
+    submitter.POST("/graphs", h.handleCreateGraph)
+
+    // Pipelines: chained tasks, each step feeding the next
+    submitter.POST("/pipelines", h.handleCreatePipeline)
+    reader.GET("/pipelines/:pipelineId", h.handleGetPipeline)
+    canceler.POST("/pipelines/:pipelineId/cancel", h.handleCancelPipeline)
+
+    // File download endpoint (does not need auth if URLs are unguessable)
+    // but we put it here for consistency.
+    downloader.GET("/files/*filepath", h.handleGetFile)
+    downloader.POST("/tasks/:taskId/download-url", h.handleCreateDownloadURL) // Shareable without the API key
+    downloader.GET("/tasks/:taskId/download", h.handleDownloadTask)          // ?format=zip bundles all outputs
+}
@@ -0,0 +1,203 @@
+package api
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "ffwebapi/auth"
+    "ffwebapi/ffmpeg"
+    "ffwebapi/task"
+    "github.com/gin-gonic/gin"
+)
+
+// ScheduleRequest creates or replaces a recurring schedule: a cron
+// expression and the task each run submits, with the fields of TaskRequest.
+type ScheduleRequest struct {
+    TaskRequest
+    Name     string `json:"name"`
+    Cron     string `json:"cron" binding:"required"` // "minute hour day-of-month month day-of-week" or @hourly, @daily, @weekly, @monthly
+    Timezone string `json:"timezone"`                // IANA zone the cron expression is read in, e.g. "Europe/Berlin"; UTC if empty
+    Paused   bool   `json:"paused"`                  // Keep the schedule without running it
+}
+
+type schedulesDoc struct {
+    Schedules []*task.Schedule `json:"schedules"`
+}
+
+// bindSchedule reads and validates a schedule request. On failure it writes
+// a 400 response and returns ok=false.
+func (h *Handler) bindSchedule(c *gin.Context) (task.Schedule, bool) {
+    var req ScheduleRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+        return task.Schedule{}, false
+    }
+    // Every run needs its input anew, so it cannot be a one-off upload, and
+    // output upload URLs are usually presigned for a single use.
+    switch {
+    case req.InputID != "":
+        respondError(c, http.StatusBadRequest, "invalid_request", "Schedules cannot use uploaded inputs (inputId); use a URL, source or local path")
+        return task.Schedule{}, false
+    case req.OutputUpload != nil:
+        respondError(c, http.StatusBadRequest, "invalid_request", "Schedules cannot upload their outputs (outputUpload)")
+        return task.Schedule{}, false
+    case len(req.DependsOn) > 0:
+        respondError(c, http.StatusBadRequest, "invalid_request", "Schedules cannot depend on other tasks (dependsOn)")
+        return task.Schedule{}, false
+    case req.RunAt != "" || req.Delay != "":
+        respondError(c, http.StatusBadRequest, "invalid_request", "Schedules run on their cron expression; runAt and delay are not supported")
+        return task.Schedule{}, false
+    }
+    _, opts, ok := h.validateTaskRequest(c, &req.TaskRequest)
+    if !ok {
+        return task.Schedule{}, false
+    }
+    spec := task.Schedule{
+        Name:       req.Name,
+        Cron:       req.Cron,
+        Timezone:   req.Timezone,
+        Paused:     req.Paused,
+        Command:    req.Command,
+        InputMedia: req.InputMedia,
+        OutputExt:  req.OutputExt,
+        Options:    opts,
+    }
+    if v, ok := c.Get(apiKeyKey); ok && strings.HasPrefix(v.(*auth.Key).ID, auth.JWTKeyPrefix) {
+        key := v.(*auth.Key)
+        spec.OwnerScopes, spec.OwnerExpiresAt = key.Scopes, key.ExpiresAt
+    }
+    return spec, true
+}
+
+// respondScheduleError maps the errors of schedule changes to responses.
+func respondScheduleError(c *gin.Context, message string, err error) {
+    switch {
+    case errors.Is(err, task.ErrScheduleNotFound):
+        respondError(c, http.StatusNotFound, "not_found", "Schedule not found")
+    case errors.Is(err, task.ErrInvalidSchedule):
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+    default:
+        respondSubmitError(c, message, err)
+    }
+}
+
+// checkScheduledRun checks every run of a schedule like a submission by its
+// owner, whose API key may have been revoked or used up its quota since the
+// schedule was created.
+func (h *Handler) checkScheduledRun(s *task.Schedule, opts *task.SubmitOptions) error {
+    if !h.cfg.AuthEnable || s.Owner == "" {
+        return nil
+    }
+    var key *auth.Key
+    switch {
+    case s.Owner == auth.LegacyKeyID:
+        if h.cfg.AuthKey == "" {
+            return errors.New("AUTH_KEY, which created the schedule, is no longer set")
+        }
+        key = &auth.Key{ID: auth.LegacyKeyID, Scopes: []string{auth.ScopeAdmin}}
+    case strings.HasPrefix(s.Owner, auth.JWTKeyPrefix):
+        // Tokens are not stored, only the scopes and expiry of the one that
+        // created or last replaced the schedule.
+        key = &auth.Key{ID: s.Owner, Scopes: s.OwnerScopes, ExpiresAt: s.OwnerExpiresAt}
+        if key.Expired(time.Now()) || !key.HasScope(auth.ScopeSubmit) {
+            return fmt.Errorf("the token of %s, which created the schedule, has expired or may not submit tasks; replace the schedule with a current token to resume it", s.Owner)
+        }
+    default:
+        var err error
+        if key, err = h.keys.Get(s.Owner); err != nil {
+            return fmt.Errorf("API key %s, which created the schedule, no longer exists", s.Owner)
+        }
+        if key.Expired(time.Now()) || !key.HasScope(auth.ScopeSubmit) {
+            return fmt.Errorf("API key %s, which created the schedule, may no longer submit tasks", s.Owner)
+        }
+    }
+    if !key.HasScope(auth.ScopeAdmin) && ffmpeg.InputScheme(s.InputMedia) == "" {
+        // LOCAL_INPUT_DIRS may have changed since.
+        if err := ffmpeg.CheckLocalInput(h.cfg, s.InputMedia); err != nil {
+            return err
+        }
+    }
+    if reason := h.exceededQuota(key, opts); reason != "" {
+        return errors.New(reason)
+    }
+    return nil
+}
+
+// handleCreateSchedule adds a recurring schedule. Each run submits a task
+// with the schedule's ID in "scheduleId".
+func (h *Handler) handleCreateSchedule(c *gin.Context) {
+    spec, ok := h.bindSchedule(c)
+    if !ok {
+        return
+    }
+    s, err := h.taskManager.CreateSchedule(spec)
+    if err != nil {
+        respondScheduleError(c, "Failed to create schedule", err)
+        return
+    }
+    c.JSON(http.StatusCreated, s)
+}
+
+// handleListSchedules lists the caller's schedules; admins see all of them.
+func (h *Handler) handleListSchedules(c *gin.Context) {
+    schedules := []*task.Schedule{}
+    for _, s := range h.taskManager.Schedules() {
+        if canSee(c, s.Owner) {
+            schedules = append(schedules, s)
+        }
+    }
+    c.JSON(http.StatusOK, schedulesDoc{Schedules: schedules})
+}
+
+// lookupSchedule returns the schedule of the request path, or writes a 404
+// if it does not exist or belongs to another key.
+func (h *Handler) lookupSchedule(c *gin.Context) (*task.Schedule, bool) {
+    s, found := h.taskManager.GetSchedule(c.Param("scheduleId"))
+    if !found || !canSee(c, s.Owner) {
+        respondError(c, http.StatusNotFound, "not_found", "Schedule not found")
+        return nil, false
+    }
+    return s, true
+}
+
+// handleGetSchedule reports a schedule with its next and last run.
+func (h *Handler) handleGetSchedule(c *gin.Context) {
+    if s, ok := h.lookupSchedule(c); ok {
+        c.JSON(http.StatusOK, s)
+    }
+}
+
+// handleUpdateSchedule replaces the cron expression and task of a schedule,
+// or pauses and resumes it with "paused".
+func (h *Handler) handleUpdateSchedule(c *gin.Context) {
+    old, ok := h.lookupSchedule(c)
+    if !ok {
+        return
+    }
+    spec, ok := h.bindSchedule(c)
+    if !ok {
+        return
+    }
+    s, err := h.taskManager.UpdateSchedule(old.ID, spec)
+    if err != nil {
+        respondScheduleError(c, "Failed to update schedule", err)
+        return
+    }
+    c.JSON(http.StatusOK, s)
+}
+
+// handleDeleteSchedule removes a schedule; the tasks of its runs are kept.
+func (h *Handler) handleDeleteSchedule(c *gin.Context) {
+    s, ok := h.lookupSchedule(c)
+    if !ok {
+        return
+    }
+    if err := h.taskManager.DeleteSchedule(s.ID); err != nil {
+        respondScheduleError(c, "Failed to delete schedule", err)
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Schedule deleted"})
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+    "net/http"
+    "reflect"
+    "strings"
+
+    "ffwebapi/task"
+    "github.com/gin-gonic/gin"
+)
+
+// schemaVersion is the version of the documents served by /schema. It is
+// bumped when a payload changes in a way strict clients would reject.
+const schemaVersion = "1.0.0"
+
+// v2ErrorDoc is the error envelope of v2Mapper.
+type v2ErrorDoc struct {
+    Error   errorBodyDoc `json:"error" binding:"required"`
+    Details string       `json:"details,omitempty"`
+}
+
+type errorBodyDoc struct {
+    Code    string `json:"code" binding:"required"`
+    Message string `json:"message" binding:"required"`
+}
+
+// schemaDocument builds the JSON Schema (draft 2020-12) document of the
+// payloads of one API version. Every schema lives under $defs, so clients
+// validate against e.g. "<$id>#/$defs/Task".
+func schemaDocument(baseURL string, v *apiVersion) gin.H {
+    g := &schemaGenerator{schemas: gin.H{}, refPrefix: "#/$defs/"}
+    g.schema(reflect.TypeOf(task.Task{}))
+    g.schema(reflect.TypeOf(TaskRequest{}))
+    g.schema(reflect.TypeOf(acceptedTaskDoc{}))
+    g.define("Error", v.mapper.ErrorSchema())
+
+    // Named string types are inlined where used; they are listed here too so
+    // clients can generate typed enums from them.
+    g.schemas["TaskStatus"] = g.schema(reflect.TypeOf(task.Status("")))
+    g.schemas["Priority"] = g.schema(reflect.TypeOf(task.Priority("")))
+
+    // Callback URLs receive the task itself once it is terminal.
+    completed := g.schema(reflect.TypeOf(task.Task{}))
+    completed["description"] = "POSTed to the callbackUrl of a task once it reaches a terminal status"
+    g.schemas["TaskCompletedEvent"] = completed
+
+    id := strings.TrimSuffix(baseURL, "/") + v.basePath() + "/schema"
+    return gin.H{
+        "$schema": "https://json-schema.org/draft/2020-12/schema",
+        "$id":     id,
+        "title":   "FFwebAPI " + v.Name + " payloads",
+        "version": schemaVersion,
+        "$defs":   g.schemas,
+    }
+}
+
+// handleGetSchema serves the JSON Schemas of the request's API version.
+func (h *Handler) handleGetSchema(c *gin.Context) {
+    c.Header("Content-Type", "application/schema+json")
+    c.JSON(http.StatusOK, schemaDocument(h.cfg.BaseURL, versionOf(c)))
+}
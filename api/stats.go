@@ -0,0 +1,46 @@
+package api
+
+import (
+    "fmt"
+    "net/http"
+    "time"
+
+    "ffwebapi/task"
+    "github.com/gin-gonic/gin"
+)
+
+// Default time ranges of stats requests without "from".
+var defaultStatsRange = map[string]time.Duration{
+    task.StatsHourly: 24 * time.Hour,
+    task.StatsDaily:  30 * 24 * time.Hour,
+}
+
+// handleGetStats reports task throughput over a time range, bucketed by hour
+// or day. "from" and "to" are RFC 3339 times; "to" defaults to now and "from"
+// to a day (hourly) or 30 days (daily) before it.
+func (h *Handler) handleGetStats(c *gin.Context) {
+    interval := c.DefaultQuery("interval", task.StatsHourly)
+    to, err := parseStatsTime(c.Query("to"), time.Now())
+    if err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid to: %v", err))
+        return
+    }
+    from, err := parseStatsTime(c.Query("from"), to.Add(-defaultStatsRange[interval]))
+    if err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid from: %v", err))
+        return
+    }
+    report, err := h.taskManager.Stats(from, to, interval)
+    if err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+    c.JSON(http.StatusOK, report)
+}
+
+func parseStatsTime(value string, fallback time.Time) (time.Time, error) {
+    if value == "" {
+        return fallback, nil
+    }
+    return time.Parse(time.RFC3339, value)
+}
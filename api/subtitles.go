@@ -0,0 +1,83 @@
+package api
+
+import (
+    "fmt"
+    "net/http"
+    "regexp"
+
+    "ffwebapi/task"
+    "github.com/gin-gonic/gin"
+)
+
+// subtitleAudioCommand prepares the audio speech-to-text backends expect.
+const subtitleAudioCommand = "-i ${INPUT_MEDIA} -vn -ac 1 -ar 16000 -c:a pcm_s16le"
+
+var languageRe = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z]{2})?$`)
+
+type SubtitleRequest struct {
+    InputMedia   string `json:"inputMedia" binding:"required"`
+    Format       string `json:"format"`   // "srt" (default) or "vtt"
+    Language     string `json:"language"` // e.g. "en"; detected if empty
+    Priority     string `json:"priority"`
+    Queue        string `json:"queue"`
+    MaxRetries   int    `json:"maxRetries"`
+    RetryBackoff string `json:"retryBackoff"`
+    CallbackURL  string `json:"callbackUrl"`
+    InlineResult bool   `json:"inlineResult"`
+}
+
+// handleCreateSubtitles transcribes an input without transcoding it; the
+// subtitle file is the task's output.
+func (h *Handler) handleCreateSubtitles(c *gin.Context) {
+    var req SubtitleRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+    if req.Format == "" {
+        req.Format = "srt"
+    }
+
+    var opts task.SubmitOptions
+    optsReq := TaskRequest{
+        InputMedia:       req.InputMedia,
+        Priority:         req.Priority,
+        Queue:            req.Queue,
+        MaxRetries:       req.MaxRetries,
+        RetryBackoff:     req.RetryBackoff,
+        CallbackURL:      req.CallbackURL,
+        InlineResult:     req.InlineResult,
+        Subtitles:        req.Format,
+        SubtitleLanguage: req.Language,
+    }
+    if !h.validateSubmitOptions(c, &optsReq, &opts) {
+        return
+    }
+    opts.SubtitlesOnly = true
+
+    t, err := h.taskManager.SubmitWithOptions(subtitleAudioCommand, req.InputMedia, "wav", opts)
+    if err != nil {
+        respondSubmitError(c, "Failed to create task", err)
+        return
+    }
+    h.setPollHints(c)
+    c.JSON(http.StatusAccepted, acceptedTask(t))
+}
+
+// validateSubtitles checks a subtitle request against the configured
+// speech-to-text backend. On failure it writes a 400 response and returns false.
+func (h *Handler) validateSubtitles(c *gin.Context, format, language string) bool {
+    if h.cfg.STTURL == "" && h.cfg.STTCommand == "" {
+        respondError(c, http.StatusBadRequest, "stt_unavailable", "Subtitle generation is not configured on this server")
+        return false
+    }
+    if format != "srt" && format != "vtt" {
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid subtitle format %q (want \"srt\" or \"vtt\")", format))
+        return false
+    }
+    if language != "" && !languageRe.MatchString(language) {
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid subtitle language %q", language))
+        return false
+    }
+    return true
+}
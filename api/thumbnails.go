@@ -0,0 +1,90 @@
+package api
+
+import (
+    "fmt"
+    "net/http"
+    "time"
+
+    "ffwebapi/ffmpeg"
+    "ffwebapi/task"
+    "github.com/gin-gonic/gin"
+)
+
+type ThumbnailRequest struct {
+    InputMedia    string    `json:"inputMedia" binding:"required"`
+    Timestamps    []float64 `json:"timestamps"` // Seconds into the input
+    Interval      float64   `json:"interval"`   // Seconds between frames; alternative to timestamps
+    Count         int       `json:"count"`      // Max number of frames
+    Width         int       `json:"width"`
+    Height        int       `json:"height"`     // Tile height of sprites
+    Format        string    `json:"format"`     // jpg (default), png or webp
+    Sprite        bool      `json:"sprite"`     // Tile the frames into sprite.<format> plus thumbnails.vtt
+    SpriteColumns int       `json:"spriteColumns"`
+    Priority      string    `json:"priority"`
+    Queue         string    `json:"queue"`
+    MaxRetries    int       `json:"maxRetries"`
+    RetryBackoff  string    `json:"retryBackoff"`
+    CallbackURL   string    `json:"callbackUrl"`
+    InlineResult  bool      `json:"inlineResult"` // Embed a small sprite or first frame in "resultData"
+}
+
+// handleCreateThumbnails extracts frames at timestamps or intervals, optionally
+// tiled into a sprite sheet with a WebVTT cue file for player previews. The
+// images are written into the task's output directory.
+func (h *Handler) handleCreateThumbnails(c *gin.Context) {
+    var req ThumbnailRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+
+    spec := ffmpeg.ThumbnailSpec{
+        Interval:      seconds(req.Interval),
+        Count:         req.Count,
+        Width:         req.Width,
+        Height:        req.Height,
+        Format:        req.Format,
+        Sprite:        req.Sprite,
+        SpriteColumns: req.SpriteColumns,
+    }
+    for _, ts := range req.Timestamps {
+        spec.Timestamps = append(spec.Timestamps, seconds(ts))
+    }
+    job, err := ffmpeg.BuildThumbnailCommand(spec)
+    if err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid thumbnail request: %v", err))
+        return
+    }
+
+    // The command is built by the server, so only the options need checking.
+    var opts task.SubmitOptions
+    optsReq := TaskRequest{
+        InputMedia:   req.InputMedia,
+        Priority:     req.Priority,
+        Queue:        req.Queue,
+        MaxRetries:   req.MaxRetries,
+        RetryBackoff: req.RetryBackoff,
+        CallbackURL:  req.CallbackURL,
+        InlineResult: req.InlineResult,
+    }
+    if !h.validateSubmitOptions(c, &optsReq, &opts) {
+        return
+    }
+    opts.OutputMode = task.OutputModeDirectory
+    opts.OutputEntry = job.Entry
+    opts.ExtraFiles = job.ExtraFiles
+    opts.ArtifactKind = task.ArtifactThumbnail
+
+    t, err := h.taskManager.SubmitWithOptions(job.Command, req.InputMedia, job.OutputExt, opts)
+    if err != nil {
+        respondSubmitError(c, "Failed to create task", err)
+        return
+    }
+    h.setPollHints(c)
+    c.JSON(http.StatusAccepted, acceptedTask(t))
+}
+
+// seconds converts fractional seconds from a request into a duration.
+func seconds(s float64) time.Duration {
+    return time.Duration(s * float64(time.Second))
+}
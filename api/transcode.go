@@ -0,0 +1,85 @@
+package api
+
+import (
+    "fmt"
+    "net/http"
+    "time"
+
+    "ffwebapi/ffmpeg"
+    "ffwebapi/task"
+    "github.com/gin-gonic/gin"
+)
+
+type RenditionRequest struct {
+    Name         string `json:"name" binding:"required"`
+    Height       int    `json:"height" binding:"required"`
+    VideoBitrate string `json:"videoBitrate" binding:"required"` // e.g. "2800k"
+    AudioBitrate string `json:"audioBitrate"`                    // Defaults to "128k"
+}
+
+type ABRRequest struct {
+    InputMedia      string             `json:"inputMedia" binding:"required"`
+    Format          string             `json:"format"`          // "hls" (default) or "dash"
+    Renditions      []RenditionRequest `json:"renditions" binding:"required,min=1,dive"`
+    SegmentDuration int                `json:"segmentDuration"` // Seconds; defaults to 6
+    Priority        string             `json:"priority"`
+    Queue           string             `json:"queue"`
+    MaxRetries      int                `json:"maxRetries"`
+    RetryBackoff    string             `json:"retryBackoff"`
+    CallbackURL     string             `json:"callbackUrl"`
+}
+
+// handleCreateABR transcodes an input into an adaptive bitrate ladder and
+// packages it as HLS or DASH. The task's download URL points at the master
+// playlist; progress is reported per rendition.
+func (h *Handler) handleCreateABR(c *gin.Context) {
+    var req ABRRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+
+    renditions := make([]ffmpeg.Rendition, 0, len(req.Renditions))
+    for _, r := range req.Renditions {
+        renditions = append(renditions, ffmpeg.Rendition{
+            Name:         r.Name,
+            Height:       r.Height,
+            VideoBitrate: r.VideoBitrate,
+            AudioBitrate: r.AudioBitrate,
+        })
+    }
+    segmentDuration := 6 * time.Second
+    if req.SegmentDuration != 0 {
+        segmentDuration = time.Duration(req.SegmentDuration) * time.Second
+    }
+    job, err := ffmpeg.BuildABRCommand(req.Format, renditions, segmentDuration)
+    if err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Invalid ABR request: %v", err))
+        return
+    }
+
+    // The command is built by the server, so only the options need checking.
+    var opts task.SubmitOptions
+    optsReq := TaskRequest{
+        InputMedia:   req.InputMedia,
+        Priority:     req.Priority,
+        Queue:        req.Queue,
+        MaxRetries:   req.MaxRetries,
+        RetryBackoff: req.RetryBackoff,
+        CallbackURL:  req.CallbackURL,
+    }
+    if !h.validateSubmitOptions(c, &optsReq, &opts) {
+        return
+    }
+    opts.OutputMode = task.OutputModeDirectory
+    opts.OutputEntry = job.Entry
+    opts.Renditions = job.Renditions
+
+    t, err := h.taskManager.SubmitWithOptions(job.Command, req.InputMedia, job.OutputExt, opts)
+    if err != nil {
+        respondSubmitError(c, "Failed to create task", err)
+        return
+    }
+    h.setPollHints(c)
+    c.JSON(http.StatusAccepted, acceptedTask(t))
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "strconv"
+
+    "ffwebapi/ffmpeg"
+    "ffwebapi/logging"
+    "ffwebapi/netguard"
+    "ffwebapi/preset"
+    "ffwebapi/task"
+    "ffwebapi/utils"
+    "github.com/gin-gonic/gin"
+)
+
+// handleTransform fetches src, applies a preset and answers with the result,
+// like an image proxy. Results are cached for TRANSFORM_CACHE_TTL and marked
+// cacheable, so the endpoint can serve previews behind a CDN. Transforms that
+// take longer than SYNC_TIMEOUT answer 503 and keep running; the result is
+// served from the cache on a later request. Image and audio presets honor the
+// Accept header, e.g. to serve WebP to browsers that support it.
+func (h *Handler) handleTransform(c *gin.Context) {
+    src := c.Query("src")
+    if !netguard.IsURL(src) {
+        respondError(c, http.StatusBadRequest, "invalid_request", "src must be an http(s) URL")
+        return
+    }
+    p, ok := preset.Lookup(c.Query("preset"))
+    if !ok {
+        respondError(c, http.StatusBadRequest, "invalid_request", fmt.Sprintf("Unknown preset %q", c.Query("preset")))
+        return
+    }
+
+    req := TaskRequest{Command: p.Command, InputMedia: src, OutputExt: p.OutputExt}
+    negotiateOutput(c, &req)
+    _, opts, ok := h.validateTaskRequest(c, &req)
+    if !ok {
+        return
+    }
+    opts.Preset = p.Name
+
+    ctx, cancel := context.WithTimeout(c.Request.Context(), h.cfg.SyncTimeout)
+    defer cancel()
+
+    res, err := h.taskManager.Transform(ctx, req.Command, req.InputMedia, req.OutputExt, opts)
+    if err != nil {
+        respondSubmitError(c, "Failed to run transform", err)
+        return
+    }
+    if res.Task != nil {
+        res.Task = res.Task.Snapshot() // The stored task may still be running
+        c.Header("X-Task-Id", res.Task.ID)
+    }
+
+    etag := `"` + res.Key + `"`
+    switch {
+    case res.Path != "":
+        c.Header("ETag", etag)
+        c.Header("Cache-Control", "public, max-age="+strconv.Itoa(int(h.cfg.TransformCacheTTL.Seconds())))
+        if res.Cached {
+            c.Header("X-Cache", "HIT")
+        } else {
+            c.Header("X-Cache", "MISS")
+        }
+        if c.GetHeader("If-None-Match") == etag {
+            c.Status(http.StatusNotModified)
+            return
+        }
+        if contentType := utils.ContentTypeOf(res.Path); contentType != "" {
+            c.Header("Content-Type", contentType)
+        }
+        c.File(res.Path)
+    case res.Task != nil && res.Task.Status.IsTerminal():
+        c.Header("Cache-Control", "no-store")
+        respondError(c, http.StatusUnprocessableEntity, "task_failed", res.Task.Error)
+    default:
+        logging.FromContext(c.Request.Context()).Info("Transform did not finish in time, continuing asynchronously", "timeout", h.cfg.SyncTimeout)
+        c.Header("Cache-Control", "no-store")
+        c.Header("Retry-After", strconv.Itoa(int(minPollInterval.Seconds())))
+        respondError(c, http.StatusServiceUnavailable, "transform_pending", "The result is not ready yet, retry later")
+    }
+}
+
+// negotiateOutput switches a single-output image or audio request to the
+// format the Accept header prefers. Such responses vary by Accept, which
+// caches in front of the server need to know.
+func negotiateOutput(c *gin.Context, req *TaskRequest) {
+    if len(req.Outputs) > 0 || req.OutputMode == task.OutputModeDirectory || utils.MediaKindOf(req.OutputExt) == utils.MediaKindVideo {
+        return
+    }
+    c.Header("Vary", "Accept")
+    args, err := ffmpeg.SplitCommand(req.Command)
+    if err != nil {
+        return // Reported by validateTaskRequest
+    }
+    if args, ext := ffmpeg.NegotiateOutput(args, req.OutputExt, c.GetHeader("Accept")); ext != req.OutputExt {
+        req.Command, req.OutputExt = ffmpeg.JoinCommand(args), ext
+    }
+}
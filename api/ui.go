@@ -0,0 +1,45 @@
+package api
+
+import (
+    "embed"
+    "io/fs"
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+)
+
+// uiFiles is the web dashboard: a single page calling the v2 API with an
+// API key entered in the browser, so it needs no session of its own.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// handleUI serves the dashboard's files under /ui/.
+func handleUI() gin.HandlerFunc {
+    // Uploads may go straight to the S3 bucket, on another origin.
+    return serveEmbedded(uiFiles, "ui", "default-src 'self'; connect-src *; frame-ancestors 'none'")
+}
+
+// serveEmbedded serves the embedded directory dir under /<dir>/, with the
+// given Content-Security-Policy.
+func serveEmbedded(files embed.FS, dir, csp string) gin.HandlerFunc {
+    sub, err := fs.Sub(files, dir)
+    if err != nil {
+        panic(err) // The directory is embedded at build time
+    }
+    server := http.StripPrefix("/"+dir, http.FileServer(http.FS(sub)))
+    return func(c *gin.Context) {
+        c.Header("Cache-Control", "no-cache")
+        c.Header("Content-Security-Policy", csp)
+        server.ServeHTTP(c.Writer, c.Request)
+    }
+}
+
+// redirectToDir redirects a page's path to its directory, e.g. /ui to /ui/,
+// so the relative URLs in it resolve.
+func redirectToDir(dir string) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        c.Header("Location", dir+"/") // Relative, for servers mounted under a prefix
+        c.Status(http.StatusMovedPermanently)
+    }
+}
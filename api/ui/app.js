@@ -0,0 +1,315 @@
+// FFwebAPI dashboard: lists the tasks the API key may see, follows them over
+// GET /tasks/events, and submits, cancels and retries them through the v2 API.
+// URLs are relative to the page, so the dashboard also works when the server
+// is mounted under a path prefix.
+"use strict";
+
+const API = "../api/v2";
+const UNFINISHED = ["queued", "processing", "scheduled", "waiting", "waiting_resources"];
+const RETRYABLE = ["failed", "canceled", "skipped"];
+
+const state = {
+  key: sessionStorage.getItem("ffwebapi.key") || "",
+  tasks: new Map(), // By ID
+  presets: [],
+  cursor: null,
+  selected: null,
+  inputId: null, // Uploaded input the next task reads
+  stream: null, // AbortController of the event stream
+};
+
+const $ = (id) => document.getElementById(id);
+
+// api calls the API with the key and returns the decoded JSON body, throwing
+// the message of the error envelope on failure.
+async function api(method, path, body) {
+  const headers = {};
+  if (state.key) headers.Authorization = "Bearer " + state.key;
+  if (body !== undefined) headers["Content-Type"] = "application/json";
+  const resp = await fetch(API + path, {
+    method,
+    headers,
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  const data = await resp.json().catch(() => null);
+  if (!resp.ok) {
+    const err = data && data.error;
+    throw new Error((err && (err.message || err)) || resp.status + " " + resp.statusText);
+  }
+  return data;
+}
+
+// --- Tasks ---
+
+async function loadTasks(more) {
+  const query = "?limit=50" + (more && state.cursor ? "&cursor=" + encodeURIComponent(state.cursor) : "");
+  const page = await api("GET", "/tasks" + query);
+  for (const t of page.tasks) state.tasks.set(t.id, t);
+  state.cursor = page.nextCursor;
+  $("more").hidden = !state.cursor;
+  render();
+}
+
+function render() {
+  const rows = $("task-rows");
+  const tasks = [...state.tasks.values()].sort((a, b) => b.createdAt.localeCompare(a.createdAt));
+  rows.replaceChildren(...tasks.map(row));
+}
+
+function row(t) {
+  const tr = document.createElement("tr");
+  tr.classList.toggle("selected", t.id === state.selected);
+  tr.addEventListener("click", () => select(t.id));
+
+  const status = document.createElement("span");
+  status.className = "status " + t.status;
+  status.textContent = t.status.replace("_", " ");
+  const progress = document.createElement("td");
+  if (t.status === "processing") {
+    const bar = document.createElement("progress");
+    bar.max = 100;
+    bar.value = t.percent || 0;
+    progress.append(bar, " " + Math.round(t.percent || 0) + "%" + (t.eta ? " · " + formatETA(t.eta) : ""));
+  } else if (t.status === "queued" && t.queuePosition) {
+    progress.textContent = "#" + t.queuePosition + " in queue";
+  }
+
+  const actions = document.createElement("td");
+  actions.className = "actions";
+  if (UNFINISHED.includes(t.status)) actions.append(button("Cancel", () => cancel(t.id)));
+  if (RETRYABLE.includes(t.status) && !t.pipelineId) actions.append(button("Retry", () => retry(t.id)));
+  if (t.status.startsWith("completed")) actions.append(button("Download", () => download(t.id)));
+
+  tr.append(cell(t.id), cell(t.preset || ""), cell(status), progress, cell(new Date(t.createdAt).toLocaleString()), actions);
+  return tr;
+}
+
+function cell(content) {
+  const td = document.createElement("td");
+  td.append(content);
+  return td;
+}
+
+function button(label, onClick) {
+  const b = document.createElement("button");
+  b.textContent = label;
+  b.addEventListener("click", (e) => {
+    e.stopPropagation();
+    onClick().catch((err) => alert(label + " failed: " + err.message));
+  });
+  return b;
+}
+
+function formatETA(seconds) {
+  const s = Math.round(seconds);
+  return s < 60 ? s + "s left" : Math.floor(s / 60) + "m " + (s % 60) + "s left";
+}
+
+async function cancel(id) {
+  await api("PATCH", "/tasks/" + id + "/cancel");
+}
+
+async function retry(id) {
+  const accepted = await api("POST", "/tasks/" + id + "/retry");
+  await show(accepted.taskId);
+}
+
+// show selects a task just submitted, before the event stream reports it.
+async function show(id) {
+  state.tasks.set(id, await api("GET", "/tasks/" + id));
+  select(id);
+}
+
+async function download(id) {
+  const signed = await api("POST", "/tasks/" + id + "/download-url");
+  window.location.href = signed.url;
+}
+
+// --- Live updates ---
+
+// follow reads GET /tasks/events. EventSource cannot send the Authorization
+// header, so the stream is read with fetch and parsed here.
+async function follow() {
+  if (state.stream) state.stream.abort();
+  const ctl = new AbortController();
+  state.stream = ctl;
+  const headers = state.key ? { Authorization: "Bearer " + state.key } : {};
+  try {
+    const resp = await fetch(API + "/tasks/events", { headers, signal: ctl.signal });
+    if (!resp.ok) throw new Error(resp.statusText);
+    setLive(true);
+    const reader = resp.body.pipeThrough(new TextDecoderStream()).getReader();
+    let buf = "";
+    for (;;) {
+      const { value, done } = await reader.read();
+      if (done) break;
+      buf += value;
+      let end;
+      while ((end = buf.indexOf("\n\n")) >= 0) {
+        handleEvent(buf.slice(0, end));
+        buf = buf.slice(end + 2);
+      }
+    }
+  } catch (err) {
+    if (ctl.signal.aborted) return;
+  }
+  setLive(false);
+  setTimeout(follow, 3000);
+}
+
+function handleEvent(raw) {
+  let type = "message";
+  let data = "";
+  for (const line of raw.split("\n")) {
+    if (line.startsWith("event:")) type = line.slice(6).trim();
+    else if (line.startsWith("data:")) data += line.slice(5);
+  }
+  const payload = JSON.parse(data);
+  if (type === "status") {
+    state.tasks.set(payload.id, payload);
+  } else if (type === "deleted") {
+    state.tasks.delete(payload.id);
+    if (state.selected === payload.id) select(null);
+  }
+  render();
+}
+
+function setLive(on) {
+  $("live").textContent = on ? "live" : "offline";
+  $("live").classList.toggle("on", on);
+}
+
+// --- Details and logs ---
+
+let logTimer = null;
+
+function select(id) {
+  state.selected = id;
+  clearTimeout(logTimer);
+  $("details").hidden = !id;
+  render();
+  if (id) showLog();
+}
+
+async function showLog() {
+  const id = state.selected;
+  const t = state.tasks.get(id);
+  $("details-id").textContent = id;
+  $("details-error").textContent = (t && (t.error || t.lastError)) || "";
+  try {
+    const page = await api("GET", "/tasks/" + id + "/logs?tail=200");
+    if (state.selected !== id) return;
+    const log = $("log");
+    const atBottom = log.scrollTop + log.clientHeight >= log.scrollHeight - 4;
+    log.textContent = page.lines.join("\n");
+    if (atBottom) log.scrollTop = log.scrollHeight;
+  } catch (err) {
+    $("log").textContent = "No log: " + err.message;
+  }
+  if (t && UNFINISHED.includes(t.status)) logTimer = setTimeout(showLog, 2000);
+}
+
+// --- Submission ---
+
+async function loadPresets() {
+  state.presets = await api("GET", "/presets");
+  $("preset").replaceChildren(
+    ...state.presets.map((p) => {
+      const o = document.createElement("option");
+      o.value = p.name;
+      o.textContent = p.name;
+      return o;
+    }),
+  );
+  describePreset();
+}
+
+function describePreset() {
+  const p = state.presets.find((p) => p.name === $("preset").value);
+  $("preset-description").textContent = p ? p.description : "";
+}
+
+async function submit(e) {
+  e.preventDefault();
+  $("submit-error").textContent = "";
+  const p = state.presets.find((p) => p.name === $("preset").value);
+  const req = { command: p.command, outputExt: p.outputExt };
+  if (state.inputId) req.inputId = state.inputId;
+  else req.inputMedia = $("input-url").value;
+  try {
+    const accepted = await api("POST", "/tasks", req);
+    state.inputId = null;
+    $("drop-label").firstChild.textContent = "Drop a file here or ";
+    $("input-url").disabled = false;
+    await show(accepted.taskId);
+  } catch (err) {
+    $("submit-error").textContent = err.message;
+  }
+}
+
+// upload reserves an input and PUTs the file to its signed URL, which needs
+// no API key.
+async function upload(file) {
+  $("submit-error").textContent = "";
+  const bar = $("upload-progress");
+  try {
+    const input = await api("POST", "/inputs", { size: file.size, contentType: file.type });
+    bar.hidden = false;
+    await new Promise((resolve, reject) => {
+      const xhr = new XMLHttpRequest(); // fetch cannot report upload progress
+      xhr.open(input.uploadMethod, input.uploadUrl);
+      xhr.upload.onprogress = (e) => (bar.value = e.loaded / e.total);
+      xhr.onload = () => (xhr.status < 300 ? resolve() : reject(new Error("upload: " + xhr.status)));
+      xhr.onerror = () => reject(new Error("upload failed"));
+      xhr.send(file);
+    });
+    state.inputId = input.inputId;
+    $("drop-label").firstChild.textContent = "Uploaded " + file.name + ". Drop another file or ";
+    $("input-url").disabled = true;
+  } catch (err) {
+    $("submit-error").textContent = err.message;
+  } finally {
+    bar.hidden = true;
+    bar.value = 0;
+  }
+}
+
+// --- Startup ---
+
+async function start() {
+  $("key").value = state.key;
+  state.tasks.clear();
+  render();
+  try {
+    await Promise.all([loadPresets(), loadTasks(false)]);
+  } catch (err) {
+    $("submit-error").textContent = err.message;
+    return;
+  }
+  follow();
+}
+
+$("key-form").addEventListener("submit", (e) => {
+  e.preventDefault();
+  state.key = $("key").value.trim();
+  sessionStorage.setItem("ffwebapi.key", state.key);
+  start();
+});
+$("more").addEventListener("click", () => loadTasks(true));
+$("preset").addEventListener("change", describePreset);
+$("task-form").addEventListener("submit", submit);
+$("file").addEventListener("change", (e) => e.target.files[0] && upload(e.target.files[0]));
+
+const drop = $("drop");
+drop.addEventListener("dragover", (e) => {
+  e.preventDefault();
+  drop.classList.add("over");
+});
+drop.addEventListener("dragleave", () => drop.classList.remove("over"));
+drop.addEventListener("drop", (e) => {
+  e.preventDefault();
+  drop.classList.remove("over");
+  if (e.dataTransfer.files[0]) upload(e.dataTransfer.files[0]);
+});
+
+start();
@@ -0,0 +1,174 @@
+package api
+
+import (
+    "encoding/base64"
+    "errors"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "ffwebapi/task"
+    "github.com/gin-gonic/gin"
+)
+
+// tusVersion is the version of the tus resumable upload protocol served
+// under /uploads, with the creation, termination and expiration extensions.
+const tusVersion = "1.0.0"
+
+// tusMiddleware checks the Tus-Resumable header every tus request but
+// OPTIONS carries, and sets it on the responses.
+func tusMiddleware(c *gin.Context) {
+    c.Header("Tus-Resumable", tusVersion)
+    if c.Request.Method != http.MethodOptions && c.GetHeader("Tus-Resumable") != tusVersion {
+        c.Header("Tus-Version", tusVersion)
+        respondError(c, http.StatusPreconditionFailed, "unsupported_tus_version", "Tus-Resumable must be "+tusVersion)
+        c.Abort()
+        return
+    }
+    c.Next()
+}
+
+// handleTusOptions tells tus clients what the server supports.
+func (h *Handler) handleTusOptions(c *gin.Context) {
+    c.Header("Tus-Version", tusVersion)
+    c.Header("Tus-Extension", "creation,termination,expiration")
+    c.Header("Tus-Max-Size", strconv.FormatInt(h.cfg.MaxInputSize, 10))
+    c.Status(http.StatusNoContent)
+}
+
+// handleCreateUpload starts a resumable upload of Upload-Length bytes. The
+// upload ID is an input ID: once complete, tasks reference it with
+// "inputId" like any other uploaded input.
+func (h *Handler) handleCreateUpload(c *gin.Context) {
+    if c.GetHeader("Upload-Defer-Length") != "" {
+        respondError(c, http.StatusBadRequest, "invalid_request", "Upload-Defer-Length is not supported; send Upload-Length")
+        return
+    }
+    size, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+    if err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", "Upload-Length must be a number of bytes")
+        return
+    }
+    if size > h.cfg.MaxInputSize {
+        respondError(c, http.StatusRequestEntityTooLarge, "input_too_large", "Upload-Length exceeds the maximum of "+strconv.FormatInt(h.cfg.MaxInputSize, 10)+" bytes")
+        return
+    }
+    metadata, err := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+    if err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+
+    in, err := h.taskManager.CreateResumableUpload(clientOf(c), size, metadata["filetype"])
+    if errors.Is(err, task.ErrQuotaExceeded) {
+        respondError(c, http.StatusTooManyRequests, "quota_exceeded", err.Error())
+        return
+    }
+    if err != nil {
+        respondError(c, http.StatusBadRequest, "invalid_request", err.Error())
+        return
+    }
+    c.Header("Location", h.baseURL(c)+versionOf(c).basePath()+"/uploads/"+in.ID)
+    c.Header("Upload-Expires", in.ExpiresAt.UTC().Format(http.TimeFormat))
+    c.JSON(http.StatusCreated, in)
+}
+
+// handleHeadUpload reports how many bytes of an upload were received, which
+// is where an interrupted client resumes.
+func (h *Handler) handleHeadUpload(c *gin.Context) {
+    in, ok := h.upload(c)
+    if !ok {
+        c.Status(http.StatusNotFound)
+        return
+    }
+    c.Header("Cache-Control", "no-store")
+    c.Header("Upload-Offset", strconv.FormatInt(h.taskManager.UploadOffset(in), 10))
+    c.Header("Upload-Length", strconv.FormatInt(in.MaxSize, 10))
+    c.Header("Upload-Expires", in.ExpiresAt.UTC().Format(http.TimeFormat))
+    c.Status(http.StatusOK)
+}
+
+// handlePatchUpload appends a chunk at Upload-Offset.
+func (h *Handler) handlePatchUpload(c *gin.Context) {
+    if c.ContentType() != "application/offset+octet-stream" {
+        respondError(c, http.StatusUnsupportedMediaType, "invalid_content_type", "Content-Type must be application/offset+octet-stream")
+        return
+    }
+    offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+    if err != nil || offset < 0 {
+        respondError(c, http.StatusBadRequest, "invalid_request", "Upload-Offset must be a number of bytes")
+        return
+    }
+    in, ok := h.upload(c)
+    if !ok {
+        respondInputError(c, task.ErrInputNotFound)
+        return
+    }
+    if c.Request.ContentLength > in.MaxSize-offset {
+        respondError(c, http.StatusRequestEntityTooLarge, "input_too_large", "The chunk runs past Upload-Length")
+        return
+    }
+
+    in, err = h.taskManager.AppendUpload(c.Request.Context(), in.ID, offset, c.Request.Body)
+    if err != nil {
+        respondUploadError(c, err)
+        return
+    }
+    c.Header("Upload-Offset", strconv.FormatInt(h.taskManager.UploadOffset(in), 10))
+    c.Header("Upload-Expires", in.ExpiresAt.UTC().Format(http.TimeFormat))
+    c.Status(http.StatusNoContent)
+}
+
+// handleDeleteUpload terminates an upload, e.g. one the client gave up on.
+func (h *Handler) handleDeleteUpload(c *gin.Context) {
+    in, ok := h.upload(c)
+    if !ok {
+        respondInputError(c, task.ErrInputNotFound)
+        return
+    }
+    if err := h.taskManager.DeleteUpload(c.Request.Context(), in.ID); err != nil {
+        respondUploadError(c, err)
+        return
+    }
+    c.Status(http.StatusNoContent)
+}
+
+// upload looks up the resumable upload of the request's :uploadId.
+func (h *Handler) upload(c *gin.Context) (*task.Input, bool) {
+    in, ok := h.taskManager.GetInput(c.Param("uploadId"))
+    if !ok || !in.Resumable || !h.ownsInput(c, in) {
+        return nil, false
+    }
+    return in, true
+}
+
+// parseUploadMetadata decodes Upload-Metadata: comma-separated keys, each
+// followed by its base64 encoded value, if any.
+func parseUploadMetadata(header string) (map[string]string, error) {
+    metadata := make(map[string]string)
+    for _, pair := range strings.Split(header, ",") {
+        key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+        if key == "" {
+            continue
+        }
+        decoded, err := base64.StdEncoding.DecodeString(value)
+        if err != nil {
+            return nil, errors.New("Upload-Metadata: value of " + key + " is not base64")
+        }
+        metadata[key] = string(decoded)
+    }
+    return metadata, nil
+}
+
+func respondUploadError(c *gin.Context, err error) {
+    switch {
+    case errors.Is(err, task.ErrUploadOffsetMismatch):
+        respondError(c, http.StatusConflict, "upload_offset_mismatch", err.Error())
+    case errors.Is(err, task.ErrUploadLocked):
+        respondError(c, http.StatusLocked, "upload_locked", err.Error())
+    case errors.Is(err, task.ErrUploadInUse):
+        respondError(c, http.StatusConflict, "input_in_use", err.Error())
+    default:
+        respondInputError(c, err)
+    }
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "ffwebapi/auth"
+    "ffwebapi/task"
+    "github.com/gin-gonic/gin"
+)
+
+// UsageReport is an API key's current usage next to its quota.
+type UsageReport struct {
+    task.Usage
+    Quota auth.Quota `json:"quota"` // Zero limits are unlimited
+}
+
+// checkQuota applies the quota of the requesting key to a submission. Tasks
+// over the running limit are queued until the key's earlier tasks finish;
+// submissions beyond the storage or monthly CPU limit are rejected with 429.
+func (h *Handler) checkQuota(c *gin.Context, key *auth.Key, opts *task.SubmitOptions) bool {
+    if reason := h.exceededQuota(key, opts); reason != "" {
+        respondError(c, http.StatusTooManyRequests, "quota_exceeded", reason)
+        return false
+    }
+    return true
+}
+
+// exceededQuota sets the running limit of key's quota in opts and tells why
+// the key may not submit more, or returns "" if it may.
+func (h *Handler) exceededQuota(key *auth.Key, opts *task.SubmitOptions) string {
+    quota := h.keys.QuotaOf(key)
+    opts.MaxRunning = quota.MaxRunning
+    if quota.MaxStorage <= 0 && quota.MonthlyCPUSeconds <= 0 {
+        return ""
+    }
+    usage := h.taskManager.Usage(key.ID, time.Now())
+    switch {
+    case quota.MaxStorage > 0 && usage.StorageBytes >= quota.MaxStorage:
+        return fmt.Sprintf("Storage quota of %d bytes is used up; delete outputs to submit more", quota.MaxStorage)
+    case quota.MonthlyCPUSeconds > 0 && usage.CPUSeconds >= quota.MonthlyCPUSeconds:
+        return fmt.Sprintf("Monthly CPU quota of %gs is used up", quota.MonthlyCPUSeconds)
+    }
+    return ""
+}
+
+// handleGetUsage reports the running and queued tasks, stored outputs and
+// this month's CPU time of the calling key, with its quota. Admins can ask
+// for another key with "keyId".
+func (h *Handler) handleGetUsage(c *gin.Context) {
+    var key *auth.Key
+    if v, ok := c.Get(apiKeyKey); ok {
+        key = v.(*auth.Key)
+    }
+    if id := c.Query("keyId"); id != "" && (key == nil || id != key.ID) {
+        if key != nil && !key.HasScope(auth.ScopeAdmin) {
+            respondError(c, http.StatusForbidden, "forbidden", "Only admin keys can see the usage of other keys")
+            return
+        }
+        other, err := h.keys.Get(id)
+        if errors.Is(err, auth.ErrKeyNotFound) && strings.HasPrefix(id, auth.JWTKeyPrefix) {
+            other, err = &auth.Key{ID: id}, nil // Token subjects are not stored
+        }
+        if err != nil {
+            respondError(c, http.StatusNotFound, "not_found", "API key not found")
+            return
+        }
+        key = other
+    }
+
+    report := UsageReport{Usage: h.taskManager.Usage("", time.Now())}
+    if key != nil {
+        report.Usage = h.taskManager.Usage(key.ID, time.Now())
+        report.Quota = h.keys.QuotaOf(key)
+    }
+    c.JSON(http.StatusOK, report)
+}
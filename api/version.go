@@ -0,0 +1,130 @@
+package api
+
+import (
+    "fmt"
+    "net/http"
+    "reflect"
+    "strings"
+    "time"
+
+    "ffwebapi/task"
+    "github.com/gin-gonic/gin"
+)
+
+const apiVersionKey = "apiVersion"
+
+// responseMapper turns internal values into the wire format of one API version.
+// Breaking changes to response shapes go into a new mapper instead of changing
+// an existing one.
+type responseMapper interface {
+    Error(status int, code, message string) gin.H
+    Task(t *task.Task) interface{}
+    TaskList(tasks []interface{}, nextCursor string) interface{}
+    ErrorSchema() reflect.Type // Go type shaped like Error's output, for /schema
+}
+
+// apiVersion describes one generation of the HTTP API.
+type apiVersion struct {
+    Name       string    // Path segment, e.g. "v1"
+    Deprecated bool      // Adds a Deprecation header to every response
+    Sunset     time.Time // Adds a Sunset header when set
+    Successor  string    // Name of the version that replaces this one
+    PageSize   int       // Default page size of lists; 0 lists everything
+    mapper     responseMapper
+}
+
+func (v *apiVersion) basePath() string {
+    return "/api/" + v.Name
+}
+
+// versionMiddleware records the API version on the context and advertises
+// deprecation and sunset dates (RFC 9745 / RFC 8594) for old versions.
+func versionMiddleware(v *apiVersion) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        c.Set(apiVersionKey, v)
+        if v.Deprecated {
+            c.Header("Deprecation", "true")
+            if v.Successor != "" {
+                c.Header("Link", fmt.Sprintf(`</api/%s>; rel="successor-version"`, v.Successor))
+            }
+        }
+        if !v.Sunset.IsZero() {
+            c.Header("Sunset", v.Sunset.UTC().Format(http.TimeFormat))
+        }
+        c.Next()
+    }
+}
+
+// versionOf returns the API version serving the request, defaulting to v1 for
+// routes outside a versioned group.
+func versionOf(c *gin.Context) *apiVersion {
+    if v, ok := c.Get(apiVersionKey); ok {
+        return v.(*apiVersion)
+    }
+    return &apiVersion{Name: "v1", mapper: v1Mapper{}}
+}
+
+// respondError writes an error in the envelope of the request's API version.
+func respondError(c *gin.Context, status int, code, message string) {
+    c.AbortWithStatusJSON(status, versionOf(c).mapper.Error(status, code, message))
+}
+
+// respondErrorDetails is respondError with an additional "details" field,
+// typically the underlying error of a 5xx.
+func respondErrorDetails(c *gin.Context, status int, code, message, details string) {
+    body := versionOf(c).mapper.Error(status, code, message)
+    body["details"] = details
+    c.AbortWithStatusJSON(status, body)
+}
+
+// respondTask writes a task in the representation of the request's API version.
+func respondTask(c *gin.Context, status int, t *task.Task) {
+    c.JSON(status, versionOf(c).mapper.Task(t))
+}
+
+// defaultErrorCode derives a machine-readable code from an HTTP status.
+func defaultErrorCode(status int) string {
+    return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// v1Mapper keeps the original v1 wire format: {"error": "...", "code": "..."}.
+type v1Mapper struct{}
+
+func (v1Mapper) Error(status int, code, message string) gin.H {
+    body := gin.H{"error": message}
+    if code != "" {
+        body["code"] = code
+    }
+    return body
+}
+
+func (v1Mapper) Task(t *task.Task) interface{} { return t }
+
+// TaskList keeps the bare array of v1; the next cursor is only in the
+// X-Next-Cursor header.
+func (v1Mapper) TaskList(tasks []interface{}, nextCursor string) interface{} { return tasks }
+
+func (v1Mapper) ErrorSchema() reflect.Type { return reflect.TypeOf(errorDoc{}) }
+
+// v2Mapper uses a structured error envelope: {"error": {"code": "...", "message": "..."}}.
+type v2Mapper struct{}
+
+func (v2Mapper) Error(status int, code, message string) gin.H {
+    if code == "" {
+        code = defaultErrorCode(status)
+    }
+    return gin.H{"error": gin.H{"code": code, "message": message}}
+}
+
+func (v2Mapper) Task(t *task.Task) interface{} { return t }
+
+// TaskList wraps a page of tasks; nextCursor is null on the last page.
+func (v2Mapper) TaskList(tasks []interface{}, nextCursor string) interface{} {
+    page := gin.H{"tasks": tasks, "nextCursor": nil}
+    if nextCursor != "" {
+        page["nextCursor"] = nextCursor
+    }
+    return page
+}
+
+func (v2Mapper) ErrorSchema() reflect.Type { return reflect.TypeOf(v2ErrorDoc{}) }
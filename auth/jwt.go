@@ -0,0 +1,322 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"ffwebapi/config"
+)
+
+// jwtLeeway tolerates clock skew between the identity provider and us.
+const jwtLeeway = time.Minute
+
+// jwksRefresh bounds how often the key set is fetched: at most once per
+// jwksMinRefresh for unknown key IDs, and at least once per jwksMaxAge.
+const (
+	jwksMinRefresh = time.Minute
+	jwksMaxAge     = time.Hour
+)
+
+// JWTKeyPrefix marks the IDs of keys derived from JWTs: "jwt:<sub>".
+const JWTKeyPrefix = "jwt:"
+
+// jwtAlgorithms maps the supported "alg" values to their hash.
+var jwtAlgorithms = map[string]crypto.Hash{
+	"HS256": crypto.SHA256, "HS384": crypto.SHA384, "HS512": crypto.SHA512,
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// jwtVerifier validates JWTs signed with JWT_SECRET or a key published at
+// JWT_JWKS_URL and turns their claims into a Key.
+type jwtVerifier struct {
+	cfg       *config.Config
+	client    *http.Client
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey // By kid
+	fetchedAt time.Time
+}
+
+// newJWTVerifier returns nil if neither JWT_SECRET nor JWT_JWKS_URL is set.
+func newJWTVerifier(cfg *config.Config) *jwtVerifier {
+	if cfg.JWTSecret == "" && cfg.JWTJWKSURL == "" {
+		return nil
+	}
+	return &jwtVerifier{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// isJWT tells JWTs apart from API key secrets, which contain no dots.
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"` // A string or an array of strings
+	ExpiresAt *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+	Scope     string          `json:"scope"` // Space separated, per RFC 8693
+	Scp       json.RawMessage `json:"scp"`   // A string or an array, as some providers use
+}
+
+// verify checks a token's signature and claims. The returned key is named
+// after the token's subject and carries the scopes it was granted.
+func (v *jwtVerifier) verify(token string, now time.Time) (*Key, error) {
+	parts := strings.Split(token, ".")
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidKey)
+	}
+	hash, ok := jwtAlgorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported alg %q", ErrInvalidKey, header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidKey)
+	}
+	if err := v.verifySignature(header, hash, []byte(parts[0]+"."+parts[1]), signature, now); err != nil {
+		return nil, err
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidKey)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing sub", ErrInvalidKey)
+	}
+	if v.cfg.JWTIssuer != "" && claims.Issuer != v.cfg.JWTIssuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidKey, claims.Issuer)
+	}
+	if v.cfg.JWTAudience != "" && !containsString(stringOrList(claims.Audience), v.cfg.JWTAudience) {
+		return nil, fmt.Errorf("%w: not meant for audience %q", ErrInvalidKey, v.cfg.JWTAudience)
+	}
+	if claims.NotBefore != nil && now.Add(jwtLeeway).Before(unixTime(*claims.NotBefore)) {
+		return nil, fmt.Errorf("%w: not valid yet", ErrInvalidKey)
+	}
+
+	key := &Key{ID: JWTKeyPrefix + claims.Subject, Name: claims.Subject, Scopes: []string{}}
+	if claims.ExpiresAt != nil {
+		key.ExpiresAt = unixTime(*claims.ExpiresAt).Add(jwtLeeway)
+		if key.Expired(now) {
+			return nil, ErrKeyExpired
+		}
+	}
+	// Scopes meant for other services are ignored.
+	for _, scope := range append(strings.Fields(claims.Scope), stringOrList(claims.Scp)...) {
+		if validScope(scope) && !containsString(key.Scopes, scope) {
+			key.Scopes = append(key.Scopes, scope)
+		}
+	}
+	return key, nil
+}
+
+// verifySignature checks the signature with JWT_SECRET for HMAC algorithms
+// and with the key set otherwise, so a token cannot pick the weaker check.
+func (v *jwtVerifier) verifySignature(header jwtHeader, hash crypto.Hash, signed, signature []byte, now time.Time) error {
+	if strings.HasPrefix(header.Alg, "HS") {
+		if v.cfg.JWTSecret == "" {
+			return fmt.Errorf("%w: HMAC tokens are not accepted", ErrInvalidKey)
+		}
+		mac := hmac.New(hashFunc(hash), []byte(v.cfg.JWTSecret))
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidKey)
+		}
+		return nil
+	}
+
+	if v.cfg.JWTJWKSURL == "" {
+		return fmt.Errorf("%w: %s tokens are not accepted", ErrInvalidKey, header.Alg)
+	}
+	keys, err := v.keySet(header.Kid, now)
+	if err != nil {
+		return err
+	}
+	h := hashFunc(hash)()
+	h.Write(signed)
+	digest := h.Sum(nil)
+	for kid, pub := range keys {
+		if header.Kid != "" && kid != header.Kid {
+			continue
+		}
+		switch pub := pub.(type) {
+		case *rsa.PublicKey:
+			if strings.HasPrefix(header.Alg, "RS") && rsa.VerifyPKCS1v15(pub, hash, digest, signature) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			if strings.HasPrefix(header.Alg, "ES") && len(signature) == 2*size {
+				r := new(big.Int).SetBytes(signature[:size])
+				s := new(big.Int).SetBytes(signature[size:])
+				if ecdsa.Verify(pub, digest, r, s) {
+					return nil
+				}
+			}
+		}
+	}
+	return fmt.Errorf("%w: bad signature", ErrInvalidKey)
+}
+
+// keySet returns the cached JWKS, fetching it if it is stale or lacks kid.
+func (v *jwtVerifier) keySet(kid string, now time.Time) (map[string]crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	_, known := v.keys[kid]
+	stale := now.Sub(v.fetchedAt) > jwksMaxAge
+	if v.keys == nil || stale || (kid != "" && !known && now.Sub(v.fetchedAt) > jwksMinRefresh) {
+		keys, err := v.fetchKeySet()
+		if err != nil && v.keys == nil {
+			return nil, err
+		}
+		if err == nil {
+			v.keys = keys
+		}
+		// On errors the previous keys stay in use until the next attempt.
+		v.fetchedAt = now
+	}
+	return v.keys, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeySet downloads JWT_JWKS_URL. Keys of unknown types are skipped.
+func (v *jwtVerifier) fetchKeySet() (map[string]crypto.PublicKey, error) {
+	resp, err := v.client.Get(v.cfg.JWTJWKSURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch JWKS: %s", resp.Status)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if pub, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = pub
+		}
+	}
+	return keys, nil
+}
+
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func hashFunc(h crypto.Hash) func() hash.Hash {
+	switch h {
+	case crypto.SHA384:
+		return sha512.New384
+	case crypto.SHA512:
+		return sha512.New
+	}
+	return sha256.New
+}
+
+// stringOrList decodes claims that are either a string or a list of strings.
+func stringOrList(raw json.RawMessage) []string {
+	var list []string
+	if json.Unmarshal(raw, &list) == nil {
+		return list
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil && s != "" {
+		return strings.Fields(s)
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ffwebapi/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func unsignedJWT(t *testing.T, header, claims map[string]interface{}) string {
+	h, err := json.Marshal(header)
+	require.NoError(t, err)
+	c, err := json.Marshal(claims)
+	require.NoError(t, err)
+	return b64(h) + "." + b64(c)
+}
+
+func hmacJWT(t *testing.T, secret string, claims map[string]interface{}) string {
+	unsigned := unsignedJWT(t, map[string]interface{}{"alg": "HS256", "typ": "JWT"}, claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + b64(mac.Sum(nil))
+}
+
+func TestJWT_HMAC(t *testing.T) {
+	cfg := &config.Config{JWTSecret: "idp-secret", JWTIssuer: "https://idp.example.com", JWTAudience: "ffwebapi"}
+	store, err := NewStore(cfg)
+	require.NoError(t, err)
+	now := time.Now()
+	claims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"sub":   "alice",
+			"iss":   "https://idp.example.com",
+			"aud":   []string{"ffwebapi", "other"},
+			"exp":   now.Add(time.Hour).Unix(),
+			"scope": "read submit billing:write",
+		}
+	}
+
+	key, err := store.Authenticate(hmacJWT(t, "idp-secret", claims()), now)
+	require.NoError(t, err)
+	assert.Equal(t, "jwt:alice", key.ID)
+	assert.Equal(t, []string{ScopeRead, ScopeSubmit}, key.Scopes)
+	assert.False(t, key.HasScope(ScopeCancel))
+
+	c := claims()
+	c["scope"] = nil
+	c["scp"] = []string{"admin"}
+	key, err = store.Authenticate(hmacJWT(t, "idp-secret", c), now)
+	require.NoError(t, err)
+	assert.True(t, key.HasScope(ScopeCancel))
+
+	_, err = store.Authenticate(hmacJWT(t, "idp-secret", claims()), now.Add(2*time.Hour))
+	assert.ErrorIs(t, err, ErrKeyExpired)
+
+	_, err = store.Authenticate(hmacJWT(t, "wrong-secret", claims()), now)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	c = claims()
+	c["iss"] = "https://evil.example.com"
+	_, err = store.Authenticate(hmacJWT(t, "idp-secret", c), now)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	c = claims()
+	c["aud"] = "other"
+	_, err = store.Authenticate(hmacJWT(t, "idp-secret", c), now)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	c = claims()
+	delete(c, "sub")
+	_, err = store.Authenticate(hmacJWT(t, "idp-secret", c), now)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	// Unsigned tokens and RS256 tokens without a key set are rejected.
+	_, err = store.Authenticate(unsignedJWT(t, map[string]interface{}{"alg": "none"}, claims())+".", now)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	_, err = store.Authenticate(unsignedJWT(t, map[string]interface{}{"alg": "RS256"}, claims())+".c2ln", now)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	// Without JWT settings tokens are just unknown keys.
+	plain, err := NewStore(&config.Config{})
+	require.NoError(t, err)
+	_, err = plain.Authenticate(hmacJWT(t, "idp-secret", claims()), now)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestJWT_JWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	var fetches atomic.Int32
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		}})
+	}))
+	defer jwks.Close()
+
+	store, err := NewStore(&config.Config{JWTJWKSURL: jwks.URL})
+	require.NoError(t, err)
+	now := time.Now()
+	claims := map[string]interface{}{"sub": "svc-encoder", "exp": now.Add(time.Hour).Unix(), "scope": "submit"}
+
+	unsigned := unsignedJWT(t, map[string]interface{}{"alg": "RS256", "kid": "rsa-1"}, claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	key, err := store.Authenticate(unsigned+"."+b64(sig), now)
+	require.NoError(t, err)
+	assert.Equal(t, "jwt:svc-encoder", key.ID)
+	assert.True(t, key.HasScope(ScopeSubmit))
+
+	unsigned = unsignedJWT(t, map[string]interface{}{"alg": "ES256", "kid": "ec-1"}, claims)
+	digest = sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+	require.NoError(t, err)
+	_, err = store.Authenticate(unsigned+"."+b64(append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)), now)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), fetches.Load(), "the key set is cached")
+
+	// Without JWT_SECRET HMAC tokens are rejected, even if keyed with the public key.
+	forged := unsignedJWT(t, map[string]interface{}{"alg": "HS256", "kid": "rsa-1"}, claims)
+	mac := hmac.New(sha256.New, rsaKey.N.Bytes())
+	mac.Write([]byte(forged))
+	_, err = store.Authenticate(forged+"."+b64(mac.Sum(nil)), now)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	// Unknown key IDs trigger a refetch, but not more than once a minute.
+	unknown := unsignedJWT(t, map[string]interface{}{"alg": "RS256", "kid": "rsa-2"}, claims) + "." + b64(sig)
+	_, err = store.Authenticate(unknown, now)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	_, err = store.Authenticate(unknown, now.Add(2*time.Minute))
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	assert.Equal(t, int32(2), fetches.Load())
+}
@@ -0,0 +1,302 @@
+// Package auth manages the API keys clients authenticate with. Each key
+// carries scopes limiting what it may do; keys are persisted in DATA_DIR.
+// JWTs of an identity provider are accepted as keys too, see jwt.go.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"ffwebapi/config"
+
+	"github.com/lithammer/shortuuid/v4"
+)
+
+// Scopes of an API key.
+const (
+	ScopeSubmit   = "submit"
+	ScopeRead     = "read"
+	ScopeCancel   = "cancel"
+	ScopeDownload = "download"
+	ScopeAdmin    = "admin" // Implies every other scope
+)
+
+// Scopes lists every valid scope.
+var Scopes = []string{ScopeSubmit, ScopeRead, ScopeCancel, ScopeDownload, ScopeAdmin}
+
+// LegacyKeyID identifies AUTH_KEY, which acts as an admin key that cannot be
+// revoked through the API.
+const LegacyKeyID = "default"
+
+var (
+	ErrInvalidKey  = errors.New("invalid API key")
+	ErrKeyExpired  = errors.New("API key expired")
+	ErrKeyNotFound = errors.New("API key not found")
+)
+
+// Quota limits what the tasks of one key may use. Zero fields are unlimited.
+type Quota struct {
+	MaxRunning        int     `json:"maxRunning,omitempty"`        // Tasks processing at once; further ones wait in their queue
+	MaxStorage        int64   `json:"maxStorage,omitempty"`        // Bytes of outputs held on the server
+	MonthlyCPUSeconds float64 `json:"monthlyCpuSeconds,omitempty"` // ffmpeg CPU time per calendar month (UTC)
+}
+
+// RateLimit overrides the server's rate limits (RATE_LIMIT_*) for one key,
+// e.g. to let a batch submitter send bursts. Zero fields keep the default.
+type RateLimit struct {
+	RequestsPerMinute  int `json:"requestsPerMinute,omitempty"`
+	RequestsBurst      int `json:"requestsBurst,omitempty"` // Requests sent at once after being idle
+	SubmissionsPerHour int `json:"submissionsPerHour,omitempty"`
+	SubmissionsBurst   int `json:"submissionsBurst,omitempty"`
+}
+
+// Key is an API key as shown to administrators; the secret itself is only
+// returned once, when the key is created.
+type Key struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name,omitempty"`
+	Scopes    []string   `json:"scopes"`
+	Queue     string     `json:"queue,omitempty"`     // Queue the key's tasks are pinned to, if any
+	Quota     *Quota     `json:"quota,omitempty"`     // Replaces the server's default quota
+	RateLimit *RateLimit `json:"rateLimit,omitempty"` // Overrides the server's rate limits
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt,omitempty"` // Zero if the key does not expire
+	hash      string
+}
+
+// HasScope reports whether the key grants scope.
+func (k *Key) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired reports whether the key is past its expiry.
+func (k *Key) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt)
+}
+
+// storedKey is the on-disk form of a key.
+type storedKey struct {
+	Key
+	Hash string `json:"hash"`
+}
+
+// Store holds the API keys. AUTH_KEY and JWTs (if configured) are accepted in
+// addition to the stored keys.
+type Store struct {
+	cfg    *config.Config
+	path   string // File the keys are persisted to; empty to keep them in memory only
+	mu     sync.RWMutex
+	keys   map[string]*Key // By ID
+	byHash map[string]*Key
+	jwt    *jwtVerifier // nil unless JWT_SECRET or JWT_JWKS_URL is set
+}
+
+// NewStore loads the keys persisted in DATA_DIR. Without DATA_DIR, keys
+// created through the API are lost on restart.
+func NewStore(cfg *config.Config) (*Store, error) {
+	s := &Store{cfg: cfg, keys: make(map[string]*Key), byHash: make(map[string]*Key), jwt: newJWTVerifier(cfg)}
+	if cfg.DataDir == "" {
+		return s, nil
+	}
+	s.path = filepath.Join(cfg.DataDir, "api_keys.json")
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored []storedKey
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", s.path, err)
+	}
+	for i := range stored {
+		k := stored[i].Key
+		k.hash = stored[i].Hash
+		s.keys[k.ID], s.byHash[k.hash] = &k, &k
+	}
+	return s, nil
+}
+
+// Authenticate returns the key a secret belongs to. For JWTs that is a key
+// derived from the token's claims, with an ID of JWTKeyPrefix + its subject.
+func (s *Store) Authenticate(secret string, now time.Time) (*Key, error) {
+	if s.cfg.AuthKey != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(s.cfg.AuthKey)) == 1 {
+		return &Key{ID: LegacyKeyID, Scopes: []string{ScopeAdmin}}, nil
+	}
+	if s.jwt != nil && isJWT(secret) {
+		return s.jwt.verify(secret, now)
+	}
+	s.mu.RLock()
+	k, ok := s.byHash[hashSecret(secret)]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	if k.Expired(now) {
+		return nil, ErrKeyExpired
+	}
+	return k, nil
+}
+
+// Create adds a key with the name, scopes, queue, quota, rate limits and
+// expiry of spec;
+// the other fields are filled in. It returns the key and its secret.
+func (s *Store) Create(spec Key) (*Key, string, error) {
+	if len(spec.Scopes) == 0 {
+		return nil, "", fmt.Errorf("a key needs at least one scope")
+	}
+	for _, scope := range spec.Scopes {
+		if !validScope(scope) {
+			return nil, "", fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	now := time.Now()
+	if !spec.ExpiresAt.IsZero() && !spec.ExpiresAt.After(now) {
+		return nil, "", fmt.Errorf("expiresAt must be in the future")
+	}
+	if q := spec.Quota; q != nil && (q.MaxRunning < 0 || q.MaxStorage < 0 || q.MonthlyCPUSeconds < 0) {
+		return nil, "", fmt.Errorf("quota limits must not be negative")
+	}
+	if r := spec.RateLimit; r != nil && (r.RequestsPerMinute < 0 || r.RequestsBurst < 0 || r.SubmissionsPerHour < 0 || r.SubmissionsBurst < 0) {
+		return nil, "", fmt.Errorf("rate limits must not be negative")
+	}
+
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", err
+	}
+	secret := "ffk_" + hex.EncodeToString(buf)
+	k := &Key{
+		ID:        shortuuid.New(),
+		Name:      spec.Name,
+		Scopes:    spec.Scopes,
+		Queue:     spec.Queue,
+		Quota:     spec.Quota,
+		RateLimit: spec.RateLimit,
+		CreatedAt: now,
+		ExpiresAt: spec.ExpiresAt,
+		hash:      hashSecret(secret),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[k.ID], s.byHash[k.hash] = k, k
+	if err := s.save(); err != nil {
+		delete(s.keys, k.ID)
+		delete(s.byHash, k.hash)
+		return nil, "", err
+	}
+	return k, secret, nil
+}
+
+// QuotaOf returns the limits of a key: its own quota if it has one, none for
+// admin keys, and the server's default (QUOTA_*) otherwise.
+func (s *Store) QuotaOf(k *Key) Quota {
+	switch {
+	case k.Quota != nil:
+		return *k.Quota
+	case k.HasScope(ScopeAdmin):
+		return Quota{}
+	}
+	return Quota{
+		MaxRunning:        s.cfg.QuotaMaxRunning,
+		MaxStorage:        s.cfg.QuotaMaxStorage,
+		MonthlyCPUSeconds: s.cfg.QuotaMonthlyCPU.Seconds(),
+	}
+}
+
+// List returns all stored keys, oldest first.
+func (s *Store) List() []*Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]*Key, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys
+}
+
+// Get returns a stored key by ID.
+func (s *Store) Get(id string) (*Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return k, nil
+}
+
+// Revoke deletes a key; requests using it are rejected from then on.
+func (s *Store) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	delete(s.keys, id)
+	delete(s.byHash, k.hash)
+	if err := s.save(); err != nil {
+		s.keys[id], s.byHash[k.hash] = k, k
+		return err
+	}
+	return nil
+}
+
+// save writes the keys to disk; the caller holds s.mu.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	stored := make([]storedKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		stored = append(stored, storedKey{Key: *k, Hash: k.hash})
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].CreatedAt.Before(stored[j].CreatedAt) })
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	// Write then rename, so a crash never leaves a truncated key file.
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func validScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// hashSecret is how secrets are stored: a leaked key file does not reveal them.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ffwebapi/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	cfg := &config.Config{AuthKey: "legacy", DataDir: t.TempDir(), QuotaMaxRunning: 3}
+	store, err := NewStore(cfg)
+	require.NoError(t, err)
+
+	legacy, err := store.Authenticate("legacy", time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, LegacyKeyID, legacy.ID)
+	assert.True(t, legacy.HasScope(ScopeCancel))
+	assert.Equal(t, Quota{}, store.QuotaOf(legacy))
+
+	_, _, err = store.Create(Key{Name: "bad", Scopes: []string{"delete-everything"}})
+	assert.Error(t, err)
+
+	reader, secret, err := store.Create(Key{Name: "dashboard", Scopes: []string{ScopeRead, ScopeDownload}})
+	require.NoError(t, err)
+	k, err := store.Authenticate(secret, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, reader.ID, k.ID)
+	assert.True(t, k.HasScope(ScopeRead))
+	assert.False(t, k.HasScope(ScopeSubmit))
+	assert.Equal(t, Quota{MaxRunning: 3}, store.QuotaOf(k))
+
+	expiring, expiringSecret, err := store.Create(Key{Name: "ci", Scopes: []string{ScopeSubmit}, Queue: "bulk", Quota: &Quota{MaxRunning: 2}, ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	_, err = store.Authenticate(expiringSecret, time.Now().Add(2*time.Hour))
+	assert.ErrorIs(t, err, ErrKeyExpired)
+
+	// Keys survive a restart; secrets are not stored in plain text.
+	reloaded, err := NewStore(cfg)
+	require.NoError(t, err)
+	assert.Len(t, reloaded.List(), 2)
+	_, err = reloaded.Authenticate(secret, time.Now())
+	assert.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(cfg.DataDir, "api_keys.json"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), secret)
+
+	require.NoError(t, reloaded.Revoke(reader.ID))
+	assert.ErrorIs(t, reloaded.Revoke(reader.ID), ErrKeyNotFound)
+	_, err = reloaded.Get(reader.ID)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	_, err = reloaded.Authenticate(secret, time.Now())
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	reloaded, err = NewStore(cfg)
+	require.NoError(t, err)
+	require.Len(t, reloaded.List(), 1)
+	assert.Equal(t, expiring.ID, reloaded.List()[0].ID)
+	assert.Equal(t, "bulk", reloaded.List()[0].Queue)
+	assert.Equal(t, Quota{MaxRunning: 2}, reloaded.QuotaOf(reloaded.List()[0]))
+}
@@ -0,0 +1,308 @@
+// Package bench drives a running server with synthetic workloads and reports
+// throughput, latencies and failures, for sizing instances ("ffwebapi bench").
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ffwebapi/preset"
+	"ffwebapi/task"
+)
+
+// Options configure a benchmark run. The run ends after Tasks tasks or once
+// Duration has passed, whichever comes first; at least one must be set.
+type Options struct {
+	Server       string        // Base URL of the server, e.g. http://localhost:8080
+	Key          string        // API key with the submit, read and cancel scopes; empty without auth
+	Workloads    []Workload    // Submitted in proportion to their weights
+	Tasks        int           // Tasks to run in total; 0 = until Duration
+	Duration     time.Duration // Soak duration; 0 = until Tasks are done
+	Concurrency  int           // Tasks in flight at once
+	Input        string        // inputMedia of every task; a generated Source is uploaded for each task if empty
+	Source       Source
+	PollInterval time.Duration
+	TaskTimeout  time.Duration // A task still running after this counts as failed
+	Keep         bool          // Keep tasks and their outputs instead of deleting them
+}
+
+// Workload is a command submitted by the benchmark.
+type Workload struct {
+	Name      string
+	Command   string
+	OutputExt string
+	Weight    int
+}
+
+// ParseWorkloads reads a comma-separated list of presets with optional
+// weights, e.g. "h264-720p=3,opus-96k=1".
+func ParseWorkloads(s string) ([]Workload, error) {
+	var workloads []Workload
+	for _, entry := range strings.Split(s, ",") {
+		name, weight, hasWeight := strings.Cut(strings.TrimSpace(entry), "=")
+		p, ok := preset.Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown preset %q", name)
+		}
+		w := Workload{Name: p.Name, Command: p.Command, OutputExt: p.OutputExt, Weight: 1}
+		if hasWeight {
+			if _, err := fmt.Sscanf(weight, "%d", &w.Weight); err != nil || w.Weight < 1 {
+				return nil, fmt.Errorf("invalid weight %q of preset %q", weight, name)
+			}
+		}
+		workloads = append(workloads, w)
+	}
+	return workloads, nil
+}
+
+// schedule lists workloads by weight, so that the n-th task of every run gets
+// the same workload and runs are reproducible.
+func schedule(workloads []Workload) []Workload {
+	var s []Workload
+	for _, w := range workloads {
+		for i := 0; i < w.Weight; i++ {
+			s = append(s, w)
+		}
+	}
+	return s
+}
+
+// sample is the outcome of one task.
+type sample struct {
+	workload   string
+	failure    string // Empty if the task succeeded
+	upload     time.Duration
+	submit     time.Duration
+	queueWait  time.Duration
+	processing time.Duration
+	endToEnd   time.Duration // From the start of the upload (or submission) until the task was seen terminal
+}
+
+// Run submits tasks until the run is over and returns its report. Tasks
+// still in flight when ctx is canceled are abandoned and not reported.
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	if opts.Tasks <= 0 && opts.Duration <= 0 {
+		return nil, fmt.Errorf("either a number of tasks or a duration is required")
+	}
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 500 * time.Millisecond
+	}
+	plan := schedule(opts.Workloads)
+	if len(plan) == 0 {
+		return nil, fmt.Errorf("no workloads")
+	}
+
+	c := &client{base: strings.TrimSuffix(opts.Server, "/") + "/api/v2", key: opts.Key, http: &http.Client{Timeout: time.Minute}}
+	var source []byte
+	if opts.Input == "" {
+		path, cleanup, err := opts.Source.Generate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+		if source, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+
+	var (
+		next    atomic.Int64
+		mu      sync.Mutex
+		samples []sample
+		wg      sync.WaitGroup
+	)
+	start := time.Now()
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				n := next.Add(1) - 1
+				if opts.Tasks > 0 && n >= int64(opts.Tasks) {
+					return
+				}
+				s := c.runTask(ctx, opts, plan[n%int64(len(plan))], source)
+				if s == nil {
+					return // Canceled mid-task
+				}
+				mu.Lock()
+				samples = append(samples, *s)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return newReport(samples, time.Since(start), opts.Concurrency), nil
+}
+
+// runTask uploads the source if there is one, submits a task and waits for
+// it to finish. It returns nil if ctx is done first.
+func (c *client) runTask(ctx context.Context, opts Options, w Workload, source []byte) *sample {
+	s := &sample{workload: w.Name}
+	begin := time.Now()
+	fail := func(reason string) *sample {
+		if ctx.Err() != nil {
+			return nil
+		}
+		s.failure = reason
+		s.endToEnd = time.Since(begin)
+		return s
+	}
+
+	req := map[string]interface{}{"command": w.Command, "outputExt": w.OutputExt, "inputMedia": opts.Input}
+	if source != nil {
+		id, err := c.upload(ctx, source)
+		if err != nil {
+			return fail(err.Error())
+		}
+		s.upload = time.Since(begin)
+		req = map[string]interface{}{"command": w.Command, "outputExt": w.OutputExt, "inputId": id}
+	}
+
+	submitted := time.Now()
+	var accepted struct {
+		TaskID string `json:"taskId"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/tasks", req, http.StatusAccepted, &accepted); err != nil {
+		return fail(err.Error())
+	}
+	s.submit = time.Since(submitted)
+	if !opts.Keep {
+		defer c.do(context.Background(), http.MethodDelete, "/tasks/"+accepted.TaskID+"?force=true", nil, http.StatusOK, nil)
+	}
+
+	var deadline <-chan time.Time
+	if opts.TaskTimeout > 0 {
+		timer := time.NewTimer(opts.TaskTimeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		var t task.Task
+		if err := c.do(ctx, http.MethodGet, "/tasks/"+accepted.TaskID, nil, http.StatusOK, &t); err != nil {
+			return fail(err.Error())
+		}
+		if t.Status.IsTerminal() {
+			s.endToEnd = time.Since(begin)
+			if !t.StartedAt.IsZero() {
+				s.queueWait = t.StartedAt.Sub(t.CreatedAt)
+				s.processing = t.CompletedAt.Sub(t.StartedAt)
+			}
+			if !t.Status.Succeeded() {
+				s.failure = "task " + string(t.Status)
+			}
+			return s
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-deadline:
+			return fail("timeout")
+		case <-time.After(opts.PollInterval):
+		}
+	}
+}
+
+// client calls the v2 API of the server under test.
+type client struct {
+	base string
+	key  string
+	http *http.Client
+}
+
+// upload reserves an input and uploads data to it, returning its ID.
+func (c *client) upload(ctx context.Context, data []byte) (string, error) {
+	var reservation struct {
+		ID           string `json:"id"`
+		UploadURL    string `json:"uploadUrl"`
+		UploadMethod string `json:"uploadMethod"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/inputs", map[string]interface{}{"size": len(data), "contentType": "video/mp4"}, http.StatusCreated, &reservation); err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, reservation.UploadMethod, reservation.UploadURL, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("upload: request failed")
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("upload: HTTP %d", resp.StatusCode)
+	}
+	return reservation.ID, nil
+}
+
+// do sends a JSON request and decodes the response into out, if not nil.
+// Responses other than want are errors named after their status and code,
+// e.g. "POST /tasks: HTTP 429 rate_limited", so failures group well.
+func (c *client) do(ctx context.Context, method, path string, body interface{}, want int, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.key != "" {
+		req.Header.Set("Authorization", "Bearer "+c.key)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: request failed", method, route(path))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != want {
+		var e struct {
+			Error struct {
+				Code string `json:"code"`
+			} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return errors.New(strings.TrimSpace(fmt.Sprintf("%s %s: HTTP %d %s", method, route(path), resp.StatusCode, e.Error.Code)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// route drops IDs and queries from a path, e.g. "/tasks/abc" -> "/tasks/:id".
+func route(path string) string {
+	path, _, _ = strings.Cut(path, "?")
+	parts := strings.Split(path, "/")
+	if len(parts) > 2 {
+		parts[2] = ":id"
+	}
+	return strings.Join(parts, "/")
+}
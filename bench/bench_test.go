@@ -0,0 +1,113 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ffwebapi/api"
+	"ffwebapi/auth"
+	"ffwebapi/config"
+	"ffwebapi/task"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingRunner fails the commands of the "h264-480p" preset and completes the rest.
+type failingRunner struct{}
+
+func (failingRunner) Run(ctx context.Context, t *task.Task) (string, error) {
+	if strings.Contains(t.Command, "scale=-2:480") {
+		return "", errors.New("encoder crashed")
+	}
+	return "ok", nil
+}
+
+func startServer(t *testing.T) (*httptest.Server, *task.Manager) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{MaxConcurrency: 2, FFTimeout: time.Minute, TempDir: t.TempDir(), MaxInputSize: 1024, UploadURLTTL: time.Minute, InputTTL: time.Hour}
+	tm, err := task.NewManager(cfg, failingRunner{})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	tm.Start(ctx)
+	keys, _ := auth.NewStore(cfg)
+	srv := httptest.NewServer(api.SetupRouter(tm, keys, cfg))
+	t.Cleanup(func() {
+		srv.Close()
+		cancel()
+		tm.Shutdown(context.Background())
+	})
+	return srv, tm
+}
+
+func TestParseWorkloads(t *testing.T) {
+	workloads, err := ParseWorkloads("h264-720p=3, vp9-720p")
+	require.NoError(t, err)
+	require.Len(t, workloads, 2)
+	assert.Equal(t, 3, workloads[0].Weight)
+	assert.Equal(t, "webm", workloads[1].OutputExt)
+	assert.Len(t, schedule(workloads), 4)
+
+	_, err = ParseWorkloads("nope")
+	assert.Error(t, err)
+	_, err = ParseWorkloads("h264-720p=0")
+	assert.Error(t, err)
+}
+
+func TestRun(t *testing.T) {
+	srv, tm := startServer(t)
+	workloads, _ := ParseWorkloads("h264-720p=3,h264-480p")
+
+	report, err := Run(context.Background(), Options{
+		Server: srv.URL, Workloads: workloads, Tasks: 8, Concurrency: 3,
+		Input: "test.mkv", PollInterval: 10 * time.Millisecond, TaskTimeout: 10 * time.Second,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 8, report.Tasks)
+	assert.Equal(t, 6, report.Succeeded)
+	assert.Equal(t, 6, report.Workloads["h264-720p"].Tasks)
+	assert.Equal(t, 2, report.Workloads["h264-480p"].Failed)
+	assert.Equal(t, map[string]int{"task failed": 2}, report.Failures)
+	assert.InDelta(t, 0.25, report.FailureRate, 1e-9)
+	assert.Equal(t, 6, report.Latency["endToEnd"].Count)
+	assert.Equal(t, 6, report.Latency["submit"].Count)
+
+	// Tasks are deleted unless kept.
+	assert.Empty(t, tm.List())
+
+	var out bytes.Buffer
+	report.WriteText(&out)
+	assert.Contains(t, out.String(), "6 succeeded, 2 failed")
+	assert.Contains(t, out.String(), "task failed")
+}
+
+func TestRunUpload(t *testing.T) {
+	srv, _ := startServer(t)
+	c := &client{base: srv.URL + "/api/v2", http: srv.Client()}
+	workloads, _ := ParseWorkloads("h264-720p")
+
+	s := c.runTask(context.Background(), Options{PollInterval: 10 * time.Millisecond}, workloads[0], []byte("not really a video"))
+	require.NotNil(t, s)
+	assert.Empty(t, s.failure)
+	assert.Greater(t, s.upload, time.Duration(0))
+
+	// Errors are named after the route and status, not the IDs.
+	s = c.runTask(context.Background(), Options{}, workloads[0], make([]byte, 2048))
+	require.NotNil(t, s)
+	assert.Equal(t, "POST /inputs: HTTP 400 invalid_request", s.failure)
+}
+
+func TestPercentiles(t *testing.T) {
+	var ds []time.Duration
+	for i := 100; i >= 1; i-- {
+		ds = append(ds, time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, Percentiles{Count: 100, P50: 50, P90: 90, P99: 99, Max: 100}, percentiles(ds))
+	assert.Equal(t, Percentiles{Count: 1, P50: 7, P90: 7, P99: 7, Max: 7}, percentiles([]time.Duration{7 * time.Millisecond}))
+}
@@ -0,0 +1,58 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Command runs "ffwebapi bench" with its command-line arguments and writes
+// the report to out.
+func Command(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.SetOutput(out)
+	opts := Options{}
+	fs.StringVar(&opts.Server, "server", "http://localhost:8080", "base URL of the server under test")
+	fs.StringVar(&opts.Key, "key", "", "API key with the submit, read and cancel scopes")
+	workloads := fs.String("workload", "h264-720p", `presets to submit with optional weights, e.g. "h264-720p=3,opus-96k=1"`)
+	fs.IntVar(&opts.Tasks, "tasks", 20, "tasks to run; 0 to run until -duration is over")
+	fs.DurationVar(&opts.Duration, "duration", 0, "soak duration, e.g. 30m; 0 to run until -tasks are done")
+	fs.IntVar(&opts.Concurrency, "concurrency", 2, "tasks in flight at once")
+	fs.StringVar(&opts.Input, "input", "", "inputMedia of every task instead of uploading a generated source")
+	fs.StringVar(&opts.Source.FFBin, "ffmpeg", "ffmpeg", "local ffmpeg generating the source")
+	fs.StringVar(&opts.Source.Size, "source-size", "1280x720", "frame size of the generated source")
+	fs.IntVar(&opts.Source.Rate, "source-rate", 30, "frame rate of the generated source")
+	fs.DurationVar(&opts.Source.Duration, "source-duration", 10*time.Second, "length of the generated source")
+	fs.DurationVar(&opts.PollInterval, "poll", 500*time.Millisecond, "task status poll interval")
+	fs.DurationVar(&opts.TaskTimeout, "task-timeout", 10*time.Minute, "time after which a task counts as failed")
+	fs.BoolVar(&opts.Keep, "keep", false, "keep tasks and outputs instead of deleting them")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var err error
+	if opts.Workloads, err = ParseWorkloads(*workloads); err != nil {
+		return err
+	}
+	report, err := Run(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	report.WriteText(out)
+	if report.Tasks == 0 {
+		return fmt.Errorf("no task finished")
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// Report summarizes a benchmark run. Latencies are in milliseconds and only
+// cover tasks that succeeded.
+type Report struct {
+	Tasks          int                        `json:"tasks"`
+	Succeeded      int                        `json:"succeeded"`
+	Failed         int                        `json:"failed"`
+	FailureRate    float64                    `json:"failureRate"`
+	Concurrency    int                        `json:"concurrency"`
+	ElapsedSeconds float64                    `json:"elapsedSeconds"`
+	TasksPerMinute float64                    `json:"tasksPerMinute"` // Succeeded tasks
+	Latency        map[string]Percentiles     `json:"latency"`        // upload, submit, queueWait, processing, endToEnd
+	Failures       map[string]int             `json:"failures,omitempty"`
+	Workloads      map[string]*WorkloadReport `json:"workloads"`
+}
+
+// WorkloadReport is the part of a report about one workload.
+type WorkloadReport struct {
+	Tasks    int         `json:"tasks"`
+	Failed   int         `json:"failed"`
+	EndToEnd Percentiles `json:"endToEnd"`
+}
+
+// Percentiles of a latency, in milliseconds.
+type Percentiles struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// latencyNames orders the latencies of the text report.
+var latencyNames = []string{"upload", "submit", "queueWait", "processing", "endToEnd"}
+
+func newReport(samples []sample, elapsed time.Duration, concurrency int) *Report {
+	r := &Report{
+		Tasks:          len(samples),
+		Concurrency:    concurrency,
+		ElapsedSeconds: elapsed.Seconds(),
+		Latency:        map[string]Percentiles{},
+		Failures:       map[string]int{},
+		Workloads:      map[string]*WorkloadReport{},
+	}
+	latencies := map[string][]time.Duration{}
+	perWorkload := map[string][]time.Duration{}
+	for _, s := range samples {
+		w := r.Workloads[s.workload]
+		if w == nil {
+			w = &WorkloadReport{}
+			r.Workloads[s.workload] = w
+		}
+		w.Tasks++
+		if s.failure != "" {
+			r.Failed++
+			w.Failed++
+			r.Failures[s.failure]++
+			continue
+		}
+		r.Succeeded++
+		for name, d := range map[string]time.Duration{"upload": s.upload, "submit": s.submit, "queueWait": s.queueWait, "processing": s.processing, "endToEnd": s.endToEnd} {
+			if d > 0 {
+				latencies[name] = append(latencies[name], d)
+			}
+		}
+		perWorkload[s.workload] = append(perWorkload[s.workload], s.endToEnd)
+	}
+	for name, ds := range latencies {
+		r.Latency[name] = percentiles(ds)
+	}
+	for name, ds := range perWorkload {
+		r.Workloads[name].EndToEnd = percentiles(ds)
+	}
+	if r.Tasks > 0 {
+		r.FailureRate = float64(r.Failed) / float64(r.Tasks)
+	}
+	if elapsed > 0 {
+		r.TasksPerMinute = float64(r.Succeeded) / elapsed.Minutes()
+	}
+	return r
+}
+
+// percentiles uses the nearest-rank method.
+func percentiles(ds []time.Duration) Percentiles {
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	rank := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(ds)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return float64(ds[i]) / float64(time.Millisecond)
+	}
+	return Percentiles{Count: len(ds), P50: rank(0.5), P90: rank(0.9), P99: rank(0.99), Max: rank(1)}
+}
+
+// WriteText writes the report as a human-readable table.
+func (r *Report) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Tasks:        %d (%d succeeded, %d failed, %.1f%% failure rate)\n", r.Tasks, r.Succeeded, r.Failed, 100*r.FailureRate)
+	fmt.Fprintf(w, "Elapsed:      %s at concurrency %d\n", time.Duration(r.ElapsedSeconds*float64(time.Second)).Round(time.Millisecond), r.Concurrency)
+	fmt.Fprintf(w, "Throughput:   %.2f tasks/min\n\n", r.TasksPerMinute)
+
+	fmt.Fprintf(w, "%-12s %8s %10s %10s %10s %10s\n", "Latency (ms)", "count", "p50", "p90", "p99", "max")
+	for _, name := range latencyNames {
+		if p, ok := r.Latency[name]; ok {
+			fmt.Fprintf(w, "%-12s %8d %10.0f %10.0f %10.0f %10.0f\n", name, p.Count, p.P50, p.P90, p.P99, p.Max)
+		}
+	}
+
+	names := make([]string, 0, len(r.Workloads))
+	for name := range r.Workloads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "\n%-12s %8s %8s %10s %10s\n", "Workload", "tasks", "failed", "p50 (ms)", "p99 (ms)")
+	for _, name := range names {
+		wr := r.Workloads[name]
+		fmt.Fprintf(w, "%-12s %8d %8d %10.0f %10.0f\n", name, wr.Tasks, wr.Failed, wr.EndToEnd.P50, wr.EndToEnd.P99)
+	}
+
+	if len(r.Failures) > 0 {
+		reasons := make([]string, 0, len(r.Failures))
+		for reason := range r.Failures {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		fmt.Fprintf(w, "\nFailures:\n")
+		for _, reason := range reasons {
+			fmt.Fprintf(w, "  %6d  %s\n", r.Failures[reason], reason)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// Source describes the synthetic input generated with a local ffmpeg: a test
+// pattern with a sine tone, so every run encodes the same content.
+type Source struct {
+	FFBin    string // Local ffmpeg used to generate the source
+	Size     string // e.g. "1280x720"
+	Rate     int    // Frames per second
+	Duration time.Duration
+}
+
+// Generate writes the source to a temporary MP4 file. Only encoders built
+// into every ffmpeg are used. cleanup removes the file.
+func (s Source) Generate(ctx context.Context) (path string, cleanup func(), err error) {
+	dir, err := os.MkdirTemp("", "ffwebapi_bench_")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+	path = filepath.Join(dir, "source.mp4")
+
+	seconds := fmt.Sprintf("%.3f", s.Duration.Seconds())
+	cmd := exec.CommandContext(ctx, s.FFBin, "-hide_banner", "-loglevel", "error", "-y",
+		"-f", "lavfi", "-i", fmt.Sprintf("testsrc2=size=%s:rate=%d", s.Size, s.Rate),
+		"-f", "lavfi", "-i", "sine=frequency=440:sample_rate=48000",
+		"-t", seconds, "-c:v", "mpeg4", "-q:v", "4", "-pix_fmt", "yuv420p", "-c:a", "aac", path)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("could not generate the test source with %s: %w: %s", s.FFBin, err, out)
+	}
+	return path, cleanup, nil
+}
@@ -0,0 +1,294 @@
+// Package client uses a remote server from the shell ("ffwebapi client"):
+// it uploads local files, submits tasks, waits for them and downloads their
+// outputs.
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"ffwebapi/task"
+)
+
+// uploadRetries is how often an interrupted upload is resumed before giving up.
+const uploadRetries = 5
+
+// Client calls the v2 API of a server.
+type Client struct {
+	Server string // Base URL, e.g. https://media.example.com
+	Key    string // API key; empty without auth
+	HTTP   *http.Client
+}
+
+// TaskRequest is the part of a task request the client sends.
+type TaskRequest struct {
+	Command    string `json:"command"`
+	InputMedia string `json:"inputMedia,omitempty"`
+	InputID    string `json:"inputId,omitempty"` // Uploaded input, instead of inputMedia
+	OutputExt  string `json:"outputExt"`
+	OutputName string `json:"outputName,omitempty"`
+}
+
+// APIError is an error response of the server.
+type APIError struct {
+	Method  string
+	Path    string
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%s %s: HTTP %d", e.Method, e.Path, e.Status)
+	if e.Code != "" {
+		msg += " " + e.Code
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Upload sends a local file as a resumable (tus) upload and returns its input
+// ID. Interrupted transfers resume where the server says they stopped.
+func (c *Client) Upload(ctx context.Context, file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := info.Size()
+
+	metadata := "filename " + base64.StdEncoding.EncodeToString([]byte(filepath.Base(file)))
+	if contentType := mime.TypeByExtension(filepath.Ext(file)); contentType != "" {
+		metadata += ",filetype " + base64.StdEncoding.EncodeToString([]byte(contentType))
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, c.url("/uploads"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Upload-Length", strconv.FormatInt(size, 10))
+	req.Header.Set("Upload-Metadata", metadata)
+	resp, err := c.send(req, http.StatusCreated)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	location := resp.Header.Get("Location")
+
+	var offset int64
+	for retries := 0; offset < size; {
+		next, err := c.patch(ctx, location, f, offset, size)
+		if err == nil {
+			offset = next
+			continue
+		}
+		if retries == uploadRetries || ctx.Err() != nil {
+			return "", err
+		}
+		retries++
+		if offset, err = c.uploadOffset(ctx, location); err != nil {
+			return "", err
+		}
+	}
+	return path.Base(location), nil
+}
+
+// patch sends the rest of the file from offset and returns the offset the
+// server reached.
+func (c *Client) patch(ctx context.Context, location string, f *os.File, offset, size int64) (int64, error) {
+	req, err := c.newRequest(ctx, http.MethodPatch, location, io.NewSectionReader(f, offset, size-offset))
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = size - offset
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	resp, err := c.send(req, http.StatusNoContent)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
+
+// uploadOffset asks how much of an upload the server received.
+func (c *Client) uploadOffset(ctx context.Context, location string) (int64, error) {
+	req, err := c.newRequest(ctx, http.MethodHead, location, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Tus-Resumable", "1.0.0")
+	resp, err := c.send(req, http.StatusOK)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
+
+// Submit creates a task and returns its ID.
+func (c *Client) Submit(ctx context.Context, tr TaskRequest) (string, error) {
+	body, err := json.Marshal(tr)
+	if err != nil {
+		return "", err
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, c.url("/tasks"), strings.NewReader(string(body)))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var accepted struct {
+		TaskID string `json:"taskId"`
+	}
+	if err := c.doJSON(req, http.StatusAccepted, &accepted); err != nil {
+		return "", err
+	}
+	return accepted.TaskID, nil
+}
+
+// Task fetches the status of a task.
+func (c *Client) Task(ctx context.Context, id string) (*task.Task, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, c.url("/tasks/"+id), nil)
+	if err != nil {
+		return nil, err
+	}
+	var t task.Task
+	if err := c.doJSON(req, http.StatusOK, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Wait polls a task until it is terminal. progress, if not nil, is called
+// with every status fetched.
+func (c *Client) Wait(ctx context.Context, id string, poll time.Duration, progress func(*task.Task)) (*task.Task, error) {
+	for {
+		t, err := c.Task(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if progress != nil {
+			progress(t)
+		}
+		if t.Status.IsTerminal() {
+			return t, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(poll):
+		}
+	}
+}
+
+// Download saves the primary output of a completed task to dst, or all its
+// outputs as a zip archive. The file only appears once it is complete.
+func (c *Client) Download(ctx context.Context, t *task.Task, zip bool, dst string) (int64, error) {
+	u := t.DownloadURL
+	if zip {
+		u = c.url("/tasks/" + t.ID + "/download?format=zip")
+	}
+	if u == "" {
+		return 0, fmt.Errorf("task %s has no output to download", t.ID)
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.send(req, http.StatusOK)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, resp.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, fmt.Errorf("download of task %s failed: %w", t.ID, err)
+	}
+	return n, os.Rename(tmp.Name(), dst)
+}
+
+func (c *Client) url(path string) string {
+	return strings.TrimSuffix(c.Server, "/") + "/api/v2" + path
+}
+
+// newRequest creates a request carrying the API key if it goes to the
+// server. Download URLs of output stores point elsewhere (e.g. presigned S3
+// URLs), which must not see the key and may reject an Authorization header.
+func (c *Client) newRequest(ctx context.Context, method, u string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.Key != "" && c.onServer(req.URL) {
+		req.Header.Set("Authorization", "Bearer "+c.Key)
+	}
+	return req, nil
+}
+
+// onServer reports whether u has the origin (scheme and host) of c.Server.
+func (c *Client) onServer(u *url.URL) bool {
+	server, err := url.Parse(c.Server)
+	return err == nil && strings.EqualFold(server.Scheme, u.Scheme) && strings.EqualFold(server.Host, u.Host)
+}
+
+// send sends a request and turns responses other than want into an
+// *APIError. The caller closes the body of successful responses.
+func (c *Client) send(req *http.Request, want int) (*http.Response, error) {
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == want {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	apiErr := &APIError{Method: req.Method, Path: req.URL.Path, Status: resp.StatusCode}
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&body) == nil {
+		apiErr.Code, apiErr.Message = body.Error.Code, body.Error.Message
+	}
+	return nil, apiErr
+}
+
+// doJSON sends a request and decodes the response into out.
+func (c *Client) doJSON(req *http.Request, want int, out interface{}) error {
+	resp, err := c.send(req, want)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ffwebapi/api"
+	"ffwebapi/auth"
+	"ffwebapi/config"
+	"ffwebapi/task"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// convertingRunner writes "converted" as the output of a task, and fails
+// the commands of the "h264-480p" preset.
+type convertingRunner struct{ dir string }
+
+func (r convertingRunner) Run(ctx context.Context, t *task.Task) (string, error) {
+	if strings.Contains(t.Command, "scale=-2:480") {
+		return "", errors.New("encoder crashed")
+	}
+	filesDir := task.FilesDir(&config.Config{TempDir: r.dir}, t.ID)
+	path := filepath.Join(filesDir, "output."+t.OutputExt)
+	if err := os.MkdirAll(filesDir, 0o700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte("converted"), 0o600); err != nil {
+		return "", err
+	}
+	t.OutputPath = path
+	t.AddArtifact(task.ArtifactOutput, task.ArtifactOutput, path)
+	return "ok", nil
+}
+
+// startServer serves the API; the first PATCH of every upload only gets
+// through halfway, like an interrupted connection.
+func startServer(t *testing.T) (*httptest.Server, *task.Manager) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	cfg := &config.Config{MaxConcurrency: 2, FFTimeout: time.Minute, TempDir: dir, MaxInputSize: 1024,
+		UploadURLTTL: time.Minute, InputTTL: time.Hour, ResumableUploadTTL: time.Hour, OutputLocalLifetime: time.Hour}
+	tm, err := task.NewManager(cfg, convertingRunner{dir: dir})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	tm.Start(ctx)
+	keys, _ := auth.NewStore(cfg)
+	router := api.SetupRouter(tm, keys, cfg)
+	interrupted := make(map[string]bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPatch && !interrupted[r.URL.Path] {
+			interrupted[r.URL.Path] = true
+			r.Body = io.NopCloser(io.LimitReader(r.Body, r.ContentLength/2))
+			r.ContentLength /= 2
+		}
+		router.ServeHTTP(w, r)
+	}))
+	t.Cleanup(func() {
+		srv.Close()
+		cancel()
+		tm.Shutdown(context.Background())
+	})
+	return srv, tm
+}
+
+func TestCommand_Convert(t *testing.T) {
+	srv, tm := startServer(t)
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.mov")
+	require.NoError(t, os.WriteFile(input, bytes.Repeat([]byte("x"), 100), 0o600))
+	output := filepath.Join(dir, "out.mp4")
+
+	var out bytes.Buffer
+	err := Command(context.Background(), []string{"convert", input, "-server", srv.URL, "-preset", "h264-720p", "-o", output, "-poll", "10ms"}, &out)
+	require.NoError(t, err, out.String())
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.Equal(t, "converted", string(data))
+	assert.Contains(t, out.String(), "completed")
+
+	tasks := tm.List()
+	require.Len(t, tasks, 1)
+	id, ok := task.InputRefID(tasks[0].InputMedia)
+	require.True(t, ok)
+	in, ok := tm.GetInput(id)
+	require.True(t, ok)
+	assert.Equal(t, task.InputUploaded, in.Status)
+	assert.EqualValues(t, 100, in.Size) // The interrupted PATCH was resumed
+
+	// The task can be downloaded again by ID, here as a zip archive.
+	archive := filepath.Join(dir, "again.zip")
+	err = Command(context.Background(), []string{"download", "-server", srv.URL, tasks[0].ID, "-o", archive}, &out)
+	require.NoError(t, err, out.String())
+	assert.FileExists(t, archive)
+}
+
+func TestCommand_Failures(t *testing.T) {
+	srv, _ := startServer(t)
+	input := filepath.Join(t.TempDir(), "input.mov")
+	require.NoError(t, os.WriteFile(input, []byte("input"), 0o600))
+	run := func(args ...string) error {
+		return Command(context.Background(), append(args, "-server", srv.URL, "-poll", "10ms"), io.Discard)
+	}
+
+	err := run("convert", input, "-preset", "h264-480p")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "encoder crashed")
+
+	assert.ErrorContains(t, run("convert", input, "-preset", "nope"), "unknown preset")
+	assert.ErrorContains(t, run("convert", input), "-preset or -command")
+	assert.ErrorContains(t, run("convert", filepath.Join(t.TempDir(), "missing.mov"), "-preset", "h264-720p"), "upload failed")
+	assert.ErrorContains(t, run("wait", "unknown"), "HTTP 404")
+	assert.ErrorContains(t, run("nope"), "unknown command")
+	assert.NoError(t, run("convert", "-h"))
+}
+
+func TestClient_DownloadOnlySendsKeyToServer(t *testing.T) {
+	var auth []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+		w.Write([]byte("output"))
+	}))
+	defer srv.Close()
+	// The same server under another host name stands in for an output store.
+	store := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	c := &Client{Server: srv.URL, Key: "secret", HTTP: srv.Client()}
+	dir := t.TempDir()
+
+	_, err := c.Download(context.Background(), &task.Task{ID: "t", DownloadURL: store + "/bucket/output.mp4?X-Amz-Signature=x"}, false, filepath.Join(dir, "a.mp4"))
+	require.NoError(t, err)
+	_, err = c.Download(context.Background(), &task.Task{ID: "t", DownloadURL: srv.URL + "/files/t/output.mp4"}, false, filepath.Join(dir, "b.mp4"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"", "Bearer secret"}, auth)
+}
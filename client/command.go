@@ -0,0 +1,286 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"ffwebapi/netguard"
+	"ffwebapi/preset"
+	"ffwebapi/task"
+)
+
+const usage = `Usage: ffwebapi client <command> [flags] [arguments]
+
+Commands:
+  convert <input>    upload or reference an input, run a task and download its output
+  submit <input>     upload or reference an input and print the ID of the task
+  wait <taskId>      wait for a task to finish
+  download <taskId>  download the output of a completed task
+
+The server and API key default to $FFWEBAPI_SERVER and $FFWEBAPI_KEY.
+Run "ffwebapi client <command> -h" for the flags of a command.
+`
+
+// Command runs "ffwebapi client" with its command-line arguments, reporting
+// progress to out.
+func Command(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+		fmt.Fprint(out, usage)
+		return nil
+	}
+	var run func(context.Context, []string, io.Writer) error
+	switch args[0] {
+	case "convert":
+		run = convert
+	case "submit":
+		run = submit
+	case "wait":
+		run = wait
+	case "download":
+		run = download
+	default:
+		return fmt.Errorf("unknown command %q (want convert, submit, wait or download)", args[0])
+	}
+	if err := run(ctx, args[1:], out); !errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+	return nil
+}
+
+// newFlagSet returns the flags of a command with those every command shares,
+// and the client they configure.
+func newFlagSet(name string, out io.Writer) (*flag.FlagSet, *Client) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(out)
+	c := &Client{HTTP: &http.Client{}}
+	server := os.Getenv("FFWEBAPI_SERVER")
+	if server == "" {
+		server = "http://localhost:8080"
+	}
+	fs.StringVar(&c.Server, "server", server, "base URL of the server")
+	fs.StringVar(&c.Key, "key", os.Getenv("FFWEBAPI_KEY"), "API key")
+	return fs, c
+}
+
+// parseArgs parses flags given before and after the positional arguments,
+// e.g. "convert input.mov -preset h264-720p", and checks the number of
+// positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string, want int, names string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if args = fs.Args(); len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	if len(positional) != want {
+		return nil, fmt.Errorf("%s: want %s", fs.Name(), names)
+	}
+	return positional, nil
+}
+
+// taskFlags are the flags of the commands that submit a task.
+type taskFlags struct {
+	preset     string
+	command    string
+	outputExt  string
+	outputName string
+}
+
+func (tf *taskFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&tf.preset, "preset", "", `preset to run, e.g. "h264-720p" (GET /presets)`)
+	fs.StringVar(&tf.command, "command", "", "ffmpeg command instead of a preset, e.g. \"-i ${INPUT_MEDIA} -c:v libx264 ${OUTPUT}\"")
+	fs.StringVar(&tf.outputExt, "ext", "", "output extension of -command")
+	fs.StringVar(&tf.outputName, "output-name", "", `download name or template, e.g. "{{.InputBasename}}_720p"`)
+}
+
+// request builds the task request, uploading input unless it is a URL or an
+// uploaded input.
+func (tf *taskFlags) request(ctx context.Context, c *Client, input string, out io.Writer) (TaskRequest, error) {
+	tr := TaskRequest{Command: tf.command, OutputExt: tf.outputExt, OutputName: tf.outputName}
+	switch {
+	case tf.preset != "" && tf.command != "":
+		return tr, fmt.Errorf("-preset and -command are mutually exclusive")
+	case tf.preset != "":
+		p, ok := preset.Lookup(tf.preset)
+		if !ok {
+			return tr, fmt.Errorf("unknown preset %q", tf.preset)
+		}
+		tr.Command, tr.OutputExt = p.Command, p.OutputExt
+	case tf.command == "" || tf.outputExt == "":
+		return tr, fmt.Errorf("either -preset or -command with -ext is required")
+	}
+
+	if netguard.IsURL(input) || strings.HasPrefix(input, task.InputRefPrefix) {
+		tr.InputMedia = input
+		return tr, nil
+	}
+	fmt.Fprintf(out, "Uploading %s\n", input)
+	id, err := c.Upload(ctx, input)
+	if err != nil {
+		return tr, fmt.Errorf("upload failed: %w", err)
+	}
+	tr.InputID = id
+	return tr, nil
+}
+
+func convert(ctx context.Context, args []string, out io.Writer) error {
+	fs, c := newFlagSet("convert", out)
+	var tf taskFlags
+	tf.register(fs)
+	output := fs.String("o", "", "output file; <input>_<preset>.<ext> if empty")
+	zip := fs.Bool("zip", false, "download all outputs as a zip archive; implied for directory outputs and -o *.zip")
+	poll := fs.Duration("poll", time.Second, "status poll interval")
+	positional, err := parseArgs(fs, args, 1, "an input file or URL")
+	if err != nil {
+		return err
+	}
+	input := positional[0]
+
+	tr, err := tf.request(ctx, c, input, out)
+	if err != nil {
+		return err
+	}
+	id, err := c.Submit(ctx, tr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Task %s submitted\n", id)
+	t, err := c.Wait(ctx, id, *poll, progressPrinter(out))
+	if err != nil {
+		return err
+	}
+	if !t.Status.Succeeded() {
+		return taskError(t)
+	}
+
+	dst := *output
+	if dst == "" {
+		name := tf.preset
+		if name == "" {
+			name = "output"
+		}
+		base := filepath.Base(input)
+		if netguard.IsURL(input) {
+			base = "output"
+		}
+		dst = strings.TrimSuffix(base, filepath.Ext(base)) + "_" + name + "." + tr.OutputExt
+	}
+	return save(ctx, c, t, *zip, dst, out)
+}
+
+func submit(ctx context.Context, args []string, out io.Writer) error {
+	fs, c := newFlagSet("submit", out)
+	var tf taskFlags
+	tf.register(fs)
+	positional, err := parseArgs(fs, args, 1, "an input file or URL")
+	if err != nil {
+		return err
+	}
+	tr, err := tf.request(ctx, c, positional[0], io.Discard)
+	if err != nil {
+		return err
+	}
+	id, err := c.Submit(ctx, tr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, id)
+	return nil
+}
+
+func wait(ctx context.Context, args []string, out io.Writer) error {
+	fs, c := newFlagSet("wait", out)
+	poll := fs.Duration("poll", time.Second, "status poll interval")
+	positional, err := parseArgs(fs, args, 1, "a task ID")
+	if err != nil {
+		return err
+	}
+	t, err := c.Wait(ctx, positional[0], *poll, progressPrinter(out))
+	if err != nil {
+		return err
+	}
+	if !t.Status.Succeeded() {
+		return taskError(t)
+	}
+	return nil
+}
+
+func download(ctx context.Context, args []string, out io.Writer) error {
+	fs, c := newFlagSet("download", out)
+	output := fs.String("o", "", "output file; the task's output name if empty")
+	zip := fs.Bool("zip", false, "download all outputs as a zip archive; implied for directory outputs and -o *.zip")
+	positional, err := parseArgs(fs, args, 1, "a task ID")
+	if err != nil {
+		return err
+	}
+	t, err := c.Task(ctx, positional[0])
+	if err != nil {
+		return err
+	}
+	if !t.Status.Succeeded() {
+		return fmt.Errorf("task %s is %s", t.ID, t.Status)
+	}
+	dst := *output
+	if dst == "" {
+		dst = t.OutputName
+	}
+	if dst == "" {
+		dst = t.ID + filepath.Ext(t.OutputPath)
+	}
+	return save(ctx, c, t, *zip, dst, out)
+}
+
+// save downloads a task's output to dst, as a zip archive if asked for or if
+// the output is more than one file.
+func save(ctx context.Context, c *Client, t *task.Task, zip bool, dst string, out io.Writer) error {
+	zip = zip || t.OutputMode == task.OutputModeDirectory || len(t.DownloadURLs) > 1 || strings.HasSuffix(dst, ".zip")
+	if zip && !strings.HasSuffix(dst, ".zip") {
+		dst = strings.TrimSuffix(dst, filepath.Ext(dst)) + ".zip"
+	}
+	n, err := c.Download(ctx, t, zip, dst)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Saved %s (%d bytes)\n", dst, n)
+	return nil
+}
+
+// progressPrinter reports status changes and whole percents of a task.
+func progressPrinter(out io.Writer) func(*task.Task) {
+	var lastStatus task.Status
+	lastPercent := -1.0
+	return func(t *task.Task) {
+		percent := math.Floor(t.Percent)
+		if t.Status == lastStatus && (t.Status != task.StatusProcessing || percent == lastPercent) {
+			return
+		}
+		lastStatus, lastPercent = t.Status, percent
+		if t.Status == task.StatusProcessing && t.Percent > 0 {
+			fmt.Fprintf(out, "Task %s %s: %.0f%%\n", t.ID, t.Status, percent)
+		} else {
+			fmt.Fprintf(out, "Task %s %s\n", t.ID, t.Status)
+		}
+	}
+}
+
+// taskError describes a task that did not succeed.
+func taskError(t *task.Task) error {
+	if t.Error != "" {
+		return fmt.Errorf("task %s %s: %s", t.ID, t.Status, t.Error)
+	}
+	return fmt.Errorf("task %s %s", t.ID, t.Status)
+}
@@ -0,0 +1,143 @@
+// ffwebapi/cmd/ffwebapi/main.go
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"ffwebapi"
+	"ffwebapi/bench"
+	"ffwebapi/client"
+	"ffwebapi/config"
+	"ffwebapi/ffmpeg"
+	"ffwebapi/logging"
+	"ffwebapi/storage"
+	"ffwebapi/task"
+	"ffwebapi/tracing"
+)
+
+func main() {
+	// "ffwebapi bench" load-tests a running server instead of being one.
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		err := bench.Command(ctx, os.Args[2:], os.Stdout)
+		stop()
+		if err != nil {
+			fatal("Benchmark failed", err)
+		}
+		return
+	}
+	// "ffwebapi client" converts files on a remote server from the shell.
+	if len(os.Args) > 1 && os.Args[1] == "client" {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		err := client.Command(ctx, os.Args[2:], os.Stdout)
+		stop()
+		if err != nil {
+			fatal("Client failed", err)
+		}
+		return
+	}
+
+	// 1. Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load configuration", err)
+	}
+	if err := logging.Setup(cfg); err != nil {
+		fatal("Failed to set up logging", err)
+	}
+
+	// Tracing is a no-op unless OTLP_TRACES_URL is set.
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg)
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+
+	// Input providers beyond the built-in ones (URLs, uploads, data URIs,
+	// local paths) are registered before the runner fetches any input.
+	if len(cfg.InputS3Buckets) > 0 {
+		s3Inputs, err := storage.NewS3Inputs(cfg)
+		if err != nil {
+			fatal("Failed to set up S3 inputs", err)
+		}
+		ffmpeg.RegisterInputProvider("s3", s3Inputs)
+	}
+	// Output stores are picked by OUTPUT_STORE or per task.
+	if cfg.OutputS3Bucket != "" {
+		s3Outputs, err := storage.NewS3Outputs(cfg)
+		if err != nil {
+			fatal("Failed to set up the s3 output store", err)
+		}
+		task.RegisterOutputStore("s3", s3Outputs)
+	}
+	if cfg.OutputWebDAVURL != "" {
+		webDAV, err := storage.NewWebDAV(cfg)
+		if err != nil {
+			fatal("Failed to set up the webdav output store", err)
+		}
+		task.RegisterOutputStore("webdav", webDAV)
+	}
+
+	// 2. Set up the task manager, the ffmpeg runner, watch folders and the API
+	server, err := ffwebapi.New(cfg)
+	if err != nil {
+		fatal("Failed to set up the server", err)
+	}
+	srv := &http.Server{
+		Addr:    ":" + cfg.Port,
+		Handler: server.Handler,
+	}
+
+	// 3. Start background services and HTTP server
+	// Create a context that can be canceled
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	server.Start(ctx)
+
+	go func() {
+		slog.Info("Server starting", "port", cfg.Port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fatal("Listen failed", err)
+		}
+	}()
+
+	// 4. Wait for interrupt signal for graceful shutdown
+	<-ctx.Done()
+
+	// Restore default behavior on the interrupt signal and notify user of shutdown.
+	stop()
+	slog.Info("Shutting down gracefully, press Ctrl+C again to force")
+
+	// Let running tasks finish first. The API keeps serving meanwhile, so
+	// clients can follow their tasks; new submissions get 503.
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.ShutdownDrainTimeout)
+	defer cancelDrain()
+	if err := server.Shutdown(drainCtx); err != nil {
+		slog.Error("Failed to save unfinished tasks", "error", err)
+	}
+
+	// The context is used to inform the server it has 5 seconds to finish
+	// the requests it is currently handling
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		fatal("Server forced to shutdown", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
+
+	slog.Info("Server exiting")
+}
+
+// fatal logs an error that prevents the server from running and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
@@ -2,7 +2,9 @@
 package config
 
 import (
+	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,19 +14,165 @@ import (
 )
 
 type Config struct {
-	FFBin               string        `mapstructure:"FF_BIN"`
-	FFTimeout           time.Duration `mapstructure:"FF_TIMEOUT"`
-	OutputLocalLifetime time.Duration `mapstructure:"OUTPUT_LOCAL_LIFETIME"`
-	MaxInputSize        int64         `mapstructure:"MAX_INPUT_SIZE"`
-	MaxConcurrency      int           `mapstructure:"MAX_CONCURRENCY"`
-	ThrottleCPU         float64       `mapstructure:"THROTTLE_CPU"`
-	ThrottleFreeMem     int64         `mapstructure:"THROTTLE_FREEMEM"`
-	ThrottleFreeDisk    int64         `mapstructure:"THROTTLE_FREEDISK"`
-	AuthEnable          bool          `mapstructure:"AUTH_ENABLE"`
-	AuthKey             string        `mapstructure:"AUTH_KEY"`
-	Port                string        `mapstructure:"PORT"`
-	BaseURL             string        `mapstructure:"BASE"`
-	TempDir             string
+	FFBin                string                   `mapstructure:"FF_BIN"`
+	FFProbeBin           string                   `mapstructure:"FFPROBE_BIN"`
+	FFBuilds             map[string]string        `mapstructure:"FF_BUILDS"`   // Further ffmpeg builds the stages of piped tasks may run, by name
+	Tools                map[string]string        `mapstructure:"TOOLS"`       // Tools besides ffmpeg tasks may run, and their binaries
+	HWProfiles           map[string]string        `mapstructure:"HW_PROFILES"` // Global args and encoder replacements per detected hardware, e.g. "nvidia=args:-hwaccel,cuda encoders:libx264=h264_nvenc"
+	FFTimeout            time.Duration            `mapstructure:"FF_TIMEOUT"`
+	ShutdownDrainTimeout time.Duration            `mapstructure:"SHUTDOWN_DRAIN_TIMEOUT"` // How long running tasks may finish on shutdown before they are interrupted
+	ShutdownReportFile   string                   `mapstructure:"SHUTDOWN_REPORT_FILE"`   // Where the shutdown report is also written as JSON; logged only if empty
+	RequeueInterrupted   bool                     `mapstructure:"REQUEUE_INTERRUPTED"`    // Queue tasks left unfinished by the previous process again on startup
+	StandbyOf            string                   `mapstructure:"STANDBY_OF"`             // Base URL of the primary whose unfinished tasks this node mirrors, taking over when it is unreachable; empty on a primary
+	StandbyAuthKey       string                   `mapstructure:"STANDBY_AUTH_KEY"`       // Admin API key for the primary; AUTH_KEY if empty
+	StandbyFailoverAfter time.Duration            `mapstructure:"STANDBY_FAILOVER_AFTER"` // How long the primary must be unreachable before a standby takes over
+	OutputLocalLifetime  time.Duration            `mapstructure:"OUTPUT_LOCAL_LIFETIME"`
+	ArtifactRetention    map[string]time.Duration `mapstructure:"ARTIFACT_RETENTION"`   // Per artifact kind; OutputLocalLifetime otherwise
+	MaxOutputTTL         time.Duration            `mapstructure:"MAX_OUTPUT_TTL"`       // Longest "outputTtl" a task may ask for
+	DownloadURLMaxTTL    time.Duration            `mapstructure:"DOWNLOAD_URL_MAX_TTL"` // Longest validity of a signed download URL
+	OutputStore          string                   `mapstructure:"OUTPUT_STORE"`         // Default output store of tasks: "local" (the temp dir), "s3", "webdav" or one registered in code
+	OutputStoreURLTTL    time.Duration            `mapstructure:"OUTPUT_STORE_URL_TTL"` // Validity of the download URLs of stored outputs
+	OutputS3Bucket       string                   `mapstructure:"OUTPUT_S3_BUCKET"`     // Bucket of the "s3" output store, with the S3_* endpoint and credentials
+	OutputS3Prefix       string                   `mapstructure:"OUTPUT_S3_PREFIX"`
+	OutputWebDAVURL      string                   `mapstructure:"OUTPUT_WEBDAV_URL"` // Collection of the "webdav" output store
+	OutputWebDAVUsername string                   `mapstructure:"OUTPUT_WEBDAV_USERNAME"`
+	OutputWebDAVPassword string                   `mapstructure:"OUTPUT_WEBDAV_PASSWORD"`
+	MaxInputSize         int64                    `mapstructure:"MAX_INPUT_SIZE"`
+	MaxOutputSize        int64                    `mapstructure:"MAX_OUTPUT_SIZE"`
+	OutputSizeWarnOnly   bool                     `mapstructure:"OUTPUT_SIZE_WARN_ONLY"`  // Accept tasks whose estimated output exceeds MAX_OUTPUT_SIZE or the storage quota, with a warning
+	QCDurationTolerance  float64                  `mapstructure:"QC_DURATION_TOLERANCE"`  // Allowed output/input duration mismatch for "qc", e.g. 0.05 = 5%
+	InlineResultMaxSize  int64                    `mapstructure:"INLINE_RESULT_MAX_SIZE"` // Largest output embedded for "inlineResult"; 0 disables it
+	InputAllowedSchemes  []string                 `mapstructure:"INPUT_ALLOWED_SCHEMES"`
+	InputAllowedPorts    []int                    `mapstructure:"INPUT_ALLOWED_PORTS"`
+	InputAllowedHosts    []string                 `mapstructure:"INPUT_ALLOWED_HOSTS"` // Host names ("*.example.com") and CIDRs inputs may come from; empty allows any
+	InputDeniedHosts     []string                 `mapstructure:"INPUT_DENIED_HOSTS"`  // Host names and CIDRs never fetched, even if allowed
+	InputAllowPrivate    bool                     `mapstructure:"INPUT_ALLOW_PRIVATE"` // Fetch from loopback, private and link-local addresses
+	InputMaxRedirects    int                      `mapstructure:"INPUT_MAX_REDIRECTS"` // Redirects followed per fetch
+	CommandAllowlist     bool                     `mapstructure:"COMMAND_ALLOWLIST"`   // Only allow the codecs, filters and output formats listed below in ffmpeg commands
+	AllowedVideoCodecs   []string                 `mapstructure:"ALLOWED_VIDEO_CODECS"`
+	AllowedAudioCodecs   []string                 `mapstructure:"ALLOWED_AUDIO_CODECS"`
+	AllowedFilters       []string                 `mapstructure:"ALLOWED_FILTERS"`      // Video and audio filters, e.g. "scale"
+	AllowedFormats       []string                 `mapstructure:"ALLOWED_FORMATS"`      // Output formats given with -f, e.g. "hls"
+	InputSources         map[string]string        `mapstructure:"INPUT_SOURCES"`        // URL templates of "source://<name>/<path>" inputs
+	InputSecrets         map[string]string        `mapstructure:"INPUT_SECRETS"`        // Values of {secret:<name>} in INPUT_SOURCES, never shown to clients
+	ZeroCopyInputDirs    []string                 `mapstructure:"ZERO_COPY_INPUT_DIRS"` // Trusted read-only mounts whose local inputs ffmpeg reads in place instead of from a copy
+	LocalInputDirs       []string                 `mapstructure:"LOCAL_INPUT_DIRS"`     // Directories API keys without the admin scope may name local inputs in, besides ZERO_COPY_INPUT_DIRS; empty allows none
+	InputS3Buckets       []string                 `mapstructure:"INPUT_S3_BUCKETS"`     // Buckets s3://<bucket>/<key> inputs are read from with the S3_* credentials; empty disables s3:// inputs
+	WatchFolders         map[string]string        `mapstructure:"WATCH_FOLDERS"`        // Folders whose new files are submitted with a preset, by preset name
+	WatchInterval        time.Duration            `mapstructure:"WATCH_INTERVAL"`       // How often WATCH_FOLDERS are scanned
+	MaxConcurrency       int                      `mapstructure:"MAX_CONCURRENCY"`
+	QueueConcurrency     map[string]int           `mapstructure:"QUEUE_CONCURRENCY"` // Named queues and their slots; "default" uses MaxConcurrency otherwise
+	QueueCapacity        int                      `mapstructure:"QUEUE_CAPACITY"`    // Queued tasks per queue before submissions get 429, unless in QUEUE_MAX_BACKLOG; 0 = unlimited
+	QueueMaxBacklog      map[string]int           `mapstructure:"QUEUE_MAX_BACKLOG"` // Per-queue QUEUE_CAPACITY; 0 = unlimited
+	MaxRetries           int                      `mapstructure:"MAX_RETRIES"`
+	MaxRetryBackoff      time.Duration            `mapstructure:"MAX_RETRY_BACKOFF"` // Upper bound for a task's retryBackoff and the doubled waits between its retries; 0 = none
+	MaxImportRows        int                      `mapstructure:"MAX_IMPORT_ROWS"`
+	AdaptiveConcurrency  bool                     `mapstructure:"ADAPTIVE_CONCURRENCY"` // Start as many queued tasks as the load allows, up to the queues' concurrency, rather than failing them on THROTTLE_*
+	ThrottleCPU          float64                  `mapstructure:"THROTTLE_CPU"`
+	ThrottleFreeMem      int64                    `mapstructure:"THROTTLE_FREEMEM"`
+	ThrottleFreeDisk     int64                    `mapstructure:"THROTTLE_FREEDISK"`
+	ResourceWaitBackoff  time.Duration            `mapstructure:"RESOURCE_WAIT_BACKOFF"` // First delay before a task the THROTTLE_* check turned away is tried again; doubles each time
+	ResourceMaxWait      time.Duration            `mapstructure:"RESOURCE_MAX_WAIT"`     // How long a task waits for resources before it fails; 0 = fail right away
+	AuthEnable           bool                     `mapstructure:"AUTH_ENABLE"`
+	AuthKey              string                   `mapstructure:"AUTH_KEY"`
+	JWTSecret            string                   `mapstructure:"JWT_SECRET"`        // HMAC key of accepted JWTs
+	JWTJWKSURL           string                   `mapstructure:"JWT_JWKS_URL"`      // Key set of accepted RS/ES-signed JWTs
+	JWTIssuer            string                   `mapstructure:"JWT_ISSUER"`        // Required "iss" of JWTs, if set
+	JWTAudience          string                   `mapstructure:"JWT_AUDIENCE"`      // Required "aud" of JWTs, if set
+	QuotaMaxRunning      int                      `mapstructure:"QUOTA_MAX_RUNNING"` // Default quotas of API keys; 0 = unlimited
+	QuotaMaxStorage      int64                    `mapstructure:"QUOTA_MAX_STORAGE"`
+	QuotaMonthlyCPU      time.Duration            `mapstructure:"QUOTA_MONTHLY_CPU"`
+	DataDir              string                   `mapstructure:"DATA_DIR"`               // Persistent state such as API keys; in memory only if empty
+	StatsRetention       time.Duration            `mapstructure:"STATS_RETENTION"`        // How long hourly task stats are kept
+	BillingRetention     time.Duration            `mapstructure:"BILLING_RETENTION"`      // How long billing line items are kept; 0 keeps them forever
+	TaskRetention        time.Duration            `mapstructure:"TASK_RETENTION"`         // How long finished tasks stay listed before they are evicted; 0 keeps them until a restart
+	HistoryExport        bool                     `mapstructure:"HISTORY_EXPORT"`         // Archive evicted tasks and their logs to the S3 bucket, as NDJSON dumps per day
+	IdempotencyWindow    time.Duration            `mapstructure:"IDEMPOTENCY_WINDOW"`     // How long Idempotency-Key headers of task submissions are remembered; 0 ignores them
+	RateLimitRequests    int                      `mapstructure:"RATE_LIMIT_REQUESTS"`    // Per client and minute; 0 = unlimited
+	RateLimitSubmissions int                      `mapstructure:"RATE_LIMIT_SUBMISSIONS"` // Task submissions per client and hour; 0 = unlimited
+	NodeName             string                   `mapstructure:"NODE_NAME"`              // Name of this node in GET /nodes; the host name if empty
+	NodeLabels           []string                 `mapstructure:"NODE_LABELS"`            // Labels tasks may require of their node, e.g. "gpu,region:eu"
+	Port                 string                   `mapstructure:"PORT"`
+	BaseURL              string                   `mapstructure:"BASE"`
+	CORSAllowedOrigins   []string                 `mapstructure:"CORS_ALLOWED_ORIGINS"` // Origins browsers may call the API from, e.g. "https://app.example.com" or "https://*.example.com"; empty disables CORS
+	CORSAllowedMethods   []string                 `mapstructure:"CORS_ALLOWED_METHODS"`
+	CORSAllowedHeaders   []string                 `mapstructure:"CORS_ALLOWED_HEADERS"`   // Request headers scripts may send
+	CORSExposedHeaders   []string                 `mapstructure:"CORS_EXPOSED_HEADERS"`   // Response headers scripts may read
+	CORSAllowCredentials bool                     `mapstructure:"CORS_ALLOW_CREDENTIALS"` // Let browsers send cookies and HTTP auth
+	CORSMaxAge           time.Duration            `mapstructure:"CORS_MAX_AGE"`           // How long browsers may cache a preflight
+	UIEnable             bool                     `mapstructure:"UI_ENABLE"`              // Serve the web dashboard at /ui
+	APIV1Sunset          time.Time                `mapstructure:"API_V1_SUNSET"`
+	SyncTimeout          time.Duration            `mapstructure:"SYNC_TIMEOUT"`
+	SyncFastConcurrency  int                      `mapstructure:"SYNC_FAST_CONCURRENCY"`
+	SyncFastTimeout      time.Duration            `mapstructure:"SYNC_FAST_TIMEOUT"`
+	SyncFastMaxDuration  time.Duration            `mapstructure:"SYNC_FAST_MAX_DURATION"`
+	TransformCacheTTL    time.Duration            `mapstructure:"TRANSFORM_CACHE_TTL"`      // How long /transform results are reused; 0 disables the cache
+	TransformCacheSize   int64                    `mapstructure:"TRANSFORM_CACHE_MAX_SIZE"` // Oldest results are evicted beyond it; 0 = unlimited
+	InputCacheSize       int64                    `mapstructure:"INPUT_CACHE_MAX_SIZE"`     // Downloaded inputs are kept for tasks reading the same URL up to this size; 0 disables the cache
+	DedupeTasks          bool                     `mapstructure:"DEDUPE_TASKS"`             // Reuse the output of a completed task with the same command and input content
+	CallbackTimeout      time.Duration            `mapstructure:"CALLBACK_TIMEOUT"`
+	CallbackMaxRetries   int                      `mapstructure:"CALLBACK_MAX_RETRIES"`
+	CallbackRetryBackoff time.Duration            `mapstructure:"CALLBACK_RETRY_BACKOFF"`
+	NotifyEmailTo        []string                 `mapstructure:"NOTIFY_EMAIL_TO"`
+	NotifyOn             []string                 `mapstructure:"NOTIFY_ON"`            // Final statuses notified of, e.g. "failed"; all but "interrupted" if empty
+	NotifyEmailDomains   []string                 `mapstructure:"NOTIFY_EMAIL_DOMAINS"` // Domains tasks may send notification emails to; empty allows none
+	SMTPHost             string                   `mapstructure:"SMTP_HOST"`            // Mail server of notification emails; STARTTLS is used if offered
+	SMTPPort             int                      `mapstructure:"SMTP_PORT"`
+	SMTPUsername         string                   `mapstructure:"SMTP_USERNAME"`
+	SMTPPassword         string                   `mapstructure:"SMTP_PASSWORD"`
+	SMTPFrom             string                   `mapstructure:"SMTP_FROM"`     // e.g. "FFwebAPI <ffwebapi@example.com>"
+	EventsBroker         string                   `mapstructure:"EVENTS_BROKER"` // "nats", "kafka" or "amqp" to publish task lifecycle events; empty disables them
+	EventsURL            string                   `mapstructure:"EVENTS_URL"`    // NATS server, Kafka REST Proxy or RabbitMQ management API
+	EventsTopic          string                   `mapstructure:"EVENTS_TOPIC"`  // NATS subject prefix, Kafka topic or RabbitMQ exchange
+	EventsAMQPVHost      string                   `mapstructure:"EVENTS_AMQP_VHOST"`
+	EventsTimeout        time.Duration            `mapstructure:"EVENTS_TIMEOUT"`
+	EventsBufferSize     int                      `mapstructure:"EVENTS_BUFFER_SIZE"` // Events held while the broker is unreachable; newer ones are dropped beyond it
+	STTURL               string                   `mapstructure:"STT_URL"`
+	STTCommand           string                   `mapstructure:"STT_COMMAND"`
+	STTModel             string                   `mapstructure:"STT_MODEL"`
+	STTAuthToken         string                   `mapstructure:"STT_AUTH_TOKEN"`
+	STTTimeout           time.Duration            `mapstructure:"STT_TIMEOUT"`
+	InputStorage         string                   `mapstructure:"INPUT_STORAGE"`        // "local" or "s3", for uploaded inputs
+	UploadURLTTL         time.Duration            `mapstructure:"UPLOAD_URL_TTL"`       // Validity of signed upload URLs
+	InputTTL             time.Duration            `mapstructure:"INPUT_TTL"`            // Lifetime of inputs no task uses
+	ResumableUploadTTL   time.Duration            `mapstructure:"RESUMABLE_UPLOAD_TTL"` // How long a tus upload may take to complete
+	UploadQuota          int64                    `mapstructure:"UPLOAD_QUOTA"`         // Bytes of inputs held per uploader; 0 = unlimited
+	UploadSigningKey     string                   `mapstructure:"UPLOAD_SIGNING_KEY"`   // HMAC key of local upload URLs and download URLs; random per process if empty
+	S3Endpoint           string                   `mapstructure:"S3_ENDPOINT"`
+	S3Region             string                   `mapstructure:"S3_REGION"`
+	S3Bucket             string                   `mapstructure:"S3_BUCKET"`
+	S3Prefix             string                   `mapstructure:"S3_PREFIX"`
+	S3AccessKeyID        string                   `mapstructure:"S3_ACCESS_KEY_ID"`
+	S3SecretAccessKey    string                   `mapstructure:"S3_SECRET_ACCESS_KEY"`
+	LogFormat            string                   `mapstructure:"LOG_FORMAT"` // "text" or "json"
+	LogLevel             string                   `mapstructure:"LOG_LEVEL"`
+	LogBufferSize        int64                    `mapstructure:"LOG_BUFFER_SIZE"` // Tail of a task's ffmpeg output kept in memory and in "ffmpegOutput"; the whole output goes to its log file. 0 = all
+	OTLPTracesURL        string                   `mapstructure:"OTLP_TRACES_URL"` // OTLP/HTTP endpoint, e.g. http://collector:4318/v1/traces; empty disables tracing
+	TracingSampleRatio   float64                  `mapstructure:"TRACING_SAMPLE_RATIO"`
+	RunAsUser            string                   `mapstructure:"RUN_AS_USER"`
+	RunAsUIDRange        string                   `mapstructure:"RUN_AS_UID_RANGE"`
+	FFSandbox            string                   `mapstructure:"FF_SANDBOX"`        // "bwrap" or "firejail" to confine task commands to their working directory; empty disables it
+	FFNice               int                      `mapstructure:"FF_NICE"`           // Niceness of task commands; 0 leaves it unchanged
+	FFIONice             string                   `mapstructure:"FF_IONICE"`         // "idle" or "best-effort[:0-7]"; empty leaves it unchanged
+	FFRlimitCPU          time.Duration            `mapstructure:"FF_RLIMIT_CPU"`     // CPU time a task command may use; 0 = unlimited
+	FFRlimitAS           int64                    `mapstructure:"FF_RLIMIT_AS"`      // Address space of a task command; 0 = unlimited
+	FFRlimitNoFile       int                      `mapstructure:"FF_RLIMIT_NOFILE"`  // Open files of a task command; 0 = unlimited
+	FFRlimitNProc        int                      `mapstructure:"FF_RLIMIT_NPROC"`   // Processes and threads of the command's user; 0 = unlimited
+	CgroupRoot           string                   `mapstructure:"CGROUP_ROOT"`       // Delegated cgroup v2 directory; every task command gets a child cgroup in it. Empty disables cgroups
+	CgroupCPUMax         float64                  `mapstructure:"CGROUP_CPU_MAX"`    // CPUs a task command may use (cpu.max); 0 = unlimited
+	CgroupMemoryMax      int64                    `mapstructure:"CGROUP_MEMORY_MAX"` // Memory a task command may use (memory.max); 0 = unlimited
+	ResourceClasses      map[string]string        `mapstructure:"RESOURCE_CLASSES"`  // Named cgroup limits tasks may ask for, e.g. "4k=cpu:8 memory:16GB"
+	TempDir              string
+
+	RateLimitRequestsBurst    int `mapstructure:"RATE_LIMIT_REQUESTS_BURST"`    // Requests a client may send at once; RATE_LIMIT_REQUESTS if lower
+	RateLimitSubmissionsBurst int `mapstructure:"RATE_LIMIT_SUBMISSIONS_BURST"` // Submissions a client may send at once; RATE_LIMIT_SUBMISSIONS if lower
+
+	NotifySlackWebhookURL   string `mapstructure:"NOTIFY_SLACK_WEBHOOK_URL"` // Notified of every task without its own "notify"
+	NotifyDiscordWebhookURL string `mapstructure:"NOTIFY_DISCORD_WEBHOOK_URL"`
+
+	OutputWebDAVPublicURL string `mapstructure:"OUTPUT_WEBDAV_PUBLIC_URL"` // Where clients download the stored files; OUTPUT_WEBDAV_URL if empty
+
+	ProcessSampleInterval time.Duration `mapstructure:"PROCESS_SAMPLE_INTERVAL"` // How often the process tree of running tasks is sampled; 0 disables it
 }
 
 // stringToDurationHookFunc is a custom Viper hook for parsing Go's duration strings.
@@ -45,6 +193,119 @@ func stringToDurationHookFunc() mapstructure.DecodeHookFunc {
 	}
 }
 
+// stringToDateHookFunc parses dates ("2006-01-02") or RFC 3339 timestamps.
+// An empty string yields the zero time.
+func stringToDateHookFunc() mapstructure.DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String || t != reflect.TypeOf(time.Time{}) {
+			return data, nil
+		}
+
+		s := data.(string)
+		if s == "" {
+			return time.Time{}, nil
+		}
+		if d, err := time.Parse("2006-01-02", s); err == nil {
+			return d, nil
+		}
+		return time.Parse(time.RFC3339, s)
+	}
+}
+
+// stringToDurationMapHookFunc parses per-key durations given as a string,
+// e.g. "log=24h,thumbnail=30m". YAML maps are decoded as usual.
+func stringToDurationMapHookFunc() mapstructure.DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String || t != reflect.TypeOf(map[string]time.Duration{}) {
+			return data, nil
+		}
+
+		m := map[string]time.Duration{}
+		for _, pair := range strings.Split(data.(string), ",") {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid entry %q, want key=duration", pair)
+			}
+			d, err := time.ParseDuration(strings.TrimSpace(value))
+			if err != nil {
+				return nil, err
+			}
+			m[strings.TrimSpace(key)] = d
+		}
+		return m, nil
+	}
+}
+
+// stringToIntMapHookFunc parses per-key numbers given as a string, e.g.
+// "bulk=2,priority=1". YAML maps are decoded as usual.
+func stringToIntMapHookFunc() mapstructure.DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String || t != reflect.TypeOf(map[string]int{}) {
+			return data, nil
+		}
+
+		m := map[string]int{}
+		for _, pair := range strings.Split(data.(string), ",") {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid entry %q, want key=number", pair)
+			}
+			n, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return nil, err
+			}
+			m[strings.TrimSpace(key)] = n
+		}
+		return m, nil
+	}
+}
+
+// stringToStringMapHookFunc parses per-key strings given as a string, e.g.
+// "cdn=https://cdn.example.com/{path}". Values may contain "=" but not ",".
+// YAML maps are decoded as usual.
+func stringToStringMapHookFunc() mapstructure.DecodeHookFunc {
+	return func(
+		f reflect.Type,
+		t reflect.Type,
+		data interface{},
+	) (interface{}, error) {
+		if f.Kind() != reflect.String || t != reflect.TypeOf(map[string]string{}) {
+			return data, nil
+		}
+
+		m := map[string]string{}
+		for _, pair := range strings.Split(data.(string), ",") {
+			if strings.TrimSpace(pair) == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid entry %q, want key=value", pair)
+			}
+			m[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+		return m, nil
+	}
+}
+
 // stringToByteSizeHookFunc is a custom Viper hook for parsing human-readable size strings.
 func stringToByteSizeHookFunc() mapstructure.DecodeHookFunc {
 	return func(
@@ -69,21 +330,7 @@ func stringToByteSizeHookFunc() mapstructure.DecodeHookFunc {
 }
 
 func Load() (*Config, error) {
-	vp := viper.New()
-
-	// Set default values as strings, the hooks will handle them.
-	vp.SetDefault("FF_BIN", "ffmpeg")
-	vp.SetDefault("FF_TIMEOUT", "12m3s")
-	vp.SetDefault("OUTPUT_LOCAL_LIFETIME", "1h23m")
-	vp.SetDefault("MAX_INPUT_SIZE", "200MB")
-	vp.SetDefault("MAX_CONCURRENCY", 1)
-	vp.SetDefault("THROTTLE_CPU", 50.0)
-	vp.SetDefault("THROTTLE_FREEMEM", "200MB")
-	vp.SetDefault("THROTTLE_FREEDISK", "200MB")
-	vp.SetDefault("AUTH_ENABLE", false)
-	vp.SetDefault("AUTH_KEY", "123456")
-	vp.SetDefault("PORT", "8080")
-	vp.SetDefault("BASE", "")
+	vp := newViper()
 
 	// Load from config file
 	vp.SetConfigName("ffwebapi_config")
@@ -102,6 +349,184 @@ func Load() (*Config, error) {
 	vp.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	vp.AutomaticEnv()
 
+	return decode(vp)
+}
+
+// Defaults returns the built-in configuration, ignoring config files and
+// the environment.
+func Defaults() (*Config, error) {
+	return decode(newViper())
+}
+
+// newViper returns a viper instance holding the defaults.
+func newViper() *viper.Viper {
+	vp := viper.New()
+
+	// Set default values as strings, the hooks will handle them.
+	vp.SetDefault("FF_BIN", "ffmpeg")
+	vp.SetDefault("FFPROBE_BIN", "ffprobe")
+	vp.SetDefault("TOOLS", "")
+	vp.SetDefault("FF_BUILDS", "")
+	vp.SetDefault("HW_PROFILES", "")
+	vp.SetDefault("FF_TIMEOUT", "12m3s")
+	vp.SetDefault("PROCESS_SAMPLE_INTERVAL", "2s")
+	vp.SetDefault("SHUTDOWN_DRAIN_TIMEOUT", "5m")
+	vp.SetDefault("SHUTDOWN_REPORT_FILE", "")
+	vp.SetDefault("REQUEUE_INTERRUPTED", false)
+	vp.SetDefault("STANDBY_OF", "")
+	vp.SetDefault("STANDBY_AUTH_KEY", "")
+	vp.SetDefault("STANDBY_FAILOVER_AFTER", "30s")
+	vp.SetDefault("OUTPUT_LOCAL_LIFETIME", "1h23m")
+	vp.SetDefault("ARTIFACT_RETENTION", "")
+	vp.SetDefault("MAX_OUTPUT_TTL", "168h")
+	vp.SetDefault("DOWNLOAD_URL_MAX_TTL", "24h")
+	vp.SetDefault("OUTPUT_STORE", "local")
+	vp.SetDefault("OUTPUT_STORE_URL_TTL", "1h")
+	vp.SetDefault("OUTPUT_S3_BUCKET", "")
+	vp.SetDefault("OUTPUT_S3_PREFIX", "")
+	vp.SetDefault("OUTPUT_WEBDAV_URL", "")
+	vp.SetDefault("OUTPUT_WEBDAV_USERNAME", "")
+	vp.SetDefault("OUTPUT_WEBDAV_PASSWORD", "")
+	vp.SetDefault("OUTPUT_WEBDAV_PUBLIC_URL", "")
+	vp.SetDefault("MAX_INPUT_SIZE", "200MB")
+	vp.SetDefault("MAX_OUTPUT_SIZE", "0")
+	vp.SetDefault("OUTPUT_SIZE_WARN_ONLY", false)
+	vp.SetDefault("INLINE_RESULT_MAX_SIZE", "256KB")
+	vp.SetDefault("QC_DURATION_TOLERANCE", 0.05)
+	vp.SetDefault("INPUT_ALLOWED_SCHEMES", []string{"http", "https"})
+	vp.SetDefault("INPUT_ALLOWED_PORTS", []int{})
+	vp.SetDefault("COMMAND_ALLOWLIST", false)
+	vp.SetDefault("ALLOWED_VIDEO_CODECS", []string{"copy", "libx264", "libx265", "libvpx-vp9", "libsvtav1", "libaom-av1", "mpeg2video", "mpeg4", "mjpeg", "png", "apng", "prores_ks", "libwebp", "gif"})
+	vp.SetDefault("ALLOWED_AUDIO_CODECS", []string{"copy", "aac", "libopus", "libmp3lame", "flac", "pcm_s16le", "pcm_s24le"})
+	vp.SetDefault("ALLOWED_FILTERS", []string{"scale", "fps", "format", "pad", "crop", "setsar", "setdar", "transpose", "hflip", "vflip", "trim", "atrim", "setpts", "asetpts", "thumbnail", "select", "loudnorm", "volume", "aresample", "aformat"})
+	vp.SetDefault("ALLOWED_FORMATS", []string{"mp4", "mov", "matroska", "webm", "mpegts", "hls", "dash", "mp3", "ogg", "opus", "wav", "flac", "image2", "ipod", "apng", "mxf"})
+	vp.SetDefault("INPUT_ALLOWED_HOSTS", []string{})
+	vp.SetDefault("INPUT_DENIED_HOSTS", []string{})
+	vp.SetDefault("INPUT_ALLOW_PRIVATE", false)
+	vp.SetDefault("INPUT_MAX_REDIRECTS", 5)
+	vp.SetDefault("INPUT_SOURCES", "")
+	vp.SetDefault("INPUT_SECRETS", "")
+	vp.SetDefault("ZERO_COPY_INPUT_DIRS", []string{})
+	vp.SetDefault("LOCAL_INPUT_DIRS", []string{})
+	vp.SetDefault("INPUT_S3_BUCKETS", []string{})
+	vp.SetDefault("WATCH_FOLDERS", map[string]string{})
+	vp.SetDefault("WATCH_INTERVAL", "10s")
+	vp.SetDefault("MAX_CONCURRENCY", 1)
+	vp.SetDefault("QUEUE_CONCURRENCY", "")
+	vp.SetDefault("QUEUE_CAPACITY", 0)
+	vp.SetDefault("QUEUE_MAX_BACKLOG", "")
+	vp.SetDefault("MAX_RETRIES", 5)
+	vp.SetDefault("MAX_RETRY_BACKOFF", "1h")
+	vp.SetDefault("MAX_IMPORT_ROWS", 50000)
+	vp.SetDefault("ADAPTIVE_CONCURRENCY", false)
+	vp.SetDefault("THROTTLE_CPU", 50.0)
+	vp.SetDefault("THROTTLE_FREEMEM", "200MB")
+	vp.SetDefault("THROTTLE_FREEDISK", "200MB")
+	vp.SetDefault("RESOURCE_WAIT_BACKOFF", "5s")
+	vp.SetDefault("RESOURCE_MAX_WAIT", "10m")
+	vp.SetDefault("AUTH_ENABLE", false)
+	vp.SetDefault("AUTH_KEY", "123456")
+	vp.SetDefault("JWT_SECRET", "")
+	vp.SetDefault("JWT_JWKS_URL", "")
+	vp.SetDefault("JWT_ISSUER", "")
+	vp.SetDefault("JWT_AUDIENCE", "")
+	vp.SetDefault("QUOTA_MAX_RUNNING", 0)
+	vp.SetDefault("QUOTA_MAX_STORAGE", "0")
+	vp.SetDefault("QUOTA_MONTHLY_CPU", "0s")
+	vp.SetDefault("DATA_DIR", "")
+	vp.SetDefault("STATS_RETENTION", "2160h")
+	vp.SetDefault("BILLING_RETENTION", "9600h")
+	vp.SetDefault("TASK_RETENTION", "0")
+	vp.SetDefault("HISTORY_EXPORT", false)
+	vp.SetDefault("IDEMPOTENCY_WINDOW", "24h")
+	vp.SetDefault("RATE_LIMIT_REQUESTS", 0)
+	vp.SetDefault("RATE_LIMIT_REQUESTS_BURST", 0)
+	vp.SetDefault("RATE_LIMIT_SUBMISSIONS", 0)
+	vp.SetDefault("RATE_LIMIT_SUBMISSIONS_BURST", 0)
+	vp.SetDefault("NODE_NAME", "")
+	vp.SetDefault("NODE_LABELS", []string{})
+	vp.SetDefault("PORT", "8080")
+	vp.SetDefault("BASE", "")
+	vp.SetDefault("CORS_ALLOWED_ORIGINS", []string{})
+	vp.SetDefault("CORS_ALLOWED_METHODS", []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"})
+	vp.SetDefault("CORS_ALLOWED_HEADERS", []string{"Authorization", "Content-Type", "Idempotency-Key", "X-Request-ID",
+		"Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata"})
+	vp.SetDefault("CORS_EXPOSED_HEADERS", []string{"Content-Disposition", "Location", "Retry-After", "X-Request-ID", "X-Task-Id",
+		"X-Next-Cursor", "X-Poll-After", "X-RateLimit-Limit", "X-RateLimit-Burst", "X-RateLimit-Remaining", "X-RateLimit-Reset",
+		"X-Submit-RateLimit-Limit", "X-Submit-RateLimit-Burst", "X-Submit-RateLimit-Remaining", "X-Submit-RateLimit-Reset",
+		"Idempotent-Replayed", "ETag", "X-Cache",
+		"Deprecation", "Sunset", "Link", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size",
+		"Upload-Offset", "Upload-Length", "Upload-Expires"})
+	vp.SetDefault("CORS_ALLOW_CREDENTIALS", false)
+	vp.SetDefault("CORS_MAX_AGE", "10m")
+	vp.SetDefault("UI_ENABLE", true)
+	vp.SetDefault("API_V1_SUNSET", "")
+	vp.SetDefault("STT_URL", "")
+	vp.SetDefault("STT_COMMAND", "")
+	vp.SetDefault("STT_MODEL", "")
+	vp.SetDefault("STT_AUTH_TOKEN", "")
+	vp.SetDefault("STT_TIMEOUT", "10m")
+	vp.SetDefault("INPUT_STORAGE", "local")
+	vp.SetDefault("UPLOAD_URL_TTL", "1h")
+	vp.SetDefault("INPUT_TTL", "24h")
+	vp.SetDefault("RESUMABLE_UPLOAD_TTL", "24h")
+	vp.SetDefault("UPLOAD_QUOTA", "0")
+	vp.SetDefault("UPLOAD_SIGNING_KEY", "")
+	vp.SetDefault("S3_ENDPOINT", "")
+	vp.SetDefault("S3_REGION", "us-east-1")
+	vp.SetDefault("S3_BUCKET", "")
+	vp.SetDefault("S3_PREFIX", "")
+	vp.SetDefault("S3_ACCESS_KEY_ID", "")
+	vp.SetDefault("S3_SECRET_ACCESS_KEY", "")
+	vp.SetDefault("LOG_FORMAT", "text")
+	vp.SetDefault("LOG_LEVEL", "info")
+	vp.SetDefault("LOG_BUFFER_SIZE", "16KB")
+	vp.SetDefault("OTLP_TRACES_URL", "")
+	vp.SetDefault("TRACING_SAMPLE_RATIO", 1.0)
+	vp.SetDefault("RUN_AS_USER", "")
+	vp.SetDefault("RUN_AS_UID_RANGE", "")
+	vp.SetDefault("FF_SANDBOX", "")
+	vp.SetDefault("FF_NICE", 0)
+	vp.SetDefault("FF_IONICE", "")
+	vp.SetDefault("FF_RLIMIT_CPU", "0")
+	vp.SetDefault("FF_RLIMIT_AS", "0")
+	vp.SetDefault("FF_RLIMIT_NOFILE", 0)
+	vp.SetDefault("FF_RLIMIT_NPROC", 0)
+	vp.SetDefault("CGROUP_ROOT", "")
+	vp.SetDefault("CGROUP_CPU_MAX", 0)
+	vp.SetDefault("CGROUP_MEMORY_MAX", "0")
+	vp.SetDefault("RESOURCE_CLASSES", "")
+	vp.SetDefault("SYNC_TIMEOUT", "2m")
+	vp.SetDefault("SYNC_FAST_CONCURRENCY", 2)
+	vp.SetDefault("SYNC_FAST_TIMEOUT", "30s")
+	vp.SetDefault("SYNC_FAST_MAX_DURATION", "1m")
+	vp.SetDefault("TRANSFORM_CACHE_TTL", "24h")
+	vp.SetDefault("TRANSFORM_CACHE_MAX_SIZE", "1GB")
+	vp.SetDefault("INPUT_CACHE_MAX_SIZE", 0)
+	vp.SetDefault("DEDUPE_TASKS", false)
+	vp.SetDefault("CALLBACK_TIMEOUT", "10s")
+	vp.SetDefault("CALLBACK_MAX_RETRIES", 5)
+	vp.SetDefault("CALLBACK_RETRY_BACKOFF", "10s")
+	vp.SetDefault("NOTIFY_SLACK_WEBHOOK_URL", "")
+	vp.SetDefault("NOTIFY_DISCORD_WEBHOOK_URL", "")
+	vp.SetDefault("NOTIFY_EMAIL_TO", []string{})
+	vp.SetDefault("NOTIFY_ON", []string{})
+	vp.SetDefault("NOTIFY_EMAIL_DOMAINS", []string{})
+	vp.SetDefault("SMTP_HOST", "")
+	vp.SetDefault("SMTP_PORT", 587)
+	vp.SetDefault("SMTP_USERNAME", "")
+	vp.SetDefault("SMTP_PASSWORD", "")
+	vp.SetDefault("SMTP_FROM", "")
+	vp.SetDefault("EVENTS_BROKER", "")
+	vp.SetDefault("EVENTS_URL", "")
+	vp.SetDefault("EVENTS_TOPIC", "ffwebapi.tasks")
+	vp.SetDefault("EVENTS_AMQP_VHOST", "/")
+	vp.SetDefault("EVENTS_TIMEOUT", "10s")
+	vp.SetDefault("EVENTS_BUFFER_SIZE", 1000)
+	return vp
+}
+
+func decode(vp *viper.Viper) (*Config, error) {
 	var cfg Config
 	// Unmarshal the config, providing our custom composed hooks.
 	// The order matters: the first hook that succeeds is used.
@@ -109,12 +534,27 @@ func Load() (*Config, error) {
 	err := vp.Unmarshal(&cfg, viper.DecodeHook(
 		mapstructure.ComposeDecodeHookFunc(
 			stringToDurationHookFunc(),
+			stringToDurationMapHookFunc(),
+			stringToIntMapHookFunc(),
+			stringToStringMapHookFunc(),
 			stringToByteSizeHookFunc(),
+			stringToDateHookFunc(),
+			// Lists can be given as comma-separated env vars, e.g. "http,https".
+			mapstructure.StringToSliceHookFunc(","),
 		),
 	))
 	if err != nil {
 		return nil, err
 	}
+	// Browsers refuse "*" on credentialed responses, and allowing every
+	// origin by name instead would let any site call the API as the user.
+	if cfg.CORSAllowCredentials {
+		for _, origin := range cfg.CORSAllowedOrigins {
+			if origin == "*" {
+				return nil, fmt.Errorf("CORS_ALLOW_CREDENTIALS cannot be used with CORS_ALLOWED_ORIGINS \"*\"; list the origins instead")
+			}
+		}
+	}
 
 	return &cfg, nil
 }
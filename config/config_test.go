@@ -27,7 +27,19 @@ func TestLoadConfig(t *testing.T) {
 		assert.Equal(t, false, cfg.AuthEnable)
 		assert.Equal(t, "ffmpeg", cfg.FFBin)
 		assert.Equal(t, 12*time.Minute+3*time.Second, cfg.FFTimeout)
+		assert.Equal(t, 5*time.Minute, cfg.ShutdownDrainTimeout)
+		assert.Equal(t, 2*time.Second, cfg.ProcessSampleInterval)
+		assert.Equal(t, 168*time.Hour, cfg.MaxOutputTTL)
+		assert.Equal(t, 24*time.Hour, cfg.DownloadURLMaxTTL)
 		assert.Equal(t, int64(200*1024*1024), cfg.MaxInputSize)
+		assert.Equal(t, int64(0), cfg.MaxOutputSize)
+		assert.False(t, cfg.OutputSizeWarnOnly)
+		assert.Equal(t, []string{"http", "https"}, cfg.InputAllowedSchemes)
+		assert.False(t, cfg.InputAllowPrivate)
+		assert.False(t, cfg.CommandAllowlist)
+		assert.Contains(t, cfg.AllowedVideoCodecs, "libx264")
+		assert.Contains(t, cfg.AllowedFilters, "scale")
+		assert.Equal(t, 5, cfg.InputMaxRedirects)
 	})
 
 	t.Run("overrides defaults with environment variables", func(t *testing.T) {
@@ -36,6 +48,16 @@ func TestLoadConfig(t *testing.T) {
 		t.Setenv("FFWEBAPI_AUTH_ENABLE", "true")
 		t.Setenv("FFWEBAPI_AUTH_KEY", "newsecret")
 		t.Setenv("FFWEBAPI_MAX_INPUT_SIZE", "50MB")
+		t.Setenv("FFWEBAPI_INPUT_ALLOWED_SCHEMES", "https")
+		t.Setenv("FFWEBAPI_INPUT_ALLOWED_PORTS", "443,8443")
+		t.Setenv("FFWEBAPI_INPUT_DENIED_HOSTS", "10.0.0.0/8,*.internal")
+		t.Setenv("FFWEBAPI_ALLOWED_AUDIO_CODECS", "aac,libopus")
+		t.Setenv("FFWEBAPI_ARTIFACT_RETENTION", "log=24h, thumbnail=30m")
+		t.Setenv("FFWEBAPI_QUEUE_CONCURRENCY", "bulk=2, priority=1")
+		t.Setenv("FFWEBAPI_TOOLS", "mkvmerge=/usr/bin/mkvmerge, magick=magick")
+		t.Setenv("FFWEBAPI_INPUT_SOURCES", "cdn=https://cdn.example.com/{path}?token={secret:cdn_token}")
+		t.Setenv("FFWEBAPI_FF_RLIMIT_AS", "4GB")
+		t.Setenv("FFWEBAPI_FF_RLIMIT_CPU", "30m")
 
 		cfg, err := config.Load() // Use the package prefix
 		assert.NoError(t, err)
@@ -46,5 +68,38 @@ func TestLoadConfig(t *testing.T) {
 		assert.Equal(t, true, cfg.AuthEnable)
 		assert.Equal(t, "newsecret", cfg.AuthKey)
 		assert.Equal(t, int64(50*1024*1024), cfg.MaxInputSize)
+		assert.Equal(t, []string{"https"}, cfg.InputAllowedSchemes)
+		assert.Equal(t, []int{443, 8443}, cfg.InputAllowedPorts)
+		assert.Equal(t, []string{"10.0.0.0/8", "*.internal"}, cfg.InputDeniedHosts)
+		assert.Equal(t, []string{"aac", "libopus"}, cfg.AllowedAudioCodecs)
+		assert.Equal(t, map[string]time.Duration{"log": 24 * time.Hour, "thumbnail": 30 * time.Minute}, cfg.ArtifactRetention)
+		assert.Equal(t, map[string]int{"bulk": 2, "priority": 1}, cfg.QueueConcurrency)
+		assert.Equal(t, map[string]string{"cdn": "https://cdn.example.com/{path}?token={secret:cdn_token}"}, cfg.InputSources)
+		assert.Equal(t, map[string]string{"mkvmerge": "/usr/bin/mkvmerge", "magick": "magick"}, cfg.Tools)
+		assert.Equal(t, int64(4*1024*1024*1024), cfg.FFRlimitAS)
+		assert.Equal(t, 30*time.Minute, cfg.FFRlimitCPU)
 	})
 }
+
+func TestLoadConfig_CORSCredentials(t *testing.T) {
+	t.Setenv("FFWEBAPI_CORS_ALLOWED_ORIGINS", "*")
+	t.Setenv("FFWEBAPI_CORS_ALLOW_CREDENTIALS", "true")
+	_, err := config.Load()
+	assert.ErrorContains(t, err, "CORS_ALLOW_CREDENTIALS")
+
+	t.Setenv("FFWEBAPI_CORS_ALLOWED_ORIGINS", "https://app.example.com")
+	cfg, err := config.Load()
+	assert.NoError(t, err)
+	assert.True(t, cfg.CORSAllowCredentials)
+}
+
+func TestDefaults(t *testing.T) {
+	t.Setenv("FFWEBAPI_PORT", "9999")
+	t.Setenv("FFWEBAPI_MAX_INPUT_SIZE", "50MB")
+
+	cfg, err := config.Defaults()
+	assert.NoError(t, err)
+	assert.Equal(t, "8080", cfg.Port)
+	assert.Equal(t, int64(200*1024*1024), cfg.MaxInputSize)
+	assert.Equal(t, []string{"http", "https"}, cfg.InputAllowedSchemes)
+}
@@ -0,0 +1,43 @@
+// Package events publishes task lifecycle events to message brokers: NATS,
+// Kafka (through the Confluent REST Proxy) and RabbitMQ (through its
+// management HTTP API). The clients speak the brokers' plain text and HTTP
+// protocols, so no broker SDK is needed.
+package events
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"ffwebapi/config"
+	"ffwebapi/task"
+)
+
+// New returns the publisher configured by EVENTS_BROKER, or nil if events
+// are disabled.
+func New(cfg *config.Config) (task.EventPublisher, error) {
+	if cfg.EventsBroker == "" {
+		return nil, nil
+	}
+	if _, err := url.Parse(cfg.EventsURL); err != nil || cfg.EventsURL == "" {
+		return nil, fmt.Errorf("EVENTS_BROKER %s needs a valid EVENTS_URL", cfg.EventsBroker)
+	}
+	if cfg.EventsTopic == "" {
+		return nil, fmt.Errorf("EVENTS_BROKER %s needs EVENTS_TOPIC", cfg.EventsBroker)
+	}
+	switch strings.ToLower(cfg.EventsBroker) {
+	case "nats":
+		return &NATS{URL: cfg.EventsURL, Subject: cfg.EventsTopic, Timeout: cfg.EventsTimeout}, nil
+	case "kafka":
+		return &Kafka{URL: cfg.EventsURL, Topic: cfg.EventsTopic, Client: newClient(cfg)}, nil
+	case "amqp":
+		return &RabbitMQ{URL: cfg.EventsURL, VHost: cfg.EventsAMQPVHost, Exchange: cfg.EventsTopic, Client: newClient(cfg)}, nil
+	}
+	return nil, fmt.Errorf("unknown EVENTS_BROKER %q (want nats, kafka or amqp)", cfg.EventsBroker)
+}
+
+// subjectSuffix is the part of an event's type after "task.", e.g.
+// "completed"; brokers append it to their subject or routing key.
+func subjectSuffix(e task.Event) string {
+	return strings.TrimPrefix(e.Type, "task.")
+}
@@ -0,0 +1,31 @@
+package events
+
+import (
+	"testing"
+
+	"ffwebapi/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	p, err := New(&config.Config{})
+	require.NoError(t, err)
+	assert.Nil(t, p)
+
+	p, err = New(&config.Config{EventsBroker: "NATS", EventsURL: "nats://localhost:4222", EventsTopic: "ffwebapi.tasks"})
+	require.NoError(t, err)
+	assert.IsType(t, &NATS{}, p)
+	p, err = New(&config.Config{EventsBroker: "kafka", EventsURL: "http://kafka-rest:8082", EventsTopic: "ffwebapi.tasks"})
+	require.NoError(t, err)
+	assert.IsType(t, &Kafka{}, p)
+	p, err = New(&config.Config{EventsBroker: "amqp", EventsURL: "http://rabbitmq:15672", EventsTopic: "ffwebapi.tasks", EventsAMQPVHost: "/"})
+	require.NoError(t, err)
+	assert.Equal(t, "/", p.(*RabbitMQ).VHost)
+
+	_, err = New(&config.Config{EventsBroker: "kafka", EventsTopic: "ffwebapi.tasks"})
+	assert.ErrorContains(t, err, "needs a valid EVENTS_URL")
+	_, err = New(&config.Config{EventsBroker: "sqs", EventsURL: "https://sqs.example.com", EventsTopic: "x"})
+	assert.ErrorContains(t, err, `unknown EVENTS_BROKER "sqs"`)
+}
@@ -0,0 +1,88 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ffwebapi/task"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEvent() task.Event {
+	return task.Event{ID: "ev1", Type: "task.completed", Time: time.Now(), TaskID: "t1", Task: json.RawMessage(`{"id":"t1"}`)}
+}
+
+func TestKafka(t *testing.T) {
+	var got map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); r.URL.Path != "/topics/media.events" || user != "kafka" || pass != "secret" {
+			http.Error(w, `{"error_code":40401,"message":"Topic not found"}`, http.StatusNotFound)
+			return
+		}
+		assert.Equal(t, "application/vnd.kafka.json.v2+json", r.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &got))
+		w.Write([]byte(`{"offsets":[{"partition":0,"offset":7,"error_code":null,"error":null}]}`))
+	}))
+	defer srv.Close()
+	k := &Kafka{URL: "http://kafka:secret@" + srv.Listener.Addr().String() + "/", Topic: "media.events"}
+	require.NoError(t, k.Publish(context.Background(), testEvent()))
+	records := got["records"].([]interface{})
+	require.Len(t, records, 1)
+	record := records[0].(map[string]interface{})
+	assert.Equal(t, "t1", record["key"])
+	assert.Equal(t, "task.completed", record["value"].(map[string]interface{})["type"])
+
+	k.Topic = "missing"
+	err := k.Publish(context.Background(), testEvent())
+	assert.ErrorContains(t, err, "Topic not found")
+	assert.ErrorIs(t, err, task.ErrEventRejected, "a 4xx is not retried")
+}
+
+func TestKafka_RecordError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"offsets":[{"partition":null,"offset":null,"error_code":50002,"error":"Kafka error: leader not available"}]}`))
+	}))
+	defer srv.Close()
+	k := &Kafka{URL: srv.URL, Topic: "media.events"}
+	err := k.Publish(context.Background(), testEvent())
+	assert.ErrorContains(t, err, "leader not available")
+	assert.NotErrorIs(t, err, task.ErrEventRejected)
+}
+
+func TestRabbitMQ(t *testing.T) {
+	var got struct {
+		Properties      map[string]interface{} `json:"properties"`
+		RoutingKey      string                 `json:"routing_key"`
+		Payload         string                 `json:"payload"`
+		PayloadEncoding string                 `json:"payload_encoding"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, _, _ := r.BasicAuth(); r.URL.EscapedPath() != "/api/exchanges/%2F/ffwebapi.tasks/publish" || user != "guest" {
+			http.Error(w, `{"error":"Object Not Found","reason":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.Write([]byte(`{"routed":true}`))
+	}))
+	defer srv.Close()
+	rmq := &RabbitMQ{URL: "http://guest:guest@" + srv.Listener.Addr().String(), Exchange: "ffwebapi.tasks"}
+	require.NoError(t, rmq.Publish(context.Background(), testEvent()))
+	assert.Equal(t, "task.completed", got.RoutingKey)
+	assert.Equal(t, "string", got.PayloadEncoding)
+	assert.Equal(t, "ev1", got.Properties["message_id"])
+	assert.EqualValues(t, 2, got.Properties["delivery_mode"])
+	var e task.Event
+	require.NoError(t, json.Unmarshal([]byte(got.Payload), &e))
+	assert.Equal(t, "t1", e.TaskID)
+
+	rmq.VHost = "media"
+	assert.ErrorContains(t, rmq.Publish(context.Background(), testEvent()), "404 Not Found")
+}
@@ -0,0 +1,165 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"ffwebapi/task"
+)
+
+// NATS publishes events to a NATS server with the core protocol: each event
+// goes to <Subject>.<status>, e.g. "ffwebapi.tasks.completed", so subscribers
+// pick the ones they need with wildcards such as "ffwebapi.tasks.>". A PING
+// after every PUB makes sure the server took the message before Publish
+// returns. The connection is opened on first use and again after errors.
+type NATS struct {
+	URL     string        // nats://[user:password@|token@]host[:port]
+	Subject string        // Prefix of the subjects, e.g. "ffwebapi.tasks"
+	Timeout time.Duration // Per publish; 10s if 0
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// natsInfo is the part of the server's INFO the client looks at.
+type natsInfo struct {
+	TLSRequired  bool `json:"tls_required"`
+	AuthRequired bool `json:"auth_required"`
+}
+
+func (n *NATS) Publish(ctx context.Context, e task.Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.conn == nil {
+		if err := n.connect(ctx); err != nil {
+			return fmt.Errorf("could not connect to NATS: %w", err)
+		}
+	}
+	subject := n.Subject + "." + subjectSuffix(e)
+	if err := n.roundTrip(fmt.Sprintf("PUB %s %d\r\n%s\r\nPING\r\n", subject, len(payload), payload)); err != nil {
+		n.closeConn()
+		return fmt.Errorf("NATS publish to %s: %w", subject, err)
+	}
+	return nil
+}
+
+// Close closes the connection to the server.
+func (n *NATS) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.closeConn()
+	return nil
+}
+
+func (n *NATS) connect(ctx context.Context) error {
+	u, err := url.Parse(n.URL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "nats" {
+		return fmt.Errorf("unsupported scheme %q; want nats://", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return err
+	}
+	n.conn, n.r = conn, bufio.NewReader(conn)
+	if err := n.handshake(u); err != nil {
+		n.closeConn()
+		return err
+	}
+	return nil
+}
+
+// handshake reads the server's INFO, then sends CONNECT and waits for the
+// PONG that confirms it.
+func (n *NATS) handshake(u *url.URL) error {
+	n.conn.SetDeadline(time.Now().Add(n.timeout()))
+	line, err := n.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	rawInfo, ok := strings.CutPrefix(strings.TrimSpace(line), "INFO ")
+	if !ok {
+		return fmt.Errorf("unexpected greeting %q", strings.TrimSpace(line))
+	}
+	var info natsInfo
+	if err := json.Unmarshal([]byte(rawInfo), &info); err != nil {
+		return fmt.Errorf("invalid INFO: %w", err)
+	}
+	if info.TLSRequired {
+		return errors.New("the server requires TLS, which is not supported")
+	}
+
+	opts := map[string]interface{}{"verbose": false, "pedantic": false, "name": "ffwebapi", "lang": "go", "protocol": 0}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			opts["user"], opts["pass"] = u.User.Username(), password
+		} else {
+			opts["auth_token"] = u.User.Username()
+		}
+	}
+	connect, err := json.Marshal(opts)
+	if err != nil {
+		return err
+	}
+	return n.roundTrip("CONNECT " + string(connect) + "\r\nPING\r\n")
+}
+
+// roundTrip sends commands ending with a PING and reads until the PONG,
+// answering the server's PINGs and failing on -ERR.
+func (n *NATS) roundTrip(cmds string) error {
+	n.conn.SetDeadline(time.Now().Add(n.timeout()))
+	if _, err := n.conn.Write([]byte(cmds)); err != nil {
+		return err
+	}
+	for {
+		line, err := n.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := n.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New(strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")), "'"))
+		}
+		// +OK and INFO updates need no answer.
+	}
+}
+
+func (n *NATS) timeout() time.Duration {
+	if n.Timeout <= 0 {
+		return 10 * time.Second
+	}
+	return n.Timeout
+}
+
+func (n *NATS) closeConn() {
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn, n.r = nil, nil
+	}
+}
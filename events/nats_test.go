@@ -0,0 +1,140 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ffwebapi/task"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// natsMessage is a message a fakeNATS server received.
+type natsMessage struct {
+	Subject string
+	Payload string
+}
+
+// fakeNATS speaks enough of the NATS protocol to accept connections and
+// publishes. Messages to subjects ending in ".denied" get a permissions
+// error.
+type fakeNATS struct {
+	ln       net.Listener
+	mu       sync.Mutex
+	connects []string
+	messages []natsMessage
+	info     string
+}
+
+func newFakeNATS(t *testing.T) *fakeNATS {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	s := &fakeNATS{ln: ln, info: `{"server_id":"fake","max_payload":1048576}`}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeNATS) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	s.mu.Lock()
+	fmt.Fprintf(conn, "INFO %s\r\n", s.info)
+	s.mu.Unlock()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "CONNECT":
+			s.mu.Lock()
+			s.connects = append(s.connects, strings.TrimSpace(strings.TrimPrefix(line, "CONNECT ")))
+			s.mu.Unlock()
+			conn.Write([]byte("PING\r\n")) // The client must answer the server's pings
+		case "PING":
+			conn.Write([]byte("PONG\r\n"))
+		case "PUB":
+			var size int
+			fmt.Sscan(fields[2], &size)
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			if strings.HasSuffix(fields[1], ".denied") {
+				conn.Write([]byte("-ERR 'Permissions Violation for Publish to " + fields[1] + "'\r\n"))
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, natsMessage{Subject: fields[1], Payload: string(payload[:size])})
+			s.mu.Unlock()
+		}
+	}
+}
+
+func TestNATS(t *testing.T) {
+	srv := newFakeNATS(t)
+	n := &NATS{URL: "nats://ffwebapi:secret@" + srv.ln.Addr().String(), Subject: "ffwebapi.tasks", Timeout: time.Second}
+	defer n.Close()
+
+	e := task.Event{ID: "ev1", Type: "task.completed", Time: time.Now(), TaskID: "t1", Task: json.RawMessage(`{"id":"t1"}`)}
+	require.NoError(t, n.Publish(context.Background(), e))
+	e.ID, e.Type = "ev2", task.EventStarted
+	require.NoError(t, n.Publish(context.Background(), e))
+
+	srv.mu.Lock()
+	require.Len(t, srv.connects, 1) // The connection is reused
+	assert.Contains(t, srv.connects[0], `"user":"ffwebapi"`)
+	assert.Contains(t, srv.connects[0], `"pass":"secret"`)
+	require.Len(t, srv.messages, 2)
+	assert.Equal(t, "ffwebapi.tasks.completed", srv.messages[0].Subject)
+	assert.Equal(t, "ffwebapi.tasks.started", srv.messages[1].Subject)
+	var got task.Event
+	require.NoError(t, json.Unmarshal([]byte(srv.messages[0].Payload), &got))
+	srv.mu.Unlock()
+	assert.Equal(t, "ev1", got.ID)
+	assert.JSONEq(t, `{"id":"t1"}`, string(got.Task))
+
+	// Errors close the connection; the next publish reconnects.
+	e.Type = "task.denied"
+	assert.ErrorContains(t, n.Publish(context.Background(), e), "Permissions Violation")
+	e.Type = "task.failed"
+	require.NoError(t, n.Publish(context.Background(), e))
+	srv.mu.Lock()
+	assert.Len(t, srv.connects, 2)
+	assert.Equal(t, "ffwebapi.tasks.failed", srv.messages[2].Subject)
+	srv.mu.Unlock()
+
+	token := &NATS{URL: "nats://s3cr3t@" + srv.ln.Addr().String(), Subject: "x"}
+	require.NoError(t, token.Publish(context.Background(), e))
+	token.Close()
+	srv.mu.Lock()
+	assert.Contains(t, srv.connects[2], `"auth_token":"s3cr3t"`)
+	srv.info = `{"server_id":"fake","tls_required":true}`
+	srv.mu.Unlock()
+	tls := &NATS{URL: "nats://" + srv.ln.Addr().String(), Subject: "x"}
+	assert.ErrorContains(t, tls.Publish(context.Background(), e), "requires TLS")
+
+	assert.ErrorContains(t, (&NATS{URL: "tls://localhost:4222"}).Publish(context.Background(), e), `unsupported scheme "tls"`)
+}
@@ -0,0 +1,144 @@
+package ffmpeg
+
+import (
+    "fmt"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+
+    "ffwebapi/task"
+)
+
+// Packaging formats of adaptive bitrate outputs.
+const (
+    ABRFormatHLS  = "hls"
+    ABRFormatDASH = "dash"
+)
+
+const maxRenditions = 8
+
+var renditionNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// Rendition is one quality level of an adaptive bitrate ladder.
+type Rendition struct {
+    Name         string // e.g. "720p"; used in file names
+    Height       int    // Output height; the width keeps the aspect ratio
+    VideoBitrate string // e.g. "2800k"
+    AudioBitrate string // e.g. "128k"; defaults to 128k
+}
+
+// ABRJob is a ready-to-submit adaptive bitrate command.
+type ABRJob struct {
+    Command    string // Uses ${INPUT_MEDIA} and ${OUTPUT_DIR}
+    OutputExt  string
+    Entry      string // Master playlist / manifest inside the output directory
+    Renditions []task.RenditionProgress
+}
+
+// BuildABRCommand builds an ffmpeg command that scales the input into every
+// rendition and packages them as HLS (one variant playlist per rendition plus
+// master.m3u8) or DASH (manifest.mpd).
+func BuildABRCommand(format string, renditions []Rendition, segmentDuration time.Duration) (*ABRJob, error) {
+    if len(renditions) == 0 || len(renditions) > maxRenditions {
+        return nil, fmt.Errorf("between 1 and %d renditions are required", maxRenditions)
+    }
+    if segmentDuration < time.Second || segmentDuration > time.Minute {
+        return nil, fmt.Errorf("segment duration must be between 1s and 1m")
+    }
+
+    seen := make(map[string]bool)
+    for i := range renditions {
+        r := &renditions[i]
+        if !renditionNameRe.MatchString(r.Name) || seen[r.Name] {
+            return nil, fmt.Errorf("rendition %d: name %q must be unique and consist of letters, digits, '-' or '_'", i, r.Name)
+        }
+        seen[r.Name] = true
+        if r.Height < 16 || r.Height > 4320 || r.Height%2 != 0 {
+            return nil, fmt.Errorf("rendition %q: height must be an even number between 16 and 4320", r.Name)
+        }
+        if _, err := parseBitrate(r.VideoBitrate); err != nil {
+            return nil, fmt.Errorf("rendition %q: invalid video bitrate: %w", r.Name, err)
+        }
+        if r.AudioBitrate == "" {
+            r.AudioBitrate = "128k"
+        }
+        if _, err := parseBitrate(r.AudioBitrate); err != nil {
+            return nil, fmt.Errorf("rendition %q: invalid audio bitrate: %w", r.Name, err)
+        }
+    }
+
+    n := len(renditions)
+    splits := make([]string, n)
+    scales := make([]string, n)
+    for i, r := range renditions {
+        splits[i] = fmt.Sprintf("[v%d]", i)
+        scales[i] = fmt.Sprintf("[v%d]scale=-2:%d[v%dout]", i, r.Height, i)
+    }
+    filter := fmt.Sprintf("[0:v]split=%d%s;%s", n, strings.Join(splits, ""), strings.Join(scales, ";"))
+
+    segSeconds := strconv.Itoa(int(segmentDuration.Seconds()))
+    args := []string{"-i", InputMediaPlaceholder, "-filter_complex", filter}
+    for i, r := range renditions {
+        idx := strconv.Itoa(i)
+        args = append(args,
+            "-map", fmt.Sprintf("[v%dout]", i),
+            "-c:v:"+idx, "libx264", "-b:v:"+idx, r.VideoBitrate,
+            // Keyframes on segment boundaries so all renditions switch cleanly.
+            "-force_key_frames:v:"+idx, "expr:gte(t,n_forced*"+segSeconds+")",
+        )
+    }
+
+    job := &ABRJob{}
+    switch format {
+    case "", ABRFormatHLS:
+        // HLS variants each carry their own audio track.
+        var streamMap []string
+        for i, r := range renditions {
+            idx := strconv.Itoa(i)
+            args = append(args, "-map", "0:a:0", "-c:a:"+idx, "aac", "-b:a:"+idx, r.AudioBitrate)
+            streamMap = append(streamMap, fmt.Sprintf("v:%d,a:%d,name:%s", i, i, r.Name))
+            job.Renditions = append(job.Renditions, task.RenditionProgress{
+                Name:            r.Name,
+                SegmentPattern:  r.Name + "/seg_*.ts",
+                SegmentDuration: segmentDuration,
+            })
+        }
+        args = append(args,
+            "-f", "hls", "-hls_time", segSeconds, "-hls_playlist_type", "vod",
+            "-hls_segment_filename", OutputDirPlaceholder+"/%v/seg_%05d.ts",
+            "-master_pl_name", "master.m3u8",
+            "-var_stream_map", strings.Join(streamMap, " "),
+            OutputDirPlaceholder+"/%v/index.m3u8",
+        )
+        job.OutputExt, job.Entry = "m3u8", "master.m3u8"
+    case ABRFormatDASH:
+        // DASH shares one audio representation between all video representations,
+        // at the highest requested audio bitrate.
+        audioBitrate, best := renditions[0].AudioBitrate, int64(0)
+        for _, r := range renditions {
+            if br, _ := parseBitrate(r.AudioBitrate); br > best {
+                audioBitrate, best = r.AudioBitrate, br
+            }
+        }
+        args = append(args, "-map", "0:a:0", "-c:a", "aac", "-b:a", audioBitrate)
+        for i, r := range renditions {
+            job.Renditions = append(job.Renditions, task.RenditionProgress{
+                Name:            r.Name,
+                SegmentPattern:  fmt.Sprintf("chunk-stream%d-*.m4s", i),
+                SegmentDuration: segmentDuration,
+            })
+        }
+        args = append(args,
+            "-f", "dash", "-seg_duration", segSeconds, "-use_template", "1", "-use_timeline", "1",
+            "-adaptation_sets", "id=0,streams=v id=1,streams=a",
+            OutputDirPlaceholder+"/manifest.mpd",
+        )
+        job.OutputExt, job.Entry = "mpd", "manifest.mpd"
+    default:
+        return nil, fmt.Errorf("unsupported format %q (want %q or %q)", format, ABRFormatHLS, ABRFormatDASH)
+    }
+
+    job.Command = JoinCommand(args)
+    return job, nil
+}
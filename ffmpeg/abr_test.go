@@ -0,0 +1,54 @@
+package ffmpeg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildABRCommand(t *testing.T) {
+	renditions := []Rendition{
+		{Name: "720p", Height: 720, VideoBitrate: "2800k"},
+		{Name: "480p", Height: 480, VideoBitrate: "1400k", AudioBitrate: "96k"},
+	}
+
+	t.Run("hls", func(t *testing.T) {
+		job, err := BuildABRCommand(ABRFormatHLS, renditions, 4*time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, "master.m3u8", job.Entry)
+		assert.Equal(t, "m3u8", job.OutputExt)
+		require.Len(t, job.Renditions, 2)
+		assert.Equal(t, "480p/seg_*.ts", job.Renditions[1].SegmentPattern)
+
+		args, err := SplitCommand(job.Command)
+		require.NoError(t, err)
+		assert.Contains(t, args, "[0:v]split=2[v0][v1];[v0]scale=-2:720[v0out];[v1]scale=-2:480[v1out]")
+		assert.Contains(t, args, "v:0,a:0,name:720p v:1,a:1,name:480p")
+		assert.Contains(t, args, "-hls_time")
+		assert.Equal(t, OutputDirPlaceholder+"/%v/index.m3u8", args[len(args)-1])
+	})
+
+	t.Run("dash", func(t *testing.T) {
+		job, err := BuildABRCommand(ABRFormatDASH, renditions, 6*time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, "manifest.mpd", job.Entry)
+		args, _ := SplitCommand(job.Command)
+		assert.Contains(t, args, "id=0,streams=v id=1,streams=a")
+		assert.Equal(t, "chunk-stream1-*.m4s", job.Renditions[1].SegmentPattern)
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		_, err := BuildABRCommand("smooth", renditions, 6*time.Second)
+		assert.Error(t, err)
+		_, err = BuildABRCommand(ABRFormatHLS, nil, 6*time.Second)
+		assert.Error(t, err)
+		_, err = BuildABRCommand(ABRFormatHLS, []Rendition{{Name: "../x", Height: 720, VideoBitrate: "1M"}}, 6*time.Second)
+		assert.Error(t, err)
+		_, err = BuildABRCommand(ABRFormatHLS, []Rendition{{Name: "odd", Height: 721, VideoBitrate: "1M"}}, 6*time.Second)
+		assert.Error(t, err)
+		_, err = BuildABRCommand(ABRFormatHLS, []Rendition{{Name: "a", Height: 720, VideoBitrate: "1M"}, {Name: "a", Height: 480, VideoBitrate: "1M"}}, 6*time.Second)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,253 @@
+package ffmpeg
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+
+    "ffwebapi/config"
+)
+
+// CheckAllowlist enforces COMMAND_ALLOWLIST on split ffmpeg arguments: codecs,
+// filters and output formats must be on the admin-approved lists. Outputs
+// without "-f" get the format ffmpeg picks for their extension in exts: of
+// ${OUTPUT_<i>} if the command has such placeholders, else of the output
+// appended to it. The error names the first disallowed token. It does
+// nothing unless the mode is on.
+func CheckAllowlist(cfg *config.Config, args []string, exts []string) error {
+    if !cfg.CommandAllowlist {
+        return nil
+    }
+    format := "" // Of the next output, if set with "-f"
+    checkOutput := func(ext string) error {
+        if format == "" && ext != "" {
+            if implied := impliedFormat(ext); !containsFold(cfg.AllowedFormats, implied) {
+                return fmt.Errorf("output format %q is not allowed (.%s)", implied, ext)
+            }
+        }
+        format = ""
+        return nil
+    }
+    placeholders := false
+    for i := 0; i < len(args); i++ {
+        opt := args[i]
+        if !strings.HasPrefix(opt, "-") {
+            if m := outputPlaceholderRe.FindStringSubmatch(opt); m != nil && m[0] == opt {
+                placeholders = true
+                if n, _ := strconv.Atoi(m[1]); n < len(exts) {
+                    if err := checkOutput(exts[n]); err != nil {
+                        return err
+                    }
+                }
+            }
+            continue
+        }
+        // "-/filter_complex graph.txt" and the *_script options read their
+        // value from a file, which can't be checked.
+        if strings.HasPrefix(opt, "-/") || strings.Contains(opt, "_script") {
+            return fmt.Errorf("option %s is not allowed", opt)
+        }
+        if i+1 >= len(args) {
+            break
+        }
+        value := args[i+1]
+
+        if kind, ok := codecKind(opt); ok {
+            if err := checkCodec(cfg, kind, opt, value); err != nil {
+                return err
+            }
+            i++
+            continue
+        }
+        switch opt {
+        case "-vf", "-af", "-filter", "-filter_complex", "-lavfi":
+            if err := checkFilters(cfg, opt, value); err != nil {
+                return err
+            }
+            i++
+        case "-f":
+            if isInputFormat(args[i+2:]) {
+                // An input format; lavfi inputs are filtergraphs themselves.
+                if strings.EqualFold(value, "lavfi") {
+                    if err := checkFilters(cfg, "-i", inputAfter(args[i+2:])); err != nil {
+                        return err
+                    }
+                }
+            } else if !containsFold(cfg.AllowedFormats, value) {
+                return fmt.Errorf("output format %q is not allowed (%s)", value, opt)
+            } else {
+                format = value
+            }
+            i++
+        default:
+            if strings.HasPrefix(opt, "-filter:") {
+                if err := checkFilters(cfg, opt, value); err != nil {
+                    return err
+                }
+                i++
+            }
+        }
+    }
+    if !placeholders && len(exts) == 1 {
+        return checkOutput(exts[0])
+    }
+    return nil
+}
+
+// impliedFormats are the formats ffmpeg picks for output extensions when no
+// "-f" is given, where they differ from the extension.
+var impliedFormats = map[string]string{
+    "mkv": "matroska", "mka": "matroska",
+    "m4a": "ipod", "m4v": "ipod", "m4b": "ipod",
+    "ts": "mpegts", "m2ts": "mpegts", "mts": "mpegts",
+    "m3u8": "hls", "mpd": "dash",
+    "oga": "ogg", "ogv": "ogg",
+    "aac": "adts", "mpg": "mpeg", "mpeg": "mpeg", "vtt": "webvtt",
+    "jpg": "image2", "jpeg": "image2", "png": "image2", "bmp": "image2", "tif": "image2", "tiff": "image2",
+}
+
+// impliedFormat returns the format ffmpeg writes a file with extension ext in.
+func impliedFormat(ext string) string {
+    ext = strings.ToLower(ext)
+    if format, ok := impliedFormats[ext]; ok {
+        return format
+    }
+    return ext
+}
+
+// codecKind classifies a codec option by the stream type it applies to:
+// "video", "audio", "" for any stream, or "other" for subtitle and data
+// streams, which are not restricted.
+func codecKind(opt string) (string, bool) {
+    switch opt {
+    case "-vcodec":
+        return "video", true
+    case "-acodec":
+        return "audio", true
+    case "-scodec", "-dcodec":
+        return "other", true
+    }
+    name, spec, _ := strings.Cut(opt, ":")
+    if name != "-c" && name != "-codec" {
+        return "", false
+    }
+    stream, _, _ := strings.Cut(spec, ":")
+    switch stream {
+    case "v", "V":
+        return "video", true
+    case "a":
+        return "audio", true
+    case "s", "d", "t":
+        return "other", true
+    }
+    return "", true // No or a numeric stream specifier: any stream
+}
+
+func checkCodec(cfg *config.Config, kind, opt, codec string) error {
+    switch kind {
+    case "video":
+        if !containsFold(cfg.AllowedVideoCodecs, codec) {
+            return fmt.Errorf("video codec %q is not allowed (%s)", codec, opt)
+        }
+    case "audio":
+        if !containsFold(cfg.AllowedAudioCodecs, codec) {
+            return fmt.Errorf("audio codec %q is not allowed (%s)", codec, opt)
+        }
+    case "":
+        if !containsFold(cfg.AllowedVideoCodecs, codec) && !containsFold(cfg.AllowedAudioCodecs, codec) {
+            return fmt.Errorf("codec %q is not allowed (%s)", codec, opt)
+        }
+    }
+    return nil
+}
+
+func checkFilters(cfg *config.Config, opt, graph string) error {
+    for _, name := range FilterNames(graph) {
+        if !containsFold(cfg.AllowedFilters, name) {
+            return fmt.Errorf("filter %q is not allowed (%s)", name, opt)
+        }
+    }
+    return nil
+}
+
+// FilterNames returns the names of the filters of a filtergraph, e.g.
+// ["scale", "format"] for "[0:v]scale=1280:-2,format=yuv420p[out]".
+func FilterNames(graph string) []string {
+    var names []string
+    for _, f := range splitFiltergraph(graph) {
+        f = strings.TrimSpace(f)
+        for strings.HasPrefix(f, "[") { // Input pad labels
+            end := strings.Index(f, "]")
+            if end < 0 {
+                break
+            }
+            f = strings.TrimSpace(f[end+1:])
+        }
+        // "scale@main=..." names an instance; options and output labels follow.
+        if end := strings.IndexAny(f, "=@[ "); end >= 0 {
+            f = f[:end]
+        }
+        if f != "" {
+            names = append(names, f)
+        }
+    }
+    return names
+}
+
+// splitFiltergraph splits a filtergraph into filters at "," and ";", except
+// inside quotes, escapes and option values.
+func splitFiltergraph(graph string) []string {
+    var parts []string
+    var cur strings.Builder
+    quoted := false
+    for i := 0; i < len(graph); i++ {
+        c := graph[i]
+        switch {
+        case c == '\\' && i+1 < len(graph):
+            cur.WriteByte(c)
+            i++
+            c = graph[i]
+        case c == '\'':
+            quoted = !quoted
+        case (c == ',' || c == ';') && !quoted:
+            parts = append(parts, cur.String())
+            cur.Reset()
+            continue
+        }
+        cur.WriteByte(c)
+    }
+    return append(parts, cur.String())
+}
+
+// isInputFormat reports whether a "-f" followed by rest applies to an input:
+// an "-i" comes before the next output placeholder. The main output is added
+// at the end of the command, so a trailing "-f" applies to it.
+func isInputFormat(rest []string) bool {
+    for _, arg := range rest {
+        if arg == "-i" {
+            return true
+        }
+        if outputPlaceholderRe.MatchString(arg) || strings.Contains(arg, OutputDirPlaceholder) {
+            return false
+        }
+    }
+    return false
+}
+
+func inputAfter(rest []string) string {
+    for i, arg := range rest {
+        if arg == "-i" && i+1 < len(rest) {
+            return rest[i+1]
+        }
+    }
+    return ""
+}
+
+func containsFold(list []string, s string) bool {
+    for _, v := range list {
+        if strings.EqualFold(v, s) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,81 @@
+package ffmpeg
+
+import (
+	"testing"
+
+	"ffwebapi/config"
+	"ffwebapi/preset"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterNames(t *testing.T) {
+	assert.Equal(t, []string{"scale", "format"}, FilterNames("scale=1280:-2,format=yuv420p"))
+	assert.Equal(t, []string{"split", "scale", "overlay"}, FilterNames("[0:v]split[a][b];[a]scale@small=320:-2[s];[b][s]overlay=10:10[out]"))
+	assert.Equal(t, []string{"drawtext"}, FilterNames(`drawtext=text='a, b; c':x=10`))
+	assert.Equal(t, []string{"drawtext"}, FilterNames(`drawtext=text=a\,b`))
+}
+
+func TestCheckAllowlist(t *testing.T) {
+	cfg := &config.Config{
+		CommandAllowlist:   true,
+		AllowedVideoCodecs: []string{"libx264", "copy"},
+		AllowedAudioCodecs: []string{"aac"},
+		AllowedFilters:     []string{"scale", "format", "color"},
+		AllowedFormats:     []string{"mp4", "hls"},
+	}
+	check := func(command string, exts ...string) error {
+		args, err := SplitCommand(command)
+		require.NoError(t, err)
+		return CheckAllowlist(cfg, args, exts)
+	}
+
+	assert.NoError(t, check(`-i ${INPUT_MEDIA} -vf scale=1280:-2,format=yuv420p -c:v libx264 -c:a aac -f mp4`))
+	assert.NoError(t, check(`-i ${INPUT_MEDIA} -c copy -c:s mov_text`))
+	assert.NoError(t, check(`-f matroska -i ${INPUT_MEDIA} -c:v:0 libx264 -f hls ${OUTPUT_DIR}/index.m3u8`))
+	assert.NoError(t, check(`-f lavfi -i color=c=black -i ${INPUT_MEDIA} -c:v libx264`))
+
+	for command, token := range map[string]string{
+		`-i ${INPUT_MEDIA} -c:v libx265`:                     `video codec "libx265" is not allowed (-c:v)`,
+		`-i ${INPUT_MEDIA} -vcodec libvpx`:                   `video codec "libvpx" is not allowed (-vcodec)`,
+		`-i ${INPUT_MEDIA} -codec:a libopus`:                 `audio codec "libopus" is not allowed (-codec:a)`,
+		`-i ${INPUT_MEDIA} -c flac`:                          `codec "flac" is not allowed (-c)`,
+		`-i ${INPUT_MEDIA} -vf scale=320:-2,drawtext=text=x`: `filter "drawtext" is not allowed (-vf)`,
+		`-i ${INPUT_MEDIA} -filter:a volume=2`:               `filter "volume" is not allowed (-filter:a)`,
+		`-i ${INPUT_MEDIA} -filter_complex [0:v]movie=x`:     `filter "movie" is not allowed (-filter_complex)`,
+		`-f lavfi -i movie=/etc/passwd -i ${INPUT_MEDIA}`:    `filter "movie" is not allowed (-i)`,
+		`-i ${INPUT_MEDIA} -f matroska`:                      `output format "matroska" is not allowed (-f)`,
+		`-i ${INPUT_MEDIA} -filter_script:v graph.txt`:       `option -filter_script:v is not allowed`,
+		`-i ${INPUT_MEDIA} -/vf graph.txt`:                   `option -/vf is not allowed`,
+	} {
+		assert.EqualError(t, check(command), token, command)
+	}
+
+	// Outputs without -f are written in the format of their extension.
+	assert.NoError(t, check(`-i ${INPUT_MEDIA} -c:v libx264`, "mp4"))
+	assert.NoError(t, check(`-i ${INPUT_MEDIA} -c:v libx264 -f mp4`, "mkv"))
+	assert.NoError(t, check(`-i ${INPUT_MEDIA} -c:v libx264 -f hls ${OUTPUT_0} -c:v libx264 ${OUTPUT_1}`, "m3u8", "MP4"))
+	assert.EqualError(t, check(`-i ${INPUT_MEDIA} -c:v libx264`, "mkv"), `output format "matroska" is not allowed (.mkv)`)
+	assert.EqualError(t, check(`-i ${INPUT_MEDIA} -f mp4 ${OUTPUT_0} -c:v libx264 ${OUTPUT_1}`, "mp4", "flv"), `output format "flv" is not allowed (.flv)`)
+
+	cfg.CommandAllowlist = false
+	assert.NoError(t, check(`-i ${INPUT_MEDIA} -c:v libx265`))
+}
+
+func TestCheckAllowlist_DefaultsCoverPresets(t *testing.T) {
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	cfg.CommandAllowlist = true
+
+	for _, p := range preset.List() {
+		args, err := SplitCommand(p.Command)
+		require.NoError(t, err)
+		assert.NoError(t, CheckAllowlist(cfg, args, []string{p.OutputExt}), p.Name)
+	}
+	for _, target := range preset.Targets() {
+		args, err := SplitCommand(target.Command)
+		require.NoError(t, err)
+		assert.NoError(t, CheckAllowlist(cfg, args, []string{target.OutputExt}), target.Name)
+	}
+}
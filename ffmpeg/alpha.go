@@ -0,0 +1,159 @@
+package ffmpeg
+
+import (
+    "fmt"
+    "regexp"
+    "strings"
+
+    "ffwebapi/task"
+    "ffwebapi/utils"
+)
+
+// WarningAlphaDropped flags outputs that lose the transparency of their input.
+const WarningAlphaDropped = "alpha_dropped"
+
+// alphaPixFmtRe matches pixel formats with an alpha channel, e.g. yuva420p,
+// yuva444p10le, rgba, bgra, gbrap or ya8.
+var alphaPixFmtRe = regexp.MustCompile(`^(yuva|gbrap|ya\d|rgba|bgra|argb|abgr)`)
+
+// alphaEncoders are the encoders that can store an alpha channel, with the
+// containers that keep it; any container if nil.
+var alphaEncoders = map[string][]string{
+    "prores_ks":    {"mov"}, // Profiles 4444 and 4444xq only
+    "qtrle":        {"mov"},
+    "libvpx-vp9":   {"webm", "mkv"},
+    "libvpx":       {"webm", "mkv"},
+    "ffv1":         {"mkv", "nut", "avi"},
+    "utvideo":      {"mkv", "avi", "mov"},
+    "png":          nil,
+    "apng":         nil,
+    "libwebp":      nil,
+    "libwebp_anim": nil,
+    "gif":          nil, // One bit: pixels are opaque or fully transparent
+    "rawvideo":     nil,
+}
+
+// defaultEncoders are the video encoders ffmpeg picks for an output
+// extension when the command names none.
+var defaultEncoders = map[string]string{
+    "mp4":  "libx264",
+    "mov":  "libx264",
+    "mkv":  "libx264",
+    "webm": "libvpx-vp9",
+    "avi":  "mpeg4",
+    "png":  "png",
+    "apng": "apng",
+    "gif":  "gif",
+    "webp": "libwebp_anim",
+    "jpg":  "mjpeg",
+    "jpeg": "mjpeg",
+}
+
+// formatFilterRe matches the format filters of a filter chain, whose last
+// one decides the pixel format handed to the encoder.
+var formatFilterRe = regexp.MustCompile(`(?:^|[,;\]])format=(?:pix_fmts=)?([a-z0-9]+)`)
+
+// HasAlpha reports whether a pixel format carries an alpha channel.
+func HasAlpha(pixFmt string) bool {
+    return alphaPixFmtRe.MatchString(pixFmt)
+}
+
+// AlphaLoss tells why the single output of an ffmpeg command would lose the
+// alpha channel of its input, or returns "" if it keeps it (or has no video).
+// Outputs whose encoder cannot be told are assumed to keep it.
+func AlphaLoss(args []string, outputExt string) string {
+    ext := strings.ToLower(outputExt)
+    if hasArg(args, "-vn") || utils.MediaKindOf(ext) == utils.MediaKindAudio {
+        return ""
+    }
+    encoder := optionValue(args, "-c:v", "-vcodec", "-codec:v", "-c:v:0", "-codec:v:0", "-c", "-codec")
+    if encoder == "copy" {
+        return ""
+    }
+    if encoder == "" {
+        if encoder = defaultEncoders[ext]; encoder == "" {
+            return ""
+        }
+    }
+    containers, ok := alphaEncoders[encoder]
+    switch {
+    case !ok:
+        return fmt.Sprintf("%s cannot store an alpha channel, so the output loses the input's transparency", encoder)
+    case len(containers) > 0 && !contains(containers, ext):
+        return fmt.Sprintf("%s keeps an alpha channel only in %s, not in %s", encoder, strings.Join(containers, " or "), ext)
+    case encoder == "prores_ks" && !contains([]string{"4", "5", "4444", "4444xq"}, optionValue(args, "-profile:v", "-profile")):
+        return "prores_ks keeps an alpha channel only with -profile:v 4444 or 4444xq"
+    }
+    if pixFmt := outputPixFmt(args); pixFmt != "" && !HasAlpha(pixFmt) {
+        return fmt.Sprintf("pixel format %s has no alpha channel, so the output loses the input's transparency", pixFmt)
+    }
+    return ""
+}
+
+// CheckAlpha warns about a command that asks for an alpha pixel format its
+// encoder or container cannot store. Whether the input has alpha is only
+// known once the task runs, when the output is checked again.
+func CheckAlpha(command, outputExt string) []task.Warning {
+    args, err := SplitCommand(command)
+    if err != nil || !HasAlpha(outputPixFmt(args)) {
+        return nil
+    }
+    if loss := AlphaLoss(args, outputExt); loss != "" {
+        return []task.Warning{{Code: WarningAlphaDropped, Message: loss, Count: 1}}
+    }
+    return nil
+}
+
+// inputHasAlpha reports whether any video stream of the input carries alpha.
+func inputHasAlpha(streams []inputStream) bool {
+    for _, s := range streams {
+        if s.CodecType == "video" && HasAlpha(s.PixFmt) {
+            return true
+        }
+    }
+    return false
+}
+
+// outputPixFmt returns the pixel format a command asks for with -pix_fmt or,
+// failing that, the last format filter of its filters.
+func outputPixFmt(args []string) string {
+    if pixFmt := optionValue(args, "-pix_fmt", "-pix_fmt:v", "-pix_fmt:v:0"); pixFmt != "" {
+        return pixFmt
+    }
+    var pixFmt string
+    for _, filters := range optionValues(args, "-vf", "-filter:v", "-filter_complex") {
+        if m := formatFilterRe.FindAllStringSubmatch(filters, -1); len(m) > 0 {
+            pixFmt = m[len(m)-1][1]
+        }
+    }
+    return pixFmt
+}
+
+// optionValue returns the value of the last of the given options in args.
+func optionValue(args []string, names ...string) string {
+    values := optionValues(args, names...)
+    if len(values) == 0 {
+        return ""
+    }
+    return values[len(values)-1]
+}
+
+func optionValues(args []string, names ...string) []string {
+    var values []string
+    for i := 0; i < len(args)-1; i++ {
+        if contains(names, args[i]) {
+            values = append(values, args[i+1])
+            i++
+        }
+    }
+    return values
+}
+
+func contains(list []string, s string) bool {
+    for _, item := range list {
+        if item == s {
+            return true
+        }
+    }
+    return false
+}
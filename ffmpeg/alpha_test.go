@@ -0,0 +1,68 @@
+package ffmpeg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasAlpha(t *testing.T) {
+	for _, f := range []string{"yuva420p", "yuva444p10le", "rgba", "bgra", "argb", "gbrap12le", "ya8", "rgba64be"} {
+		assert.True(t, HasAlpha(f), f)
+	}
+	for _, f := range []string{"", "yuv420p", "yuv444p10le", "rgb24", "gbrp", "gray", "nv12"} {
+		assert.False(t, HasAlpha(f), f)
+	}
+}
+
+func TestAlphaLoss(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  string
+		ext   string
+		loses bool
+	}{
+		{"prores 4444", "-i in.mov -c:v prores_ks -profile:v 4444 -pix_fmt yuva444p10le", "mov", false},
+		{"prores 422", "-i in.mov -c:v prores_ks -profile:v 3", "mov", true},
+		{"vp9 webm", "-i in.mov -c:v libvpx-vp9 -pix_fmt yuva420p", "webm", false},
+		{"vp9 default encoder", "-i in.mov", "webm", false},
+		{"vp9 in mp4", "-i in.mov -c:v libvpx-vp9 -pix_fmt yuva420p", "mp4", true},
+		{"vp9 without alpha pixel format", "-i in.mov -c:v libvpx-vp9 -pix_fmt yuv420p", "webm", true},
+		{"format filter", "-i in.mov -vf scale=640:-2,format=yuv420p", "webm", true},
+		{"h264", "-i in.mov -c:v libx264", "mp4", true},
+		{"h264 default encoder", "-i in.mov", "mp4", true},
+		{"apng", "-i in.mov -c:v apng -pix_fmt rgba", "apng", false},
+		{"png frame", "-i in.mov -frames:v 1", "png", false},
+		{"jpeg frame", "-i in.mov -frames:v 1", "jpg", true},
+		{"stream copy", "-i in.mov -c copy", "mp4", false},
+		{"no video", "-i in.mov -vn -c:a aac", "mp4", false},
+		{"audio output", "-i in.mov", "m4a", false},
+		{"unknown container", "-i in.mov", "ts", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := SplitCommand(tt.args)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.loses, AlphaLoss(args, tt.ext) != "", AlphaLoss(args, tt.ext))
+		})
+	}
+}
+
+func TestCheckAlpha(t *testing.T) {
+	warnings := CheckAlpha("-i ${INPUT_MEDIA} -c:v libx264 -pix_fmt yuva420p", "mp4")
+	if assert.Len(t, warnings, 1) {
+		assert.Equal(t, WarningAlphaDropped, warnings[0].Code)
+		assert.Contains(t, warnings[0].Message, "libx264")
+	}
+	assert.Len(t, CheckAlpha("-i ${INPUT_MEDIA} -c:v libvpx-vp9 -pix_fmt yuva420p", "mp4"), 1)
+
+	// Fine, or the command doesn't ask for alpha: the input is checked at run time.
+	assert.Empty(t, CheckAlpha("-i ${INPUT_MEDIA} -c:v libvpx-vp9 -pix_fmt yuva420p", "webm"))
+	assert.Empty(t, CheckAlpha("-i ${INPUT_MEDIA} -c:v libx264", "mp4"))
+}
+
+func TestInputHasAlpha(t *testing.T) {
+	assert.True(t, inputHasAlpha([]inputStream{{CodecType: "audio"}, {CodecType: "video", PixFmt: "yuva420p"}}))
+	assert.False(t, inputHasAlpha([]inputStream{{CodecType: "video", PixFmt: "yuv420p"}}))
+	assert.False(t, inputHasAlpha(nil))
+}
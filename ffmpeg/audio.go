@@ -0,0 +1,103 @@
+package ffmpeg
+
+import (
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// audioFormat is an audio-only output format of audio extraction.
+type audioFormat struct {
+    ext            string
+    codec          []string
+    defaultBitrate int // kbit/s; 0 for lossless formats, which take no bitrate
+    minBitrate     int
+    maxBitrate     int
+    sampleRates    []int // Supported by the encoder; any of audioSampleRates if nil
+}
+
+var audioFormats = map[string]audioFormat{
+    "mp3":  {ext: "mp3", codec: []string{"-c:a", "libmp3lame"}, defaultBitrate: 192, minBitrate: 32, maxBitrate: 320, sampleRates: []int{8000, 11025, 12000, 16000, 22050, 24000, 32000, 44100, 48000}},
+    "aac":  {ext: "m4a", codec: []string{"-c:a", "aac", "-movflags", "+faststart"}, defaultBitrate: 160, minBitrate: 32, maxBitrate: 512},
+    "opus": {ext: "opus", codec: []string{"-c:a", "libopus"}, defaultBitrate: 96, minBitrate: 6, maxBitrate: 510, sampleRates: []int{8000, 12000, 16000, 24000, 48000}},
+    "flac": {ext: "flac", codec: []string{"-c:a", "flac"}},
+}
+
+// audioSampleRates are the sample rates audio can be resampled to.
+var audioSampleRates = []int{8000, 11025, 12000, 16000, 22050, 24000, 32000, 44100, 48000, 88200, 96000}
+
+// AudioExtractSpec describes extracting an audio track from an input.
+type AudioExtractSpec struct {
+    Format     string // mp3 (default), aac (in .m4a), opus or flac
+    Bitrate    int    // kbit/s; the format's default if 0. Not for flac
+    SampleRate int    // Hz; the input's if 0
+    Channels   int    // 1 (mono) or 2 (stereo); the input's if 0
+    Track      int    // Audio stream of the input, from 0
+}
+
+// BuildAudioExtractCommand builds an ffmpeg command writing one audio track of
+// the input, without video, subtitles or data, to an audio-only file.
+func BuildAudioExtractCommand(spec AudioExtractSpec) (*ConversionJob, error) {
+    name := spec.Format
+    if name == "" {
+        name = "mp3"
+    }
+    format, ok := audioFormats[name]
+    if !ok {
+        return nil, fmt.Errorf("unsupported format %q (want %s)", spec.Format, strings.Join(audioFormatNames(), ", "))
+    }
+    if spec.Track < 0 {
+        return nil, fmt.Errorf("invalid track %d", spec.Track)
+    }
+
+    args := []string{"-i", InputMediaPlaceholder, "-map", fmt.Sprintf("0:a:%d", spec.Track), "-vn", "-sn", "-dn"}
+    args = append(args, format.codec...)
+    switch {
+    case format.defaultBitrate == 0 && spec.Bitrate != 0:
+        return nil, fmt.Errorf("%s is lossless and takes no bitrate", name)
+    case format.defaultBitrate != 0 && spec.Bitrate == 0:
+        args = append(args, "-b:a", strconv.Itoa(format.defaultBitrate)+"k")
+    case format.defaultBitrate != 0:
+        if spec.Bitrate < format.minBitrate || spec.Bitrate > format.maxBitrate {
+            return nil, fmt.Errorf("bitrate for %s must be %d to %d kbit/s", name, format.minBitrate, format.maxBitrate)
+        }
+        args = append(args, "-b:a", strconv.Itoa(spec.Bitrate)+"k")
+    }
+    if spec.SampleRate != 0 {
+        rates := format.sampleRates
+        if rates == nil {
+            rates = audioSampleRates
+        }
+        if !containsInt(rates, spec.SampleRate) {
+            return nil, fmt.Errorf("sample rate %d is not supported by %s", spec.SampleRate, name)
+        }
+        args = append(args, "-ar", strconv.Itoa(spec.SampleRate))
+    }
+    switch spec.Channels {
+    case 0:
+    case 1, 2:
+        args = append(args, "-ac", strconv.Itoa(spec.Channels))
+    default:
+        return nil, fmt.Errorf("invalid channels %d (want 1 or 2)", spec.Channels)
+    }
+    return &ConversionJob{Command: JoinCommand(args), OutputExt: format.ext}, nil
+}
+
+func audioFormatNames() []string {
+    names := make([]string, 0, len(audioFormats))
+    for name := range audioFormats {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+func containsInt(values []int, v int) bool {
+    for _, x := range values {
+        if x == v {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,45 @@
+package ffmpeg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildAudioExtractCommand(t *testing.T) {
+	job, err := BuildAudioExtractCommand(AudioExtractSpec{})
+	require.NoError(t, err)
+	assert.Equal(t, "mp3", job.OutputExt)
+	args, err := SplitCommand(job.Command)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-i", InputMediaPlaceholder, "-map", "0:a:0", "-vn", "-sn", "-dn", "-c:a", "libmp3lame", "-b:a", "192k"}, args)
+
+	job, err = BuildAudioExtractCommand(AudioExtractSpec{Format: "aac", Bitrate: 64, SampleRate: 44100, Channels: 1, Track: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "m4a", job.OutputExt)
+	assert.Equal(t, "-i ${INPUT_MEDIA} -map 0:a:1 -vn -sn -dn -c:a aac -movflags +faststart -b:a 64k -ar 44100 -ac 1", job.Command)
+
+	job, err = BuildAudioExtractCommand(AudioExtractSpec{Format: "opus"})
+	require.NoError(t, err)
+	assert.Equal(t, "opus", job.OutputExt)
+	assert.Contains(t, job.Command, "-c:a libopus -b:a 96k")
+
+	job, err = BuildAudioExtractCommand(AudioExtractSpec{Format: "flac", SampleRate: 96000})
+	require.NoError(t, err)
+	assert.Equal(t, "flac", job.OutputExt)
+	assert.NotContains(t, job.Command, "-b:a")
+
+	for spec, msg := range map[AudioExtractSpec]string{
+		{Format: "wav"}:                     `unsupported format "wav" (want aac, flac, mp3, opus)`,
+		{Format: "flac", Bitrate: 128}:      "lossless",
+		{Format: "mp3", Bitrate: 500}:       "32 to 320 kbit/s",
+		{Format: "opus", SampleRate: 44100}: "sample rate 44100 is not supported by opus",
+		{SampleRate: 12345}:                 "sample rate 12345",
+		{Channels: 6}:                       "invalid channels 6",
+		{Track: -1}:                         "invalid track",
+	} {
+		_, err := BuildAudioExtractCommand(spec)
+		assert.ErrorContains(t, err, msg)
+	}
+}
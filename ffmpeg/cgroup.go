@@ -0,0 +1,132 @@
+package ffmpeg
+
+import (
+    "fmt"
+    "log/slog"
+    "sort"
+    "strconv"
+    "strings"
+
+    "ffwebapi/config"
+    "ffwebapi/task"
+    "github.com/c2h5oh/datasize"
+)
+
+// cgroupPeriod is the cpu.max period; the quota is a share of it per CPU.
+const cgroupPeriod = 100000
+
+// cgroupLimits are the caps of the cgroup a task command runs in. Zero
+// values mean unlimited.
+type cgroupLimits struct {
+    cpus   float64
+    memory int64
+}
+
+// cpuMax renders the limits as a cpu.max value, e.g. "150000 100000".
+func (l cgroupLimits) cpuMax() string {
+    if l.cpus <= 0 {
+        return fmt.Sprintf("max %d", cgroupPeriod)
+    }
+    return fmt.Sprintf("%d %d", int64(l.cpus*cgroupPeriod), cgroupPeriod)
+}
+
+// memoryMax renders the limits as a memory.max value.
+func (l cgroupLimits) memoryMax() string {
+    if l.memory <= 0 {
+        return "max"
+    }
+    return strconv.FormatInt(l.memory, 10)
+}
+
+// parseResourceClass parses a RESOURCE_CLASSES entry such as
+// "cpu:8 memory:16GB". Limits it doesn't name are taken from base.
+func parseResourceClass(spec string, base cgroupLimits) (cgroupLimits, error) {
+    l := base
+    for _, field := range strings.Fields(spec) {
+        key, value, _ := strings.Cut(field, ":")
+        switch key {
+        case "cpu":
+            cpus, err := strconv.ParseFloat(value, 64)
+            if err != nil || cpus < 0 {
+                return l, fmt.Errorf("invalid cpu %q", value)
+            }
+            l.cpus = cpus
+        case "memory":
+            var size datasize.ByteSize
+            if err := size.UnmarshalText([]byte(value)); err != nil {
+                return l, fmt.Errorf("invalid memory %q", value)
+            }
+            l.memory = int64(size.Bytes())
+        default:
+            return l, fmt.Errorf("unknown limit %q (want cpu:<cpus> or memory:<size>)", field)
+        }
+    }
+    return l, nil
+}
+
+// resourceClasses parses RESOURCE_CLASSES on top of the default limits.
+func resourceClasses(cfg *config.Config) (map[string]cgroupLimits, error) {
+    base := cgroupLimits{cpus: cfg.CgroupCPUMax, memory: cfg.CgroupMemoryMax}
+    classes := make(map[string]cgroupLimits, len(cfg.ResourceClasses))
+    for name, spec := range cfg.ResourceClasses {
+        l, err := parseResourceClass(spec, base)
+        if err != nil {
+            return nil, fmt.Errorf("resource class %q: %w", name, err)
+        }
+        classes[strings.ToLower(name)] = l
+    }
+    return classes, nil
+}
+
+// ResourceClasses lists the resource classes tasks may ask for, sorted by name.
+func ResourceClasses(cfg *config.Config) []string {
+    var names []string
+    for name := range cfg.ResourceClasses {
+        names = append(names, strings.ToLower(name))
+    }
+    sort.Strings(names)
+    return names
+}
+
+// LookupResourceClass returns the canonical name of a configured resource
+// class. Names are case-insensitive.
+func LookupResourceClass(cfg *config.Config, name string) (string, bool) {
+    for n := range cfg.ResourceClasses {
+        if strings.EqualFold(n, name) {
+            return strings.ToLower(n), true
+        }
+    }
+    return "", false
+}
+
+// checkCgroupConfig validates the cgroup settings and prepares CGROUP_ROOT.
+// It returns the limits of every resource class.
+func checkCgroupConfig(cfg *config.Config) (map[string]cgroupLimits, error) {
+    if cfg.CgroupCPUMax < 0 || cfg.CgroupMemoryMax < 0 {
+        return nil, fmt.Errorf("CGROUP_CPU_MAX and CGROUP_MEMORY_MAX must not be negative")
+    }
+    classes, err := resourceClasses(cfg)
+    if err != nil {
+        return nil, err
+    }
+    if cfg.CgroupRoot == "" {
+        if len(classes) > 0 || cfg.CgroupCPUMax > 0 || cfg.CgroupMemoryMax > 0 {
+            slog.Warn("CGROUP_ROOT is not set; CPU and memory caps are not applied")
+        }
+        return classes, nil
+    }
+    if err := setupCgroups(cfg.CgroupRoot); err != nil {
+        return nil, err
+    }
+    slog.Info("Task commands run in cgroups", "root", cfg.CgroupRoot, "resource_classes", ResourceClasses(cfg))
+    return classes, nil
+}
+
+// limitsFor returns the cgroup limits of a task: those of its resource class,
+// or the defaults.
+func (r *Runner) limitsFor(t *task.Task) cgroupLimits {
+    if l, ok := r.classes[t.ResourceClass]; ok {
+        return l
+    }
+    return cgroupLimits{cpus: r.cfg.CgroupCPUMax, memory: r.cfg.CgroupMemoryMax}
+}
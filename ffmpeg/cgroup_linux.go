@@ -0,0 +1,116 @@
+//go:build linux
+
+package ffmpeg
+
+import (
+    "bufio"
+    "bytes"
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "syscall"
+    "time"
+)
+
+// cgroup2Magic is the file system type of cgroup v2 mounts (CGROUP2_SUPER_MAGIC).
+const cgroup2Magic = 0x63677270
+
+// setupCgroups checks that root is a cgroup v2 directory and enables the cpu
+// and memory controllers for the task cgroups created in it.
+func setupCgroups(root string) error {
+    if err := os.MkdirAll(root, 0o755); err != nil {
+        return fmt.Errorf("could not create CGROUP_ROOT: %w", err)
+    }
+    var st syscall.Statfs_t
+    if err := syscall.Statfs(root, &st); err != nil {
+        return fmt.Errorf("could not inspect CGROUP_ROOT: %w", err)
+    }
+    if int64(st.Type) != cgroup2Magic {
+        return fmt.Errorf("CGROUP_ROOT %s is not on a cgroup v2 file system", root)
+    }
+    if err := os.WriteFile(filepath.Join(root, "cgroup.subtree_control"), []byte("+cpu +memory"), 0); err != nil {
+        return fmt.Errorf("could not enable the cpu and memory controllers in %s (it must be delegated and hold no processes): %w", root, err)
+    }
+    return nil
+}
+
+// taskCgroup is the cgroup one task command runs in.
+type taskCgroup struct {
+    path string
+    dir  *os.File // Handed to clone3 so the command starts inside the cgroup
+}
+
+// newTaskCgroup creates a cgroup for a task command below root and applies
+// the limits to it.
+func newTaskCgroup(root, name string, l cgroupLimits) (*taskCgroup, error) {
+    // A fresh name per attempt, in case an earlier cgroup is still draining.
+    path, err := os.MkdirTemp(root, name+"_")
+    if err != nil {
+        return nil, fmt.Errorf("could not create task cgroup: %w", err)
+    }
+    cg := &taskCgroup{path: path}
+    settings := []struct{ file, value string }{
+        {"cpu.max", l.cpuMax()},
+        {"memory.max", l.memoryMax()},
+    }
+    if l.memory > 0 {
+        // Swapping would let the command exceed its memory cap. The file is
+        // missing without swap accounting.
+        settings = append(settings, struct{ file, value string }{"memory.swap.max", "0"})
+    }
+    for _, s := range settings {
+        err := os.WriteFile(filepath.Join(path, s.file), []byte(s.value), 0o644)
+        if err != nil && !(s.file == "memory.swap.max" && errors.Is(err, fs.ErrNotExist)) {
+            cg.remove()
+            return nil, fmt.Errorf("could not set %s of the task cgroup: %w", s.file, err)
+        }
+    }
+    if cg.dir, err = os.Open(path); err != nil {
+        cg.remove()
+        return nil, err
+    }
+    return cg, nil
+}
+
+// attach makes the command start inside the cgroup.
+func (cg *taskCgroup) attach(cmd *exec.Cmd) {
+    if cmd.SysProcAttr == nil {
+        cmd.SysProcAttr = &syscall.SysProcAttr{}
+    }
+    cmd.SysProcAttr.UseCgroupFD = true
+    cmd.SysProcAttr.CgroupFD = int(cg.dir.Fd())
+}
+
+// oomKilled reports whether a process of the cgroup was killed for
+// exceeding memory.max.
+func (cg *taskCgroup) oomKilled() bool {
+    data, err := os.ReadFile(filepath.Join(cg.path, "memory.events"))
+    if err != nil {
+        return false
+    }
+    scanner := bufio.NewScanner(bytes.NewReader(data))
+    for scanner.Scan() {
+        if count, ok := strings.CutPrefix(scanner.Text(), "oom_kill "); ok {
+            return count != "0"
+        }
+    }
+    return false
+}
+
+// remove kills what is left in the cgroup and deletes it.
+func (cg *taskCgroup) remove() {
+    if cg.dir != nil {
+        cg.dir.Close()
+    }
+    os.WriteFile(filepath.Join(cg.path, "cgroup.kill"), []byte("1"), 0)
+    for i := 0; i < 10; i++ {
+        if err := os.Remove(cg.path); err == nil || errors.Is(err, fs.ErrNotExist) {
+            return
+        }
+        time.Sleep(20 * time.Millisecond) // Killed processes take a moment to leave
+    }
+}
@@ -0,0 +1,34 @@
+//go:build linux
+
+package ffmpeg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTaskCgroup(t *testing.T) {
+	// A plain directory stands in for the cgroup file system.
+	root := t.TempDir()
+	cg, err := newTaskCgroup(root, "task1", cgroupLimits{cpus: 2, memory: 1 << 30})
+	require.NoError(t, err)
+	defer cg.dir.Close()
+	assert.Equal(t, root, filepath.Dir(cg.path))
+
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(cg.path, name))
+		require.NoError(t, err)
+		return string(data)
+	}
+	assert.Equal(t, "200000 100000", read("cpu.max"))
+	assert.Equal(t, "1073741824", read("memory.max"))
+	assert.Equal(t, "0", read("memory.swap.max"))
+
+	assert.False(t, cg.oomKilled())
+	require.NoError(t, os.WriteFile(filepath.Join(cg.path, "memory.events"), []byte("low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n"), 0o644))
+	assert.True(t, cg.oomKilled())
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package ffmpeg
+
+import (
+    "errors"
+    "os/exec"
+)
+
+func setupCgroups(root string) error {
+    return errors.New("CGROUP_ROOT is only supported on Linux")
+}
+
+// taskCgroup is not supported on this platform.
+type taskCgroup struct{}
+
+func newTaskCgroup(root, name string, l cgroupLimits) (*taskCgroup, error) {
+    return nil, errors.New("cgroups are only supported on Linux")
+}
+
+func (cg *taskCgroup) attach(cmd *exec.Cmd) {}
+
+func (cg *taskCgroup) oomKilled() bool { return false }
+
+func (cg *taskCgroup) remove() {}
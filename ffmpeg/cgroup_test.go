@@ -0,0 +1,49 @@
+package ffmpeg
+
+import (
+	"testing"
+
+	"ffwebapi/config"
+	"ffwebapi/task"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCgroupLimits(t *testing.T) {
+	assert.Equal(t, "max 100000", cgroupLimits{}.cpuMax())
+	assert.Equal(t, "max", cgroupLimits{}.memoryMax())
+	assert.Equal(t, "150000 100000", cgroupLimits{cpus: 1.5}.cpuMax())
+	assert.Equal(t, "1073741824", cgroupLimits{memory: 1 << 30}.memoryMax())
+}
+
+func TestResourceClasses(t *testing.T) {
+	cfg := &config.Config{CgroupCPUMax: 2, CgroupMemoryMax: 4 << 30, ResourceClasses: map[string]string{
+		"small": "cpu:0.5 memory:512MB",
+		"4K":    "cpu:8",
+	}}
+	classes, err := checkCgroupConfig(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]cgroupLimits{
+		"small": {cpus: 0.5, memory: 512 << 20},
+		"4k":    {cpus: 8, memory: 4 << 30}, // Memory from CGROUP_MEMORY_MAX
+	}, classes)
+	assert.Equal(t, []string{"4k", "small"}, ResourceClasses(cfg))
+
+	name, ok := LookupResourceClass(cfg, "4k")
+	assert.True(t, ok)
+	assert.Equal(t, "4k", name)
+	_, ok = LookupResourceClass(cfg, "huge")
+	assert.False(t, ok)
+
+	r := &Runner{cfg: cfg, classes: classes}
+	assert.Equal(t, cgroupLimits{cpus: 8, memory: 4 << 30}, r.limitsFor(&task.Task{ResourceClass: "4k"}))
+	assert.Equal(t, cgroupLimits{cpus: 2, memory: 4 << 30}, r.limitsFor(&task.Task{}))
+
+	for _, spec := range []string{"cpu:many", "cpu:-1", "memory:lots", "disk:1GB"} {
+		_, err := checkCgroupConfig(&config.Config{ResourceClasses: map[string]string{"bad": spec}})
+		assert.Error(t, err, spec)
+	}
+	_, err = checkCgroupConfig(&config.Config{CgroupMemoryMax: -1})
+	assert.Error(t, err)
+}
@@ -0,0 +1,98 @@
+package ffmpeg
+
+import (
+    "errors"
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Clip modes.
+const (
+    ClipModeEncode = "encode" // Frame-accurate cut, re-encoding the clip
+    ClipModeCopy   = "copy"   // Stream copy; fast and lossless, but starts at the keyframe before Start
+)
+
+// ClipSpec describes a clip cut out of the input.
+type ClipSpec struct {
+    Start     time.Duration
+    End       time.Duration // Clip end in the input's timeline; 0 for Duration, or the end of the input if both are 0
+    Duration  time.Duration
+    Mode      string // ClipModeEncode (default) or ClipModeCopy
+    OutputExt string // mp4 (default), mov or mkv
+}
+
+// ParseClipTime parses a position in the input: seconds such as "90.5", or
+// "MM:SS" and "HH:MM:SS" with optional fractional seconds, e.g. "01:30.5".
+func ParseClipTime(s string) (time.Duration, error) {
+    parts := strings.Split(strings.TrimSpace(s), ":")
+    if len(parts) > 3 {
+        return 0, fmt.Errorf("invalid time %q", s)
+    }
+    secs, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+    if err != nil || !(secs >= 0) || math.IsInf(secs, 0) || (len(parts) > 1 && secs >= 60) {
+        return 0, fmt.Errorf("invalid time %q (want seconds or HH:MM:SS.mmm)", s)
+    }
+    total := secs
+    for i, unit := len(parts)-2, 60.0; i >= 0; i, unit = i-1, unit*60 {
+        n, err := strconv.Atoi(parts[i])
+        if err != nil || n < 0 || (i > 0 && n >= 60) {
+            return 0, fmt.Errorf("invalid time %q (want seconds or HH:MM:SS.mmm)", s)
+        }
+        total += float64(n) * unit
+    }
+    return time.Duration(math.Round(total*1000)) * time.Millisecond, nil
+}
+
+// BuildClipCommand builds an ffmpeg command cutting a clip out of the input's
+// video and audio streams. Both modes seek before the input, which is fast
+// and, when re-encoding, exact; the clip's length is passed as -t after the
+// input, so it holds whichever way ffmpeg resets timestamps on seeking.
+func BuildClipCommand(spec ClipSpec) (*ConversionJob, error) {
+    ext := spec.OutputExt
+    if ext == "" {
+        ext = "mp4"
+    }
+    codecs, ext, err := conversionContainer(ext)
+    if err != nil {
+        return nil, err
+    }
+    switch spec.Mode {
+    case "", ClipModeEncode:
+    case ClipModeCopy:
+        codecs = []string{"-c", "copy", "-avoid_negative_ts", "make_zero"}
+    default:
+        return nil, fmt.Errorf("unknown mode %q (want encode or copy)", spec.Mode)
+    }
+    duration := spec.Duration
+    switch {
+    case spec.Start < 0 || spec.End < 0 || spec.Duration < 0:
+        return nil, errors.New("times must not be negative")
+    case spec.End > 0 && spec.Duration > 0:
+        return nil, errors.New("end and duration are mutually exclusive")
+    case spec.End > 0 && spec.End <= spec.Start:
+        return nil, fmt.Errorf("end %s is not after start %s", spec.End, spec.Start)
+    case spec.End > 0:
+        duration = spec.End - spec.Start
+    case spec.Start == 0 && duration == 0:
+        return nil, errors.New("start, end or duration is required")
+    }
+
+    var args []string
+    if spec.Start > 0 {
+        args = append(args, "-ss", clipSeconds(spec.Start))
+    }
+    args = append(args, "-i", InputMediaPlaceholder)
+    if duration > 0 {
+        args = append(args, "-t", clipSeconds(duration))
+    }
+    args = append(args, "-map", "0:v?", "-map", "0:a?")
+    args = append(args, codecs...)
+    return &ConversionJob{Command: JoinCommand(args), OutputExt: ext}, nil
+}
+
+func clipSeconds(d time.Duration) string {
+    return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
@@ -0,0 +1,60 @@
+package ffmpeg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClipTime(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"0":            0,
+		"90":           90 * time.Second,
+		"90.5":         90*time.Second + 500*time.Millisecond,
+		"01:30":        90 * time.Second,
+		"1:30.25":      90*time.Second + 250*time.Millisecond,
+		"01:02:03.040": time.Hour + 2*time.Minute + 3*time.Second + 40*time.Millisecond,
+		"25:00:00":     25 * time.Hour,
+	} {
+		d, err := ParseClipTime(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, want, d, s)
+	}
+	for _, s := range []string{"", "-5", "abc", "01:60", "01:60:00", "1:2:3:4", "00:-1", "Inf", "NaN"} {
+		_, err := ParseClipTime(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestBuildClipCommand(t *testing.T) {
+	job, err := BuildClipCommand(ClipSpec{Start: 90 * time.Second, End: 100*time.Second + 500*time.Millisecond})
+	require.NoError(t, err)
+	assert.Equal(t, "mp4", job.OutputExt)
+	args, err := SplitCommand(job.Command)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-ss", "90.000", "-i", InputMediaPlaceholder, "-t", "10.500", "-map", "0:v?", "-map", "0:a?",
+		"-c:v", "libx264", "-preset", "medium", "-crf", "16", "-pix_fmt", "yuv420p", "-c:a", "aac", "-b:a", "256k"}, args)
+
+	job, err = BuildClipCommand(ClipSpec{Start: 5 * time.Second, Mode: ClipModeCopy, OutputExt: "mkv"})
+	require.NoError(t, err)
+	assert.Equal(t, "mkv", job.OutputExt)
+	assert.Equal(t, "-ss 5.000 -i ${INPUT_MEDIA} -map 0:v? -map 0:a? -c copy -avoid_negative_ts make_zero", job.Command)
+
+	job, err = BuildClipCommand(ClipSpec{Duration: 30 * time.Second, Mode: ClipModeCopy})
+	require.NoError(t, err)
+	assert.Equal(t, "-i ${INPUT_MEDIA} -t 30.000 -map 0:v? -map 0:a? -c copy -avoid_negative_ts make_zero", job.Command)
+
+	for spec, msg := range map[ClipSpec]string{
+		{}: "start, end or duration is required",
+		{Start: 10 * time.Second, End: 5 * time.Second}:   "not after start",
+		{End: 5 * time.Second, Duration: 5 * time.Second}: "mutually exclusive",
+		{Duration: time.Second, Mode: "fast"}:             `unknown mode "fast"`,
+		{Duration: time.Second, OutputExt: "avi"}:         `unsupported output format "avi"`,
+		{Start: -time.Second, Duration: time.Second}:      "negative",
+	} {
+		_, err := BuildClipCommand(spec)
+		assert.ErrorContains(t, err, msg)
+	}
+}
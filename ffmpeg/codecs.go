@@ -0,0 +1,149 @@
+package ffmpeg
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "os/exec"
+    "strings"
+
+    "ffwebapi/task"
+)
+
+// encoderFallbacks lists, per encoder, the encoders to use instead when the
+// local ffmpeg build lacks it, nearest first.
+var encoderFallbacks = map[string][]string{
+    "libsvtav1":  {"libaom-av1", "librav1e", "libvpx-vp9", "libx265", "libx264"},
+    "libaom-av1": {"libsvtav1", "librav1e", "libvpx-vp9", "libx265", "libx264"},
+    "libvpx-vp9": {"libaom-av1", "libsvtav1", "libx264"},
+    "libx265":    {"libx264"},
+    "libopus":    {"libvorbis", "aac"},
+    "libvorbis":  {"libopus", "aac"},
+}
+
+// encoderContainers restricts fallbacks to encoders the output container can
+// hold. Containers not listed accept any encoder.
+var encoderContainers = map[string][]string{
+    "libx264":    {"mp4", "mkv", "mov"},
+    "libx265":    {"mp4", "mkv", "mov"},
+    "libsvtav1":  {"mp4", "mkv", "webm"},
+    "libaom-av1": {"mp4", "mkv", "webm"},
+    "librav1e":   {"mp4", "mkv", "webm"},
+    "libvpx-vp9": {"mp4", "mkv", "webm"},
+    "libopus":    {"mp4", "mkv", "webm", "ogg", "opus"},
+    "libvorbis":  {"mkv", "webm", "ogg"},
+    "aac":        {"mp4", "mkv", "mov", "m4a"},
+}
+
+// encoderTuning are the speed and quality settings a fallback encoder starts
+// with, replacing those of the encoder it stands in for.
+var encoderTuning = map[string][]string{
+    "libx264":    {"-preset", "medium", "-crf", "23"},
+    "libx265":    {"-preset", "medium", "-crf", "28"},
+    "libsvtav1":  {"-preset", "8", "-crf", "32"},
+    "libaom-av1": {"-cpu-used", "6", "-row-mt", "1", "-crf", "32", "-b:v", "0"},
+    "librav1e":   {"-speed", "8", "-qp", "100"},
+    "libvpx-vp9": {"-deadline", "good", "-cpu-used", "4", "-row-mt", "1", "-crf", "32", "-b:v", "0"},
+}
+
+// encoderTuningFlags are encoder specific options (with a value) dropped
+// when the video encoder is replaced.
+var encoderTuningFlags = map[string]bool{
+    "-preset": true, "-crf": true, "-qp": true, "-tune": true, "-profile:v": true,
+    "-cpu-used": true, "-row-mt": true, "-deadline": true, "-speed": true,
+    "-svtav1-params": true, "-x265-params": true, "-x264-params": true, "-aom-params": true,
+}
+
+// detectEncoders lists the encoders of the ffmpeg build, or returns nil if
+// they cannot be determined, in which case no encoder is substituted.
+func detectEncoders(ffmpegBin string) map[string]bool {
+    ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+    defer cancel()
+    out, err := exec.CommandContext(ctx, ffmpegBin, "-hide_banner", "-encoders").Output()
+    if err != nil {
+        return nil
+    }
+    return parseEncoders(out)
+}
+
+// parseEncoders reads the output of "ffmpeg -encoders": a legend, a dashed
+// line, then one " V....D libx264  description" line per encoder.
+func parseEncoders(out []byte) map[string]bool {
+    encoders := make(map[string]bool)
+    listing := false
+    scanner := bufio.NewScanner(bytes.NewReader(out))
+    for scanner.Scan() {
+        fields := strings.Fields(scanner.Text())
+        switch {
+        case len(fields) == 1 && strings.HasPrefix(fields[0], "---"):
+            listing = true
+        case listing && len(fields) >= 2:
+            encoders[fields[1]] = true
+        }
+    }
+    if len(encoders) == 0 {
+        return nil
+    }
+    return encoders
+}
+
+// SubstituteEncoders replaces the encoders of args that are not available
+// with the nearest available fallback the output container can hold. When a
+// video encoder is replaced, the tuning options of the command give way to
+// the fallback's. Encoders without a usable fallback are left for ffmpeg to
+// report.
+func SubstituteEncoders(args []string, outputExt string, available map[string]bool) ([]string, []task.CodecSubstitution) {
+    var subs []task.CodecSubstitution
+    used := make(map[int]string) // By index of the encoder name in args
+    retuned := false
+    for i := 1; i < len(args); i++ {
+        if !isCodecFlag(args[i-1]) || available[args[i]] {
+            continue
+        }
+        for _, candidate := range encoderFallbacks[args[i]] {
+            if available[candidate] && fitsContainer(candidate, outputExt) {
+                subs = append(subs, task.CodecSubstitution{Requested: args[i], Used: candidate})
+                used[i] = candidate
+                _, video := encoderTuning[candidate]
+                retuned = retuned || video
+                break
+            }
+        }
+    }
+    if len(subs) == 0 {
+        return args, nil
+    }
+
+    out := make([]string, 0, len(args)+8)
+    for i := 0; i < len(args); i++ {
+        switch {
+        case retuned && i+1 < len(args) && (encoderTuningFlags[args[i]] || (args[i] == "-b:v" && args[i+1] == "0")):
+            i++ // Tuning of the replaced encoder; "-b:v 0" selects its constant quality mode
+        case used[i] != "":
+            out = append(out, used[i])
+            out = append(out, encoderTuning[used[i]]...)
+        default:
+            out = append(out, args[i])
+        }
+    }
+    return out, subs
+}
+
+func isCodecFlag(arg string) bool {
+    switch arg {
+    case "-c:v", "-codec:v", "-vcodec", "-c:a", "-codec:a", "-acodec":
+        return true
+    }
+    return false
+}
+
+// fitsContainer tells whether the output container can hold the encoder's
+// stream. Unknown containers are assumed to hold anything.
+func fitsContainer(encoder, outputExt string) bool {
+    for _, containers := range encoderContainers {
+        if hasArg(containers, outputExt) {
+            return hasArg(encoderContainers[encoder], outputExt)
+        }
+    }
+    return true
+}
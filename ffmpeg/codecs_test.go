@@ -0,0 +1,53 @@
+package ffmpeg
+
+import (
+	"testing"
+
+	"ffwebapi/task"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEncoders(t *testing.T) {
+	out := []byte(`Encoders:
+ V..... = Video
+ A..... = Audio
+ ------
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC (codec h264)
+ V....D libaom-av1           libaom AV1 (codec av1)
+ A....D aac                  AAC (Advanced Audio Coding)
+`)
+	assert.Equal(t, map[string]bool{"libx264": true, "libaom-av1": true, "aac": true}, parseEncoders(out))
+	assert.Nil(t, parseEncoders([]byte("ffmpeg: unrecognized option")))
+}
+
+func TestSubstituteEncoders(t *testing.T) {
+	av1 := []string{"-i", "in.mkv", "-c:v", "libsvtav1", "-preset", "8", "-crf", "32", "-c:a", "libopus", "-b:a", "96k"}
+
+	// Everything available: nothing changes.
+	all := map[string]bool{"libsvtav1": true, "libopus": true}
+	args, subs := SubstituteEncoders(av1, "mp4", all)
+	assert.Equal(t, av1, args)
+	assert.Empty(t, subs)
+
+	// The nearest fallback takes over, with its own tuning.
+	args, subs = SubstituteEncoders(av1, "mp4", map[string]bool{"libaom-av1": true, "libx264": true, "libopus": true})
+	assert.Equal(t, []string{"-i", "in.mkv", "-c:v", "libaom-av1", "-cpu-used", "6", "-row-mt", "1", "-crf", "32", "-b:v", "0", "-c:a", "libopus", "-b:a", "96k"}, args)
+	assert.Equal(t, []task.CodecSubstitution{{Requested: "libsvtav1", Used: "libaom-av1"}}, subs)
+
+	// Fallbacks the container cannot hold are skipped: WebM takes VP9 but not H.264.
+	args, subs = SubstituteEncoders(av1, "webm", map[string]bool{"libx264": true, "libvpx-vp9": true, "libvorbis": true, "aac": true})
+	assert.Equal(t, []string{"-i", "in.mkv", "-c:v", "libvpx-vp9", "-deadline", "good", "-cpu-used", "4", "-row-mt", "1", "-crf", "32", "-b:v", "0", "-c:a", "libvorbis", "-b:a", "96k"}, args)
+	assert.Equal(t, []task.CodecSubstitution{{Requested: "libsvtav1", Used: "libvpx-vp9"}, {Requested: "libopus", Used: "libvorbis"}}, subs)
+
+	// Audio-only substitutions keep the video tuning.
+	hevc := []string{"-i", "in.mkv", "-c:v", "libx265", "-preset", "medium", "-crf", "26", "-c:a", "libopus"}
+	args, subs = SubstituteEncoders(hevc, "mp4", map[string]bool{"libx265": true, "aac": true})
+	assert.Equal(t, []string{"-i", "in.mkv", "-c:v", "libx265", "-preset", "medium", "-crf", "26", "-c:a", "aac"}, args)
+	assert.Len(t, subs, 1)
+
+	// Without a usable fallback the command is left for ffmpeg to report.
+	args, subs = SubstituteEncoders(av1, "webm", map[string]bool{"libx264": true, "libopus": true})
+	assert.Equal(t, av1, args)
+	assert.Empty(t, subs)
+}
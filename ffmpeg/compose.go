@@ -0,0 +1,93 @@
+package ffmpeg
+
+import (
+    "fmt"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+// maxComposeOffset bounds the overlay position, in pixels from the top-left
+// corner of the background.
+const maxComposeOffset = 16384
+
+// hexColorRe and colorNameRe match the background colors accepted, e.g.
+// "#00b140" or "black"; ffmpeg knows the color names.
+var (
+    hexColorRe  = regexp.MustCompile(`^(?:#|0x)([0-9a-fA-F]{6})$`)
+    colorNameRe = regexp.MustCompile(`^[a-zA-Z]{3,24}$`)
+)
+
+// ComposeSpec describes overlaying a source with an alpha channel, e.g.
+// ProRes 4444, VP9 WebM with alpha or APNG, onto a background.
+type ComposeSpec struct {
+    BackgroundMedia bool   // Overlay onto ${INPUT_MEDIA_1}, looped as needed, rather than a color
+    BackgroundColor string // Solid background the size of the source; black if empty
+    X, Y            *int   // Top-left corner of the source on the background; centered if nil
+    OutputExt       string // mov (default), mp4 or mkv
+}
+
+// BuildComposeCommand builds an ffmpeg command overlaying the input onto a
+// background, blending by the input's alpha channel. The output lasts as long
+// as the input and keeps its audio.
+func BuildComposeCommand(spec ComposeSpec) (*ConversionJob, error) {
+    codecs, ext, err := conversionContainer(spec.OutputExt)
+    if err != nil {
+        return nil, err
+    }
+    x, y := "(W-w)/2", "(H-h)/2"
+    if spec.X != nil {
+        if x, err = composeOffset("x", *spec.X); err != nil {
+            return nil, err
+        }
+    }
+    if spec.Y != nil {
+        if y, err = composeOffset("y", *spec.Y); err != nil {
+            return nil, err
+        }
+    }
+    overlay := fmt.Sprintf("overlay=x=%s:y=%s:shortest=1[v]", x, y)
+
+    args := []string{"-i", InputMediaPlaceholder}
+    var graph string
+    if spec.BackgroundMedia {
+        if spec.BackgroundColor != "" {
+            return nil, fmt.Errorf("a background color cannot be combined with background media")
+        }
+        // Looping makes a still image last, and a short clip repeat, for as
+        // long as the source runs.
+        args = append(args, "-stream_loop", "-1", "-i", ExtraInputPlaceholder(1))
+        graph = "[1:v][0:v]" + overlay
+    } else {
+        color, err := composeColor(spec.BackgroundColor)
+        if err != nil {
+            return nil, err
+        }
+        // The filled copy of the source is the background: same size and
+        // frames, made opaque by dropping its alpha plane.
+        graph = "[0:v]split[fg][bg];[bg]drawbox=c=" + color + ":t=fill,format=yuv444p[canvas];[canvas][fg]" + overlay
+    }
+    args = append(args, "-filter_complex", graph, "-map", "[v]", "-map", "0:a?")
+    args = append(args, codecs...)
+    return &ConversionJob{Command: JoinCommand(args), OutputExt: ext}, nil
+}
+
+func composeOffset(name string, v int) (string, error) {
+    if v < -maxComposeOffset || v > maxComposeOffset {
+        return "", fmt.Errorf("%s must be between %d and %d", name, -maxComposeOffset, maxComposeOffset)
+    }
+    return strconv.Itoa(v), nil
+}
+
+// composeColor normalizes a background color to ffmpeg's syntax.
+func composeColor(color string) (string, error) {
+    switch {
+    case color == "":
+        return "black", nil
+    case hexColorRe.MatchString(color):
+        return "0x" + strings.ToUpper(hexColorRe.FindStringSubmatch(color)[1]), nil
+    case colorNameRe.MatchString(color):
+        return strings.ToLower(color), nil
+    }
+    return "", fmt.Errorf("invalid background color %q (want a name like \"black\" or a hex color like \"#00b140\")", color)
+}
@@ -0,0 +1,44 @@
+package ffmpeg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildComposeCommand(t *testing.T) {
+	job, err := BuildComposeCommand(ComposeSpec{})
+	require.NoError(t, err)
+	assert.Equal(t, "mov", job.OutputExt)
+	args, err := SplitCommand(job.Command)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-i", InputMediaPlaceholder,
+		"-filter_complex", "[0:v]split[fg][bg];[bg]drawbox=c=black:t=fill,format=yuv444p[canvas];[canvas][fg]overlay=x=(W-w)/2:y=(H-h)/2:shortest=1[v]",
+		"-map", "[v]", "-map", "0:a?",
+		"-c:v", "libx264", "-preset", "medium", "-crf", "16", "-pix_fmt", "yuv420p", "-c:a", "pcm_s24le"}, args)
+
+	x, y := 40, -20
+	job, err = BuildComposeCommand(ComposeSpec{BackgroundMedia: true, X: &x, Y: &y, OutputExt: "mp4"})
+	require.NoError(t, err)
+	args, err = SplitCommand(job.Command)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-i", InputMediaPlaceholder, "-stream_loop", "-1", "-i", "${INPUT_MEDIA_1}",
+		"-filter_complex", "[1:v][0:v]overlay=x=40:y=-20:shortest=1[v]"}, args[:8])
+
+	job, err = BuildComposeCommand(ComposeSpec{BackgroundColor: "#00b140"})
+	require.NoError(t, err)
+	assert.Contains(t, job.Command, "drawbox=c=0x00B140:t=fill")
+
+	far := 20000
+	for _, spec := range []ComposeSpec{
+		{BackgroundColor: "red;"},
+		{BackgroundColor: "#00b14"},
+		{BackgroundMedia: true, BackgroundColor: "black"},
+		{X: &far},
+		{OutputExt: "gif"},
+	} {
+		_, err := BuildComposeCommand(spec)
+		assert.Error(t, err, spec)
+	}
+}
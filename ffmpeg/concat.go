@@ -0,0 +1,273 @@
+package ffmpeg
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+
+    "ffwebapi/task"
+)
+
+// Ways of joining inputs, reported as the task's concatMethod.
+const (
+    ConcatMethodDemuxer = "demuxer" // Stream copy; the inputs share codecs and stream parameters
+    ConcatMethodFilter  = "filter"  // Re-encode, scaling and padding every input to the first one's frame
+)
+
+// MaxConcatInputs bounds the number of inputs joined by one task.
+const MaxConcatInputs = 100
+
+// concatListName is the concat demuxer's list of inputs in the working directory.
+const concatListName = "concat.txt"
+
+// BuildConcatCommand builds an ffmpeg command joining n inputs, the first at
+// ${INPUT_MEDIA} and the others at ${INPUT_MEDIA_1} and up, in order. The
+// command re-encodes with the concat filter; once the inputs are fetched, the
+// runner probes them and replaces it (see concatArgs), stream-copying with
+// the concat demuxer when they all match.
+func BuildConcatCommand(n int, outputExt string) (*ConversionJob, error) {
+    if outputExt == "" {
+        outputExt = "mp4"
+    }
+    codecs, ext, err := conversionContainer(outputExt)
+    if err != nil {
+        return nil, err
+    }
+    if n < 2 || n > MaxConcatInputs {
+        return nil, fmt.Errorf("between 2 and %d inputs are needed, got %d", MaxConcatInputs, n)
+    }
+    args := []string{"-i", InputMediaPlaceholder}
+    var graph strings.Builder
+    for i := 1; i < n; i++ {
+        args = append(args, "-i", ExtraInputPlaceholder(i))
+    }
+    for i := 0; i < n; i++ {
+        fmt.Fprintf(&graph, "[%d:v:0][%d:a:0]", i, i)
+    }
+    fmt.Fprintf(&graph, "concat=n=%d:v=1:a=1[v][a]", n)
+    args = append(args, "-filter_complex", graph.String(), "-map", "[v]", "-map", "[a]")
+    args = append(args, codecs...)
+    return &ConversionJob{Command: JoinCommand(args), OutputExt: ext, Concat: true}, nil
+}
+
+// concatStream holds what decides whether streams of different inputs can be
+// joined without re-encoding.
+type concatStream struct {
+    CodecType     string `json:"codec_type"`
+    CodecName     string `json:"codec_name"`
+    Profile       string `json:"profile"`
+    Width         int    `json:"width"`
+    Height        int    `json:"height"`
+    PixFmt        string `json:"pix_fmt"`
+    SAR           string `json:"sample_aspect_ratio"`
+    FrameRate     string `json:"r_frame_rate"`
+    TimeBase      string `json:"time_base"`
+    SampleRate    string `json:"sample_rate"`
+    Channels      int    `json:"channels"`
+    ChannelLayout string `json:"channel_layout"`
+}
+
+// concatInput is a probed input of a concat task.
+type concatInput struct {
+    Path     string
+    Streams  []concatStream // Video and audio streams only
+    Duration time.Duration  // 0 if unknown
+}
+
+func (in concatInput) first(codecType string) *concatStream {
+    for i := range in.Streams {
+        if in.Streams[i].CodecType == codecType {
+            return &in.Streams[i]
+        }
+    }
+    return nil
+}
+
+// probeConcatInput reads the streams and duration of an input.
+func (r *Runner) probeConcatInput(ctx context.Context, path string) (concatInput, error) {
+    ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+    defer cancel()
+
+    cmd := exec.CommandContext(ctx, r.cfg.FFProbeBin,
+        "-v", "error",
+        "-show_entries", "stream=codec_type,codec_name,profile,width,height,pix_fmt,sample_aspect_ratio,r_frame_rate,time_base,sample_rate,channels,channel_layout:format=duration",
+        "-of", "json",
+        path,
+    )
+    out, err := cmd.Output()
+    if err != nil {
+        return concatInput{}, fmt.Errorf("ffprobe failed: %w", err)
+    }
+    var probe struct {
+        Streams []concatStream `json:"streams"`
+        Format  struct {
+            Duration string `json:"duration"`
+        } `json:"format"`
+    }
+    if err := json.Unmarshal(out, &probe); err != nil {
+        return concatInput{}, fmt.Errorf("unexpected ffprobe output: %w", err)
+    }
+    in := concatInput{Path: path}
+    for _, s := range probe.Streams {
+        if s.CodecType == "video" || s.CodecType == "audio" {
+            in.Streams = append(in.Streams, s)
+        }
+    }
+    if secs, err := strconv.ParseFloat(probe.Format.Duration, 64); err == nil && secs > 0 {
+        in.Duration = time.Duration(secs * float64(time.Second))
+    }
+    return in, nil
+}
+
+// concatArgs probes the inputs of a concat task and returns the command
+// joining them, with the method it uses and the duration of the result (0 if
+// unknown). The demuxer's list of inputs is written into workDir and handed
+// to id, which ffmpeg runs as.
+func (r *Runner) concatArgs(ctx context.Context, t *task.Task, paths []string, workDir string, id *identity) ([]string, string, time.Duration, error) {
+    codecs, _, err := conversionContainer(t.OutputExt)
+    if err != nil {
+        return nil, "", 0, err
+    }
+    inputs := make([]concatInput, len(paths))
+    var total time.Duration
+    for i, path := range paths {
+        if inputs[i], err = r.probeConcatInput(ctx, path); err != nil {
+            return nil, "", 0, fmt.Errorf("could not probe input %d: %w", i, err)
+        }
+        if len(inputs[i].Streams) == 0 {
+            return nil, "", 0, fmt.Errorf("input %d has no video or audio stream", i)
+        }
+        if total >= 0 && inputs[i].Duration > 0 {
+            total += inputs[i].Duration
+        } else {
+            total = -1 // Unknown if any input's duration is
+        }
+    }
+    if total < 0 {
+        total = 0
+    }
+
+    if concatCompatible(inputs) {
+        list := filepath.Join(workDir, concatListName)
+        if err := os.WriteFile(list, concatList(paths), 0o600); err != nil {
+            return nil, "", 0, err
+        }
+        if err := chownTo(list, id); err != nil {
+            return nil, "", 0, err
+        }
+        return []string{"-f", "concat", "-safe", "0", "-i", list, "-map", "0:v?", "-map", "0:a?", "-c", "copy"}, ConcatMethodDemuxer, total, nil
+    }
+    args, err := concatFilterArgs(inputs)
+    if err != nil {
+        return nil, "", 0, err
+    }
+    return append(args, codecs...), ConcatMethodFilter, total, nil
+}
+
+// concatCompatible tells whether the concat demuxer can join the inputs: they
+// have the same video and audio streams in the same order, with the same
+// codecs and parameters.
+func concatCompatible(inputs []concatInput) bool {
+    for _, in := range inputs[1:] {
+        if len(in.Streams) != len(inputs[0].Streams) {
+            return false
+        }
+        for i, s := range in.Streams {
+            if normalizeConcatStream(s) != normalizeConcatStream(inputs[0].Streams[i]) {
+                return false
+            }
+        }
+    }
+    return true
+}
+
+// normalizeConcatStream treats an unset sample aspect ratio as square pixels.
+func normalizeConcatStream(s concatStream) concatStream {
+    if s.SAR == "" || s.SAR == "0:1" {
+        s.SAR = "1:1"
+    }
+    return s
+}
+
+// concatList is the concat demuxer's list of the inputs, in order.
+func concatList(paths []string) []byte {
+    var b strings.Builder
+    b.WriteString("ffconcat version 1.0\n")
+    for _, path := range paths {
+        b.WriteString("file '" + strings.ReplaceAll(path, "'", `'\''`) + "'\n")
+    }
+    return []byte(b.String())
+}
+
+// concatFilterArgs joins the inputs with the concat filter, which needs the
+// same frame size and rate throughout: every input is scaled into the first
+// video input's frame, padded and set to its frame rate. Inputs without audio
+// contribute silence as long as themselves if others have audio.
+func concatFilterArgs(inputs []concatInput) ([]string, error) {
+    var video, audio *concatStream
+    for _, in := range inputs {
+        if v := in.first("video"); v != nil && video == nil {
+            video = v
+        }
+        if a := in.first("audio"); a != nil && audio == nil {
+            audio = a
+        }
+    }
+
+    if video != nil && (video.Width <= 0 || video.Height <= 0) {
+        return nil, fmt.Errorf("the frame size of the first video input is unknown")
+    }
+    for i, in := range inputs {
+        if video != nil && in.first("video") == nil {
+            return nil, fmt.Errorf("input %d has no video stream; audio-only inputs cannot be joined with videos", i)
+        }
+    }
+
+    var args, graph, segments []string
+    for i, in := range inputs {
+        args = append(args, "-i", in.Path)
+        if video != nil {
+            graph = append(graph, fmt.Sprintf("[%d:v:0]scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=%s,format=yuv420p[v%d]",
+                i, video.Width, video.Height, video.Width, video.Height, concatFrameRate(video.FrameRate), i))
+            segments = append(segments, fmt.Sprintf("[v%d]", i))
+        }
+        if audio != nil {
+            if in.first("audio") != nil {
+                segments = append(segments, fmt.Sprintf("[%d:a:0]", i))
+                continue
+            }
+            if in.Duration <= 0 {
+                return nil, fmt.Errorf("input %d has no audio stream and its duration is unknown, so it cannot be padded with silence", i)
+            }
+            graph = append(graph, fmt.Sprintf("anullsrc=r=48000:cl=stereo,atrim=duration=%.3f[a%d]", in.Duration.Seconds(), i))
+            segments = append(segments, fmt.Sprintf("[a%d]", i))
+        }
+    }
+
+    v, a := 0, 0
+    var outputs, maps []string
+    if video != nil {
+        v, outputs, maps = 1, append(outputs, "[v]"), append(maps, "-map", "[v]")
+    }
+    if audio != nil {
+        a, outputs, maps = 1, append(outputs, "[a]"), append(maps, "-map", "[a]")
+    }
+    graph = append(graph, fmt.Sprintf("%sconcat=n=%d:v=%d:a=%d%s", strings.Join(segments, ""), len(inputs), v, a, strings.Join(outputs, "")))
+    args = append(args, "-filter_complex", strings.Join(graph, ";"))
+    return append(args, maps...), nil
+}
+
+// concatFrameRate is the frame rate the filter path converts to: the first
+// video input's, or 30 if ffprobe did not know it.
+func concatFrameRate(rate string) string {
+    if rate == "" || strings.HasPrefix(rate, "0/") || strings.HasSuffix(rate, "/0") {
+        return "30"
+    }
+    return rate
+}
@@ -0,0 +1,143 @@
+package ffmpeg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"ffwebapi/config"
+	"ffwebapi/task"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildConcatCommand(t *testing.T) {
+	job, err := BuildConcatCommand(3, "")
+	require.NoError(t, err)
+	assert.Equal(t, "mp4", job.OutputExt)
+	assert.True(t, job.Concat)
+	args, err := SplitCommand(job.Command)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-i", InputMediaPlaceholder, "-i", "${INPUT_MEDIA_1}", "-i", "${INPUT_MEDIA_2}",
+		"-filter_complex", "[0:v:0][0:a:0][1:v:0][1:a:0][2:v:0][2:a:0]concat=n=3:v=1:a=1[v][a]", "-map", "[v]", "-map", "[a]"}, args[:12])
+
+	_, err = BuildConcatCommand(1, "mp4")
+	assert.ErrorContains(t, err, "between 2 and 100 inputs")
+	_, err = BuildConcatCommand(MaxConcatInputs+1, "mp4")
+	assert.Error(t, err)
+	_, err = BuildConcatCommand(2, "avi")
+	assert.ErrorContains(t, err, `unsupported output format "avi"`)
+}
+
+func TestConcatCompatible(t *testing.T) {
+	h264 := concatStream{CodecType: "video", CodecName: "h264", Profile: "High", Width: 1920, Height: 1080, PixFmt: "yuv420p", SAR: "1:1", FrameRate: "25/1", TimeBase: "1/12800"}
+	aac := concatStream{CodecType: "audio", CodecName: "aac", Profile: "LC", SampleRate: "48000", Channels: 2, ChannelLayout: "stereo", TimeBase: "1/48000"}
+	unsetSAR := h264
+	unsetSAR.SAR = ""
+	other := h264
+	other.Width, other.Height = 1280, 720
+
+	same := []concatInput{{Streams: []concatStream{h264, aac}}, {Streams: []concatStream{unsetSAR, aac}}}
+	assert.True(t, concatCompatible(same))
+	assert.False(t, concatCompatible([]concatInput{{Streams: []concatStream{h264, aac}}, {Streams: []concatStream{other, aac}}}))
+	assert.False(t, concatCompatible([]concatInput{{Streams: []concatStream{h264, aac}}, {Streams: []concatStream{h264}}}))
+	assert.False(t, concatCompatible([]concatInput{{Streams: []concatStream{h264, aac}}, {Streams: []concatStream{aac, h264}}}))
+}
+
+func TestConcatFilterArgs(t *testing.T) {
+	video := concatStream{CodecType: "video", Width: 1280, Height: 720, FrameRate: "30000/1001"}
+	audio := concatStream{CodecType: "audio"}
+	args, err := concatFilterArgs([]concatInput{
+		{Path: "a.mp4", Streams: []concatStream{video, audio}},
+		{Path: "b.mov", Streams: []concatStream{{CodecType: "video", Width: 1920, Height: 1080, FrameRate: "25/1"}}, Duration: 2500 * time.Millisecond},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-i", "a.mp4", "-i", "b.mov", "-filter_complex",
+		"[0:v:0]scale=1280:720:force_original_aspect_ratio=decrease,pad=1280:720:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=30000/1001,format=yuv420p[v0];" +
+			"[1:v:0]scale=1280:720:force_original_aspect_ratio=decrease,pad=1280:720:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=30000/1001,format=yuv420p[v1];" +
+			"anullsrc=r=48000:cl=stereo,atrim=duration=2.500[a1];" +
+			"[v0][0:a:0][v1][a1]concat=n=2:v=1:a=1[v][a]",
+		"-map", "[v]", "-map", "[a]"}, args)
+
+	// Audio only
+	args, err = concatFilterArgs([]concatInput{{Path: "a.mp3", Streams: []concatStream{audio}}, {Path: "b.wav", Streams: []concatStream{audio}}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-i", "a.mp3", "-i", "b.wav", "-filter_complex", "[0:a:0][1:a:0]concat=n=2:v=0:a=1[a]", "-map", "[a]"}, args)
+
+	_, err = concatFilterArgs([]concatInput{{Streams: []concatStream{video}}, {Streams: []concatStream{audio}}})
+	assert.ErrorContains(t, err, "input 1 has no video stream")
+	_, err = concatFilterArgs([]concatInput{{Streams: []concatStream{video, audio}}, {Streams: []concatStream{video}}})
+	assert.ErrorContains(t, err, "duration is unknown")
+}
+
+func TestConcatList(t *testing.T) {
+	assert.Equal(t, "ffconcat version 1.0\nfile '/tmp/a.mp4'\nfile '/tmp/it'\\''s.mp4'\n", string(concatList([]string{"/tmp/a.mp4", "/tmp/it's.mp4"})))
+}
+
+func TestRunner_Concat(t *testing.T) {
+	dir := t.TempDir()
+	// The fake ffprobe prints the input, which holds its own probe output;
+	// the fake ffmpeg records its arguments.
+	ffprobeBin := filepath.Join(dir, "ffprobe")
+	require.NoError(t, os.WriteFile(ffprobeBin, []byte("#!/bin/sh\nfor f; do :; done\ncat \"$f\"\n"), 0o755))
+	script := "#!/bin/sh\necho \"$@\" > " + filepath.Join(dir, "args") + "\nfor out; do :; done\necho joined > \"$out\"\n"
+	ffmpegBin := filepath.Join(dir, "ffmpeg")
+	writeFakeFFmpeg(t, ffmpegBin, script)
+	input := func(name, probe string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(probe), 0o600))
+		return path
+	}
+	hd := input("hd.mp4", `{"streams": [{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080, "r_frame_rate": "25/1"},
+		{"codec_type": "audio", "codec_name": "aac", "sample_rate": "48000", "channels": 2}], "format": {"duration": "10.0"}}`)
+	hd2 := input("hd2.mp4", `{"streams": [{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080, "r_frame_rate": "25/1"},
+		{"codec_type": "audio", "codec_name": "aac", "sample_rate": "48000", "channels": 2}, {"codec_type": "data"}], "format": {"duration": "5.0"}}`)
+	sd := input("sd.mp4", `{"streams": [{"codec_type": "video", "codec_name": "mpeg2video", "width": 720, "height": 576, "r_frame_rate": "25/1"},
+		{"codec_type": "audio", "codec_name": "mp2", "sample_rate": "48000", "channels": 2}], "format": {"duration": "5.0"}}`)
+
+	cfg := &config.Config{FFBin: ffmpegBin, FFProbeBin: ffprobeBin, MaxConcurrency: 1, MaxInputSize: 1 << 20}
+	r, err := NewRunner(cfg)
+	require.NoError(t, err)
+	defer os.RemoveAll(cfg.TempDir)
+
+	run := func(id string, inputs ...string) (*task.Task, string) {
+		job, err := BuildConcatCommand(len(inputs), "mp4")
+		require.NoError(t, err)
+		tk := &task.Task{ID: id, Command: job.Command, InputMedia: inputs[0], ExtraInputs: inputs[1:], OutputExt: job.OutputExt, Concat: true}
+		_, err = r.Run(context.Background(), tk)
+		require.NoError(t, err)
+		args, err := os.ReadFile(filepath.Join(dir, "args"))
+		require.NoError(t, err)
+		return tk, string(args)
+	}
+
+	// Matching streams are stream-copied; data streams don't count.
+	tk, args := run("same", hd, hd2)
+	assert.Equal(t, ConcatMethodDemuxer, tk.ConcatMethod)
+	assert.True(t, strings.HasPrefix(args, "-f concat -safe 0 -protocol_whitelist file,pipe -i "), args)
+	assert.Contains(t, args, concatListName+" -map 0:v? -map 0:a? -c copy")
+	data, err := os.ReadFile(tk.OutputPath)
+	require.NoError(t, err)
+	assert.Equal(t, "joined\n", string(data))
+
+	tk, args = run("mixed", hd, sd)
+	assert.Equal(t, ConcatMethodFilter, tk.ConcatMethod)
+	assert.Contains(t, args, "[1:v:0]scale=1920:1080:force_original_aspect_ratio=decrease")
+	assert.Contains(t, args, "concat=n=2:v=1:a=1[v][a] -map [v] -map [a] -c:v libx264")
+
+	// The demuxer's list is handed to the uid ffmpeg runs as.
+	if os.Geteuid() == 0 {
+		workDir := t.TempDir()
+		_, method, _, err := r.concatArgs(context.Background(), &task.Task{OutputExt: "mp4"}, []string{hd, hd2}, workDir, &identity{uid: 60000, gid: 60000})
+		require.NoError(t, err)
+		assert.Equal(t, ConcatMethodDemuxer, method)
+		info, err := os.Stat(filepath.Join(workDir, concatListName))
+		require.NoError(t, err)
+		assert.Equal(t, uint32(60000), info.Sys().(*syscall.Stat_t).Uid)
+	}
+}
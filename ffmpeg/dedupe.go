@@ -0,0 +1,80 @@
+package ffmpeg
+
+import (
+    "context"
+    "fmt"
+    "hash"
+    "io"
+    "os"
+    "path/filepath"
+
+    "ffwebapi/logging"
+    "ffwebapi/task"
+)
+
+// hashFile writes the content of a file to digest.
+func hashFile(path string, digest hash.Hash) error {
+    f, err := os.Open(path)
+    if err != nil {
+        return fmt.Errorf("could not open local input file: %w", err)
+    }
+    defer f.Close()
+    if _, err := io.Copy(digest, f); err != nil {
+        return fmt.Errorf("could not read local input file: %w", err)
+    }
+    return nil
+}
+
+// reuseOutput publishes the output of src, an identical task that already
+// completed, as the output of t. The file is hard-linked where possible, so
+// either task's output can expire without affecting the other.
+func (r *Runner) reuseOutput(ctx context.Context, t, src *task.Task) error {
+    filesDir, err := r.filesDir(t)
+    if err != nil {
+        return err
+    }
+    outputPath := filepath.Join(filesDir, fmt.Sprintf("%s.%s", task.ArtifactOutput, t.OutputExt))
+    if err := linkOrCopy(src.OutputPath, outputPath); err != nil {
+        return fmt.Errorf("could not reuse the output of task %s: %w", src.ID, err)
+    }
+    logging.FromContext(ctx).Info("Reusing the output of an identical task", "deduped_from", src.ID)
+
+    kind := t.ArtifactKind
+    if kind == "" {
+        kind = task.ArtifactOutput
+    }
+    t.Update(func() {
+        t.AddArtifact(task.ArtifactOutput, kind, outputPath)
+        t.OutputPath = outputPath
+        t.DedupedFrom = src.ID
+        t.Percent, t.ETA = 100, 0
+        t.Warnings, t.QCReport, t.CodecSubstitutions, t.MediaDuration = src.Warnings, src.QCReport, src.CodecSubstitutions, src.MediaDuration
+    })
+    return nil
+}
+
+// linkOrCopy hard-links src to dst, or copies it through a temporary file
+// if they are on different file systems.
+func linkOrCopy(src, dst string) error {
+    if err := os.Link(src, dst); err == nil {
+        return nil
+    }
+    in, err := os.Open(src)
+    if err != nil {
+        return err
+    }
+    defer in.Close()
+    tmp, err := os.CreateTemp(filepath.Dir(dst), ".tmp_*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    if _, err := io.Copy(tmp, in); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), dst)
+}
@@ -0,0 +1,83 @@
+package ffmpeg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ffwebapi/config"
+	"ffwebapi/task"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunner_DedupeTasks(t *testing.T) {
+	dir := t.TempDir()
+	runs := filepath.Join(dir, "runs")
+	// Copies the input to the output and counts its runs.
+	bin := filepath.Join(dir, "ffmpeg")
+	writeFakeFFmpeg(t, bin, "#!/bin/sh\necho run >> "+runs+"\nfor out; do :; done\ncat \"$4\" > \"$out\"\n")
+	inputs := map[string]string{"a.raw": "media", "b.raw": "media", "c.raw": "other"}
+	for name, content := range inputs {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+
+	cfg := &config.Config{FFBin: bin, FFProbeBin: filepath.Join(dir, "ffprobe"), MaxConcurrency: 1, MaxInputSize: 1 << 20,
+		LogBufferSize: 1024, FFTimeout: time.Minute, DedupeTasks: true}
+	r, err := NewRunner(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(cfg.TempDir) })
+	mgr, err := task.NewManager(cfg, r)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mgr.Start(ctx)
+
+	runAs := func(input string, opts task.SubmitOptions) *task.Task {
+		tk, err := mgr.SubmitWithOptions("-i ${INPUT_MEDIA} -c copy", filepath.Join(dir, input), "mkv", opts)
+		require.NoError(t, err)
+		select {
+		case <-tk.Done():
+		case <-time.After(10 * time.Second):
+			t.Fatal("task did not finish")
+		}
+		require.Equal(t, task.StatusCompleted, tk.Status, tk.Error)
+		return tk
+	}
+	run := func(input string) *task.Task { return runAs(input, task.SubmitOptions{}) }
+	first := run("a.raw")
+	assert.Empty(t, first.DedupedFrom)
+	assert.Len(t, first.InputDigest, 64)
+
+	// Same content at another path: the output is reused.
+	second := run("b.raw")
+	assert.Equal(t, first.ID, second.DedupedFrom)
+	assert.Equal(t, first.InputDigest, second.InputDigest)
+	assert.NotEqual(t, first.OutputPath, second.OutputPath)
+	data, err := os.ReadFile(second.OutputPath)
+	require.NoError(t, err)
+	assert.Equal(t, "media", string(data))
+
+	assert.Empty(t, run("c.raw").DedupedFrom)
+
+	// Options changing the arguments make another output, and other owners'
+	// tasks are never reused.
+	labeled := runAs("b.raw", task.SubmitOptions{StreamLabels: []task.StreamLabel{{Stream: "a:0", Language: "eng"}}})
+	assert.Empty(t, labeled.DedupedFrom)
+	assert.Empty(t, runAs("b.raw", task.SubmitOptions{SkipStreamLabels: true}).DedupedFrom)
+	bob := runAs("b.raw", task.SubmitOptions{Owner: "bob"})
+	assert.Empty(t, bob.DedupedFrom)
+	assert.Equal(t, bob.ID, runAs("a.raw", task.SubmitOptions{Owner: "bob"}).DedupedFrom)
+
+	// Once the output is gone the task runs again.
+	require.NoError(t, os.Remove(first.OutputPath))
+	assert.Empty(t, run("a.raw").DedupedFrom)
+
+	count, err := os.ReadFile(runs)
+	require.NoError(t, err)
+	assert.Equal(t, 6, strings.Count(string(count), "run"))
+}
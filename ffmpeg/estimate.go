@@ -0,0 +1,130 @@
+package ffmpeg
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// maxSaneBitrate is the highest bitrate (bits/s) we accept for a single stream.
+// Anything above this is almost certainly a typo (e.g. "5000M" instead of "5000k").
+const maxSaneBitrate = 1_000_000_000
+
+// OutputEstimate is a rough, pre-encode guess of what a command will produce.
+type OutputEstimate struct {
+    VideoBitrate int64         // bits per second, 0 if not set in the command
+    AudioBitrate int64         // bits per second, 0 if not set in the command
+    Duration     time.Duration // 0 if the command does not bound the duration
+    Size         int64         // estimated output size in bytes, 0 if unknown
+}
+
+// EstimateOutput inspects the split arguments for target bitrates and duration
+// and computes an estimated output size. It returns an error when a bitrate is
+// malformed or outside sane bounds.
+func EstimateOutput(args []string) (*OutputEstimate, error) {
+    est := &OutputEstimate{}
+    var sizeLimit int64
+
+    for i := 0; i < len(args)-1; i++ {
+        opt, val := args[i], args[i+1]
+        switch opt {
+        case "-b:v", "-vb", "-b":
+            br, err := parseBitrate(val)
+            if err != nil {
+                return nil, err
+            }
+            est.VideoBitrate = br
+        case "-b:a", "-ab":
+            br, err := parseBitrate(val)
+            if err != nil {
+                return nil, err
+            }
+            est.AudioBitrate = br
+        case "-t":
+            d, err := parseDuration(val)
+            if err != nil {
+                return nil, fmt.Errorf("invalid duration %q: %w", val, err)
+            }
+            est.Duration = d
+        case "-fs":
+            n, err := parseBitrate(val)
+            if err != nil {
+                return nil, fmt.Errorf("invalid file size limit %q", val)
+            }
+            sizeLimit = n
+        default:
+            continue
+        }
+        i++ // Skip the consumed value
+    }
+
+    if est.Duration > 0 && (est.VideoBitrate > 0 || est.AudioBitrate > 0) {
+        totalBits := float64(est.VideoBitrate+est.AudioBitrate) * est.Duration.Seconds()
+        est.Size = int64(totalBits / 8)
+    }
+    if sizeLimit > 0 && (est.Size == 0 || est.Size > sizeLimit) {
+        // -fs stops the encode once the limit is reached, so it bounds the output.
+        est.Size = sizeLimit
+    }
+    return est, nil
+}
+
+// parseBitrate parses ffmpeg-style numbers with optional SI suffixes (e.g. "128k", "2.5M").
+func parseBitrate(s string) (int64, error) {
+    v := strings.TrimSpace(s)
+    mult := 1.0
+    if n := len(v); n > 0 {
+        switch v[n-1] {
+        case 'k', 'K':
+            mult, v = 1e3, v[:n-1]
+        case 'M':
+            mult, v = 1e6, v[:n-1]
+        case 'G':
+            mult, v = 1e9, v[:n-1]
+        }
+    }
+    f, err := strconv.ParseFloat(v, 64)
+    if err != nil {
+        return 0, fmt.Errorf("invalid bitrate %q", s)
+    }
+    br := int64(f * mult)
+    if br <= 0 || br > maxSaneBitrate {
+        return 0, fmt.Errorf("bitrate %q is outside the sane range (1 to %d bits/s)", s, int64(maxSaneBitrate))
+    }
+    return br, nil
+}
+
+// parseDuration parses ffmpeg time duration syntax: [-][HH:]MM:SS[.m...] or S+[.m...][s|ms|us].
+func parseDuration(s string) (time.Duration, error) {
+    if strings.Contains(s, ":") {
+        parts := strings.Split(s, ":")
+        if len(parts) > 3 {
+            return 0, fmt.Errorf("too many fields")
+        }
+        var total float64
+        for _, p := range parts {
+            f, err := strconv.ParseFloat(p, 64)
+            if err != nil {
+                return 0, err
+            }
+            total = total*60 + f
+        }
+        return time.Duration(total * float64(time.Second)), nil
+    }
+
+    unit := time.Second
+    switch {
+    case strings.HasSuffix(s, "ms"):
+        unit, s = time.Millisecond, strings.TrimSuffix(s, "ms")
+    case strings.HasSuffix(s, "us"):
+        unit, s = time.Microsecond, strings.TrimSuffix(s, "us")
+    case strings.HasSuffix(s, "s"):
+        s = strings.TrimSuffix(s, "s")
+    }
+    f, err := strconv.ParseFloat(s, 64)
+    if err != nil {
+        return 0, err
+    }
+    return time.Duration(f * float64(unit)), nil
+}
@@ -0,0 +1,45 @@
+package ffmpeg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateOutput(t *testing.T) {
+	t.Run("Bitrate and duration", func(t *testing.T) {
+		args, _ := SplitCommand(`-i ${INPUT_MEDIA} -b:v 2M -b:a 128k -t 00:01:00`)
+		est, err := EstimateOutput(args)
+		assert.NoError(t, err)
+		assert.Equal(t, time.Minute, est.Duration)
+		assert.Equal(t, int64((2_000_000+128_000)*60/8), est.Size)
+	})
+
+	t.Run("Unknown duration", func(t *testing.T) {
+		args, _ := SplitCommand(`-i ${INPUT_MEDIA} -b:v 2M`)
+		est, err := EstimateOutput(args)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), est.Size)
+	})
+
+	t.Run("File size limit bounds the estimate", func(t *testing.T) {
+		args, _ := SplitCommand(`-i ${INPUT_MEDIA} -b:v 8M -t 3600 -fs 10M`)
+		est, err := EstimateOutput(args)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(10_000_000), est.Size)
+	})
+
+	t.Run("Insane bitrate", func(t *testing.T) {
+		args, _ := SplitCommand(`-i ${INPUT_MEDIA} -b:v 5000G`)
+		_, err := EstimateOutput(args)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "outside the sane range")
+	})
+
+	t.Run("Malformed bitrate", func(t *testing.T) {
+		args, _ := SplitCommand(`-i ${INPUT_MEDIA} -b:a loud`)
+		_, err := EstimateOutput(args)
+		assert.Error(t, err)
+	})
+}
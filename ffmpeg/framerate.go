@@ -0,0 +1,214 @@
+package ffmpeg
+
+import (
+    "fmt"
+    "math"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+// Frame rate conversion methods.
+const (
+    FrameRateMethodFPS         = "fps"         // Drop or repeat frames; duration and audio are unchanged
+    FrameRateMethodInterpolate = "interpolate" // Synthesize frames by motion interpolation; slow
+    FrameRateMethodSpeed       = "speed"       // Keep every frame and play faster or slower, e.g. 23.976 to 25 for PAL
+)
+
+// ntscRates maps the decimal spellings of the NTSC family to their exact rates.
+var ntscRates = map[string]FrameRate{
+    "23.976": {24000, 1001},
+    "23.98":  {24000, 1001},
+    "29.97":  {30000, 1001},
+    "47.952": {48000, 1001},
+    "59.94":  {60000, 1001},
+    "119.88": {120000, 1001},
+}
+
+// FrameRate is an exact frame rate, e.g. 30000/1001 for NTSC's 29.97.
+type FrameRate struct {
+    Num, Den int
+}
+
+// ParseFrameRate parses "25", "30000/1001" or an NTSC decimal like "29.97".
+// Other fractional decimals are rejected: they only approximate a rate.
+func ParseFrameRate(s string) (FrameRate, error) {
+    if r, ok := ntscRates[s]; ok {
+        return r, nil
+    }
+    num, den, isFraction := strings.Cut(s, "/")
+    r := FrameRate{Den: 1}
+    var err error
+    if r.Num, err = strconv.Atoi(num); err != nil {
+        return r, fmt.Errorf("invalid frame rate %q (want e.g. \"25\", \"30000/1001\" or \"29.97\")", s)
+    }
+    if isFraction {
+        if r.Den, err = strconv.Atoi(den); err != nil || r.Den <= 0 {
+            return r, fmt.Errorf("invalid frame rate %q (want e.g. \"25\", \"30000/1001\" or \"29.97\")", s)
+        }
+    }
+    if fps := r.fps(); r.Num <= 0 || fps < 1 || fps > 240 {
+        return r, fmt.Errorf("frame rate %q must be between 1 and 240 fps", s)
+    }
+    return r, nil
+}
+
+func (r FrameRate) String() string {
+    if r.Den == 1 {
+        return strconv.Itoa(r.Num)
+    }
+    return fmt.Sprintf("%d/%d", r.Num, r.Den)
+}
+
+func (r FrameRate) fps() float64 {
+    return float64(r.Num) / float64(r.Den)
+}
+
+// dropFrame reports whether SMPTE drop-frame timecode applies to the rate.
+func (r FrameRate) dropFrame() bool {
+    return r == FrameRate{30000, 1001} || r == FrameRate{60000, 1001}
+}
+
+// timecodeRe matches SMPTE timecode; ";" (or ".") before the frames marks drop-frame.
+var timecodeRe = regexp.MustCompile(`^(\d{2}):(\d{2}):(\d{2})([:;.])(\d{2})$`)
+
+// ValidateTimecode checks a start timecode such as "01:00:00:00", or
+// "01:00:00;02" in drop-frame, against the frame rate it counts.
+func ValidateTimecode(tc string, rate FrameRate) error {
+    m := timecodeRe.FindStringSubmatch(tc)
+    if m == nil {
+        return fmt.Errorf("invalid timecode %q (want HH:MM:SS:FF, or HH:MM:SS;FF for drop-frame)", tc)
+    }
+    hours, _ := strconv.Atoi(m[1])
+    minutes, _ := strconv.Atoi(m[2])
+    secs, _ := strconv.Atoi(m[3])
+    frames, _ := strconv.Atoi(m[5])
+    if hours > 23 || minutes > 59 || secs > 59 {
+        return fmt.Errorf("invalid timecode %q", tc)
+    }
+    if perSecond := int(math.Ceil(rate.fps())); frames >= perSecond {
+        return fmt.Errorf("timecode %q has frame %d, but %s fps counts frames 00 to %02d", tc, frames, rate, perSecond-1)
+    }
+    if m[4] == ":" {
+        return nil
+    }
+    if !rate.dropFrame() {
+        return fmt.Errorf("drop-frame timecode %q needs 29.97 or 59.94 fps, not %s", tc, rate)
+    }
+    // Drop-frame skips the first frame numbers of every minute but each tenth.
+    dropped := 2 * int(math.Round(rate.fps()/30))
+    if secs == 0 && minutes%10 != 0 && frames < dropped {
+        return fmt.Errorf("timecode %q does not exist in drop-frame: frames 00 to %02d of minute %02d are skipped", tc, dropped-1, minutes)
+    }
+    return nil
+}
+
+// FrameRateSpec describes a frame rate conversion.
+type FrameRateSpec struct {
+    Rate       FrameRate
+    Method     string    // FrameRateMethodFPS (default), FrameRateMethodInterpolate or FrameRateMethodSpeed
+    SourceRate FrameRate // The input's rate; required for FrameRateMethodSpeed
+    Timecode   string    // Start timecode of the output; the input's is kept if empty, so set it if its frames don't fit the new rate
+    OutputExt  string    // mov (default), mp4 or mkv
+}
+
+// ConversionJob is a ready-to-submit command writing one output file.
+type ConversionJob struct {
+    Command   string
+    OutputExt string
+    Concat    bool // Joins its inputs; the runner picks the method once it probed them
+}
+
+// conversionCodecs are the codecs conversions encode to per container:
+// near-lossless H.264 and audio fit for further editing.
+var conversionCodecs = map[string][]string{
+    "mov": {"-c:v", "libx264", "-preset", "medium", "-crf", "16", "-pix_fmt", "yuv420p", "-c:a", "pcm_s24le"},
+    "mp4": {"-c:v", "libx264", "-preset", "medium", "-crf", "16", "-pix_fmt", "yuv420p", "-c:a", "aac", "-b:a", "256k"},
+    "mkv": {"-c:v", "libx264", "-preset", "medium", "-crf", "16", "-pix_fmt", "yuv420p", "-c:a", "flac"},
+}
+
+// BuildFrameRateCommand builds an ffmpeg command converting the input's first
+// video stream to an exact frame rate, keeping its audio streams. The fps
+// filter (rather than the -r output option) decides which frames are kept,
+// so timestamps stay exact and the output is constant frame rate.
+func BuildFrameRateCommand(spec FrameRateSpec) (*ConversionJob, error) {
+    codecs, ext, err := conversionContainer(spec.OutputExt)
+    if err != nil {
+        return nil, err
+    }
+    if spec.Timecode != "" {
+        if err := ValidateTimecode(spec.Timecode, spec.Rate); err != nil {
+            return nil, err
+        }
+    }
+
+    rate := spec.Rate.String()
+    var videoFilter, audioFilter string
+    switch spec.Method {
+    case "", FrameRateMethodFPS:
+        videoFilter = "fps=" + rate
+    case FrameRateMethodInterpolate:
+        videoFilter = "minterpolate=fps=" + rate + ":mi_mode=mci:mc_mode=aobmc:me_mode=bidir:vsbmc=1"
+    case FrameRateMethodSpeed:
+        if spec.SourceRate.Num == 0 {
+            return nil, fmt.Errorf("method %q needs the source frame rate", FrameRateMethodSpeed)
+        }
+        speed := spec.Rate.fps() / spec.SourceRate.fps()
+        if speed < 0.5 || speed > 2 {
+            return nil, fmt.Errorf("method %q supports speed changes between 0.5x and 2x, not %.3fx", FrameRateMethodSpeed, speed)
+        }
+        // PTS are scaled by source/target so every frame lands on the new grid.
+        videoFilter = fmt.Sprintf("setpts=PTS*%d*%d/(%d*%d),fps=%s", spec.SourceRate.Num, spec.Rate.Den, spec.SourceRate.Den, spec.Rate.Num, rate)
+        audioFilter = "atempo=" + strconv.FormatFloat(speed, 'f', 6, 64)
+    default:
+        return nil, fmt.Errorf("unknown method %q (want %q, %q or %q)", spec.Method, FrameRateMethodFPS, FrameRateMethodInterpolate, FrameRateMethodSpeed)
+    }
+
+    args := []string{"-i", InputMediaPlaceholder, "-map", "0:v:0", "-map", "0:a?", "-vf", videoFilter}
+    if audioFilter != "" {
+        args = append(args, "-af", audioFilter)
+    }
+    args = append(args, codecs...)
+    args = append(args, timecodeArgs(spec.Timecode, ext)...)
+    return &ConversionJob{Command: JoinCommand(args), OutputExt: ext}, nil
+}
+
+// BuildTimecodeCommand builds an ffmpeg command that copies every stream of
+// the input and sets its start timecode, counted at the input's frame rate.
+func BuildTimecodeCommand(timecode string, rate FrameRate, outputExt string) (*ConversionJob, error) {
+    _, ext, err := conversionContainer(outputExt)
+    if err != nil {
+        return nil, err
+    }
+    if err := ValidateTimecode(timecode, rate); err != nil {
+        return nil, err
+    }
+    args := []string{"-i", InputMediaPlaceholder, "-map", "0", "-c", "copy"}
+    args = append(args, timecodeArgs(timecode, ext)...)
+    return &ConversionJob{Command: JoinCommand(args), OutputExt: ext}, nil
+}
+
+func conversionContainer(ext string) ([]string, string, error) {
+    if ext == "" {
+        ext = "mov"
+    }
+    codecs, ok := conversionCodecs[ext]
+    if !ok {
+        return nil, "", fmt.Errorf("unsupported output format %q (want mov, mp4 or mkv)", ext)
+    }
+    return codecs, ext, nil
+}
+
+// timecodeArgs sets the start timecode, if any. MOV and MP4 get a timecode
+// track (tmcd) either way, so a timecode carried over from the input keeps
+// one too; MP4 only writes it when asked.
+func timecodeArgs(timecode, ext string) []string {
+    var args []string
+    if timecode != "" {
+        args = append(args, "-timecode", timecode)
+    }
+    if ext == "mp4" {
+        args = append(args, "-write_tmcd", "on")
+    }
+    return args
+}
@@ -0,0 +1,86 @@
+package ffmpeg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFrameRate(t *testing.T) {
+	for s, want := range map[string]FrameRate{
+		"25":         {25, 1},
+		"29.97":      {30000, 1001},
+		"23.976":     {24000, 1001},
+		"30000/1001": {30000, 1001},
+		"50/1":       {50, 1},
+	} {
+		r, err := ParseFrameRate(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, want, r, s)
+	}
+	for _, s := range []string{"", "29.9", "25.5", "0", "-25", "1/0", "300", "abc"} {
+		_, err := ParseFrameRate(s)
+		assert.Error(t, err, s)
+	}
+	assert.Equal(t, "30000/1001", FrameRate{30000, 1001}.String())
+	assert.Equal(t, "25", FrameRate{25, 1}.String())
+}
+
+func TestValidateTimecode(t *testing.T) {
+	pal, ntsc, ntsc60 := FrameRate{25, 1}, FrameRate{30000, 1001}, FrameRate{60000, 1001}
+	assert.NoError(t, ValidateTimecode("10:00:00:24", pal))
+	assert.NoError(t, ValidateTimecode("01:00:00:29", ntsc))
+	assert.NoError(t, ValidateTimecode("01:00:00;00", ntsc)) // Each tenth minute keeps its first frames
+	assert.NoError(t, ValidateTimecode("01:01:00;02", ntsc))
+	assert.NoError(t, ValidateTimecode("01:01:00;04", ntsc60))
+
+	assert.ErrorContains(t, ValidateTimecode("10:00:00:25", pal), "frames 00 to 24")
+	assert.ErrorContains(t, ValidateTimecode("01:00:00;00", pal), "needs 29.97 or 59.94")
+	assert.ErrorContains(t, ValidateTimecode("01:01:00;01", ntsc), "does not exist in drop-frame")
+	assert.ErrorContains(t, ValidateTimecode("01:01:00;03", ntsc60), "does not exist in drop-frame")
+	for _, tc := range []string{"1:00:00:00", "24:00:00:00", "01:60:00:00", "01:00:00", "01:00:00,00"} {
+		assert.Error(t, ValidateTimecode(tc, pal), tc)
+	}
+}
+
+func TestBuildFrameRateCommand(t *testing.T) {
+	job, err := BuildFrameRateCommand(FrameRateSpec{Rate: FrameRate{30000, 1001}})
+	require.NoError(t, err)
+	assert.Equal(t, "mov", job.OutputExt)
+	args, err := SplitCommand(job.Command)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-i", InputMediaPlaceholder, "-map", "0:v:0", "-map", "0:a?", "-vf", "fps=30000/1001",
+		"-c:v", "libx264", "-preset", "medium", "-crf", "16", "-pix_fmt", "yuv420p", "-c:a", "pcm_s24le"}, args)
+
+	job, err = BuildFrameRateCommand(FrameRateSpec{Rate: FrameRate{50, 1}, Method: FrameRateMethodInterpolate, OutputExt: "mp4", Timecode: "00:59:50:00"})
+	require.NoError(t, err)
+	assert.Contains(t, job.Command, "-vf minterpolate=fps=50:")
+	assert.Contains(t, job.Command, "-timecode 00:59:50:00 -write_tmcd on")
+
+	// PAL speed-up: 23.976 fps played at 25 fps, audio 4.3% faster.
+	job, err = BuildFrameRateCommand(FrameRateSpec{Rate: FrameRate{25, 1}, Method: FrameRateMethodSpeed, SourceRate: FrameRate{24000, 1001}, OutputExt: "mkv"})
+	require.NoError(t, err)
+	assert.Contains(t, job.Command, "-vf setpts=PTS*24000*1/(1001*25),fps=25 -af atempo=1.042708")
+
+	for _, spec := range []FrameRateSpec{
+		{Rate: FrameRate{25, 1}, Method: FrameRateMethodSpeed},                               // No source rate
+		{Rate: FrameRate{60, 1}, Method: FrameRateMethodSpeed, SourceRate: FrameRate{24, 1}}, // 2.5x
+		{Rate: FrameRate{25, 1}, Method: "blend"},                                            // Unknown method
+		{Rate: FrameRate{25, 1}, OutputExt: "avi"},                                           // Unsupported container
+		{Rate: FrameRate{25, 1}, Timecode: "01:00:00;00"},                                    // Drop-frame at 25 fps
+	} {
+		_, err := BuildFrameRateCommand(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestBuildTimecodeCommand(t *testing.T) {
+	job, err := BuildTimecodeCommand("01:00:00:00", FrameRate{25, 1}, "")
+	require.NoError(t, err)
+	assert.Equal(t, "-i ${INPUT_MEDIA} -map 0 -c copy -timecode 01:00:00:00", job.Command)
+	assert.Equal(t, "mov", job.OutputExt)
+
+	_, err = BuildTimecodeCommand("01:00:00:30", FrameRate{25, 1}, "")
+	assert.Error(t, err)
+}
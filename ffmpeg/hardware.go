@@ -0,0 +1,188 @@
+package ffmpeg
+
+import (
+    "fmt"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "runtime"
+    "sort"
+    "strings"
+
+    "ffwebapi/config"
+    "ffwebapi/task"
+)
+
+// Hardware a node may have, as named in HW_PROFILES.
+const (
+    HardwareNVIDIA       = "nvidia"
+    HardwareVAAPI        = "vaapi"
+    HardwareVideoToolbox = "videotoolbox"
+)
+
+// hardwareKinds are the known kinds of hardware, most preferred first: the
+// first one detected that has a profile is applied.
+var hardwareKinds = []string{HardwareNVIDIA, HardwareVAAPI, HardwareVideoToolbox}
+
+// HWDevicePlaceholder is replaced in the args of a hardware profile by the
+// device detected, e.g. /dev/dri/renderD128 for VA-API.
+const HWDevicePlaceholder = "${HW_DEVICE}"
+
+// hwProfile is what HW_PROFILES applies to the ffmpeg commands of a node
+// with some kind of hardware.
+type hwProfile struct {
+    kind     string
+    args     []string          // Global args placed before the command's
+    encoders map[string]string // Replacements of software encoders
+    device   string            // Replaces HWDevicePlaceholder; detected if empty
+}
+
+// detectedHardware is hardware found at startup.
+type detectedHardware struct {
+    kind   string
+    device string // Device node, if there is one
+}
+
+// parseHWProfile parses a HW_PROFILES entry such as
+// "args:-hwaccel,cuda encoders:libx264=h264_nvenc,libx265=hevc_nvenc".
+func parseHWProfile(kind, spec string) (hwProfile, error) {
+    p := hwProfile{kind: kind, encoders: make(map[string]string)}
+    for _, field := range strings.Fields(spec) {
+        key, value, _ := strings.Cut(field, ":")
+        switch key {
+        case "args":
+            p.args = append(p.args, strings.Split(value, ",")...)
+        case "encoders":
+            for _, pair := range strings.Split(value, ",") {
+                from, to, ok := strings.Cut(pair, "=")
+                if !ok || from == "" || to == "" {
+                    return p, fmt.Errorf("invalid encoder replacement %q (want <encoder>=<encoder>)", pair)
+                }
+                p.encoders[from] = to
+            }
+        case "device":
+            p.device = value
+        default:
+            return p, fmt.Errorf("unknown setting %q (want args:, encoders: or device:)", field)
+        }
+    }
+    for _, arg := range p.args {
+        if arg == "" {
+            return p, fmt.Errorf("empty argument in args:")
+        }
+    }
+    return p, nil
+}
+
+// hwProfiles parses HW_PROFILES by kind of hardware.
+func hwProfiles(cfg *config.Config) (map[string]hwProfile, error) {
+    profiles := make(map[string]hwProfile, len(cfg.HWProfiles))
+    for kind, spec := range cfg.HWProfiles {
+        kind = strings.ToLower(kind)
+        if !contains(hardwareKinds, kind) {
+            return nil, fmt.Errorf("HW_PROFILES: unknown hardware %q (want one of %s)", kind, strings.Join(hardwareKinds, ", "))
+        }
+        p, err := parseHWProfile(kind, spec)
+        if err != nil {
+            return nil, fmt.Errorf("HW_PROFILES: %s: %w", kind, err)
+        }
+        profiles[kind] = p
+    }
+    return profiles, nil
+}
+
+// detectHardware looks for hardware ffmpeg can use. It is a variable so
+// tests can fake a node's hardware.
+var detectHardware = func() []detectedHardware {
+    var found []detectedHardware
+    for _, path := range []string{"/dev/nvidiactl", "/proc/driver/nvidia/version"} {
+        if _, err := os.Stat(path); err == nil {
+            found = append(found, detectedHardware{kind: HardwareNVIDIA})
+            break
+        }
+    }
+    if devices, _ := filepath.Glob("/dev/dri/renderD*"); len(devices) > 0 {
+        sort.Strings(devices)
+        found = append(found, detectedHardware{kind: HardwareVAAPI, device: devices[0]})
+    }
+    if runtime.GOOS == "darwin" {
+        found = append(found, detectedHardware{kind: HardwareVideoToolbox})
+    }
+    return found
+}
+
+// selectHWProfile returns the profile of the most preferred hardware
+// detected, with its device filled in, or nil if none applies.
+func selectHWProfile(profiles map[string]hwProfile, detected []detectedHardware) *hwProfile {
+    for _, kind := range hardwareKinds {
+        p, ok := profiles[kind]
+        if !ok {
+            continue
+        }
+        for _, hw := range detected {
+            if hw.kind != kind {
+                continue
+            }
+            if p.device == "" {
+                p.device = hw.device
+            }
+            args := make([]string, len(p.args))
+            for i, arg := range p.args {
+                args[i] = strings.ReplaceAll(arg, HWDevicePlaceholder, p.device)
+            }
+            p.args = args
+            return &p
+        }
+    }
+    return nil
+}
+
+// apply places the profile's global args before args and replaces the
+// encoders it names, unless the ffmpeg build is known to lack the
+// replacement.
+func (p *hwProfile) apply(args []string, available map[string]bool) ([]string, []task.CodecSubstitution) {
+    var subs []task.CodecSubstitution
+    out := make([]string, 0, len(p.args)+len(args))
+    out = append(out, p.args...)
+    out = append(out, args...)
+    for i := len(p.args) + 1; i < len(out); i++ {
+        if !isCodecFlag(out[i-1]) {
+            continue
+        }
+        if to, ok := p.encoders[out[i]]; ok && (available == nil || available[to]) {
+            subs = append(subs, task.CodecSubstitution{Requested: out[i], Used: to})
+            out[i] = to
+        }
+    }
+    return out, subs
+}
+
+// setupHardware detects the node's hardware and picks the HW_PROFILES entry
+// to apply.
+func (r *Runner) setupHardware() error {
+    profiles, err := hwProfiles(r.cfg)
+    if err != nil {
+        return err
+    }
+    detected := detectHardware()
+    for _, hw := range detected {
+        r.hardware = append(r.hardware, hw.kind)
+    }
+    r.hwProfile = selectHWProfile(profiles, detected)
+    switch {
+    case r.hwProfile != nil:
+        slog.Info("Applying hardware profile", "hardware", r.hardware, "profile", r.hwProfile.kind, "device", r.hwProfile.device)
+    case len(r.hardware) > 0:
+        slog.Info("Hardware detected without a profile in HW_PROFILES", "hardware", r.hardware)
+    }
+    return nil
+}
+
+// Hardware reports the hardware detected at startup and the kind whose
+// profile is applied, if any.
+func (r *Runner) Hardware() (detected []string, profile string) {
+    if r.hwProfile != nil {
+        profile = r.hwProfile.kind
+    }
+    return r.hardware, profile
+}
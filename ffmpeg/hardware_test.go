@@ -0,0 +1,90 @@
+package ffmpeg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ffwebapi/config"
+	"ffwebapi/task"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHWProfile(t *testing.T) {
+	p, err := parseHWProfile("vaapi", "args:-vaapi_device,${HW_DEVICE} encoders:libx264=h264_vaapi,libx265=hevc_vaapi")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-vaapi_device", "${HW_DEVICE}"}, p.args)
+	assert.Equal(t, map[string]string{"libx264": "h264_vaapi", "libx265": "hevc_vaapi"}, p.encoders)
+
+	for _, spec := range []string{"encoders:libx264", "args:-hwaccel,,cuda", "hwaccel:cuda"} {
+		_, err := parseHWProfile("nvidia", spec)
+		assert.Error(t, err, spec)
+	}
+	_, err = hwProfiles(&config.Config{HWProfiles: map[string]string{"tpu": "args:-hwaccel,tpu"}})
+	assert.ErrorContains(t, err, `unknown hardware "tpu"`)
+}
+
+func TestSelectHWProfile(t *testing.T) {
+	profiles, err := hwProfiles(&config.Config{HWProfiles: map[string]string{
+		"vaapi":  "args:-vaapi_device,${HW_DEVICE}",
+		"nvidia": "args:-hwaccel,cuda",
+	}})
+	require.NoError(t, err)
+
+	assert.Nil(t, selectHWProfile(profiles, nil))
+	assert.Nil(t, selectHWProfile(profiles, []detectedHardware{{kind: HardwareVideoToolbox}}))
+	p := selectHWProfile(profiles, []detectedHardware{{kind: HardwareVAAPI, device: "/dev/dri/renderD129"}})
+	require.NotNil(t, p)
+	assert.Equal(t, []string{"-vaapi_device", "/dev/dri/renderD129"}, p.args)
+	p = selectHWProfile(profiles, []detectedHardware{{kind: HardwareVAAPI, device: "/dev/dri/renderD129"}, {kind: HardwareNVIDIA}})
+	require.NotNil(t, p)
+	assert.Equal(t, HardwareNVIDIA, p.kind, "NVIDIA is preferred")
+}
+
+func TestHWProfileApply(t *testing.T) {
+	p, err := parseHWProfile("nvidia", "args:-hwaccel,cuda encoders:libx264=h264_nvenc,libx265=hevc_nvenc")
+	require.NoError(t, err)
+	args := []string{"-i", "in.mp4", "-c:v", "libx265", "-c:a", "aac"}
+
+	out, subs := p.apply(args, nil)
+	assert.Equal(t, []string{"-hwaccel", "cuda", "-i", "in.mp4", "-c:v", "hevc_nvenc", "-c:a", "aac"}, out)
+	assert.Equal(t, []task.CodecSubstitution{{Requested: "libx265", Used: "hevc_nvenc"}}, subs)
+
+	// Builds known to lack the hardware encoder keep the software one.
+	out, subs = p.apply(args, map[string]bool{"libx265": true, "h264_nvenc": true})
+	assert.Equal(t, "libx265", out[5])
+	assert.Empty(t, subs)
+}
+
+func TestRunner_HWProfile(t *testing.T) {
+	detect := detectHardware
+	detectHardware = func() []detectedHardware {
+		return []detectedHardware{{kind: HardwareVAAPI, device: "/dev/dri/renderD128"}}
+	}
+	t.Cleanup(func() { detectHardware = detect })
+
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "ffmpeg")
+	writeFakeFFmpeg(t, bin, "#!/bin/sh\necho \"$@\"\nfor out; do :; done\ntouch \"$out\"\n")
+	input := filepath.Join(dir, "input.mp4")
+	require.NoError(t, os.WriteFile(input, []byte("media"), 0o600))
+	cfg := &config.Config{FFBin: bin, FFProbeBin: filepath.Join(dir, "ffprobe"), MaxInputSize: 1 << 20, LogBufferSize: 1024,
+		HWProfiles: map[string]string{"vaapi": "args:-vaapi_device,${HW_DEVICE} encoders:libx264=h264_vaapi"}}
+	r, err := NewRunner(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(cfg.TempDir) })
+	detected, profile := r.Hardware()
+	assert.Equal(t, []string{HardwareVAAPI}, detected)
+	assert.Equal(t, HardwareVAAPI, profile)
+
+	tk := &task.Task{ID: "hw", Command: "-i ${INPUT_MEDIA} -c:v libx264", InputMedia: input, OutputExt: "mp4"}
+	output, err := r.Run(context.Background(), tk)
+	require.NoError(t, err)
+	assert.Contains(t, output, "-vaapi_device /dev/dri/renderD128 -protocol_whitelist file,pipe -i ")
+	assert.Contains(t, output, "-c:v h264_vaapi")
+	assert.Equal(t, HardwareVAAPI, tk.HWProfile)
+	assert.Equal(t, []task.CodecSubstitution{{Requested: "libx264", Used: "h264_vaapi"}}, tk.CodecSubstitutions)
+}
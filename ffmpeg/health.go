@@ -0,0 +1,98 @@
+package ffmpeg
+
+import (
+    "fmt"
+    "log/slog"
+    "os/exec"
+    "strings"
+    "sync"
+    "time"
+
+    "ffwebapi/config"
+    "github.com/shirou/gopsutil/v3/cpu"
+    "github.com/shirou/gopsutil/v3/disk"
+    "github.com/shirou/gopsutil/v3/mem"
+)
+
+// ResourceUsage is a snapshot of the host resources that decide whether a
+// task may start (THROTTLE_*).
+type ResourceUsage struct {
+    CPUPercent      float64 `json:"cpuPercent"`
+    MemoryAvailable uint64  `json:"memoryAvailable"` // Bytes
+    DiskFree        uint64  `json:"diskFree"`        // Bytes free in TEMP_DIR
+
+    cpuKnown, memKnown, diskKnown bool // Figures that could not be read are not checked
+}
+
+// SampleResources measures the host's CPU usage over interval (since the
+// previous sample if 0), its available memory and the free disk space in
+// TEMP_DIR. Failures to read a figure are logged and leave it unchecked.
+func SampleResources(cfg *config.Config, interval time.Duration) ResourceUsage {
+    var u ResourceUsage
+    if p, err := cpu.Percent(interval, false); err != nil {
+        slog.Warn("Could not get CPU usage", "error", err)
+    } else if len(p) > 0 {
+        u.CPUPercent, u.cpuKnown = p[0], true
+    }
+    if vm, err := mem.VirtualMemory(); err != nil {
+        slog.Warn("Could not get memory usage", "error", err)
+    } else {
+        u.MemoryAvailable, u.memKnown = vm.Available, true
+    }
+    if d, err := disk.Usage(cfg.TempDir); err != nil {
+        slog.Warn("Could not get disk usage", "dir", cfg.TempDir, "error", err)
+    } else {
+        u.DiskFree, u.diskKnown = d.Free, true
+    }
+    return u
+}
+
+// Check reports which THROTTLE_* threshold the usage violates, if any.
+func (u ResourceUsage) Check(cfg *config.Config) error {
+    if u.cpuKnown && u.CPUPercent > (100.0-cfg.ThrottleCPU) {
+        return fmt.Errorf("not enough idle CPU. Current usage: %.2f%%, Idle threshold: %.2f%%", u.CPUPercent, cfg.ThrottleCPU)
+    }
+    if u.memKnown && u.MemoryAvailable < uint64(cfg.ThrottleFreeMem) {
+        return fmt.Errorf("not enough free memory. Available: %d, Required: %d", u.MemoryAvailable, cfg.ThrottleFreeMem)
+    }
+    return u.CheckDisk(cfg)
+}
+
+// CheckDisk reports whether TEMP_DIR has less free space than THROTTLE_FREE_DISK.
+func (u ResourceUsage) CheckDisk(cfg *config.Config) error {
+    if u.diskKnown && u.DiskFree < uint64(cfg.ThrottleFreeDisk) {
+        return fmt.Errorf("not enough free disk space. Available: %d, Required: %d", u.DiskFree, cfg.ThrottleFreeDisk)
+    }
+    return nil
+}
+
+// versions caches the version of each ffmpeg binary; it does not change
+// while the server runs.
+var versions sync.Map
+
+// Version returns the version of the configured ffmpeg binary, e.g. "6.1.1",
+// or "" if it cannot be run.
+func Version(cfg *config.Config) string {
+    if v, ok := versions.Load(cfg.FFBin); ok {
+        return v.(string)
+    }
+    out, err := exec.Command(cfg.FFBin, "-version").Output()
+    if err != nil {
+        return "" // Not cached, so it shows up once the binary works
+    }
+    v := parseVersion(string(out))
+    versions.Store(cfg.FFBin, v)
+    return v
+}
+
+// parseVersion extracts the version from the output of "ffmpeg -version",
+// whose first line reads e.g. "ffmpeg version 6.1.1 Copyright (c) ...".
+func parseVersion(out string) string {
+    line, _, _ := strings.Cut(out, "\n")
+    _, rest, found := strings.Cut(line, " version ")
+    if !found {
+        return ""
+    }
+    version, _, _ := strings.Cut(rest, " ")
+    return version
+}
@@ -0,0 +1,33 @@
+package ffmpeg
+
+import (
+	"testing"
+
+	"ffwebapi/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVersion(t *testing.T) {
+	assert.Equal(t, "6.1.1", parseVersion("ffmpeg version 6.1.1 Copyright (c) 2000-2023 the FFmpeg developers\nbuilt with gcc 13\n"))
+	assert.Equal(t, "n7.0-12-gabcdef", parseVersion("ffmpeg version n7.0-12-gabcdef Copyright (c) 2000-2024"))
+	assert.Empty(t, parseVersion("sh: ffmpeg: not found"))
+}
+
+func TestResourceUsageCheck(t *testing.T) {
+	cfg := &config.Config{ThrottleCPU: 10, ThrottleFreeMem: 1024, ThrottleFreeDisk: 4096}
+	ok := ResourceUsage{CPUPercent: 50, MemoryAvailable: 2048, DiskFree: 8192, cpuKnown: true, memKnown: true, diskKnown: true}
+	assert.NoError(t, ok.Check(cfg))
+
+	busy := ok
+	busy.CPUPercent = 95
+	assert.ErrorContains(t, busy.Check(cfg), "not enough idle CPU")
+	assert.NoError(t, busy.CheckDisk(cfg))
+
+	full := ok
+	full.DiskFree = 100
+	assert.ErrorContains(t, full.Check(cfg), "not enough free disk space")
+
+	// Figures that could not be read are not held against the host.
+	assert.NoError(t, ResourceUsage{}.Check(cfg))
+}
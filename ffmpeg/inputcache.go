@@ -0,0 +1,235 @@
+package ffmpeg
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+)
+
+// errNotCacheable is set on downloads whose response has no ETag, so their
+// content cannot be revalidated and is not kept.
+var errNotCacheable = errors.New("response has no ETag")
+
+// inputCache keeps downloaded inputs in TempDir/input_cache, so tasks
+// reading the same URL, e.g. one master file transcoded to several formats,
+// download it once. Concurrent tasks share one download; later ones
+// revalidate the cached copy with If-None-Match. Entries in use are never
+// evicted; the least recently used others go once the cache exceeds
+// INPUT_CACHE_MAX_SIZE.
+type inputCache struct {
+    dir     string
+    maxSize int64
+    mu      sync.Mutex
+    entries map[string]*cachedInput // By URL
+    size    int64                   // Of the entries downloaded
+}
+
+// cachedInput is the content of a URL as of one ETag.
+type cachedInput struct {
+    url      string
+    etag     string
+    path     string
+    size     int64
+    refs     int           // Tasks downloading or copying it
+    lastUsed time.Time
+    ready    chan struct{} // Closed once the download ended
+    err      error         // Why the download failed; set before ready is closed
+    removed  bool          // No longer in entries; the file goes with the last reference
+}
+
+func newInputCache(tempDir string, maxSize int64) (*inputCache, error) {
+    dir := filepath.Join(tempDir, "input_cache")
+    if err := os.MkdirAll(dir, 0o700); err != nil {
+        return nil, fmt.Errorf("could not create input cache directory: %w", err)
+    }
+    return &inputCache{dir: dir, maxSize: maxSize, entries: make(map[string]*cachedInput)}, nil
+}
+
+// fetch writes the content of url to dst, from the cache if it is still
+// current and through it otherwise.
+func (c *inputCache) fetch(ctx context.Context, client *http.Client, url string, dst io.Writer, maxSize int64) error {
+    c.mu.Lock()
+    e, cached := c.entries[url]
+    if !cached {
+        e = &cachedInput{url: url, ready: make(chan struct{})}
+        c.entries[url] = e
+    }
+    e.refs++
+    c.mu.Unlock()
+    defer c.release(e)
+    if !cached {
+        return c.download(ctx, client, e, dst, maxSize)
+    }
+
+    // A download that just ended needs no revalidation.
+    fresh := false
+    select {
+    case <-e.ready:
+    default:
+        select {
+        case <-e.ready:
+            fresh = true
+        case <-ctx.Done():
+            return ctx.Err()
+        }
+    }
+    if e.err != nil {
+        _, _, err := getInput(ctx, client, url, "", dst, maxSize)
+        return err
+    }
+    if !fresh {
+        _, notModified, err := getInput(ctx, client, url, e.etag, dst, maxSize)
+        if err != nil || !notModified {
+            // Changed: dst has the new content, which the next task caches.
+            c.remove(e)
+            return err
+        }
+    }
+    f, err := os.Open(e.path)
+    if err != nil {
+        return fmt.Errorf("could not open cached input: %w", err)
+    }
+    defer f.Close()
+    if _, err := io.Copy(dst, f); err != nil {
+        return fmt.Errorf("failed to copy cached input: %w", err)
+    }
+    return nil
+}
+
+// download fetches url into a new entry and dst at once.
+func (c *inputCache) download(ctx context.Context, client *http.Client, e *cachedInput, dst io.Writer, maxSize int64) error {
+    var path, etag string
+    var size int64
+    f, err := os.CreateTemp(c.dir, ".tmp_*")
+    if err == nil {
+        counter := &countingWriter{w: f}
+        etag, _, err = getInput(ctx, client, e.url, "", io.MultiWriter(dst, counter), maxSize)
+        if closeErr := f.Close(); err == nil && closeErr != nil {
+            err = fmt.Errorf("failed to write cached input: %w", closeErr)
+        }
+        if err == nil && etag == "" {
+            err = errNotCacheable
+        }
+        if err == nil {
+            sum := sha256.Sum256([]byte(e.url + "\x00" + etag))
+            path, size = filepath.Join(c.dir, hex.EncodeToString(sum[:16])), counter.n
+            err = os.Rename(f.Name(), path)
+        }
+        if err != nil {
+            os.Remove(f.Name())
+        }
+    }
+
+    c.mu.Lock()
+    e.err = err
+    if err == nil {
+        e.path, e.etag, e.size = path, etag, size
+        c.size += size
+    } else {
+        c.removeLocked(e)
+    }
+    close(e.ready)
+    c.mu.Unlock()
+    if errors.Is(err, errNotCacheable) {
+        slog.Debug("Input not cached", "reason", err)
+        return nil // dst got the whole content
+    }
+    return err
+}
+
+// release drops a reference to an entry and evicts entries beyond the size
+// limit.
+func (c *inputCache) release(e *cachedInput) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    e.refs--
+    e.lastUsed = time.Now()
+    if e.removed && e.refs == 0 && e.path != "" {
+        os.Remove(e.path)
+    }
+    for c.size > c.maxSize {
+        var oldest *cachedInput
+        for _, cand := range c.entries {
+            if cand.refs == 0 && cand.err == nil && cand.path != "" && (oldest == nil || cand.lastUsed.Before(oldest.lastUsed)) {
+                oldest = cand
+            }
+        }
+        if oldest == nil {
+            return
+        }
+        c.removeLocked(oldest)
+        os.Remove(oldest.path)
+    }
+}
+
+// remove drops an entry whose content changed.
+func (c *inputCache) remove(e *cachedInput) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.removeLocked(e)
+}
+
+func (c *inputCache) removeLocked(e *cachedInput) {
+    if e.removed {
+        return
+    }
+    e.removed = true
+    if c.entries[e.url] == e {
+        delete(c.entries, e.url)
+    }
+    c.size -= e.size
+}
+
+// getInput downloads url to dst, keeping to maxSize. With etag set, an
+// unchanged resource is answered with 304 and reported as notModified,
+// leaving dst untouched. It returns the ETag of the content written.
+func getInput(ctx context.Context, client *http.Client, url, etag string, dst io.Writer, maxSize int64) (newETag string, notModified bool, err error) {
+    req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+    if etag != "" {
+        req.Header.Set("If-None-Match", etag)
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return "", false, err
+    }
+    defer resp.Body.Close()
+
+    if etag != "" && resp.StatusCode == http.StatusNotModified {
+        return etag, true, nil
+    }
+    if resp.StatusCode != http.StatusOK {
+        return "", false, fmt.Errorf("failed to download file, status: %s", resp.Status)
+    }
+
+    // Use a LimitedReader to enforce max input size
+    limitedReader := &io.LimitedReader{R: resp.Body, N: maxSize + 1}
+    written, err := io.Copy(dst, limitedReader)
+    if err != nil {
+        return "", false, fmt.Errorf("failed to write downloaded file: %w", err)
+    }
+    if written > maxSize {
+        return "", false, fmt.Errorf("input file size exceeds limit of %d bytes", maxSize)
+    }
+    return resp.Header.Get("ETag"), false, nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+    w io.Writer
+    n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+    n, err := cw.w.Write(p)
+    cw.n += int64(n)
+    return n, err
+}
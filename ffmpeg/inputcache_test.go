@@ -0,0 +1,103 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInputCache(t *testing.T) {
+	var mu sync.Mutex
+	content := map[string]string{"/master.mov": "master", "/other.mov": "other!", "/plain.mov": "plain"}
+	etags := map[string]string{"/master.mov": `"v1"`, "/other.mov": `"o1"`}
+	var downloads, revalidations atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		body, etag := content[r.URL.Path], etags[r.URL.Path]
+		mu.Unlock()
+		if etag != "" && r.Header.Get("If-None-Match") == etag {
+			revalidations.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads.Add(1)
+		if r.URL.Query().Has("slow") {
+			<-release
+		}
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	cache, err := newInputCache(t.TempDir(), 10)
+	require.NoError(t, err)
+	fetch := func(path string) string {
+		var buf bytes.Buffer
+		require.NoError(t, cache.fetch(context.Background(), srv.Client(), srv.URL+path, &buf, 1<<20))
+		return buf.String()
+	}
+
+	t.Run("concurrent tasks share one download", func(t *testing.T) {
+		var wg sync.WaitGroup
+		results := make([]string, 5)
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i] = fetch("/master.mov?slow")
+			}(i)
+		}
+		// The others queue up behind the first request.
+		require.Eventually(t, func() bool { return downloads.Load() > 0 }, 5*time.Second, time.Millisecond)
+		close(release)
+		wg.Wait()
+		assert.Equal(t, []string{"master", "master", "master", "master", "master"}, results)
+		assert.Equal(t, int32(1), downloads.Load())
+	})
+
+	downloads.Store(0)
+	t.Run("later tasks revalidate", func(t *testing.T) {
+		assert.Equal(t, "master", fetch("/master.mov"))
+		assert.Equal(t, "master", fetch("/master.mov"))
+		assert.Equal(t, int32(1), downloads.Load())
+		assert.Equal(t, int32(1), revalidations.Load())
+
+		mu.Lock()
+		content["/master.mov"], etags["/master.mov"] = "master v2", `"v2"`
+		mu.Unlock()
+		assert.Equal(t, "master v2", fetch("/master.mov"))
+		assert.Equal(t, "master v2", fetch("/master.mov"))
+		assert.Equal(t, int32(3), downloads.Load(), "the changed content is downloaded and cached again")
+	})
+
+	t.Run("responses without an ETag are not cached", func(t *testing.T) {
+		downloads.Store(0)
+		assert.Equal(t, "plain", fetch("/plain.mov"))
+		assert.Equal(t, "plain", fetch("/plain.mov"))
+		assert.Equal(t, int32(2), downloads.Load())
+	})
+
+	t.Run("least recently used inputs are evicted", func(t *testing.T) {
+		fetch("/other.mov")
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		assert.LessOrEqual(t, cache.size, int64(10))
+		assert.Contains(t, cache.entries, srv.URL+"/other.mov")
+		assert.NotContains(t, cache.entries, srv.URL+"/master.mov")
+		files, err := os.ReadDir(cache.dir)
+		require.NoError(t, err)
+		assert.Len(t, files, 1)
+	})
+}
@@ -0,0 +1,219 @@
+package ffmpeg
+
+import (
+    "context"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "io"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+
+    "ffwebapi/config"
+    "ffwebapi/netguard"
+    "ffwebapi/task"
+)
+
+// InputProvider fetches the inputs of one scheme, e.g. "s3" for
+// s3://bucket/key. The runner copies every input into the task's working
+// directory through a provider before ffmpeg starts.
+type InputProvider interface {
+    // Fetch writes the input to dst. It fails once more than maxSize bytes
+    // arrive, without writing them all.
+    Fetch(ctx context.Context, inputMedia string, dst io.Writer, maxSize int64) error
+}
+
+// InputChecker is optionally implemented by providers to reject inputs at
+// submission, e.g. from buckets they may not read.
+type InputChecker interface {
+    CheckInput(inputMedia string) error
+}
+
+var (
+    inputProvidersMu sync.RWMutex
+    inputProviders   = make(map[string]InputProvider) // Registered providers by scheme
+)
+
+// RegisterInputProvider makes inputs of scheme available to tasks, e.g.
+// RegisterInputProvider("sftp", p) for sftp://host/path. Providers are
+// registered before the server starts. They take precedence over the
+// built-in ones (http, https, input, data and local paths), so replacing
+// those also replaces their egress and size checks.
+func RegisterInputProvider(scheme string, p InputProvider) {
+    inputProvidersMu.Lock()
+    defer inputProvidersMu.Unlock()
+    inputProviders[strings.ToLower(scheme)] = p
+}
+
+// LookupInputProvider returns the registered provider of a scheme; the
+// built-in ones are not included.
+func LookupInputProvider(scheme string) (InputProvider, bool) {
+    inputProvidersMu.RLock()
+    defer inputProvidersMu.RUnlock()
+    p, ok := inputProviders[strings.ToLower(scheme)]
+    return p, ok
+}
+
+// CheckRegisteredInput checks an input at submission if a registered
+// provider serves its scheme, using the provider's InputChecker if any. ok
+// is false for inputs of the built-in schemes, which the caller checks.
+func CheckRegisteredInput(inputMedia string) (ok bool, err error) {
+    p, ok := LookupInputProvider(InputScheme(inputMedia))
+    if !ok {
+        return false, nil
+    }
+    if checker, ok := p.(InputChecker); ok {
+        return true, checker.CheckInput(inputMedia)
+    }
+    return true, nil
+}
+
+// InputScheme returns the lowercase scheme of an input: "data" for data URIs,
+// the part before "://" for URLs and "" for local paths.
+func InputScheme(inputMedia string) string {
+    if strings.HasPrefix(inputMedia, "data:") {
+        return "data"
+    }
+    if scheme, _, ok := strings.Cut(inputMedia, "://"); ok && netguard.IsURL(inputMedia) {
+        return strings.ToLower(scheme)
+    }
+    return ""
+}
+
+// inputProvider returns the provider of an input's scheme: a registered one
+// or a built-in one.
+func (r *Runner) inputProvider(scheme string) (InputProvider, error) {
+    if p, ok := LookupInputProvider(scheme); ok {
+        return p, nil
+    }
+    switch scheme {
+    case "http", "https":
+        return urlInputs{r}, nil
+    case strings.TrimSuffix(task.InputRefPrefix, "://"):
+        return uploadedInputs{r}, nil
+    case "data":
+        return dataURIInputs{}, nil
+    case "":
+        return localInputs{}, nil
+    }
+    return nil, fmt.Errorf("no input provider for scheme %q", scheme)
+}
+
+// urlInputs downloads http(s) inputs, through the input cache if enabled.
+type urlInputs struct{ r *Runner }
+
+func (p urlInputs) Fetch(ctx context.Context, inputMedia string, dst io.Writer, maxSize int64) error {
+    // Checked at submission, but the policy may have changed since.
+    if err := netguard.InputPolicy(p.r.cfg).CheckURL(inputMedia); err != nil {
+        return err
+    }
+    client := netguard.InputPolicy(p.r.cfg).Client(0)
+    defer client.CloseIdleConnections()
+    if p.r.inputCache != nil {
+        return p.r.inputCache.fetch(ctx, client, inputMedia, dst, maxSize)
+    }
+    _, _, err := getInput(ctx, client, inputMedia, "", dst, maxSize)
+    return err
+}
+
+// uploadedInputs reads inputs uploaded to the input storage (input://<id>).
+type uploadedInputs struct{ r *Runner }
+
+func (p uploadedInputs) Fetch(ctx context.Context, inputMedia string, dst io.Writer, maxSize int64) error {
+    inputID, _ := task.InputRefID(inputMedia)
+    src, err := p.r.inputs.Open(ctx, inputID)
+    if err != nil {
+        return fmt.Errorf("could not open uploaded input: %w", err)
+    }
+    defer src.Close()
+    if err := copyLimited(dst, src, maxSize); err != nil {
+        return fmt.Errorf("failed to copy uploaded input: %w", err)
+    }
+    return nil
+}
+
+// dataURIInputs decodes inputs embedded as data URIs (RFC 2397), e.g.
+// "data:text/vtt;base64,V0VCVlRU...".
+type dataURIInputs struct{}
+
+func (dataURIInputs) Fetch(ctx context.Context, inputMedia string, dst io.Writer, maxSize int64) error {
+    header, data, ok := strings.Cut(strings.TrimPrefix(inputMedia, "data:"), ",")
+    if !ok {
+        return errors.New("invalid data URI: missing ','")
+    }
+    var src io.Reader
+    if strings.HasSuffix(header, ";base64") {
+        src = base64.NewDecoder(base64.StdEncoding, strings.NewReader(data))
+    } else {
+        decoded, err := url.PathUnescape(data)
+        if err != nil {
+            return fmt.Errorf("invalid data URI: %w", err)
+        }
+        src = strings.NewReader(decoded)
+    }
+    if err := copyLimited(dst, src, maxSize); err != nil {
+        return fmt.Errorf("invalid data URI: %w", err)
+    }
+    return nil
+}
+
+// localInputs copies local files. Those in ZERO_COPY_INPUT_DIRS never get
+// here; prepareInput has ffmpeg read them in place.
+type localInputs struct{}
+
+func (localInputs) Fetch(ctx context.Context, inputMedia string, dst io.Writer, maxSize int64) error {
+    src, err := os.Open(inputMedia)
+    if err != nil {
+        return fmt.Errorf("could not open local input file: %w", err)
+    }
+    defer src.Close()
+    info, err := src.Stat()
+    if err != nil {
+        return err
+    }
+    if info.Size() > maxSize {
+        return fmt.Errorf("input file size %d exceeds limit of %d bytes", info.Size(), maxSize)
+    }
+    if _, err := io.Copy(dst, src); err != nil {
+        return fmt.Errorf("failed to copy local file: %w", err)
+    }
+    return nil
+}
+
+// CheckLocalInput checks a local-path input of a caller without the admin
+// scope, which may only read below LOCAL_INPUT_DIRS and ZERO_COPY_INPUT_DIRS
+// rather than anything the server's user can. Symlinks are resolved first, so
+// none leads out of them. Missing files are rejected like files elsewhere,
+// which tells callers nothing about what exists on the server.
+func CheckLocalInput(cfg *config.Config, input string) error {
+    denied := fmt.Errorf("local input %s is not below LOCAL_INPUT_DIRS", input)
+    abs, err := filepath.Abs(input)
+    if err != nil {
+        return denied
+    }
+    path, err := filepath.EvalSymlinks(abs)
+    if err != nil {
+        return denied
+    }
+    for _, dir := range resolveDirs(append(append([]string{}, cfg.LocalInputDirs...), cfg.ZeroCopyInputDirs...)) {
+        if isWithin(dir, path) {
+            return nil
+        }
+    }
+    return denied
+}
+
+// copyLimited copies src to dst, failing once more than maxSize bytes arrive.
+func copyLimited(dst io.Writer, src io.Reader, maxSize int64) error {
+    written, err := io.Copy(dst, io.LimitReader(src, maxSize+1))
+    if err != nil {
+        return err
+    }
+    if written > maxSize {
+        return fmt.Errorf("input file size exceeds limit of %d bytes", maxSize)
+    }
+    return nil
+}
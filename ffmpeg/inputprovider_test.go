@@ -0,0 +1,106 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"ffwebapi/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryInputs serves inputs of the "mem" scheme from a map, and rejects
+// names starting with "private" at submission.
+type memoryInputs map[string]string
+
+func (p memoryInputs) Fetch(ctx context.Context, inputMedia string, dst io.Writer, maxSize int64) error {
+	data, ok := p[strings.TrimPrefix(inputMedia, "mem://")]
+	if !ok {
+		return errors.New("no such input")
+	}
+	return copyLimited(dst, strings.NewReader(data), maxSize)
+}
+
+func (p memoryInputs) CheckInput(inputMedia string) error {
+	if strings.HasPrefix(inputMedia, "mem://private") {
+		return errors.New("private input")
+	}
+	return nil
+}
+
+func TestInputScheme(t *testing.T) {
+	for input, want := range map[string]string{
+		"https://example.com/in.mp4": "https",
+		"HTTP://example.com/in.mp4":  "http",
+		"input://in_123":             "input",
+		"s3://media/in.mp4":          "s3",
+		"data:text/plain,hi":         "data",
+		"/srv/media/in.mp4":          "",
+		`C:\media\in.mp4`:            "",
+	} {
+		assert.Equal(t, want, InputScheme(input), input)
+	}
+}
+
+func TestRegisterInputProvider(t *testing.T) {
+	RegisterInputProvider("MEM", memoryInputs{"clip": "0123456789"})
+	t.Cleanup(func() {
+		inputProvidersMu.Lock()
+		delete(inputProviders, "mem")
+		inputProvidersMu.Unlock()
+	})
+
+	_, ok := LookupInputProvider("mem")
+	assert.True(t, ok)
+	_, ok = LookupInputProvider("https") // Built-in providers are not registered
+	assert.False(t, ok)
+
+	ok, err := CheckRegisteredInput("mem://clip")
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	ok, err = CheckRegisteredInput("mem://private/clip")
+	assert.True(t, ok)
+	assert.EqualError(t, err, "private input")
+	ok, _ = CheckRegisteredInput("https://example.com/in.mp4")
+	assert.False(t, ok)
+
+	dir := t.TempDir()
+	r := &Runner{cfg: &config.Config{MaxInputSize: 100}}
+	path, cleanup, err := r.prepareInput(context.Background(), "mem://clip", dir, nil, nil)
+	require.NoError(t, err)
+	defer cleanup()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data))
+
+	r.cfg.MaxInputSize = 5
+	_, _, err = r.prepareInput(context.Background(), "mem://clip", dir, nil, nil)
+	assert.ErrorContains(t, err, "exceeds limit of 5 bytes")
+	_, _, err = r.prepareInput(context.Background(), "sftp://host/in.mp4", dir, nil, nil)
+	assert.EqualError(t, err, `no input provider for scheme "sftp"`)
+}
+
+func TestPrepareInput_DataURI(t *testing.T) {
+	dir := t.TempDir()
+	r := &Runner{cfg: &config.Config{MaxInputSize: 100}}
+	for input, want := range map[string]string{
+		"data:text/vtt;base64,V0VCVlRU": "WEBVTT",
+		"data:,hello%20world":           "hello world",
+	} {
+		path, cleanup, err := r.prepareInput(context.Background(), input, dir, nil, nil)
+		require.NoError(t, err, input)
+		data, _ := os.ReadFile(path)
+		assert.Equal(t, want, string(data))
+		cleanup()
+	}
+
+	_, _, err := r.prepareInput(context.Background(), "data:text/plain;base64", dir, nil, nil)
+	assert.ErrorContains(t, err, "invalid data URI")
+	_, _, err = r.prepareInput(context.Background(), "data:;base64,"+strings.Repeat("QUFB", 50), dir, nil, nil)
+	assert.ErrorContains(t, err, "exceeds limit")
+}
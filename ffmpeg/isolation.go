@@ -0,0 +1,206 @@
+package ffmpeg
+
+import (
+    "bytes"
+    "crypto/rand"
+    "fmt"
+    "io/fs"
+    "log/slog"
+    "os"
+    "os/exec"
+    "os/user"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+
+    "ffwebapi/config"
+)
+
+// Isolation levels reported by the startup self-check.
+const (
+    IsolationNone = "none" // ffmpeg runs as the server user and can read everything it can
+    IsolationUser = "user" // ffmpeg runs as a dedicated user shared by all tasks
+    IsolationTask = "task" // every task runs as its own user and can't read sibling tasks
+)
+
+// identity is the uid/gid an ffmpeg child process runs as.
+type identity struct {
+    uid, gid uint32
+}
+
+// isolation hands out the identities ffmpeg children run as and prepares
+// per-task working directories owned by them.
+type isolation struct {
+    base   *identity // RUN_AS_USER; nil when children run as the server user
+    pool   []uint32  // RUN_AS_UID_RANGE; one uid per running task when set
+    mu     sync.Mutex
+    leased map[uint32]bool
+    level  string
+}
+
+func newIsolation(cfg *config.Config) (*isolation, error) {
+    iso := &isolation{leased: make(map[uint32]bool), level: IsolationNone}
+    if cfg.RunAsUser == "" {
+        return iso, nil
+    }
+
+    u, err := user.Lookup(cfg.RunAsUser)
+    if err != nil {
+        return nil, fmt.Errorf("runtime user %q: %w", cfg.RunAsUser, err)
+    }
+    uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+    gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+    iso.base = &identity{uid: uint32(uid), gid: uint32(gid)}
+    iso.level = IsolationUser
+
+    if cfg.RunAsUIDRange != "" {
+        lo, hi, ok := strings.Cut(cfg.RunAsUIDRange, "-")
+        first, err1 := strconv.ParseUint(strings.TrimSpace(lo), 10, 32)
+        last, err2 := strconv.ParseUint(strings.TrimSpace(hi), 10, 32)
+        if !ok || err1 != nil || err2 != nil || first > last {
+            return nil, fmt.Errorf("invalid RUN_AS_UID_RANGE %q (want e.g. 60000-60999)", cfg.RunAsUIDRange)
+        }
+        for id := first; id <= last; id++ {
+            iso.pool = append(iso.pool, uint32(id))
+        }
+        iso.level = IsolationTask
+    }
+    return iso, nil
+}
+
+// lease returns the identity for a new task and a function to release it.
+// A nil identity means the child runs as the server user.
+func (iso *isolation) lease() (*identity, func(), error) {
+    if len(iso.pool) == 0 {
+        return iso.base, func() {}, nil
+    }
+
+    iso.mu.Lock()
+    defer iso.mu.Unlock()
+    for _, uid := range iso.pool {
+        if !iso.leased[uid] {
+            iso.leased[uid] = true
+            id := &identity{uid: uid, gid: iso.base.gid}
+            return id, func() {
+                iso.mu.Lock()
+                delete(iso.leased, uid)
+                iso.mu.Unlock()
+            }, nil
+        }
+    }
+    return nil, nil, fmt.Errorf("all %d uids of RUN_AS_UID_RANGE are in use", len(iso.pool))
+}
+
+// makeWorkDir creates a 0700 directory for one task, owned by the task's identity.
+func makeWorkDir(parent, name string, id *identity) (string, error) {
+    dir := filepath.Join(parent, name)
+    if err := os.Mkdir(dir, 0o700); err != nil {
+        return "", fmt.Errorf("could not create work directory: %w", err)
+    }
+    if err := chownTo(dir, id); err != nil {
+        os.RemoveAll(dir)
+        return "", err
+    }
+    return dir, nil
+}
+
+// chownTo hands a path over to the given identity. A nil identity is a no-op.
+func chownTo(path string, id *identity) error {
+    if id == nil {
+        return nil
+    }
+    if err := os.Chown(path, int(id.uid), int(id.gid)); err != nil {
+        return fmt.Errorf("could not hand %s to uid %d: %w", path, id.uid, err)
+    }
+    return nil
+}
+
+// reclaim takes a file or directory tree produced by the child back for the
+// server user and makes it private again, so other tasks can't read it once
+// it is published.
+func reclaim(path string, id *identity) error {
+    if id == nil {
+        return nil
+    }
+    return filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        if err := os.Chown(p, os.Getuid(), os.Getgid()); err != nil {
+            return err
+        }
+        mode := os.FileMode(0o600)
+        if d.IsDir() {
+            mode = 0o700
+        }
+        return os.Chmod(p, mode)
+    })
+}
+
+// selfCheck verifies the effective isolation level by letting one task
+// identity try to read a file in a sibling task's directory.
+func (r *Runner) selfCheck() string {
+    level := r.isolation.level
+    if level == IsolationNone {
+        slog.Warn("Isolation self-check: ffmpeg runs as the server user; set RUN_AS_USER to harden", "level", level)
+        return level
+    }
+
+    owner, releaseOwner, err := r.isolation.lease()
+    if err != nil {
+        slog.Warn("Isolation self-check skipped", "error", err)
+        return level
+    }
+    defer releaseOwner()
+    intruder, releaseIntruder, err := r.isolation.lease()
+    if err != nil {
+        slog.Warn("Isolation self-check skipped", "error", err)
+        return level
+    }
+    defer releaseIntruder()
+
+    ownerDir, err := makeWorkDir(r.workRoot, "selfcheck_owner", owner)
+    if err != nil {
+        slog.Error("Isolation self-check failed", "error", err)
+        return level
+    }
+    defer os.RemoveAll(ownerDir)
+    intruderDir, err := makeWorkDir(r.workRoot, "selfcheck_intruder", intruder)
+    if err != nil {
+        slog.Error("Isolation self-check failed", "error", err)
+        return level
+    }
+    defer os.RemoveAll(intruderDir)
+
+    secret := filepath.Join(ownerDir, "secret.bin")
+    junk := make([]byte, 64)
+    rand.Read(junk)
+    if err := os.WriteFile(secret, junk, 0o600); err != nil || chownTo(secret, owner) != nil {
+        slog.Error("Isolation self-check failed: could not write probe file")
+        return level
+    }
+
+    // ffmpeg reports "Permission denied" if it can't open the file and
+    // "Invalid data" if it could read our random bytes.
+    cmd := exec.Command(r.cfg.FFBin, "-v", "error", "-i", secret)
+    cmd.Dir = intruderDir
+    var out bytes.Buffer
+    cmd.Stdout, cmd.Stderr = &out, &out
+    setCredential(cmd, intruder)
+    cmd.Run()
+
+    if strings.Contains(out.String(), "Permission denied") {
+        level = IsolationTask
+        slog.Info("Isolation self-check: sibling task directories are unreadable", "level", level)
+    } else {
+        level = IsolationUser
+        slog.Warn("Isolation self-check: ffmpeg runs as a dedicated user but can read sibling task directories; set RUN_AS_UID_RANGE for per-task isolation", "level", level)
+    }
+    return level
+}
+
+// IsolationLevel reports the effective isolation level found at startup.
+func (r *Runner) IsolationLevel() string {
+    return r.isolationLevel
+}
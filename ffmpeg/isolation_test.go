@@ -0,0 +1,48 @@
+package ffmpeg
+
+import (
+	"testing"
+
+	"ffwebapi/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsolationLease(t *testing.T) {
+	t.Run("No runtime user", func(t *testing.T) {
+		iso, err := newIsolation(&config.Config{})
+		require.NoError(t, err)
+		assert.Equal(t, IsolationNone, iso.level)
+
+		id, release, err := iso.lease()
+		require.NoError(t, err)
+		assert.Nil(t, id)
+		release()
+	})
+
+	t.Run("Per-task uids", func(t *testing.T) {
+		iso, err := newIsolation(&config.Config{RunAsUser: "root", RunAsUIDRange: "60000-60001"})
+		require.NoError(t, err)
+		assert.Equal(t, IsolationTask, iso.level)
+
+		first, releaseFirst, err := iso.lease()
+		require.NoError(t, err)
+		second, _, err := iso.lease()
+		require.NoError(t, err)
+		assert.NotEqual(t, first.uid, second.uid)
+
+		_, _, err = iso.lease()
+		assert.Error(t, err, "pool should be exhausted")
+
+		releaseFirst()
+		third, _, err := iso.lease()
+		require.NoError(t, err)
+		assert.Equal(t, first.uid, third.uid)
+	})
+
+	t.Run("Invalid range", func(t *testing.T) {
+		_, err := newIsolation(&config.Config{RunAsUser: "root", RunAsUIDRange: "10-1"})
+		assert.Error(t, err)
+	})
+}
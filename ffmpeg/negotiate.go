@@ -0,0 +1,151 @@
+package ffmpeg
+
+import (
+    "mime"
+    "strconv"
+    "strings"
+
+    "ffwebapi/utils"
+)
+
+// negotiableFormat is an output content negotiation may switch to.
+type negotiableFormat struct {
+    ext         string
+    contentType string
+    encoder     string
+}
+
+// negotiableFormats are tried in this order when the Accept header ranks
+// several of them equally. Formats are only swapped within a media kind.
+var negotiableFormats = []negotiableFormat{
+    {"webp", "image/webp", "libwebp"},
+    {"jpg", "image/jpeg", "mjpeg"},
+    {"png", "image/png", "png"},
+    {"opus", "audio/opus", "libopus"},
+    {"ogg", "audio/ogg", "libopus"},
+    {"m4a", "audio/mp4", "aac"},
+    {"mp3", "audio/mpeg", "libmp3lame"},
+    {"flac", "audio/flac", "flac"},
+}
+
+// codecFlags and qualityFlags are rewritten or dropped when the output format
+// changes, as they are specific to the original encoder.
+var (
+    codecFlags = map[utils.MediaKind][]string{
+        utils.MediaKindImage: {"-c:v", "-codec:v", "-vcodec"},
+        utils.MediaKindAudio: {"-c:a", "-codec:a", "-acodec"},
+    }
+    qualityFlags = map[string]bool{"-q:v": true, "-qscale:v": true, "-q:a": true, "-qscale:a": true, "-compression_level": true}
+)
+
+// acceptRange is one media range of an Accept header.
+type acceptRange struct {
+    typ, subtype string
+    q            float64
+}
+
+func parseAccept(accept string) []acceptRange {
+    var ranges []acceptRange
+    for _, part := range strings.Split(accept, ",") {
+        mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+        if err != nil {
+            continue
+        }
+        typ, subtype, _ := strings.Cut(mediaType, "/")
+        r := acceptRange{typ: typ, subtype: subtype, q: 1}
+        if q, err := strconv.ParseFloat(params["q"], 64); err == nil {
+            r.q = q
+        }
+        ranges = append(ranges, r)
+    }
+    return ranges
+}
+
+// quality returns the q of the most specific range matching contentType, its
+// specificity (2 = exact, 1 = type/*, 0 = */*) and its position, or ok=false.
+func quality(ranges []acceptRange, contentType string) (q float64, specificity, pos int, ok bool) {
+    typ, subtype, _ := strings.Cut(contentType, "/")
+    specificity = -1
+    for i, r := range ranges {
+        s := -1
+        switch {
+        case r.typ == typ && r.subtype == subtype:
+            s = 2
+        case r.typ == typ && r.subtype == "*":
+            s = 1
+        case r.typ == "*" && r.subtype == "*":
+            s = 0
+        }
+        if s > specificity {
+            q, specificity, pos, ok = r.q, s, i, true
+        }
+    }
+    return q, specificity, pos, ok
+}
+
+// NegotiateOutput picks the image or audio format the client prefers per its
+// Accept header, e.g. WebP instead of JPEG for browsers announcing
+// image/webp. Formats named explicitly beat wildcards of the same quality
+// and earlier ones beat later ones; outputExt is kept unless another format
+// ranks above it. If the format changes, the encoder options of the command
+// are adapted and the new args and extension are returned; otherwise args
+// and outputExt are returned as they are.
+func NegotiateOutput(args []string, outputExt, accept string) ([]string, string) {
+    kind := utils.MediaKindOf(outputExt)
+    ranges := parseAccept(accept)
+    if kind == utils.MediaKindVideo || len(ranges) == 0 {
+        return args, outputExt
+    }
+
+    current := utils.ContentTypeOf("." + outputExt)
+    for _, f := range negotiableFormats {
+        if f.ext == outputExt {
+            current = f.contentType
+        }
+    }
+    bestQ, bestSpec, bestPos, ok := quality(ranges, current)
+    if !ok {
+        bestQ, bestSpec, bestPos = 0, -1, len(ranges)
+    }
+    var best *negotiableFormat
+    for i := range negotiableFormats {
+        f := &negotiableFormats[i]
+        if f.ext == outputExt || utils.MediaKindOf(f.ext) != kind {
+            continue
+        }
+        q, spec, pos, ok := quality(ranges, f.contentType)
+        if !ok || q <= 0 {
+            continue
+        }
+        if q > bestQ || (q == bestQ && (spec > bestSpec || (spec == bestSpec && pos < bestPos))) {
+            best, bestQ, bestSpec, bestPos = f, q, spec, pos
+        }
+    }
+    if best == nil {
+        return args, outputExt
+    }
+    return withEncoder(args, kind, best), best.ext
+}
+
+// withEncoder points the command's encoder of the given kind at f, dropping
+// quality options meant for the previous encoder.
+func withEncoder(args []string, kind utils.MediaKind, f *negotiableFormat) []string {
+    out := make([]string, 0, len(args)+2)
+    replaced := false
+    for i := 0; i < len(args); i++ {
+        switch {
+        case qualityFlags[args[i]] && i+1 < len(args):
+            i++
+        case hasArg(codecFlags[kind], args[i]) && i+1 < len(args):
+            out = append(out, args[i], f.encoder)
+            replaced = true
+            i++
+        default:
+            out = append(out, args[i])
+        }
+    }
+    if !replaced {
+        out = append(out, codecFlags[kind][0], f.encoder)
+    }
+    return append(out, imageQualityArgs(f.ext)...)
+}
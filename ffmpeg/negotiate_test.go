@@ -0,0 +1,38 @@
+package ffmpeg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateOutput(t *testing.T) {
+	jpg := []string{"-i", "${INPUT_MEDIA}", "-frames:v", "1", "-vf", "scale=320:-2", "-q:v", "3"}
+	browser := "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8"
+
+	args, ext := NegotiateOutput(jpg, "jpg", browser)
+	assert.Equal(t, "webp", ext)
+	assert.Equal(t, []string{"-i", "${INPUT_MEDIA}", "-frames:v", "1", "-vf", "scale=320:-2", "-c:v", "libwebp"}, args)
+
+	// Wildcards and unrelated types keep the requested format.
+	for _, accept := range []string{"", "*/*", "image/*", "application/json", "image/webp;q=0"} {
+		args, ext = NegotiateOutput(jpg, "jpg", accept)
+		assert.Equal(t, "jpg", ext, accept)
+		assert.Equal(t, jpg, args, accept)
+	}
+
+	args, ext = NegotiateOutput([]string{"-i", "${INPUT_MEDIA}", "-c:v", "libwebp"}, "webp", "image/jpeg")
+	assert.Equal(t, "jpg", ext)
+	assert.Equal(t, []string{"-i", "${INPUT_MEDIA}", "-c:v", "mjpeg", "-q:v", "3"}, args)
+
+	mp3 := []string{"-i", "${INPUT_MEDIA}", "-vn", "-c:a", "libmp3lame", "-b:a", "192k"}
+	args, ext = NegotiateOutput(mp3, "mp3", "audio/ogg, audio/mpeg;q=0.9")
+	assert.Equal(t, "ogg", ext)
+	assert.Equal(t, []string{"-i", "${INPUT_MEDIA}", "-vn", "-c:a", "libopus", "-b:a", "192k"}, args)
+	_, ext = NegotiateOutput(mp3, "mp3", "audio/mpeg, audio/ogg")
+	assert.Equal(t, "mp3", ext)
+
+	// Video outputs are never switched.
+	_, ext = NegotiateOutput([]string{"-i", "${INPUT_MEDIA}"}, "mp4", "video/webm")
+	assert.Equal(t, "mp4", ext)
+}
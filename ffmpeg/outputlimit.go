@@ -0,0 +1,87 @@
+package ffmpeg
+
+import (
+    "io/fs"
+    "path/filepath"
+    "sync"
+    "time"
+
+    "ffwebapi/task"
+)
+
+// outputCheckInterval is how often the outputs of a running command are
+// measured against the task's maximum output size.
+const outputCheckInterval = 500 * time.Millisecond
+
+// maxOutputSize returns the output limit of a task: its own or MAX_OUTPUT_SIZE.
+// 0 means unlimited.
+func (r *Runner) maxOutputSize(t *task.Task) int64 {
+    if t.MaxOutputSize > 0 {
+        return t.MaxOutputSize
+    }
+    return r.cfg.MaxOutputSize
+}
+
+// watchOutputSize measures the outputs at paths (files or directories) while
+// a command runs and calls kill once together they exceed limit bytes. The
+// returned function stops watching, measures a last time and reports whether
+// the limit was exceeded.
+func watchOutputSize(paths []string, limit int64, kill func()) func() bool {
+    if limit <= 0 {
+        return func() bool { return false }
+    }
+    var once sync.Once
+    exceeded := false
+    check := func() bool {
+        if outputSize(paths) <= limit {
+            return false
+        }
+        once.Do(func() {
+            exceeded = true
+            kill()
+        })
+        return true
+    }
+
+    done := make(chan struct{})
+    stopped := make(chan struct{})
+    go func() {
+        defer close(stopped)
+        ticker := time.NewTicker(outputCheckInterval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-done:
+                return
+            case <-ticker.C:
+                if check() {
+                    return
+                }
+            }
+        }
+    }()
+    return func() bool {
+        close(done)
+        <-stopped
+        check() // The command may have finished between two checks
+        return exceeded
+    }
+}
+
+// outputSize returns the bytes of the files at paths, walking directories.
+// Missing paths count as empty.
+func outputSize(paths []string) int64 {
+    var size int64
+    for _, path := range paths {
+        filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+            if err != nil || d.IsDir() {
+                return nil
+            }
+            if info, err := d.Info(); err == nil {
+                size += info.Size()
+            }
+            return nil
+        })
+    }
+    return size
+}
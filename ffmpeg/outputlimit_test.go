@@ -0,0 +1,48 @@
+package ffmpeg
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ffwebapi/config"
+	"ffwebapi/task"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchOutputSize(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "output.mp4")
+	hls := filepath.Join(dir, "output")
+	require.NoError(t, os.Mkdir(hls, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(hls, "seg0.ts"), make([]byte, 600), 0o600))
+	paths := []string{file, hls}
+
+	t.Run("within the limit", func(t *testing.T) {
+		stop := watchOutputSize(paths, 1000, func() { t.Error("killed") })
+		assert.False(t, stop())
+	})
+
+	t.Run("killed once exceeded", func(t *testing.T) {
+		var kills atomic.Int32
+		stop := watchOutputSize(paths, 1000, func() { kills.Add(1) })
+		require.NoError(t, os.WriteFile(file, make([]byte, 500), 0o600))
+		assert.Eventually(t, func() bool { return kills.Load() == 1 }, 2*time.Second, 10*time.Millisecond)
+		assert.True(t, stop())
+		assert.Equal(t, int32(1), kills.Load())
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		assert.False(t, watchOutputSize(paths, 0, func() { t.Error("killed") })())
+	})
+}
+
+func TestMaxOutputSize(t *testing.T) {
+	r := &Runner{cfg: &config.Config{MaxOutputSize: 1 << 30}}
+	assert.Equal(t, int64(1<<30), r.maxOutputSize(&task.Task{}))
+	assert.Equal(t, int64(1<<20), r.maxOutputSize(&task.Task{MaxOutputSize: 1 << 20}))
+}
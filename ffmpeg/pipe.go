@@ -0,0 +1,139 @@
+package ffmpeg
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "os/exec"
+
+    "ffwebapi/task"
+)
+
+// Ends of the pipe between the stages of a piped task, as ffmpeg names them.
+const (
+    pipeWriteEnd = "pipe:1"
+    pipeReadEnd  = "pipe:0"
+)
+
+// PipeArgs splits the second stage of a piped task, reading the first
+// stage's stdout at ${INPUT_MEDIA}.
+func PipeArgs(command string) ([]string, error) {
+    args, err := SplitCommand(command)
+    if err != nil {
+        return nil, err
+    }
+    for i, arg := range args {
+        if arg == InputMediaPlaceholder {
+            args[i] = pipeReadEnd
+            return args, nil
+        }
+    }
+    return nil, fmt.Errorf("the second stage must read its input from %s", InputMediaPlaceholder)
+}
+
+// CheckPipe checks that the first stage of a piped task names the format it
+// writes to the pipe, since ffmpeg cannot guess it from a file name there.
+func CheckPipe(firstArgs []string) error {
+    for _, arg := range firstArgs {
+        if arg == "-f" {
+            return nil
+        }
+    }
+    return errors.New(`the first stage must set the format of its output with "-f", e.g. "-f nut"`)
+}
+
+// pipeStage is the second ffmpeg of a piped task. Its stdin is connected to
+// the first stage's stdout by an OS pipe, without a shell, and it logs to a
+// file of its own.
+type pipeStage struct {
+    cmd     *exec.Cmd
+    read    *os.File // The parent's ends of the pipe, closed once both stages started
+    write   *os.File
+    output  *progressWriter
+    logFile *os.File
+}
+
+// newPipeStage prepares the second stage of t and makes first write to it.
+func (r *Runner) newPipeStage(ctx context.Context, t *task.Task, first *exec.Cmd, args []string, workDir string, id *identity, cg *taskCgroup) (*pipeStage, error) {
+    bin, ok := LookupBuild(r.cfg, t.Pipe.Build)
+    if !ok {
+        return nil, fmt.Errorf("ffmpeg build %q is not configured", t.Pipe.Build)
+    }
+    read, write, err := os.Pipe()
+    if err != nil {
+        return nil, fmt.Errorf("could not create pipe: %w", err)
+    }
+    argv := sandboxCommand(r.cfg, bin, restrictProtocols(args), workDir)
+    cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+    cmd.Dir = workDir
+    setCredential(cmd, id)
+    if cg != nil {
+        cg.attach(cmd)
+    }
+    p := &pipeStage{cmd: cmd, read: read, write: write, output: &progressWriter{limit: int(r.cfg.LogBufferSize)}}
+    if r.cfg.TempDir != "" {
+        if p.logFile, err = os.OpenFile(task.PipeLogPath(r.cfg, t.ID), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600); err != nil {
+            p.close()
+            return nil, fmt.Errorf("could not create the log of stage 2: %w", err)
+        }
+        p.output.log = p.logFile
+    }
+    cmd.Stdin = read
+    cmd.Stdout = p.output
+    cmd.Stderr = p.output
+    first.Stdout = write
+    return p, nil
+}
+
+// start starts the second stage once the first one runs. The parent's ends
+// of the pipe are closed, so each stage sees the other one exit.
+func (p *pipeStage) start() error {
+    if err := p.cmd.Start(); err != nil {
+        p.close()
+        return fmt.Errorf("stage 2: %w", err)
+    }
+    p.read.Close()
+    p.write.Close()
+    return nil
+}
+
+func (p *pipeStage) kill() {
+    if p.cmd.Process != nil {
+        p.cmd.Process.Kill()
+    }
+}
+
+// close releases the pipe and the log, for a stage that did not start.
+func (p *pipeStage) close() {
+    p.read.Close()
+    p.write.Close()
+    if p.logFile != nil {
+        p.logFile.Close()
+    }
+}
+
+// wait waits for the second stage and returns the error of the piped
+// command. The second stage's is reported first: a failing second stage
+// makes the first one fail too, on a broken pipe.
+func (p *pipeStage) wait(firstErr error) error {
+    err := p.cmd.Wait()
+    if p.logFile != nil {
+        p.logFile.Close()
+    }
+    switch {
+    case err != nil:
+        return fmt.Errorf("stage 2: %w", err)
+    case firstErr != nil:
+        return fmt.Errorf("stage 1: %w", firstErr)
+    }
+    return nil
+}
+
+// cpuSeconds is the CPU time the second stage used.
+func (p *pipeStage) cpuSeconds() float64 {
+    if p.cmd.ProcessState == nil {
+        return 0
+    }
+    return (p.cmd.ProcessState.UserTime() + p.cmd.ProcessState.SystemTime()).Seconds()
+}
@@ -0,0 +1,86 @@
+package ffmpeg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ffwebapi/config"
+	"ffwebapi/task"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPipeRunner(t *testing.T, stage2 string) (*Runner, *config.Config, string) {
+	dir := t.TempDir()
+	// Stage 1 writes its input to stdout; stage 2 copies stdin to its output.
+	decoder := filepath.Join(dir, "decoder")
+	require.NoError(t, os.WriteFile(decoder, []byte("#!/bin/sh\necho decoding >&2\ncat \"$4\"\n"), 0o755))
+	encoder := filepath.Join(dir, "ffmpeg")
+	writeFakeFFmpeg(t, encoder, stage2)
+	input := filepath.Join(dir, "input.raw")
+	require.NoError(t, os.WriteFile(input, []byte("media"), 0o600))
+
+	cfg := &config.Config{FFBin: encoder, FFProbeBin: filepath.Join(dir, "ffprobe"), FFBuilds: map[string]string{"decoder": decoder},
+		MaxConcurrency: 1, MaxInputSize: 1 << 20, LogBufferSize: 1024}
+	r, err := NewRunner(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(cfg.TempDir) })
+	return r, cfg, input
+}
+
+func TestRunner_Pipe(t *testing.T) {
+	r, cfg, input := newPipeRunner(t, "#!/bin/sh\nfor out; do :; done\necho \"encoding $4\" >&2\ncat > \"$out\"\n")
+
+	tk := &task.Task{ID: "piped", Command: "-i ${INPUT_MEDIA} -f nut", InputMedia: input, OutputExt: "mp4",
+		Pipe: &task.Pipe{Command: "-i ${INPUT_MEDIA} -c:v libx264", FirstBuild: "Decoder"}}
+	output, err := r.Run(context.Background(), tk)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(tk.OutputPath)
+	require.NoError(t, err)
+	assert.Equal(t, "media", string(data))
+	assert.Equal(t, "decoding\n", output)
+	assert.Equal(t, "encoding pipe:0\n", tk.PipeOutput)
+	log, err := os.ReadFile(task.PipeLogPath(cfg, tk.ID))
+	require.NoError(t, err)
+	assert.Equal(t, "encoding pipe:0\n", string(log))
+}
+
+func TestRunner_PipeStage2Fails(t *testing.T) {
+	r, _, input := newPipeRunner(t, "#!/bin/sh\necho 'Unknown encoder' >&2\nexit 1\n")
+
+	tk := &task.Task{ID: "piped", Command: "-i ${INPUT_MEDIA} -f nut", InputMedia: input, OutputExt: "mp4",
+		Pipe: &task.Pipe{Command: "-i ${INPUT_MEDIA} -c:v nope", FirstBuild: "decoder"}}
+	_, err := r.Run(context.Background(), tk)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "stage 2")
+	assert.Empty(t, tk.OutputPath)
+	assert.Equal(t, "Unknown encoder\n", tk.PipeOutput)
+}
+
+func TestRunner_PipeUnknownBuild(t *testing.T) {
+	r, _, input := newPipeRunner(t, "#!/bin/sh\n")
+
+	tk := &task.Task{ID: "piped", Command: "-i ${INPUT_MEDIA} -f nut", InputMedia: input, OutputExt: "mp4",
+		Pipe: &task.Pipe{Command: "-i ${INPUT_MEDIA}", Build: "missing"}}
+	_, err := r.Run(context.Background(), tk)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `ffmpeg build "missing" is not configured`)
+}
+
+func TestPipeArgs(t *testing.T) {
+	args, err := PipeArgs("-i ${INPUT_MEDIA} -c:v libx264")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-i", "pipe:0", "-c:v", "libx264"}, args)
+
+	_, err = PipeArgs("-i other.mp4 -c:v libx264")
+	assert.Error(t, err)
+}
+
+func TestCheckPipe(t *testing.T) {
+	assert.NoError(t, CheckPipe([]string{"-i", "${INPUT_MEDIA}", "-f", "nut"}))
+	assert.Error(t, CheckPipe([]string{"-i", "${INPUT_MEDIA}", "-c:v", "rawvideo"}))
+}
@@ -0,0 +1,70 @@
+package ffmpeg
+
+import (
+    "sort"
+
+    "ffwebapi/config"
+)
+
+// CommandPolicy describes the checks commands go through, so that clients
+// can check user input before submitting it.
+type CommandPolicy struct {
+    InputPlaceholder     string           `json:"inputPlaceholder"`     // Every command must contain it
+    DisallowedCharacters string           `json:"disallowedCharacters"` // Rejected in arguments, outside placeholders
+    OutputExtPattern     string           `json:"outputExtPattern"`     // Regular expression output extensions must match
+    Tools                []ToolPolicy     `json:"tools"`                // Enabled tools, ffmpeg included
+    Builds               []string         `json:"builds,omitempty"`     // FF_BUILDS entries the stages of piped tasks may run
+    Allowlist            *AllowlistPolicy `json:"allowlist,omitempty"`  // Set if COMMAND_ALLOWLIST is on
+}
+
+// ToolPolicy is an enabled tool and the options it allows. ffmpeg has none
+// listed; its commands are checked as a whole (see Allowlist).
+type ToolPolicy struct {
+    Name           string   `json:"name"`
+    AllowedOptions []string `json:"allowedOptions,omitempty"`
+}
+
+// AllowlistPolicy lists what ffmpeg commands may use with COMMAND_ALLOWLIST
+// on. Options matching DeniedOptionPatterns are rejected outright.
+type AllowlistPolicy struct {
+    VideoCodecs          []string `json:"videoCodecs"`
+    AudioCodecs          []string `json:"audioCodecs"`
+    Filters              []string `json:"filters"`
+    Formats              []string `json:"formats"` // Output formats given with -f
+    DeniedOptionPatterns []string `json:"deniedOptionPatterns"`
+}
+
+// EffectivePolicy returns the command policy of the configuration.
+func EffectivePolicy(cfg *config.Config) CommandPolicy {
+    p := CommandPolicy{
+        InputPlaceholder:     InputMediaPlaceholder,
+        DisallowedCharacters: disallowedChars,
+        OutputExtPattern:     outputExtRe.String(),
+    }
+    for _, name := range EnabledTools(cfg) {
+        tool, _ := LookupTool(cfg, name)
+        p.Tools = append(p.Tools, ToolPolicy{Name: tool.Name, AllowedOptions: tool.allowedOptions()})
+    }
+    for name := range cfg.FFBuilds {
+        p.Builds = append(p.Builds, name)
+    }
+    sort.Strings(p.Builds)
+    if cfg.CommandAllowlist {
+        p.Allowlist = &AllowlistPolicy{
+            VideoCodecs:          nonNil(cfg.AllowedVideoCodecs),
+            AudioCodecs:          nonNil(cfg.AllowedAudioCodecs),
+            Filters:              nonNil(cfg.AllowedFilters),
+            Formats:              nonNil(cfg.AllowedFormats),
+            DeniedOptionPatterns: []string{"-/*", "*_script*"}, // Read their value from a file; see CheckAllowlist
+        }
+    }
+    return p
+}
+
+// nonNil makes empty lists encode as [] rather than null.
+func nonNil(list []string) []string {
+    if list == nil {
+        return []string{}
+    }
+    return list
+}
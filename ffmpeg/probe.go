@@ -0,0 +1,70 @@
+package ffmpeg
+
+import (
+    "context"
+    "fmt"
+    "os/exec"
+    "path/filepath"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// probeTimeout bounds how long we wait on ffprobe; probing should be near instant.
+const probeTimeout = 10 * time.Second
+
+// progressTimeRe matches the media time of ffmpeg's progress lines, e.g.
+// "frame=  250 fps=0.0 ... time=00:00:10.01 bitrate=...".
+var progressTimeRe = regexp.MustCompile(`time=(\d+):(\d{2}):(\d{2}(?:\.\d+)?)`)
+
+// Probe returns the duration of a local file using ffprobe. Media without a
+// duration (e.g. still images) report 0. URLs are rejected: ffprobe would
+// fetch them itself, around the input policy that applies to fetched inputs.
+func (r *Runner) Probe(ctx context.Context, path string) (time.Duration, error) {
+    if !filepath.IsAbs(path) {
+        return 0, fmt.Errorf("can only probe local files, not %q", path)
+    }
+    ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+    defer cancel()
+
+    cmd := exec.CommandContext(ctx, r.cfg.FFProbeBin,
+        "-v", "error",
+        "-show_entries", "format=duration",
+        "-of", "default=noprint_wrappers=1:nokey=1",
+        "file:"+path, // Never another protocol, whatever the name
+    )
+    out, err := cmd.Output()
+    if err != nil {
+        return 0, fmt.Errorf("ffprobe failed: %w", err)
+    }
+
+    value := strings.TrimSpace(string(out))
+    if value == "" || value == "N/A" {
+        return 0, nil
+    }
+    seconds, err := strconv.ParseFloat(value, 64)
+    if err != nil {
+        return 0, fmt.Errorf("unexpected ffprobe duration %q", value)
+    }
+    return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// ProcessedDuration returns how much media ffmpeg processed according to the
+// last progress line of its output, or 0 if it reported none.
+func ProcessedDuration(output string) time.Duration {
+    matches := progressTimeRe.FindAllStringSubmatch(output, -1)
+    if len(matches) == 0 {
+        return 0
+    }
+    last := matches[len(matches)-1]
+    return parseProgressTime(last[1], last[2], last[3])
+}
+
+// parseProgressTime adds up the hours, minutes and seconds of a progress line.
+func parseProgressTime(h, m, s string) time.Duration {
+    hours, _ := strconv.Atoi(h)
+    minutes, _ := strconv.Atoi(m)
+    seconds, _ := strconv.ParseFloat(s, 64)
+    return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds*float64(time.Second))
+}
@@ -0,0 +1,50 @@
+package ffmpeg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ffwebapi/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunner_Probe(t *testing.T) {
+	dir := t.TempDir()
+	// The fake ffprobe records its input and reports a duration.
+	ffprobeBin := filepath.Join(dir, "ffprobe")
+	script := "#!/bin/sh\nfor f; do :; done\necho \"$f\" > " + filepath.Join(dir, "probed") + "\necho 12.5\n"
+	require.NoError(t, os.WriteFile(ffprobeBin, []byte(script), 0o755))
+	r := &Runner{cfg: &config.Config{FFProbeBin: ffprobeBin}}
+
+	input := filepath.Join(dir, "in.mp4")
+	duration, err := r.Probe(context.Background(), input)
+	require.NoError(t, err)
+	assert.Equal(t, 12500*time.Millisecond, duration)
+	probed, err := os.ReadFile(filepath.Join(dir, "probed"))
+	require.NoError(t, err)
+	assert.Equal(t, "file:"+input+"\n", string(probed))
+
+	// URLs and protocols would be fetched by ffprobe, around the input policy.
+	require.NoError(t, os.Remove(filepath.Join(dir, "probed")))
+	for _, media := range []string{"http://169.254.169.254/latest", "concat:a.mp4|b.mp4", "in.mp4"} {
+		_, err := r.Probe(context.Background(), media)
+		assert.Error(t, err, media)
+	}
+	assert.NoFileExists(t, filepath.Join(dir, "probed"))
+}
+
+func TestProcessedDuration(t *testing.T) {
+	output := "Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'input.mp4':\n" +
+		"  Duration: 00:01:30.00, start: 0.000000, bitrate: 1205 kb/s\n" +
+		"frame=  120 fps=0.0 q=28.0 size=     256kB time=00:00:04.96 bitrate= 422.3kbits/s speed=9.9x\r" +
+		"frame= 2250 fps=240 q=-1.0 Lsize=    4711kB time=00:01:29.92 bitrate= 429.2kbits/s speed=9.6x\n"
+	assert.Equal(t, 89920*time.Millisecond, ProcessedDuration(output))
+
+	assert.Equal(t, 2*time.Hour+3*time.Minute+4*time.Second, ProcessedDuration("size=N/A time=02:03:04.00 bitrate=N/A"))
+	assert.Zero(t, ProcessedDuration("Input #0, png_pipe, from 'input.png':\n  Duration: N/A, bitrate: N/A"))
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package ffmpeg
+
+import (
+    "log/slog"
+    "os/exec"
+)
+
+// setCredential is not supported on this platform; children run as the server user.
+func setCredential(cmd *exec.Cmd, id *identity) {
+    if id != nil {
+        slog.Warn("RUN_AS_USER is not supported on this platform")
+    }
+}
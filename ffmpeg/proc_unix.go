@@ -0,0 +1,19 @@
+//go:build unix
+
+package ffmpeg
+
+import (
+    "os/exec"
+    "syscall"
+)
+
+// setCredential makes the child process run as the given identity.
+func setCredential(cmd *exec.Cmd, id *identity) {
+    if id == nil {
+        return
+    }
+    if cmd.SysProcAttr == nil {
+        cmd.SysProcAttr = &syscall.SysProcAttr{}
+    }
+    cmd.SysProcAttr.Credential = &syscall.Credential{Uid: id.uid, Gid: id.gid}
+}
@@ -0,0 +1,64 @@
+package ffmpeg
+
+import (
+    "math"
+    "time"
+
+    "ffwebapi/task"
+)
+
+// treeSample holds the counters of a process tree at one point in time.
+type treeSample struct {
+    processes             int
+    cpuTicks              int64
+    rss                   int64
+    readBytes, writeBytes int64
+}
+
+// watchProcessTree samples the process tree of pid every PROCESS_SAMPLE_INTERVAL
+// into the task's metrics until the returned function is called.
+func (r *Runner) watchProcessTree(t *task.Task, pid int) func() {
+    interval := r.cfg.ProcessSampleInterval
+    if interval <= 0 {
+        return func() {}
+    }
+    t.SetMetrics(nil)
+    done, exited := make(chan struct{}), make(chan struct{})
+    go func() {
+        defer close(exited)
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        var prev treeSample
+        var prevAt time.Time
+        var peak int64
+        for {
+            select {
+            case <-done:
+                return
+            case <-ticker.C:
+            }
+            s, err := sampleTree(pid)
+            if err != nil {
+                continue // Not started yet, already gone, or unsupported
+            }
+            now := time.Now()
+            m := &task.ProcessMetrics{
+                SampledAt: now, Processes: s.processes, RSSBytes: s.rss,
+                ReadBytes: s.readBytes, WriteBytes: s.writeBytes,
+            }
+            if !prevAt.IsZero() {
+                // Children that exited take their ticks with them; never report less than 0.
+                cpu := float64(s.cpuTicks-prev.cpuTicks) / clockTicks / now.Sub(prevAt).Seconds() * 100
+                m.CPUPercent = math.Round(math.Max(0, cpu)*10) / 10
+            }
+            peak = max(peak, s.rss)
+            m.PeakRSSBytes = peak
+            t.SetMetrics(m) // Replaced, never modified, so readers see a whole sample
+            prev, prevAt = s, now
+        }
+    }()
+    return func() {
+        close(done)
+        <-exited
+    }
+}
@@ -0,0 +1,115 @@
+//go:build linux
+
+package ffmpeg
+
+import (
+    "bufio"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+)
+
+// clockTicks is USER_HZ, the unit of CPU times in /proc. It is 100 on every
+// platform Linux supports today.
+const clockTicks = 100
+
+// sampleTree reads the counters of a process and all its descendants from
+// /proc. I/O counters of processes running as another user are unreadable
+// and left out.
+func sampleTree(pid int) (treeSample, error) {
+    stats, err := filepath.Glob("/proc/[0-9]*/stat")
+    if err != nil {
+        return treeSample{}, err
+    }
+    children := make(map[int][]int)
+    procs := make(map[int]procStat)
+    for _, path := range stats {
+        data, err := os.ReadFile(path)
+        if err != nil {
+            continue // The process exited meanwhile
+        }
+        st, err := parseProcStat(string(data))
+        if err != nil {
+            continue
+        }
+        procs[st.pid] = st
+        children[st.ppid] = append(children[st.ppid], st.pid)
+    }
+    if _, ok := procs[pid]; !ok {
+        return treeSample{}, fmt.Errorf("process %d not found", pid)
+    }
+
+    var s treeSample
+    pageSize := int64(os.Getpagesize())
+    for queue := []int{pid}; len(queue) > 0; queue = queue[1:] {
+        st := procs[queue[0]]
+        s.processes++
+        s.cpuTicks += st.utime + st.stime
+        s.rss += st.rssPages * pageSize
+        if read, write, err := readProcIO(st.pid); err == nil {
+            s.readBytes += read
+            s.writeBytes += write
+        }
+        queue = append(queue, children[st.pid]...)
+    }
+    return s, nil
+}
+
+type procStat struct {
+    pid, ppid    int
+    utime, stime int64 // Clock ticks
+    rssPages     int64
+}
+
+// parseProcStat parses /proc/<pid>/stat. The command name is in parentheses
+// and may contain spaces, so fields are counted from the last ")".
+func parseProcStat(data string) (procStat, error) {
+    open, end := strings.IndexByte(data, '('), strings.LastIndexByte(data, ')')
+    if open < 0 || end < open {
+        return procStat{}, fmt.Errorf("malformed stat")
+    }
+    fields := strings.Fields(data[end+1:])
+    if len(fields) < 22 {
+        return procStat{}, fmt.Errorf("malformed stat")
+    }
+    var st procStat
+    var err error
+    ints := []struct {
+        dst   *int64
+        field int
+    }{{&st.utime, 11}, {&st.stime, 12}, {&st.rssPages, 21}}
+    for _, f := range ints {
+        if *f.dst, err = strconv.ParseInt(fields[f.field], 10, 64); err != nil {
+            return procStat{}, err
+        }
+    }
+    if st.pid, err = strconv.Atoi(strings.TrimSpace(data[:open])); err != nil {
+        return procStat{}, err
+    }
+    if st.ppid, err = strconv.Atoi(fields[1]); err != nil {
+        return procStat{}, err
+    }
+    return st, nil
+}
+
+// readProcIO returns the bytes a process read from and wrote to storage.
+func readProcIO(pid int) (read, write int64, err error) {
+    f, err := os.Open(fmt.Sprintf("/proc/%d/io", pid))
+    if err != nil {
+        return 0, 0, err
+    }
+    defer f.Close()
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        name, value, _ := strings.Cut(scanner.Text(), ": ")
+        switch name {
+        case "read_bytes":
+            read, _ = strconv.ParseInt(value, 10, 64)
+        case "write_bytes":
+            write, _ = strconv.ParseInt(value, 10, 64)
+        }
+    }
+    return read, write, scanner.Err()
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package ffmpeg
+
+import "errors"
+
+const clockTicks = 100
+
+// sampleTree is only supported on Linux, which exposes process counters in /proc.
+func sampleTree(pid int) (treeSample, error) {
+    return treeSample{}, errors.New("process sampling is not supported on this platform")
+}
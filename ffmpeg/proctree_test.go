@@ -0,0 +1,58 @@
+//go:build linux
+
+package ffmpeg
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"ffwebapi/config"
+	"ffwebapi/task"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProcStat(t *testing.T) {
+	st, err := parseProcStat("4242 (ffmpeg (worker) 1) S 4200 4242 4200 0 -1 4194560 1000 0 0 0 350 25 0 0 20 0 3 0 123 456789 2048 18446744073709551615")
+	require.NoError(t, err)
+	assert.Equal(t, procStat{pid: 4242, ppid: 4200, utime: 350, stime: 25, rssPages: 2048}, st)
+
+	_, err = parseProcStat("4242 (ffmpeg) S")
+	assert.Error(t, err)
+}
+
+func TestSampleTree(t *testing.T) {
+	s, err := sampleTree(os.Getpid())
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, s.processes, 1)
+	assert.Greater(t, s.rss, int64(0))
+
+	_, err = sampleTree(1 << 30)
+	assert.Error(t, err)
+}
+
+func TestWatchProcessTree(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "sleep 2 & sleep 2 & wait")
+	require.NoError(t, cmd.Start())
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	r := &Runner{cfg: &config.Config{ProcessSampleInterval: 20 * time.Millisecond}}
+	tk := &task.Task{ID: "t1"}
+	tk.SetMetrics(&task.ProcessMetrics{Processes: 99}) // Left over from a previous attempt
+	stop := r.watchProcessTree(tk, cmd.Process.Pid)
+	assert.Eventually(t, func() bool {
+		m := tk.LatestMetrics()
+		return m != nil && m.Processes == 3 && !m.SampledAt.IsZero()
+	}, 2*time.Second, 10*time.Millisecond)
+	stop()
+	m := tk.LatestMetrics()
+	assert.Greater(t, m.RSSBytes, int64(0))
+	assert.GreaterOrEqual(t, m.PeakRSSBytes, m.RSSBytes)
+	assert.GreaterOrEqual(t, m.CPUPercent, 0.0)
+}
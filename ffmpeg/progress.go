@@ -0,0 +1,120 @@
+package ffmpeg
+
+import (
+    "bytes"
+    "io"
+    "math"
+    "sync"
+    "time"
+
+    "ffwebapi/task"
+)
+
+// progressTail is how much of a write is kept to match progress lines that
+// span two writes.
+const progressTail = 64
+
+// progressWriter streams a command's output to log, keeps its last limit
+// bytes in memory (all of it if limit is 0), and follows the media time of
+// ffmpeg's progress lines as they are written.
+type progressWriter struct {
+    mu     sync.Mutex
+    log    io.Writer // May be nil
+    logErr error     // First failed write to log, after which it is no longer written
+    limit  int
+    buf    []byte
+    cut    bool // Output was dropped from the front of buf
+    tail   []byte
+    onTime func(time.Duration)
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    scan := append(w.tail, p...)
+    if m := progressTimeRe.FindAllSubmatchIndex(scan, -1); len(m) > 0 {
+        last := m[len(m)-1]
+        if w.onTime != nil {
+            w.onTime(parseProgressTime(string(scan[last[2]:last[3]]), string(scan[last[4]:last[5]]), string(scan[last[6]:last[7]])))
+        }
+        scan = scan[last[1]:] // Matched once only
+    }
+    if len(scan) > progressTail {
+        scan = scan[len(scan)-progressTail:]
+    }
+    w.tail = append(w.tail[:0], scan...)
+
+    if w.log != nil && w.logErr == nil {
+        _, w.logErr = w.log.Write(p)
+    }
+    w.buf = append(w.buf, p...)
+    // Trimmed once twice the limit, so the kept bytes are not moved on every write.
+    if w.limit > 0 && len(w.buf) > 2*w.limit {
+        w.buf = w.buf[:copy(w.buf, w.buf[len(w.buf)-w.limit:])]
+        w.cut = true
+    }
+    return len(p), nil
+}
+
+// String returns the output kept in memory, starting at a line if earlier
+// output was dropped.
+func (w *progressWriter) String() string {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    out := w.buf
+    if w.limit > 0 && len(out) > w.limit {
+        out, w.cut = out[len(out)-w.limit:], true
+    }
+    if w.cut {
+        if i := bytes.IndexAny(out, "\r\n"); i >= 0 {
+            out = out[i+1:]
+        }
+    }
+    return string(out)
+}
+
+// truncated reports whether output was dropped from memory.
+func (w *progressWriter) truncated() bool {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    return w.cut || (w.limit > 0 && len(w.buf) > w.limit)
+}
+
+// expectedDuration returns how much media a command processes: the input's
+// duration less any -ss offset, bounded by -t. 0 if unknown.
+func expectedDuration(args []string, inputDuration time.Duration) time.Duration {
+    if inputDuration <= 0 {
+        return 0
+    }
+    expected := inputDuration
+    for i := 0; i < len(args)-1; i++ {
+        if args[i] == "-ss" {
+            if offset, err := parseDuration(args[i+1]); err == nil && offset > 0 {
+                expected -= offset
+            }
+        }
+    }
+    if est, err := EstimateOutput(args); err == nil && est.Duration > 0 && est.Duration < expected {
+        expected = est.Duration
+    }
+    if expected < 0 {
+        return 0
+    }
+    return expected
+}
+
+// trackProgress returns a callback that sets the task's percent and ETA from
+// the media time processed so far, extrapolating the time the command took
+// since started.
+func trackProgress(t *task.Task, expected time.Duration, started time.Time) func(time.Duration) {
+    return func(processed time.Duration) {
+        if expected <= 0 || processed <= 0 {
+            return
+        }
+        p := math.Min(1, float64(processed)/float64(expected))
+        t.Update(func() {
+            t.Percent = math.Round(p*1000) / 10
+            t.ETA = math.Round(time.Since(started).Seconds() * (1 - p) / p)
+        })
+    }
+}
@@ -0,0 +1,73 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"ffwebapi/task"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressWriter(t *testing.T) {
+	var times []time.Duration
+	w := &progressWriter{onTime: func(d time.Duration) { times = append(times, d) }}
+
+	w.Write([]byte("Input #0, matroska\n"))
+	w.Write([]byte("frame=  250 fps=0.0 q=28.0 size=256kB time=00:00:10.01 bitrate=209.5kbits/s\r"))
+	// A progress line split across two writes is still seen whole.
+	w.Write([]byte("frame=  500 fps=250 q=28.0 size=512kB time=00:0"))
+	w.Write([]byte("0:20.02 bitrate=209.5kbits/s\r"))
+
+	assert.Equal(t, []time.Duration{10010 * time.Millisecond, 20020 * time.Millisecond}, times)
+	assert.Contains(t, w.String(), "Input #0, matroska\nframe=  250")
+}
+
+func TestProgressWriter_Limit(t *testing.T) {
+	var log strings.Builder
+	w := &progressWriter{log: &log, limit: 64}
+	var full strings.Builder
+	for i := 0; i < 100; i++ {
+		line := fmt.Sprintf("line %03d of the output\n", i)
+		full.WriteString(line)
+		w.Write([]byte(line))
+	}
+
+	assert.Equal(t, full.String(), log.String(), "the log gets all of the output")
+	assert.True(t, w.truncated())
+	out := w.String()
+	assert.LessOrEqual(t, len(out), 64)
+	assert.True(t, strings.HasPrefix(out, "line "), "the kept tail starts at a line: %q", out)
+	assert.True(t, strings.HasSuffix(out, "line 099 of the output\n"))
+
+	short := &progressWriter{limit: 64}
+	short.Write([]byte("short\n"))
+	assert.False(t, short.truncated())
+	assert.Equal(t, "short\n", short.String())
+}
+
+func TestExpectedDuration(t *testing.T) {
+	assert.Equal(t, 60*time.Second, expectedDuration([]string{"-i", "in.mp4", "-c", "copy"}, time.Minute))
+	assert.Equal(t, 45*time.Second, expectedDuration([]string{"-ss", "15", "-i", "in.mp4"}, time.Minute))
+	assert.Equal(t, 10*time.Second, expectedDuration([]string{"-i", "in.mp4", "-t", "10"}, time.Minute))
+	assert.Equal(t, time.Duration(0), expectedDuration([]string{"-ss", "90", "-i", "in.mp4"}, time.Minute))
+	assert.Equal(t, time.Duration(0), expectedDuration([]string{"-i", "in.mp4"}, 0))
+}
+
+func TestTrackProgress(t *testing.T) {
+	tk := &task.Task{}
+	track := trackProgress(tk, 100*time.Second, time.Now().Add(-10*time.Second))
+	track(25 * time.Second)
+	assert.Equal(t, 25.0, tk.Percent)
+	assert.InDelta(t, 30, tk.ETA, 1) // 10s for a quarter, so 30s for the rest
+
+	track(200 * time.Second) // Never beyond 100%
+	assert.Equal(t, 100.0, tk.Percent)
+	assert.Equal(t, 0.0, tk.ETA)
+
+	unknown := &task.Task{}
+	trackProgress(unknown, 0, time.Now())(25 * time.Second)
+	assert.Zero(t, unknown.Percent)
+}
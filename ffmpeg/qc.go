@@ -0,0 +1,345 @@
+package ffmpeg
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "math"
+    "os/exec"
+    "path/filepath"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+
+    "ffwebapi/task"
+    "ffwebapi/utils"
+)
+
+// minDurationTolerance is the smallest duration mismatch QC flags, so short
+// outputs aren't failed over container rounding.
+const minDurationTolerance = 500 * time.Millisecond
+
+// timingFilters change an output's duration on purpose.
+var timingFilters = []string{"setpts", "atempo", "trim", "select", "loop"}
+
+// loudnessTolerance is how far (in LU) an output's integrated loudness may be
+// off its target's; single pass loudness normalization is not exact.
+const loudnessTolerance = 1.0
+
+// mediaInfo is what QC needs to know about a media file.
+type mediaInfo struct {
+    Duration time.Duration
+    BitRate  int64       // bits/s; 0 if unknown
+    Streams  []string    // codec_type of each stream, e.g. "video"
+    Video    *streamInfo // First video stream, if any
+    Audio    *streamInfo // First audio stream, if any
+}
+
+// streamInfo are the stream properties conformance targets constrain.
+type streamInfo struct {
+    CodecName      string  `json:"codec_name"`
+    Profile        string  `json:"profile"`
+    Level          int     `json:"level"`
+    PixFmt         string  `json:"pix_fmt"`
+    ColorPrimaries string  `json:"color_primaries"`
+    Width          int     `json:"width"`
+    Height         int     `json:"height"`
+    FrameRate      float64 `json:"-"` // Parsed from r_frame_rate
+    SampleRate     int     `json:"-"` // Parsed from sample_rate, which ffprobe reports as a string
+    Channels       int     `json:"channels"`
+}
+
+// loudnessInfo is an EBU R128 measurement of an output.
+type loudnessInfo struct {
+    Integrated float64 // LUFS
+    TruePeak   float64 // dBTP
+}
+
+func (m *mediaInfo) has(streamType string) bool {
+    for _, s := range m.Streams {
+        if s == streamType {
+            return true
+        }
+    }
+    return false
+}
+
+// probeMedia inspects a local file with ffprobe.
+func (r *Runner) probeMedia(ctx context.Context, path string) (*mediaInfo, error) {
+    ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+    defer cancel()
+
+    cmd := exec.CommandContext(ctx, r.cfg.FFProbeBin,
+        "-v", "error",
+        "-show_entries", "format=duration,bit_rate:stream=codec_type,codec_name,profile,level,pix_fmt,color_primaries,width,height,r_frame_rate,sample_rate,channels",
+        "-of", "json",
+        path,
+    )
+    out, err := cmd.Output()
+    if err != nil {
+        return nil, fmt.Errorf("ffprobe failed: %w", err)
+    }
+
+    var probe struct {
+        Streams []struct {
+            streamInfo
+            CodecType  string `json:"codec_type"`
+            FrameRate  string `json:"r_frame_rate"`
+            SampleRate string `json:"sample_rate"`
+        } `json:"streams"`
+        Format struct {
+            Duration string `json:"duration"`
+            BitRate  string `json:"bit_rate"`
+        } `json:"format"`
+    }
+    if err := json.Unmarshal(out, &probe); err != nil {
+        return nil, fmt.Errorf("unexpected ffprobe output: %w", err)
+    }
+    info := &mediaInfo{}
+    for _, s := range probe.Streams {
+        info.Streams = append(info.Streams, s.CodecType)
+        stream := s.streamInfo
+        stream.FrameRate = parseFrameRate(s.FrameRate)
+        stream.SampleRate, _ = strconv.Atoi(s.SampleRate)
+        switch {
+        case s.CodecType == "video" && info.Video == nil:
+            info.Video = &stream
+        case s.CodecType == "audio" && info.Audio == nil:
+            info.Audio = &stream
+        }
+    }
+    // Missing values ("N/A") stay zero.
+    if seconds, err := strconv.ParseFloat(probe.Format.Duration, 64); err == nil {
+        info.Duration = time.Duration(seconds * float64(time.Second))
+    }
+    info.BitRate, _ = strconv.ParseInt(probe.Format.BitRate, 10, 64)
+    return info, nil
+}
+
+// verifyOutput probes the task's primary output and checks it against the
+// input and the executed command.
+func (r *Runner) verifyOutput(ctx context.Context, t *task.Task, args []string, inputPath string) *task.QCReport {
+    report := &task.QCReport{}
+    output, err := r.probeMedia(ctx, t.OutputPath)
+    if err != nil {
+        report.Issues = []string{fmt.Sprintf("output could not be probed: %v", err)}
+        return report
+    }
+    // Without input details only the output's own sanity is checked.
+    input, err := r.probeMedia(ctx, inputPath)
+    if err != nil {
+        input = nil
+    } else {
+        report.InputDuration = input.Duration.Seconds()
+    }
+
+    report.OutputDuration = output.Duration.Seconds()
+    report.OutputStreams = output.Streams
+    report.OutputBitrate = output.BitRate
+    kind := utils.MediaKindOf(filepath.Ext(t.OutputPath))
+    report.Issues = checkOutput(input, output, args, kind, len(t.OutputExts) > 0, r.cfg.QCDurationTolerance)
+    if t.Target != nil {
+        report.Target = t.Target.Name
+        var loudness *loudnessInfo
+        if t.Target.Loudness != 0 || t.Target.TruePeak != 0 {
+            if loudness, err = r.measureLoudness(ctx, t.OutputPath); err != nil {
+                report.Issues = append(report.Issues, fmt.Sprintf("loudness could not be measured: %v", err))
+            } else if !math.IsInf(loudness.Integrated, 0) && !math.IsInf(loudness.TruePeak, 0) {
+                report.Loudness, report.TruePeak = loudness.Integrated, loudness.TruePeak // Silence has no JSON value
+            }
+        }
+        report.Issues = append(report.Issues, checkTarget(t.Target, output, loudness)...)
+    }
+    report.Passed = len(report.Issues) == 0
+    return report
+}
+
+// checkTarget lists how an output misses its conformance target. loudness is
+// nil if it was not measured.
+func checkTarget(target *task.ConformanceTarget, output *mediaInfo, loudness *loudnessInfo) []string {
+    var issues []string
+    mismatch := func(what string, got, want interface{}) {
+        issues = append(issues, fmt.Sprintf("%s is %v, %s requires %v", what, got, target.Name, want))
+    }
+
+    if v := output.Video; v != nil {
+        if target.VideoCodec != "" && v.CodecName != target.VideoCodec {
+            mismatch("video codec", v.CodecName, target.VideoCodec)
+        }
+        if len(target.Profiles) > 0 && !hasArg(target.Profiles, v.Profile) {
+            mismatch("video profile", v.Profile, strings.Join(target.Profiles, " or "))
+        }
+        if target.MaxLevel > 0 && v.Level > target.MaxLevel {
+            mismatch("video level", v.Level, fmt.Sprintf("at most %d", target.MaxLevel))
+        }
+        if target.PixelFormat != "" && v.PixFmt != target.PixelFormat {
+            mismatch("pixel format", v.PixFmt, target.PixelFormat)
+        }
+        if target.ColorPrimaries != "" && v.ColorPrimaries != target.ColorPrimaries {
+            mismatch("color primaries", v.ColorPrimaries, target.ColorPrimaries)
+        }
+        if target.Width > 0 && target.Height > 0 && (v.Width != target.Width || v.Height != target.Height) {
+            mismatch("frame size", fmt.Sprintf("%dx%d", v.Width, v.Height), fmt.Sprintf("%dx%d", target.Width, target.Height))
+        }
+        if (target.MaxWidth > 0 && v.Width > target.MaxWidth) || (target.MaxHeight > 0 && v.Height > target.MaxHeight) {
+            mismatch("frame size", fmt.Sprintf("%dx%d", v.Width, v.Height), fmt.Sprintf("at most %dx%d", target.MaxWidth, target.MaxHeight))
+        }
+        if target.FrameRate > 0 && math.Abs(v.FrameRate-target.FrameRate) > 0.01 {
+            mismatch("frame rate", strconv.FormatFloat(v.FrameRate, 'f', -1, 64), target.FrameRate)
+        }
+    } else if target.VideoCodec != "" {
+        issues = append(issues, fmt.Sprintf("output has no video stream, %s requires %s", target.Name, target.VideoCodec))
+    }
+
+    if a := output.Audio; a != nil {
+        if target.AudioCodec != "" && a.CodecName != target.AudioCodec {
+            mismatch("audio codec", a.CodecName, target.AudioCodec)
+        }
+        if target.SampleRate > 0 && a.SampleRate != target.SampleRate {
+            mismatch("sample rate", a.SampleRate, target.SampleRate)
+        }
+        if target.Channels > 0 && a.Channels != target.Channels {
+            mismatch("channel count", a.Channels, target.Channels)
+        }
+        if loudness != nil && target.Loudness != 0 && math.Abs(loudness.Integrated-target.Loudness) > loudnessTolerance {
+            mismatch("integrated loudness", fmt.Sprintf("%.1f LUFS", loudness.Integrated), fmt.Sprintf("%.1f LUFS", target.Loudness))
+        }
+        if loudness != nil && target.TruePeak != 0 && loudness.TruePeak > target.TruePeak {
+            mismatch("true peak", fmt.Sprintf("%.1f dBTP", loudness.TruePeak), fmt.Sprintf("at most %.1f dBTP", target.TruePeak))
+        }
+    }
+
+    if target.MaxDuration > 0 && output.Duration.Seconds() > target.MaxDuration {
+        mismatch("duration", fmt.Sprintf("%.2fs", output.Duration.Seconds()), fmt.Sprintf("at most %gs", target.MaxDuration))
+    }
+    return issues
+}
+
+// ebur128 summary lines, e.g. "    I:         -23.0 LUFS" and "    Peak:       -1.2 dBFS".
+var (
+    integratedLoudnessRe = regexp.MustCompile(`I:\s+(-?[\d.]+|-inf) LUFS`)
+    truePeakRe           = regexp.MustCompile(`Peak:\s+(-?[\d.]+|-inf) dBFS`)
+)
+
+// measureLoudness runs the ebur128 filter over the audio of a local file.
+func (r *Runner) measureLoudness(ctx context.Context, path string) (*loudnessInfo, error) {
+    cmd := exec.CommandContext(ctx, r.cfg.FFBin, "-hide_banner", "-nostats", "-i", path, "-vn", "-af", "ebur128=peak=true", "-f", "null", "-")
+    out, err := cmd.CombinedOutput()
+    if err != nil {
+        return nil, fmt.Errorf("ffmpeg failed: %w", err)
+    }
+    return parseLoudness(string(out))
+}
+
+// parseLoudness reads the summary the ebur128 filter logs at the end; the
+// per-frame lines before it report running values.
+func parseLoudness(output string) (*loudnessInfo, error) {
+    integrated := integratedLoudnessRe.FindAllStringSubmatch(output, -1)
+    if len(integrated) == 0 {
+        return nil, fmt.Errorf("no loudness summary in ffmpeg output")
+    }
+    info := &loudnessInfo{Integrated: parseDecibels(integrated[len(integrated)-1][1]), TruePeak: math.Inf(-1)}
+    if peaks := truePeakRe.FindAllStringSubmatch(output, -1); len(peaks) > 0 {
+        info.TruePeak = parseDecibels(peaks[len(peaks)-1][1])
+    }
+    return info, nil
+}
+
+func parseDecibels(s string) float64 {
+    v, err := strconv.ParseFloat(s, 64)
+    if err != nil {
+        return math.Inf(-1) // "-inf" for silence
+    }
+    return v
+}
+
+// parseFrameRate parses ffprobe's rational frame rates, e.g. "30000/1001".
+func parseFrameRate(s string) float64 {
+    num, den, ok := strings.Cut(s, "/")
+    n, err := strconv.ParseFloat(num, 64)
+    if err != nil {
+        return 0
+    }
+    if !ok {
+        return n
+    }
+    d, err := strconv.ParseFloat(den, 64)
+    if err != nil || d == 0 {
+        return 0
+    }
+    return n / d
+}
+
+// checkOutput lists what looks wrong with an output. input may be nil if it
+// could not be probed; multiOutput commands map streams themselves.
+func checkOutput(input, output *mediaInfo, args []string, kind utils.MediaKind, multiOutput bool, tolerance float64) []string {
+    var issues []string
+    if len(output.Streams) == 0 {
+        return []string{"output has no streams"}
+    }
+    if kind != utils.MediaKindImage && output.BitRate <= 0 {
+        issues = append(issues, "output has zero bitrate")
+    }
+    if input == nil {
+        return issues
+    }
+
+    // Explicit stream mapping makes the expected streams unknowable.
+    if !multiOutput && !hasArg(args, "-map") && !hasArg(args, "-filter_complex") {
+        if kind == utils.MediaKindVideo && input.has("video") && !hasArg(args, "-vn") && !output.has("video") {
+            issues = append(issues, "output has no video stream although the input has one")
+        }
+        if kind != utils.MediaKindImage && input.has("audio") && !hasArg(args, "-an") && !output.has("audio") {
+            issues = append(issues, "output has no audio stream although the input has one")
+        }
+    }
+
+    if kind != utils.MediaKindImage && input.Duration > 0 && !changesTiming(args) {
+        expected := input.Duration
+        if est, err := EstimateOutput(args); err == nil && est.Duration > 0 && est.Duration < expected {
+            expected = est.Duration // Bounded by -t
+        }
+        allowed := time.Duration(float64(expected) * tolerance)
+        if allowed < minDurationTolerance {
+            allowed = minDurationTolerance
+        }
+        diff := output.Duration - expected
+        if diff < 0 {
+            diff = -diff
+        }
+        if diff > allowed {
+            issues = append(issues, fmt.Sprintf("output duration %.2fs differs from the expected %.2fs", output.Duration.Seconds(), expected.Seconds()))
+        }
+    }
+    return issues
+}
+
+// changesTiming reports whether a command cuts or retimes its input, in which
+// case the output duration cannot be predicted.
+func changesTiming(args []string) bool {
+    for i, arg := range args {
+        switch arg {
+        case "-ss", "-sseof", "-to", "-frames:v", "-vframes", "-frames:a", "-aframes":
+            return true
+        case "-vf", "-af", "-filter:v", "-filter:a", "-filter_complex":
+            if i+1 < len(args) {
+                for _, f := range timingFilters {
+                    if strings.Contains(args[i+1], f) {
+                        return true
+                    }
+                }
+            }
+        }
+    }
+    return false
+}
+
+func hasArg(args []string, name string) bool {
+    for _, arg := range args {
+        if arg == name {
+            return true
+        }
+    }
+    return false
+}
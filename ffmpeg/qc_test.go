@@ -0,0 +1,108 @@
+package ffmpeg
+
+import (
+	"testing"
+	"time"
+
+	"ffwebapi/task"
+	"ffwebapi/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckOutput(t *testing.T) {
+	input := &mediaInfo{Duration: 60 * time.Second, BitRate: 2_000_000, Streams: []string{"video", "audio"}}
+	split := func(command string) []string {
+		args, _ := SplitCommand(command)
+		return args
+	}
+
+	t.Run("Good output", func(t *testing.T) {
+		output := &mediaInfo{Duration: 60*time.Second + 40*time.Millisecond, BitRate: 1_000_000, Streams: []string{"video", "audio"}}
+		assert.Empty(t, checkOutput(input, output, split("-i in.mkv -c:v libx264"), utils.MediaKindVideo, false, 0.05))
+	})
+
+	t.Run("Silent and truncated output", func(t *testing.T) {
+		output := &mediaInfo{Duration: 12 * time.Second, BitRate: 1_000_000, Streams: []string{"video"}}
+		assert.Equal(t, []string{
+			"output has no audio stream although the input has one",
+			"output duration 12.00s differs from the expected 60.00s",
+		}, checkOutput(input, output, split("-i in.mkv -c:v libx264"), utils.MediaKindVideo, false, 0.05))
+	})
+
+	t.Run("Dropped streams and cuts are expected", func(t *testing.T) {
+		output := &mediaInfo{Duration: 10 * time.Second, BitRate: 128_000, Streams: []string{"audio"}}
+		assert.Empty(t, checkOutput(input, output, split("-i in.mkv -vn -t 10"), utils.MediaKindAudio, false, 0.05))
+		assert.Empty(t, checkOutput(input, output, split("-ss 30 -i in.mkv -c:a aac"), utils.MediaKindAudio, false, 0.05))
+	})
+
+	t.Run("Empty output", func(t *testing.T) {
+		assert.Equal(t, []string{"output has no streams"}, checkOutput(input, &mediaInfo{}, nil, utils.MediaKindVideo, false, 0.05))
+		output := &mediaInfo{Duration: 60 * time.Second, Streams: []string{"video", "audio"}}
+		assert.Equal(t, []string{"output has zero bitrate"}, checkOutput(nil, output, nil, utils.MediaKindVideo, false, 0.05))
+	})
+
+	t.Run("Images skip duration and bitrate", func(t *testing.T) {
+		output := &mediaInfo{Streams: []string{"video"}}
+		assert.Empty(t, checkOutput(input, output, split("-i in.mkv -frames:v 1"), utils.MediaKindImage, false, 0.05))
+	})
+}
+
+func TestCheckTarget(t *testing.T) {
+	target := &task.ConformanceTarget{
+		Name: "reel", VideoCodec: "h264", Profiles: []string{"High", "Main"}, MaxLevel: 42, PixelFormat: "yuv420p",
+		Width: 1080, Height: 1920, FrameRate: 30, AudioCodec: "aac", SampleRate: 48000, Channels: 2,
+		Loudness: -14, TruePeak: -1, MaxDuration: 90,
+	}
+	good := &mediaInfo{
+		Duration: 60 * time.Second,
+		Video:    &streamInfo{CodecName: "h264", Profile: "High", Level: 40, PixFmt: "yuv420p", Width: 1080, Height: 1920, FrameRate: 30},
+		Audio:    &streamInfo{CodecName: "aac", SampleRate: 48000, Channels: 2},
+	}
+	assert.Empty(t, checkTarget(target, good, &loudnessInfo{Integrated: -14.4, TruePeak: -1.6}))
+
+	bad := &mediaInfo{
+		Duration: 120 * time.Second,
+		Video:    &streamInfo{CodecName: "h264", Profile: "High 10", Level: 51, PixFmt: "yuv420p10le", Width: 1920, Height: 1080, FrameRate: 30000.0 / 1001},
+		Audio:    &streamInfo{CodecName: "aac", SampleRate: 44100, Channels: 6},
+	}
+	assert.Equal(t, []string{
+		"video profile is High 10, reel requires High or Main",
+		"video level is 51, reel requires at most 42",
+		"pixel format is yuv420p10le, reel requires yuv420p",
+		"frame size is 1920x1080, reel requires 1080x1920",
+		"frame rate is 29.97002997002997, reel requires 30",
+		"sample rate is 44100, reel requires 48000",
+		"channel count is 6, reel requires 2",
+		"integrated loudness is -23.0 LUFS, reel requires -14.0 LUFS",
+		"true peak is -0.2 dBTP, reel requires at most -1.0 dBTP",
+		"duration is 120.00s, reel requires at most 90s",
+	}, checkTarget(target, bad, &loudnessInfo{Integrated: -23, TruePeak: -0.2}))
+
+	audioOnly := &mediaInfo{Duration: 10 * time.Second, Audio: good.Audio}
+	assert.Equal(t, []string{"output has no video stream, reel requires h264"}, checkTarget(target, audioOnly, nil))
+}
+
+func TestParseLoudness(t *testing.T) {
+	output := `[Parsed_ebur128_0 @ 0x5581] t: 0.4 TARGET:-23 LUFS    M: -25.1 S:-120.7     I: -25.1 LUFS       LRA:   0.0 LU  FTPK: -5.0 dBFS  TPK: -5.0 dBFS
+[Parsed_ebur128_0 @ 0x5581] Summary:
+
+  Integrated loudness:
+    I:         -16.2 LUFS
+    Threshold: -26.5 LUFS
+
+  Loudness range:
+    LRA:         4.1 LU
+
+  True peak:
+    Peak:       -1.4 dBFS
+`
+	info, err := parseLoudness(output)
+	require.NoError(t, err)
+	assert.Equal(t, &loudnessInfo{Integrated: -16.2, TruePeak: -1.4}, info)
+
+	_, err = parseLoudness("Output file is empty, nothing was encoded")
+	assert.Error(t, err)
+	assert.Equal(t, 30000.0/1001, parseFrameRate("30000/1001"))
+	assert.Equal(t, 0.0, parseFrameRate("0/0"))
+}
@@ -1,28 +1,45 @@
 package ffmpeg
 
 import (
-    "bytes"
     "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
     "fmt"
+    "hash"
     "io"
-    "log"
-    "net/http"
+    "log/slog"
+    "math"
+    "net/url"
     "os"
     "os/exec"
     "path/filepath"
+    "strconv"
     "strings"
     "time"
 
     "ffwebapi/config"
+    "ffwebapi/logging"
+    "ffwebapi/netguard"
+    "ffwebapi/storage"
     "ffwebapi/task"
-    "github.com/shirou/gopsutil/v3/cpu"
-    "github.com/shirou/gopsutil/v3/disk"
-    "github.com/shirou/gopsutil/v3/mem"
+    "ffwebapi/tracing"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/trace"
 )
 
 type Runner struct {
-    cfg     *config.Config
-    tempDir string
+    cfg            *config.Config
+    tempDir        string
+    workRoot       string          // Parent of the per-task working directories
+    isolation      *isolation
+    isolationLevel string
+    inputs         storage.Backend         // Uploaded inputs, referenced as input://<id>
+    encoders       map[string]bool         // Encoders of the ffmpeg build; nil if unknown
+    classes        map[string]cgroupLimits // RESOURCE_CLASSES by lowercase name
+    hardware       []string                // Kinds of hardware detected at startup
+    hwProfile      *hwProfile              // HW_PROFILES entry applied to ffmpeg commands; nil if none
+    inputCache     *inputCache             // Nil unless INPUT_CACHE_MAX_SIZE is set
 }
 
 func NewRunner(cfg *config.Config) (*Runner, error) {
@@ -30,36 +47,147 @@ func NewRunner(cfg *config.Config) (*Runner, error) {
     if _, err := exec.LookPath(cfg.FFBin); err != nil {
         return nil, fmt.Errorf("ffmpeg binary not found or not in PATH: %s", cfg.FFBin)
     }
+    if _, err := exec.LookPath(cfg.FFProbeBin); err != nil {
+        slog.Warn("ffprobe binary not found; input probing is disabled", "ffprobe", cfg.FFProbeBin)
+    }
+    if err := checkToolsConfig(cfg); err != nil {
+        return nil, err
+    }
+    if err := checkSandboxConfig(cfg); err != nil {
+        return nil, err
+    }
+    classes, err := checkCgroupConfig(cfg)
+    if err != nil {
+        return nil, err
+    }
+    for _, name := range EnabledTools(cfg) {
+        tool, _ := LookupTool(cfg, name)
+        if _, err := exec.LookPath(tool.bin(cfg)); err != nil {
+            slog.Warn("Tool binary not found; its tasks will fail", "tool", name, "bin", tool.bin(cfg))
+        }
+    }
+    for name, bin := range cfg.FFBuilds {
+        if _, err := exec.LookPath(bin); err != nil {
+            slog.Warn("ffmpeg build not found; piped tasks using it will fail", "build", name, "bin", bin)
+        }
+    }
 
     // Create and set a temporary directory for all I/O
     tempDir, err := os.MkdirTemp("", "ffwebapi_")
     if err != nil {
         return nil, fmt.Errorf("could not create temp directory: %w", err)
     }
-    log.Printf("Using temporary directory: %s", tempDir)
+    slog.Info("Using temporary directory", "dir", tempDir)
     cfg.TempDir = tempDir
 
-    return &Runner{
-        cfg:     cfg,
-        tempDir: tempDir,
-    }, nil
+    iso, err := newIsolation(cfg)
+    if err != nil {
+        return nil, err
+    }
+
+    // With a dedicated runtime user the child must be able to traverse (but not
+    // list) the temp dir to reach its own working directory.
+    dirMode := os.FileMode(0o700)
+    if iso.base != nil {
+        dirMode = 0o711
+    }
+    if err := os.Chmod(tempDir, dirMode); err != nil {
+        return nil, err
+    }
+    workRoot := filepath.Join(tempDir, "work")
+    if err := os.Mkdir(workRoot, dirMode); err != nil {
+        return nil, fmt.Errorf("could not create work directory root: %w", err)
+    }
+    // Mkdir is subject to the umask, so set the mode explicitly.
+    if err := os.Chmod(workRoot, dirMode); err != nil {
+        return nil, err
+    }
+
+    inputs, err := storage.New(cfg)
+    if err != nil {
+        return nil, err
+    }
+
+    r := &Runner{
+        cfg:       cfg,
+        tempDir:   tempDir,
+        workRoot:  workRoot,
+        isolation: iso,
+        inputs:    inputs,
+        encoders:  detectEncoders(cfg.FFBin),
+        classes:   classes,
+    }
+    if err := r.setupHardware(); err != nil {
+        return nil, err
+    }
+    if cfg.InputCacheSize > 0 {
+        if r.inputCache, err = newInputCache(tempDir, cfg.InputCacheSize); err != nil {
+            return nil, err
+        }
+    }
+    r.isolationLevel = r.selfCheck()
+    return r, nil
 }
 
-// Run executes an ffmpeg command for a given task.
+// Run executes the command of a task with ffmpeg or the task's tool, then
+// uploads the output if the task asked for it.
 // It returns the combined stdout/stderr and an error.
 func (r *Runner) Run(ctx context.Context, t *task.Task) (string, error) {
-    // 1. Check system resources before starting
-    if err := r.checkResources(); err != nil {
-        return "", fmt.Errorf("insufficient system resources: %w", err)
+    outputLog, err := r.run(ctx, t)
+    if err == nil && t.OutputUpload != nil && t.OutputPath != "" {
+        r.uploadOutput(ctx, t)
+    }
+    return outputLog, err
+}
+
+func (r *Runner) run(ctx context.Context, t *task.Task) (string, error) {
+    tool, ok := LookupTool(r.cfg, t.Tool)
+    if !ok {
+        return "", fmt.Errorf("tool %q is not enabled", t.Tool)
     }
 
-    // 2. Prepare input file
-    inputPath, cleanupInput, err := r.prepareInput(ctx, t.InputMedia, t.ID)
+    // 1. Check system resources before starting, unless the manager paces
+    // the tasks to the load
+    if !r.cfg.AdaptiveConcurrency {
+        if err := r.checkResources(); err != nil {
+            return "", fmt.Errorf("%w: %v", task.ErrInsufficientResources, err)
+        }
+    }
+
+    // 2. Prepare a private working directory and the input file inside it
+    id, releaseID, err := r.isolation.lease()
+    if err != nil {
+        return "", err
+    }
+    defer releaseID()
+    workDir, err := makeWorkDir(r.workRoot, t.ID, id)
+    if err != nil {
+        return "", err
+    }
+    defer os.RemoveAll(workDir)
+
+    _, span := tracing.Tracer().Start(ctx, "input.download", trace.WithAttributes(attribute.Bool("input.remote", netguard.IsURL(t.InputMedia))))
+    var digest hash.Hash
+    if t.Dedupes() {
+        digest = sha256.New()
+    }
+    inputPath, cleanupInput, err := r.prepareInput(ctx, t.InputMedia, workDir, id, digest)
+    if err == nil {
+        if info, statErr := os.Stat(inputPath); statErr == nil {
+            span.SetAttributes(attribute.Int64("input.size", info.Size()))
+        }
+    }
+    tracing.End(span, err)
     if err != nil {
         return "", fmt.Errorf("failed to prepare input: %w", err)
     }
     defer cleanupInput()
-    t.InputPath = inputPath
+    t.Update(func() { t.InputPath = inputPath })
+    if digest != nil {
+        if src := t.FindDuplicate(hex.EncodeToString(digest.Sum(nil))); src != nil {
+            return "", r.reuseOutput(ctx, t, src)
+        }
+    }
 
     // 3. Prepare command
     // First split the command, then substitute the placeholder.
@@ -80,129 +208,542 @@ func (r *Runner) Run(ctx context.Context, t *task.Task) (string, error) {
     if !foundPlaceholder {
         return "", fmt.Errorf("could not find placeholder %s in command", InputMediaPlaceholder)
     }
+    inputPaths := []string{inputPath}
+    for i, media := range t.ExtraInputs {
+        path, cleanup, err := r.prepareInput(ctx, media, workDir, id, nil)
+        if err != nil {
+            return "", fmt.Errorf("failed to prepare input %d: %w", i+1, err)
+        }
+        defer cleanup()
+        inputPaths = append(inputPaths, path)
+        placeholder := ExtraInputPlaceholder(i + 1)
+        for j, arg := range args {
+            if arg == placeholder {
+                args[j] = path
+            }
+        }
+    }
+    // Concat tasks join their inputs without re-encoding when the probed
+    // streams allow it.
+    var concatDuration time.Duration
+    if t.Concat {
+        var concatMethod string
+        if args, concatMethod, concatDuration, err = r.concatArgs(ctx, t, inputPaths, workDir, id); err != nil {
+            return "", err
+        }
+        t.Update(func() { t.ConcatMethod = concatMethod })
+    }
+    // A piped task's output is written by its second stage, reading what
+    // the command writes to stdout.
+    var pipeArgs []string
+    if t.Pipe != nil {
+        if pipeArgs, err = PipeArgs(t.Pipe.Command); err != nil {
+            return "", err
+        }
+        args = append(args, pipeWriteEnd)
+    }
+    // The node's hardware profile goes first, so its encoders are not
+    // replaced by software fallbacks. Only FF_BIN's encoders are known.
+    var substitutions []task.CodecSubstitution
+    hwProfile := ""
+    if r.hwProfile != nil && tool.Name == ToolFFmpeg && t.Pipe == nil {
+        args, substitutions = r.hwProfile.apply(args, r.encoders)
+        hwProfile = r.hwProfile.kind
+    }
+    if r.encoders != nil && tool.Name == ToolFFmpeg && t.Pipe == nil {
+        var subs []task.CodecSubstitution
+        args, subs = SubstituteEncoders(args, t.OutputExt, r.encoders)
+        for _, sub := range subs {
+            slog.Warn("Encoder not available, using fallback", "task_id", t.ID, "requested", sub.Requested, "used", sub.Used)
+        }
+        substitutions = append(substitutions, subs...)
+    }
+    t.Update(func() { t.CodecSubstitutions, t.HWProfile = substitutions, hwProfile })
 
+    // The input's streams give single-output commands their labels, and
+    // tell whether the output drops the input's transparency.
+    var alphaLoss string
+    if tool.Name == ToolFFmpeg && t.OutputMode != task.OutputModeDirectory && len(t.OutputExts) == 0 && t.Pipe == nil {
+        streams, err := r.probeStreams(ctx, inputPath)
+        if err != nil {
+            logging.FromContext(ctx).Warn("Input stream labels are not carried over", "error", err)
+        }
+        args = append(args, streamLabelArgs(t, args, streams)...)
+        if inputHasAlpha(streams) {
+            alphaLoss = AlphaLoss(args, t.OutputExt)
+        }
+    }
 
-    // 4. Prepare output path
-    outputFilename := fmt.Sprintf("%s_output.%s", t.ID, t.OutputExt)
-    outputPath := filepath.Join(r.tempDir, outputFilename)
-    t.OutputPath = outputPath
-    args = append(args, outputPath) // FFMpeg's last argument is the output file
+    // 4. Prepare output paths. ffmpeg writes .part files in the working
+    // directory; they are only renamed to where they are served from once
+    // ffmpeg succeeded and the output passed QC, so no download can ever
+    // start on a file that is still being written.
+    var outputFilenames, workOutputs []string
+    stopWatching := func() {}
+    switch {
+    case t.OutputMode == task.OutputModeDirectory:
+        // The whole directory gets published; its entry point is index.<ext>
+        // unless the command places files itself via ${OUTPUT_DIR}.
+        dirName := task.ArtifactOutput
+        workOutputDir, err := makeWorkDir(workDir, partName(dirName), id)
+        if err != nil {
+            return "", err
+        }
+        outputFilenames = []string{dirName}
+        workOutputs = []string{workOutputDir}
+        usesDir := false
+        for i, arg := range args {
+            if strings.Contains(arg, OutputDirPlaceholder) {
+                args[i] = strings.ReplaceAll(arg, OutputDirPlaceholder, workOutputDir)
+                usesDir = true
+            }
+        }
+        if !usesDir {
+            args = append(args, filepath.Join(workOutputDir, directoryEntry(t)))
+        }
+        if len(t.Renditions) > 0 {
+            stopWatching = r.watchRenditions(ctx, t, workOutputDir, inputPath)
+        }
+    case len(t.OutputExts) == 0:
+        outputFilenames = []string{fmt.Sprintf("%s.%s", task.ArtifactOutput, t.OutputExt)}
+        workOutputs = []string{filepath.Join(workDir, partName(outputFilenames[0]))}
+        if t.Pipe != nil {
+            pipeArgs = append(pipeArgs, workOutputs[0])
+        } else {
+            args = tool.placeOutput(args, workOutputs[0]) // FFMpeg's last argument is the output file
+        }
+    default:
+        // Multi-output commands place their outputs themselves via ${OUTPUT_n}.
+        for i, ext := range t.OutputExts {
+            outputFilenames = append(outputFilenames, fmt.Sprintf("%s_%d.%s", task.ArtifactOutput, i, ext))
+            workOutputs = append(workOutputs, filepath.Join(workDir, partName(outputFilenames[i])))
+        }
+        for i, arg := range args {
+            args[i] = outputPlaceholderRe.ReplaceAllStringFunc(arg, func(m string) string {
+                n, _ := strconv.Atoi(outputPlaceholderRe.FindStringSubmatch(m)[1])
+                return workOutputs[n]
+            })
+        }
+    }
 
     // 5. Execute command
-    cmd := exec.CommandContext(ctx, r.cfg.FFBin, args...)
-    var outputBuf bytes.Buffer
-    cmd.Stdout = &outputBuf
-    cmd.Stderr = &outputBuf
+    bin := tool.bin(r.cfg)
+    if t.Pipe != nil {
+        var ok bool
+        if bin, ok = LookupBuild(r.cfg, t.Pipe.FirstBuild); !ok {
+            stopWatching()
+            return "", fmt.Errorf("ffmpeg build %q is not configured", t.Pipe.FirstBuild)
+        }
+    }
+    if tool.Name == ToolFFmpeg {
+        args = restrictProtocols(args)
+    }
+    argv := sandboxCommand(r.cfg, bin, args, workDir)
+    cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+    cmd.Dir = workDir
+    setCredential(cmd, id)
+    var cg *taskCgroup
+    if r.cfg.CgroupRoot != "" {
+        if cg, err = newTaskCgroup(r.cfg.CgroupRoot, t.ID, r.limitsFor(t)); err != nil {
+            stopWatching()
+            return "", err
+        }
+        defer cg.remove()
+        cg.attach(cmd)
+    }
+    // ffmpeg's progress lines against the input's duration give the task's
+    // percent and ETA while it runs.
+    var expected time.Duration
+    if t.Concat {
+        expected = concatDuration
+    } else if tool.Name == ToolFFmpeg {
+        if duration, err := r.Probe(ctx, inputPath); err == nil {
+            expected = expectedDuration(args, duration)
+        }
+    }
+    t.Update(func() { t.Percent, t.ETA = 0, 0 })
+    // Only the tail of the output stays in memory; all of it goes to the
+    // task's log file, where the logs endpoint can read it while ffmpeg runs.
+    outputBuf := &progressWriter{limit: int(r.cfg.LogBufferSize), onTime: trackProgress(t, expected, time.Now())}
+    if logFile, err := r.createLog(t); err != nil {
+        logging.FromContext(ctx).Warn("Could not create the task log; only its tail is kept", "error", err)
+    } else if logFile != nil {
+        defer logFile.Close()
+        outputBuf.log = logFile
+    }
+    cmd.Stdout = outputBuf
+    cmd.Stderr = outputBuf
+    var pipe *pipeStage
+    if t.Pipe != nil {
+        if pipe, err = r.newPipeStage(ctx, t, cmd, pipeArgs, workDir, id, cg); err != nil {
+            stopWatching()
+            return "", err
+        }
+    }
 
-    log.Printf("Executing for task %s: %s %s", t.ID, cmd.Path, strings.Join(cmd.Args, " "))
+    logging.FromContext(ctx).Info("Executing command", "tool", tool.Name, "path", cmd.Path, "args", strings.Join(cmd.Args[1:], " "))
+    if pipe != nil {
+        logging.FromContext(ctx).Info("Executing stage 2", "path", pipe.cmd.Path, "args", strings.Join(pipe.cmd.Args[1:], " "))
+    }
 
-    err = cmd.Run()
+    _, span = tracing.Tracer().Start(ctx, "ffmpeg.exec", trace.WithAttributes(attribute.String("ffmpeg.isolation", r.isolationLevel), attribute.String("ffmpeg.tool", tool.Name), attribute.String("ffmpeg.sandbox", r.cfg.FFSandbox)))
+    if err = cmd.Start(); err == nil && pipe != nil {
+        if err = pipe.start(); err != nil {
+            cmd.Process.Kill()
+            cmd.Wait()
+        }
+    } else if pipe != nil {
+        pipe.close()
+    }
+    if err == nil {
+        stopSampling := r.watchProcessTree(t, cmd.Process.Pid)
+        limit := r.maxOutputSize(t)
+        stopSizeWatch := watchOutputSize(workOutputs, limit, func() {
+            cmd.Process.Kill()
+            if pipe != nil {
+                pipe.kill()
+            }
+        })
+        err = cmd.Wait()
+        if pipe != nil {
+            err = pipe.wait(err)
+        }
+        stopSampling()
+        if stopSizeWatch() {
+            err = &task.CodedError{Code: task.ErrorCodeOutputTooLarge, Err: fmt.Errorf("output exceeded the maximum output size of %d bytes", limit)}
+        }
+    }
+    stopWatching()
+    tracing.End(span, err)
     outputLog := outputBuf.String()
-
-    if err != nil {
-        // If the command failed, clean up the (likely empty or partial) output file.
-        os.Remove(outputPath)
-        t.OutputPath = ""
-        return outputLog, fmt.Errorf("ffmpeg execution failed: %w", err)
+    if outputBuf.logErr != nil {
+        logging.FromContext(ctx).Warn("Could not write the task log; only its tail is kept", "error", outputBuf.logErr)
+    }
+    t.Update(func() {
+        if cmd.ProcessState != nil {
+            // Failed attempts count towards the owner's CPU quota too.
+            t.CPUSeconds += (cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()).Seconds()
+        }
+        if pipe != nil {
+            t.CPUSeconds += pipe.cpuSeconds()
+            t.PipeOutput = pipe.output.String()
+        }
+    })
+    if pipe != nil {
+        if pipe.output.logErr != nil {
+            logging.FromContext(ctx).Warn("Could not write the log of stage 2; only its tail is kept", "error", pipe.output.logErr)
+        }
     }
 
-    return outputLog, nil
-}
-
-// prepareInput downloads, decodes, or copies the input media to a local temporary file.
-// It returns the path to the temp file, a cleanup function, and an error.
-func (r *Runner) prepareInput(ctx context.Context, inputMedia string, taskID string) (string, func(), error) {
-    // Create a unique temporary file for the input
-    tmpFile, err := os.CreateTemp(r.tempDir, fmt.Sprintf("%s_input_*", taskID))
+    if err != nil && cg != nil && cg.oomKilled() {
+        err = fmt.Errorf("%w (memory limit of %d bytes exceeded)", err, r.limitsFor(t).memory)
+    }
     if err != nil {
-        return "", func() {}, err
+        t.Update(func() {
+            // The (likely empty or partial) output file goes away with the working directory.
+            t.OutputPath, t.ETA = "", 0
+            t.Warnings, t.QCReport, t.MediaDuration = nil, nil, 0
+        })
+        return outputLog, fmt.Errorf("%s execution failed: %w", tool.Name, err)
     }
-    
-    cleanup := func() {
-        tmpFile.Close()
-        os.Remove(tmpFile.Name())
+    var warnings []task.Warning
+    if tool.Name == ToolFFmpeg {
+        warnings = r.parseWarnings(t, outputBuf)
+        if alphaLoss != "" {
+            warnings = append(warnings, task.Warning{Code: WarningAlphaDropped, Message: alphaLoss, Count: 1})
+        }
     }
+    t.Update(func() {
+        t.Percent, t.ETA = 100, 0
+        if tool.Name == ToolFFmpeg {
+            t.Warnings, t.MediaDuration = warnings, ProcessedDuration(outputLog)
+        }
+    })
 
-    // Handle different input types
-    if strings.HasPrefix(inputMedia, "http://") || strings.HasPrefix(inputMedia, "https://") {
-        // Input is a URL
-        req, _ := http.NewRequestWithContext(ctx, "GET", inputMedia, nil)
-        resp, err := http.DefaultClient.Do(req)
+    if t.Subtitles != "" {
+        // Subtitle-only tasks produce the audio to transcribe as their output.
+        audioPath := ""
+        if t.SubtitlesOnly {
+            audioPath = workOutputs[0]
+        }
+        _, span = tracing.Tracer().Start(ctx, "subtitles.transcribe")
+        subtitlePath, err := r.generateSubtitles(ctx, t, workDir, inputPath, audioPath, id)
+        tracing.End(span, err)
         if err != nil {
-            return "", cleanup, err
+            return outputLog, fmt.Errorf("subtitle generation failed: %w", err)
+        }
+        t.Update(func() {
+            t.SubtitlePath = subtitlePath
+            if t.SubtitlesOnly {
+                t.OutputPath = subtitlePath
+                t.AddArtifact(task.ArtifactOutput, task.ArtifactSubtitles, subtitlePath)
+                return
+            }
+            t.AddArtifact(task.ArtifactSubtitles, task.ArtifactSubtitles, subtitlePath)
+        })
+        if t.SubtitlesOnly {
+            return outputLog, nil
         }
-        defer resp.Body.Close()
+    }
 
-        if resp.StatusCode != http.StatusOK {
-            return "", cleanup, fmt.Errorf("failed to download file, status: %s", resp.Status)
+    // 6. Optionally verify the output, since ffmpeg happily exits 0 after
+    // writing an empty or audio-less file. Outputs failing in "fail" mode are
+    // never published.
+    t.Update(func() {
+        t.OutputPath = workOutputs[0]
+        if t.OutputMode == task.OutputModeDirectory {
+            t.OutputPath = filepath.Join(workOutputs[0], directoryEntry(t))
         }
-        
-        // Use a LimitedReader to enforce max input size
-        limitedReader := &io.LimitedReader{R: resp.Body, N: r.cfg.MaxInputSize + 1}
-        written, err := io.Copy(tmpFile, limitedReader)
-        if err != nil {
-            return "", cleanup, fmt.Errorf("failed to write downloaded file: %w", err)
+    })
+    if t.OutputMode == task.OutputModeDirectory {
+        // Sidecar files are published together with the directory.
+        for name, data := range t.ExtraFiles {
+            if err := os.WriteFile(filepath.Join(workOutputs[0], filepath.Base(name)), data, 0o600); err != nil {
+                return outputLog, fmt.Errorf("could not write %s: %w", name, err)
+            }
         }
-        if written > r.cfg.MaxInputSize {
-            return "", cleanup, fmt.Errorf("input file size exceeds limit of %d bytes", r.cfg.MaxInputSize)
+    }
+    if t.QC != "" {
+        _, span = tracing.Tracer().Start(ctx, "output.qc")
+        report := r.verifyOutput(ctx, t, args, inputPath)
+        t.Update(func() { t.QCReport = report })
+        span.SetAttributes(attribute.Bool("qc.passed", t.QCReport.Passed))
+        span.End()
+        if !t.QCReport.Passed {
+            logging.FromContext(ctx).Warn("Output failed QC", "issues", t.QCReport.Issues, "mode", t.QC)
+            if t.QC == task.QCModeFail {
+                t.Update(func() { t.OutputPath = "" })
+                return outputLog, fmt.Errorf("output failed QC: %s", strings.Join(t.QCReport.Issues, "; "))
+            }
         }
+    }
 
-    } else if strings.HasPrefix(inputMedia, "data:") {
-        // Input is a data URI - not implemented for brevity, but this is where it would go
-        return "", cleanup, fmt.Errorf("data URI inputs are not yet supported")
+    // 7. Publish the outputs, each with a single rename.
+    kind := t.ArtifactKind
+    if kind == "" {
+        kind = task.ArtifactOutput
+    }
+    filesDir, err := r.filesDir(t)
+    if err != nil {
+        t.Update(func() { t.OutputPath = "" })
+        return outputLog, err
+    }
+    var outputPaths []string
+    for i, name := range outputFilenames {
+        outputPath := filepath.Join(filesDir, name)
+        if err := reclaim(workOutputs[i], id); err != nil {
+            t.Update(func() { t.OutputPath = "" })
+            return outputLog, fmt.Errorf("could not take over output file: %w", err)
+        }
+        if err := os.Rename(workOutputs[i], outputPath); err != nil {
+            t.Update(func() { t.OutputPath = "" })
+            return outputLog, fmt.Errorf("could not publish output file: %w", err)
+        }
+        outputPaths = append(outputPaths, outputPath)
+        t.Update(func() {
+            switch {
+            case t.OutputMode == task.OutputModeDirectory:
+                t.AddArtifact(task.ArtifactOutput, kind, filepath.Join(outputPath, directoryEntry(t))).Dir = outputPath
+            case len(t.OutputExts) > 0:
+                t.AddArtifact(fmt.Sprintf("%s_%d", task.ArtifactOutput, i), kind, outputPath)
+            default:
+                t.AddArtifact(task.ArtifactOutput, kind, outputPath)
+            }
+        })
+    }
+    segments := make([]int, len(t.Renditions))
+    for i, rp := range t.Renditions {
+        matches, _ := filepath.Glob(filepath.Join(outputPaths[0], rp.SegmentPattern))
+        segments[i] = len(matches)
+    }
+    t.Update(func() {
+        t.OutputPath = outputPaths[0]
+        if t.OutputMode == task.OutputModeDirectory {
+            t.OutputDir = outputPaths[0]
+            t.OutputPath = filepath.Join(t.OutputDir, directoryEntry(t))
+            for name := range t.ExtraFiles {
+                t.AddArtifact(filepath.Base(name), kind, filepath.Join(t.OutputDir, filepath.Base(name))).Dir = t.OutputDir
+            }
+            for i := range t.Renditions {
+                t.Renditions[i].Segments, t.Renditions[i].Progress = segments[i], 1
+            }
+        } else if len(t.OutputExts) > 0 {
+            t.OutputPaths = outputPaths
+        }
+    })
 
-    } else {
-        // Assume input is a local file path
-        srcFile, err := os.Open(inputMedia)
-        if err != nil {
-            return "", cleanup, fmt.Errorf("could not open local input file: %w", err)
+    return outputLog, nil
+}
+
+// partName is the name an output is written under until it is published:
+// "output.part.mp4" for "output.mp4", keeping the extension ffmpeg picks the
+// muxer by, and "output.part" for an output directory.
+func partName(name string) string {
+    ext := filepath.Ext(name)
+    return strings.TrimSuffix(name, ext) + ".part" + ext
+}
+
+// directoryEntry is the name of the main file of a directory output, e.g. index.m3u8.
+func directoryEntry(t *task.Task) string {
+    if t.OutputEntry != "" {
+        return t.OutputEntry
+    }
+    return "index." + t.OutputExt
+}
+
+// watchRenditions updates the per-rendition progress of an ABR task by
+// counting the segments written so far, until the returned stop function is
+// called.
+func (r *Runner) watchRenditions(ctx context.Context, t *task.Task, outputDir, inputPath string) func() {
+    done, exited := make(chan struct{}), make(chan struct{})
+    go func() {
+        defer close(exited)
+        // Without a known duration only segment counts are reported.
+        duration, _ := r.Probe(ctx, inputPath)
+        ticker := time.NewTicker(2 * time.Second)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-done:
+                return
+            case <-ticker.C:
+            }
+            for i := range t.Renditions {
+                rp := &t.Renditions[i]
+                matches, _ := filepath.Glob(filepath.Join(outputDir, rp.SegmentPattern))
+                t.Update(func() {
+                    rp.Segments = len(matches)
+                    if duration > 0 && rp.SegmentDuration > 0 {
+                        rp.Progress = math.Min(1, float64(time.Duration(rp.Segments)*rp.SegmentDuration)/float64(duration))
+                    }
+                })
+            }
         }
-        defer srcFile.Close()
+    }()
+    return func() {
+        close(done)
+        <-exited
+    }
+}
 
-        // Check file size
-        info, err := srcFile.Stat()
+// prepareInput fetches the input media through the provider of its scheme
+// (see InputProvider) into a temporary file in the task's working directory,
+// readable by the task's identity.
+// The content is hashed into digest unless it is nil.
+// It returns the path to the temp file, a cleanup function, and an error.
+func (r *Runner) prepareInput(ctx context.Context, inputMedia string, workDir string, id *identity, digest hash.Hash) (string, func(), error) {
+    // Named sources are resolved only now, so their secrets stay out of the
+    // task, its logs and its errors.
+    fromSource := netguard.IsSourceRef(inputMedia)
+    if fromSource {
+        resolved, err := netguard.ResolveSource(r.cfg, inputMedia)
         if err != nil {
-            return "", cleanup, err
-        }
-        if info.Size() > r.cfg.MaxInputSize {
-            return "", cleanup, fmt.Errorf("input file size %d exceeds limit of %d bytes", info.Size(), r.cfg.MaxInputSize)
+            return "", func() {}, err
         }
+        inputMedia = resolved
+    }
 
-        if _, err := io.Copy(tmpFile, srcFile); err != nil {
-            return "", cleanup, fmt.Errorf("failed to copy local file: %w", err)
+    scheme := InputScheme(inputMedia)
+    provider, err := r.inputProvider(scheme)
+    if err != nil {
+        return "", func() {}, err
+    }
+    // Local files on trusted read-only mounts are read in place.
+    if _, builtin := provider.(localInputs); builtin {
+        if path, ok, err := zeroCopyPath(r.cfg, inputMedia); ok || err != nil {
+            if err == nil && digest != nil {
+                err = hashFile(path, digest)
+            }
+            return path, func() {}, err
         }
     }
+
+    // Create a unique temporary file for the input
+    tmpFile, err := os.CreateTemp(workDir, "input_*")
+    if err != nil {
+        return "", func() {}, err
+    }
+    var dst io.Writer = tmpFile
+    if digest != nil {
+        dst = io.MultiWriter(tmpFile, digest)
+    }
+    
+    cleanup := func() {
+        tmpFile.Close()
+        os.Remove(tmpFile.Name())
+    }
+
+    err = provider.Fetch(ctx, inputMedia, dst, r.cfg.MaxInputSize)
+    var urlErr *url.Error
+    if fromSource && errors.As(err, &urlErr) {
+        err = fmt.Errorf("failed to download input source: %w", urlErr.Err)
+    }
+    if err != nil {
+        return "", cleanup, err
+    }
     // Need to close here to ensure data is flushed before ffmpeg reads it
     if err := tmpFile.Close(); err != nil {
         return "", cleanup, err
     }
+    if err := chownTo(tmpFile.Name(), id); err != nil {
+        return "", cleanup, err
+    }
     return tmpFile.Name(), cleanup, nil
 }
 
-// checkResources verifies that the system has enough free resources to start a new job.
-func (r *Runner) checkResources() error {
-    // CPU
-    p, err := cpu.Percent(time.Second, false)
-    if err != nil {
-        log.Printf("Warning: could not get CPU usage: %v", err)
-    } else if len(p) > 0 && p[0] > (100.0 - r.cfg.ThrottleCPU) {
-        return fmt.Errorf("not enough idle CPU. Current usage: %.2f%%, Idle threshold: %.2f%%", p[0], r.cfg.ThrottleCPU)
+// createLog creates the file a task's output is streamed to, replacing that
+// of an earlier attempt. It returns nil without a TEMP_DIR.
+func (r *Runner) createLog(t *task.Task) (*os.File, error) {
+    if r.cfg.TempDir == "" {
+        return nil, nil
+    }
+    if _, err := r.filesDir(t); err != nil {
+        return nil, err
     }
+    return os.OpenFile(task.LogPath(r.cfg, t.ID), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+}
 
-    // Memory
-    vm, err := mem.VirtualMemory()
+// parseWarnings finds the known warnings in a command's output, reading the
+// log file if the output kept in memory is not complete.
+func (r *Runner) parseWarnings(t *task.Task, output *progressWriter) []task.Warning {
+    if !output.truncated() || output.log == nil || output.logErr != nil {
+        return ParseWarnings(output.String())
+    }
+    f, err := os.Open(task.LogPath(r.cfg, t.ID))
     if err != nil {
-        log.Printf("Warning: could not get memory usage: %v", err)
-    } else if vm.Available < uint64(r.cfg.ThrottleFreeMem) {
-        return fmt.Errorf("not enough free memory. Available: %d, Required: %d", vm.Available, r.cfg.ThrottleFreeMem)
+        return ParseWarnings(output.String())
+    }
+    defer f.Close()
+    warnings, _ := ScanWarnings(f)
+    return warnings
+}
+
+// filesDir creates the directory the task's files are published to.
+func (r *Runner) filesDir(t *task.Task) (string, error) {
+    dir := task.FilesDir(r.cfg, t.ID)
+    if err := os.MkdirAll(dir, 0o700); err != nil {
+        return "", fmt.Errorf("could not create files directory: %w", err)
     }
+    return dir, nil
+}
 
-    // Disk
-    d, err := disk.Usage(r.tempDir)
-    if err != nil {
-        log.Printf("Warning: could not get disk usage for %s: %v", r.tempDir, err)
-    } else if d.Free < uint64(r.cfg.ThrottleFreeDisk) {
-        return fmt.Errorf("not enough free disk space. Available: %d, Required: %d", d.Free, r.cfg.ThrottleFreeDisk)
+// checkResources verifies that the system has enough free resources to start a new job.
+func (r *Runner) checkResources() error {
+    return SampleResources(r.cfg, time.Second).Check(r.cfg)
+}
+
+// SampleLoad measures the host for ADAPTIVE_CONCURRENCY.
+func (r *Runner) SampleLoad() task.LoadSample {
+    u := SampleResources(r.cfg, time.Second)
+    s := task.LoadSample{CPUPercent: -1, MemoryAvailable: -1, DiskFree: -1}
+    if u.cpuKnown {
+        s.CPUPercent = u.CPUPercent
+    }
+    if u.memKnown {
+        s.MemoryAvailable = int64(u.MemoryAvailable)
+    }
+    if u.diskKnown {
+        s.DiskFree = int64(u.DiskFree)
     }
-    return nil
+    return s
 }
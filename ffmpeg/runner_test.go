@@ -0,0 +1,57 @@
+package ffmpeg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ffwebapi/config"
+	"ffwebapi/task"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFakeFFmpeg writes a script standing in for ffmpeg. It answers the
+// encoder listing NewRunner asks for with nothing, so scripts writing to their
+// last argument don't leave a file named "-encoders" in the package directory.
+func writeFakeFFmpeg(t *testing.T, path, script string) {
+	script = strings.Replace(script, "#!/bin/sh\n", "#!/bin/sh\n[ \"$2\" = -encoders ] && exit 0\n", 1)
+	require.NoError(t, os.WriteFile(path, []byte(script), 0o755))
+}
+
+func TestRunner_PublishesOutputOnlyWhenDone(t *testing.T) {
+	dir := t.TempDir()
+	// The fake ffmpeg records where it writes its output.
+	script := "#!/bin/sh\nfor out; do :; done\necho \"$out\" > " + filepath.Join(dir, "seen") + "\necho encoded > \"$out\"\n"
+	ffmpegBin := filepath.Join(dir, "ffmpeg")
+	writeFakeFFmpeg(t, ffmpegBin, script)
+	input := filepath.Join(dir, "input.mp4")
+	require.NoError(t, os.WriteFile(input, []byte("media"), 0o600))
+
+	cfg := &config.Config{FFBin: ffmpegBin, FFProbeBin: filepath.Join(dir, "ffprobe"), MaxConcurrency: 1, MaxInputSize: 1 << 20}
+	r, err := NewRunner(cfg)
+	require.NoError(t, err)
+	defer os.RemoveAll(cfg.TempDir)
+
+	tk := &task.Task{ID: "publish", Command: "-i ${INPUT_MEDIA} -c copy", InputMedia: input, OutputExt: "mp4"}
+	_, err = r.Run(context.Background(), tk)
+	require.NoError(t, err)
+
+	assert.Equal(t, filepath.Join(task.FilesDir(cfg, tk.ID), "output.mp4"), tk.OutputPath)
+	data, err := os.ReadFile(tk.OutputPath)
+	require.NoError(t, err)
+	assert.Equal(t, "encoded\n", string(data))
+	seen, err := os.ReadFile(filepath.Join(dir, "seen"))
+	require.NoError(t, err)
+	assert.Equal(t, "output.part.mp4\n", filepath.Base(string(seen)))
+	assert.NoFileExists(t, filepath.Join(task.FilesDir(cfg, tk.ID), "output.part.mp4"))
+}
+
+func TestPartName(t *testing.T) {
+	assert.Equal(t, "output.part.mp4", partName("output.mp4"))
+	assert.Equal(t, "output_1.part.webm", partName("output_1.webm"))
+	assert.Equal(t, "output.part", partName("output"))
+}
@@ -0,0 +1,152 @@
+package ffmpeg
+
+import (
+    "fmt"
+    "math"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "strings"
+
+    "ffwebapi/config"
+)
+
+// Sandboxes task commands can run in (FF_SANDBOX).
+const (
+    SandboxBwrap    = "bwrap"
+    SandboxFirejail = "firejail"
+)
+
+// sandboxReadOnly are the system directories a bwrap sandbox sees, read-only,
+// besides the directory of the binary itself. Missing ones are skipped.
+var sandboxReadOnly = []string{"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/etc/ld.so.cache", "/etc/alternatives", "/etc/fonts"}
+
+// checkSandboxConfig validates the sandbox, priority and resource limit
+// settings and checks that the programs applying them are installed.
+func checkSandboxConfig(cfg *config.Config) error {
+    var need []string
+    switch cfg.FFSandbox {
+    case "":
+    case SandboxBwrap, SandboxFirejail:
+        need = append(need, cfg.FFSandbox)
+    default:
+        return fmt.Errorf("invalid FF_SANDBOX %q (want %q or %q)", cfg.FFSandbox, SandboxBwrap, SandboxFirejail)
+    }
+    if cfg.FFNice < -20 || cfg.FFNice > 19 {
+        return fmt.Errorf("invalid FF_NICE %d (want -20 to 19)", cfg.FFNice)
+    }
+    if cfg.FFNice != 0 {
+        need = append(need, "nice")
+    }
+    if _, err := ioniceArgs(cfg.FFIONice); err != nil {
+        return err
+    }
+    if cfg.FFIONice != "" {
+        need = append(need, "ionice")
+    }
+    if cfg.FFRlimitCPU < 0 || cfg.FFRlimitAS < 0 || cfg.FFRlimitNoFile < 0 || cfg.FFRlimitNProc < 0 {
+        return fmt.Errorf("FF_RLIMIT_* limits must not be negative")
+    }
+    if len(prlimitArgs(cfg)) > 0 {
+        need = append(need, "prlimit")
+    }
+    for _, bin := range need {
+        if _, err := exec.LookPath(bin); err != nil {
+            return fmt.Errorf("%s is required by the sandbox settings but not found in PATH", bin)
+        }
+    }
+    return nil
+}
+
+// sandboxCommand wraps a task command in the programs applying its priority,
+// sandbox and resource limits, e.g.
+// "nice -n 10 bwrap ... -- prlimit --cpu=60 -- ffmpeg ...". nice and ionice
+// go outermost so they cover the sandbox too; prlimit goes innermost so the
+// sandbox itself may still fork.
+func sandboxCommand(cfg *config.Config, bin string, args []string, workDir string) []string {
+    if path, err := exec.LookPath(bin); err == nil {
+        bin = path // The sandbox may not search the same PATH
+    }
+    var argv []string
+    if cfg.FFNice != 0 {
+        argv = append(argv, "nice", "-n", strconv.Itoa(cfg.FFNice))
+    }
+    if cfg.FFIONice != "" {
+        ionice, _ := ioniceArgs(cfg.FFIONice)
+        argv = append(argv, ionice...)
+    }
+    switch cfg.FFSandbox {
+    case SandboxBwrap:
+        argv = append(argv, bwrapArgs(bin, workDir, zeroCopyDirs(cfg))...)
+    case SandboxFirejail:
+        argv = append(argv, firejailArgs(workDir, zeroCopyDirs(cfg))...)
+    }
+    if limits := prlimitArgs(cfg); len(limits) > 0 {
+        argv = append(append(append(argv, "prlimit"), limits...), "--")
+    }
+    return append(append(argv, bin), args...)
+}
+
+// bwrapArgs confines a command to read-only system directories and input
+// mounts and its read-write working directory, in new namespaces without
+// network.
+func bwrapArgs(bin, workDir string, inputDirs []string) []string {
+    args := []string{"bwrap", "--die-with-parent", "--new-session", "--unshare-all"}
+    readOnly := append(append(append([]string{}, sandboxReadOnly...), filepath.Dir(bin)), inputDirs...)
+    for _, dir := range readOnly {
+        args = append(args, "--ro-bind-try", dir, dir)
+    }
+    return append(args,
+        "--proc", "/proc", "--dev", "/dev", "--tmpfs", "/tmp",
+        "--bind", workDir, workDir, "--chdir", workDir, "--")
+}
+
+// firejailArgs runs a command without network, privileges or home directory
+// and with only its working directory visible under the temp directory, and
+// the input mounts read-only.
+func firejailArgs(workDir string, inputDirs []string) []string {
+    args := []string{"firejail", "--quiet", "--noprofile", "--net=none", "--nonewprivs", "--caps.drop=all",
+        "--seccomp", "--private", "--private-dev", "--whitelist=" + workDir}
+    for _, dir := range inputDirs {
+        args = append(args, "--whitelist="+dir, "--read-only="+dir)
+    }
+    return append(args, "--")
+}
+
+// prlimitArgs returns the prlimit options for the configured limits, which
+// set both the soft and the hard limit.
+func prlimitArgs(cfg *config.Config) []string {
+    var args []string
+    if cfg.FFRlimitCPU > 0 {
+        args = append(args, fmt.Sprintf("--cpu=%d", int64(math.Ceil(cfg.FFRlimitCPU.Seconds()))))
+    }
+    if cfg.FFRlimitAS > 0 {
+        args = append(args, fmt.Sprintf("--as=%d", cfg.FFRlimitAS))
+    }
+    if cfg.FFRlimitNoFile > 0 {
+        args = append(args, fmt.Sprintf("--nofile=%d", cfg.FFRlimitNoFile))
+    }
+    if cfg.FFRlimitNProc > 0 {
+        args = append(args, fmt.Sprintf("--nproc=%d", cfg.FFRlimitNProc))
+    }
+    return args
+}
+
+// ioniceArgs parses FF_IONICE: "idle", or "best-effort" with an optional
+// level from 0 (highest) to 7.
+func ioniceArgs(class string) ([]string, error) {
+    name, level, hasLevel := strings.Cut(class, ":")
+    switch {
+    case class == "":
+        return nil, nil
+    case name == "idle" && !hasLevel:
+        return []string{"ionice", "-c", "3"}, nil
+    case name == "best-effort" && !hasLevel:
+        return []string{"ionice", "-c", "2"}, nil
+    case name == "best-effort":
+        if n, err := strconv.Atoi(level); err == nil && n >= 0 && n <= 7 {
+            return []string{"ionice", "-c", "2", "-n", level}, nil
+        }
+    }
+    return nil, fmt.Errorf("invalid FF_IONICE %q (want \"idle\" or \"best-effort[:0-7]\")", class)
+}
@@ -0,0 +1,79 @@
+package ffmpeg
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ffwebapi/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckSandboxConfig(t *testing.T) {
+	assert.NoError(t, checkSandboxConfig(&config.Config{}))
+
+	for name, cfg := range map[string]*config.Config{
+		"sandbox":  {FFSandbox: "docker"},
+		"nice":     {FFNice: 20},
+		"ionice":   {FFIONice: "realtime"},
+		"level":    {FFIONice: "best-effort:8"},
+		"idle":     {FFIONice: "idle:3"},
+		"negative": {FFRlimitNoFile: -1},
+	} {
+		assert.Error(t, checkSandboxConfig(cfg), name)
+	}
+}
+
+func TestSandboxCommand(t *testing.T) {
+	args := []string{"-i", "in.mp4", "out.mp4"}
+
+	assert.Equal(t, []string{"/opt/ff/ffmpeg", "-i", "in.mp4", "out.mp4"}, sandboxCommand(&config.Config{}, "/opt/ff/ffmpeg", args, "/tmp/w"))
+
+	cfg := &config.Config{FFNice: 10, FFIONice: "best-effort:7", FFRlimitCPU: 90500 * time.Millisecond, FFRlimitAS: 4 << 30, FFRlimitNoFile: 256, FFRlimitNProc: 64}
+	assert.Equal(t, []string{
+		"nice", "-n", "10", "ionice", "-c", "2", "-n", "7",
+		"prlimit", "--cpu=91", "--as=4294967296", "--nofile=256", "--nproc=64", "--",
+		"/opt/ff/ffmpeg", "-i", "in.mp4", "out.mp4",
+	}, sandboxCommand(cfg, "/opt/ff/ffmpeg", args, "/tmp/w"))
+
+	cfg = &config.Config{FFSandbox: SandboxBwrap, FFRlimitNoFile: 256}
+	argv := strings.Join(sandboxCommand(cfg, "/opt/ff/ffmpeg", args, "/tmp/w"), " ")
+	assert.True(t, strings.HasPrefix(argv, "bwrap "), argv)
+	assert.Contains(t, argv, "--unshare-all")
+	assert.Contains(t, argv, "--ro-bind-try /opt/ff /opt/ff")
+	assert.Contains(t, argv, "--bind /tmp/w /tmp/w --chdir /tmp/w -- prlimit --nofile=256 -- /opt/ff/ffmpeg -i in.mp4 out.mp4")
+
+	cfg = &config.Config{FFSandbox: SandboxFirejail, FFIONice: "idle"}
+	argv = strings.Join(sandboxCommand(cfg, "/opt/ff/ffmpeg", args, "/tmp/w"), " ")
+	assert.True(t, strings.HasPrefix(argv, "ionice -c 3 firejail "), argv)
+	assert.Contains(t, argv, "--net=none")
+	assert.Contains(t, argv, "--whitelist=/tmp/w -- /opt/ff/ffmpeg -i in.mp4 out.mp4")
+
+	// Zero-copy input mounts are visible read-only.
+	share := t.TempDir()
+	share, _ = filepath.EvalSymlinks(share)
+	cfg = &config.Config{FFSandbox: SandboxBwrap, ZeroCopyInputDirs: []string{share, "/does/not/exist"}}
+	argv = strings.Join(sandboxCommand(cfg, "/opt/ff/ffmpeg", args, "/tmp/w"), " ")
+	assert.Contains(t, argv, "--ro-bind-try "+share+" "+share)
+	assert.NotContains(t, argv, "/does/not/exist")
+	cfg.FFSandbox = SandboxFirejail
+	argv = strings.Join(sandboxCommand(cfg, "/opt/ff/ffmpeg", args, "/tmp/w"), " ")
+	assert.Contains(t, argv, "--whitelist="+share+" --read-only="+share+" --")
+}
+
+func TestSandboxCommand_Limits(t *testing.T) {
+	if _, err := exec.LookPath("prlimit"); err != nil {
+		t.Skip("prlimit not installed")
+	}
+	cfg := &config.Config{FFRlimitNoFile: 123, FFRlimitCPU: time.Minute}
+	require.NoError(t, checkSandboxConfig(cfg))
+
+	argv := sandboxCommand(cfg, "sh", []string{"-c", "ulimit -n; ulimit -t"}, t.TempDir())
+	out, err := exec.Command(argv[0], argv[1:]...).CombinedOutput()
+	require.NoError(t, err, string(out))
+	assert.Equal(t, "123\n60\n", string(out))
+}
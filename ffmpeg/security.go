@@ -2,6 +2,8 @@ package ffmpeg
 
 import (
     "fmt"
+    "regexp"
+    "strconv"
     "strings"
 
     "github.com/google/shlex"
@@ -10,6 +12,9 @@ import (
 // The placeholder for the input file in user commands
 const InputMediaPlaceholder = "${INPUT_MEDIA}"
 
+// The placeholder for the output directory of directory-mode tasks (e.g. HLS)
+const OutputDirPlaceholder = "${OUTPUT_DIR}"
+
 // SplitCommand securely splits a command string into a slice of arguments.
 // It prevents shell injection by not using a shell.
 func SplitCommand(command string) ([]string, error) {
@@ -20,6 +25,65 @@ func SplitCommand(command string) ([]string, error) {
     return args, nil
 }
 
+// JoinCommand is the inverse of SplitCommand: it quotes arguments so that
+// SplitCommand(JoinCommand(args)) yields args again. It is used for commands
+// the server builds itself.
+func JoinCommand(args []string) string {
+    quoted := make([]string, len(args))
+    for i, arg := range args {
+        if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\#") {
+            quoted[i] = arg
+            continue
+        }
+        quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'"'"'`) + "'"
+    }
+    return strings.Join(quoted, " ")
+}
+
+// outputPlaceholderRe matches the placeholders of multi-output tasks, e.g. ${OUTPUT_0}.
+var outputPlaceholderRe = regexp.MustCompile(`\$\{OUTPUT_(\d+)\}`)
+
+// outputExtRe restricts output extensions, which end up in file names.
+var outputExtRe = regexp.MustCompile(`^[A-Za-z0-9]{1,10}$`)
+
+// disallowedChars may not appear in arguments outside placeholders.
+const disallowedChars = "|&;`$()<>"
+
+// protocolRe matches the protocol prefixes through which ffmpeg opens URLs,
+// devices or other files itself, e.g. "tcp:", "concat:" or "subfile,".
+var protocolRe = regexp.MustCompile(`(?i)(^|[^a-z0-9_])(async|bluray|cache|concat|concatf|crypto|data|fd|file|ftp|gopher|gophers|hls|http|httpproxy|https|icecast|ipfs|ipns|mmsh|mmst|pipe|prompeg|rtmpe?|rtmpts?|rtmps|rtmpte?|rtp|rtsp|sctp|sftp|smb|srt|srtp|subfile|tcp|tls|udp|udplite|unix|zmq)[:,]`)
+
+// protocolWhitelist is what the ffmpeg child may open: the local copies of
+// the inputs the server fetched, and the pipe between two stages.
+const protocolWhitelist = "file,pipe"
+
+// OutputPlaceholder returns the placeholder for the i-th output of a multi-output task.
+func OutputPlaceholder(i int) string {
+    return fmt.Sprintf("${OUTPUT_%d}", i)
+}
+
+// ExtraInputPlaceholder returns the placeholder for the n-th input after the
+// first (n >= 1). Only commands built by the server use it.
+func ExtraInputPlaceholder(n int) string {
+    return fmt.Sprintf("${INPUT_MEDIA_%d}", n)
+}
+
+// ValidateOutputExt checks that an output extension is safe to use in a file name.
+func ValidateOutputExt(ext string) error {
+    if !outputExtRe.MatchString(ext) {
+        return fmt.Errorf("invalid output extension %q", ext)
+    }
+    return nil
+}
+
+// stripPlaceholders removes every known placeholder from an argument, leaving
+// only the text the user wrote around them.
+func stripPlaceholders(arg string) string {
+    arg = strings.ReplaceAll(arg, InputMediaPlaceholder, "")
+    arg = strings.ReplaceAll(arg, OutputDirPlaceholder, "")
+    return outputPlaceholderRe.ReplaceAllString(arg, "")
+}
+
 // SanitizeAndValidateArgs checks the split arguments for potential security risks.
 func SanitizeAndValidateArgs(args []string) error {
     hasInput := false
@@ -33,16 +97,77 @@ func SanitizeAndValidateArgs(args []string) error {
         // Rule 2: Ensure the input placeholder is present.
         // Rule 3: Disallow shell-like metacharacters just in case, though exec.Command prevents their execution.
         // We allow " and ' as they are handled by shlex, but block others.
-        if arg == InputMediaPlaceholder {
-			hasInput = true
-		} else if strings.ContainsAny(arg, "|&;`$()<>") {
-			// This check is now only performed if the argument is NOT the placeholder.
-			return fmt.Errorf("disallowed character found in argument: %s", arg)
-		}
+        // Placeholders themselves contain "$" and are exempt from this check.
+        if strings.Contains(arg, InputMediaPlaceholder) {
+            hasInput = true
+        }
+        if strings.ContainsAny(stripPlaceholders(arg), disallowedChars) {
+            return fmt.Errorf("disallowed character found in argument: %s", arg)
+        }
     }
 
     if !hasInput {
         return fmt.Errorf("command must include the input placeholder '%s'", InputMediaPlaceholder)
     }
+
+    // Rule 4: The command reads nothing but the input, which the server
+    // fetched under the input policy; other inputs and protocols would let
+    // ffmpeg reach URLs and files past it.
+    for i, arg := range args {
+        if arg == "-i" && (i+1 == len(args) || args[i+1] != InputMediaPlaceholder) {
+            return fmt.Errorf("-i may only name the input placeholder '%s'", InputMediaPlaceholder)
+        }
+        if stripped := stripPlaceholders(arg); strings.Contains(stripped, "://") || protocolRe.MatchString(stripped) {
+            return fmt.Errorf("URLs and protocols are not allowed in arguments: %s", arg)
+        }
+    }
+    return nil
+}
+
+// restrictProtocols limits every input of an ffmpeg command to the
+// protocols in protocolWhitelist.
+func restrictProtocols(args []string) []string {
+    restricted := make([]string, 0, len(args)+2)
+    for _, arg := range args {
+        if arg == "-i" {
+            restricted = append(restricted, "-protocol_whitelist", protocolWhitelist)
+        }
+        restricted = append(restricted, arg)
+    }
+    return restricted
+}
+
+// ValidateOutputDirPlaceholder rejects ${OUTPUT_DIR} in commands that don't
+// write to a directory.
+func ValidateOutputDirPlaceholder(args []string, directoryMode bool) error {
+    if directoryMode {
+        return nil
+    }
+    for _, arg := range args {
+        if strings.Contains(arg, OutputDirPlaceholder) {
+            return fmt.Errorf("placeholder %s requires outputMode \"directory\"", OutputDirPlaceholder)
+        }
+    }
+    return nil
+}
+
+// ValidateOutputPlaceholders checks a multi-output command: each of the
+// numOutputs placeholders must appear, and no other output index may be used.
+func ValidateOutputPlaceholders(args []string, numOutputs int) error {
+    seen := make(map[int]bool)
+    for _, arg := range args {
+        for _, m := range outputPlaceholderRe.FindAllStringSubmatch(arg, -1) {
+            i, _ := strconv.Atoi(m[1])
+            if i >= numOutputs {
+                return fmt.Errorf("placeholder %s has no matching entry in outputs", m[0])
+            }
+            seen[i] = true
+        }
+    }
+    for i := 0; i < numOutputs; i++ {
+        if !seen[i] {
+            return fmt.Errorf("command must include the output placeholder '%s'", OutputPlaceholder(i))
+        }
+    }
     return nil
 }
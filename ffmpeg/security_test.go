@@ -42,4 +42,57 @@ func TestSanitizeAndValidateArgs(t *testing.T) {
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "disallowed character found in argument: crop=$(($RANDOM))")
 	})
-}
\ No newline at end of file
+
+	t.Run("Extra input", func(t *testing.T) {
+		args, _ := SplitCommand(`-i http://169.254.169.254/latest/meta-data/ -i ${INPUT_MEDIA} -map 0`)
+		err := SanitizeAndValidateArgs(args)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "-i may only name the input placeholder")
+	})
+
+	t.Run("Protocols", func(t *testing.T) {
+		for _, arg := range []string{"http://169.254.169.254/", "concat:/etc/passwd", "subfile,,start,0,end,0,,:/etc/passwd",
+			"tcp:127.0.0.1:22", "UDP:10.0.0.1:1234", "movie=file:/etc/passwd"} {
+			args := []string{"-i", InputMediaPlaceholder, "-vf", arg}
+			err := SanitizeAndValidateArgs(args)
+			if assert.Error(t, err, arg) {
+				assert.Contains(t, err.Error(), "URLs and protocols are not allowed")
+			}
+		}
+	})
+
+	t.Run("Filters and codecs", func(t *testing.T) {
+		args, _ := SplitCommand(`-i ${INPUT_MEDIA} -vf "scale=1280:-1,drawtext=text='a\:b'" -c:v libx264 -af "volume=0.5" ${OUTPUT_0}`)
+		assert.NoError(t, SanitizeAndValidateArgs(args))
+	})
+}
+
+func TestValidateOutputPlaceholders(t *testing.T) {
+	args, _ := SplitCommand(`-i ${INPUT_MEDIA} -map 0 ${OUTPUT_0} -vframes 1 ${OUTPUT_1}`)
+	assert.NoError(t, SanitizeAndValidateArgs(args))
+	assert.NoError(t, ValidateOutputPlaceholders(args, 2))
+
+	err := ValidateOutputPlaceholders(args, 3)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "${OUTPUT_2}")
+
+	err = ValidateOutputPlaceholders(args, 1)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "no matching entry")
+
+	args, _ = SplitCommand(`-i ${INPUT_MEDIA} ${OUTPUT_0};rm`)
+	assert.Error(t, SanitizeAndValidateArgs(args))
+}
+
+func TestValidateOutputExt(t *testing.T) {
+	assert.NoError(t, ValidateOutputExt("mp4"))
+	assert.Error(t, ValidateOutputExt("mp4/../../etc"))
+	assert.Error(t, ValidateOutputExt(""))
+}
+
+func TestValidateOutputDirPlaceholder(t *testing.T) {
+	args, _ := SplitCommand(`-i ${INPUT_MEDIA} -f hls -hls_segment_filename ${OUTPUT_DIR}/seg_%03d.ts ${OUTPUT_DIR}/index.m3u8`)
+	assert.NoError(t, SanitizeAndValidateArgs(args))
+	assert.NoError(t, ValidateOutputDirPlaceholder(args, true))
+	assert.Error(t, ValidateOutputDirPlaceholder(args, false))
+}
@@ -0,0 +1,296 @@
+package ffmpeg
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "os/exec"
+    "strconv"
+    "strings"
+
+    "ffwebapi/task"
+    "ffwebapi/utils"
+)
+
+// inputStream holds the labels ffprobe reports for a stream of the input,
+// what ffmpeg's automatic stream selection looks at, and its pixel format.
+type inputStream struct {
+    CodecType string `json:"codec_type"`
+    PixFmt    string `json:"pix_fmt"`
+    Width     int    `json:"width"`
+    Height    int    `json:"height"`
+    Channels  int    `json:"channels"`
+    Tags      struct {
+        Language string `json:"language"`
+        Title    string `json:"title"`
+    } `json:"tags"`
+    Disposition struct {
+        Default int `json:"default"`
+        Forced  int `json:"forced"`
+    } `json:"disposition"`
+}
+
+// streamTypes maps the stream type letters of map specifiers to ffprobe's
+// codec types.
+var streamTypes = map[string]string{
+    "v": "video",
+    "a": "audio",
+    "s": "subtitle",
+    "d": "data",
+    "t": "attachment",
+}
+
+// subtitleExts are outputs ffmpeg selects subtitle streams for, which the
+// automatic selection below does not model.
+var subtitleExts = map[string]bool{"srt": true, "vtt": true, "ass": true, "ssa": true}
+
+// probeStreams lists the streams of a local file with their labels.
+func (r *Runner) probeStreams(ctx context.Context, path string) ([]inputStream, error) {
+    ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+    defer cancel()
+
+    cmd := exec.CommandContext(ctx, r.cfg.FFProbeBin,
+        "-v", "error",
+        "-show_entries", "stream=codec_type,pix_fmt,width,height,channels:stream_tags=language,title:stream_disposition=default,forced",
+        "-of", "json",
+        path,
+    )
+    out, err := cmd.Output()
+    if err != nil {
+        return nil, fmt.Errorf("ffprobe failed: %w", err)
+    }
+    var probe struct {
+        Streams []inputStream `json:"streams"`
+    }
+    if err := json.Unmarshal(out, &probe); err != nil {
+        return nil, fmt.Errorf("unexpected ffprobe output: %w", err)
+    }
+    return probe.Streams, nil
+}
+
+// streamLabelArgs returns the output options that label the streams of a
+// single-output ffmpeg command: the language, title and default/forced
+// dispositions of the input streams they come from, unless the task skips
+// them, the command maps metadata itself or the input could not be probed
+// (streams is nil), followed by the task's overrides.
+func streamLabelArgs(t *task.Task, args []string, streams []inputStream) []string {
+    var labelArgs []string
+    if streams != nil && !t.SkipStreamLabels && !hasArg(args, "-map_metadata") {
+        if sources, ok := streamSources(args, streams, t.OutputExt); ok {
+            labelArgs = preservedLabelArgs(streams, sources)
+        }
+    }
+    return append(labelArgs, overrideLabelArgs(t.StreamLabels)...)
+}
+
+// streamSources works out which input stream each output stream of a
+// single-output command comes from: an index into streams, or -1 for streams
+// of filter graphs and other inputs. ok is false if the mapping is unknown,
+// e.g. for negative maps or automatic selection across several inputs.
+func streamSources(args []string, streams []inputStream, outputExt string) (sources []int, ok bool) {
+    inputs := 0
+    mapped := false
+    for i := 0; i < len(args)-1; i++ {
+        switch args[i] {
+        case "-i":
+            inputs++
+        case "-map":
+            mapped = true
+            srcs, ok := mapSources(args[i+1], streams)
+            if !ok {
+                return nil, false
+            }
+            sources = append(sources, srcs...)
+        }
+    }
+    if mapped {
+        return sources, true
+    }
+    if inputs != 1 || hasArg(args, "-filter_complex") || subtitleExts[strings.ToLower(outputExt)] {
+        return nil, false
+    }
+
+    // Without -map, ffmpeg picks the video stream with the most pixels and
+    // the audio stream with the most channels, in that order.
+    kind := utils.MediaKindOf(outputExt)
+    if kind != utils.MediaKindAudio && !hasArg(args, "-vn") {
+        if best := bestStream(streams, "video", func(s inputStream) int { return s.Width * s.Height }); best >= 0 {
+            sources = append(sources, best)
+        }
+    }
+    if kind != utils.MediaKindImage && !hasArg(args, "-an") {
+        if best := bestStream(streams, "audio", func(s inputStream) int { return s.Channels }); best >= 0 {
+            sources = append(sources, best)
+        }
+    }
+    return sources, true
+}
+
+// mapSources resolves a -map value to the input streams it selects, e.g.
+// "0", "0:a", "0:a:1", "0:3" or "[out]" (a filter graph output, -1).
+func mapSources(spec string, streams []inputStream) ([]int, bool) {
+    if strings.HasPrefix(spec, "[") {
+        return []int{-1}, true
+    }
+    parts := strings.Split(strings.TrimSuffix(spec, "?"), ":")
+    if parts[0] != "0" {
+        return nil, false // Negative maps, or streams of another input we did not probe
+    }
+    var sources []int
+    switch len(parts) {
+    case 1:
+        for i := range streams {
+            sources = append(sources, i)
+        }
+    case 2:
+        if n, err := strconv.Atoi(parts[1]); err == nil {
+            if n < len(streams) {
+                sources = append(sources, n)
+            }
+            break
+        }
+        codecType, ok := streamTypes[parts[1]]
+        if !ok {
+            return nil, false
+        }
+        for i, s := range streams {
+            if s.CodecType == codecType {
+                sources = append(sources, i)
+            }
+        }
+    case 3:
+        codecType, ok := streamTypes[parts[1]]
+        n, err := strconv.Atoi(parts[2])
+        if !ok || err != nil {
+            return nil, false
+        }
+        for i, s := range streams {
+            if s.CodecType == codecType {
+                if n == 0 {
+                    sources = append(sources, i)
+                    break
+                }
+                n--
+            }
+        }
+    default:
+        return nil, false
+    }
+    return sources, true
+}
+
+// bestStream returns the index of the first stream of the codec type with
+// the highest score, or -1 if there is none.
+func bestStream(streams []inputStream, codecType string, score func(inputStream) int) int {
+    best := -1
+    for i, s := range streams {
+        if s.CodecType == codecType && (best < 0 || score(s) > score(streams[best])) {
+            best = i
+        }
+    }
+    return best
+}
+
+// preservedLabelArgs sets the labels of each input stream on the output
+// stream made from it.
+func preservedLabelArgs(streams []inputStream, sources []int) []string {
+    var args []string
+    for out, src := range sources {
+        if src < 0 {
+            continue
+        }
+        s := streams[src]
+        if s.Tags.Language != "" {
+            args = append(args, fmt.Sprintf("-metadata:s:%d", out), "language="+s.Tags.Language)
+        }
+        if s.Tags.Title != "" {
+            args = append(args, fmt.Sprintf("-metadata:s:%d", out), "title="+s.Tags.Title)
+        }
+        var flags []string
+        if s.Disposition.Default != 0 {
+            flags = append(flags, "default")
+        }
+        if s.Disposition.Forced != 0 {
+            flags = append(flags, "forced")
+        }
+        if len(flags) > 0 {
+            args = append(args, fmt.Sprintf("-disposition:%d", out), strings.Join(flags, "+"))
+        }
+    }
+    return args
+}
+
+// overrideLabelArgs applies a task's stream labels. Dispositions are changed
+// relative to the input's (e.g. "+default-forced"), so flags a label doesn't
+// mention are kept.
+func overrideLabelArgs(labels []task.StreamLabel) []string {
+    var args []string
+    for _, l := range labels {
+        if l.Language != "" {
+            args = append(args, "-metadata:s:"+l.Stream, "language="+l.Language)
+        }
+        if l.Title != "" {
+            args = append(args, "-metadata:s:"+l.Stream, "title="+l.Title)
+        }
+        flags := dispositionFlag("default", l.Default) + dispositionFlag("forced", l.Forced)
+        if flags != "" {
+            args = append(args, "-disposition:"+l.Stream, flags)
+        }
+    }
+    return args
+}
+
+func dispositionFlag(name string, set *bool) string {
+    switch {
+    case set == nil:
+        return ""
+    case *set:
+        return "+" + name
+    default:
+        return "-" + name
+    }
+}
+
+// ValidateStreamLabels checks the stream specifiers and values of a task's
+// stream labels.
+func ValidateStreamLabels(labels []task.StreamLabel) error {
+    for _, l := range labels {
+        if !validStreamSpec(l.Stream) {
+            return fmt.Errorf("invalid stream %q (want an index like \"1\" or a type and index like \"a:0\")", l.Stream)
+        }
+        if l.Language != "" && !isLanguageCode(l.Language) {
+            return fmt.Errorf("invalid language %q for stream %s (want an ISO 639-2 code like \"eng\")", l.Language, l.Stream)
+        }
+        if len(l.Title) > 256 || strings.ContainsFunc(l.Title, func(r rune) bool { return r < ' ' || r == 0x7f }) {
+            return fmt.Errorf("invalid title for stream %s", l.Stream)
+        }
+        if l.Language == "" && l.Title == "" && l.Default == nil && l.Forced == nil {
+            return fmt.Errorf("stream label %s sets nothing", l.Stream)
+        }
+    }
+    return nil
+}
+
+func validStreamSpec(spec string) bool {
+    index := spec
+    if kind, n, found := strings.Cut(spec, ":"); found {
+        if kind != "v" && kind != "a" && kind != "s" {
+            return false
+        }
+        index = n
+    }
+    n, err := strconv.Atoi(index)
+    return err == nil && n >= 0 && strconv.Itoa(n) == index
+}
+
+func isLanguageCode(s string) bool {
+    if len(s) != 3 {
+        return false
+    }
+    for _, r := range s {
+        if r < 'a' || r > 'z' {
+            return false
+        }
+    }
+    return true
+}
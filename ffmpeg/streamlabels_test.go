@@ -0,0 +1,104 @@
+package ffmpeg
+
+import (
+	"testing"
+
+	"ffwebapi/task"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func labeledStream(codecType, language, title string, isDefault, forced bool) inputStream {
+	s := inputStream{CodecType: codecType}
+	s.Tags.Language, s.Tags.Title = language, title
+	if isDefault {
+		s.Disposition.Default = 1
+	}
+	if forced {
+		s.Disposition.Forced = 1
+	}
+	return s
+}
+
+func TestStreamSources(t *testing.T) {
+	streams := []inputStream{
+		{CodecType: "video", Width: 640, Height: 360},
+		{CodecType: "video", Width: 1920, Height: 1080},
+		{CodecType: "audio", Channels: 2},
+		{CodecType: "audio", Channels: 6},
+		{CodecType: "subtitle"},
+	}
+	tests := []struct {
+		name string
+		args string
+		ext  string
+		want []int
+		ok   bool
+	}{
+		{"automatic selection", "-i in.mkv -c copy", "mkv", []int{1, 3}, true},
+		{"audio output", "-i in.mkv -c:a aac", "m4a", []int{3}, true},
+		{"no audio", "-i in.mkv -an", "mp4", []int{1}, true},
+		{"map all", "-i in.mkv -map 0 -c copy", "mkv", []int{0, 1, 2, 3, 4}, true},
+		{"map by type", "-i in.mkv -map 0:v:0 -map 0:a -map 0:s?", "mkv", []int{0, 2, 3, 4}, true},
+		{"map by index", "-i in.mkv -map 0:3 -map 0:a:9", "mkv", []int{3}, true},
+		{"filter graph output", "-i in.mkv -filter_complex [0:v]scale=1280:-2[v] -map [v] -map 0:a:1", "mp4", []int{-1, 3}, true},
+		{"negative map", "-i in.mkv -map 0 -map -0:s", "mkv", nil, false},
+		{"other input", "-i in.mkv -i logo.png -map 1:v", "mp4", nil, false},
+		{"several inputs", "-i in.mkv -i logo.png", "mp4", nil, false},
+		{"unmapped filter graph", "-i in.mkv -filter_complex [0:v]scale=1280:-2", "mp4", nil, false},
+		{"subtitle output", "-i in.mkv", "srt", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := SplitCommand(tt.args)
+			assert.NoError(t, err)
+			sources, ok := streamSources(args, streams, tt.ext)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, sources)
+		})
+	}
+}
+
+func TestPreservedLabelArgs(t *testing.T) {
+	streams := []inputStream{
+		labeledStream("video", "", "", true, false),
+		labeledStream("audio", "eng", "Stereo", true, false),
+		labeledStream("audio", "deu", "", false, false),
+		labeledStream("subtitle", "fra", "Forced", false, true),
+	}
+	args := preservedLabelArgs(streams, []int{-1, 1, 3})
+	assert.Equal(t, []string{
+		"-metadata:s:1", "language=eng", "-metadata:s:1", "title=Stereo", "-disposition:1", "default",
+		"-metadata:s:2", "language=fra", "-metadata:s:2", "title=Forced", "-disposition:2", "forced",
+	}, args)
+	assert.Empty(t, preservedLabelArgs(streams, []int{-1}))
+}
+
+func TestOverrideLabelArgs(t *testing.T) {
+	yes, no := true, false
+	args := overrideLabelArgs([]task.StreamLabel{
+		{Stream: "a:1", Language: "spa", Title: "Español", Default: &yes, Forced: &no},
+		{Stream: "2", Forced: &yes},
+	})
+	assert.Equal(t, []string{
+		"-metadata:s:a:1", "language=spa", "-metadata:s:a:1", "title=Español", "-disposition:a:1", "+default-forced",
+		"-disposition:2", "+forced",
+	}, args)
+}
+
+func TestValidateStreamLabels(t *testing.T) {
+	yes := true
+	assert.NoError(t, ValidateStreamLabels(nil))
+	assert.NoError(t, ValidateStreamLabels([]task.StreamLabel{{Stream: "a:0", Language: "eng"}, {Stream: "3", Default: &yes}}))
+
+	for _, l := range []task.StreamLabel{
+		{Stream: "x:0", Language: "eng"},
+		{Stream: "a:01", Language: "eng"},
+		{Stream: "", Language: "eng"},
+		{Stream: "a:0", Language: "en"},
+		{Stream: "a:0", Title: "line\nbreak"},
+		{Stream: "a:0"},
+	} {
+		assert.Error(t, ValidateStreamLabels([]task.StreamLabel{l}), l.Stream)
+	}
+}
@@ -0,0 +1,145 @@
+package ffmpeg
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "io"
+    "mime/multipart"
+    "net/http"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+
+    "ffwebapi/task"
+)
+
+// Placeholders of STT_COMMAND.
+const (
+    sttAudioPlaceholder    = "${AUDIO}"
+    sttFormatPlaceholder   = "${FORMAT}"
+    sttLanguagePlaceholder = "${LANGUAGE}"
+)
+
+// maxSubtitleSize bounds the subtitle file returned by the STT backend.
+const maxSubtitleSize = 16 << 20
+
+// generateSubtitles transcribes the task's audio with the configured speech-to-text
+// backend and stores the result next to the outputs. audioPath may point at
+// audio prepared by the task itself; otherwise the audio track is extracted
+// from the input first.
+func (r *Runner) generateSubtitles(ctx context.Context, t *task.Task, workDir, inputPath, audioPath string, id *identity) (string, error) {
+    ctx, cancel := context.WithTimeout(ctx, r.cfg.STTTimeout)
+    defer cancel()
+
+    if audioPath == "" {
+        audioPath = filepath.Join(workDir, "stt_audio.wav")
+        cmd := exec.CommandContext(ctx, r.cfg.FFBin, "-v", "error", "-i", inputPath, "-vn", "-ac", "1", "-ar", "16000", "-c:a", "pcm_s16le", audioPath)
+        cmd.Dir = workDir
+        setCredential(cmd, id)
+        if out, err := cmd.CombinedOutput(); err != nil {
+            return "", fmt.Errorf("could not extract audio: %w: %s", err, strings.TrimSpace(string(out)))
+        }
+    }
+
+    var subtitles []byte
+    var err error
+    if r.cfg.STTURL != "" {
+        subtitles, err = r.transcribeHTTP(ctx, t, audioPath)
+    } else {
+        subtitles, err = r.transcribeCommand(ctx, t, workDir, audioPath, id)
+    }
+    if err != nil {
+        return "", err
+    }
+    if len(bytes.TrimSpace(subtitles)) == 0 {
+        return "", fmt.Errorf("speech-to-text backend returned no subtitles")
+    }
+
+    filesDir, err := r.filesDir(t)
+    if err != nil {
+        return "", err
+    }
+    subtitlePath := filepath.Join(filesDir, fmt.Sprintf("%s.%s", task.ArtifactSubtitles, t.Subtitles))
+    if err := os.WriteFile(subtitlePath, subtitles, 0o600); err != nil {
+        return "", fmt.Errorf("could not store subtitles: %w", err)
+    }
+    return subtitlePath, nil
+}
+
+// transcribeHTTP posts the audio to a Whisper-compatible server (OpenAI's
+// /v1/audio/transcriptions or whisper.cpp's /inference).
+func (r *Runner) transcribeHTTP(ctx context.Context, t *task.Task, audioPath string) ([]byte, error) {
+    audio, err := os.Open(audioPath)
+    if err != nil {
+        return nil, err
+    }
+    defer audio.Close()
+
+    var body bytes.Buffer
+    form := multipart.NewWriter(&body)
+    part, _ := form.CreateFormFile("file", filepath.Base(audioPath))
+    if _, err := io.Copy(part, audio); err != nil {
+        return nil, err
+    }
+    form.WriteField("response_format", t.Subtitles)
+    if t.SubtitleLanguage != "" {
+        form.WriteField("language", t.SubtitleLanguage)
+    }
+    if r.cfg.STTModel != "" {
+        form.WriteField("model", r.cfg.STTModel)
+    }
+    form.Close()
+
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.cfg.STTURL, &body)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Content-Type", form.FormDataContentType())
+    if r.cfg.STTAuthToken != "" {
+        req.Header.Set("Authorization", "Bearer "+r.cfg.STTAuthToken)
+    }
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        return nil, fmt.Errorf("speech-to-text request failed: %w", err)
+    }
+    defer resp.Body.Close()
+    subtitles, err := io.ReadAll(io.LimitReader(resp.Body, maxSubtitleSize))
+    if err != nil {
+        return nil, err
+    }
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("speech-to-text backend answered %s: %s", resp.Status, strings.TrimSpace(string(subtitles)))
+    }
+    return subtitles, nil
+}
+
+// transcribeCommand runs STT_COMMAND, which prints the subtitles to stdout.
+func (r *Runner) transcribeCommand(ctx context.Context, t *task.Task, workDir, audioPath string, id *identity) ([]byte, error) {
+    args, err := SplitCommand(r.cfg.STTCommand)
+    if err != nil || len(args) == 0 {
+        return nil, fmt.Errorf("invalid STT_COMMAND: %v", err)
+    }
+    language := t.SubtitleLanguage
+    if language == "" {
+        language = "auto"
+    }
+    replacer := strings.NewReplacer(sttAudioPlaceholder, audioPath, sttFormatPlaceholder, t.Subtitles, sttLanguagePlaceholder, language)
+    for i, arg := range args {
+        args[i] = replacer.Replace(arg)
+    }
+
+    cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+    cmd.Dir = workDir
+    setCredential(cmd, id)
+    var stdout, stderr bytes.Buffer
+    cmd.Stdout, cmd.Stderr = &stdout, &stderr
+    if err := cmd.Run(); err != nil {
+        return nil, fmt.Errorf("speech-to-text command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+    }
+    if stdout.Len() > maxSubtitleSize {
+        return nil, fmt.Errorf("speech-to-text command printed more than %d bytes", maxSubtitleSize)
+    }
+    return stdout.Bytes(), nil
+}
@@ -0,0 +1,65 @@
+package ffmpeg
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ffwebapi/config"
+	"ffwebapi/task"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateSubtitles(t *testing.T) {
+	dir := t.TempDir()
+	audio := filepath.Join(dir, "audio.wav")
+	require.NoError(t, os.WriteFile(audio, []byte("RIFF"), 0o600))
+	tk := &task.Task{ID: "t1", Subtitles: "vtt", SubtitleLanguage: "en"}
+
+	t.Run("http backend", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+			assert.Equal(t, "vtt", r.FormValue("response_format"))
+			assert.Equal(t, "en", r.FormValue("language"))
+			f, _, err := r.FormFile("file")
+			require.NoError(t, err)
+			data, _ := io.ReadAll(f)
+			assert.Equal(t, "RIFF", string(data))
+			io.WriteString(w, "WEBVTT\n\n00:00.000 --> 00:01.000\nhello\n")
+		}))
+		defer srv.Close()
+
+		r := &Runner{tempDir: dir, cfg: &config.Config{TempDir: dir, STTURL: srv.URL, STTAuthToken: "secret", STTTimeout: 5 * time.Second}}
+		path, err := r.generateSubtitles(context.Background(), tk, dir, "", audio, nil)
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(dir, "files", "t1", "subtitles.vtt"), path)
+		data, _ := os.ReadFile(path)
+		assert.Contains(t, string(data), "hello")
+	})
+
+	t.Run("command backend", func(t *testing.T) {
+		r := &Runner{tempDir: dir, cfg: &config.Config{TempDir: dir, STTCommand: "echo ${FORMAT} ${LANGUAGE} ${AUDIO}", STTTimeout: 5 * time.Second}}
+		path, err := r.generateSubtitles(context.Background(), tk, dir, "", audio, nil)
+		require.NoError(t, err)
+		data, _ := os.ReadFile(path)
+		assert.Equal(t, "vtt en "+audio+"\n", string(data))
+	})
+
+	t.Run("backend error", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "model not loaded", http.StatusServiceUnavailable)
+		}))
+		defer srv.Close()
+
+		r := &Runner{tempDir: dir, cfg: &config.Config{TempDir: dir, STTURL: srv.URL, STTTimeout: 5 * time.Second}}
+		_, err := r.generateSubtitles(context.Background(), tk, dir, "", audio, nil)
+		assert.ErrorContains(t, err, "model not loaded")
+	})
+}
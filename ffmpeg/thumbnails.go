@@ -0,0 +1,153 @@
+package ffmpeg
+
+import (
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+    "time"
+)
+
+const maxThumbnails = 500
+
+// ThumbnailSpec describes which frames to extract and how to lay them out.
+// Exactly one of Timestamps and Interval must be set.
+type ThumbnailSpec struct {
+    Timestamps    []time.Duration
+    Interval      time.Duration
+    Count         int    // Max frames; required for sprites with an interval
+    Width         int    // Defaults to 160
+    Height        int    // Sprites only; defaults to 16:9 of Width
+    Format        string // jpg (default), png or webp
+    Sprite        bool   // Tile all frames into one image
+    SpriteColumns int    // Defaults to 10
+}
+
+// ThumbnailJob is a ready-to-submit thumbnail command writing into ${OUTPUT_DIR}.
+type ThumbnailJob struct {
+    Command    string
+    OutputExt  string
+    Entry      string            // sprite.<ext> or the first frame
+    ExtraFiles map[string][]byte // The WebVTT cue file of a sprite
+}
+
+// BuildThumbnailCommand builds an ffmpeg command extracting frames at the given
+// timestamps or intervals. Sprites come with a thumbnails.vtt whose cues point
+// players at the tile for each time range (sprite.jpg#xywh=x,y,w,h).
+func BuildThumbnailCommand(spec ThumbnailSpec) (*ThumbnailJob, error) {
+    if (len(spec.Timestamps) == 0) == (spec.Interval == 0) {
+        return nil, fmt.Errorf("either timestamps or an interval is required")
+    }
+    if spec.Interval < 0 || spec.Count < 0 || len(spec.Timestamps) > maxThumbnails || spec.Count > maxThumbnails {
+        return nil, fmt.Errorf("at most %d thumbnails at non-negative times are supported", maxThumbnails)
+    }
+    if spec.Format == "" {
+        spec.Format = "jpg"
+    }
+    if spec.Format != "jpg" && spec.Format != "png" && spec.Format != "webp" {
+        return nil, fmt.Errorf("unsupported image format %q", spec.Format)
+    }
+    if spec.Width == 0 {
+        spec.Width = 160
+    }
+    if spec.Height == 0 {
+        spec.Height = spec.Width * 9 / 16 &^ 1
+    }
+    if spec.Width < 16 || spec.Width > 3840 || spec.Height < 16 || spec.Height > 2160 {
+        return nil, fmt.Errorf("thumbnail size must be between 16x16 and 3840x2160")
+    }
+
+    // The frame times, as far as they are known up front.
+    times := append([]time.Duration{}, spec.Timestamps...)
+    var selectFilter string
+    if len(times) > 0 {
+        for i, ts := range times {
+            if ts < 0 || (i > 0 && ts <= times[i-1]) {
+                return nil, fmt.Errorf("timestamps must be non-negative and ascending")
+            }
+        }
+        // Pick the first frame at or after each timestamp.
+        terms := make([]string, len(times))
+        for i, ts := range times {
+            sec := strconv.FormatFloat(ts.Seconds(), 'f', 3, 64)
+            terms[i] = fmt.Sprintf("(isnan(prev_pts)+lt(prev_pts*TB,%s))*gte(pts*TB,%s)", sec, sec)
+        }
+        selectFilter = "select='" + strings.Join(terms, "+") + "'"
+    } else {
+        selectFilter = "fps=1/" + strconv.FormatFloat(spec.Interval.Seconds(), 'f', 3, 64)
+        for i := 0; i < spec.Count; i++ {
+            times = append(times, time.Duration(i)*spec.Interval)
+        }
+    }
+    n := len(times)
+
+    job := &ThumbnailJob{OutputExt: spec.Format}
+    args := []string{"-i", InputMediaPlaceholder}
+    if spec.Sprite {
+        if n == 0 {
+            return nil, fmt.Errorf("sprites with an interval need a count")
+        }
+        cols := spec.SpriteColumns
+        if cols <= 0 {
+            cols = 10
+        }
+        if cols > n {
+            cols = n
+        }
+        rows := int(math.Ceil(float64(n) / float64(cols)))
+        if spec.Interval > 0 {
+            // Stop after Count frames, so they don't fill the rest of the grid.
+            selectFilter += fmt.Sprintf(",select='lt(n,%d)'", n)
+        }
+        filter := fmt.Sprintf("%s,scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,tile=%dx%d",
+            selectFilter, spec.Width, spec.Height, spec.Width, spec.Height, cols, rows)
+        job.Entry = "sprite." + spec.Format
+        args = append(args, "-vf", filter, "-fps_mode", "vfr", "-frames:v", "1")
+        args = append(args, imageQualityArgs(spec.Format)...)
+        args = append(args, OutputDirPlaceholder+"/"+job.Entry)
+        job.ExtraFiles = map[string][]byte{"thumbnails.vtt": spriteVTT(job.Entry, times, spec.Interval, cols, spec.Width, spec.Height)}
+    } else {
+        filter := fmt.Sprintf("%s,scale=%d:-2", selectFilter, spec.Width)
+        job.Entry = "thumb_001." + spec.Format
+        args = append(args, "-vf", filter, "-fps_mode", "vfr")
+        if n == 0 {
+            n = maxThumbnails
+        }
+        args = append(args, "-frames:v", strconv.Itoa(n))
+        args = append(args, imageQualityArgs(spec.Format)...)
+        args = append(args, OutputDirPlaceholder+"/thumb_%03d."+spec.Format)
+    }
+
+    job.Command = JoinCommand(args)
+    return job, nil
+}
+
+func imageQualityArgs(format string) []string {
+    if format == "jpg" {
+        return []string{"-q:v", "3"}
+    }
+    return nil
+}
+
+// spriteVTT renders the WebVTT cue file of a sprite: each cue lasts until the
+// next frame; the last one for one interval (or 10s for timestamps).
+func spriteVTT(sprite string, times []time.Duration, interval time.Duration, cols, w, h int) []byte {
+    var b strings.Builder
+    b.WriteString("WEBVTT\n")
+    for i, start := range times {
+        end := start + interval
+        if i+1 < len(times) {
+            end = times[i+1]
+        } else if interval == 0 {
+            end = start + 10*time.Second
+        }
+        fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+            vttTimestamp(start), vttTimestamp(end), sprite, (i%cols)*w, (i/cols)*h, w, h)
+    }
+    return []byte(b.String())
+}
+
+func vttTimestamp(d time.Duration) string {
+    ms := d.Milliseconds()
+    return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
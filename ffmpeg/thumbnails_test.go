@@ -0,0 +1,47 @@
+package ffmpeg
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildThumbnailCommand(t *testing.T) {
+	t.Run("frames at timestamps", func(t *testing.T) {
+		job, err := BuildThumbnailCommand(ThumbnailSpec{Timestamps: []time.Duration{0, 5 * time.Second}, Width: 320})
+		require.NoError(t, err)
+		assert.Equal(t, "thumb_001.jpg", job.Entry)
+		args, err := SplitCommand(job.Command)
+		require.NoError(t, err)
+		assert.Contains(t, args, "select='(isnan(prev_pts)+lt(prev_pts*TB,0.000))*gte(pts*TB,0.000)+(isnan(prev_pts)+lt(prev_pts*TB,5.000))*gte(pts*TB,5.000)',scale=320:-2")
+		assert.Equal(t, OutputDirPlaceholder+"/thumb_%03d.jpg", args[len(args)-1])
+	})
+
+	t.Run("sprite with cue file", func(t *testing.T) {
+		job, err := BuildThumbnailCommand(ThumbnailSpec{Interval: 10 * time.Second, Count: 3, Sprite: true, SpriteColumns: 2, Format: "png"})
+		require.NoError(t, err)
+		assert.Equal(t, "sprite.png", job.Entry)
+		args, err := SplitCommand(job.Command)
+		require.NoError(t, err)
+		assert.Contains(t, args, "fps=1/10.000,select='lt(n,3)',scale=160:90:force_original_aspect_ratio=decrease,pad=160:90:(ow-iw)/2:(oh-ih)/2,tile=2x2")
+
+		vtt := string(job.ExtraFiles["thumbnails.vtt"])
+		assert.True(t, strings.HasPrefix(vtt, "WEBVTT\n"))
+		assert.Contains(t, vtt, "00:00:10.000 --> 00:00:20.000\nsprite.png#xywh=160,0,160,90")
+		assert.Contains(t, vtt, "00:00:20.000 --> 00:00:30.000\nsprite.png#xywh=0,90,160,90")
+	})
+
+	t.Run("invalid specs", func(t *testing.T) {
+		_, err := BuildThumbnailCommand(ThumbnailSpec{})
+		assert.Error(t, err)
+		_, err = BuildThumbnailCommand(ThumbnailSpec{Interval: time.Second, Sprite: true})
+		assert.ErrorContains(t, err, "count")
+		_, err = BuildThumbnailCommand(ThumbnailSpec{Timestamps: []time.Duration{5 * time.Second, time.Second}})
+		assert.ErrorContains(t, err, "ascending")
+		_, err = BuildThumbnailCommand(ThumbnailSpec{Interval: time.Second, Format: "bmp"})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,273 @@
+package ffmpeg
+
+import (
+    "fmt"
+    "regexp"
+    "sort"
+    "strings"
+
+    "ffwebapi/config"
+)
+
+// ToolFFmpeg is the tool of tasks that don't name one.
+const ToolFFmpeg = "ffmpeg"
+
+// Tool is an allow-listed binary tasks can run through the same queue,
+// sanitization and output handling as ffmpeg. Only single-file outputs are
+// supported for tools other than ffmpeg.
+type Tool struct {
+    Name        string
+    options     map[string]toolValue                     // Allowed options; nil allows any (ffmpeg, checked elsewhere)
+    assignment  func(arg string) (bool, error)           // Allows options not in options, e.g. exiftool tag assignments; may be nil
+    foldCase    bool                                     // Option names are case-insensitive, as exiftool's mostly are
+    placeOutput func(args []string, out string) []string // Adds the output path to the arguments
+}
+
+// toolValue is what an allowed tool option takes after it.
+type toolValue int
+
+const (
+    noValue    toolValue = iota
+    textValue            // A value that names no file, e.g. -resize 50%
+    inputValue           // The input placeholder, e.g. mp4box's -add ${INPUT_MEDIA}#video
+)
+
+// appendOutput places the output last, as ffmpeg and magick expect it.
+func appendOutput(args []string, out string) []string {
+    return append(args, out)
+}
+
+// toolInputRe matches the arguments naming the input: the placeholder, with
+// an optional track or frame selector such as "#video" or "[0]".
+var toolInputRe = regexp.MustCompile(`^` + regexp.QuoteMeta(InputMediaPlaceholder) + `[A-Za-z0-9#:=\[\],._+-]*$`)
+
+// exiftoolAssignmentRe matches exiftool tag assignments, e.g. "-all=",
+// "-XMP:Title=x" or "-Keywords+=x". Assignments with "<" copy from other tags
+// or files and are rejected with the disallowed characters.
+var exiftoolAssignmentRe = regexp.MustCompile(`^-(?:[A-Za-z0-9_-]+:)*([A-Za-z0-9_]+)[+-]?=`)
+
+// exiftoolFileTags are the pseudo-tags that move, rename or link the file
+// when written.
+var exiftoolFileTags = []string{"filename", "directory", "hardlink", "symlink", "testname"}
+
+// exiftoolAssignment allows tag assignments other than of exiftoolFileTags.
+func exiftoolAssignment(arg string) (bool, error) {
+    m := exiftoolAssignmentRe.FindStringSubmatch(arg)
+    if m == nil {
+        return false, nil
+    }
+    for _, tag := range exiftoolFileTags {
+        if strings.EqualFold(m[1], tag) {
+            return false, fmt.Errorf("tag %s is not allowed for exiftool", m[1])
+        }
+    }
+    return true, nil
+}
+
+// tools are the tools operators can enable with TOOLS, besides ffmpeg. Each
+// allows only options that neither read nor write files of their choosing.
+var tools = map[string]Tool{
+    "mkvmerge": {
+        Name: "mkvmerge",
+        options: map[string]toolValue{
+            "-A": noValue, "--no-audio": noValue, "-D": noValue, "--no-video": noValue,
+            "-S": noValue, "--no-subtitles": noValue, "-B": noValue, "--no-buttons": noValue,
+            "-T": noValue, "--no-track-tags": noValue, "-M": noValue, "--no-attachments": noValue,
+            "--no-chapters": noValue, "--no-global-tags": noValue, "-w": noValue, "--webm": noValue,
+            "--disable-track-statistics-tags": noValue, "--no-date": noValue, "-q": noValue, "--quiet": noValue,
+            "-a": textValue, "--audio-tracks": textValue, "-d": textValue, "--video-tracks": textValue,
+            "-s": textValue, "--subtitle-tracks": textValue, "--language": textValue, "--track-name": textValue,
+            "--default-track-flag": textValue, "--default-track": textValue, "--forced-display-flag": textValue,
+            "--forced-track": textValue, "-y": textValue, "--sync": textValue, "--title": textValue,
+            "--aspect-ratio": textValue, "--default-duration": textValue, "--display-dimensions": textValue,
+            "--cropping": textValue, "--stereo-mode": textValue, "--cues": textValue, "--compression": textValue,
+            "--track-order": textValue,
+        },
+        placeOutput: func(args []string, out string) []string { return append([]string{"-o", out}, args...) },
+    },
+    "mp4box": {
+        Name: "mp4box",
+        options: map[string]toolValue{
+            "-add": inputValue,
+            "-isma": noValue, "-hint": noValue, "-flat": noValue, "-ipod": noValue, "-noprog": noValue, "-quiet": noValue,
+            "-fps": textValue, "-lang": textValue, "-name": textValue, "-delay": textValue, "-par": textValue,
+            "-rem": textValue, "-disable": textValue, "-enable": textValue, "-inter": textValue, "-brand": textValue,
+            "-ab": textValue,
+        },
+        placeOutput: func(args []string, out string) []string { return append(args, "-out", out) },
+    },
+    "exiftool": {
+        Name: "exiftool",
+        options: map[string]toolValue{
+            "-m": noValue, "-q": noValue, "-n": noValue, "-overwrite_original": noValue,
+        },
+        assignment:  exiftoolAssignment,
+        foldCase:    true,
+        placeOutput: func(args []string, out string) []string { return append([]string{"-o", out}, args...) },
+    },
+    "magick": {
+        Name: "magick",
+        options: map[string]toolValue{
+            "-strip": noValue, "-auto-orient": noValue, "-flatten": noValue, "-flip": noValue, "-flop": noValue,
+            "-trim": noValue, "+repage": noValue, "-negate": noValue, "-normalize": noValue, "-monochrome": noValue,
+            "-coalesce": noValue, "+dither": noValue,
+            "-resize": textValue, "-thumbnail": textValue, "-scale": textValue, "-sample": textValue,
+            "-adaptive-resize": textValue, "-crop": textValue, "-extent": textValue, "-shave": textValue,
+            "-repage": textValue, "-gravity": textValue, "-background": textValue, "-fill": textValue,
+            "-border": textValue, "-bordercolor": textValue, "-rotate": textValue, "-quality": textValue,
+            "-colorspace": textValue, "-type": textValue, "-depth": textValue, "-density": textValue,
+            "-units": textValue, "-interlace": textValue, "-sampling-factor": textValue, "-filter": textValue,
+            "-blur": textValue, "-sharpen": textValue, "-unsharp": textValue, "-gamma": textValue,
+            "-level": textValue, "-modulate": textValue, "-brightness-contrast": textValue, "-alpha": textValue,
+            "-grayscale": textValue, "-posterize": textValue, "-colors": textValue, "-dither": textValue,
+            "-compress": textValue, "-layers": textValue, "-sepia-tone": textValue,
+        },
+        placeOutput: appendOutput,
+    },
+}
+
+// LookupTool returns an enabled tool: ffmpeg, or one listed in TOOLS.
+func LookupTool(cfg *config.Config, name string) (Tool, bool) {
+    name = strings.ToLower(name)
+    if name == "" || name == ToolFFmpeg {
+        return Tool{Name: ToolFFmpeg, placeOutput: appendOutput}, true
+    }
+    if _, enabled := configuredBin(cfg, name); !enabled {
+        return Tool{}, false
+    }
+    t, ok := tools[name]
+    return t, ok
+}
+
+// EnabledTools lists the tools tasks may use, sorted by name.
+func EnabledTools(cfg *config.Config) []string {
+    names := []string{ToolFFmpeg}
+    for name := range cfg.Tools {
+        if t, ok := tools[strings.ToLower(name)]; ok {
+            names = append(names, t.Name)
+        }
+    }
+    sort.Strings(names)
+    return names
+}
+
+// checkToolsConfig rejects TOOLS entries without a known tool, since only
+// known tools come with argument validation.
+func checkToolsConfig(cfg *config.Config) error {
+    for name := range cfg.Tools {
+        if _, ok := tools[strings.ToLower(name)]; !ok {
+            return fmt.Errorf("unknown tool %q in TOOLS (known: mkvmerge, mp4box, exiftool, magick)", name)
+        }
+    }
+    return nil
+}
+
+// bin returns the binary of an enabled tool.
+func (tl Tool) bin(cfg *config.Config) string {
+    if tl.Name == ToolFFmpeg {
+        return cfg.FFBin
+    }
+    if bin, _ := configuredBin(cfg, tl.Name); bin != "" {
+        return bin
+    }
+    return tl.Name
+}
+
+// configuredBin returns the TOOLS entry of a tool. Names are case-insensitive.
+func configuredBin(cfg *config.Config, name string) (string, bool) {
+    for n, bin := range cfg.Tools {
+        if strings.EqualFold(n, name) {
+            return bin, true
+        }
+    }
+    return "", false
+}
+
+// LookupBuild returns the binary of an ffmpeg build: FF_BIN for the empty
+// name, or an FF_BUILDS entry. Names are case-insensitive.
+func LookupBuild(cfg *config.Config, name string) (string, bool) {
+    if name == "" {
+        return cfg.FFBin, true
+    }
+    for n, bin := range cfg.FFBuilds {
+        if strings.EqualFold(n, name) {
+            return bin, true
+        }
+    }
+    return "", false
+}
+
+// ValidateArgs checks split arguments for the tool: the checks of
+// SanitizeAndValidateArgs, then the tool's allow-list. Arguments other than
+// options and their values must name the input, and values must not name
+// files, so tools read and write nothing but the input and the output.
+func (tl Tool) ValidateArgs(args []string) error {
+    if err := SanitizeAndValidateArgs(args); err != nil {
+        return err
+    }
+    if tl.options == nil {
+        return nil
+    }
+    for i := 0; i < len(args); i++ {
+        arg := args[i]
+        if toolInputRe.MatchString(arg) {
+            continue
+        }
+        if !strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "+") {
+            return fmt.Errorf("argument %q is not allowed for %s: only %s may name a file", arg, tl.Name, InputMediaPlaceholder)
+        }
+        if tl.assignment != nil {
+            ok, err := tl.assignment(arg)
+            if err != nil {
+                return err
+            }
+            if ok {
+                continue
+            }
+        }
+        name := arg
+        if tl.foldCase {
+            name = strings.ToLower(name)
+        }
+        value, ok := tl.options[name]
+        if !ok {
+            return fmt.Errorf("option %s is not allowed for %s", arg, tl.Name)
+        }
+        if value == noValue {
+            continue
+        }
+        if i+1 == len(args) {
+            return fmt.Errorf("option %s needs a value", arg)
+        }
+        i++
+        if value == inputValue {
+            if !toolInputRe.MatchString(args[i]) {
+                return fmt.Errorf("option %s only takes %s", arg, InputMediaPlaceholder)
+            }
+            continue
+        }
+        if err := checkToolValue(args[i]); err != nil {
+            return fmt.Errorf("invalid value for %s: %w", arg, err)
+        }
+    }
+    return nil
+}
+
+// checkToolValue rejects option values that could name a file: paths, and
+// ImageMagick's @file references.
+func checkToolValue(value string) error {
+    if strings.ContainsAny(value, `/\`) || strings.HasPrefix(value, "@") || strings.HasPrefix(value, "~") {
+        return fmt.Errorf("%q looks like a file", value)
+    }
+    return nil
+}
+
+// allowedOptions lists the tool's allowed options, sorted.
+func (tl Tool) allowedOptions() []string {
+    var names []string
+    for name := range tl.options {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
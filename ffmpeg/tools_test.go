@@ -0,0 +1,97 @@
+package ffmpeg
+
+import (
+	"testing"
+
+	"ffwebapi/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupTool(t *testing.T) {
+	cfg := &config.Config{FFBin: "/opt/ffmpeg", Tools: map[string]string{"MKVMerge": "/usr/bin/mkvmerge", "magick": ""}}
+
+	tool, ok := LookupTool(cfg, "")
+	require.True(t, ok)
+	assert.Equal(t, ToolFFmpeg, tool.Name)
+	assert.Equal(t, "/opt/ffmpeg", tool.bin(cfg))
+
+	tool, ok = LookupTool(cfg, "mkvmerge")
+	require.True(t, ok)
+	assert.Equal(t, "/usr/bin/mkvmerge", tool.bin(cfg))
+	tool, ok = LookupTool(cfg, "magick")
+	require.True(t, ok)
+	assert.Equal(t, "magick", tool.bin(cfg))
+
+	// Known tools must be enabled, unknown ones never are.
+	_, ok = LookupTool(cfg, "mp4box")
+	assert.False(t, ok)
+	_, ok = LookupTool(&config.Config{Tools: map[string]string{"sh": "/bin/sh"}}, "sh")
+	assert.False(t, ok)
+
+	assert.Equal(t, []string{"ffmpeg", "magick", "mkvmerge"}, EnabledTools(cfg))
+	assert.NoError(t, checkToolsConfig(cfg))
+	assert.Error(t, checkToolsConfig(&config.Config{Tools: map[string]string{"sh": "/bin/sh"}}))
+}
+
+func TestToolValidateArgs(t *testing.T) {
+	cfg := &config.Config{Tools: map[string]string{"mkvmerge": "", "mp4box": "", "exiftool": "", "magick": ""}}
+	cases := []struct {
+		tool  string
+		cmd   string
+		valid bool
+	}{
+		{"mkvmerge", `${INPUT_MEDIA} --language 0:eng`, true},
+		{"mkvmerge", `${INPUT_MEDIA} --output /etc/passwd`, false},
+		{"mkvmerge", `${INPUT_MEDIA} @opts.json`, false},
+		{"mp4box", `-add ${INPUT_MEDIA}#video -fps 25`, true},
+		{"mp4box", `-add ${INPUT_MEDIA} -OUT other.mp4`, false},
+		{"exiftool", `-all= ${INPUT_MEDIA}`, true},
+		{"exiftool", `-tagsFromFile /etc/passwd ${INPUT_MEDIA}`, false},
+		{"magick", `${INPUT_MEDIA} -resize 50%`, true},
+		{"magick", `${INPUT_MEDIA} -write /tmp/x.png`, false},
+		{"magick", `msl:script.xml ${INPUT_MEDIA}`, false},
+		{"magick", `${INPUT_MEDIA} -resize 50% | cat`, false},
+		// Only allow-listed options, and no files but the input
+		{"mkvmerge", `--track-name "0:Main audio" --no-subtitles ${INPUT_MEDIA}`, true},
+		{"mkvmerge", `${INPUT_MEDIA} /etc/passwd`, false},
+		{"mkvmerge", `${INPUT_MEDIA} --language`, false},
+		{"mp4box", `-add /etc/passwd`, false},
+		{"mp4box", `-add ${INPUT_MEDIA} -add other.mp4`, false},
+		{"mp4box", `-add ${INPUT_MEDIA} -itags cover=/etc/passwd`, false},
+		{"exiftool", `-XMP:Title="Holiday 2024" -Keywords+=beach -overwrite_original ${INPUT_MEDIA}`, true},
+		{"exiftool", `-if "system('id')" ${INPUT_MEDIA}`, false},
+		{"exiftool", `-FileName=moved.jpg ${INPUT_MEDIA}`, false},
+		{"exiftool", `-System:Directory=x ${INPUT_MEDIA}`, false},
+		{"exiftool", `${INPUT_MEDIA} other.jpg`, false},
+		{"magick", `${INPUT_MEDIA}[0] -auto-orient -quality 85 -strip`, true},
+		{"magick", `${INPUT_MEDIA} -draw "text 0,0 '@/etc/passwd'"`, false},
+		{"magick", `${INPUT_MEDIA} -texture pattern.png`, false},
+		{"magick", `${INPUT_MEDIA} -profile /etc/icc`, false},
+		{"magick", `${INPUT_MEDIA} -fill @/etc/passwd`, false},
+		{"magick", `${INPUT_MEDIA} other.png`, false},
+	}
+	for _, tc := range cases {
+		tool, ok := LookupTool(cfg, tc.tool)
+		require.True(t, ok, tc.tool)
+		args, err := SplitCommand(tc.cmd)
+		require.NoError(t, err)
+		err = tool.ValidateArgs(args)
+		if tc.valid {
+			assert.NoError(t, err, tc.cmd)
+		} else {
+			assert.Error(t, err, tc.cmd)
+		}
+	}
+}
+
+func TestToolPlaceOutput(t *testing.T) {
+	cfg := &config.Config{Tools: map[string]string{"mkvmerge": "", "mp4box": ""}}
+	mkvmerge, _ := LookupTool(cfg, "mkvmerge")
+	assert.Equal(t, []string{"-o", "out.mkv", "in.mp4"}, mkvmerge.placeOutput([]string{"in.mp4"}, "out.mkv"))
+	mp4box, _ := LookupTool(cfg, "mp4box")
+	assert.Equal(t, []string{"-add", "in.mkv", "-out", "out.mp4"}, mp4box.placeOutput([]string{"-add", "in.mkv"}, "out.mp4"))
+	ffmpeg, _ := LookupTool(cfg, "")
+	assert.Equal(t, []string{"-i", "in.mkv", "out.mp4"}, ffmpeg.placeOutput([]string{"-i", "in.mkv"}, "out.mp4"))
+}
@@ -0,0 +1,117 @@
+package ffmpeg
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+    "os"
+    "time"
+
+    "ffwebapi/logging"
+    "ffwebapi/netguard"
+    "ffwebapi/task"
+    "ffwebapi/tracing"
+    "ffwebapi/utils"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/trace"
+)
+
+// Output uploads are tried this often, backing off by uploadBackoff doubled
+// each time, when the network or the destination fails transiently.
+const (
+    uploadAttempts = 3
+    uploadBackoff  = time.Second
+)
+
+// uploadOutput sends the primary output of a succeeded task to its
+// OutputUpload destination and records the outcome in the task. A failed
+// upload leaves the task completed, as the output can still be downloaded.
+func (r *Runner) uploadOutput(ctx context.Context, t *task.Task) {
+    // The outcome is recorded in a copy, which replaces the task's at the end.
+    result := *t.OutputUpload
+    up := &result
+    defer t.Update(func() { *t.OutputUpload = result })
+    ctx, span := tracing.Tracer().Start(ctx, "output.upload", trace.WithAttributes(attribute.String("upload.method", up.Method)))
+    var err error
+    delay := uploadBackoff
+    for attempt := 1; attempt <= uploadAttempts; attempt++ {
+        var retry bool
+        if retry, err = r.putOutput(ctx, t.OutputPath, up); !retry {
+            break
+        }
+        if attempt < uploadAttempts {
+            select {
+            case <-ctx.Done():
+                err = ctx.Err()
+                attempt = uploadAttempts
+            case <-time.After(delay):
+                delay *= 2
+            }
+        }
+    }
+    tracing.End(span, err)
+
+    if err != nil {
+        up.Status, up.Error = task.UploadFailed, err.Error()
+        logging.FromContext(ctx).Warn("Output upload failed", "error", err)
+        return
+    }
+    up.Status, up.Error, up.UploadedAt = task.UploadUploaded, "", time.Now()
+    logging.FromContext(ctx).Info("Output uploaded", "bytes", up.Bytes, "status_code", up.StatusCode)
+}
+
+// putOutput makes one upload attempt and reports whether another one may
+// succeed. Errors never contain the URL, which may carry credentials.
+func (r *Runner) putOutput(ctx context.Context, path string, up *task.OutputUpload) (retry bool, err error) {
+    // The URL was checked at submission, but the policy may have changed since.
+    if err := netguard.InputPolicy(r.cfg).CheckURL(up.URL); err != nil {
+        var egress *netguard.EgressError
+        if errors.As(err, &egress) {
+            return false, fmt.Errorf("egress to the upload destination denied: %s", egress.Reason)
+        }
+        return false, fmt.Errorf("upload destination is not usable")
+    }
+    f, err := os.Open(path)
+    if err != nil {
+        return false, fmt.Errorf("could not open output: %w", err)
+    }
+    defer f.Close()
+    info, err := f.Stat()
+    if err != nil {
+        return false, err
+    }
+    if info.IsDir() {
+        return false, fmt.Errorf("directory outputs cannot be uploaded")
+    }
+
+    req, err := http.NewRequestWithContext(ctx, up.Method, up.URL, f)
+    if err != nil {
+        return false, fmt.Errorf("invalid upload request")
+    }
+    req.ContentLength = info.Size() // Presigned S3 URLs refuse chunked uploads
+    if contentType := utils.ContentTypeOf(path); contentType != "" {
+        req.Header.Set("Content-Type", contentType)
+    }
+    for name, value := range up.Headers {
+        req.Header.Set(name, value)
+    }
+    client := netguard.InputPolicy(r.cfg).Client(0)
+    defer client.CloseIdleConnections()
+    resp, err := client.Do(req)
+    var urlErr *url.Error
+    if errors.As(err, &urlErr) {
+        return ctx.Err() == nil, fmt.Errorf("upload request failed: %w", urlErr.Err)
+    }
+    if err != nil {
+        return false, err
+    }
+    resp.Body.Close()
+    up.StatusCode = resp.StatusCode
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, fmt.Errorf("destination answered %s", resp.Status)
+    }
+    up.Bytes = info.Size()
+    return false, nil
+}
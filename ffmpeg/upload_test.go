@@ -0,0 +1,88 @@
+package ffmpeg
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"ffwebapi/config"
+	"ffwebapi/task"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadOutput(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "output.mp4")
+	require.NoError(t, os.WriteFile(output, []byte("media"), 0o600))
+	r := &Runner{tempDir: dir, cfg: &config.Config{TempDir: dir, InputAllowPrivate: true}}
+
+	t.Run("uploaded", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			assert.Equal(t, http.MethodPut, req.Method)
+			assert.Equal(t, "/bucket/out.mp4", req.URL.Path)
+			assert.Equal(t, "video/mp4", req.Header.Get("Content-Type"))
+			assert.Equal(t, "private", req.Header.Get("X-Amz-Acl"))
+			assert.Equal(t, int64(5), req.ContentLength)
+			data, _ := io.ReadAll(req.Body)
+			assert.Equal(t, "media", string(data))
+		}))
+		defer srv.Close()
+
+		tk := &task.Task{ID: "t1", OutputPath: output, OutputUpload: &task.OutputUpload{
+			URL: srv.URL + "/bucket/out.mp4?X-Amz-Signature=secret", Method: http.MethodPut,
+			Headers: map[string]string{"X-Amz-Acl": "private"}, Status: task.UploadPending,
+		}}
+		r.uploadOutput(context.Background(), tk)
+		assert.Equal(t, task.UploadUploaded, tk.OutputUpload.Status)
+		assert.Equal(t, http.StatusOK, tk.OutputUpload.StatusCode)
+		assert.Equal(t, int64(5), tk.OutputUpload.Bytes)
+		assert.False(t, tk.OutputUpload.UploadedAt.IsZero())
+	})
+
+	t.Run("retries server errors", func(t *testing.T) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if calls.Add(1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		defer srv.Close()
+
+		tk := &task.Task{ID: "t2", OutputPath: output, OutputUpload: &task.OutputUpload{URL: srv.URL, Method: http.MethodPost}}
+		r.uploadOutput(context.Background(), tk)
+		assert.Equal(t, task.UploadUploaded, tk.OutputUpload.Status)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("client errors fail without retry", func(t *testing.T) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusForbidden)
+		}))
+		defer srv.Close()
+
+		tk := &task.Task{ID: "t3", OutputPath: output, OutputUpload: &task.OutputUpload{URL: srv.URL + "/?sig=secret", Method: http.MethodPut}}
+		r.uploadOutput(context.Background(), tk)
+		assert.Equal(t, task.UploadFailed, tk.OutputUpload.Status)
+		assert.Equal(t, http.StatusForbidden, tk.OutputUpload.StatusCode)
+		assert.Equal(t, int32(1), calls.Load())
+		assert.NotContains(t, tk.OutputUpload.Error, "secret")
+	})
+
+	t.Run("egress denied", func(t *testing.T) {
+		r := &Runner{tempDir: dir, cfg: &config.Config{TempDir: dir, InputAllowedSchemes: []string{"https"}}}
+		tk := &task.Task{ID: "t4", OutputPath: output, OutputUpload: &task.OutputUpload{URL: "http://127.0.0.1:1/?sig=secret", Method: http.MethodPut}}
+		r.uploadOutput(context.Background(), tk)
+		assert.Equal(t, task.UploadFailed, tk.OutputUpload.Status)
+		assert.Contains(t, tk.OutputUpload.Error, "scheme")
+		assert.NotContains(t, tk.OutputUpload.Error, "secret")
+	})
+}
@@ -0,0 +1,61 @@
+package ffmpeg
+
+import (
+    "bufio"
+    "io"
+    "regexp"
+    "strings"
+
+    "ffwebapi/task"
+)
+
+// maxWarningMessage bounds the log line quoted in a warning.
+const maxWarningMessage = 200
+
+// warningPatterns are ffmpeg log messages that hint at a suspect output even
+// though ffmpeg exited successfully.
+var warningPatterns = []struct {
+    code string
+    re   *regexp.Regexp
+}{
+    {"non_monotonic_dts", regexp.MustCompile(`(?i)non[- ]monoton(ous|ically increasing) dts`)},
+    {"deprecated_pixel_format", regexp.MustCompile(`(?i)deprecated pixel format used`)},
+    {"missing_codec_parameters", regexp.MustCompile(`(?i)could not find codec parameters`)},
+    {"timestamps_unset", regexp.MustCompile(`(?i)timestamps are unset in a packet`)},
+}
+
+// ParseWarnings scans ffmpeg's output for known warnings. Each kind is
+// reported once, in order of first occurrence, with the first matching line
+// and the number of matches.
+func ParseWarnings(output string) []task.Warning {
+    warnings, _ := ScanWarnings(strings.NewReader(output))
+    return warnings
+}
+
+// ScanWarnings is ParseWarnings for output read from r, e.g. a log file.
+func ScanWarnings(r io.Reader) ([]task.Warning, error) {
+    var warnings []task.Warning
+    index := make(map[string]int)
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(make([]byte, 64<<10), 1<<20)
+    scanner.Split(task.ScanLogLines)
+    for scanner.Scan() {
+        line := scanner.Text()
+        for _, p := range warningPatterns {
+            if !p.re.MatchString(line) {
+                continue
+            }
+            if i, ok := index[p.code]; ok {
+                warnings[i].Count++
+                continue
+            }
+            message := strings.TrimSpace(line)
+            if len(message) > maxWarningMessage {
+                message = message[:maxWarningMessage]
+            }
+            index[p.code] = len(warnings)
+            warnings = append(warnings, task.Warning{Code: p.code, Message: message, Count: 1})
+        }
+    }
+    return warnings, scanner.Err()
+}
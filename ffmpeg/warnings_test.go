@@ -0,0 +1,25 @@
+package ffmpeg
+
+import (
+	"testing"
+
+	"ffwebapi/task"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWarnings(t *testing.T) {
+	output := `Input #0, matroska,webm, from 'input.mkv':
+[h264 @ 0x55d1c8a2c940] Could not find codec parameters for stream 1 (Subtitle: hdmv_pgs_subtitle): unspecified size
+[swscaler @ 0x55d1c8b0e200] deprecated pixel format used, make sure you did set range correctly
+[mp4 @ 0x55d1c8a31c80] Application provided invalid, non monotonically increasing dts to muxer in stream 0: 1024 >= 1024
+[mp4 @ 0x55d1c8a31c80] Application provided invalid, non monotonically increasing dts to muxer in stream 0: 2048 >= 2048
+frame=  250 fps=0.0 q=-1.0 Lsize=     512kB time=00:00:10.00 bitrate= 419.4kbits/s speed=20x`
+
+	assert.Equal(t, []task.Warning{
+		{Code: "missing_codec_parameters", Message: "[h264 @ 0x55d1c8a2c940] Could not find codec parameters for stream 1 (Subtitle: hdmv_pgs_subtitle): unspecified size", Count: 1},
+		{Code: "deprecated_pixel_format", Message: "[swscaler @ 0x55d1c8b0e200] deprecated pixel format used, make sure you did set range correctly", Count: 1},
+		{Code: "non_monotonic_dts", Message: "[mp4 @ 0x55d1c8a31c80] Application provided invalid, non monotonically increasing dts to muxer in stream 0: 1024 >= 1024", Count: 2},
+	}, ParseWarnings(output))
+
+	assert.Empty(t, ParseWarnings("frame=  250 fps=0.0 q=-1.0 Lsize=     512kB"))
+}
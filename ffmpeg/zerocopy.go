@@ -0,0 +1,69 @@
+package ffmpeg
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "ffwebapi/config"
+)
+
+// zeroCopyDirs returns ZERO_COPY_INPUT_DIRS with symlinks resolved, skipping
+// those that don't exist.
+func zeroCopyDirs(cfg *config.Config) []string {
+    return resolveDirs(cfg.ZeroCopyInputDirs)
+}
+
+// resolveDirs returns dirs with symlinks resolved, skipping those that don't
+// exist.
+func resolveDirs(dirs []string) []string {
+    var resolved []string
+    for _, dir := range dirs {
+        if path, err := filepath.EvalSymlinks(dir); err == nil {
+            resolved = append(resolved, path)
+        }
+    }
+    return resolved
+}
+
+// zeroCopyPath resolves a local input inside ZERO_COPY_INPUT_DIRS, which
+// ffmpeg then reads in place rather than from a copy in its working
+// directory. ok is false for inputs anywhere else, which are copied as
+// usual. Symlinks are resolved first, so none leads out of the trusted mounts.
+func zeroCopyPath(cfg *config.Config, input string) (path string, ok bool, err error) {
+    if len(cfg.ZeroCopyInputDirs) == 0 {
+        return "", false, nil
+    }
+    abs, err := filepath.Abs(input)
+    if err != nil {
+        return "", false, nil
+    }
+    path, err = filepath.EvalSymlinks(abs)
+    if err != nil {
+        return "", false, nil // Reported by the copy
+    }
+    for _, dir := range zeroCopyDirs(cfg) {
+        if !isWithin(dir, path) {
+            continue
+        }
+        info, err := os.Stat(path)
+        if err != nil {
+            return "", true, fmt.Errorf("could not open local input file: %w", err)
+        }
+        if !info.Mode().IsRegular() {
+            return "", true, fmt.Errorf("local input %s is not a regular file", input)
+        }
+        if info.Size() > cfg.MaxInputSize {
+            return "", true, fmt.Errorf("input file size %d exceeds limit of %d bytes", info.Size(), cfg.MaxInputSize)
+        }
+        return path, true, nil
+    }
+    return "", false, nil
+}
+
+// isWithin reports whether path lies below dir.
+func isWithin(dir, path string) bool {
+    rel, err := filepath.Rel(dir, path)
+    return err == nil && rel != "." && !strings.HasPrefix(rel, "..")
+}
@@ -0,0 +1,53 @@
+package ffmpeg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ffwebapi/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZeroCopyPath(t *testing.T) {
+	share, elsewhere := t.TempDir(), t.TempDir()
+	share, _ = filepath.EvalSymlinks(share)
+	require.NoError(t, os.WriteFile(filepath.Join(share, "movie.mp4"), []byte("0123456789"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(elsewhere, "secret.mp4"), []byte("x"), 0o644))
+	require.NoError(t, os.Symlink(filepath.Join(elsewhere, "secret.mp4"), filepath.Join(share, "escape.mp4")))
+	require.NoError(t, os.Mkdir(filepath.Join(share, "dir"), 0o755))
+
+	// Off unless configured.
+	_, ok, err := zeroCopyPath(&config.Config{MaxInputSize: 100}, filepath.Join(share, "movie.mp4"))
+	assert.False(t, ok)
+	assert.NoError(t, err)
+
+	cfg := &config.Config{MaxInputSize: 100, ZeroCopyInputDirs: []string{share}}
+	path, ok, err := zeroCopyPath(cfg, filepath.Join(share, "movie.mp4"))
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(share, "movie.mp4"), path)
+
+	for name, input := range map[string]string{
+		"outside the share":         filepath.Join(elsewhere, "secret.mp4"),
+		"symlink out":               filepath.Join(share, "escape.mp4"),
+		"dot-dot out":               filepath.Join(share, "..", filepath.Base(elsewhere), "secret.mp4"),
+		"the share itself":          share,
+		"missing, left to the copy": filepath.Join(share, "missing.mp4"),
+	} {
+		_, ok, err := zeroCopyPath(cfg, input)
+		assert.False(t, ok, name)
+		assert.NoError(t, err, name)
+	}
+
+	_, ok, err = zeroCopyPath(cfg, filepath.Join(share, "dir"))
+	assert.True(t, ok)
+	assert.ErrorContains(t, err, "not a regular file")
+
+	cfg.MaxInputSize = 5
+	_, ok, err = zeroCopyPath(cfg, filepath.Join(share, "movie.mp4"))
+	assert.True(t, ok)
+	assert.ErrorContains(t, err, "exceeds limit")
+}
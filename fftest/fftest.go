@@ -0,0 +1,319 @@
+// Package fftest runs an in-process FFWebAPI server for integration tests of
+// services that call the API. It serves the real HTTP API (same routes,
+// validation, responses and errors). With NewServer tasks never run ffmpeg:
+// each one takes a scripted time and then succeeds with placeholder output
+// files or fails with a scripted error.
+//
+//	srv := fftest.NewServer(t)
+//	srv.Enqueue(fftest.Outcome{Delay: 100 * time.Millisecond}, fftest.Outcome{Err: "invalid data"})
+//	client := myservice.NewClient(srv.URL + "/api/v2")
+//
+// NewFFmpegServer runs the tasks with the real ffmpeg instead, on inputs
+// made by SyntheticMedia:
+//
+//	srv := fftest.NewFFmpegServer(t)
+//	input := fftest.SyntheticMedia(t, 2*time.Second, "mp4")
+//
+// State lives in memory and in temporary directories removed on Close.
+package fftest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"ffwebapi/api"
+	"ffwebapi/auth"
+	"ffwebapi/config"
+	"ffwebapi/ffmpeg"
+	"ffwebapi/task"
+	"github.com/gin-gonic/gin"
+)
+
+// Outcome scripts how a task runs.
+type Outcome struct {
+	Delay  time.Duration // How long the task runs before finishing
+	Err    string        // Fails the task with this error; it succeeds if empty
+	Log    string        // ffmpeg output, served as the task's ffmpeg.log
+	Output []byte        // Contents of every output file; placeholder bytes if nil
+}
+
+// Task describes a task the server ran.
+type Task struct {
+	ID         string
+	Command    string
+	InputMedia string
+	OutputExt  string
+	Tool       string // Empty for ffmpeg
+	Attempt    int
+}
+
+// Option adjusts the configuration of a server, e.g. to enable API keys.
+type Option func(cfg *config.Config)
+
+// WithAuthKey requires clients to authenticate with the given admin key.
+func WithAuthKey(key string) Option {
+	return func(cfg *config.Config) {
+		cfg.AuthEnable = true
+		cfg.AuthKey = key
+	}
+}
+
+// Server is a running fake FFWebAPI server. The API is served under
+// URL + "/api/v1" and URL + "/api/v2".
+type Server struct {
+	*httptest.Server
+	Config  *config.Config
+	Manager *task.Manager
+
+	runner    *scriptedRunner // Nil for NewFFmpegServer
+	cancel    context.CancelFunc
+	dir       string
+	runnerDir string // Temporary directory of the ffmpeg runner
+}
+
+// NewServer starts a server and closes it when the test ends. It uses the
+// built-in defaults (config.Defaults), not the environment or config files;
+// options adjust them. It puts gin into test mode to keep test output quiet.
+func NewServer(tb testing.TB, opts ...Option) *Server {
+	tb.Helper()
+	runner := &scriptedRunner{}
+	s := newServer(tb, opts, func(cfg *config.Config) (task.FFmpegRunner, error) {
+		runner.cfg = cfg
+		return runner, nil
+	})
+	s.runner = runner
+	return s
+}
+
+// NewFFmpegServer starts a server whose tasks run the real ffmpeg (FF_BIN,
+// "ffmpeg" from the PATH by default), for end-to-end tests of the server
+// itself or of services embedding it. The test is skipped if ffmpeg is not
+// installed. Outcomes cannot be scripted; SyntheticMedia makes inputs.
+func NewFFmpegServer(tb testing.TB, opts ...Option) *Server {
+	tb.Helper()
+	var runnerDir string
+	s := newServer(tb, opts, func(cfg *config.Config) (task.FFmpegRunner, error) {
+		if _, err := exec.LookPath(cfg.FFBin); err != nil {
+			return nil, fmt.Errorf("%w: %v", errNoFFmpeg, err)
+		}
+		r, err := ffmpeg.NewRunner(cfg) // Replaces cfg.TempDir with its own
+		runnerDir = cfg.TempDir
+		return r, err
+	})
+	s.runnerDir = runnerDir
+	return s
+}
+
+// errNoFFmpeg skips tests of NewFFmpegServer on hosts without ffmpeg.
+var errNoFFmpeg = errors.New("ffmpeg not found")
+
+func newServer(tb testing.TB, opts []Option, newRunner func(cfg *config.Config) (task.FFmpegRunner, error)) *Server {
+	tb.Helper()
+	gin.SetMode(gin.TestMode)
+	dir, err := os.MkdirTemp("", "fftest_")
+	if err != nil {
+		tb.Fatalf("fftest: %v", err)
+	}
+	cfg, err := config.Defaults()
+	if err != nil {
+		os.RemoveAll(dir)
+		tb.Fatalf("fftest: %v", err)
+	}
+	cfg.TempDir = dir
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	runner, err := newRunner(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		if errors.Is(err, errNoFFmpeg) {
+			tb.Skipf("fftest: %v", err)
+		}
+		tb.Fatalf("fftest: %v", err)
+	}
+	tm, err := task.NewManager(cfg, runner)
+	if err != nil {
+		os.RemoveAll(dir)
+		tb.Fatalf("fftest: %v", err)
+	}
+	keys, err := auth.NewStore(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		tb.Fatalf("fftest: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	tm.Start(ctx)
+
+	s := &Server{
+		Server:  httptest.NewServer(api.SetupRouter(tm, keys, cfg)),
+		Config:  cfg,
+		Manager: tm,
+		cancel:  cancel,
+		dir:     dir,
+	}
+	tb.Cleanup(s.Close)
+	return s
+}
+
+// Close shuts the server down and removes its files.
+func (s *Server) Close() {
+	s.Server.Close()
+	s.cancel()
+	os.RemoveAll(s.dir)
+	if s.runnerDir != "" {
+		os.RemoveAll(s.runnerDir)
+	}
+}
+
+// SetDefault sets the outcome of tasks without an enqueued or handled one.
+// Tasks succeed right away by default.
+func (s *Server) SetDefault(o Outcome) {
+	s.scripted("SetDefault")
+	s.runner.mu.Lock()
+	defer s.runner.mu.Unlock()
+	s.runner.fallback = o
+}
+
+// Enqueue scripts the outcomes of the next task attempts, in the order they
+// start. Retries of a task take the next outcome too.
+func (s *Server) Enqueue(outcomes ...Outcome) {
+	s.scripted("Enqueue")
+	s.runner.mu.Lock()
+	defer s.runner.mu.Unlock()
+	s.runner.queue = append(s.runner.queue, outcomes...)
+}
+
+// Handle decides the outcome of every task attempt without an enqueued one,
+// e.g. by its command or input. A nil function removes the handler.
+func (s *Server) Handle(fn func(Task) Outcome) {
+	s.scripted("Handle")
+	s.runner.mu.Lock()
+	defer s.runner.mu.Unlock()
+	s.runner.handler = fn
+}
+
+// Tasks returns the task attempts the server started, in order.
+func (s *Server) Tasks() []Task {
+	s.scripted("Tasks")
+	s.runner.mu.Lock()
+	defer s.runner.mu.Unlock()
+	return append([]Task(nil), s.runner.started...)
+}
+
+// scripted panics unless the server's outcomes are scripted.
+func (s *Server) scripted(method string) {
+	if s.runner == nil {
+		panic("fftest: " + method + " needs a server started with NewServer")
+	}
+}
+
+// scriptedRunner stands in for the ffmpeg runner.
+type scriptedRunner struct {
+	cfg      *config.Config
+	mu       sync.Mutex
+	queue    []Outcome
+	handler  func(Task) Outcome
+	fallback Outcome
+	started  []Task
+}
+
+func (r *scriptedRunner) Run(ctx context.Context, t *task.Task) (string, error) {
+	info := Task{ID: t.ID, Command: t.Command, InputMedia: t.InputMedia, OutputExt: t.OutputExt, Tool: t.Tool, Attempt: t.Attempt}
+	o := r.next(info)
+
+	timer := time.NewTimer(o.Delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return o.Log, ctx.Err()
+	case <-timer.C:
+	}
+	if o.Err != "" {
+		return o.Log, errors.New(o.Err)
+	}
+	return o.Log, publish(r.cfg, t, o.Output)
+}
+
+func (r *scriptedRunner) next(info Task) Outcome {
+	r.mu.Lock()
+	r.started = append(r.started, info)
+	if len(r.queue) > 0 {
+		o := r.queue[0]
+		r.queue = r.queue[1:]
+		r.mu.Unlock()
+		return o
+	}
+	handler, fallback := r.handler, r.fallback
+	r.mu.Unlock()
+	if handler != nil {
+		return handler(info) // Unlocked, so the handler may script further outcomes
+	}
+	return fallback
+}
+
+// publish writes the output files of a succeeded task where the real runner
+// publishes them, so downloads work as usual.
+func publish(cfg *config.Config, t *task.Task, data []byte) error {
+	if data == nil {
+		data = []byte("fftest output of " + t.ID)
+	}
+	filesDir := task.FilesDir(cfg, t.ID)
+	if err := os.MkdirAll(filesDir, 0o700); err != nil {
+		return err
+	}
+	write := func(path string) error {
+		return os.WriteFile(path, data, 0o600)
+	}
+
+	switch {
+	case t.OutputMode == task.OutputModeDirectory:
+		dir := filepath.Join(filesDir, task.ArtifactOutput)
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+		entry := t.OutputEntry
+		if entry == "" {
+			entry = "index." + t.OutputExt
+		}
+		path := filepath.Join(dir, entry)
+		if err := write(path); err != nil {
+			return err
+		}
+		t.Update(func() {
+			t.OutputDir, t.OutputPath = dir, path
+			t.AddArtifact(task.ArtifactOutput, task.ArtifactOutput, path).Dir = dir
+		})
+		return nil
+	case len(t.OutputExts) > 0:
+		var paths []string
+		for i, ext := range t.OutputExts {
+			path := filepath.Join(filesDir, fmt.Sprintf("%s_%d.%s", task.ArtifactOutput, i, ext))
+			if err := write(path); err != nil {
+				return err
+			}
+			t.Update(func() { t.AddArtifact(fmt.Sprintf("%s_%d", task.ArtifactOutput, i), task.ArtifactOutput, path) })
+			paths = append(paths, path)
+		}
+		t.Update(func() { t.OutputPaths, t.OutputPath = paths, paths[0] })
+		return nil
+	default:
+		path := filepath.Join(filesDir, fmt.Sprintf("%s.%s", task.ArtifactOutput, t.OutputExt))
+		if err := write(path); err != nil {
+			return err
+		}
+		t.Update(func() {
+			t.OutputPath = path
+			t.AddArtifact(task.ArtifactOutput, task.ArtifactOutput, path)
+		})
+		return nil
+	}
+}
@@ -0,0 +1,128 @@
+package fftest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"ffwebapi/fftest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type taskStatus struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	Error       string `json:"error"`
+	DownloadURL string `json:"downloadUrl"`
+}
+
+func submit(t *testing.T, srv *fftest.Server, body string) string {
+	t.Helper()
+	resp, err := http.Post(srv.URL+"/api/v2/tasks", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	var accepted map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&accepted))
+	return accepted["taskId"].(string)
+}
+
+func status(t *testing.T, srv *fftest.Server, id string) taskStatus {
+	t.Helper()
+	resp, err := http.Get(srv.URL + "/api/v2/tasks/" + id)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var st taskStatus
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&st))
+	return st
+}
+
+func waitFor(t *testing.T, srv *fftest.Server, id string) taskStatus {
+	t.Helper()
+	var st taskStatus
+	require.Eventually(t, func() bool {
+		st = status(t, srv, id)
+		return st.Status != "queued" && st.Status != "processing"
+	}, 5*time.Second, 10*time.Millisecond)
+	return st
+}
+
+func TestServer(t *testing.T) {
+	srv := fftest.NewServer(t)
+	srv.Enqueue(
+		fftest.Outcome{Delay: 50 * time.Millisecond, Output: []byte("encoded")},
+		fftest.Outcome{Err: "Invalid data found when processing input"},
+	)
+
+	id := submit(t, srv, `{"command": "-i ${INPUT_MEDIA} -c:v libx264", "inputMedia": "https://example.com/in.mov", "outputExt": "mp4"}`)
+	assert.Equal(t, "processing", status(t, srv, id).Status)
+	st := waitFor(t, srv, id)
+	assert.Equal(t, "completed", st.Status)
+	require.NotEmpty(t, st.DownloadURL)
+	resp, err := http.Get(st.DownloadURL)
+	require.NoError(t, err)
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "encoded", string(data))
+
+	failed := submit(t, srv, `{"command": "-i ${INPUT_MEDIA} -c:v libx264", "inputMedia": "https://example.com/broken.mov", "outputExt": "mp4"}`)
+	st = waitFor(t, srv, failed)
+	assert.Equal(t, "failed", st.Status)
+	assert.Contains(t, st.Error, "Invalid data")
+
+	// Requests are validated like by the real server.
+	resp, err = http.Post(srv.URL+"/api/v2/tasks", "application/json", strings.NewReader(`{"command": "-i ${INPUT_MEDIA}; rm -rf /", "inputMedia": "in.mov", "outputExt": "mp4"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	tasks := srv.Tasks()
+	require.Len(t, tasks, 2)
+	assert.Equal(t, id, tasks[0].ID)
+	assert.Equal(t, "https://example.com/broken.mov", tasks[1].InputMedia)
+}
+
+func TestServer_HandleAndCancel(t *testing.T) {
+	srv := fftest.NewServer(t)
+	srv.Handle(func(tk fftest.Task) fftest.Outcome {
+		if strings.Contains(tk.Command, "slow") {
+			return fftest.Outcome{Delay: time.Hour}
+		}
+		return fftest.Outcome{}
+	})
+
+	slow := submit(t, srv, `{"command": "-i ${INPUT_MEDIA} -metadata title=slow", "inputMedia": "https://example.com/in.mov", "outputExt": "mp4"}`)
+	require.Eventually(t, func() bool { return status(t, srv, slow).Status == "processing" }, time.Second, 10*time.Millisecond)
+	req, _ := http.NewRequest(http.MethodPatch, srv.URL+"/api/v2/tasks/"+slow+"/cancel", nil)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "canceled", waitFor(t, srv, slow).Status)
+
+	fast := submit(t, srv, `{"command": "-i ${INPUT_MEDIA} ${OUTPUT_0} -frames:v 1 ${OUTPUT_1}", "inputMedia": "https://example.com/in.mov", "outputs": ["mp4", "jpg"]}`)
+	assert.Equal(t, "completed", waitFor(t, srv, fast).Status)
+}
+
+func TestServer_AuthKey(t *testing.T) {
+	srv := fftest.NewServer(t, fftest.WithAuthKey("secret"))
+
+	body := `{"command": "-i ${INPUT_MEDIA}", "inputMedia": "https://example.com/in.mov", "outputExt": "mp4"}`
+	resp, err := http.Post(srv.URL+"/api/v2/tasks", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/v2/tasks", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+}
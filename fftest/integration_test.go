@@ -0,0 +1,120 @@
+//go:build integration
+
+package fftest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"ffwebapi/fftest"
+	"ffwebapi/task"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The tests in this file run the real ffmpeg. They are opt-in:
+//
+//	go test -tags integration ./fftest
+
+type progressStatus struct {
+	taskStatus
+	Percent float64 `json:"percent"`
+}
+
+func submitInput(t *testing.T, srv *fftest.Server, command, input, outputExt string) string {
+	t.Helper()
+	body, err := json.Marshal(map[string]string{"command": command, "inputMedia": input, "outputExt": outputExt})
+	require.NoError(t, err)
+	return submit(t, srv, string(body))
+}
+
+// follow polls a task until it finishes and returns its final state and the
+// highest percent reported while it was processing.
+func follow(t *testing.T, srv *fftest.Server, id string) (progressStatus, float64) {
+	t.Helper()
+	deadline := time.Now().Add(time.Minute)
+	var maxPercent float64
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(srv.URL + "/api/v2/tasks/" + id)
+		require.NoError(t, err)
+		var st progressStatus
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&st))
+		resp.Body.Close()
+		switch st.Status {
+		case "queued":
+		case "processing":
+			maxPercent = max(maxPercent, st.Percent)
+		default:
+			return st, maxPercent
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("task %s did not finish within a minute", id)
+	return progressStatus{}, 0
+}
+
+func TestIntegration_Lifecycle(t *testing.T) {
+	srv := fftest.NewFFmpegServer(t)
+	input := fftest.SyntheticMedia(t, 3*time.Second, "mp4")
+
+	// -re reads the input in real time, so progress is reported on the way.
+	id := submitInput(t, srv, "-re -i ${INPUT_MEDIA} -c:v mpeg4 -c:a aac", input, "mkv")
+	st, maxPercent := follow(t, srv, id)
+	require.Equal(t, "completed", st.Status, st.Error)
+	assert.Greater(t, maxPercent, 0.0, "no progress reported while processing")
+	assert.Less(t, maxPercent, 100.0)
+
+	require.NotEmpty(t, st.DownloadURL)
+	resp, err := http.Get(st.DownloadURL)
+	require.NoError(t, err)
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, bytes.HasPrefix(data, []byte{0x1a, 0x45, 0xdf, 0xa3}), "output is not Matroska")
+
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/api/v2/tasks/"+id, nil)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	_, err = os.Stat(task.FilesDir(srv.Config, id))
+	assert.True(t, os.IsNotExist(err), "task files are removed")
+	resp, err = http.Get(st.DownloadURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestIntegration_Failure(t *testing.T) {
+	srv := fftest.NewFFmpegServer(t)
+	input := fftest.SyntheticMedia(t, time.Second, "mp4")
+
+	id := submitInput(t, srv, "-i ${INPUT_MEDIA} -c:v no_such_encoder", input, "mkv")
+	st, _ := follow(t, srv, id)
+	assert.Equal(t, "failed", st.Status)
+	assert.Contains(t, st.Error, "execution failed")
+
+	resp, err := http.Get(fmt.Sprintf("%s/api/v2/tasks/%s/logs?tail=20", srv.URL, id))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	log, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(log), "no_such_encoder")
+}
+
+func TestIntegration_AudioOnly(t *testing.T) {
+	srv := fftest.NewFFmpegServer(t)
+	input := fftest.SyntheticMedia(t, time.Second, "wav")
+
+	id := submitInput(t, srv, "-i ${INPUT_MEDIA} -c:a pcm_s16le", input, "wav")
+	st, _ := follow(t, srv, id)
+	require.Equal(t, "completed", st.Status, st.Error)
+}
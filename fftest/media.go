@@ -0,0 +1,37 @@
+package fftest
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// SyntheticMedia generates a clip of the given duration with ffmpeg's
+// testsrc pattern and a sine tone, encoded with codecs every ffmpeg build
+// has, and returns its path in a temporary directory removed when the test
+// ends. ext picks the container, e.g. "mp4", "mkv" or "wav". The test is
+// skipped if ffmpeg is not installed.
+func SyntheticMedia(tb testing.TB, duration time.Duration, ext string) string {
+	tb.Helper()
+	bin, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		tb.Skipf("fftest: ffmpeg not found: %v", err)
+	}
+	seconds := fmt.Sprintf("%.3f", duration.Seconds())
+	path := filepath.Join(tb.TempDir(), "synthetic."+ext)
+	args := []string{"-hide_banner", "-nostdin", "-y"}
+	if ext != "wav" {
+		args = append(args, "-f", "lavfi", "-i", "testsrc=size=320x240:rate=25:duration="+seconds)
+	}
+	args = append(args, "-f", "lavfi", "-i", "sine=frequency=440:duration="+seconds)
+	if ext != "wav" {
+		args = append(args, "-c:v", "mpeg4", "-c:a", "aac")
+	}
+	args = append(args, "-shortest", path)
+	if out, err := exec.Command(bin, args...).CombinedOutput(); err != nil {
+		tb.Fatalf("fftest: generating synthetic media: %v\n%s", err, out)
+	}
+	return path
+}
@@ -0,0 +1,139 @@
+// Package ffwebapi embeds the FFWebAPI server in another Go program. New
+// wires the task manager, the HTTP API and the watch folders from a
+// configuration; the program mounts Handler on its own router and submits
+// tasks through Manager without going through HTTP:
+//
+//	cfg, _ := config.Defaults()
+//	cfg.TempDir = "/var/lib/myservice/media"
+//	srv, err := ffwebapi.New(cfg)
+//	...
+//	srv.Start(ctx)
+//	defer srv.Shutdown(context.Background())
+//	mux.Handle("/api/", srv.Handler)
+//	t, err := srv.Manager.Submit("-i ${INPUT_MEDIA} -c:v libx264 ${OUTPUT}", "https://example.com/in.mov", "mp4")
+//
+// The API serves absolute paths (/api/v1, /api/v2, /healthz, ...). To mount
+// it below a prefix, strip the prefix with http.StripPrefix and set BASE to
+// the public URL including it, so download links and upload locations
+// point back through the prefix.
+//
+// Logging and tracing stay with the embedding program: New uses slog's and
+// OpenTelemetry's global defaults as they are.
+package ffwebapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"ffwebapi/api"
+	"ffwebapi/auth"
+	"ffwebapi/config"
+	"ffwebapi/events"
+	"ffwebapi/ffmpeg"
+	"ffwebapi/task"
+	"ffwebapi/watch"
+)
+
+// Server is an FFWebAPI instance, not yet listening anywhere.
+type Server struct {
+	Manager *task.Manager // Submits, queries and cancels tasks without HTTP
+	Handler http.Handler  // The HTTP API
+
+	cfg     *config.Config
+	watcher *watch.Watcher // Nil without WATCH_FOLDERS
+}
+
+// Option adjusts how New sets up a server.
+type Option func(o *options)
+
+type options struct {
+	runner    task.FFmpegRunner
+	publisher task.EventPublisher
+}
+
+// WithRunner runs tasks with runner instead of the local ffmpeg, e.g. to
+// hand them to another execution backend or to fake them in tests.
+func WithRunner(runner task.FFmpegRunner) Option {
+	return func(o *options) {
+		o.runner = runner
+	}
+}
+
+// WithEventPublisher publishes task lifecycle events through p instead of
+// the broker EVENTS_BROKER configures, e.g. onto the program's own bus.
+func WithEventPublisher(p task.EventPublisher) Option {
+	return func(o *options) {
+		o.publisher = p
+	}
+}
+
+// New sets up a server from cfg, e.g. config.Load() or config.Defaults().
+// Tasks only start running once Start is called.
+func New(cfg *config.Config, opts ...Option) (*Server, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.runner == nil {
+		runner, err := ffmpeg.NewRunner(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("could not initialize ffmpeg runner: %w", err)
+		}
+		o.runner = runner
+	}
+
+	if o.publisher == nil {
+		publisher, err := events.New(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("could not set up event publishing: %w", err)
+		}
+		o.publisher = publisher
+	}
+
+	tm, err := task.NewManager(cfg, o.runner)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize task manager: %w", err)
+	}
+	if o.publisher != nil {
+		tm.SetEventPublisher(o.publisher)
+	}
+	watcher, err := watch.New(cfg, tm)
+	if err != nil {
+		return nil, fmt.Errorf("could not set up watch folders: %w", err)
+	}
+	keys, err := auth.NewStore(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not load API keys: %w", err)
+	}
+	return &Server{
+		Manager: tm,
+		Handler: api.SetupRouter(tm, keys, cfg),
+		cfg:     cfg,
+		watcher: watcher,
+	}, nil
+}
+
+// Start starts running tasks and scanning watch folders until ctx is done.
+// A standby (STANDBY_OF) only serves the API until its primary fails.
+func (s *Server) Start(ctx context.Context) {
+	if s.cfg.StandbyOf == "" {
+		s.Manager.Start(ctx)
+	} else {
+		go func() {
+			if s.Manager.Follow(ctx) {
+				s.Manager.Start(ctx)
+			}
+		}()
+	}
+	if s.watcher != nil {
+		go s.watcher.Run(ctx)
+	}
+}
+
+// Shutdown lets running tasks finish until ctx is done and saves the
+// unfinished ones for the next start. The Handler keeps serving meanwhile,
+// so clients can follow their tasks; new submissions get 503.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.Manager.Shutdown(ctx)
+}
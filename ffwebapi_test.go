@@ -0,0 +1,77 @@
+package ffwebapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ffwebapi"
+	"ffwebapi/config"
+	"ffwebapi/task"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// outputRunner writes a small output per task in the files directory below
+// the temp dir.
+type outputRunner struct{ cfg *config.Config }
+
+func (r outputRunner) Run(ctx context.Context, t *task.Task) (string, error) {
+	filesDir := task.FilesDir(r.cfg, t.ID)
+	if err := os.MkdirAll(filesDir, 0o700); err != nil {
+		return "", err
+	}
+	t.OutputPath = filepath.Join(filesDir, "output."+t.OutputExt)
+	t.AddArtifact(task.ArtifactOutput, task.ArtifactOutput, t.OutputPath)
+	return "ok", os.WriteFile(t.OutputPath, []byte("video"), 0o600)
+}
+
+func TestNew_Embedded(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg, err := config.Defaults()
+	require.NoError(t, err)
+	cfg.TempDir = t.TempDir()
+	cfg.DataDir = t.TempDir()
+
+	srv, err := ffwebapi.New(cfg, ffwebapi.WithRunner(outputRunner{cfg: cfg}))
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv.Start(ctx)
+	defer srv.Shutdown(context.Background())
+
+	// The embedding service mounts the API below its own prefix.
+	mux := http.NewServeMux()
+	mux.Handle("/media/", http.StripPrefix("/media", srv.Handler))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	cfg.BaseURL = ts.URL + "/media"
+
+	submitted, err := srv.Manager.Submit("-i ${INPUT_MEDIA} ${OUTPUT}", "https://example.com/in.mov", "mp4")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		got, ok := srv.Manager.Get(submitted.ID)
+		return ok && got.Status == task.StatusCompleted
+	}, 5*time.Second, 10*time.Millisecond)
+
+	resp, err := http.Get(ts.URL + "/media/api/v2/tasks/" + submitted.ID)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var got task.Task
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+	assert.True(t, strings.HasPrefix(got.DownloadURL, ts.URL+"/media/api/v2/files/"), got.DownloadURL)
+
+	file, err := http.Get(got.DownloadURL)
+	require.NoError(t, err)
+	defer file.Body.Close()
+	assert.Equal(t, http.StatusOK, file.StatusCode)
+}
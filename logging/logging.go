@@ -0,0 +1,63 @@
+// Package logging configures structured logging (log/slog) and carries
+// request- and task-scoped loggers through contexts, so every line of one
+// request or task can be found by its ID.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"ffwebapi/config"
+)
+
+// Output formats of LOG_FORMAT.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+type ctxKey struct{}
+
+// Setup installs the logger configured by LOG_FORMAT and LOG_LEVEL as the
+// slog default. Output of the standard log package goes through it as well.
+func Setup(cfg *config.Config) error {
+	logger, err := New(os.Stderr, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// New creates a logger writing text or JSON lines at the given minimum level.
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(strings.ToUpper(level))); err != nil {
+		return nil, fmt.Errorf("invalid LOG_LEVEL %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "", FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid LOG_FORMAT %q (want %q or %q)", format, FormatText, FormatJSON)
+}
+
+// WithLogger returns a context carrying logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, logger)
+}
+
+// FromContext returns the logger of a context, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,36 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, FormatJSON, "warn")
+	require.NoError(t, err)
+	logger.Info("dropped")
+	logger.Warn("kept", "task_id", "t1")
+
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "kept", line["msg"])
+	assert.Equal(t, "t1", line["task_id"])
+
+	_, err = New(&buf, "xml", "info")
+	assert.Error(t, err)
+	_, err = New(&buf, FormatText, "loud")
+	assert.Error(t, err)
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Equal(t, slog.Default(), FromContext(context.Background()))
+	logger := slog.Default().With("request_id", "r1")
+	assert.Equal(t, logger, FromContext(WithLogger(context.Background(), logger)))
+}
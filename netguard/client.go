@@ -0,0 +1,67 @@
+package netguard
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Client returns an HTTP client enforcing the policy on every request. Host
+// names are resolved before connecting and every address is checked, and the
+// connection goes to a checked address, so DNS answers cannot lead a fetch to
+// a denied or private address. Redirects are capped at MaxRedirects and each
+// target is checked like the original URL. Environment proxies are not used.
+// A zero timeout means none.
+func (p Policy) Client(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         p.dialContext(dialer),
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > p.MaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", p.MaxRedirects)
+			}
+			return p.CheckURL(req.URL.String())
+		},
+	}
+}
+
+func (p Policy) dialContext(dialer *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkHost(host); err != nil {
+			return nil, &EgressError{URL: host, Reason: err.Error()}
+		}
+		addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err == nil && len(addrs) == 0 {
+			err = fmt.Errorf("no addresses found for %s", host)
+		}
+		if err != nil {
+			return nil, err
+		}
+		nameAllowed := matchName(p.AllowedHosts, host)
+		for _, addr := range addrs {
+			if err := p.checkAddr(addr, nameAllowed); err != nil {
+				return nil, &EgressError{URL: host, Reason: err.Error()}
+			}
+		}
+		for _, addr := range addrs {
+			var conn net.Conn
+			if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(addr.Unmap().String(), port)); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
@@ -0,0 +1,54 @@
+package netguard
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/twice":
+			http.Redirect(w, r, "/once", http.StatusFound)
+		case "/once":
+			http.Redirect(w, r, "/ok", http.StatusFound)
+		case "/metadata":
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	get := func(p Policy, path string) error {
+		resp, err := p.Client(0).Get(srv.URL + path)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	var egress *EgressError
+	err := get(Policy{}, "/ok")
+	require.True(t, errors.As(err, &egress), err)
+	assert.Contains(t, egress.Reason, "loopback")
+
+	loopback := Policy{AllowedHosts: []string{"127.0.0.0/8"}, MaxRedirects: 1}
+	assert.NoError(t, get(loopback, "/once"))
+	assert.ErrorContains(t, get(loopback, "/twice"), "stopped after 1 redirects")
+	assert.ErrorContains(t, get(loopback, "/metadata"), "169.254.169.254")
+
+	// Names are resolved and every address is checked before connecting.
+	byName := Policy{AllowedHosts: []string{"localhost"}, DeniedHosts: []string{"127.0.0.0/8", "::1/128"}, AllowPrivate: true}
+	resp, err := byName.Client(0).Get("http://localhost:" + u.Port() + "/ok")
+	if err == nil {
+		resp.Body.Close()
+	}
+	require.True(t, errors.As(err, &egress), err)
+	assert.Contains(t, egress.Reason, "is denied")
+}
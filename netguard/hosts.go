@@ -0,0 +1,114 @@
+package netguard
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// privatePrefixes are blocked unless the policy allows private addresses, in
+// addition to what netip.Addr classifies as loopback, private or link-local.
+var privatePrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // Carrier-grade NAT
+}
+
+// checkHost checks the host of a URL before it is resolved. Literal addresses
+// are checked completely; names only against the name rules.
+func (p Policy) checkHost(host string) error {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return p.checkAddr(addr, false)
+	}
+	if matchName(p.DeniedHosts, host) {
+		return fmt.Errorf("host %q is denied", host)
+	}
+	if len(p.AllowedHosts) > 0 && !matchName(p.AllowedHosts, host) && !hasPrefixes(p.AllowedHosts) {
+		return fmt.Errorf("host %q is not allowed", host)
+	}
+	if !p.AllowPrivate && (host == "localhost" || strings.HasSuffix(host, ".localhost")) {
+		return fmt.Errorf("host %q is a loopback host", host)
+	}
+	return nil
+}
+
+// checkAddr checks an address a host resolved to. nameAllowed tells whether
+// the host name itself is on the allow list.
+func (p Policy) checkAddr(addr netip.Addr, nameAllowed bool) error {
+	addr = addr.Unmap()
+	if inPrefixes(p.DeniedHosts, addr) {
+		return fmt.Errorf("address %s is denied", addr)
+	}
+	allowedByCIDR := inPrefixes(p.AllowedHosts, addr)
+	if len(p.AllowedHosts) > 0 && !nameAllowed && !allowedByCIDR {
+		return fmt.Errorf("address %s is not allowed", addr)
+	}
+	// An allowed CIDR is an explicit opt-in, e.g. for internal storage.
+	if !p.AllowPrivate && !allowedByCIDR && isPrivate(addr) {
+		return fmt.Errorf("address %s is a private, loopback or link-local address", addr)
+	}
+	return nil
+}
+
+func isPrivate(addr netip.Addr) bool {
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return true
+	}
+	for _, prefix := range privatePrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHostEntry splits a host list entry into a prefix (for CIDRs and
+// addresses) or a lowercase name.
+func parseHostEntry(entry string) (netip.Prefix, string) {
+	entry = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(entry)), ".")
+	if prefix, err := netip.ParsePrefix(entry); err == nil {
+		return prefix.Masked(), ""
+	}
+	if addr, err := netip.ParseAddr(entry); err == nil {
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), ""
+	}
+	return netip.Prefix{}, entry
+}
+
+// matchName reports whether a host name matches a name entry of the list.
+// "*.example.com" matches the subdomains of example.com, not example.com.
+func matchName(list []string, host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, entry := range list {
+		_, name := parseHostEntry(entry)
+		switch {
+		case name == "":
+		case strings.HasPrefix(name, "*."):
+			if strings.HasSuffix(host, name[1:]) {
+				return true
+			}
+		case name == host:
+			return true
+		}
+	}
+	return false
+}
+
+func inPrefixes(list []string, addr netip.Addr) bool {
+	for _, entry := range list {
+		if prefix, _ := parseHostEntry(entry); prefix.IsValid() && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPrefixes(list []string) bool {
+	for _, entry := range list {
+		if prefix, _ := parseHostEntry(entry); prefix.IsValid() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+// Package netguard decides which outbound URLs the service is allowed to reach
+// on behalf of a task (input downloads and similar fetches).
+package netguard
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"ffwebapi/config"
+)
+
+// Policy restricts the URL schemes, ports and hosts that may be fetched.
+// An empty list means "no restriction" for that dimension.
+type Policy struct {
+	AllowedSchemes []string
+	AllowedPorts   []int
+	AllowedHosts   []string // Names, "*.example.com" wildcards or CIDRs
+	DeniedHosts    []string // Same forms; checked before AllowedHosts
+	AllowPrivate   bool     // Reach loopback, private and link-local addresses
+	MaxRedirects   int
+}
+
+// InputPolicy returns the egress policy for input downloads.
+func InputPolicy(cfg *config.Config) Policy {
+	return Policy{
+		AllowedSchemes: cfg.InputAllowedSchemes,
+		AllowedPorts:   cfg.InputAllowedPorts,
+		AllowedHosts:   cfg.InputAllowedHosts,
+		DeniedHosts:    cfg.InputDeniedHosts,
+		AllowPrivate:   cfg.InputAllowPrivate,
+		MaxRedirects:   cfg.InputMaxRedirects,
+	}
+}
+
+// EgressError is returned when a URL violates the egress policy.
+type EgressError struct {
+	URL    string
+	Reason string
+}
+
+func (e *EgressError) Error() string {
+	return fmt.Sprintf("egress to %s denied: %s", e.URL, e.Reason)
+}
+
+// IsURL reports whether the input refers to a remote resource rather than a local path.
+func IsURL(input string) bool {
+	return strings.Contains(input, "://")
+}
+
+// CheckURL validates a URL against the policy.
+func (p Policy) CheckURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return &EgressError{URL: raw, Reason: "not a valid absolute URL"}
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if len(p.AllowedSchemes) > 0 && !containsFold(p.AllowedSchemes, scheme) {
+		return &EgressError{URL: raw, Reason: fmt.Sprintf("scheme %q is not allowed", scheme)}
+	}
+
+	if len(p.AllowedPorts) > 0 {
+		port, err := effectivePort(u)
+		if err != nil {
+			return &EgressError{URL: raw, Reason: err.Error()}
+		}
+		allowed := false
+		for _, p := range p.AllowedPorts {
+			if p == port {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return &EgressError{URL: raw, Reason: fmt.Sprintf("port %d is not allowed", port)}
+		}
+	}
+
+	// Names are resolved and checked again when connecting, see Client.
+	if err := p.checkHost(u.Hostname()); err != nil {
+		return &EgressError{URL: raw, Reason: err.Error()}
+	}
+	return nil
+}
+
+// effectivePort returns the explicit port of a URL or the default one for its scheme.
+func effectivePort(u *url.URL) (int, error) {
+	if p := u.Port(); p != "" {
+		return strconv.Atoi(p)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http":
+		return 80, nil
+	case "https":
+		return 443, nil
+	case "ftp":
+		return 21, nil
+	}
+	return 0, fmt.Errorf("no default port known for scheme %q", u.Scheme)
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
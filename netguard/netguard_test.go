@@ -0,0 +1,61 @@
+package netguard
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyCheckURL(t *testing.T) {
+	p := Policy{AllowedSchemes: []string{"https"}, AllowedPorts: []int{443}}
+
+	assert.NoError(t, p.CheckURL("https://cdn.example.com/video.mp4"))
+	assert.NoError(t, p.CheckURL("https://cdn.example.com:443/video.mp4"))
+
+	var egressErr *EgressError
+	err := p.CheckURL("http://cdn.example.com/video.mp4")
+	assert.True(t, errors.As(err, &egressErr))
+	assert.Contains(t, err.Error(), `scheme "http" is not allowed`)
+
+	err = p.CheckURL("https://cdn.example.com:8443/video.mp4")
+	assert.Contains(t, err.Error(), "port 8443 is not allowed")
+
+	assert.Error(t, p.CheckURL("https:///no-host"))
+	assert.NoError(t, Policy{}.CheckURL("ftp://files.example.com/a.mkv"))
+}
+
+func TestPolicyCheckURL_Hosts(t *testing.T) {
+	// Private, loopback and link-local addresses are blocked by default.
+	for _, u := range []string{
+		"http://169.254.169.254/latest/meta-data/",
+		"http://127.0.0.1:8080/",
+		"http://[::1]/",
+		"http://[::ffff:10.0.0.1]/",
+		"http://192.168.1.10/",
+		"http://100.64.0.1/",
+		"http://localhost/",
+		"http://api.localhost./",
+	} {
+		assert.Error(t, Policy{}.CheckURL(u), u)
+	}
+	assert.NoError(t, Policy{AllowPrivate: true}.CheckURL("http://127.0.0.1:8080/"))
+
+	p := Policy{
+		AllowedHosts: []string{"cdn.example.com", "*.media.example.com", "10.1.0.0/16"},
+		DeniedHosts:  []string{"private.media.example.com", "10.1.2.0/24"},
+	}
+	assert.NoError(t, p.CheckURL("https://CDN.example.com/a.mp4"))
+	assert.NoError(t, p.CheckURL("https://eu.media.example.com/a.mp4"))
+	assert.NoError(t, p.CheckURL("http://10.1.0.5/a.mp4")) // Allowed CIDRs may be private
+	// Names off the list may still resolve into an allowed CIDR.
+	assert.NoError(t, p.CheckURL("https://other.example.com/a.mp4"))
+
+	assert.ErrorContains(t, p.CheckURL("https://private.media.example.com/a.mp4"), "is denied")
+	assert.ErrorContains(t, p.CheckURL("http://10.1.2.3/a.mp4"), "is denied")
+	assert.ErrorContains(t, p.CheckURL("http://203.0.113.7/a.mp4"), "not allowed")
+
+	names := Policy{AllowedHosts: []string{"*.example.com"}}
+	assert.ErrorContains(t, names.CheckURL("https://example.com/a.mp4"), "not allowed")
+	assert.ErrorContains(t, names.CheckURL("https://example.org/a.mp4"), "not allowed")
+}
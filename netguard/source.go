@@ -0,0 +1,68 @@
+package netguard
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"ffwebapi/config"
+)
+
+// SourceRefPrefix marks an inputMedia downloaded through a named INPUT_SOURCES
+// template, e.g. "source://cdn/videos/intro.mp4".
+const SourceRefPrefix = "source://"
+
+// ErrUnknownSource is returned for source references to an unconfigured source.
+var ErrUnknownSource = errors.New("unknown input source")
+
+var secretPlaceholder = regexp.MustCompile(`\{secret:([^}]*)\}`)
+
+// IsSourceRef reports whether the input refers to a named input source.
+func IsSourceRef(input string) bool {
+	return strings.HasPrefix(input, SourceRefPrefix)
+}
+
+// ResolveSource turns a source reference into the URL to download: the path
+// is escaped into {path} of the source's template and {secret:<name>} is
+// replaced by INPUT_SECRETS. The URL carries secrets, so it must not be shown
+// to clients or logged; errors never contain it.
+func ResolveSource(cfg *config.Config, ref string) (string, error) {
+	name, path, _ := strings.Cut(strings.TrimPrefix(ref, SourceRefPrefix), "/")
+	tmpl, ok := cfg.InputSources[strings.ToLower(name)]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownSource, name)
+	}
+
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if s == "" || s == "." || s == ".." || strings.Contains(s, `\`) {
+			return "", fmt.Errorf("invalid path %q for input source %q", path, name)
+		}
+		segments[i] = url.PathEscape(s)
+	}
+	resolved := strings.ReplaceAll(tmpl, "{path}", strings.Join(segments, "/"))
+
+	var missing string
+	resolved = secretPlaceholder.ReplaceAllStringFunc(resolved, func(m string) string {
+		secret := secretPlaceholder.FindStringSubmatch(m)[1]
+		value, ok := cfg.InputSecrets[strings.ToLower(secret)]
+		if !ok {
+			missing = secret
+		}
+		return url.QueryEscape(value)
+	})
+	if missing != "" {
+		return "", fmt.Errorf("input source %q uses undefined secret %q", name, missing)
+	}
+
+	if err := InputPolicy(cfg).CheckURL(resolved); err != nil {
+		var egress *EgressError
+		if errors.As(err, &egress) {
+			return "", fmt.Errorf("egress to input source %q denied: %s", name, egress.Reason)
+		}
+		return "", fmt.Errorf("input source %q is not usable", name)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,48 @@
+package netguard
+
+import (
+	"errors"
+	"testing"
+
+	"ffwebapi/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveSource(t *testing.T) {
+	cfg := &config.Config{
+		InputAllowedSchemes: []string{"https"},
+		InputSources: map[string]string{
+			"cdn":    "https://cdn.example.com/{path}?token={secret:cdn_token}",
+			"broken": "https://cdn.example.com/{path}?token={secret:nope}",
+			"plain":  "http://origin.example.com/media/{path}",
+		},
+		InputSecrets: map[string]string{"cdn_token": "s3cr3t&x"},
+	}
+
+	u, err := ResolveSource(cfg, "source://cdn/videos/my clip.mp4")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://cdn.example.com/videos/my%20clip.mp4?token=s3cr3t%26x", u)
+	u, err = ResolveSource(cfg, "source://CDN/a.mp4")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://cdn.example.com/a.mp4?token=s3cr3t%26x", u)
+
+	// Paths cannot escape the template.
+	for _, ref := range []string{"source://cdn/../admin", "source://cdn/", "source://cdn//a.mp4", `source://cdn/a\b.mp4`} {
+		_, err = ResolveSource(cfg, ref)
+		assert.Error(t, err, ref)
+	}
+	u, err = ResolveSource(cfg, "source://cdn/a.mp4?token=mine#x")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://cdn.example.com/a.mp4%3Ftoken=mine%23x?token=s3cr3t%26x", u)
+
+	_, err = ResolveSource(cfg, "source://other/a.mp4")
+	assert.True(t, errors.Is(err, ErrUnknownSource))
+	_, err = ResolveSource(cfg, "source://broken/a.mp4")
+	assert.ErrorContains(t, err, "undefined secret")
+
+	// The egress policy still applies, and errors do not reveal the URL.
+	_, err = ResolveSource(cfg, "source://plain/a.mp4")
+	assert.ErrorContains(t, err, `scheme "http" is not allowed`)
+	assert.NotContains(t, err.Error(), "origin.example.com")
+}
@@ -0,0 +1,62 @@
+// Package preset holds named ffmpeg command templates curated by the server,
+// so clients can ask for "h264-720p" instead of hand-crafting a command.
+package preset
+
+import "sort"
+
+// Preset is a command template using ${INPUT_MEDIA}; the output file is
+// appended by the runner like for any other task.
+type Preset struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Command     string `json:"command"`
+	OutputExt   string `json:"outputExt"`
+}
+
+var builtin = map[string]Preset{}
+
+func init() {
+	for _, p := range []Preset{
+		{"h264-1080p", "H.264/AAC MP4, 1080p", "-i ${INPUT_MEDIA} -vf scale=-2:1080 -c:v libx264 -preset medium -crf 22 -c:a aac -b:a 160k -movflags +faststart", "mp4"},
+		{"h264-720p", "H.264/AAC MP4, 720p", "-i ${INPUT_MEDIA} -vf scale=-2:720 -c:v libx264 -preset medium -crf 23 -c:a aac -b:a 128k -movflags +faststart", "mp4"},
+		{"h264-480p", "H.264/AAC MP4, 480p", "-i ${INPUT_MEDIA} -vf scale=-2:480 -c:v libx264 -preset medium -crf 24 -c:a aac -b:a 96k -movflags +faststart", "mp4"},
+		{"vp9-1080p", "VP9/Opus WebM, 1080p", "-i ${INPUT_MEDIA} -vf scale=-2:1080 -c:v libvpx-vp9 -deadline good -cpu-used 2 -row-mt 1 -crf 31 -b:v 0 -c:a libopus -b:a 128k", "webm"},
+		{"vp9-720p", "VP9/Opus WebM, 720p", "-i ${INPUT_MEDIA} -vf scale=-2:720 -c:v libvpx-vp9 -crf 32 -b:v 0 -c:a libopus -b:a 96k", "webm"},
+		{"vp9-720p-fast", "VP9/Opus WebM, 720p, realtime speed", "-i ${INPUT_MEDIA} -vf scale=-2:720 -c:v libvpx-vp9 -deadline realtime -cpu-used 8 -row-mt 1 -crf 34 -b:v 0 -c:a libopus -b:a 96k", "webm"},
+		{"av1-1080p", "AV1 (SVT-AV1)/Opus MP4, 1080p", "-i ${INPUT_MEDIA} -vf scale=-2:1080 -c:v libsvtav1 -preset 8 -crf 30 -c:a libopus -b:a 128k -movflags +faststart", "mp4"},
+		{"av1-720p", "AV1 (SVT-AV1)/Opus MP4, 720p", "-i ${INPUT_MEDIA} -vf scale=-2:720 -c:v libsvtav1 -preset 8 -crf 32 -c:a libopus -b:a 96k -movflags +faststart", "mp4"},
+		{"av1-720p-fast", "AV1 (SVT-AV1)/Opus MP4, 720p, fastest encoding", "-i ${INPUT_MEDIA} -vf scale=-2:720 -c:v libsvtav1 -preset 12 -crf 34 -c:a libopus -b:a 96k -movflags +faststart", "mp4"},
+		{"av1-720p-quality", "AV1 (SVT-AV1)/Opus MP4, 720p, slow high quality encoding", "-i ${INPUT_MEDIA} -vf scale=-2:720 -c:v libsvtav1 -preset 4 -crf 28 -c:a libopus -b:a 128k -movflags +faststart", "mp4"},
+		{"av1-720p-webm", "AV1 (SVT-AV1)/Opus WebM, 720p", "-i ${INPUT_MEDIA} -vf scale=-2:720 -c:v libsvtav1 -preset 8 -crf 32 -c:a libopus -b:a 96k", "webm"},
+		{"hevc-1080p", "HEVC/AAC MP4, 1080p, playable on Apple devices", "-i ${INPUT_MEDIA} -vf scale=-2:1080 -c:v libx265 -preset medium -crf 26 -tag:v hvc1 -c:a aac -b:a 160k -movflags +faststart", "mp4"},
+		{"hevc-720p", "HEVC/AAC MP4, 720p, playable on Apple devices", "-i ${INPUT_MEDIA} -vf scale=-2:720 -c:v libx265 -preset medium -crf 28 -tag:v hvc1 -c:a aac -b:a 128k -movflags +faststart", "mp4"},
+		{"hevc-720p-fast", "HEVC/AAC MP4, 720p, fast encoding", "-i ${INPUT_MEDIA} -vf scale=-2:720 -c:v libx265 -preset veryfast -crf 28 -tag:v hvc1 -c:a aac -b:a 128k -movflags +faststart", "mp4"},
+		{"mp3-192k", "MP3 audio, 192 kbit/s", "-i ${INPUT_MEDIA} -vn -c:a libmp3lame -b:a 192k", "mp3"},
+		{"aac-128k", "AAC audio, 128 kbit/s", "-i ${INPUT_MEDIA} -vn -c:a aac -b:a 128k", "m4a"},
+		{"opus-96k", "Opus audio, 96 kbit/s", "-i ${INPUT_MEDIA} -vn -c:a libopus -b:a 96k", "opus"},
+		{"opus-32k-voice", "Opus mono speech, 32 kbit/s", "-i ${INPUT_MEDIA} -vn -ac 1 -c:a libopus -b:a 32k -application voip", "opus"},
+		{"opus-128k-webm", "Opus audio in WebM, 128 kbit/s", "-i ${INPUT_MEDIA} -vn -c:a libopus -b:a 128k", "webm"},
+		{"prores4444-alpha", "ProRes 4444 MOV keeping the alpha channel, for editing", "-i ${INPUT_MEDIA} -c:v prores_ks -profile:v 4444 -pix_fmt yuva444p10le -alpha_bits 16 -vendor apl0 -c:a pcm_s24le", "mov"},
+		{"vp9-alpha-720p", "VP9/Opus WebM keeping the alpha channel, 720p, for browsers", "-i ${INPUT_MEDIA} -vf scale=-2:720 -c:v libvpx-vp9 -pix_fmt yuva420p -auto-alt-ref 0 -crf 32 -b:v 2M -c:a libopus -b:a 96k", "webm"},
+		{"apng-alpha", "Looping animated PNG keeping the alpha channel", "-i ${INPUT_MEDIA} -an -c:v apng -pix_fmt rgba -plays 0", "apng"},
+		{"thumbnail-jpg", "JPEG of the first frame, 320px wide", "-i ${INPUT_MEDIA} -frames:v 1 -vf scale=320:-2 -q:v 3", "jpg"},
+	} {
+		builtin[p.Name] = p
+	}
+}
+
+// Lookup returns the preset with the given name.
+func Lookup(name string) (Preset, bool) {
+	p, ok := builtin[name]
+	return p, ok
+}
+
+// List returns all presets sorted by name.
+func List() []Preset {
+	list := make([]Preset, 0, len(builtin))
+	for _, p := range builtin {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
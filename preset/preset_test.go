@@ -0,0 +1,50 @@
+package preset
+
+import (
+	"testing"
+
+	"ffwebapi/ffmpeg"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuiltinPresetsAreValid(t *testing.T) {
+	for _, p := range List() {
+		args, err := ffmpeg.SplitCommand(p.Command)
+		assert.NoError(t, err, p.Name)
+		assert.NoError(t, ffmpeg.SanitizeAndValidateArgs(args), p.Name)
+		assert.NoError(t, ffmpeg.ValidateOutputExt(p.OutputExt), p.Name)
+	}
+
+	p, ok := Lookup("h264-720p")
+	assert.True(t, ok)
+	assert.Equal(t, "mp4", p.OutputExt)
+	_, ok = Lookup("nope")
+	assert.False(t, ok)
+}
+
+func TestAlphaPresetsKeepAlpha(t *testing.T) {
+	for _, name := range []string{"prores4444-alpha", "vp9-alpha-720p", "apng-alpha"} {
+		p, ok := Lookup(name)
+		if assert.True(t, ok, name) {
+			args, _ := ffmpeg.SplitCommand(p.Command)
+			assert.Empty(t, ffmpeg.AlphaLoss(args, p.OutputExt), name)
+		}
+	}
+}
+
+func TestTargetsAreValid(t *testing.T) {
+	for _, target := range Targets() {
+		args, err := ffmpeg.SplitCommand(target.Command)
+		assert.NoError(t, err, target.Name)
+		assert.NoError(t, ffmpeg.SanitizeAndValidateArgs(args), target.Name)
+		assert.NoError(t, ffmpeg.ValidateOutputExt(target.OutputExt), target.Name)
+		assert.NotEmpty(t, target.Description, target.Name)
+	}
+
+	target, ok := LookupTarget("web")
+	assert.True(t, ok)
+	args, _ := ffmpeg.SplitCommand(target.Command)
+	assert.Contains(t, args, "-profile:v")
+	assert.Equal(t, "mp4", target.OutputExt)
+}
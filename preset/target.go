@@ -0,0 +1,97 @@
+package preset
+
+import (
+	"sort"
+
+	"ffwebapi/task"
+)
+
+// Target is a conformance target: the command producing an output that meets
+// a delivery spec, and the spec QC checks the output against.
+type Target struct {
+	task.ConformanceTarget
+	Description string `json:"description"`
+	Command     string `json:"command"`
+	OutputExt   string `json:"outputExt"`
+}
+
+var targets = map[string]Target{}
+
+func init() {
+	for _, t := range []Target{
+		{
+			ConformanceTarget: task.ConformanceTarget{
+				Name: "web", VideoCodec: "h264", Profiles: []string{"High", "Main"}, MaxLevel: 41,
+				PixelFormat: "yuv420p", ColorPrimaries: "bt709", MaxWidth: 1920, MaxHeight: 1080,
+				AudioCodec: "aac", SampleRate: 48000, Channels: 2, Loudness: -16, TruePeak: -1,
+			},
+			Description: "H.264 High@4.1/AAC MP4 fit into 1080p for browsers and mobile devices, -16 LUFS",
+			Command: "-i ${INPUT_MEDIA} -vf scale=1920:1080:force_original_aspect_ratio=decrease:force_divisible_by=2,format=yuv420p" +
+				" -c:v libx264 -profile:v high -level:v 4.1 -preset medium -crf 23 -color_primaries bt709 -color_trc bt709 -colorspace bt709" +
+				" -af loudnorm=I=-16:TP=-1.5:LRA=11 -c:a aac -b:a 160k -ar 48000 -ac 2 -movflags +faststart",
+			OutputExt: "mp4",
+		},
+		{
+			ConformanceTarget: task.ConformanceTarget{
+				Name: "instagram_reel", VideoCodec: "h264", Profiles: []string{"High", "Main"}, MaxLevel: 42,
+				PixelFormat: "yuv420p", ColorPrimaries: "bt709", Width: 1080, Height: 1920, FrameRate: 30,
+				AudioCodec: "aac", SampleRate: 48000, Channels: 2, Loudness: -14, TruePeak: -1, MaxDuration: 90,
+			},
+			Description: "Vertical 1080x1920 H.264/AAC MP4 at 30 fps, at most 90s, -14 LUFS",
+			Command: "-i ${INPUT_MEDIA} -t 90 -vf scale=1080:1920:force_original_aspect_ratio=decrease,pad=1080:1920:-1:-1,setsar=1,fps=30,format=yuv420p" +
+				" -c:v libx264 -profile:v high -level:v 4.2 -preset medium -crf 21 -maxrate 8M -bufsize 16M -color_primaries bt709 -color_trc bt709 -colorspace bt709" +
+				" -af loudnorm=I=-14:TP=-1.5:LRA=11 -c:a aac -b:a 128k -ar 48000 -ac 2 -movflags +faststart",
+			OutputExt: "mp4",
+		},
+		{
+			ConformanceTarget: task.ConformanceTarget{
+				Name: "youtube_1080p", VideoCodec: "h264", Profiles: []string{"High"}, MaxLevel: 42,
+				PixelFormat: "yuv420p", ColorPrimaries: "bt709", MaxWidth: 1920, MaxHeight: 1080,
+				AudioCodec: "aac", SampleRate: 48000, Channels: 2, Loudness: -14, TruePeak: -1,
+			},
+			Description: "H.264 High/AAC MP4 fit into 1080p with YouTube's recommended bitrates, -14 LUFS",
+			Command: "-i ${INPUT_MEDIA} -vf scale=1920:1080:force_original_aspect_ratio=decrease:force_divisible_by=2,format=yuv420p" +
+				" -c:v libx264 -profile:v high -level:v 4.2 -preset slow -crf 18 -maxrate 12M -bufsize 24M -g 60 -bf 2 -color_primaries bt709 -color_trc bt709 -colorspace bt709" +
+				" -af loudnorm=I=-14:TP=-1.5:LRA=11 -c:a aac -b:a 384k -ar 48000 -ac 2 -movflags +faststart",
+			OutputExt: "mp4",
+		},
+		{
+			ConformanceTarget: task.ConformanceTarget{
+				Name: "ebu_r128_broadcast", VideoCodec: "mpeg2video", PixelFormat: "yuv422p", ColorPrimaries: "bt709",
+				Width: 1920, Height: 1080, FrameRate: 25,
+				AudioCodec: "pcm_s24le", SampleRate: 48000, Channels: 2, Loudness: -23, TruePeak: -1,
+			},
+			Description: "MPEG-2 4:2:2 50 Mbit/s 1080p25 MXF with 24-bit PCM audio, EBU R128 loudness (-23 LUFS, -1 dBTP)",
+			Command: "-i ${INPUT_MEDIA} -vf scale=1920:1080:force_original_aspect_ratio=decrease,pad=1920:1080:-1:-1,setsar=1,fps=25,format=yuv422p" +
+				" -c:v mpeg2video -profile:v 0 -level:v 2 -b:v 50M -minrate 50M -maxrate 50M -bufsize 17825792 -g 12 -bf 2 -color_primaries bt709 -color_trc bt709 -colorspace bt709" +
+				" -af loudnorm=I=-23:TP=-2:LRA=15 -c:a pcm_s24le -ar 48000 -ac 2",
+			OutputExt: "mxf",
+		},
+		{
+			ConformanceTarget: task.ConformanceTarget{
+				Name: "podcast", AudioCodec: "mp3", SampleRate: 44100, Channels: 2, Loudness: -16, TruePeak: -1,
+			},
+			Description: "MP3 audio at 44.1 kHz, -16 LUFS as recommended by podcast platforms",
+			Command:     "-i ${INPUT_MEDIA} -vn -af loudnorm=I=-16:TP=-1.5:LRA=11 -c:a libmp3lame -b:a 128k -ar 44100 -ac 2",
+			OutputExt:   "mp3",
+		},
+	} {
+		targets[t.Name] = t
+	}
+}
+
+// LookupTarget returns the conformance target with the given name.
+func LookupTarget(name string) (Target, bool) {
+	t, ok := targets[name]
+	return t, ok
+}
+
+// Targets returns all conformance targets sorted by name.
+func Targets() []Target {
+	list := make([]Target, 0, len(targets))
+	for _, t := range targets {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"ffwebapi/config"
+)
+
+// S3Outputs is the "s3" output store: task files are put into
+// OUTPUT_S3_BUCKET and downloaded through presigned URLs. GCS works through
+// its S3-compatible endpoint (S3_ENDPOINT=https://storage.googleapis.com
+// with HMAC keys).
+type S3Outputs struct {
+	S3 S3
+}
+
+// NewS3Outputs returns the output store configured by OUTPUT_S3_BUCKET and
+// the S3_* settings.
+func NewS3Outputs(cfg *config.Config) (*S3Outputs, error) {
+	if cfg.OutputS3Bucket == "" || cfg.S3AccessKeyID == "" || cfg.S3SecretAccessKey == "" {
+		return nil, fmt.Errorf("the s3 output store needs OUTPUT_S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY")
+	}
+	return &S3Outputs{S3: S3{
+		Endpoint:        cfg.S3Endpoint,
+		Region:          cfg.S3Region,
+		Bucket:          cfg.OutputS3Bucket,
+		Prefix:          cfg.OutputS3Prefix,
+		AccessKeyID:     cfg.S3AccessKeyID,
+		SecretAccessKey: cfg.S3SecretAccessKey,
+	}}, nil
+}
+
+// Put uploads a file, streaming it rather than buffering it like S3.Put.
+func (s *S3Outputs) Put(ctx context.Context, key, filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	resp, err := s.S3.do(ctx, http.MethodPut, key, f, info.Size())
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// URL presigns a download URL, valid for at most the seven days S3 allows.
+func (s *S3Outputs) URL(key string, expires time.Time) (string, error) {
+	expiry := time.Until(expires)
+	if expiry > maxPresignExpiry {
+		expiry = maxPresignExpiry
+	}
+	return s.S3.presign(http.MethodGet, key, time.Now(), expiry)
+}
+
+// WebDAV is the "webdav" output store: task files are PUT below Endpoint,
+// one collection per task, and downloaded from PublicURL.
+type WebDAV struct {
+	Endpoint  string // Collection the files are put into, e.g. https://dav.example.com/media
+	Username  string
+	Password  string
+	PublicURL string       // Where clients download the files; Endpoint if empty
+	Client    *http.Client // Defaults to http.DefaultClient
+}
+
+// NewWebDAV returns the output store configured by the OUTPUT_WEBDAV_*
+// settings.
+func NewWebDAV(cfg *config.Config) (*WebDAV, error) {
+	if _, err := url.Parse(cfg.OutputWebDAVURL); err != nil || cfg.OutputWebDAVURL == "" {
+		return nil, fmt.Errorf("the webdav output store needs a valid OUTPUT_WEBDAV_URL")
+	}
+	return &WebDAV{
+		Endpoint:  cfg.OutputWebDAVURL,
+		Username:  cfg.OutputWebDAVUsername,
+		Password:  cfg.OutputWebDAVPassword,
+		PublicURL: cfg.OutputWebDAVPublicURL,
+	}, nil
+}
+
+// Put creates the collections of key, then uploads the file.
+func (w *WebDAV) Put(ctx context.Context, key, filePath string) error {
+	dirs := strings.Split(path.Dir(key), "/")
+	for i := range dirs {
+		if dirs[i] == "." {
+			break
+		}
+		// 405 means the collection exists already.
+		if err := w.do(ctx, "MKCOL", strings.Join(dirs[:i+1], "/"), nil, 0, http.StatusMethodNotAllowed); err != nil {
+			return err
+		}
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return w.do(ctx, http.MethodPut, key, f, info.Size())
+}
+
+// URL returns the public URL of key; it does not expire.
+func (w *WebDAV) URL(key string, expires time.Time) (string, error) {
+	base := w.PublicURL
+	if base == "" {
+		base = w.Endpoint
+	}
+	return joinURL(base, key)
+}
+
+// do sends a request for key and turns answers other than 2xx and the
+// accepted status codes into errors.
+func (w *WebDAV) do(ctx context.Context, method, key string, body *os.File, size int64, accepted ...int) error {
+	u, err := joinURL(w.Endpoint, key)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Body, req.ContentLength = body, size
+	}
+	if w.Username != "" {
+		req.SetBasicAuth(w.Username, w.Password)
+	}
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("WebDAV %s %s: %w", method, key, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	for _, code := range accepted {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	return fmt.Errorf("WebDAV %s %s: %s", method, key, resp.Status)
+}
+
+// joinURL appends a slash-separated key to a base URL, escaping its segments.
+func joinURL(base, key string) (string, error) {
+	u, err := url.Parse(strings.TrimSuffix(base, "/"))
+	if err != nil {
+		return "", err
+	}
+	return u.JoinPath(strings.Split(key, "/")...).String(), nil
+}
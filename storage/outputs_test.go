@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"ffwebapi/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeOutput(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "output.mp4")
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+	return path
+}
+
+func TestS3Outputs(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		put = make(map[string]string)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Query().Get("X-Amz-Signature") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		put[r.URL.Path] = string(data)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	_, err := NewS3Outputs(&config.Config{OutputS3Bucket: "media"})
+	assert.Error(t, err)
+	s, err := NewS3Outputs(&config.Config{S3Endpoint: srv.URL, S3Region: "us-east-1", OutputS3Bucket: "media", OutputS3Prefix: "done/",
+		S3AccessKeyID: "key", S3SecretAccessKey: "secret"})
+	require.NoError(t, err)
+
+	require.NoError(t, s.Put(context.Background(), "task1/output.mp4", writeOutput(t, "video")))
+	assert.Equal(t, map[string]string{"/media/done/task1/output.mp4": "video"}, put)
+	assert.Error(t, s.Put(context.Background(), "task1/missing.mp4", filepath.Join(t.TempDir(), "missing.mp4")))
+
+	u, err := s.URL("task1/output.mp4", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	parsed, err := url.Parse(u)
+	require.NoError(t, err)
+	assert.Equal(t, "/media/done/task1/output.mp4", parsed.Path)
+	assert.NotEmpty(t, parsed.Query().Get("X-Amz-Signature"))
+	assert.NotEmpty(t, parsed.Query().Get("X-Amz-Expires"))
+
+	// Expiries beyond what S3 allows are capped.
+	u, err = s.URL("task1/output.mp4", time.Now().Add(30*24*time.Hour))
+	require.NoError(t, err)
+	parsed, _ = url.Parse(u)
+	assert.Equal(t, "604800", parsed.Query().Get("X-Amz-Expires"))
+}
+
+func TestWebDAV(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []string
+		data     string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "dav" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.Method == "MKCOL" && r.URL.Path == "/dav/task1":
+			w.WriteHeader(http.StatusMethodNotAllowed) // Exists already
+		case r.Method == "MKCOL":
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPut:
+			b, _ := io.ReadAll(r.Body)
+			data = string(b)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+	_, err := NewWebDAV(&config.Config{})
+	assert.Error(t, err)
+	w, err := NewWebDAV(&config.Config{OutputWebDAVURL: srv.URL + "/dav/", OutputWebDAVUsername: "dav", OutputWebDAVPassword: "secret"})
+	require.NoError(t, err)
+
+	require.NoError(t, w.Put(context.Background(), "task1/hls/segment 1.ts", writeOutput(t, "segment")))
+	assert.Equal(t, []string{"MKCOL /dav/task1", "MKCOL /dav/task1/hls", "PUT /dav/task1/hls/segment 1.ts"}, requests)
+	assert.Equal(t, "segment", data)
+
+	u, err := w.URL("task1/hls/segment 1.ts", time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, srv.URL+"/dav/task1/hls/segment%201.ts", u)
+	w.PublicURL = "https://cdn.example.com/media"
+	u, err = w.URL("task1/output.mp4", time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, "https://cdn.example.com/media/task1/output.mp4", u)
+
+	w.Password = "wrong"
+	assert.ErrorContains(t, w.Put(context.Background(), "task1/output.mp4", writeOutput(t, "video")), "401 Unauthorized")
+}
@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ffwebapi/config"
+)
+
+// maxPresignExpiry is the longest validity S3 accepts for a presigned URL.
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// S3 stores objects in an S3-compatible bucket. Requests are authenticated with
+// presigned (SigV4 query string) URLs, so clients and the server use the same
+// mechanism and no SDK is needed.
+type S3 struct {
+	Endpoint        string // e.g. https://minio.local:9000 (path-style); empty for AWS (virtual-hosted)
+	Region          string
+	Bucket          string
+	Prefix          string // Prepended to every key, e.g. "uploads/"
+	AccessKeyID     string
+	SecretAccessKey string
+	Client          *http.Client // Defaults to http.DefaultClient
+}
+
+func (s *S3) UploadURL(key string, expires time.Time) (string, error) {
+	return s.presign(http.MethodPut, key, time.Now(), time.Until(expires))
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	// S3 needs the length up front, so buffer the body.
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return int64(len(body)), nil
+}
+
+func (s *S3) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (int64, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, 0)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a presigned request and turns non-2xx answers into errors; 404s
+// wrap os.ErrNotExist.
+func (s *S3) do(ctx context.Context, method, key string, body io.Reader, size int64) (*http.Response, error) {
+	u, err := s.presign(method, key, time.Now(), 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 %s %s: %w", method, key, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("S3 object %s: %w", key, os.ErrNotExist)
+		}
+		return nil, fmt.Errorf("S3 %s %s: %s", method, key, resp.Status)
+	}
+	return resp, nil
+}
+
+// objectURL is the URL of a key: path-style below Endpoint, or virtual-hosted
+// on AWS.
+func (s *S3) objectURL(key string) (*url.URL, error) {
+	objectPath := "/" + s.Prefix + key
+	if s.Endpoint == "" {
+		return &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", s.Bucket, s.Region), Path: objectPath}, nil
+	}
+	u, err := url.Parse(strings.TrimSuffix(s.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	u.Path += "/" + s.Bucket + objectPath
+	return u, nil
+}
+
+func (s *S3) presign(method, key string, now time.Time, expiry time.Duration) (string, error) {
+	if expiry <= 0 || expiry > maxPresignExpiry {
+		return "", fmt.Errorf("presigned URLs must expire within %s", maxPresignExpiry)
+	}
+	u, err := s.objectURL(key)
+	if err != nil {
+		return "", err
+	}
+	return presignV4(method, u, s.Region, s.AccessKeyID, s.SecretAccessKey, now, expiry), nil
+}
+
+// presignV4 adds an AWS Signature Version 4 to the query string of u.
+func presignV4(method string, u *url.URL, region, accessKeyID, secretAccessKey string, now time.Time, expiry time.Duration) string {
+	now = now.UTC()
+	date := now.Format("20060102")
+	amzDate := now.Format("20060102T150405Z")
+	scope := date + "/" + region + "/s3/aws4_request"
+
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", accessKeyID+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expiry.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	canonicalQuery := canonicalQueryString(query)
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		uriEncode(u.Path, false),
+		canonicalQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	for _, part := range []string{region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	signed := *u
+	signed.RawPath = uriEncode(u.Path, false)
+	signed.RawQuery = canonicalQuery + "&X-Amz-Signature=" + signature
+	return signed.String()
+}
+
+func canonicalQueryString(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters (and '/'
+// unless encodeSlash), as SigV4 requires.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// S3Inputs reads task inputs given as s3://<bucket>/<key>, from the buckets
+// of INPUT_S3_BUCKETS only. It is an input provider of the runner (see
+// ffmpeg.RegisterInputProvider).
+type S3Inputs struct {
+	S3      S3 // Endpoint, region and credentials; Bucket and Prefix are taken from each input
+	Buckets []string
+}
+
+// NewS3Inputs returns the provider of s3:// inputs configured by
+// INPUT_S3_BUCKETS and the S3_* settings.
+func NewS3Inputs(cfg *config.Config) (*S3Inputs, error) {
+	if cfg.S3AccessKeyID == "" || cfg.S3SecretAccessKey == "" {
+		return nil, fmt.Errorf("s3:// inputs need S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY")
+	}
+	return &S3Inputs{
+		S3: S3{
+			Endpoint:        cfg.S3Endpoint,
+			Region:          cfg.S3Region,
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+		},
+		Buckets: cfg.InputS3Buckets,
+	}, nil
+}
+
+// CheckInput rejects inputs outside the allowed buckets at submission.
+func (p *S3Inputs) CheckInput(inputMedia string) error {
+	_, _, err := p.parse(inputMedia)
+	return err
+}
+
+// Fetch downloads an object to dst, failing once more than maxSize bytes arrive.
+func (p *S3Inputs) Fetch(ctx context.Context, inputMedia string, dst io.Writer, maxSize int64) error {
+	bucket, key, err := p.parse(inputMedia)
+	if err != nil {
+		return err
+	}
+	s3 := p.S3
+	s3.Bucket, s3.Prefix = bucket, ""
+	src, err := s3.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	written, err := io.Copy(dst, io.LimitReader(src, maxSize+1))
+	if err != nil {
+		return fmt.Errorf("S3 GET %s: %w", key, err)
+	}
+	if written > maxSize {
+		return fmt.Errorf("input file size exceeds limit of %d bytes", maxSize)
+	}
+	return nil
+}
+
+func (p *S3Inputs) parse(inputMedia string) (bucket, key string, err error) {
+	u, err := url.Parse(inputMedia)
+	if err != nil || !strings.EqualFold(u.Scheme, "s3") || u.Host == "" || strings.TrimPrefix(u.Path, "/") == "" {
+		return "", "", fmt.Errorf("invalid S3 input %q: want s3://<bucket>/<key>", inputMedia)
+	}
+	for _, allowed := range p.Buckets {
+		if allowed == u.Host {
+			return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+		}
+	}
+	return "", "", fmt.Errorf("S3 bucket %q is not in INPUT_S3_BUCKETS", u.Host)
+}
@@ -0,0 +1,117 @@
+// Package storage keeps client-uploaded inputs, either on local disk (uploads
+// go through the API) or in an S3-compatible bucket (clients upload directly
+// via presigned URLs).
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"ffwebapi/config"
+)
+
+// Backend kinds of INPUT_STORAGE.
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// Backend stores objects by key.
+type Backend interface {
+	// UploadURL returns a presigned URL clients PUT the object to, or "" if
+	// uploads must go through the API (see Put).
+	UploadURL(key string, expires time.Time) (string, error)
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Stat returns the size of an object, or an error wrapping os.ErrNotExist.
+	Stat(ctx context.Context, key string) (int64, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// New returns the backend configured by INPUT_STORAGE.
+func New(cfg *config.Config) (Backend, error) {
+	switch cfg.InputStorage {
+	case "", BackendLocal:
+		return &Local{Dir: filepath.Join(cfg.TempDir, "inputs")}, nil
+	case BackendS3:
+		s3, err := NewS3(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return s3, nil
+	}
+	return nil, fmt.Errorf("unknown INPUT_STORAGE %q (want %q or %q)", cfg.InputStorage, BackendLocal, BackendS3)
+}
+
+// NewS3 returns the bucket configured by the S3_* settings.
+func NewS3(cfg *config.Config) (*S3, error) {
+	if cfg.S3Bucket == "" || cfg.S3AccessKeyID == "" || cfg.S3SecretAccessKey == "" {
+		return nil, fmt.Errorf("S3 storage needs S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY")
+	}
+	return &S3{
+		Endpoint:        cfg.S3Endpoint,
+		Region:          cfg.S3Region,
+		Bucket:          cfg.S3Bucket,
+		Prefix:          cfg.S3Prefix,
+		AccessKeyID:     cfg.S3AccessKeyID,
+		SecretAccessKey: cfg.S3SecretAccessKey,
+	}, nil
+}
+
+// Local stores objects as files in Dir.
+type Local struct {
+	Dir string
+}
+
+func (l *Local) UploadURL(key string, expires time.Time) (string, error) {
+	return "", nil
+}
+
+// Path returns the file an object is stored in.
+func (l *Local) Path(key string) string {
+	return filepath.Join(l.Dir, filepath.Base(key))
+}
+
+// Put writes the object to a temporary file first, so a partial upload never
+// shows up as the object.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	if err := os.MkdirAll(l.Dir, 0o700); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(l.Dir, ".upload_*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, err
+	}
+	return n, os.Rename(tmp.Name(), l.Path(key))
+}
+
+func (l *Local) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(l.Path(key))
+}
+
+func (l *Local) Stat(ctx context.Context, key string) (int64, error) {
+	info, err := os.Stat(l.Path(key))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(l.Path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package task
+
+import (
+    "context"
+    "fmt"
+    "log/slog"
+    "sync"
+    "time"
+
+    "ffwebapi/config"
+)
+
+// With ADAPTIVE_CONCURRENCY, the host's load is sampled every adaptiveInterval,
+// and memory, disk and CPU trends are projected adaptiveHorizon samples ahead.
+const (
+    adaptiveInterval = 5 * time.Second
+    adaptiveHorizon  = 3
+)
+
+// LoadSampler is optionally implemented by runners that can measure the
+// host's load, for ADAPTIVE_CONCURRENCY.
+type LoadSampler interface {
+    SampleLoad() LoadSample
+}
+
+// LoadSample is a measurement of the host. Figures that could not be read
+// are negative and not acted on.
+type LoadSample struct {
+    CPUPercent      float64 // Busy CPU, over all cores
+    MemoryAvailable int64   // Bytes
+    DiskFree        int64   // Bytes free in TEMP_DIR
+}
+
+// ConcurrencyStatus is the state of ADAPTIVE_CONCURRENCY as shown to operators.
+type ConcurrencyStatus struct {
+    Limit     int       `json:"limit"`   // Tasks that may run at once over all queues
+    Max       int       `json:"max"`     // Slots of all queues together
+    Running   int       `json:"running"` // Tasks dispatched from the queues
+    Reason    string    `json:"reason,omitempty"`
+    ChangedAt time.Time `json:"changedAt,omitempty"`
+}
+
+// concurrencyLimiter caps the tasks running over all queues at a limit that
+// follows the host's load, between 0 and the queues' slots. A nil limiter
+// never holds tasks back.
+type concurrencyLimiter struct {
+    mu        sync.Mutex
+    limit     int
+    max       int
+    running   int
+    reason    string
+    changedAt time.Time
+    prev      *LoadSample // For trends
+}
+
+// newConcurrencyLimiter returns nil unless ADAPTIVE_CONCURRENCY is set. It
+// starts with one task per queue and works its way up.
+func newConcurrencyLimiter(cfg *config.Config, queues map[string]*namedQueue) *concurrencyLimiter {
+    if !cfg.AdaptiveConcurrency {
+        return nil
+    }
+    l := &concurrencyLimiter{limit: len(queues), reason: "initial"}
+    for _, q := range queues {
+        l.max += cap(q.slots)
+    }
+    return l
+}
+
+// tryAcquire counts a task as running unless the limit is reached.
+func (l *concurrencyLimiter) tryAcquire() bool {
+    if l == nil {
+        return true
+    }
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if l.running >= l.limit {
+        return false
+    }
+    l.running++
+    return true
+}
+
+func (l *concurrencyLimiter) release() {
+    if l == nil {
+        return
+    }
+    l.mu.Lock()
+    l.running--
+    l.mu.Unlock()
+}
+
+// adjust moves the limit by a sample. Pressure brings it below the tasks
+// running, by one, so the next finishing task is not replaced; a disk short of
+// THROTTLE_FREEDISK holds all dispatching. Headroom that the trends are not
+// about to use up raises it by one, as long as tasks wait for it. Reports
+// whether the limit went up.
+func (l *concurrencyLimiter) adjust(cfg *config.Config, s LoadSample, waiting bool, now time.Time) bool {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    prev := l.prev
+    l.prev = &s
+
+    cpuFree := func(cpu float64) bool { return 100-cpu >= cfg.ThrottleCPU }
+    limit, reason := l.limit, ""
+    switch {
+    case s.DiskFree >= 0 && s.DiskFree < cfg.ThrottleFreeDisk:
+        limit, reason = 0, fmt.Sprintf("free disk space %d below THROTTLE_FREEDISK", s.DiskFree)
+    case s.MemoryAvailable >= 0 && s.MemoryAvailable < cfg.ThrottleFreeMem:
+        limit, reason = max(min(l.limit, l.running)-1, 1), fmt.Sprintf("available memory %d below THROTTLE_FREEMEM", s.MemoryAvailable)
+    case s.CPUPercent >= 0 && !cpuFree(s.CPUPercent):
+        limit, reason = max(min(l.limit, l.running)-1, 1), fmt.Sprintf("CPU usage %.1f%% leaves less than THROTTLE_CPU idle", s.CPUPercent)
+    case !waiting || l.running < l.limit || l.limit >= l.max:
+        // Nothing to gain from a higher limit
+    case prev != nil && s.MemoryAvailable >= 0 && prev.MemoryAvailable >= 0 &&
+        s.MemoryAvailable+(s.MemoryAvailable-prev.MemoryAvailable)*adaptiveHorizon < cfg.ThrottleFreeMem:
+        // Memory is running out
+    case prev != nil && s.DiskFree >= 0 && prev.DiskFree >= 0 &&
+        s.DiskFree+(s.DiskFree-prev.DiskFree)*adaptiveHorizon < cfg.ThrottleFreeDisk:
+        // Disk space is running out
+    case prev != nil && s.CPUPercent >= 0 && prev.CPUPercent >= 0 &&
+        !cpuFree(s.CPUPercent+(s.CPUPercent-prev.CPUPercent)*adaptiveHorizon):
+        // CPU usage is still climbing, e.g. from the last task started
+    default:
+        limit, reason = l.limit+1, "resources to spare"
+    }
+    if limit == l.limit {
+        return false
+    }
+    raised := limit > l.limit
+    slog.Info("Adjusted task concurrency", "limit", limit, "previous", l.limit, "running", l.running, "reason", reason)
+    l.limit, l.reason, l.changedAt = limit, reason, now
+    return raised
+}
+
+func (l *concurrencyLimiter) status() ConcurrencyStatus {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    return ConcurrencyStatus{Limit: l.limit, Max: l.max, Running: l.running, Reason: l.reason, ChangedAt: l.changedAt}
+}
+
+// adaptiveLoop adjusts the concurrency limit to the host's load.
+func (m *Manager) adaptiveLoop(ctx context.Context, sampler LoadSampler) {
+    ticker := time.NewTicker(adaptiveInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            waiting := false
+            for _, q := range m.queues {
+                if q.tasks.len() > 0 && !q.tasks.isPaused() {
+                    waiting = true
+                }
+            }
+            if m.limiter.adjust(m.cfg, sampler.SampleLoad(), waiting, time.Now()) {
+                for _, q := range m.queues {
+                    q.tasks.wake()
+                }
+            }
+        }
+    }
+}
+
+// ConcurrencyStatus reports the limit of ADAPTIVE_CONCURRENCY, or nil if it
+// is off.
+func (m *Manager) ConcurrencyStatus() *ConcurrencyStatus {
+    if m.limiter == nil {
+        return nil
+    }
+    s := m.limiter.status()
+    return &s
+}
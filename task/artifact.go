@@ -0,0 +1,310 @@
+package task
+
+import (
+    "encoding/base64"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "ffwebapi/config"
+    "ffwebapi/utils"
+)
+
+// Artifact kinds. A task has at most one primary output (or one per ${OUTPUT_n})
+// plus any number of derived files.
+const (
+    ArtifactOutput    = "output"
+    ArtifactLog       = "log"
+    ArtifactReport    = "report"
+    ArtifactThumbnail = "thumbnail"
+    ArtifactSubtitles = "subtitles"
+    ArtifactChecksum  = "checksum"
+)
+
+// FilesRoot is where published files live: one directory per task, so file
+// names only need to be unique within their task, e.g. "<taskId>/output.mp4".
+func FilesRoot(cfg *config.Config) string {
+    return filepath.Join(cfg.TempDir, "files")
+}
+
+// FilesDir is the directory of a task's published files.
+func FilesDir(cfg *config.Config, taskID string) string {
+    return filepath.Join(FilesRoot(cfg), taskID)
+}
+
+// Artifact is one downloadable file produced by a task.
+type Artifact struct {
+    Name        string    `json:"name"` // Unique within the task, e.g. "output", "output_1", "log"
+    Kind        string    `json:"kind"`
+    ContentType string    `json:"contentType,omitempty"`
+    Size        int64     `json:"size"` // Including the rest of the directory for directory outputs
+    DownloadURL string    `json:"downloadUrl,omitempty"`
+    ExpiresAt   time.Time `json:"expiresAt"`
+    Path        string    `json:"-"`
+    Dir         string    `json:"-"` // Output directory the file belongs to, served as a whole
+    taskID      string
+}
+
+// AddArtifact records a file produced for the task, replacing an artifact of
+// the same name from an earlier attempt. Runners call it in Update once the
+// file is in place; size, content type and expiry are filled in when the task
+// finishes.
+func (t *Task) AddArtifact(name, kind, path string) *Artifact {
+    a := &Artifact{Name: name, Kind: kind, Path: path}
+    for i, old := range t.Artifacts {
+        if old.Name == name {
+            t.Artifacts[i] = a
+            return a
+        }
+    }
+    t.Artifacts = append(t.Artifacts, a)
+    return a
+}
+
+// Artifact returns the task's artifact with the given name.
+func (t *Task) Artifact(name string) (*Artifact, bool) {
+    for _, a := range t.Artifacts {
+        if a.Name == name {
+            return a, true
+        }
+    }
+    return nil, false
+}
+
+// BundleFile is a file of a task's outputs as placed in a download bundle.
+type BundleFile struct {
+    Name string // Slash-separated path within the bundle
+    Path string
+}
+
+// BundleFiles lists the files of a task's artifacts other than logs, for
+// downloading them as one archive. Directory outputs such as HLS contribute
+// every file below their directory, under its relative path.
+func (t *Task) BundleFiles() []BundleFile {
+    var files []BundleFile
+    seen := make(map[string]bool) // By path; several artifacts share a directory
+    for _, a := range t.Artifacts {
+        if a.Kind == ArtifactLog {
+            continue
+        }
+        if a.Dir == "" {
+            if !seen[a.Path] {
+                seen[a.Path] = true
+                files = append(files, BundleFile{Name: filepath.Base(a.Path), Path: a.Path})
+            }
+            continue
+        }
+        filepath.WalkDir(a.Dir, func(p string, d fs.DirEntry, err error) error {
+            if err != nil || d.IsDir() || seen[p] {
+                return nil
+            }
+            seen[p] = true
+            rel, _ := filepath.Rel(a.Dir, p)
+            files = append(files, BundleFile{Name: filepath.ToSlash(rel), Path: p})
+            return nil
+        })
+    }
+    return files
+}
+
+// retentionFor returns how long the task's artifacts of a kind are kept.
+func (m *Manager) retentionFor(t *Task, kind string) time.Duration {
+    if t.OutputTTL > 0 {
+        return t.OutputTTL
+    }
+    if d, ok := m.cfg.ArtifactRetention[kind]; ok {
+        return d
+    }
+    return m.cfg.OutputLocalLifetime
+}
+
+// finalizeArtifacts runs once a task is terminal: it fills in sizes, content
+// types and expiry of its artifacts, including the log of its last attempt,
+// and makes the files downloadable.
+func (m *Manager) finalizeArtifacts(t *Task) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    for _, a := range t.Artifacts {
+        a.taskID = t.ID
+        a.ExpiresAt = t.CompletedAt.Add(m.retentionFor(t, a.Kind))
+        if a.Path == t.OutputPath {
+            t.ExpiresAt = a.ExpiresAt
+        }
+        a.ContentType = utils.ContentTypeOf(a.Path)
+        if a.Dir != "" {
+            a.Size = dirSize(a.Dir)
+        } else if info, err := os.Stat(a.Path); err == nil {
+            a.Size = info.Size()
+        }
+        m.files.Store(m.relPath(a), a)
+    }
+    m.inlineResult(t)
+}
+
+// inlineResult embeds the primary output of a completed task as a data URI if
+// the caller asked for it and the file is at most INLINE_RESULT_MAX_SIZE.
+// Larger outputs are left to the download URL.
+func (m *Manager) inlineResult(t *Task) {
+    if !t.InlineResult || !t.Status.Succeeded() || t.OutputPath == "" {
+        return
+    }
+    info, err := os.Stat(t.OutputPath)
+    if err != nil || info.IsDir() {
+        return
+    }
+    if info.Size() > m.cfg.InlineResultMaxSize {
+        t.logger().Info("Output too large to inline", "size", info.Size(), "limit", m.cfg.InlineResultMaxSize)
+        return
+    }
+    data, err := os.ReadFile(t.OutputPath)
+    if err != nil {
+        t.logger().Error("Could not inline output", "error", err)
+        return
+    }
+    t.ResultData = "data:" + utils.ContentTypeOf(t.OutputPath) + ";base64," + base64.StdEncoding.EncodeToString(data) // The caller holds t.mu
+}
+
+// writeFile writes a file into a task's files directory, creating it if needed.
+func writeFile(path string, data []byte) error {
+    if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+        return err
+    }
+    return os.WriteFile(path, data, 0o600)
+}
+
+// relPath is the path of an artifact below FilesRoot, as used by the files
+// endpoint. Directory outputs are registered by their directory.
+func (m *Manager) relPath(a *Artifact) string {
+    p := a.Path
+    if a.Dir != "" {
+        p = a.Dir
+    }
+    return m.URLPath(p)
+}
+
+// URLPath returns the path of a published file relative to the files endpoint.
+func (m *Manager) URLPath(p string) string {
+    if rel, err := filepath.Rel(FilesRoot(m.cfg), p); err == nil && !strings.HasPrefix(rel, "..") {
+        return filepath.ToSlash(rel)
+    }
+    return filepath.Base(p)
+}
+
+// removeFilesDir removes the files directory of a task once all its artifacts
+// are gone.
+func (m *Manager) removeFilesDir(t *Task) {
+    if len(t.Artifacts) > 0 || m.cfg.TempDir == "" {
+        return
+    }
+    if err := os.Remove(FilesDir(m.cfg, t.ID)); err != nil && !os.IsNotExist(err) {
+        t.logger().Warn("Could not remove files directory", "error", err)
+    }
+}
+
+// ArtifactPath returns the URL path of an artifact relative to the files endpoint.
+func (m *Manager) ArtifactPath(a *Artifact) string {
+    if a.Dir == "" {
+        return m.relPath(a)
+    }
+    return m.relPath(a) + "/" + filepath.ToSlash(filepath.Base(a.Path))
+}
+
+// ArtifactScope returns what a signed download URL for the artifact covers,
+// relative to the files endpoint: the file, or its whole output directory.
+func (m *Manager) ArtifactScope(a *Artifact) string {
+    return m.relPath(a)
+}
+
+// FileScope returns the ArtifactScope of the artifact a file belongs to.
+func (m *Manager) FileScope(filename string) (string, bool) {
+    a, err := m.lookupFile(filename)
+    if err != nil {
+        return "", false
+    }
+    return m.relPath(a), true
+}
+
+// expireArtifacts deletes the artifacts of a task whose retention is over.
+func (m *Manager) expireArtifacts(t *Task, now time.Time) {
+    var expired []*Artifact
+    outputExpired := false
+    t.Update(func() {
+        var kept []*Artifact
+        for _, a := range t.Artifacts {
+            if a.ExpiresAt.IsZero() || now.Before(a.ExpiresAt) {
+                kept = append(kept, a)
+                continue
+            }
+            expired = append(expired, a)
+            if a.Path == t.OutputPath {
+                t.ResultData = "" // Keep inline copies no longer than the file
+                outputExpired = true
+            }
+        }
+        t.Artifacts = kept
+    })
+    if outputExpired {
+        m.dedupe.drop(t)
+    }
+    for _, a := range expired {
+        t.logger().Info("Cleaning up expired artifact", "kind", a.Kind, "path", a.Path)
+        m.files.Delete(m.relPath(a))
+        if err := removeArtifact(a); err != nil {
+            t.logger().Error("Could not remove artifact", "path", a.Path, "error", err)
+        }
+    }
+    m.removeFilesDir(t)
+}
+
+func removeArtifact(a *Artifact) error {
+    if a.Dir != "" {
+        // Several artifacts may live in one directory; the first to expire
+        // takes the directory with it.
+        return os.RemoveAll(a.Dir)
+    }
+    if err := os.Remove(a.Path); err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    return nil
+}
+
+// lookupFile resolves a cleaned path below the temp dir to a registered artifact.
+// Files nested in a directory output resolve through the directory's artifact.
+func (m *Manager) lookupFile(rel string) (*Artifact, error) {
+    if val, ok := m.files.Load(rel); ok {
+        if a := val.(*Artifact); a.Dir == "" {
+            return a, nil
+        }
+    }
+    for dir := filepath.ToSlash(filepath.Dir(rel)); dir != "."; dir = filepath.ToSlash(filepath.Dir(dir)) {
+        if val, ok := m.files.Load(dir); ok && val.(*Artifact).Dir != "" {
+            return val.(*Artifact), nil
+        }
+    }
+    return nil, fmt.Errorf("file not found")
+}
+
+// FileTask returns the task that produced a file served by the files endpoint.
+func (m *Manager) FileTask(filename string) (*Task, bool) {
+    a, err := m.lookupFile(filename)
+    if err != nil {
+        return nil, false
+    }
+    return m.Get(a.taskID)
+}
+
+func dirSize(dir string) int64 {
+    var size int64
+    filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+        if err == nil && !d.IsDir() {
+            if info, err := d.Info(); err == nil {
+                size += info.Size()
+            }
+        }
+        return nil
+    })
+    return size
+}
@@ -0,0 +1,185 @@
+package task
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+    "time"
+
+    "ffwebapi/config"
+)
+
+// LineItem is what one finished task of an owner consumed, for invoicing.
+// Only tasks submitted with billing enabled get one.
+type LineItem struct {
+    TaskID        string    `json:"taskId"`
+    Owner         string    `json:"owner"`
+    Status        Status    `json:"status"`
+    Preset        string    `json:"preset,omitempty"` // Preset the task was built from, if any
+    Queue         string    `json:"queue"`
+    CreatedAt     time.Time `json:"createdAt"`
+    CompletedAt   time.Time `json:"completedAt"`   // Line items are dated by it
+    EncodeSeconds float64   `json:"encodeSeconds"` // Wall time of the final attempt
+    CPUSeconds    float64   `json:"cpuSeconds"`    // ffmpeg CPU time over all attempts
+    StoredBytes   int64     `json:"storedBytes"`   // Outputs held on the server when the task finished
+    EgressBytes   int64     `json:"egressBytes"`   // Output bytes served for download so far
+}
+
+// billingLedger keeps the line items of billed tasks for BILLING_RETENTION,
+// after the tasks themselves have been evicted. It is persisted in DATA_DIR.
+type billingLedger struct {
+    path      string // File the line items are persisted to; empty to keep them in memory only
+    retention time.Duration
+    mu        sync.Mutex
+    items     map[string]*LineItem // By task ID
+    dirty     bool                 // Changed since the last flush
+}
+
+func newBillingLedger(cfg *config.Config) (*billingLedger, error) {
+    b := &billingLedger{retention: cfg.BillingRetention, items: make(map[string]*LineItem)}
+    if cfg.DataDir == "" {
+        return b, nil
+    }
+    b.path = filepath.Join(cfg.DataDir, "billing.json")
+    data, err := os.ReadFile(b.path)
+    if errors.Is(err, os.ErrNotExist) {
+        return b, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    var items []*LineItem
+    if err := json.Unmarshal(data, &items); err != nil {
+        return nil, fmt.Errorf("could not read %s: %w", b.path, err)
+    }
+    for _, item := range items {
+        b.items[item.TaskID] = item
+    }
+    return b, nil
+}
+
+// record adds the line item of a terminal billed task. Tasks that never
+// started consumed nothing and get none.
+func (b *billingLedger) record(t *Task) {
+    if !t.Billing || t.Attempt == 0 {
+        return
+    }
+    item := &LineItem{
+        TaskID:      t.ID,
+        Owner:       t.Owner,
+        Status:      t.Status,
+        Preset:      t.Preset,
+        Queue:       t.Queue,
+        CreatedAt:   t.CreatedAt,
+        CompletedAt: t.CompletedAt,
+        CPUSeconds:  t.CPUSeconds,
+        StoredBytes: storedBytes(t),
+    }
+    if item.CompletedAt.IsZero() {
+        item.CompletedAt = time.Now()
+    }
+    if !t.StartedAt.IsZero() {
+        item.EncodeSeconds = item.CompletedAt.Sub(t.StartedAt).Seconds()
+    }
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if old, ok := b.items[t.ID]; ok {
+        item.EgressBytes = old.EgressBytes
+    }
+    b.items[t.ID] = item
+    b.dirty = true
+}
+
+// addEgress counts bytes served from a billed task's outputs.
+func (b *billingLedger) addEgress(taskID string, n int64) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if item, ok := b.items[taskID]; ok && n > 0 {
+        item.EgressBytes += n
+        b.dirty = true
+    }
+}
+
+// lineItems returns copies of the line items of tasks that completed in
+// [from, to), of one owner or of all if owner is empty, oldest first.
+func (b *billingLedger) lineItems(owner string, from, to time.Time) []LineItem {
+    b.mu.Lock()
+    var items []LineItem
+    for _, item := range b.items {
+        if (owner != "" && item.Owner != owner) || item.CompletedAt.Before(from) || !item.CompletedAt.Before(to) {
+            continue
+        }
+        items = append(items, *item)
+    }
+    b.mu.Unlock()
+    sort.Slice(items, func(i, j int) bool {
+        if !items[i].CompletedAt.Equal(items[j].CompletedAt) {
+            return items[i].CompletedAt.Before(items[j].CompletedAt)
+        }
+        return items[i].TaskID < items[j].TaskID
+    })
+    return items
+}
+
+// prune drops the line items older than the retention.
+func (b *billingLedger) prune(now time.Time) {
+    if b.retention <= 0 {
+        return
+    }
+    cutoff := now.Add(-b.retention)
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    for id, item := range b.items {
+        if item.CompletedAt.Before(cutoff) {
+            delete(b.items, id)
+            b.dirty = true
+        }
+    }
+}
+
+// flush writes the line items to disk if they changed.
+func (b *billingLedger) flush() error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if b.path == "" || !b.dirty {
+        return nil
+    }
+    items := make([]*LineItem, 0, len(b.items))
+    for _, item := range b.items {
+        items = append(items, item)
+    }
+    sort.Slice(items, func(i, j int) bool { return items[i].TaskID < items[j].TaskID })
+    data, err := json.Marshal(items)
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(b.path), 0o700); err != nil {
+        return err
+    }
+    // Write then rename, so a crash never leaves a truncated ledger.
+    tmp := b.path + ".tmp"
+    if err := os.WriteFile(tmp, data, 0o600); err != nil {
+        return err
+    }
+    if err := os.Rename(tmp, b.path); err != nil {
+        return err
+    }
+    b.dirty = false
+    return nil
+}
+
+// LineItems returns the billing line items of the tasks that completed in
+// [from, to), of one owner or of all owners if owner is empty.
+func (m *Manager) LineItems(owner string, from, to time.Time) []LineItem {
+    return m.billing.lineItems(owner, from, to)
+}
+
+// RecordEgress counts n bytes served from the files of a task against its
+// line item, if the task is billed.
+func (m *Manager) RecordEgress(taskID string, n int64) {
+    m.billing.addEgress(taskID, n)
+}
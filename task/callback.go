@@ -0,0 +1,184 @@
+package task
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "net/http"
+    "sort"
+    "sync"
+    "time"
+
+    "ffwebapi/config"
+    "ffwebapi/netguard"
+)
+
+// CallbackAttempt records one delivery of a task's completion webhook.
+type CallbackAttempt struct {
+    Attempt     int        `json:"attempt"`
+    URL         string     `json:"url"`
+    SentAt      time.Time  `json:"sentAt"`
+    StatusCode  int        `json:"statusCode,omitempty"` // 0 if no response was received
+    LatencyMs   int64      `json:"latencyMs"`
+    Error       string     `json:"error,omitempty"`
+    NextRetryAt *time.Time `json:"nextRetryAt,omitempty"` // Set when another attempt is scheduled
+}
+
+// CallbackEndpoint summarizes deliveries to one callback URL.
+type CallbackEndpoint struct {
+    URL                 string    `json:"url"`
+    Deliveries          int       `json:"deliveries"`
+    Failures            int       `json:"failures"`
+    ConsecutiveFailures int       `json:"consecutiveFailures"`
+    LastStatusCode      int       `json:"lastStatusCode,omitempty"`
+    LastError           string    `json:"lastError,omitempty"`
+    LastAttemptAt       time.Time `json:"lastAttemptAt"`
+    LastTaskID          string    `json:"lastTaskId"`
+}
+
+// callbackTracker delivers completion webhooks and keeps the history of every
+// attempt, so delivery problems can be diagnosed through the API.
+type callbackTracker struct {
+    cfg       *config.Config
+    client    *http.Client
+    mu        sync.Mutex
+    attempts  map[string][]CallbackAttempt // By task ID
+    endpoints map[string]*CallbackEndpoint // By URL
+}
+
+func newCallbackTracker(cfg *config.Config) *callbackTracker {
+    return &callbackTracker{
+        cfg:       cfg,
+        client:    netguard.InputPolicy(cfg).Client(cfg.CallbackTimeout),
+        attempts:  make(map[string][]CallbackAttempt),
+        endpoints: make(map[string]*CallbackEndpoint),
+    }
+}
+
+// notify starts delivering the webhook of a terminal task in the background.
+func (ct *callbackTracker) notify(t *Task) {
+    if t.CallbackURL == "" {
+        return
+    }
+    body, err := json.Marshal(t)
+    if err != nil {
+        t.logger().Error("Could not encode callback payload", "error", err)
+        return
+    }
+    go ct.deliver(t.ID, t.CallbackURL, body, 1)
+}
+
+// deliver POSTs the payload once and schedules a retry with exponential
+// backoff if the endpoint didn't answer with a 2xx.
+func (ct *callbackTracker) deliver(taskID, url string, body []byte, attempt int) {
+    a := CallbackAttempt{Attempt: attempt, URL: url, SentAt: time.Now()}
+    err := ct.post(url, body, &a)
+    a.LatencyMs = time.Since(a.SentAt).Milliseconds()
+
+    var delay time.Duration
+    if err != nil {
+        a.Error = err.Error()
+        if attempt <= ct.cfg.CallbackMaxRetries {
+            delay = ct.cfg.CallbackRetryBackoff << (attempt - 1)
+            next := a.SentAt.Add(delay)
+            a.NextRetryAt = &next
+        }
+        slog.Warn("Callback attempt failed", "task_id", taskID, "attempt", attempt, "url", url, "error", err)
+    }
+    ct.record(taskID, a)
+
+    if a.NextRetryAt != nil {
+        time.AfterFunc(delay, func() { ct.deliver(taskID, url, body, attempt+1) })
+    }
+}
+
+func (ct *callbackTracker) post(url string, body []byte, a *CallbackAttempt) error {
+    // The URL was checked at submission, but the policy may have changed since.
+    if err := netguard.InputPolicy(ct.cfg).CheckURL(url); err != nil {
+        return err
+    }
+    req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    resp, err := ct.client.Do(req)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    a.StatusCode = resp.StatusCode
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return fmt.Errorf("endpoint answered %s", resp.Status)
+    }
+    return nil
+}
+
+func (ct *callbackTracker) record(taskID string, a CallbackAttempt) {
+    ct.mu.Lock()
+    defer ct.mu.Unlock()
+    ct.attempts[taskID] = append(ct.attempts[taskID], a)
+
+    ep, ok := ct.endpoints[a.URL]
+    if !ok {
+        ep = &CallbackEndpoint{URL: a.URL}
+        ct.endpoints[a.URL] = ep
+    }
+    ep.Deliveries++
+    ep.LastStatusCode = a.StatusCode
+    ep.LastError = a.Error
+    ep.LastAttemptAt = a.SentAt
+    ep.LastTaskID = taskID
+    if a.Error != "" {
+        ep.Failures++
+        ep.ConsecutiveFailures++
+    } else {
+        ep.ConsecutiveFailures = 0
+    }
+}
+
+// forget drops the delivery history of a deleted task.
+func (ct *callbackTracker) forget(taskID string) {
+    ct.mu.Lock()
+    defer ct.mu.Unlock()
+    delete(ct.attempts, taskID)
+}
+
+func (ct *callbackTracker) history(taskID string) []CallbackAttempt {
+    ct.mu.Lock()
+    defer ct.mu.Unlock()
+    return append([]CallbackAttempt{}, ct.attempts[taskID]...)
+}
+
+// failing lists the endpoints whose latest delivery failed, worst first.
+func (ct *callbackTracker) failing() []CallbackEndpoint {
+    ct.mu.Lock()
+    defer ct.mu.Unlock()
+    list := []CallbackEndpoint{}
+    for _, ep := range ct.endpoints {
+        if ep.ConsecutiveFailures > 0 {
+            list = append(list, *ep)
+        }
+    }
+    sort.Slice(list, func(i, j int) bool {
+        if list[i].ConsecutiveFailures != list[j].ConsecutiveFailures {
+            return list[i].ConsecutiveFailures > list[j].ConsecutiveFailures
+        }
+        return list[i].URL < list[j].URL
+    })
+    return list
+}
+
+// CallbackAttempts returns the webhook delivery history of a task.
+func (m *Manager) CallbackAttempts(taskID string) ([]CallbackAttempt, bool) {
+    if _, ok := m.Get(taskID); !ok {
+        return nil, false
+    }
+    return m.callbacks.history(taskID), true
+}
+
+// FailingCallbackEndpoints returns the callback URLs whose latest delivery failed.
+func (m *Manager) FailingCallbackEndpoints() []CallbackEndpoint {
+    return m.callbacks.failing()
+}
@@ -0,0 +1,40 @@
+package task
+
+// CancelResult reports the cancellation of one member of a group of tasks.
+type CancelResult struct {
+    TaskID   string `json:"taskId"`
+    Status   Status `json:"status"`   // After the cancellation; processing tasks stop shortly after
+    Canceled bool   `json:"canceled"` // False if the task was already terminal
+    Error    string `json:"error,omitempty"`
+}
+
+// CancelAll cancels the non-terminal tasks of a group, such as a batch or a
+// pipeline, as one operation: no other cancellation interleaves with it, and
+// waiting and queued members are canceled before processing ones, so the
+// slots freed by the latter never start another member. Waiting members go
+// first so they end up canceled rather than skipped. Results are in the order
+// of tasks.
+func (m *Manager) CancelAll(tasks []*Task) []CancelResult {
+    m.cancelMu.Lock()
+    defer m.cancelMu.Unlock()
+
+    results := make([]CancelResult, len(tasks))
+    // The last pass takes the rest: terminal tasks and those that changed state meanwhile.
+    for _, pass := range []Status{StatusWaiting, StatusScheduled, StatusQueued, StatusWaitingResources, StatusProcessing, ""} {
+        for i, t := range tasks {
+            if results[i].TaskID != "" || (pass != "" && t.status() != pass) {
+                continue
+            }
+            results[i].TaskID = t.ID
+            if err := m.cancel(t.ID); err != nil {
+                results[i].Error = err.Error()
+            } else {
+                results[i].Canceled = true
+            }
+        }
+    }
+    for i, t := range tasks {
+        results[i].Status = t.status()
+    }
+    return results
+}
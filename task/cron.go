@@ -0,0 +1,159 @@
+package task
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// cronMacros are the shorthands accepted for common cron expressions.
+var cronMacros = map[string]string{
+    "@yearly":   "0 0 1 1 *",
+    "@annually": "0 0 1 1 *",
+    "@monthly":  "0 0 1 * *",
+    "@weekly":   "0 0 * * 0",
+    "@daily":    "0 0 * * *",
+    "@midnight": "0 0 * * *",
+    "@hourly":   "0 * * * *",
+}
+
+// cronField describes one of the five fields of a cron expression.
+type cronField struct {
+    name     string
+    min, max int
+    names    []string // Names of the values from min on, e.g. "jan"
+}
+
+var cronFields = []cronField{
+    {name: "minute", min: 0, max: 59},
+    {name: "hour", min: 0, max: 23},
+    {name: "day of month", min: 1, max: 31},
+    {name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+    {name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}, // 0 and 7 are Sunday
+}
+
+// cronSpec is a parsed cron expression: the values each field matches, as
+// bit sets.
+type cronSpec struct {
+    minute, hour, dom, month, dow uint64
+    domStar, dowStar              bool // Unrestricted; otherwise a day matching either field is due
+}
+
+// parseCron parses a standard five-field cron expression ("minute hour
+// day-of-month month day-of-week", with *, lists, ranges, steps and month
+// and weekday names) or one of the @daily style macros.
+func parseCron(expr string) (*cronSpec, error) {
+    expr = strings.TrimSpace(expr)
+    if macro, ok := cronMacros[strings.ToLower(expr)]; ok {
+        expr = macro
+    }
+    fields := strings.Fields(expr)
+    if len(fields) != len(cronFields) {
+        return nil, fmt.Errorf("cron expression %q must have 5 fields (minute hour day-of-month month day-of-week) or be a macro such as @daily", expr)
+    }
+    sets := make([]uint64, len(fields))
+    for i, f := range fields {
+        set, err := cronFields[i].parse(f)
+        if err != nil {
+            return nil, fmt.Errorf("cron %s: %w", cronFields[i].name, err)
+        }
+        sets[i] = set
+    }
+    spec := &cronSpec{minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+        domStar: strings.HasPrefix(fields[2], "*"), dowStar: strings.HasPrefix(fields[4], "*")}
+    if spec.dow&(1<<7) != 0 {
+        spec.dow |= 1 // Sunday
+    }
+    return spec, nil
+}
+
+// parse reads a comma-separated list of values, ranges and steps.
+func (f cronField) parse(s string) (uint64, error) {
+    var set uint64
+    for _, item := range strings.Split(s, ",") {
+        rng, stepStr, hasStep := strings.Cut(item, "/")
+        step := 1
+        if hasStep {
+            n, err := strconv.Atoi(stepStr)
+            if err != nil || n < 1 {
+                return 0, fmt.Errorf("invalid step %q", stepStr)
+            }
+            step = n
+        }
+        lo, hi := f.min, f.max
+        if rng != "*" {
+            from, to, isRange := strings.Cut(rng, "-")
+            var err error
+            if lo, err = f.value(from); err != nil {
+                return 0, err
+            }
+            hi = lo
+            if isRange {
+                if hi, err = f.value(to); err != nil {
+                    return 0, err
+                }
+            } else if hasStep {
+                hi = f.max // "5/15" is "5-59/15"
+            }
+            if hi < lo {
+                return 0, fmt.Errorf("invalid range %q", rng)
+            }
+        }
+        for v := lo; v <= hi; v += step {
+            set |= 1 << uint(v)
+        }
+    }
+    return set, nil
+}
+
+// value parses a number or name within the field's bounds.
+func (f cronField) value(s string) (int, error) {
+    for i, name := range f.names {
+        if strings.EqualFold(s, name) {
+            return f.min + i, nil
+        }
+    }
+    n, err := strconv.Atoi(s)
+    if err != nil || n < f.min || n > f.max {
+        return 0, fmt.Errorf("%q is not between %d and %d", s, f.min, f.max)
+    }
+    return n, nil
+}
+
+// next returns the first time after after that the expression matches, in
+// after's location, or the zero time if none comes within five years (e.g.
+// for "0 0 30 2 *").
+func (c *cronSpec) next(after time.Time) time.Time {
+    loc := after.Location()
+    t := after.Truncate(time.Minute).Add(time.Minute)
+    limit := t.AddDate(5, 0, 0)
+    for t.Before(limit) {
+        switch {
+        case c.month&(1<<uint(t.Month())) == 0:
+            t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+        case !c.dayMatches(t):
+            t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+        case c.hour&(1<<uint(t.Hour())) == 0:
+            // Added rather than set, so hours repeated or skipped by DST
+            // changes are stepped over.
+            t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+        case c.minute&(1<<uint(t.Minute())) == 0:
+            t = t.Add(time.Minute)
+        default:
+            return t
+        }
+    }
+    return time.Time{}
+}
+
+// dayMatches applies cron's rule for the two day fields: if both are
+// restricted, a day matching either is due.
+func (c *cronSpec) dayMatches(t time.Time) bool {
+    dom := c.dom&(1<<uint(t.Day())) != 0
+    dow := c.dow&(1<<uint(t.Weekday())) != 0
+    if c.domStar || c.dowStar {
+        return dom && dow
+    }
+    return dom || dow
+}
@@ -0,0 +1,107 @@
+package task
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "os"
+    "sync"
+)
+
+// dedupeIndex finds completed tasks by their command and input content, so
+// that with DEDUPE_TASKS an identical task of the same owner reuses their
+// output instead of running ffmpeg again. Only the latest such task is kept
+// per key; entries are dropped when their task is deleted or its output
+// expires, and on lookup if the output is gone otherwise.
+type dedupeIndex struct {
+    mu    sync.Mutex
+    byKey map[string]*Task
+}
+
+func newDedupeIndex() *dedupeIndex {
+    return &dedupeIndex{byKey: make(map[string]*Task)}
+}
+
+// dedupeKey identifies what a task produces from an input with the given
+// content digest: everything that goes into its arguments or decides whether
+// its output passes QC. Keys include the owner, so tasks never reuse (and
+// dedupedFrom never reveals) another owner's tasks.
+func dedupeKey(t *Task, inputDigest string) string {
+    key, _ := json.Marshal(struct {
+        Owner            string
+        Tool             string
+        Command          string
+        OutputExt        string
+        QC               string
+        Target           *ConformanceTarget
+        StreamLabels     []StreamLabel
+        SkipStreamLabels bool
+        InputDigest      string
+    }{t.Owner, t.Tool, t.Command, t.OutputExt, t.QC, t.Target, t.StreamLabels, t.SkipStreamLabels, inputDigest})
+    sum := sha256.Sum256(key)
+    return hex.EncodeToString(sum[:])
+}
+
+// dedupable reports whether a task's output depends on nothing but its
+// command and input. Tasks with further inputs, several or generated
+// outputs are always run.
+func dedupable(t *Task) bool {
+    return t.OutputMode != OutputModeDirectory && len(t.OutputExts) == 0 && len(t.ExtraInputs) == 0 &&
+        t.Pipe == nil && t.Subtitles == "" && len(t.ExtraFiles) == 0
+}
+
+func (d *dedupeIndex) lookup(key string, t *Task) *Task {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    src, ok := d.byKey[key]
+    if !ok || src == t {
+        return nil
+    }
+    if _, err := os.Stat(src.OutputPath); !src.Status.Succeeded() || src.OutputPath == "" || err != nil {
+        delete(d.byKey, key)
+        return nil
+    }
+    return src
+}
+
+// record indexes a task that completed, unless it reused another's output.
+func (d *dedupeIndex) record(t *Task) {
+    if d == nil || t.dedupeKey == "" || t.DedupedFrom != "" || !t.Status.Succeeded() {
+        return
+    }
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    d.byKey[t.dedupeKey] = t
+}
+
+// drop removes a task from the index once it is deleted or its output
+// expired, unless a later task took its place.
+func (d *dedupeIndex) drop(t *Task) {
+    if d == nil || t.dedupeKey == "" {
+        return
+    }
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    if d.byKey[t.dedupeKey] == t {
+        delete(d.byKey, t.dedupeKey)
+    }
+}
+
+// FindDuplicate is called by runners once the content digest of the input
+// is known. It returns a completed task that ran the same command on the
+// same content and whose output still exists, or nil if the task has to
+// run. Identical tasks running at the same time both run.
+func (t *Task) FindDuplicate(inputDigest string) *Task {
+    t.Update(func() { t.InputDigest = inputDigest })
+    if t.dedupe == nil || !dedupable(t) {
+        return nil
+    }
+    t.dedupeKey = dedupeKey(t, inputDigest)
+    return t.dedupe.lookup(t.dedupeKey, t)
+}
+
+// Dedupes reports whether the runner should compute the digest of the input
+// for FindDuplicate.
+func (t *Task) Dedupes() bool {
+    return t.dedupe != nil && dedupable(t)
+}
@@ -0,0 +1,88 @@
+package task
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+)
+
+// Errors of Delete, for mapping to HTTP statuses.
+var (
+    ErrTaskProcessing = errors.New("task is still processing")
+    ErrTaskInUse      = errors.New("task output is the input of a task that has not run yet")
+)
+
+// Delete removes a task, its artifacts and, once no other task needs it, its
+// uploaded input. Queued and waiting tasks are canceled first. A processing
+// task is refused with ErrTaskProcessing unless force is set, in which case it
+// is canceled and Delete waits for it to stop or ctx to be done.
+func (m *Manager) Delete(ctx context.Context, taskID string, force bool) error {
+    t, ok := m.Get(taskID)
+    if !ok {
+        return fmt.Errorf("task %s not found", taskID)
+    }
+    if m.feedsPendingTask(t) {
+        return ErrTaskInUse
+    }
+
+    switch t.status() {
+    case StatusProcessing:
+        if !force {
+            return ErrTaskProcessing
+        }
+        if err := m.Cancel(taskID); err != nil {
+            return err
+        }
+        select {
+        case <-t.Done():
+        case <-ctx.Done():
+            return ctx.Err()
+        }
+    case StatusQueued, StatusWaiting, StatusWaitingResources, StatusScheduled:
+        if err := m.Cancel(taskID); err != nil {
+            return err
+        }
+    }
+
+    m.forget(ctx, t)
+    t.logger().Info("Task deleted")
+    return nil
+}
+
+// forget drops a finished task with its artifacts and delivery history, and
+// releases its input.
+func (m *Manager) forget(ctx context.Context, t *Task) {
+    m.tasks.Delete(t.ID)
+    m.dedupe.drop(t)
+    var artifacts []*Artifact
+    t.Update(func() {
+        artifacts, t.Artifacts = t.Artifacts, nil
+        t.ResultData = ""
+    })
+    for _, a := range artifacts {
+        m.files.Delete(m.relPath(a))
+        if err := removeArtifact(a); err != nil {
+            t.logger().Error("Could not remove artifact", "path", a.Path, "error", err)
+        }
+    }
+    m.removeFilesDir(t)
+    m.callbacks.forget(t.ID)
+    if in, ok := m.GetInput(t.InputID); ok {
+        m.releaseInput(ctx, in, time.Now())
+    }
+}
+
+// feedsPendingTask reports whether the output of t is to become the input of
+// a task that has not finished yet.
+func (m *Manager) feedsPendingTask(t *Task) bool {
+    pending := false
+    m.tasks.Range(func(key, value interface{}) bool {
+        other := value.(*Task)
+        if other.inputFrom == t.ID && !other.status().IsTerminal() {
+            pending = true
+        }
+        return !pending
+    })
+    return pending
+}
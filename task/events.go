@@ -0,0 +1,172 @@
+package task
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "log/slog"
+    "sync"
+    "time"
+
+    "github.com/lithammer/shortuuid/v4"
+)
+
+// Types of task lifecycle events. Tasks that end publish "task.<status>",
+// e.g. "task.completed" or "task.failed".
+const (
+    EventCreated = "task.created" // Accepted; queued, scheduled or waiting for dependencies
+    EventStarted = "task.started" // An attempt started processing
+)
+
+const (
+    eventRetryMin     = time.Second
+    eventRetryMax     = 30 * time.Second
+    eventMaxAttempts  = 8               // Publishing attempts before an event is dropped, about a minute and a half
+    eventFlushTimeout = 5 * time.Second // How long Shutdown waits for the last events
+)
+
+// ErrEventRejected is wrapped by publishers whose broker refused an event for
+// good, e.g. with an HTTP 4xx answer; the event is dropped, not retried.
+var ErrEventRejected = errors.New("event rejected by the broker")
+
+// Event is a task lifecycle event, published to a message broker so other
+// systems (catalogs, billing, search indexing) can react without polling.
+type Event struct {
+    ID     string          `json:"id"`   // Unique per event; redeliveries keep it, so consumers can deduplicate
+    Type   string          `json:"type"` // EventCreated, EventStarted or "task.<status>"
+    Time   time.Time       `json:"time"`
+    TaskID string          `json:"taskId"`
+    Task   json.RawMessage `json:"task"` // The task at the time of the event, without download URLs
+}
+
+// EventPublisher sends events to a message broker, e.g. a NATS subject, a
+// Kafka topic or a RabbitMQ exchange.
+type EventPublisher interface {
+    Publish(ctx context.Context, e Event) error
+}
+
+// eventOf returns the type of the event a task ending in status publishes.
+func eventOf(status Status) string {
+    return "task." + string(status)
+}
+
+// eventQueue publishes events in order from a bounded buffer. A failing
+// broker is retried with backoff while events queue up, up to
+// eventMaxAttempts per event; once the buffer is full, new events are
+// dropped.
+type eventQueue struct {
+    p        EventPublisher
+    ch       chan Event
+    done     chan struct{}
+    stop     chan struct{} // Closed when close gives up on the queued events
+    stopOnce sync.Once
+    mu       sync.Mutex // Guards sending on ch against closing it
+    closed   bool
+}
+
+func newEventQueue(p EventPublisher, size int) *eventQueue {
+    if size <= 0 {
+        size = 1
+    }
+    q := &eventQueue{p: p, ch: make(chan Event, size), done: make(chan struct{}), stop: make(chan struct{})}
+    go q.run()
+    return q
+}
+
+// publish queues an event of t. A snapshot of the task is encoded right away,
+// so the event shows its state at that time.
+func (q *eventQueue) publish(typ string, t *Task) {
+    if q == nil {
+        return
+    }
+    body, err := json.Marshal(t.Snapshot())
+    if err != nil {
+        t.logger().Error("Could not encode task event", "type", typ, "error", err)
+        return
+    }
+    e := Event{ID: shortuuid.New(), Type: typ, Time: time.Now(), TaskID: t.ID, Task: body}
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    if q.closed {
+        t.logger().Warn("Dropping task event after shutdown", "type", typ)
+        return
+    }
+    select {
+    case q.ch <- e:
+    default:
+        t.logger().Warn("Event buffer full, dropping task event", "type", typ)
+    }
+}
+
+func (q *eventQueue) run() {
+    defer close(q.done)
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    go func() {
+        select {
+        case <-q.stop:
+            cancel()
+        case <-ctx.Done():
+        }
+    }()
+    for e := range q.ch {
+        if !q.send(ctx, e) {
+            return
+        }
+    }
+}
+
+// send publishes e, retrying with backoff until the broker takes it, rejects
+// it or eventMaxAttempts are used up. It returns false once ctx is done.
+func (q *eventQueue) send(ctx context.Context, e Event) bool {
+    delay := eventRetryMin
+    for attempt := 1; ; attempt++ {
+        err := q.p.Publish(ctx, e)
+        switch {
+        case err == nil:
+            return true
+        case ctx.Err() != nil:
+            return false
+        case errors.Is(err, ErrEventRejected):
+            slog.Error("Task event rejected, dropping it", "task_id", e.TaskID, "type", e.Type, "error", err)
+            return true
+        case attempt == eventMaxAttempts:
+            slog.Error("Could not publish task event, dropping it", "task_id", e.TaskID, "type", e.Type, "attempts", attempt, "error", err)
+            return true
+        }
+        slog.Warn("Could not publish task event, retrying", "task_id", e.TaskID, "type", e.Type, "retry_in", delay, "error", err)
+        select {
+        case <-time.After(delay):
+        case <-ctx.Done():
+            return false
+        }
+        delay = min(2*delay, eventRetryMax)
+    }
+}
+
+// close stops accepting events and waits until the queued ones are
+// published or ctx is done, which stops publishing the rest.
+func (q *eventQueue) close(ctx context.Context) {
+    if q == nil {
+        return
+    }
+    q.mu.Lock()
+    if !q.closed {
+        q.closed = true
+        close(q.ch)
+    }
+    q.mu.Unlock()
+    select {
+    case <-q.done:
+    case <-ctx.Done():
+        slog.Warn("Gave up publishing the last task events", "pending", len(q.ch))
+        q.stopOnce.Do(func() { close(q.stop) })
+    }
+}
+
+// SetEventPublisher publishes the lifecycle events of tasks through p from
+// now on, buffering up to EVENTS_BUFFER_SIZE while the broker is unreachable.
+// It is called once, before Start.
+func (m *Manager) SetEventPublisher(p EventPublisher) {
+    m.events = newEventQueue(p, m.cfg.EventsBufferSize)
+}
@@ -0,0 +1,198 @@
+package task
+
+import (
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/lithammer/shortuuid/v4"
+)
+
+var (
+    ErrDependencyNotFound = errors.New("dependency not found")
+    ErrDependencyCycle    = errors.New("dependencies form a cycle")
+)
+
+// GraphNode is one task of a dependency graph. DependsOn names other nodes
+// of the graph by Ref, or tasks submitted earlier by ID.
+type GraphNode struct {
+    Ref        string
+    Command    string
+    InputMedia string
+    OutputExt  string
+    DependsOn  []string
+    Options    SubmitOptions
+}
+
+// SubmitGraph submits the tasks of a dependency graph as one batch and
+// returns its ID and the tasks in the order of nodes. Tasks start once all
+// their dependencies completed, and are skipped if one of them does not.
+func (m *Manager) SubmitGraph(nodes []GraphNode) (string, []*Task, error) {
+    if len(nodes) == 0 {
+        return "", nil, fmt.Errorf("a graph needs at least one task")
+    }
+    refs := make(map[string]int, len(nodes))
+    for i, n := range nodes {
+        if n.Ref == "" {
+            return "", nil, fmt.Errorf("task %d: ref is required", i)
+        }
+        if _, dup := refs[n.Ref]; dup {
+            return "", nil, fmt.Errorf("duplicate ref %q", n.Ref)
+        }
+        refs[n.Ref] = i
+    }
+    order, err := graphOrder(nodes, refs)
+    if err != nil {
+        return "", nil, err
+    }
+    for _, n := range nodes {
+        for _, dep := range n.DependsOn {
+            if _, internal := refs[dep]; !internal {
+                if _, ok := m.Get(dep); !ok {
+                    return "", nil, fmt.Errorf("%s: %w: %s", n.Ref, ErrDependencyNotFound, dep)
+                }
+            }
+        }
+        q, err := m.queueFor(n.Options.Queue)
+        if err != nil {
+            return "", nil, err
+        }
+        if err := q.admit(); err != nil {
+            return "", nil, err
+        }
+    }
+
+    batchID := fmt.Sprintf("graph_%s_%d", shortuuid.New(), time.Now().Unix())
+    tasks := make([]*Task, len(nodes))
+    for i, n := range nodes {
+        opts := n.Options
+        opts.BatchID = batchID
+        if tasks[i], err = m.newTask(n.Command, n.InputMedia, n.OutputExt, opts); err != nil {
+            return "", nil, fmt.Errorf("%s: %w", n.Ref, err)
+        }
+        if !tasks[i].ScheduledFor.IsZero() && len(n.DependsOn) > 0 {
+            return "", nil, fmt.Errorf("%s: tasks with dependencies cannot be scheduled for later", n.Ref)
+        }
+    }
+    for i, n := range nodes {
+        for _, dep := range n.DependsOn {
+            if j, internal := refs[dep]; internal {
+                dep = tasks[j].ID
+            }
+            tasks[i].DependsOn = append(tasks[i].DependsOn, dep)
+        }
+    }
+
+    // Upstreams are placed before their dependents, so those see whether
+    // they were skipped.
+    m.depsMu.Lock()
+    defer m.depsMu.Unlock()
+    for _, i := range order {
+        m.placeDependent(tasks[i])
+    }
+    tasks[0].logger().Info("Graph submitted", "batch_id", batchID, "tasks", len(tasks))
+    return batchID, tasks, nil
+}
+
+// graphOrder sorts the nodes topologically, upstreams first, and fails if
+// their dependencies form a cycle.
+func graphOrder(nodes []GraphNode, refs map[string]int) ([]int, error) {
+    pending := make([]int, len(nodes)) // Dependencies on other nodes not placed yet
+    dependents := make([][]int, len(nodes))
+    for i, n := range nodes {
+        for _, dep := range n.DependsOn {
+            if j, internal := refs[dep]; internal {
+                pending[i]++
+                dependents[j] = append(dependents[j], i)
+            }
+        }
+    }
+    var order []int
+    for i := range nodes {
+        if pending[i] == 0 {
+            order = append(order, i)
+        }
+    }
+    for k := 0; k < len(order); k++ {
+        for _, i := range dependents[order[k]] {
+            if pending[i]--; pending[i] == 0 {
+                order = append(order, i)
+            }
+        }
+    }
+    if len(order) < len(nodes) {
+        for i := range nodes {
+            if pending[i] > 0 {
+                return nil, fmt.Errorf("%w through %q", ErrDependencyCycle, nodes[i].Ref)
+            }
+        }
+    }
+    return order, nil
+}
+
+// submitDependent submits a task that depends on tasks submitted earlier.
+func (m *Manager) submitDependent(t *Task) (*Task, error) {
+    if !t.ScheduledFor.IsZero() {
+        return nil, fmt.Errorf("tasks with dependencies cannot be scheduled for later")
+    }
+    for _, dep := range t.DependsOn {
+        if _, ok := m.Get(dep); !ok {
+            return nil, fmt.Errorf("%w: %s", ErrDependencyNotFound, dep)
+        }
+    }
+    m.depsMu.Lock()
+    defer m.depsMu.Unlock()
+    m.placeDependent(t)
+    return t, nil
+}
+
+// placeDependent stores a new task with dependencies: skipped if one of
+// them did not complete, queued if all did, and waiting otherwise, until
+// resolveDependents releases it. The caller holds depsMu.
+func (m *Manager) placeDependent(t *Task) {
+    ready := true
+    for _, id := range t.DependsOn {
+        dep, ok := m.Get(id)
+        var depStatus Status
+        if ok {
+            depStatus = dep.status()
+        }
+        switch {
+        case !ok:
+            // Deleted meanwhile; its outcome is unknown.
+            m.tasks.Store(t.ID, t)
+            m.events.publish(EventCreated, t)
+            m.skip(t, fmt.Sprintf("Skipped because upstream task %s no longer exists", id))
+            return
+        case depStatus.IsTerminal() && !depStatus.Succeeded():
+            m.tasks.Store(t.ID, t)
+            m.events.publish(EventCreated, t)
+            m.skip(t, fmt.Sprintf("Skipped because upstream task %s %s", dep.ID, depStatus))
+            return
+        case !depStatus.Succeeded():
+            ready = false
+        }
+    }
+    if !ready {
+        t.setStatus(StatusWaiting)
+        m.tasks.Store(t.ID, t)
+        m.events.publish(EventCreated, t)
+        t.logger().Info("Task waiting for its dependencies", "depends_on", t.DependsOn)
+        return
+    }
+    m.tasks.Store(t.ID, t)
+    m.events.publish(EventCreated, t)
+    m.enqueue(t)
+    t.logger().Info("Task submitted to queue", "queue", t.Queue, "priority", t.Priority)
+}
+
+// skip ends a task that cannot run because of an upstream.
+func (m *Manager) skip(t *Task, reason string) {
+    t.finish(StatusSkipped, reason, nil)
+    m.tasks.Store(t.ID, t)
+    t.markDone()
+    m.callbacks.notify(t)
+    m.notify(t)
+    m.events.publish(eventOf(StatusSkipped), t)
+    t.logger().Info("Task skipped", "reason", reason)
+}
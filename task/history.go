@@ -0,0 +1,271 @@
+package task
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    "ffwebapi/config"
+    "ffwebapi/storage"
+)
+
+// historyPrefix is where archived tasks go in the bucket: the records of a
+// day as history/<day>.ndjson, and the logs as history/<day>/<task>.log.
+const historyPrefix = "history/"
+
+// uploadedSuffix marks a spooled batch that is in its day's dump in the
+// bucket. It stays in the spool until the day is over, as the dump is
+// rewritten with the batches of later passes.
+const uploadedSuffix = ".uploaded"
+
+// archivedTask is a line of a history dump: the task as clients saw it.
+type archivedTask struct {
+    *Task
+    ArchivedAt time.Time `json:"archivedAt"`
+    LogKey     string    `json:"logKey,omitempty"` // Object holding the whole log
+}
+
+// historyExporter archives tasks before TASK_RETENTION evicts them. The
+// records of a pass are written to a batch in a local spool, and the day's
+// batches are uploaded together as the day's dump, so each task is in it once.
+// The spool outlives restarts, and tells which tasks are archived already
+// until they are evicted.
+type historyExporter struct {
+    store    storage.Backend
+    dir      string          // Spool of batches, by <day>T<time>.ndjson
+    archived map[string]bool // Tasks in a batch, by ID, until they are evicted
+}
+
+func newHistoryExporter(cfg *config.Config) (*historyExporter, error) {
+    if !cfg.HistoryExport {
+        return nil, nil
+    }
+    store, err := storage.NewS3(cfg)
+    if err != nil {
+        return nil, fmt.Errorf("HISTORY_EXPORT: %w", err)
+    }
+    dir := cfg.DataDir
+    if dir == "" {
+        dir = cfg.TempDir
+    }
+    h := &historyExporter{store: store, dir: filepath.Join(dir, "history"), archived: map[string]bool{}}
+    if err := h.load(); err != nil {
+        return nil, fmt.Errorf("HISTORY_EXPORT: %w", err)
+    }
+    return h, nil
+}
+
+// load marks the tasks of the spooled batches as archived.
+func (h *historyExporter) load() error {
+    for _, pattern := range []string{"*.ndjson", "*.ndjson" + uploadedSuffix} {
+        batches, err := filepath.Glob(filepath.Join(h.dir, pattern))
+        if err != nil {
+            return err
+        }
+        for _, path := range batches {
+            data, err := os.ReadFile(path)
+            if err != nil {
+                return err
+            }
+            for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+                var rec struct {
+                    ID string `json:"id"`
+                }
+                if json.Unmarshal([]byte(line), &rec) == nil && rec.ID != "" {
+                    h.archived[rec.ID] = true
+                }
+            }
+        }
+    }
+    return nil
+}
+
+// archive writes the tasks not archived yet, and their logs, to a new batch,
+// and uploads the dump of every day with a batch that is not in the bucket
+// yet, including those a failure left behind. Once it returns nil, all tasks
+// are in the bucket.
+func (h *historyExporter) archive(ctx context.Context, tasks []*Task, now time.Time) error {
+    day := now.UTC().Format("2006-01-02")
+    batch := day + "T" + now.UTC().Format("150405.000000000") + ".ndjson"
+    if err := os.MkdirAll(h.dir, 0o700); err != nil {
+        return err
+    }
+    for _, t := range tasks {
+        if h.archived[t.ID] {
+            continue
+        }
+        rec := archivedTask{Task: t, ArchivedAt: now}
+        if a, ok := t.Artifact(ArtifactLog); ok {
+            rec.LogKey = historyPrefix + day + "/" + t.ID + ".log"
+            if err := h.upload(ctx, rec.LogKey, a.Path); err != nil {
+                return err
+            }
+        }
+        if err := h.appendRecord(batch, rec); err != nil {
+            return err
+        }
+        h.archived[t.ID] = true
+    }
+
+    pending, err := filepath.Glob(filepath.Join(h.dir, "*.ndjson"))
+    if err != nil {
+        return err
+    }
+    sort.Strings(pending)
+    byDay := map[string][]string{}
+    var days []string
+    for _, path := range pending {
+        day, _, _ := strings.Cut(filepath.Base(path), "T")
+        if byDay[day] == nil {
+            days = append(days, day)
+        }
+        byDay[day] = append(byDay[day], path)
+    }
+    for _, day := range days {
+        if err := h.uploadDay(ctx, day); err != nil {
+            return err
+        }
+        for _, path := range byDay[day] {
+            if err := os.Rename(path, path+uploadedSuffix); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// uploadDay uploads the dump of a day: its spooled batches, in order, as one
+// object replacing the previous one.
+func (h *historyExporter) uploadDay(ctx context.Context, day string) error {
+    batches, err := filepath.Glob(filepath.Join(h.dir, day+"T*.ndjson*"))
+    if err != nil {
+        return err
+    }
+    sort.Slice(batches, func(i, j int) bool {
+        return strings.TrimSuffix(batches[i], uploadedSuffix) < strings.TrimSuffix(batches[j], uploadedSuffix)
+    })
+    var dump bytes.Buffer
+    for _, path := range batches {
+        data, err := os.ReadFile(path)
+        if err != nil {
+            return err
+        }
+        dump.Write(data)
+    }
+    key := historyPrefix + day + ".ndjson"
+    if _, err := h.store.Put(ctx, key, &dump); err != nil {
+        return fmt.Errorf("could not upload %s: %w", key, err)
+    }
+    return nil
+}
+
+// evicted forgets the tasks, which are gone from the manager, and drops the
+// batches of earlier days from the spool once their dumps are complete.
+func (h *historyExporter) evicted(tasks []*Task, now time.Time) {
+    for _, t := range tasks {
+        delete(h.archived, t.ID)
+    }
+    today := now.UTC().Format("2006-01-02")
+    uploaded, _ := filepath.Glob(filepath.Join(h.dir, "*.ndjson"+uploadedSuffix))
+    for _, path := range uploaded {
+        day, _, _ := strings.Cut(filepath.Base(path), "T")
+        if day >= today {
+            continue
+        }
+        if pending, _ := filepath.Glob(filepath.Join(h.dir, day+"T*.ndjson")); len(pending) > 0 {
+            continue // The day's dump is uploaded again with them
+        }
+        if err := os.Remove(path); err != nil {
+            slog.Warn("Could not remove an archived history batch", "path", path, "error", err)
+        }
+    }
+}
+
+// appendRecord adds a line to a spooled batch.
+func (h *historyExporter) appendRecord(batch string, rec archivedTask) error {
+    line, err := json.Marshal(rec)
+    if err != nil {
+        return err
+    }
+    f, err := os.OpenFile(filepath.Join(h.dir, batch), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+    if err != nil {
+        return err
+    }
+    _, err = f.Write(append(line, '\n'))
+    if closeErr := f.Close(); err == nil {
+        err = closeErr
+    }
+    return err
+}
+
+func (h *historyExporter) upload(ctx context.Context, key, path string) error {
+    f, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    if _, err := h.store.Put(ctx, key, f); err != nil {
+        return fmt.Errorf("could not upload %s: %w", key, err)
+    }
+    return nil
+}
+
+// evictTasks drops the tasks that finished more than TASK_RETENTION ago,
+// with their artifacts. With HISTORY_EXPORT, they are archived first and
+// kept until that succeeds.
+func (m *Manager) evictTasks(ctx context.Context, now time.Time) {
+    if m.cfg.TaskRetention <= 0 {
+        return
+    }
+    cutoff := now.Add(-m.cfg.TaskRetention)
+    var expired []*Task
+    m.tasks.Range(func(key, value interface{}) bool {
+        t := value.(*Task)
+        if !t.status().IsTerminal() {
+            return true
+        }
+        finished := t.CompletedAt
+        if finished.IsZero() {
+            finished = t.CreatedAt // Canceled before it ran
+        }
+        if finished.Before(cutoff) && !m.feedsPendingTask(t) {
+            expired = append(expired, t)
+        }
+        return true
+    })
+    if len(expired) == 0 {
+        return
+    }
+    sort.Slice(expired, func(i, j int) bool { return expired[i].CreatedAt.Before(expired[j].CreatedAt) })
+
+    if m.history != nil {
+        if err := m.history.archive(ctx, expired, now); err != nil {
+            slog.Error("Could not archive task history; evicting the tasks later", "tasks", len(expired), "error", err)
+            return
+        }
+    }
+    for _, t := range expired {
+        m.forget(ctx, t)
+    }
+    if m.history != nil {
+        m.history.evicted(expired, now)
+    }
+    m.pipelines.Range(func(key, value interface{}) bool {
+        p := value.(*Pipeline)
+        for _, step := range p.Steps {
+            if _, ok := m.tasks.Load(step.ID); ok {
+                return true
+            }
+        }
+        m.pipelines.Delete(key)
+        return true
+    })
+    slog.Info("Evicted finished tasks", "tasks", len(expired), "archived", m.history != nil)
+}
@@ -0,0 +1,86 @@
+package task
+
+import (
+    "errors"
+    "sync"
+    "time"
+
+    "ffwebapi/config"
+)
+
+// ErrIdempotencyKeyReused is returned when an idempotency key is sent again
+// with a different request.
+var ErrIdempotencyKeyReused = errors.New("idempotency key was already used for a different request")
+
+// idempotencyKeys remembers the tasks submitted with an idempotency key for
+// IDEMPOTENCY_WINDOW, so a client retrying a submission gets the task of the
+// first attempt rather than a second encode.
+type idempotencyKeys struct {
+    window  time.Duration
+    mu      sync.Mutex // Held across submissions, so concurrent retries create one task
+    entries map[string]*Task // By owner and key
+    swept   time.Time
+}
+
+func newIdempotencyKeys(cfg *config.Config) *idempotencyKeys {
+    return &idempotencyKeys{window: cfg.IdempotencyWindow, entries: make(map[string]*Task)}
+}
+
+func idempotencyID(owner, key string) string {
+    return owner + "\x00" + key
+}
+
+// lookup returns the live task submitted with a key. The caller holds mu.
+func (k *idempotencyKeys) lookup(owner, key string, now time.Time) *Task {
+    if now.Sub(k.swept) > time.Minute {
+        for id, t := range k.entries {
+            if !now.Before(t.CreatedAt.Add(k.window)) {
+                delete(k.entries, id)
+            }
+        }
+        k.swept = now
+    }
+    t, ok := k.entries[idempotencyID(owner, key)]
+    if !ok || !now.Before(t.CreatedAt.Add(k.window)) {
+        return nil
+    }
+    return t
+}
+
+// remember records the key of a submitted task, e.g. of one restored after
+// a restart.
+func (k *idempotencyKeys) remember(t *Task) {
+    if t.IdempotencyKey == "" || k.window <= 0 {
+        return
+    }
+    k.mu.Lock()
+    defer k.mu.Unlock()
+    k.entries[idempotencyID(t.Owner, t.IdempotencyKey)] = t
+}
+
+// SubmitIdempotent submits a task unless the owner submitted one with the
+// same key within IDEMPOTENCY_WINDOW; then that task is returned, with
+// replayed set, even if it was evicted meanwhile. hash identifies the
+// request: reusing a key for another request is ErrIdempotencyKeyReused.
+// Failed submissions are not remembered, so they can be retried.
+func (m *Manager) SubmitIdempotent(key, hash, command, inputMedia, outputExt string, opts SubmitOptions) (t *Task, replayed bool, err error) {
+    k := m.idemKeys
+    if k.window <= 0 {
+        t, err = m.SubmitWithOptions(command, inputMedia, outputExt, opts)
+        return t, false, err
+    }
+    k.mu.Lock()
+    defer k.mu.Unlock()
+    if t := k.lookup(opts.Owner, key, time.Now()); t != nil {
+        if t.idempotencyHash != hash {
+            return nil, false, ErrIdempotencyKeyReused
+        }
+        return t, true, nil
+    }
+    opts.IdempotencyKey, opts.idempotencyHash = key, hash
+    if t, err = m.SubmitWithOptions(command, inputMedia, outputExt, opts); err != nil {
+        return nil, false, err
+    }
+    k.entries[idempotencyID(t.Owner, key)] = t
+    return t, false, nil
+}
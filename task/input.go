@@ -0,0 +1,288 @@
+package task
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "os"
+    "strings"
+    "time"
+
+    "ffwebapi/storage"
+
+    "github.com/lithammer/shortuuid/v4"
+)
+
+// InputRefPrefix marks an inputMedia that refers to an uploaded input, e.g.
+// "input://in_abc123".
+const InputRefPrefix = "input://"
+
+type InputStatus string
+
+const (
+    InputReserved InputStatus = "reserved" // Waiting for its upload
+    InputUploaded InputStatus = "uploaded"
+)
+
+// Errors of input reservations, for mapping to HTTP statuses.
+var (
+    ErrInputNotFound    = errors.New("input not found")
+    ErrInputNotUploaded = errors.New("input has not been uploaded")
+    ErrInputConflict    = errors.New("input has already been uploaded")
+    ErrInputExpired     = errors.New("upload window has expired")
+    ErrInputTooLarge    = errors.New("input exceeds its size limit")
+    ErrQuotaExceeded    = errors.New("upload quota exceeded")
+)
+
+// Input is a slot reserved for a client upload. Tasks reference it with
+// InputRef(ID) instead of a URL.
+type Input struct {
+    ID          string      `json:"inputId"`
+    Status      InputStatus `json:"status"`
+    MaxSize     int64       `json:"maxSize"`             // Declared size, or MAX_INPUT_SIZE
+    Size        int64       `json:"size,omitempty"`      // Once uploaded
+    ContentType string      `json:"contentType,omitempty"`
+    CreatedAt   time.Time   `json:"createdAt"`
+    ExpiresAt   time.Time   `json:"expiresAt"`           // End of the upload window
+    UploadedAt  time.Time   `json:"uploadedAt,omitempty"`
+    Resumable   bool        `json:"resumable,omitempty"` // Uploaded in chunks through /uploads (tus)
+    Offset      int64       `json:"offset,omitempty"`    // Bytes of a resumable upload received so far
+    ReleaseAt   time.Time   `json:"releaseAt,omitempty"` // Deleted then unless a task uses it
+    TaskIDs     []string    `json:"taskIds,omitempty"`   // Tasks using the input; it is released once they are finished
+    Owner       string      `json:"-"`                   // Uploader the space is accounted to
+    patching    bool        // An AppendUpload is writing to it; guarded by inputMu
+}
+
+// held is the space an input takes from its owner's quota: the reserved size
+// until the upload is done, then the actual size.
+func (in *Input) held() int64 {
+    if in.Status == InputUploaded {
+        return in.Size
+    }
+    return in.MaxSize
+}
+
+// InputRef returns the inputMedia value referring to an uploaded input.
+func InputRef(id string) string {
+    return InputRefPrefix + id
+}
+
+// InputRefID extracts the input ID from an inputMedia value.
+func InputRefID(inputMedia string) (string, bool) {
+    if !strings.HasPrefix(inputMedia, InputRefPrefix) {
+        return "", false
+    }
+    return strings.TrimPrefix(inputMedia, InputRefPrefix), true
+}
+
+// ReserveInput reserves an input slot of at most size bytes (MAX_INPUT_SIZE if
+// 0) for owner. The returned URL is a presigned upload URL of the storage
+// backend, or "" if the upload goes through the API.
+func (m *Manager) ReserveInput(owner string, size int64, contentType string) (*Input, string, error) {
+    if size < 0 || size > m.cfg.MaxInputSize {
+        return nil, "", fmt.Errorf("size must be between 0 and %d bytes", m.cfg.MaxInputSize)
+    }
+    if size == 0 {
+        size = m.cfg.MaxInputSize
+    }
+
+    m.inputMu.Lock()
+    defer m.inputMu.Unlock()
+    in, err := m.newInputLocked(owner, size, contentType, m.cfg.UploadURLTTL)
+    if err != nil {
+        return nil, "", err
+    }
+    in.ReleaseAt = in.CreatedAt.Add(m.cfg.InputTTL)
+    uploadURL, err := m.store.UploadURL(in.ID, in.ExpiresAt)
+    if err != nil {
+        return nil, "", err
+    }
+    m.inputs.Store(in.ID, in)
+    return in, uploadURL, nil
+}
+
+// newInputLocked checks owner's quota and returns a reservation of size bytes
+// whose upload window lasts window. The caller holds inputMu until it stores
+// the input, so concurrent reservations can't exceed the quota together.
+func (m *Manager) newInputLocked(owner string, size int64, contentType string, window time.Duration) (*Input, error) {
+    if used := m.InputUsage(owner); m.cfg.UploadQuota > 0 && used+size > m.cfg.UploadQuota {
+        return nil, fmt.Errorf("%w: %d of %d bytes held, %d more requested", ErrQuotaExceeded, used, m.cfg.UploadQuota, size)
+    }
+    now := time.Now()
+    return &Input{
+        ID:          fmt.Sprintf("in_%s_%d", shortuuid.New(), now.Unix()),
+        Status:      InputReserved,
+        MaxSize:     size,
+        ContentType: contentType,
+        CreatedAt:   now,
+        ExpiresAt:   now.Add(window),
+        Owner:       owner,
+    }, nil
+}
+
+func (m *Manager) GetInput(id string) (*Input, bool) {
+    if val, ok := m.inputs.Load(id); ok {
+        return val.(*Input), true
+    }
+    return nil, false
+}
+
+// localInputPath returns the file of an uploaded input if the input storage
+// is local, so it can be read without fetching anything.
+func (m *Manager) localInputPath(inputMedia string) (string, bool) {
+    id, ok := InputRefID(inputMedia)
+    if !ok {
+        return "", false
+    }
+    local, ok := m.store.(*storage.Local)
+    if !ok {
+        return "", false
+    }
+    in, ok := m.GetInput(id)
+    if !ok {
+        return "", false
+    }
+    m.inputMu.Lock()
+    uploaded := in.Status == InputUploaded
+    m.inputMu.Unlock()
+    return local.Path(in.ID), uploaded
+}
+
+// UploadInput stores the content of a reserved input received through the API.
+func (m *Manager) UploadInput(ctx context.Context, id string, r io.Reader) (*Input, error) {
+    in, ok := m.GetInput(id)
+    if !ok {
+        return nil, ErrInputNotFound
+    }
+    if in.Status != InputReserved {
+        return nil, ErrInputConflict
+    }
+    if time.Now().After(in.ExpiresAt) {
+        return nil, ErrInputExpired
+    }
+    n, err := m.store.Put(ctx, in.ID, io.LimitReader(r, in.MaxSize+1))
+    if err != nil {
+        return nil, fmt.Errorf("could not store input: %w", err)
+    }
+    if n > in.MaxSize {
+        m.store.Delete(ctx, in.ID)
+        return nil, ErrInputTooLarge
+    }
+    in.Size, in.Status, in.UploadedAt = n, InputUploaded, time.Now()
+    return in, nil
+}
+
+// ResolveInput checks that an input is ready to be used by a task. Inputs
+// uploaded directly to the storage backend are only noticed here.
+func (m *Manager) ResolveInput(ctx context.Context, id string) (*Input, error) {
+    in, ok := m.GetInput(id)
+    if !ok {
+        return nil, ErrInputNotFound
+    }
+    if in.Status == InputUploaded {
+        return in, nil
+    }
+    if in.Resumable {
+        return nil, ErrInputNotUploaded // Completed by AppendUpload only
+    }
+    size, err := m.store.Stat(ctx, in.ID)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil, ErrInputNotUploaded
+    }
+    if err != nil {
+        return nil, err
+    }
+    if size > in.MaxSize {
+        m.store.Delete(ctx, in.ID)
+        return nil, ErrInputTooLarge
+    }
+    in.Size, in.Status, in.UploadedAt = size, InputUploaded, time.Now()
+    return in, nil
+}
+
+// InputUsage returns the bytes of inputs held by an uploader.
+func (m *Manager) InputUsage(owner string) int64 {
+    var used int64
+    m.inputs.Range(func(key, value interface{}) bool {
+        if in := value.(*Input); in.Owner == owner {
+            used += in.held()
+        }
+        return true
+    })
+    return used
+}
+
+// attachInput records that a task uses an input, which keeps it from expiring.
+func (m *Manager) attachInput(id, taskID string) {
+    if in, ok := m.GetInput(id); ok {
+        m.inputMu.Lock()
+        in.TaskIDs = append(in.TaskIDs, taskID)
+        in.ReleaseAt = time.Time{}
+        m.inputMu.Unlock()
+    }
+}
+
+// gcInputs deletes inputs that are no longer needed: those never used by a
+// task within INPUT_TTL, and those whose tasks are all finished. Their space
+// is given back to the uploader's quota.
+func (m *Manager) gcInputs(ctx context.Context, now time.Time) {
+    m.inputs.Range(func(key, value interface{}) bool {
+        m.releaseInput(ctx, value.(*Input), now)
+        return true
+    })
+}
+
+// releaseInput deletes an input if it is no longer needed, see gcInputs.
+// Deleted tasks count as finished.
+func (m *Manager) releaseInput(ctx context.Context, in *Input, now time.Time) {
+    m.inputMu.Lock()
+    release := false
+    switch {
+    case in.patching:
+        // Checked again on the next pass
+    case len(in.TaskIDs) == 0:
+        release = now.After(in.ReleaseAt)
+    default:
+        release = true
+        for _, taskID := range in.TaskIDs {
+            if t, ok := m.Get(taskID); ok && !t.status().IsTerminal() {
+                release = false
+                break
+            }
+        }
+    }
+    m.inputMu.Unlock()
+    if !release {
+        return
+    }
+
+    if err := m.store.Delete(ctx, in.ID); err != nil {
+        slog.Error("Could not delete input", "input_id", in.ID, "error", err)
+        return
+    }
+    if in.Resumable {
+        os.Remove(m.uploadPartPath(in.ID))
+    }
+    m.inputs.Delete(in.ID)
+    slog.Info("Released input", "input_id", in.ID, "bytes", in.held(), "owner", in.Owner)
+}
+
+// inputGCLoop periodically releases unneeded inputs.
+func (m *Manager) inputGCLoop(ctx context.Context) {
+    interval := m.cfg.InputTTL / 4
+    if interval <= 0 || interval > time.Minute {
+        interval = time.Minute
+    }
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case now := <-ticker.C:
+            m.gcInputs(ctx, now)
+        }
+    }
+}
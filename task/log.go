@@ -0,0 +1,216 @@
+package task
+
+import (
+    "bufio"
+    "bytes"
+    "errors"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "ffwebapi/config"
+)
+
+// maxLogLineSize is the longest line a log may have to be read.
+const maxLogLineSize = 1 << 20
+
+// ErrLogNotFound is returned for tasks without a stored log: tasks that did
+// not finish an attempt yet, and tasks whose log expired or was deleted.
+var ErrLogNotFound = errors.New("task log not found")
+
+// LogPage is a range of lines of a task's log.
+type LogPage struct {
+    Lines      []string `json:"lines"`
+    Offset     int      `json:"offset"`               // Index of the first line
+    NextOffset int      `json:"nextOffset,omitempty"` // Offset of the next page; 0 on the last page
+    TotalLines int      `json:"totalLines"`
+}
+
+// LogPath is where the log of a task's current or last attempt is kept.
+// Runners may stream the output there while the command runs.
+func LogPath(cfg *config.Config, taskID string) string {
+    return filepath.Join(FilesDir(cfg, taskID), "ffmpeg.log")
+}
+
+// PipeLogArtifact is the artifact holding the log of the second stage of a
+// piped task.
+const PipeLogArtifact = "pipe_log"
+
+// PipeLogPath is where the log of the second stage of a piped task is kept.
+func PipeLogPath(cfg *config.Config, taskID string) string {
+    return filepath.Join(FilesDir(cfg, taskID), "pipe.log")
+}
+
+// logPath returns the log of a stage of the task: 1 for its command, 2 for
+// the second stage of a piped task.
+func (m *Manager) logPath(t *Task, stage int) string {
+    if stage == 2 {
+        return PipeLogPath(m.cfg, t.ID)
+    }
+    return LogPath(m.cfg, t.ID)
+}
+
+// LogTail returns the end of a log, at most size bytes (all of it if size is
+// 0), starting at a line.
+func LogTail(output string, size int64) string {
+    if size <= 0 || int64(len(output)) <= size {
+        return output
+    }
+    tail := output[int64(len(output))-size:]
+    if i := strings.IndexAny(tail, "\r\n"); i >= 0 {
+        tail = tail[i+1:]
+    }
+    return tail
+}
+
+// clearLog removes the logs of an earlier attempt before the next one runs.
+func (m *Manager) clearLog(t *Task) {
+    if m.cfg.TempDir == "" {
+        return
+    }
+    for _, path := range []string{LogPath(m.cfg, t.ID), PipeLogPath(m.cfg, t.ID)} {
+        if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+            t.logger().Warn("Could not remove the log of the previous attempt", "error", err)
+        }
+    }
+    t.Update(func() {
+        var kept []*Artifact
+        for _, a := range t.Artifacts {
+            if a.Name != ArtifactLog && a.Name != PipeLogArtifact {
+                kept = append(kept, a)
+            }
+        }
+        t.Artifacts = kept
+    })
+}
+
+// storeLog keeps the tail of an attempt's output in memory and makes its log
+// an artifact. The log is written from output unless the runner streamed it
+// to LogPath already.
+func (m *Manager) storeLog(t *Task, output string) {
+    t.Update(func() { t.FFMpegOutput = LogTail(output, m.cfg.LogBufferSize) })
+    if m.cfg.TempDir == "" {
+        return
+    }
+    logPath := LogPath(m.cfg, t.ID)
+    if _, err := os.Stat(logPath); err != nil {
+        if output == "" {
+            return
+        }
+        if err := writeFile(logPath, []byte(output)); err != nil {
+            t.logger().Error("Could not store log artifact", "error", err)
+            return
+        }
+    }
+    t.Update(func() { t.AddArtifact(ArtifactLog, ArtifactLog, logPath) })
+    m.adoptPipeLog(t)
+}
+
+// adoptPipeLog makes the log the runner wrote for the second stage of a
+// piped task an artifact.
+func (m *Manager) adoptPipeLog(t *Task) {
+    if t.Pipe == nil {
+        return
+    }
+    pipeLogPath := PipeLogPath(m.cfg, t.ID)
+    if _, err := os.Stat(pipeLogPath); err == nil {
+        t.Update(func() { t.AddArtifact(PipeLogArtifact, ArtifactLog, pipeLogPath) })
+    }
+}
+
+// adoptLog makes the log an interrupted attempt left behind an artifact, so
+// that it expires with the task rather than staying in TempDir for good.
+func (m *Manager) adoptLog(t *Task) {
+    if m.cfg.TempDir == "" {
+        return
+    }
+    logPath := LogPath(m.cfg, t.ID)
+    if _, err := os.Stat(logPath); err == nil {
+        t.Update(func() { t.AddArtifact(ArtifactLog, ArtifactLog, logPath) })
+    }
+    m.adoptPipeLog(t)
+}
+
+// ReadLog returns up to limit lines of the log of a stage of the task (see
+// logPath), starting at line offset; while the task runs, of the log written
+// so far. ffmpeg's progress updates, separated by carriage returns, count as
+// lines of their own.
+func (m *Manager) ReadLog(t *Task, stage, offset, limit int) (*LogPage, error) {
+    page := &LogPage{Lines: []string{}, Offset: offset}
+    err := m.scanLog(t, stage, func(i int, line string) {
+        if i >= offset && i < offset+limit {
+            page.Lines = append(page.Lines, line)
+        }
+    }, &page.TotalLines)
+    if err != nil {
+        return nil, err
+    }
+    if offset+limit < page.TotalLines {
+        page.NextOffset = offset + limit
+    }
+    return page, nil
+}
+
+// TailLog returns the last n lines of the log of a stage of the task.
+func (m *Manager) TailLog(t *Task, stage, n int) (*LogPage, error) {
+    ring := make([]string, n)
+    page := &LogPage{}
+    err := m.scanLog(t, stage, func(i int, line string) {
+        ring[i%n] = line
+    }, &page.TotalLines)
+    if err != nil {
+        return nil, err
+    }
+    if page.TotalLines > n {
+        page.Offset = page.TotalLines - n
+    }
+    page.Lines = make([]string, 0, page.TotalLines-page.Offset)
+    for i := page.Offset; i < page.TotalLines; i++ {
+        page.Lines = append(page.Lines, ring[i%n])
+    }
+    return page, nil
+}
+
+// scanLog calls fn for every line of a stage's log, reading it from disk
+// rather than loading it whole, and counts the lines into total.
+func (m *Manager) scanLog(t *Task, stage int, fn func(i int, line string), total *int) error {
+    if m.cfg.TempDir == "" {
+        return ErrLogNotFound
+    }
+    f, err := os.Open(m.logPath(t, stage))
+    if os.IsNotExist(err) {
+        return ErrLogNotFound
+    }
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    scanner := bufio.NewScanner(f)
+    scanner.Buffer(make([]byte, 64<<10), maxLogLineSize)
+    scanner.Split(ScanLogLines)
+    for ; scanner.Scan(); *total++ {
+        fn(*total, scanner.Text())
+    }
+    return scanner.Err()
+}
+
+// ScanLogLines is a bufio.SplitFunc for logs that splits at "\n", "\r\n"
+// and lone "\r"s.
+func ScanLogLines(data []byte, atEOF bool) (int, []byte, error) {
+    if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+        if data[i] == '\r' {
+            if i+1 == len(data) && !atEOF {
+                return 0, nil, nil // Might be followed by "\n"
+            }
+            if i+1 < len(data) && data[i+1] == '\n' {
+                return i + 2, data[:i], nil
+            }
+        }
+        return i + 1, data[:i], nil
+    }
+    if atEOF && len(data) > 0 {
+        return len(data), data, nil
+    }
+    return 0, nil, nil
+}
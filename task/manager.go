@@ -2,147 +2,496 @@ package task
 
 import (
     "context"
+    "errors"
     "fmt"
-    "log"
+    "log/slog"
+    "math"
+    "net/http"
     "os"
+    "path"
     "path/filepath"
     "sync"
     "time"
 
     "ffwebapi/config"
+    "ffwebapi/logging"
+    "ffwebapi/netguard"
+    "ffwebapi/storage"
+    "ffwebapi/tracing"
+    "ffwebapi/utils"
     // "ffwebapi/ffmpeg"
     "github.com/lithammer/shortuuid/v4"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/trace"
 )
 
 type FFmpegRunner interface {
 	Run(ctx context.Context, t *Task) (logOutput string, err error)
 }
 
+// MediaProber is optionally implemented by runners that can inspect inputs
+// before running them (e.g. via ffprobe).
+type MediaProber interface {
+    Probe(ctx context.Context, inputMedia string) (time.Duration, error)
+}
+
 type Manager struct {
-    cfg            *config.Config
-    tasks          sync.Map // More scalable than a mutex-protected map
-    taskQueue      chan *Task
-    concurrencySem chan struct{}
-    runner         FFmpegRunner
+    cfg        *config.Config
+    tasks      sync.Map               // More scalable than a mutex-protected map
+    pipelines  sync.Map
+    files      sync.Map               // Artifacts by path below the temp dir, for the files endpoint
+    inputs     sync.Map               // Reserved and uploaded inputs by ID
+    inputMu    sync.Mutex             // Guards quota checks and input task lists
+    cancelMu   sync.Mutex             // Serializes cancellations, so a group is canceled in one go
+    depsMu     sync.Mutex             // Serializes releasing waiting tasks with placing new ones that depend on others
+    idemKeys   *idempotencyKeys       // Tasks by owner and Idempotency-Key
+    store      storage.Backend
+    queues     map[string]*namedQueue // By name; fixed after NewManager
+    fastSem    chan struct{}          // Slots of the low-latency pool for sync calls
+    runner     FFmpegRunner
+    callbacks  *callbackTracker
+    notifyHTTP *http.Client           // Posts chat notifications
+    events     *eventQueue            // Nil unless an EventPublisher is set
+    stats      *statsTracker
+    usage      *usageTracker
+    billing    *billingLedger
+    transforms *transformCache
+    dedupe     *dedupeIndex           // Nil unless DEDUPE_TASKS is set
+    taskStore  *taskStore
+    history    *historyExporter       // Nil unless HISTORY_EXPORT is set
+    limiter    *concurrencyLimiter    // Nil unless ADAPTIVE_CONCURRENCY is set
+    wheel      *timerWheel            // Tasks scheduled for later
+    schedules  *scheduleStore         // Recurring tasks
 }
 
 func NewManager(cfg *config.Config, runner FFmpegRunner) (*Manager, error) {
+    store, err := storage.New(cfg)
+    if err != nil {
+        return nil, err
+    }
+    stats, err := newStatsTracker(cfg)
+    if err != nil {
+        return nil, err
+    }
+    usage, err := newUsageTracker(cfg)
+    if err != nil {
+        return nil, err
+    }
+    billing, err := newBillingLedger(cfg)
+    if err != nil {
+        return nil, err
+    }
+    queues, err := newQueues(cfg)
+    if err != nil {
+        return nil, err
+    }
+    history, err := newHistoryExporter(cfg)
+    if err != nil {
+        return nil, err
+    }
+    if _, err := resolveOutputStore(cfg, ""); err != nil {
+        return nil, fmt.Errorf("OUTPUT_STORE: %w", err)
+    }
+    if err := validateNotifyConfig(cfg); err != nil {
+        return nil, err
+    }
+    for _, label := range cfg.NodeLabels {
+        if err := ValidateLabel(label); err != nil {
+            return nil, fmt.Errorf("NODE_LABELS: %w", err)
+        }
+    }
+    schedules, err := newScheduleStore(cfg, time.Now())
+    if err != nil {
+        return nil, err
+    }
     m := &Manager{
-        cfg:            cfg,
-        tasks:          sync.Map{},
-        taskQueue:      make(chan *Task, 100), // Buffered queue
-        concurrencySem: make(chan struct{}, cfg.MaxConcurrency),
-        runner:         runner,
+        cfg:        cfg,
+        tasks:      sync.Map{},
+        queues:     queues,
+        fastSem:    make(chan struct{}, cfg.SyncFastConcurrency),
+        runner:     runner,
+        callbacks:  newCallbackTracker(cfg),
+        notifyHTTP: netguard.InputPolicy(cfg).Client(cfg.CallbackTimeout),
+        stats:      stats,
+        usage:      usage,
+        billing:    billing,
+        transforms: newTransformCache(),
+        taskStore:  newTaskStore(cfg),
+        store:      store,
+        history:    history,
+        limiter:    newConcurrencyLimiter(cfg, queues),
+        wheel:      newTimerWheel(),
+        schedules:  schedules,
+        idemKeys:   newIdempotencyKeys(cfg),
+    }
+    if cfg.DedupeTasks {
+        m.dedupe = newDedupeIndex()
+    }
+    if cfg.StandbyOf != "" {
+        // The tasks are restored from the primary's copy when taking over.
+        if cfg.DataDir == "" {
+            return nil, fmt.Errorf("STANDBY_OF needs DATA_DIR to keep the primary's tasks")
+        }
+        for _, q := range queues {
+            q.standby.Store(true)
+        }
+        return m, nil
+    }
+    if err := m.restoreTasks(); err != nil {
+        return nil, err
     }
     return m, nil
 }
 
 func (m *Manager) Start(ctx context.Context) {
-    log.Println("Task manager started. Concurrency limit:", m.cfg.MaxConcurrency)
+    slog.Info("Task manager started", "queues", m.Queues())
+    for _, q := range m.queues {
+        slog.Info("Queue started", "queue", q.name, "concurrency", cap(q.slots))
+        go m.workerLoop(ctx, q)
+    }
     go m.cleanupLoop(ctx)
-    go m.workerLoop(ctx)
+    go m.inputGCLoop(ctx)
+    go m.persistLoop(ctx)
+    go m.taskStoreLoop(ctx)
+    go m.scheduleLoop(ctx)
+    go m.cronLoop(ctx)
+    if m.limiter != nil {
+        if sampler, ok := m.runner.(LoadSampler); ok {
+            go m.adaptiveLoop(ctx, sampler)
+        } else {
+            slog.Warn("The runner cannot measure the host's load; ADAPTIVE_CONCURRENCY runs every queue at its concurrency")
+            m.limiter.limit = m.limiter.max
+        }
+    }
 }
 
-// workerLoop pulls tasks from the queue and processes them
-func (m *Manager) workerLoop(ctx context.Context) {
+// workerLoop pulls tasks from one queue and processes them
+func (m *Manager) workerLoop(ctx context.Context, q *namedQueue) {
     for {
+        // Wait for a free processing slot before picking a task, so the
+        // highest priority task at dispatch time is the one that runs.
         select {
         case <-ctx.Done():
-            log.Println("Worker loop shutting down.")
+            slog.Info("Worker loop shutting down", "queue", q.name)
             return
-        case task := <-m.taskQueue:
-            // Wait for a free processing slot
-            m.concurrencySem <- struct{}{}
-            go func(t *Task) {
-                defer func() { <-m.concurrencySem }() // Release slot
-                m.processTask(ctx, t)
-            }(task)
+        case q.slots <- struct{}{}:
         }
+
+        task, ok := q.tasks.pop(ctx, m.claim)
+        if !ok {
+            <-q.slots
+            slog.Info("Worker loop shutting down", "queue", q.name)
+            return
+        }
+        q.running.Add(1)
+        go func(t *Task) {
+            defer func() { <-q.slots }() // Release slot
+            defer q.running.Add(-1)
+            // Running tasks outlive ctx; Shutdown lets them finish or interrupts them.
+            m.processTask(context.WithoutCancel(ctx), t, m.cfg.FFTimeout)
+            m.limiter.release()
+            m.releaseOwner(t)
+        }(task)
     }
 }
 
 // processTask handles the execution of a single task
-func (m *Manager) processTask(parentCtx context.Context, t *Task) {
+func (m *Manager) processTask(parentCtx context.Context, t *Task, timeout time.Duration) {
     // Create a new context for this specific task for cancellation and timeout
-    taskCtx, cancel := context.WithTimeout(parentCtx, m.cfg.FFTimeout)
-    t.cancelFunc = cancel // Store cancel func so it can be called externally
+    taskCtx, cancel := context.WithTimeout(parentCtx, timeout)
     defer cancel()
 
-    // Check if task was canceled while in queue
-    if t.Status == StatusCanceled {
-        log.Printf("Task %s was canceled before processing.", t.ID)
+    // Check if task was canceled while in queue; otherwise store the cancel
+    // func so it can be called externally.
+    if !t.start(cancel) {
+        t.logger().Info("Task was canceled before processing")
         return
     }
-
-    log.Printf("Processing task %s", t.ID)
-    t.Status = StatusProcessing
-    t.StartedAt = time.Now()
+    t.endQueueWait()
+    t.logger().Info("Processing task", "attempt", t.Attempt)
+    if t.Attempt == 1 && !t.queuedAt.IsZero() {
+        t.queueWait = t.StartedAt.Sub(t.queuedAt) // Fast lane tasks never queue
+    }
     m.tasks.Store(t.ID, t)
+    m.events.publish(EventStarted, t)
 
-    outputLog, err := m.runner.Run(taskCtx, t)
-    t.FFMpegOutput = outputLog
+    // Runner spans (input download, ffmpeg, ...) nest under this attempt.
+    runCtx, span := tracing.Tracer().Start(trace.ContextWithSpan(logging.WithLogger(taskCtx, t.logger()), t.span), "task.attempt",
+        trace.WithAttributes(attribute.Int("task.attempt", t.Attempt)))
+    m.clearLog(t)
+    t.dedupe = m.dedupe
+    outputLog, err := m.runner.Run(runCtx, t)
+    t.keepMetrics()
+    if err == nil && t.OutputStore != "" {
+        err = m.storeOutputs(runCtx, t)
+    }
+    tracing.End(span, err)
+    m.storeLog(t, outputLog)
+    if !errors.Is(err, ErrInsufficientResources) {
+        t.resourceWaits, t.resourceWaitSince = 0, time.Time{}
+    }
+    var coded *CodedError
+    if errors.As(err, &coded) {
+        t.Update(func() { t.ErrorCode = coded.Code })
+    }
 
     if err != nil {
-        if err == context.Canceled || err == context.DeadlineExceeded {
-            log.Printf("Task %s canceled or timed out.", t.ID)
-            t.Status = StatusCanceled
-            t.Error = "Task was canceled or timed out"
+        // The runner usually reports a killed process rather than the context
+        // error itself, so check the task context too.
+        if t.isInterrupted() {
+            t.logger().Warn("Task interrupted by shutdown")
+            t.finish(StatusInterrupted, "Interrupted by server shutdown", nil)
+        } else if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || taskCtx.Err() != nil {
+            t.logger().Info("Task canceled or timed out")
+            t.finish(StatusCanceled, "Task was canceled or timed out", nil)
+        } else if errors.Is(err, ErrInsufficientResources) && m.scheduleResourceWait(t, err) {
+            return
+        } else if t.Attempt <= t.MaxRetries && coded == nil {
+            m.scheduleRetry(t, err)
+            return
         } else {
-            log.Printf("Task %s failed: %v", t.ID, err)
-            t.Status = StatusFailed
-            t.Error = err.Error()
+            t.logger().Error("Task failed", "error", err)
+            t.finish(StatusFailed, err.Error(), err)
         }
     } else {
-        log.Printf("Task %s completed successfully.", t.ID)
-        t.Status = StatusCompleted
+        status := StatusCompleted
+        if t.QCReport != nil && !t.QCReport.Passed {
+            status = StatusCompletedWithWarnings
+        }
+        t.finish(status, "", nil)
+        t.logger().Info("Task completed", "status", status, "duration", time.Since(t.StartedAt))
+        m.dedupe.record(t)
+    }
+    m.finalizeArtifacts(t)
+    m.tasks.Store(t.ID, t)
+    t.markDone()
+    m.callbacks.notify(t)
+    m.notify(t)
+    m.events.publish(eventOf(t.Status), t)
+    m.stats.record(t)
+    m.billing.record(t)
+    if t.Status != StatusInterrupted {
+        m.resolveDependents(t) // Dependents of interrupted tasks wait for the requeue
+    }
+}
+
+// claim decides whether a queued task may start: the concurrency limit and
+// its owner's quota must allow it.
+func (m *Manager) claim(t *Task) bool {
+    if !m.limiter.tryAcquire() {
+        return false
+    }
+    if !m.usage.tryClaim(t) {
+        m.limiter.release()
+        return false
+    }
+    return true
+}
+
+// retryDelay returns the wait after the given attempt: backoff, doubled for
+// every attempt before it, but at most limit (if set) and never overflowing.
+func retryDelay(backoff time.Duration, attempt int, limit time.Duration) time.Duration {
+    if limit <= 0 {
+        limit = math.MaxInt64
+    }
+    delay := min(backoff, limit)
+    for i := 1; i < attempt && delay < limit; i++ {
+        if delay > limit/2 {
+            return limit
+        }
+        delay *= 2
+    }
+    return delay
+}
+
+// releaseOwner frees the owner's running slot after an attempt. Tasks of the
+// owner held back by its MaxRunning quota may be dispatched now.
+func (m *Manager) releaseOwner(t *Task) {
+    m.usage.release(t, time.Now())
+    for _, q := range m.queues {
+        q.tasks.wake()
+    }
+}
+
+// scheduleRetry puts a failed task back into the queue after an exponential
+// backoff (RetryBackoff, doubled for every attempt already made).
+func (m *Manager) scheduleRetry(t *Task, err error) {
+    delay := retryDelay(t.RetryBackoff, t.Attempt, m.cfg.MaxRetryBackoff)
+    t.logger().Warn("Task attempt failed, retrying", "attempt", t.Attempt, "max_attempts", t.MaxRetries+1, "delay", delay, "error", err)
+
+    t.hold(StatusQueued, err, true)
+    m.tasks.Store(t.ID, t)
+
+    time.AfterFunc(delay, func() {
+        // The task may have been canceled while waiting out the backoff.
+        if t.status() != StatusQueued {
+            return
+        }
+        m.enqueue(t)
+    })
+}
+
+// ErrInsufficientResources is returned by runners that turn a task away
+// because the host is short of CPU, memory or disk space. Such a task waits
+// for RESOURCE_MAX_WAIT before it fails.
+var ErrInsufficientResources = errors.New("insufficient system resources")
+
+// maxResourceWaitDelay caps the backoff between resource checks.
+const maxResourceWaitDelay = time.Minute
+
+// scheduleResourceWait re-enqueues a task the runner turned away for lack of
+// resources, after a backoff doubling from RESOURCE_WAIT_BACKOFF. The
+// attempt does not count towards MaxRetries. Reports false once the task has
+// waited RESOURCE_MAX_WAIT, so it fails.
+func (m *Manager) scheduleResourceWait(t *Task, err error) bool {
+    now := time.Now()
+    if t.resourceWaits == 0 {
+        t.resourceWaitSince = now
+    }
+    waited := now.Sub(t.resourceWaitSince)
+    if waited >= m.cfg.ResourceMaxWait {
+        t.resourceWaits, t.resourceWaitSince = 0, time.Time{}
+        return false
     }
-    t.CompletedAt = time.Now()
+    delay := maxResourceWaitDelay
+    if t.resourceWaits < 16 {
+        delay = min(m.cfg.ResourceWaitBackoff<<t.resourceWaits, delay)
+    }
+    delay = min(delay, m.cfg.ResourceMaxWait-waited)
+    t.resourceWaits++
+    t.logger().Warn("Insufficient resources, waiting", "waits", t.resourceWaits, "waited", waited, "delay", delay, "error", err)
+
+    t.hold(StatusWaitingResources, err, false)
     m.tasks.Store(t.ID, t)
+
+    time.AfterFunc(delay, func() {
+        // The task may have been canceled while waiting.
+        if !t.transition(StatusWaitingResources, StatusQueued) {
+            return
+        }
+        m.enqueue(t)
+    })
+    return true
 }
 
-// cleanupLoop periodically removes old output files
+// cleanupLoop periodically removes artifacts whose retention is over, and
+// evicts tasks past TASK_RETENTION.
 func (m *Manager) cleanupLoop(ctx context.Context) {
-    ticker := time.NewTicker(m.cfg.OutputLocalLifetime / 4) // Check 4 times per lifetime
+    // Check 4 times per lifetime, and at least every minute for tasks with a
+    // shorter outputTtl.
+    interval := m.cfg.OutputLocalLifetime / 4
+    if interval <= 0 || interval > time.Minute {
+        interval = time.Minute
+    }
+    ticker := time.NewTicker(interval)
     defer ticker.Stop()
 
     for {
         select {
         case <-ctx.Done():
-            log.Println("Cleanup loop shutting down.")
+            slog.Info("Cleanup loop shutting down")
             return
         case <-ticker.C:
+            now := time.Now()
             m.tasks.Range(func(key, value interface{}) bool {
-                task := value.(*Task)
-                if task.Status == StatusCompleted && time.Since(task.CompletedAt) > m.cfg.OutputLocalLifetime {
-                    if task.OutputPath != "" {
-                        log.Printf("Cleaning up old output file: %s", task.OutputPath)
-                        os.Remove(task.OutputPath)
-                        // We can also remove the task from the map if desired
-                        // m.tasks.Delete(key)
-                    }
+                if task := value.(*Task); task.status().IsTerminal() {
+                    m.expireArtifacts(task, now)
                 }
                 return true
             })
+            m.pruneTransforms(now)
+            m.evictTasks(ctx, now)
         }
     }
 }
 
+// SubmitOptions carries optional per-task settings for SubmitWithOptions.
+type SubmitOptions struct {
+    Priority         Priority
+    Tool             string             // Allow-listed tool to run instead of ffmpeg
+    ResourceClass    string             // Resource class whose cgroup limits apply; the defaults if empty
+    Requires         []string           // Node labels the task needs, e.g. "gpu" or "region:eu"
+    MaxRetries       int                // Extra attempts after a non-cancellation failure
+    RetryBackoff     time.Duration      // Delay before the first retry, doubled for each further one
+    RunAt            time.Time          // Hold the task back until then; queued right away if zero or past
+    ScheduleID       string             // Recurring schedule the task is a run of
+    Preset           string             // Preset the command was built from, for billing
+    Billing          bool               // Record a billing line item once the task finishes
+    OutputTTL        time.Duration      // How long the task's files are kept; the configured retention if 0
+    Outputs          []string           // Extensions of a multi-output task; replaces outputExt
+    ExtraInputs      []string           // Inputs after the first, of server-built commands only
+    OutputMode       string             // OutputModeFile (default) or OutputModeDirectory
+    CallbackURL      string             // Receives the task as JSON once it is terminal
+    Notify           *Notify            // Chat and email notifications; the NOTIFY_* settings if nil
+    OutputUpload     *OutputUpload      // Caller storage the output is uploaded to
+    OutputStore      string             // Registered output store the files are put into; OUTPUT_STORE if empty
+    Pipe             *Pipe              // Second stage reading the command's stdout
+    MaxOutputSize    int64              // Bytes the outputs may grow to; MAX_OUTPUT_SIZE if 0
+    OutputEntry      string             // Main file of a directory output, e.g. master.m3u8
+    Renditions       []RenditionProgress
+    ExtraFiles       map[string][]byte  // Sidecar files of a directory output, e.g. a WebVTT cue file
+    StreamLabels     []StreamLabel      // Language, title and disposition overrides of output streams
+    SkipStreamLabels bool               // Don't carry the input's stream labels over explicitly
+    OutputName       string             // File name offered to downloaders
+    BatchID          string
+    DependsOn        []string           // Tasks that must complete before this one starts; it is skipped if one does not
+    Subtitles        string             // "srt" or "vtt" to transcribe the audio
+    SubtitleLanguage string
+    SubtitlesOnly    bool
+    ArtifactKind     string             // Kind of the output artifacts, e.g. ArtifactThumbnail
+    Concat           bool               // Join the input and ExtraInputs, in order
+    Queue            string             // Named queue to run in; DefaultQueue if empty
+    QC               string             // QCModeWarn or QCModeFail to verify the output with ffprobe
+    Target           *ConformanceTarget // Conformance target QC checks the output against
+    InlineResult     bool               // Embed a small primary output in the task JSON
+    MaxRunning       int                // Tasks of the same owner processing at once; 0 = unlimited
+    Owner            string             // ID of the submitting API key
+    RequestID        string             // Request that submitted the task, for log correlation
+    TraceParent      trace.SpanContext  // Span the task's trace continues, e.g. of the submit request
+    IdempotencyKey   string             // Set by SubmitIdempotent
+    RetryOf          string             // Task the new one runs again; set by Retry
+    idempotencyHash  string
+}
+
 func (m *Manager) Submit(command, inputMedia, outputExt string) (*Task, error) {
-    t := &Task{
-        ID:         fmt.Sprintf("%s_%d", shortuuid.New(), time.Now().Unix()),
-        Status:     StatusQueued,
-        Command:    command,
-        InputMedia: inputMedia,
-        OutputExt:  outputExt,
-        CreatedAt:  time.Now(),
+    return m.SubmitWithOptions(command, inputMedia, outputExt, SubmitOptions{})
+}
+
+func (m *Manager) SubmitWithOptions(command, inputMedia, outputExt string, opts SubmitOptions) (*Task, error) {
+    q, err := m.queueFor(opts.Queue)
+    if err != nil {
+        return nil, err
+    }
+    if err := q.admit(); err != nil {
+        return nil, err
+    }
+    t, err := m.newTask(command, inputMedia, outputExt, opts)
+    if err != nil {
+        return nil, err
     }
 
-    m.tasks.Store(t.ID, t)
-    m.taskQueue <- t
-    log.Printf("Task %s submitted to queue.", t.ID)
+    if len(t.DependsOn) > 0 {
+        return m.submitDependent(t)
+    }
+    if !t.ScheduledFor.IsZero() {
+        m.schedule(t)
+        m.tasks.Store(t.ID, t)
+        m.events.publish(EventCreated, t)
+        t.logger().Info("Task scheduled", "queue", t.Queue, "scheduled_for", t.ScheduledFor)
+        return t, nil
+    }
+    m.submitQueued(t)
     return t, nil
 }
 
+// submitQueued stores a new task and queues it.
+func (m *Manager) submitQueued(t *Task) {
+    m.tasks.Store(t.ID, t)
+    m.events.publish(EventCreated, t)
+    m.enqueue(t)
+    t.logger().Info("Task submitted to queue", "queue", t.Queue, "priority", t.Priority)
+}
+
 func (m *Manager) Get(taskID string) (*Task, bool) {
     if val, ok := m.tasks.Load(taskID); ok {
         return val.(*Task), true
@@ -150,6 +499,223 @@ func (m *Manager) Get(taskID string) (*Task, bool) {
     return nil, false
 }
 
+func (m *Manager) newTask(command, inputMedia, outputExt string, opts SubmitOptions) (*Task, error) {
+    priority, err := ParsePriority(string(opts.Priority))
+    if err != nil {
+        return nil, err
+    }
+    if opts.MaxRetries < 0 || opts.MaxRetries > m.cfg.MaxRetries {
+        return nil, fmt.Errorf("maxRetries must be between 0 and %d", m.cfg.MaxRetries)
+    }
+    if opts.RetryBackoff < 0 {
+        return nil, fmt.Errorf("retryBackoff must not be negative")
+    }
+    if m.cfg.MaxRetryBackoff > 0 && opts.RetryBackoff > m.cfg.MaxRetryBackoff {
+        return nil, fmt.Errorf("retryBackoff must be at most %s", m.cfg.MaxRetryBackoff)
+    }
+    if opts.OutputTTL < 0 || (m.cfg.MaxOutputTTL > 0 && opts.OutputTTL > m.cfg.MaxOutputTTL) {
+        return nil, fmt.Errorf("outputTtl must be between 0 and %s", m.cfg.MaxOutputTTL)
+    }
+    if len(opts.Outputs) > 0 {
+        outputExt = opts.Outputs[0] // The first output is the primary one
+    }
+    if opts.OutputMode == OutputModeDirectory && len(opts.Outputs) > 0 {
+        return nil, fmt.Errorf("directory output mode does not support multiple outputs")
+    }
+    q, err := m.queueFor(opts.Queue)
+    if err != nil {
+        return nil, err
+    }
+    if err := m.checkPlacement(opts.Requires); err != nil {
+        return nil, err
+    }
+    outputStore, err := resolveOutputStore(m.cfg, opts.OutputStore)
+    if err != nil {
+        return nil, err
+    }
+
+    t := &Task{
+        ID:               fmt.Sprintf("%s_%d", shortuuid.New(), time.Now().Unix()),
+        Status:           StatusQueued,
+        Priority:         priority,
+        Queue:            q.name,
+        Tool:             opts.Tool,
+        ResourceClass:    opts.ResourceClass,
+        Requires:         opts.Requires,
+        Command:          command,
+        InputMedia:       inputMedia,
+        ExtraInputs:      opts.ExtraInputs,
+        OutputExt:        outputExt,
+        CreatedAt:        time.Now(),
+        MaxRetries:       opts.MaxRetries,
+        RetryBackoff:     opts.RetryBackoff,
+        OutputTTL:        opts.OutputTTL,
+        OutputExts:       opts.Outputs,
+        OutputMode:       opts.OutputMode,
+        CallbackURL:      opts.CallbackURL,
+        Notify:           opts.Notify,
+        OutputUpload:     opts.OutputUpload,
+        OutputStore:      outputStore,
+        Pipe:             opts.Pipe,
+        MaxOutputSize:    opts.MaxOutputSize,
+        OutputEntry:      opts.OutputEntry,
+        Renditions:       opts.Renditions,
+        ExtraFiles:       opts.ExtraFiles,
+        StreamLabels:     opts.StreamLabels,
+        SkipStreamLabels: opts.SkipStreamLabels,
+        OutputName:       opts.OutputName,
+        BatchID:          opts.BatchID,
+        DependsOn:        opts.DependsOn,
+        IdempotencyKey:   opts.IdempotencyKey,
+        RetryOf:          opts.RetryOf,
+        idempotencyHash:  opts.idempotencyHash,
+        ScheduleID:       opts.ScheduleID,
+        Preset:           opts.Preset,
+        Billing:          opts.Billing,
+        Subtitles:        opts.Subtitles,
+        SubtitleLanguage: opts.SubtitleLanguage,
+        SubtitlesOnly:    opts.SubtitlesOnly,
+        ArtifactKind:     opts.ArtifactKind,
+        Concat:           opts.Concat,
+        InlineResult:     opts.InlineResult,
+        QC:               opts.QC,
+        Target:           opts.Target,
+        Owner:            opts.Owner,
+        maxRunning:       opts.MaxRunning,
+        RequestID:        opts.RequestID,
+        done:             make(chan struct{}),
+    }
+    if opts.RunAt.After(t.CreatedAt) {
+        t.ScheduledFor = opts.RunAt
+    }
+    if id, ok := InputRefID(inputMedia); ok {
+        t.InputID = id
+        m.attachInput(id, t.ID)
+    }
+    t.startTrace(opts.TraceParent)
+    return t, nil
+}
+
+// RunSync runs a task on behalf of a waiting caller. Short audio-only and image
+// operations are executed right away on the low-latency pool; everything else
+// goes through the regular queue. RunSync returns once the task is terminal or
+// ctx is done, in which case the task keeps running in the background.
+func (m *Manager) RunSync(ctx context.Context, command, inputMedia, outputExt string, opts SubmitOptions) (*Task, error) {
+    q, err := m.queueFor(opts.Queue)
+    if err != nil {
+        return nil, err
+    }
+    if err := q.admit(); err != nil {
+        return nil, err
+    }
+    // A paused queue holds back sync calls as well, and the scheduler the
+    // ones due later.
+    if q.tasks.isPaused() || !opts.RunAt.IsZero() || !m.isFastPath(ctx, inputMedia, outputExt) {
+        t, err := m.SubmitWithOptions(command, inputMedia, outputExt, opts)
+        if err != nil {
+            return nil, err
+        }
+        select {
+        case <-t.Done():
+        case <-ctx.Done():
+        }
+        return t, nil
+    }
+
+    t, err := m.newTask(command, inputMedia, outputExt, opts)
+    if err != nil {
+        return nil, err
+    }
+    if m.usage.tryClaim(t) {
+        t.Lane = "fast"
+        m.tasks.Store(t.ID, t)
+        m.events.publish(EventCreated, t)
+        t.logger().Info("Task takes the sync fast path")
+        // As in the queues, a caller giving up only stops waiting for the task.
+        go m.runFast(context.WithoutCancel(ctx), t)
+    } else {
+        // Owners already running MaxRunning tasks wait in the queue, like
+        // their other submissions.
+        m.submitQueued(t)
+    }
+    select {
+    case <-t.Done():
+    case <-ctx.Done():
+    }
+    return t, nil
+}
+
+// runFast runs a task in the low-latency pool once one of its slots is free,
+// or cancels it if none is within SYNC_FAST_TIMEOUT. The task's owner has been
+// claimed already.
+func (m *Manager) runFast(ctx context.Context, t *Task) {
+    defer m.releaseOwner(t)
+    timer := time.NewTimer(m.cfg.SyncFastTimeout)
+    defer timer.Stop()
+    select {
+    case m.fastSem <- struct{}{}:
+        defer func() { <-m.fastSem }()
+    case <-timer.C:
+        if !t.transition(StatusQueued, StatusCanceled) {
+            return // Canceled meanwhile
+        }
+        t.finish(StatusCanceled, "No fast lane slot became available in time", nil)
+        t.markDone()
+        m.callbacks.notify(t)
+        m.notify(t)
+        m.events.publish(eventOf(StatusCanceled), t)
+        m.stats.record(t)
+        m.billing.record(t)
+        return
+    }
+
+    m.processTask(ctx, t, m.cfg.SyncFastTimeout)
+}
+
+// isFastPath reports whether a sync call qualifies for the low-latency pool:
+// the output must be audio or an image and the input a short upload. Only
+// uploads stored on this node are probed; URLs are never fetched before the
+// runner applies the input policy and size limit to them.
+func (m *Manager) isFastPath(ctx context.Context, inputMedia, outputExt string) bool {
+    if cap(m.fastSem) == 0 || utils.MediaKindOf(outputExt) == utils.MediaKindVideo {
+        return false
+    }
+    prober, ok := m.runner.(MediaProber)
+    if !ok {
+        return false
+    }
+    path, ok := m.localInputPath(inputMedia)
+    if !ok {
+        return false
+    }
+    duration, err := prober.Probe(ctx, path)
+    if err != nil {
+        logging.FromContext(ctx).Warn("Could not probe input for the sync fast path", "input", inputMedia, "error", err)
+        return false
+    }
+    return duration <= m.cfg.SyncFastMaxDuration
+}
+
+// QueuePosition returns the 1-based position of a queued task in its queue's
+// dispatch order, or 0 if the task is not waiting in a queue.
+func (m *Manager) QueuePosition(taskID string) int {
+    t, ok := m.Get(taskID)
+    if !ok {
+        return 0
+    }
+    return m.queues[t.Queue].tasks.position(taskID)
+}
+
+// QueueDepth returns the number of tasks waiting for a processing slot, over
+// all queues.
+func (m *Manager) QueueDepth() int {
+    depth := 0
+    for _, q := range m.queues {
+        depth += q.tasks.len()
+    }
+    return depth
+}
+
 func (m *Manager) List() []*Task {
     var taskList []*Task
     m.tasks.Range(func(key, value interface{}) bool {
@@ -160,24 +726,44 @@ func (m *Manager) List() []*Task {
 }
 
 func (m *Manager) Cancel(taskID string) error {
+    m.cancelMu.Lock()
+    defer m.cancelMu.Unlock()
+    return m.cancel(taskID)
+}
+
+func (m *Manager) cancel(taskID string) error {
     val, ok := m.tasks.Load(taskID)
     if !ok {
         return fmt.Errorf("task %s not found", taskID)
     }
 
     task := val.(*Task)
-    switch task.Status {
-    case StatusCompleted, StatusFailed, StatusCanceled:
-        return fmt.Errorf("cannot cancel task in state: %s", task.Status)
-    case StatusQueued:
+    task.mu.Lock()
+    status, cancelFunc := task.Status, task.cancelFunc
+    if status == StatusQueued || status == StatusWaiting || status == StatusWaitingResources || status == StatusScheduled {
         task.Status = StatusCanceled
         task.Error = "Canceled by user while in queue"
+    }
+    task.mu.Unlock()
+    switch status {
+    case StatusCompleted, StatusCompletedWithWarnings, StatusFailed, StatusCanceled, StatusSkipped, StatusInterrupted:
+        return fmt.Errorf("cannot cancel task in state: %s", status)
+    case StatusQueued, StatusWaiting, StatusWaitingResources, StatusScheduled:
+        m.queues[task.Queue].tasks.remove(task.ID)
+        m.wheel.remove(task.ID)
+        task.markDone()
         m.tasks.Store(task.ID, task)
-        log.Printf("Task %s marked as canceled in queue.", task.ID)
+        m.callbacks.notify(task)
+        m.notify(task)
+        m.events.publish(eventOf(StatusCanceled), task)
+        m.stats.record(task)
+        m.billing.record(task)
+        task.logger().Info("Task marked as canceled in queue")
+        m.resolveDependents(task)
     case StatusProcessing:
-        if task.cancelFunc != nil {
-            task.cancelFunc()
-            log.Printf("Cancellation signal sent to running task %s.", task.ID)
+        if cancelFunc != nil {
+            cancelFunc()
+            task.logger().Info("Cancellation signal sent to running task")
         } else {
             return fmt.Errorf("task %s is processing but has no cancellation handle", task.ID)
         }
@@ -185,15 +771,22 @@ func (m *Manager) Cancel(taskID string) error {
     return nil
 }
 
+// GetFilePath resolves a download path relative to FilesRoot to the file of
+// a task artifact, e.g. "<taskId>/output.mp4" or, inside an output directory
+// (directory mode), "<taskId>/output/index.m3u8".
 func (m *Manager) GetFilePath(filename string) (string, error) {
     // Security: Prevent path traversal
-    cleanFilename := filepath.Base(filename)
-    if cleanFilename != filename {
+    cleanPath := path.Clean("/" + filename)[1:]
+    if cleanPath != filename || cleanPath == "" {
         return "", fmt.Errorf("invalid filename")
     }
+    if _, err := m.lookupFile(cleanPath); err != nil {
+        return "", err
+    }
 
-    fullPath := filepath.Join(m.cfg.TempDir, cleanFilename)
-    if _, err := os.Stat(fullPath); os.IsNotExist(err) {
+    fullPath := filepath.Join(FilesRoot(m.cfg), filepath.FromSlash(cleanPath))
+    // Directories are never served.
+    if info, err := os.Stat(fullPath); err != nil || info.IsDir() {
         return "", fmt.Errorf("file not found")
     }
     return fullPath, nil
@@ -0,0 +1,247 @@
+package task
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "net"
+    "net/http"
+    "net/mail"
+    "net/smtp"
+    "net/url"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+
+    "ffwebapi/config"
+    "ffwebapi/netguard"
+)
+
+// Notify sends a short summary of a terminal task to chat webhooks and email,
+// e.g. to learn that an overnight encode finished. Tasks without one use the
+// NOTIFY_* settings. Webhook URLs carry their token and are never shown in
+// task responses; savedTask keeps them across a restart.
+type Notify struct {
+    Slack   string   `json:"-"`               // Slack incoming webhook URL
+    Discord string   `json:"-"`               // Discord webhook URL
+    Email   []string `json:"email,omitempty"` // Recipients, sent through SMTP_HOST
+    On      []Status `json:"on,omitempty"`    // Statuses notified of; every final one if empty
+}
+
+// notifyStatuses are notified of when Notify.On is empty. Interrupted tasks
+// are left out; they run again after the restart.
+var notifyStatuses = []Status{StatusCompleted, StatusCompletedWithWarnings, StatusFailed, StatusCanceled, StatusSkipped}
+
+// ValidateNotify checks the notification settings of a submission: webhook
+// URLs must pass the egress policy and recipients must be in
+// NOTIFY_EMAIL_DOMAINS.
+func ValidateNotify(cfg *config.Config, n *Notify) error {
+    for _, s := range n.On {
+        if !s.IsTerminal() {
+            return fmt.Errorf("cannot notify of status %q; want a final one", s)
+        }
+    }
+    for _, u := range []string{n.Slack, n.Discord} {
+        if u == "" {
+            continue
+        }
+        if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+            return fmt.Errorf("webhook %q is not an http(s) URL", u)
+        }
+        if err := netguard.InputPolicy(cfg).CheckURL(u); err != nil {
+            return err
+        }
+    }
+    if len(n.Email) == 0 {
+        return nil
+    }
+    if cfg.SMTPHost == "" {
+        return fmt.Errorf("email notifications are disabled on this server")
+    }
+    for _, to := range n.Email {
+        addr, err := mail.ParseAddress(to)
+        if err != nil || addr.Name != "" {
+            return fmt.Errorf("invalid email address %q", to)
+        }
+        domain := strings.ToLower(addr.Address[strings.LastIndex(addr.Address, "@")+1:])
+        if !containsFold(cfg.NotifyEmailDomains, domain) {
+            return fmt.Errorf("email address %q is not in NOTIFY_EMAIL_DOMAINS", to)
+        }
+    }
+    return nil
+}
+
+// validateNotifyConfig checks the global NOTIFY_* settings.
+func validateNotifyConfig(cfg *config.Config) error {
+    for _, s := range cfg.NotifyOn {
+        if !Status(s).IsTerminal() {
+            return fmt.Errorf("NOTIFY_ON: %q is not a final status", s)
+        }
+    }
+    if len(cfg.NotifyEmailTo) > 0 && (cfg.SMTPHost == "" || cfg.SMTPFrom == "") {
+        return fmt.Errorf("NOTIFY_EMAIL_TO needs SMTP_HOST and SMTP_FROM")
+    }
+    for _, to := range cfg.NotifyEmailTo {
+        if _, err := mail.ParseAddress(to); err != nil {
+            return fmt.Errorf("NOTIFY_EMAIL_TO: invalid address %q", to)
+        }
+    }
+    return nil
+}
+
+// notifyOf returns the notification settings of a task, falling back to the
+// global ones.
+func (m *Manager) notifyOf(t *Task) *Notify {
+    if t.Notify != nil {
+        return t.Notify
+    }
+    n := &Notify{Slack: m.cfg.NotifySlackWebhookURL, Discord: m.cfg.NotifyDiscordWebhookURL, Email: m.cfg.NotifyEmailTo}
+    for _, s := range m.cfg.NotifyOn {
+        n.On = append(n.On, Status(s))
+    }
+    return n
+}
+
+// notify sends the notifications of a terminal task in the background.
+// Failures are logged, not retried; the callback URL is the reliable way to
+// learn of a task's end.
+func (m *Manager) notify(t *Task) {
+    n := m.notifyOf(t)
+    if n.Slack == "" && n.Discord == "" && len(n.Email) == 0 {
+        return
+    }
+    on := n.On
+    if len(on) == 0 {
+        on = notifyStatuses
+    }
+    notified := false
+    for _, s := range on {
+        notified = notified || s == t.Status
+    }
+    if !notified {
+        return
+    }
+
+    subject, text := m.notificationText(t)
+    logger := t.logger()
+    go func() {
+        ctx := context.Background() // Bounded by CALLBACK_TIMEOUT through the client
+        if n.Slack != "" {
+            if err := m.postNotification(ctx, n.Slack, map[string]string{"text": text}); err != nil {
+                logger.Warn("Could not notify Slack", "error", err)
+            }
+        }
+        if n.Discord != "" {
+            if err := m.postNotification(ctx, n.Discord, map[string]string{"content": text}); err != nil {
+                logger.Warn("Could not notify Discord", "error", err)
+            }
+        }
+        if len(n.Email) > 0 {
+            if err := m.sendNotificationEmail(n.Email, subject, text); err != nil {
+                logger.Warn("Could not send notification email", "error", err)
+            }
+        }
+    }()
+}
+
+// notificationText summarizes a terminal task: status, input, how long it
+// took, and the error or download link.
+func (m *Manager) notificationText(t *Task) (subject, text string) {
+    subject = fmt.Sprintf("Task %s %s", t.ID, strings.ReplaceAll(string(t.Status), "_", " "))
+    var b strings.Builder
+    fmt.Fprintf(&b, "%s after %s\n", subject, t.CompletedAt.Sub(t.CreatedAt).Round(time.Second))
+    fmt.Fprintf(&b, "Input: %s\n", inputLabel(t.InputMedia))
+    if t.Error != "" {
+        fmt.Fprintf(&b, "Error: %s\n", t.Error)
+    }
+    if link := m.notificationLink(t); link != "" {
+        fmt.Fprintf(&b, "Download: %s\n", link)
+    }
+    return subject, b.String()
+}
+
+// notificationLink is the download URL of a succeeded task's output: from its
+// output store, or below BASE. Without BASE the server's address is unknown
+// and there is no link.
+func (m *Manager) notificationLink(t *Task) string {
+    if !t.Status.Succeeded() || t.OutputPath == "" {
+        return ""
+    }
+    if u, ok, err := m.StoredURL(t, t.OutputPath); err == nil && ok {
+        return u
+    }
+    if m.cfg.BaseURL == "" {
+        return ""
+    }
+    return strings.TrimSuffix(m.cfg.BaseURL, "/") + "/api/v2/files/" + m.URLPath(t.OutputPath)
+}
+
+// inputLabel names an input without leaking credentials in its URL or the
+// content of a data URI.
+func inputLabel(inputMedia string) string {
+    if strings.HasPrefix(inputMedia, "data:") {
+        return "data URI"
+    }
+    if u, err := url.Parse(inputMedia); err == nil && u.Scheme != "" && u.Host != "" {
+        return u.Scheme + "://" + u.Host + u.Path // Without user info and query, e.g. a presigned URL's signature
+    }
+    return filepath.Base(inputMedia)
+}
+
+func (m *Manager) postNotification(ctx context.Context, webhook string, payload map[string]string) error {
+    // The URL was checked at submission, but the policy may have changed since.
+    if err := netguard.InputPolicy(m.cfg).CheckURL(webhook); err != nil {
+        return err
+    }
+    body, err := json.Marshal(payload)
+    if err != nil {
+        return err
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    resp, err := m.notifyHTTP.Do(req)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return fmt.Errorf("webhook answered %s", resp.Status)
+    }
+    return nil
+}
+
+// sendNotificationEmail sends a plain-text email through SMTP_HOST, using
+// STARTTLS if the server offers it.
+func (m *Manager) sendNotificationEmail(to []string, subject, text string) error {
+    var auth smtp.Auth
+    if m.cfg.SMTPUsername != "" {
+        auth = smtp.PlainAuth("", m.cfg.SMTPUsername, m.cfg.SMTPPassword, m.cfg.SMTPHost)
+    }
+    var msg bytes.Buffer
+    fmt.Fprintf(&msg, "From: %s\r\n", m.cfg.SMTPFrom)
+    fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+    fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+    fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+    msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+    msg.WriteString(strings.ReplaceAll(text, "\n", "\r\n"))
+    from, err := mail.ParseAddress(m.cfg.SMTPFrom)
+    if err != nil {
+        return fmt.Errorf("SMTP_FROM: %w", err)
+    }
+    addr := net.JoinHostPort(m.cfg.SMTPHost, strconv.Itoa(m.cfg.SMTPPort))
+    return smtp.SendMail(addr, auth, from.Address, to, msg.Bytes())
+}
+
+func containsFold(list []string, s string) bool {
+    for _, v := range list {
+        if strings.EqualFold(v, s) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,97 @@
+package task
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+
+    "ffwebapi/config"
+)
+
+// OutputStoreLocal is the built-in output store: files stay in the temp dir
+// and are downloaded through the files endpoint.
+const OutputStoreLocal = "local"
+
+// OutputStore keeps the files of completed tasks where clients download them
+// from directly, e.g. a bucket or a WebDAV share. Stores are registered by
+// name with RegisterOutputStore and picked by OUTPUT_STORE or per task.
+type OutputStore interface {
+    // Put stores a file under key, its path below the files endpoint, e.g.
+    // "<taskId>/output.mp4".
+    Put(ctx context.Context, key, path string) error
+    // URL returns where a stored key is downloaded, valid at least until
+    // expires.
+    URL(key string, expires time.Time) (string, error)
+}
+
+var (
+    outputStoresMu sync.RWMutex
+    outputStores   = make(map[string]OutputStore) // Registered stores by lowercase name
+)
+
+// RegisterOutputStore makes an output store available under name, e.g.
+// RegisterOutputStore("sftp", s). Stores are registered before the task
+// manager is created.
+func RegisterOutputStore(name string, s OutputStore) {
+    outputStoresMu.Lock()
+    defer outputStoresMu.Unlock()
+    outputStores[strings.ToLower(name)] = s
+}
+
+// LookupOutputStore returns a registered output store.
+func LookupOutputStore(name string) (OutputStore, bool) {
+    outputStoresMu.RLock()
+    defer outputStoresMu.RUnlock()
+    s, ok := outputStores[strings.ToLower(name)]
+    return s, ok
+}
+
+// resolveOutputStore resolves the output store a task asks for, OUTPUT_STORE
+// if empty, to its lowercase name; "" stands for the local store.
+func resolveOutputStore(cfg *config.Config, name string) (string, error) {
+    if name == "" {
+        name = cfg.OutputStore
+    }
+    name = strings.ToLower(name)
+    if name == "" || name == OutputStoreLocal {
+        return "", nil
+    }
+    if _, ok := LookupOutputStore(name); !ok {
+        return "", fmt.Errorf("unknown output store %q", name)
+    }
+    return name, nil
+}
+
+// storeOutputs puts the files of a succeeded task into its output store.
+// Local copies remain until the task's retention ends, so bundles and
+// downloads through the files endpoint keep working.
+func (m *Manager) storeOutputs(ctx context.Context, t *Task) error {
+    s, ok := LookupOutputStore(t.OutputStore)
+    if !ok {
+        return fmt.Errorf("unknown output store %q", t.OutputStore)
+    }
+    for _, f := range t.BundleFiles() {
+        if err := s.Put(ctx, m.URLPath(f.Path), f.Path); err != nil {
+            return fmt.Errorf("could not store output %s in %s: %w", f.Name, t.OutputStore, err)
+        }
+    }
+    t.logger().Info("Outputs stored", "output_store", t.OutputStore)
+    return nil
+}
+
+// StoredURL returns the URL a file of t is downloaded from in the task's
+// output store, valid for OUTPUT_STORE_URL_TTL. ok is false for tasks kept
+// in the local store.
+func (m *Manager) StoredURL(t *Task, path string) (u string, ok bool, err error) {
+    if t.OutputStore == "" {
+        return "", false, nil
+    }
+    s, ok := LookupOutputStore(t.OutputStore)
+    if !ok {
+        return "", false, fmt.Errorf("unknown output store %q", t.OutputStore)
+    }
+    u, err = s.URL(m.URLPath(path), time.Now().Add(m.cfg.OutputStoreURLTTL))
+    return u, err == nil, err
+}
@@ -0,0 +1,169 @@
+package task
+
+import (
+    "fmt"
+    "log/slog"
+    "time"
+
+    "github.com/lithammer/shortuuid/v4"
+)
+
+// PipelineStep describes one stage of a pipeline. The output of step N becomes
+// the ${INPUT_MEDIA} of step N+1.
+type PipelineStep struct {
+    Command   string
+    OutputExt string
+}
+
+// Pipeline is a chain of tasks that run one after another.
+type Pipeline struct {
+    ID        string    `json:"id"`
+    Status    Status    `json:"status"` // Derived from the steps on every read
+    Steps     []*Task   `json:"steps"`
+    CreatedAt time.Time `json:"createdAt"`
+}
+
+// status derives the pipeline status from its steps: the first failed,
+// canceled or interrupted step decides the outcome, otherwise the pipeline is
+// as far as its least advanced step.
+func (p *Pipeline) status() Status {
+    status := StatusCompleted
+    for _, step := range p.Steps {
+        switch stepStatus := step.status(); stepStatus {
+        case StatusFailed, StatusCanceled, StatusInterrupted:
+            return stepStatus
+        case StatusCompletedWithWarnings:
+            if status == StatusCompleted {
+                status = StatusCompletedWithWarnings
+            }
+        case StatusProcessing:
+            status = StatusProcessing
+        case StatusQueued, StatusWaiting, StatusWaitingResources:
+            if status.Succeeded() {
+                status = StatusQueued
+            }
+        }
+    }
+    return status
+}
+
+// SubmitPipeline creates one task per step. Only the first step is queued right
+// away; the others wait for their predecessor to complete.
+func (m *Manager) SubmitPipeline(inputMedia string, steps []PipelineStep, opts SubmitOptions) (*Pipeline, error) {
+    if len(steps) == 0 {
+        return nil, fmt.Errorf("a pipeline needs at least one step")
+    }
+    q, err := m.queueFor(opts.Queue)
+    if err != nil {
+        return nil, err
+    }
+    if err := q.admit(); err != nil {
+        return nil, err
+    }
+
+    p := &Pipeline{
+        ID:        fmt.Sprintf("pl_%s_%d", shortuuid.New(), time.Now().Unix()),
+        CreatedAt: time.Now(),
+    }
+    for i, step := range steps {
+        stepInput := ""
+        if i == 0 {
+            stepInput = inputMedia
+        }
+        t, err := m.newTask(step.Command, stepInput, step.OutputExt, opts)
+        if err != nil {
+            return nil, fmt.Errorf("step %d: %w", i, err)
+        }
+        t.PipelineID = p.ID
+        if i > 0 {
+            prev := p.Steps[i-1]
+            t.Status = StatusWaiting
+            t.DependsOn = []string{prev.ID}
+            t.inputFrom = prev.ID
+        }
+        p.Steps = append(p.Steps, t)
+    }
+
+    for _, t := range p.Steps {
+        m.tasks.Store(t.ID, t)
+        m.events.publish(EventCreated, t)
+    }
+    m.pipelines.Store(p.ID, p)
+    m.enqueue(p.Steps[0])
+    slog.Info("Pipeline submitted", "pipeline_id", p.ID, "steps", len(p.Steps))
+
+    return &Pipeline{ID: p.ID, Status: p.status(), Steps: p.Steps, CreatedAt: p.CreatedAt}, nil
+}
+
+func (m *Manager) GetPipeline(pipelineID string) (*Pipeline, bool) {
+    val, ok := m.pipelines.Load(pipelineID)
+    if !ok {
+        return nil, false
+    }
+    // Pipelines are shared, so the derived status goes into a copy.
+    p := val.(*Pipeline)
+    return &Pipeline{ID: p.ID, Status: p.status(), Steps: p.Steps, CreatedAt: p.CreatedAt}, true
+}
+
+// resolveDependents is called once a task is terminal. Waiting tasks whose
+// dependencies all completed are queued; if the finished task did not complete,
+// its dependents are skipped, and so on down the chain.
+func (m *Manager) resolveDependents(finished *Task) {
+    m.depsMu.Lock()
+    defer m.depsMu.Unlock()
+    for pending := []*Task{finished}; len(pending) > 0; pending = pending[1:] {
+        m.releaseDependents(pending[0], func(skipped *Task) { pending = append(pending, skipped) })
+    }
+}
+
+// releaseDependents queues or skips the waiting tasks that depend on
+// finished, reporting the skipped ones to onSkip.
+func (m *Manager) releaseDependents(finished *Task, onSkip func(*Task)) {
+    m.tasks.Range(func(key, value interface{}) bool {
+        t := value.(*Task)
+        if t.status() != StatusWaiting || !containsString(t.DependsOn, finished.ID) {
+            return true
+        }
+
+        if finishedStatus := finished.status(); !finishedStatus.Succeeded() {
+            m.skip(t, fmt.Sprintf("Skipped because upstream task %s %s", finished.ID, finishedStatus))
+            onSkip(t)
+            return true
+        }
+
+        if !m.dependenciesCompleted(t) {
+            return true
+        }
+        if t.inputFrom != "" {
+            if upstream, ok := m.Get(t.inputFrom); ok {
+                t.Update(func() { t.InputMedia = upstream.OutputPath })
+            }
+        }
+        if !t.transition(StatusWaiting, StatusQueued) {
+            return true // Canceled meanwhile
+        }
+        m.tasks.Store(t.ID, t)
+        m.enqueue(t)
+        t.logger().Info("Task released: all dependencies completed")
+        return true
+    })
+}
+
+func (m *Manager) dependenciesCompleted(t *Task) bool {
+    for _, id := range t.DependsOn {
+        dep, ok := m.Get(id)
+        if !ok || !dep.status().Succeeded() {
+            return false
+        }
+    }
+    return true
+}
+
+func containsString(list []string, s string) bool {
+    for _, v := range list {
+        if v == s {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,96 @@
+package task
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "regexp"
+    "strings"
+)
+
+// labelRe matches node labels and task requirements: a name such as "gpu",
+// optionally with a value, such as "region:eu".
+var labelRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,62}(:[a-zA-Z0-9._-]{1,63})?$`)
+
+// ErrUnplaceable is returned for tasks whose requirements no node meets.
+// The error is a *PlacementError.
+var ErrUnplaceable = errors.New("no node can run the task")
+
+// PlacementError rejects a task whose "requires" no node's labels satisfy,
+// so it would wait forever.
+type PlacementError struct {
+    Missing []string // Requirements no node has
+}
+
+func (e *PlacementError) Error() string {
+    return fmt.Sprintf("%v: no node has %s", ErrUnplaceable, strings.Join(e.Missing, ", "))
+}
+
+func (e *PlacementError) Is(target error) bool { return target == ErrUnplaceable }
+
+// NodeInfo describes a node tasks are placed on.
+type NodeInfo struct {
+    Name      string   `json:"name"`
+    Labels    []string `json:"labels"`
+    Hardware  []string `json:"hardware,omitempty"`  // Detected at startup, e.g. "nvidia"
+    HWProfile string   `json:"hwProfile,omitempty"` // HW_PROFILES entry applied to its commands
+    Standby   bool     `json:"standby,omitempty"`   // Takes tasks only once its primary fails
+}
+
+// HardwareReporter is optionally implemented by runners that detect
+// hardware acceleration at startup.
+type HardwareReporter interface {
+    Hardware() (detected []string, profile string)
+}
+
+// ValidateLabel checks the syntax of a node label or task requirement.
+func ValidateLabel(label string) error {
+    if !labelRe.MatchString(label) {
+        return fmt.Errorf("invalid label %q (want a name like \"gpu\" or \"region:eu\")", label)
+    }
+    return nil
+}
+
+// Nodes lists the nodes tasks may be placed on, with their NODE_LABELS and
+// a "hw:<kind>" label per kind of hardware detected. This server runs its
+// tasks itself, so that is this node.
+func (m *Manager) Nodes() []NodeInfo {
+    name := m.cfg.NodeName
+    if name == "" {
+        name, _ = os.Hostname()
+    }
+    node := NodeInfo{Name: name, Labels: append([]string{}, m.cfg.NodeLabels...), Standby: m.IsStandby()}
+    if hw, ok := m.runner.(HardwareReporter); ok {
+        node.Hardware, node.HWProfile = hw.Hardware()
+        for _, kind := range node.Hardware {
+            if label := "hw:" + kind; !containsString(node.Labels, label) {
+                node.Labels = append(node.Labels, label)
+            }
+        }
+    }
+    return []NodeInfo{node}
+}
+
+// checkPlacement reports which requirements no node satisfies: each must be
+// among the labels of one and the same node.
+func (m *Manager) checkPlacement(requires []string) error {
+    if len(requires) == 0 {
+        return nil
+    }
+    var best []string
+    for _, node := range m.Nodes() {
+        var missing []string
+        for _, r := range requires {
+            if !containsString(node.Labels, r) {
+                missing = append(missing, r)
+            }
+        }
+        if len(missing) == 0 {
+            return nil
+        }
+        if best == nil || len(missing) < len(best) {
+            best = missing
+        }
+    }
+    return &PlacementError{Missing: best}
+}
@@ -0,0 +1,349 @@
+package task
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log/slog"
+    "sort"
+    "sync"
+    "sync/atomic"
+
+    "ffwebapi/config"
+)
+
+// DefaultQueue receives the tasks that do not name a queue.
+const DefaultQueue = "default"
+
+// ErrQueueFull is returned for submissions to a queue at its backlog limit.
+// The error is a *QueueFullError.
+var ErrQueueFull = errors.New("queue is full")
+
+// QueueFullError rejects a submission to a queue at its capacity.
+type QueueFullError struct {
+    Queue    string
+    Depth    int // Tasks waiting in the queue
+    Capacity int
+}
+
+func (e *QueueFullError) Error() string {
+    return fmt.Sprintf("%v: %q has %d tasks waiting", ErrQueueFull, e.Queue, e.Depth)
+}
+
+func (e *QueueFullError) Is(target error) bool { return target == ErrQueueFull }
+
+// ErrQueueDraining is returned for submissions to a queue that is drained.
+var ErrQueueDraining = errors.New("queue is draining")
+
+// ErrStandby is returned for submissions to a standby; they go to its primary.
+var ErrStandby = errors.New("node is a standby")
+
+type Priority string
+
+const (
+    PriorityLow    Priority = "low"
+    PriorityNormal Priority = "normal"
+    PriorityHigh   Priority = "high"
+)
+
+// dispatchOrder lists priorities from most to least urgent.
+var dispatchOrder = []Priority{PriorityHigh, PriorityNormal, PriorityLow}
+
+// ParsePriority validates a client supplied priority. An empty string means normal.
+func ParsePriority(s string) (Priority, error) {
+    switch p := Priority(s); p {
+    case "":
+        return PriorityNormal, nil
+    case PriorityLow, PriorityNormal, PriorityHigh:
+        return p, nil
+    default:
+        return "", fmt.Errorf("invalid priority %q (must be low, normal or high)", s)
+    }
+}
+
+// taskQueue is a priority-aware FIFO. Tasks of a higher priority are always
+// dispatched before lower ones; within one priority, order of submission wins.
+type taskQueue struct {
+    mu     sync.Mutex
+    levels map[Priority][]*Task
+    paused bool          // Nothing is dispatched while set
+    ready  chan struct{} // Signaled whenever a task is pushed
+}
+
+func newTaskQueue() *taskQueue {
+    return &taskQueue{
+        levels: make(map[Priority][]*Task),
+        ready:  make(chan struct{}, 1),
+    }
+}
+
+func (q *taskQueue) push(t *Task) {
+    q.mu.Lock()
+    q.levels[t.Priority] = append(q.levels[t.Priority], t)
+    q.mu.Unlock()
+    q.wake()
+}
+
+// wake makes a blocked pop look at the queue again.
+func (q *taskQueue) wake() {
+    select {
+    case q.ready <- struct{}{}:
+    default: // A wake-up is already pending
+    }
+}
+
+// setPaused stops or resumes dispatching.
+func (q *taskQueue) setPaused(paused bool) {
+    q.mu.Lock()
+    q.paused = paused
+    q.mu.Unlock()
+    q.wake()
+}
+
+func (q *taskQueue) isPaused() bool {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    return q.paused
+}
+
+// pop blocks until a task that may start is available or ctx is done. Tasks
+// for which claim returns false are skipped but keep their place; call wake
+// once they may be eligible again. Nothing is returned while paused.
+func (q *taskQueue) pop(ctx context.Context, claim func(*Task) bool) (*Task, bool) {
+    for {
+        q.mu.Lock()
+        for _, p := range dispatchOrder {
+            for i, t := range q.levels[p] {
+                if !q.paused && claim(t) {
+                    q.levels[p] = append(q.levels[p][:i:i], q.levels[p][i+1:]...)
+                    q.mu.Unlock()
+                    return t, true
+                }
+            }
+        }
+        q.mu.Unlock()
+
+        select {
+        case <-ctx.Done():
+            return nil, false
+        case <-q.ready:
+        }
+    }
+}
+
+// remove drops a task from the queue, e.g. when it is canceled before dispatch.
+func (q *taskQueue) remove(taskID string) bool {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    for p, tasks := range q.levels {
+        for i, t := range tasks {
+            if t.ID == taskID {
+                q.levels[p] = append(tasks[:i:i], tasks[i+1:]...)
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// position returns the 1-based dispatch position of a task, or 0 if it is not queued.
+func (q *taskQueue) position(taskID string) int {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    pos := 0
+    for _, p := range dispatchOrder {
+        for _, t := range q.levels[p] {
+            pos++
+            if t.ID == taskID {
+                return pos
+            }
+        }
+    }
+    return 0
+}
+
+func (q *taskQueue) len() int {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    n := 0
+    for _, tasks := range q.levels {
+        n += len(tasks)
+    }
+    return n
+}
+
+// namedQueue is a queue with its own processing slots, so that workloads in
+// different queues cannot starve each other.
+type namedQueue struct {
+    name       string
+    tasks      *taskQueue
+    slots      chan struct{} // Held by the queue's running tasks
+    maxBacklog int           // 0 = unlimited
+    running    atomic.Int32  // Tasks dispatched and not yet finished
+    draining   atomic.Bool   // New submissions are rejected while set
+    standby    atomic.Bool   // Set until a standby takes over from its primary
+}
+
+// QueueStatus is the state of a queue as shown to operators.
+type QueueStatus struct {
+    Name        string `json:"name"`
+    Paused      bool   `json:"paused"`   // Queued tasks are not dispatched
+    Draining    bool   `json:"draining"` // New submissions are rejected
+    Drained     bool   `json:"drained"`  // Draining, with nothing queued or running
+    Depth       int    `json:"depth"`    // Tasks waiting for dispatch
+    InFlight    int    `json:"inFlight"` // Tasks running
+    Concurrency int    `json:"concurrency"`
+    Capacity    int    `json:"capacity,omitempty"` // Waiting tasks before submissions are rejected; unlimited if 0
+}
+
+// newQueues creates the default queue and those of QUEUE_CONCURRENCY.
+func newQueues(cfg *config.Config) (map[string]*namedQueue, error) {
+    concurrency := map[string]int{DefaultQueue: cfg.MaxConcurrency}
+    for name, n := range cfg.QueueConcurrency {
+        if n < 1 {
+            return nil, fmt.Errorf("queue %q needs at least one slot", name)
+        }
+        concurrency[name] = n
+    }
+    for name := range cfg.QueueMaxBacklog {
+        if _, ok := concurrency[name]; !ok {
+            return nil, fmt.Errorf("QUEUE_MAX_BACKLOG names unknown queue %q", name)
+        }
+    }
+
+    queues := make(map[string]*namedQueue, len(concurrency))
+    for name, n := range concurrency {
+        queues[name] = &namedQueue{
+            name:       name,
+            tasks:      newTaskQueue(),
+            slots:      make(chan struct{}, n),
+            maxBacklog: queueCapacity(cfg, name),
+        }
+    }
+    return queues, nil
+}
+
+// queueCapacity returns how many tasks may wait in a queue: its
+// QUEUE_MAX_BACKLOG entry, or QUEUE_CAPACITY.
+func queueCapacity(cfg *config.Config, name string) int {
+    if n, ok := cfg.QueueMaxBacklog[name]; ok {
+        return n
+    }
+    return cfg.QueueCapacity
+}
+
+// admit checks that the queue may take another task.
+func (q *namedQueue) admit() error {
+    if q.standby.Load() {
+        return ErrStandby
+    }
+    if q.draining.Load() {
+        return fmt.Errorf("%w: %q accepts no new tasks", ErrQueueDraining, q.name)
+    }
+    if n := q.tasks.len(); q.maxBacklog > 0 && n >= q.maxBacklog {
+        return &QueueFullError{Queue: q.name, Depth: n, Capacity: q.maxBacklog}
+    }
+    return nil
+}
+
+// queueFor returns the named queue; an empty name selects DefaultQueue.
+func (m *Manager) queueFor(name string) (*namedQueue, error) {
+    if name == "" {
+        name = DefaultQueue
+    }
+    q, ok := m.queues[name]
+    if !ok {
+        return nil, fmt.Errorf("unknown queue %q", name)
+    }
+    return q, nil
+}
+
+// Queues returns the names of the configured queues, sorted.
+func (m *Manager) Queues() []string {
+    names := make([]string, 0, len(m.queues))
+    for name := range m.queues {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// selectQueues returns the named queue, or all queues if name is empty.
+func (m *Manager) selectQueues(name string) ([]*namedQueue, error) {
+    if name != "" {
+        q, err := m.queueFor(name)
+        if err != nil {
+            return nil, err
+        }
+        return []*namedQueue{q}, nil
+    }
+    queues := make([]*namedQueue, 0, len(m.queues))
+    for _, name := range m.Queues() {
+        queues = append(queues, m.queues[name])
+    }
+    return queues, nil
+}
+
+// PauseQueue stops dispatching from the named queue, or from all queues if
+// name is empty. Running tasks finish and submissions are still queued.
+func (m *Manager) PauseQueue(name string) error {
+    queues, err := m.selectQueues(name)
+    if err != nil {
+        return err
+    }
+    for _, q := range queues {
+        q.tasks.setPaused(true)
+        slog.Info("Queue paused", "queue", q.name)
+    }
+    return nil
+}
+
+// ResumeQueue undoes PauseQueue and DrainQueue.
+func (m *Manager) ResumeQueue(name string) error {
+    queues, err := m.selectQueues(name)
+    if err != nil {
+        return err
+    }
+    for _, q := range queues {
+        q.draining.Store(false)
+        q.tasks.setPaused(false)
+        slog.Info("Queue resumed", "queue", q.name)
+    }
+    return nil
+}
+
+// DrainQueue rejects new submissions to the named queue, or to all queues if
+// name is empty, while the queued and running tasks are worked off. A paused
+// queue is resumed, so that it can run empty.
+func (m *Manager) DrainQueue(name string) error {
+    queues, err := m.selectQueues(name)
+    if err != nil {
+        return err
+    }
+    for _, q := range queues {
+        q.draining.Store(true)
+        q.tasks.setPaused(false)
+        slog.Info("Queue draining", "queue", q.name, "depth", q.tasks.len(), "in_flight", q.running.Load())
+    }
+    return nil
+}
+
+// QueueStatus reports the state of every queue, sorted by name.
+func (m *Manager) QueueStatus() []QueueStatus {
+    queues, _ := m.selectQueues("")
+    status := make([]QueueStatus, 0, len(queues))
+    for _, q := range queues {
+        s := QueueStatus{
+            Name:        q.name,
+            Paused:      q.tasks.isPaused(),
+            Draining:    q.draining.Load(),
+            Depth:       q.tasks.len(),
+            InFlight:    int(q.running.Load()),
+            Concurrency: cap(q.slots),
+            Capacity:    q.maxBacklog,
+        }
+        s.Drained = s.Draining && s.Depth == 0 && s.InFlight == 0
+        status = append(status, s)
+    }
+    return status
+}
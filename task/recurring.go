@@ -0,0 +1,349 @@
+package task
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+    "time"
+
+    "ffwebapi/config"
+    "github.com/lithammer/shortuuid/v4"
+    "go.opentelemetry.io/otel/trace"
+)
+
+// ErrScheduleNotFound is returned for unknown schedule IDs.
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+// ErrInvalidSchedule is wrapped by errors in the cron expression or time
+// zone of a schedule.
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// Schedule submits a task on a cron schedule, e.g. a nightly re-encode of a
+// live recording. Every run is an ordinary task carrying the schedule's ID.
+// Runs missed while the server was down are skipped.
+type Schedule struct {
+    ID             string        `json:"id"`
+    Name           string        `json:"name,omitempty"`
+    Cron           string        `json:"cron"`               // e.g. "30 2 * * *" or "@daily"
+    Timezone       string        `json:"timezone,omitempty"` // IANA zone the cron expression is read in; UTC if empty
+    Paused         bool          `json:"paused,omitempty"`
+    Command        string        `json:"command"`
+    InputMedia     string        `json:"inputMedia"`
+    OutputExt      string        `json:"outputExt,omitempty"`
+    Options        SubmitOptions `json:"-"` // Of the task of every run
+    Owner          string        `json:"owner,omitempty"`
+    CreatedAt      time.Time     `json:"createdAt"`
+    OwnerScopes    []string      `json:"ownerScopes,omitempty"`    // Of the JWT that created or last replaced the schedule, if the owner is a token subject
+    OwnerExpiresAt time.Time     `json:"ownerExpiresAt,omitempty"` // When that JWT expires; the schedule no longer runs after
+    NextRunAt      time.Time     `json:"nextRunAt,omitempty"`      // Zero while paused
+    LastRunAt      time.Time     `json:"lastRunAt,omitempty"`
+    LastTaskID     string        `json:"lastTaskId,omitempty"`
+    LastError      string        `json:"lastError,omitempty"` // Why the last run could not be submitted
+    Runs           int           `json:"runs"`
+    cron           *cronSpec
+    loc            *time.Location
+}
+
+// prepare parses the cron expression and time zone and sets the next run.
+func (s *Schedule) prepare(now time.Time) error {
+    spec, err := parseCron(s.Cron)
+    if err != nil {
+        return fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+    }
+    loc, err := time.LoadLocation(s.Timezone)
+    if err != nil {
+        return fmt.Errorf("%w: unknown time zone %q", ErrInvalidSchedule, s.Timezone)
+    }
+    s.cron, s.loc = spec, loc
+    s.NextRunAt = time.Time{}
+    if !s.Paused {
+        if s.NextRunAt = spec.next(now.In(loc)); s.NextRunAt.IsZero() {
+            return fmt.Errorf("%w: cron expression %q never matches", ErrInvalidSchedule, s.Cron)
+        }
+    }
+    return nil
+}
+
+// savedSchedule is the on-disk form of a schedule, with its task options.
+type savedSchedule struct {
+    *Schedule
+    Options SubmitOptions `json:"options"`
+}
+
+// scheduleStore holds the schedules, persisted in DATA_DIR.
+type scheduleStore struct {
+    mu   sync.Mutex
+    path  string // Empty to keep schedules in memory only
+    byID  map[string]*Schedule
+    check ScheduleCheck // May be nil
+}
+
+// ScheduleCheck applies the checks of a submission by a schedule's owner to
+// one of its runs, e.g. that the owner's API key still exists and is within
+// its quota, and may update the options of the run's task. An error skips
+// the run.
+type ScheduleCheck func(s *Schedule, opts *SubmitOptions) error
+
+// SetScheduleCheck has every run of a schedule checked by check first.
+func (m *Manager) SetScheduleCheck(check ScheduleCheck) {
+    m.schedules.mu.Lock()
+    defer m.schedules.mu.Unlock()
+    m.schedules.check = check
+}
+
+func newScheduleStore(cfg *config.Config, now time.Time) (*scheduleStore, error) {
+    s := &scheduleStore{byID: map[string]*Schedule{}}
+    if cfg.DataDir == "" {
+        return s, nil
+    }
+    s.path = filepath.Join(cfg.DataDir, "schedules.json")
+    data, err := os.ReadFile(s.path)
+    if errors.Is(err, os.ErrNotExist) {
+        return s, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    var saved []savedSchedule
+    if err := json.Unmarshal(data, &saved); err != nil {
+        return nil, fmt.Errorf("could not read %s: %w", s.path, err)
+    }
+    for _, ss := range saved {
+        sched := ss.Schedule
+        sched.Options = ss.Options
+        if err := sched.prepare(now); err != nil {
+            return nil, fmt.Errorf("schedule %s: %w", sched.ID, err)
+        }
+        s.byID[sched.ID] = sched
+    }
+    return s, nil
+}
+
+// save writes the schedules; s.mu must be held.
+func (s *scheduleStore) save() error {
+    if s.path == "" {
+        return nil
+    }
+    saved := make([]savedSchedule, 0, len(s.byID))
+    for _, sched := range s.list() {
+        saved = append(saved, savedSchedule{Schedule: sched, Options: sched.Options})
+    }
+    data, err := json.MarshalIndent(saved, "", "  ")
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+        return err
+    }
+    // Write then rename, so a crash never leaves a truncated schedule file.
+    tmp := s.path + ".tmp"
+    if err := os.WriteFile(tmp, data, 0o600); err != nil {
+        return err
+    }
+    return os.Rename(tmp, s.path)
+}
+
+// list returns the schedules, oldest first; s.mu must be held.
+func (s *scheduleStore) list() []*Schedule {
+    list := make([]*Schedule, 0, len(s.byID))
+    for _, sched := range s.byID {
+        list = append(list, sched)
+    }
+    sort.Slice(list, func(i, j int) bool {
+        if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+            return list[i].CreatedAt.Before(list[j].CreatedAt)
+        }
+        return list[i].ID < list[j].ID
+    })
+    return list
+}
+
+// CreateSchedule adds a schedule with the name, cron expression, time zone,
+// task and options of spec; the other fields are filled in.
+func (m *Manager) CreateSchedule(spec Schedule) (*Schedule, error) {
+    if m.IsStandby() {
+        return nil, ErrStandby
+    }
+    if _, err := m.queueFor(spec.Options.Queue); err != nil {
+        return nil, err
+    }
+    now := time.Now()
+    s := &Schedule{
+        ID:         shortuuid.New(),
+        Name:       spec.Name,
+        Cron:       spec.Cron,
+        Timezone:   spec.Timezone,
+        Paused:     spec.Paused,
+        Command:    spec.Command,
+        InputMedia: spec.InputMedia,
+        OutputExt:  spec.OutputExt,
+        Options:    scheduledOptions(spec.Options),
+        Owner:      spec.Options.Owner,
+        CreatedAt:  now,
+    }
+    s.OwnerScopes, s.OwnerExpiresAt = spec.OwnerScopes, spec.OwnerExpiresAt
+    if err := s.prepare(now); err != nil {
+        return nil, err
+    }
+
+    store := m.schedules
+    store.mu.Lock()
+    defer store.mu.Unlock()
+    store.byID[s.ID] = s
+    if err := store.save(); err != nil {
+        delete(store.byID, s.ID)
+        return nil, err
+    }
+    slog.Info("Schedule created", "schedule_id", s.ID, "cron", s.Cron, "next_run", s.NextRunAt)
+    c := *s
+    return &c, nil
+}
+
+// UpdateSchedule replaces the cron expression, time zone, pause state, task
+// and options of a schedule with those of spec. Its runs so far are kept.
+func (m *Manager) UpdateSchedule(id string, spec Schedule) (*Schedule, error) {
+    if m.IsStandby() {
+        return nil, ErrStandby
+    }
+    if _, err := m.queueFor(spec.Options.Queue); err != nil {
+        return nil, err
+    }
+    store := m.schedules
+    store.mu.Lock()
+    defer store.mu.Unlock()
+    old, ok := store.byID[id]
+    if !ok {
+        return nil, ErrScheduleNotFound
+    }
+    s := *old
+    s.Name, s.Cron, s.Timezone, s.Paused = spec.Name, spec.Cron, spec.Timezone, spec.Paused
+    s.Command, s.InputMedia, s.OutputExt = spec.Command, spec.InputMedia, spec.OutputExt
+    s.Options = scheduledOptions(spec.Options)
+    s.Options.Owner = old.Owner // Stays with whoever created it
+    if spec.Options.Owner == old.Owner {
+        s.OwnerScopes, s.OwnerExpiresAt = spec.OwnerScopes, spec.OwnerExpiresAt
+    }
+    if err := s.prepare(time.Now()); err != nil {
+        return nil, err
+    }
+    store.byID[id] = &s
+    if err := store.save(); err != nil {
+        store.byID[id] = old
+        return nil, err
+    }
+    c := s
+    return &c, nil
+}
+
+// DeleteSchedule removes a schedule. Tasks of its past runs are kept.
+func (m *Manager) DeleteSchedule(id string) error {
+    store := m.schedules
+    store.mu.Lock()
+    defer store.mu.Unlock()
+    s, ok := store.byID[id]
+    if !ok {
+        return ErrScheduleNotFound
+    }
+    delete(store.byID, id)
+    if err := store.save(); err != nil {
+        store.byID[id] = s
+        return err
+    }
+    slog.Info("Schedule deleted", "schedule_id", id)
+    return nil
+}
+
+// GetSchedule returns a copy of a schedule.
+func (m *Manager) GetSchedule(id string) (*Schedule, bool) {
+    store := m.schedules
+    store.mu.Lock()
+    defer store.mu.Unlock()
+    s, ok := store.byID[id]
+    if !ok {
+        return nil, false
+    }
+    c := *s
+    return &c, true
+}
+
+// Schedules returns copies of all schedules, oldest first.
+func (m *Manager) Schedules() []*Schedule {
+    store := m.schedules
+    store.mu.Lock()
+    defer store.mu.Unlock()
+    list := store.list()
+    for i, s := range list {
+        c := *s
+        list[i] = &c
+    }
+    return list
+}
+
+// scheduledOptions drops the parts of submit options that belong to the
+// request creating a schedule rather than to its runs.
+func scheduledOptions(opts SubmitOptions) SubmitOptions {
+    opts.RequestID, opts.RunAt, opts.ScheduleID = "", time.Time{}, ""
+    opts.TraceParent = trace.SpanContext{}
+    return opts
+}
+
+// runSchedules submits a task for every schedule due at now.
+func (m *Manager) runSchedules(now time.Time) {
+    store := m.schedules
+    store.mu.Lock()
+    defer store.mu.Unlock()
+    ran := false
+    for _, s := range store.list() {
+        if s.NextRunAt.IsZero() || s.NextRunAt.After(now) {
+            continue
+        }
+        opts := s.Options
+        opts.ScheduleID = s.ID
+        var t *Task
+        var err error
+        if store.check != nil {
+            err = store.check(s, &opts)
+        }
+        if err == nil {
+            t, err = m.SubmitWithOptions(s.Command, s.InputMedia, s.OutputExt, opts)
+        }
+        s.LastRunAt, s.LastError = now, ""
+        s.Runs++
+        if err != nil {
+            slog.Error("Could not submit scheduled task", "schedule_id", s.ID, "error", err)
+            s.LastError = err.Error()
+        } else {
+            s.LastTaskID = t.ID
+            t.logger().Info("Scheduled run submitted", "schedule_id", s.ID)
+        }
+        s.NextRunAt = s.cron.next(now.In(s.loc))
+        ran = true
+    }
+    if ran {
+        if err := store.save(); err != nil {
+            slog.Error("Could not save schedules", "error", err)
+        }
+    }
+}
+
+// cronLoop runs the due schedules at the start of every minute. A standby
+// leaves them to its primary.
+func (m *Manager) cronLoop(ctx context.Context) {
+    for {
+        now := time.Now()
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(now.Truncate(time.Minute).Add(time.Minute).Sub(now)):
+        }
+        if !m.IsStandby() {
+            m.runSchedules(time.Now())
+        }
+    }
+}
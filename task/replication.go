@@ -0,0 +1,148 @@
+package task
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// ReplicationWait is how long the primary holds a standby's request for the
+// task store while nothing changes.
+const ReplicationWait = 30 * time.Second
+
+// ReplicationVersionHeader carries the version of the task store sent to a
+// standby, which it passes back as "after".
+const ReplicationVersionHeader = "X-Replication-Version"
+
+// ErrNoTaskStore is returned for replication requests to a node without
+// DATA_DIR, which keeps no task store.
+var ErrNoTaskStore = errors.New("tasks are not persisted (DATA_DIR is not set)")
+
+// standbyRetry is how long a standby waits after a failed request to its
+// primary.
+const standbyRetry = 2 * time.Second
+
+// ReplicationSnapshot returns the saved unfinished tasks as soon as their
+// version differs from after, or the current version alone once ctx is done.
+func (m *Manager) ReplicationSnapshot(ctx context.Context, after int64) ([]byte, int64, error) {
+    s := m.taskStore
+    if s.path == "" {
+        return nil, 0, ErrNoTaskStore
+    }
+    for {
+        s.mu.Lock()
+        data, version, changed := s.last, s.version, s.changed
+        s.mu.Unlock()
+        if version != after && data != nil { // nil until the first save after a restart
+            return data, version, nil
+        }
+        select {
+        case <-changed:
+        case <-ctx.Done():
+            return nil, version, nil
+        }
+    }
+}
+
+// IsStandby reports whether the node mirrors a primary (STANDBY_OF) and has
+// not taken over yet.
+func (m *Manager) IsStandby() bool {
+    return m.queues[DefaultQueue].standby.Load()
+}
+
+// Follow keeps DATA_DIR's task store a copy of the primary's until the
+// primary has been unreachable for STANDBY_FAILOVER_AFTER, and then takes
+// over: the copied tasks are restored as after a restart and the queues start
+// taking submissions. It returns true once the node took over, or false when
+// ctx is done. A standby that never reached its primary does not take over.
+func (m *Manager) Follow(ctx context.Context) bool {
+    url := strings.TrimSuffix(m.cfg.StandbyOf, "/") + "/api/v2/admin/replication/tasks"
+    key := m.cfg.StandbyAuthKey
+    if key == "" {
+        key = m.cfg.AuthKey
+    }
+    client := &http.Client{Timeout: ReplicationWait + 10*time.Second}
+    slog.Info("Following primary", "primary", m.cfg.StandbyOf, "failover_after", m.cfg.StandbyFailoverAfter)
+
+    var version int64
+    var lastContact time.Time
+    for {
+        reached, err := m.pull(ctx, client, url, key, &version)
+        if reached {
+            lastContact = time.Now()
+        }
+        if ctx.Err() != nil {
+            return false
+        }
+        if err != nil {
+            slog.Warn("Could not replicate the primary's tasks", "error", err)
+            if !lastContact.IsZero() && time.Since(lastContact) >= m.cfg.StandbyFailoverAfter {
+                break
+            }
+            select {
+            case <-ctx.Done():
+                return false
+            case <-time.After(standbyRetry):
+            }
+        }
+    }
+
+    slog.Warn("Primary unreachable, taking over", "primary", m.cfg.StandbyOf, "last_contact", lastContact)
+    if err := m.restoreTasks(); err != nil {
+        slog.Error("Could not restore the replicated tasks", "error", err)
+    }
+    for _, q := range m.queues {
+        q.standby.Store(false)
+    }
+    return true
+}
+
+// pull makes one request for a newer task store and saves it. reached is
+// false if the primary could not be reached or failed, which counts towards
+// the failover; other errors are configuration problems.
+func (m *Manager) pull(ctx context.Context, client *http.Client, url, key string, version *int64) (reached bool, err error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"?after="+strconv.FormatInt(*version, 10), nil)
+    if err != nil {
+        return false, err
+    }
+    if key != "" {
+        req.Header.Set("Authorization", "Bearer "+key)
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return false, err
+    }
+    defer resp.Body.Close()
+    switch {
+    case resp.StatusCode == http.StatusNotModified:
+        return true, nil
+    case resp.StatusCode >= 500:
+        return false, fmt.Errorf("primary answered %s", resp.Status)
+    case resp.StatusCode != http.StatusOK:
+        return true, fmt.Errorf("primary answered %s", resp.Status)
+    }
+    data, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return false, err
+    }
+    v, err := strconv.ParseInt(resp.Header.Get(ReplicationVersionHeader), 10, 64)
+    if err != nil {
+        return true, fmt.Errorf("invalid %s from primary", ReplicationVersionHeader)
+    }
+
+    s := m.taskStore
+    s.mu.Lock()
+    err = s.write(data, v)
+    s.mu.Unlock()
+    if err != nil {
+        return true, err
+    }
+    *version = v
+    return true, nil
+}
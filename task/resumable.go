@@ -0,0 +1,176 @@
+package task
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+// Errors of resumable uploads, besides those of input reservations.
+var (
+    ErrUploadOffsetMismatch = errors.New("upload offset does not match the bytes received")
+    ErrUploadLocked         = errors.New("upload is receiving another chunk")
+    ErrUploadInUse          = errors.New("upload is used by a task")
+)
+
+// uploadPartPath is where the bytes of a resumable upload are gathered until
+// it is complete.
+func (m *Manager) uploadPartPath(id string) string {
+    return filepath.Join(m.cfg.TempDir, "uploads", id+".part")
+}
+
+// CreateResumableUpload reserves an input of exactly size bytes for owner,
+// uploaded in chunks with AppendUpload (tus). The chunks are kept in
+// TempDir/uploads for RESUMABLE_UPLOAD_TTL, so an interrupted upload can
+// resume where it stopped; the input is stored once the last byte arrives.
+func (m *Manager) CreateResumableUpload(owner string, size int64, contentType string) (*Input, error) {
+    if size <= 0 || size > m.cfg.MaxInputSize {
+        return nil, fmt.Errorf("size must be between 1 and %d bytes", m.cfg.MaxInputSize)
+    }
+
+    m.inputMu.Lock()
+    defer m.inputMu.Unlock()
+    in, err := m.newInputLocked(owner, size, contentType, m.cfg.ResumableUploadTTL)
+    if err != nil {
+        return nil, err
+    }
+    in.Resumable = true
+    in.ReleaseAt = in.ExpiresAt
+    path := m.uploadPartPath(in.ID)
+    if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+        return nil, fmt.Errorf("could not create upload directory: %w", err)
+    }
+    f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+    if err != nil {
+        return nil, fmt.Errorf("could not create upload: %w", err)
+    }
+    f.Close()
+    m.inputs.Store(in.ID, in)
+    return in, nil
+}
+
+// UploadOffset returns the number of bytes a resumable upload received so far.
+func (m *Manager) UploadOffset(in *Input) int64 {
+    m.inputMu.Lock()
+    defer m.inputMu.Unlock()
+    return in.Offset
+}
+
+// AppendUpload writes a chunk of a resumable upload that starts at offset,
+// which must be the number of bytes received so far. The bytes of an
+// interrupted chunk are kept, so the client resumes from the offset
+// reported afterwards. A chunk running past the declared size is rejected
+// as a whole.
+func (m *Manager) AppendUpload(ctx context.Context, id string, offset int64, r io.Reader) (*Input, error) {
+    in, ok := m.GetInput(id)
+    if !ok || !in.Resumable {
+        return nil, ErrInputNotFound
+    }
+    var err error
+    m.inputMu.Lock()
+    switch {
+    case in.Status != InputReserved:
+        err = ErrInputConflict
+    case time.Now().After(in.ExpiresAt):
+        err = ErrInputExpired
+    case in.patching:
+        err = ErrUploadLocked
+    case offset != in.Offset:
+        err = ErrUploadOffsetMismatch
+    }
+    if err == nil {
+        in.patching = true
+    }
+    m.inputMu.Unlock()
+    if err != nil {
+        return nil, err
+    }
+    defer func() {
+        m.inputMu.Lock()
+        in.patching = false
+        m.inputMu.Unlock()
+    }()
+
+    f, err := os.OpenFile(m.uploadPartPath(id), os.O_WRONLY|os.O_APPEND, 0)
+    if err != nil {
+        return nil, fmt.Errorf("could not open upload: %w", err)
+    }
+    remaining := in.MaxSize - offset
+    n, copyErr := io.Copy(f, io.LimitReader(r, remaining+1))
+    if n > remaining {
+        f.Truncate(offset)
+        f.Close()
+        return nil, ErrInputTooLarge
+    }
+    if err := f.Close(); err != nil && copyErr == nil {
+        copyErr = err
+    }
+    m.inputMu.Lock()
+    in.Offset += n
+    m.inputMu.Unlock()
+    if copyErr != nil {
+        return nil, fmt.Errorf("upload interrupted after %d bytes: %w", n, copyErr)
+    }
+    if in.Offset < in.MaxSize {
+        return in, nil
+    }
+    return in, m.completeUpload(ctx, in)
+}
+
+// completeUpload moves a fully received upload to the input storage. If that
+// fails, a PATCH with no content at the final offset tries again.
+func (m *Manager) completeUpload(ctx context.Context, in *Input) error {
+    path := m.uploadPartPath(in.ID)
+    f, err := os.Open(path)
+    if err != nil {
+        return fmt.Errorf("could not open upload: %w", err)
+    }
+    size, err := m.store.Put(ctx, in.ID, f)
+    f.Close()
+    if err != nil {
+        return fmt.Errorf("could not store input: %w", err)
+    }
+    os.Remove(path)
+
+    now := time.Now()
+    m.inputMu.Lock()
+    in.Size, in.Status, in.UploadedAt = size, InputUploaded, now
+    if len(in.TaskIDs) == 0 {
+        in.ReleaseAt = now.Add(m.cfg.InputTTL)
+    }
+    m.inputMu.Unlock()
+    return nil
+}
+
+// DeleteUpload terminates a resumable upload and frees its space, unless a
+// task uses it.
+func (m *Manager) DeleteUpload(ctx context.Context, id string) error {
+    in, ok := m.GetInput(id)
+    if !ok || !in.Resumable {
+        return ErrInputNotFound
+    }
+    m.inputMu.Lock()
+    var err error
+    switch {
+    case in.patching:
+        err = ErrUploadLocked
+    case len(in.TaskIDs) > 0:
+        err = ErrUploadInUse
+    default:
+        m.inputs.Delete(in.ID) // No more chunks or tasks can find it
+    }
+    m.inputMu.Unlock()
+    if err != nil {
+        return err
+    }
+
+    os.Remove(m.uploadPartPath(in.ID))
+    if err := m.store.Delete(ctx, in.ID); err != nil {
+        return fmt.Errorf("could not delete input: %w", err)
+    }
+    return nil
+}
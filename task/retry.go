@@ -0,0 +1,92 @@
+package task
+
+import (
+    "errors"
+    "fmt"
+)
+
+// ErrNotRetryable is returned by Retry for tasks that did not end in failure.
+var ErrNotRetryable = errors.New("only failed, canceled or skipped tasks can be retried")
+
+// RetryOptions are what a retry takes from the caller asking for it rather
+// than from the original task.
+type RetryOptions struct {
+    Owner      string // The original's owner if empty
+    MaxRunning int    // Owner's MaxRunning quota
+    RequestID  string
+}
+
+// Retry submits a new task running an ended task again, with the same
+// command, inputs and options; its RetryOf names the original. Steps of
+// pipelines are not retried on their own, as they read their input from the
+// step before. Callers check the retry like any submission first.
+func (m *Manager) Retry(taskID string, ro RetryOptions) (*Task, error) {
+    t, ok := m.Get(taskID)
+    if !ok {
+        return nil, fmt.Errorf("task %s not found", taskID)
+    }
+    switch status := t.status(); status {
+    case StatusFailed, StatusCanceled, StatusSkipped:
+    default:
+        return nil, fmt.Errorf("%w; task %s is %s", ErrNotRetryable, t.ID, status)
+    }
+    if t.PipelineID != "" {
+        return nil, fmt.Errorf("task %s is a step of pipeline %s; submit the pipeline again", t.ID, t.PipelineID)
+    }
+    owner := ro.Owner
+    if owner == "" {
+        owner = t.Owner
+    }
+    opts := SubmitOptions{
+        Priority:         t.Priority,
+        Queue:            t.Queue,
+        Tool:             t.Tool,
+        ResourceClass:    t.ResourceClass,
+        Requires:         t.Requires,
+        MaxRetries:       t.MaxRetries,
+        RetryBackoff:     t.RetryBackoff,
+        OutputTTL:        t.OutputTTL,
+        Outputs:          t.OutputExts,
+        ExtraInputs:      t.ExtraInputs,
+        OutputMode:       t.OutputMode,
+        CallbackURL:      t.CallbackURL,
+        Notify:           t.Notify,
+        OutputStore:      t.OutputStore,
+        Pipe:             t.Pipe,
+        MaxOutputSize:    t.MaxOutputSize,
+        OutputEntry:      t.OutputEntry,
+        ExtraFiles:       t.ExtraFiles,
+        StreamLabels:     t.StreamLabels,
+        SkipStreamLabels: t.SkipStreamLabels,
+        OutputName:       t.OutputName,
+        Preset:           t.Preset,
+        Billing:          t.Billing,
+        Subtitles:        t.Subtitles,
+        SubtitleLanguage: t.SubtitleLanguage,
+        SubtitlesOnly:    t.SubtitlesOnly,
+        ArtifactKind:     t.ArtifactKind,
+        Concat:           t.Concat,
+        QC:               t.QC,
+        Target:           t.Target,
+        InlineResult:     t.InlineResult,
+        MaxRunning:       ro.MaxRunning,
+        Owner:            owner,
+        RequestID:        ro.RequestID,
+        RetryOf:          t.ID,
+    }
+    for _, r := range t.Renditions {
+        opts.Renditions = append(opts.Renditions, RenditionProgress{Name: r.Name, SegmentPattern: r.SegmentPattern, SegmentDuration: r.SegmentDuration})
+    }
+    if t.OutputUpload != nil {
+        opts.OutputUpload = &OutputUpload{URL: t.OutputUpload.URL, Method: t.OutputUpload.Method, Headers: t.OutputUpload.Headers, Status: UploadPending}
+    }
+    if opts.OutputStore == "" {
+        opts.OutputStore = OutputStoreLocal // Not OUTPUT_STORE, which may have changed since
+    }
+    retry, err := m.SubmitWithOptions(t.Command, t.InputMedia, t.OutputExt, opts)
+    if err != nil {
+        return nil, err
+    }
+    retry.logger().Info("Task retried", "retry_of", t.ID)
+    return retry, nil
+}
@@ -0,0 +1,106 @@
+package task
+
+import (
+    "context"
+    "sync"
+    "time"
+)
+
+// Scheduled tasks are held in a timing wheel of wheelSlots slots, one per
+// wheelTick; tasks due more than a turn ahead go round again.
+const (
+    wheelTick  = time.Second
+    wheelSlots = 3600
+)
+
+// timerWheel holds scheduled tasks until they are due. Adding and removing a
+// task costs the same however many are scheduled.
+type timerWheel struct {
+    mu    sync.Mutex
+    tick  time.Duration
+    pos   int                     // Slot of the last tick
+    slots []map[string]*wheelEntry
+    index map[string]int          // Slot of each task, by ID
+}
+
+type wheelEntry struct {
+    task   *Task
+    rounds int // Turns left before the task is due
+}
+
+func newTimerWheel() *timerWheel {
+    w := &timerWheel{tick: wheelTick, slots: make([]map[string]*wheelEntry, wheelSlots), index: map[string]int{}}
+    for i := range w.slots {
+        w.slots[i] = map[string]*wheelEntry{}
+    }
+    return w
+}
+
+// add schedules t for due, rounded up to the next tick.
+func (w *timerWheel) add(t *Task, due, now time.Time) {
+    ticks := int((due.Sub(now) + w.tick - 1) / w.tick)
+    if ticks < 1 {
+        ticks = 1
+    }
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    slot := (w.pos + ticks) % len(w.slots)
+    w.slots[slot][t.ID] = &wheelEntry{task: t, rounds: (ticks - 1) / len(w.slots)}
+    w.index[t.ID] = slot
+}
+
+func (w *timerWheel) remove(id string) {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    if slot, ok := w.index[id]; ok {
+        delete(w.slots[slot], id)
+        delete(w.index, id)
+    }
+}
+
+// advance moves the wheel by a tick and returns the tasks now due.
+func (w *timerWheel) advance() []*Task {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    w.pos = (w.pos + 1) % len(w.slots)
+    var due []*Task
+    for id, e := range w.slots[w.pos] {
+        if e.rounds > 0 {
+            e.rounds--
+            continue
+        }
+        due = append(due, e.task)
+        delete(w.slots[w.pos], id)
+        delete(w.index, id)
+    }
+    return due
+}
+
+// schedule holds a task back until its ScheduledFor.
+func (m *Manager) schedule(t *Task) {
+    t.setStatus(StatusScheduled)
+    m.wheel.add(t, t.ScheduledFor, time.Now())
+}
+
+// scheduleLoop queues scheduled tasks once they are due.
+func (m *Manager) scheduleLoop(ctx context.Context) {
+    ticker := time.NewTicker(m.wheel.tick)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            for _, t := range m.wheel.advance() {
+                // Canceled tasks are removed from the wheel, but may have
+                // been taken out just now.
+                if !t.transition(StatusScheduled, StatusQueued) {
+                    continue
+                }
+                m.enqueue(t)
+                t.logger().Info("Scheduled task queued", "scheduled_for", t.ScheduledFor)
+            }
+        }
+    }
+}
@@ -0,0 +1,175 @@
+package task
+
+import (
+    "context"
+    "encoding/json"
+    "io/fs"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "sort"
+    "time"
+)
+
+// drainPollInterval is how often Shutdown checks whether the running tasks
+// have finished.
+const drainPollInterval = 100 * time.Millisecond
+
+// ShutdownReport tells operators restarting a node what the previous process
+// left behind: tasks to re-drive and files still on disk.
+type ShutdownReport struct {
+    At          time.Time      `json:"at"`
+    Drained     bool           `json:"drained"`     // All running tasks finished within the drain period
+    Queued      []ReportedTask `json:"queued"`      // Queued or waiting for dependencies
+    Interrupted []ReportedTask `json:"interrupted"` // Stopped by this shutdown
+    Files       []ReportedFile `json:"files"`       // Published files under TEMP_DIR/files
+    FileBytes   int64          `json:"fileBytes"`
+}
+
+// ReportedTask is a task listed in a ShutdownReport.
+type ReportedTask struct {
+    ID        string    `json:"id"`
+    Status    Status    `json:"status"`
+    Queue     string    `json:"queue"`
+    Owner     string    `json:"owner,omitempty"`
+    BatchID   string    `json:"batchId,omitempty"`
+    CreatedAt time.Time `json:"createdAt"`
+}
+
+// ReportedFile is a file left on disk, relative to the files directory.
+type ReportedFile struct {
+    Path  string `json:"path"` // <taskId>/<name>
+    Bytes int64  `json:"bytes"`
+}
+
+// Shutdown stops accepting work and waits for the running tasks to finish.
+// Tasks still running when ctx is done are stopped and marked interrupted.
+// Interrupted, queued and waiting tasks are then saved to DATA_DIR, to be
+// restored after a restart, and a ShutdownReport is logged and written to
+// SHUTDOWN_REPORT_FILE.
+func (m *Manager) Shutdown(ctx context.Context) error {
+    for _, q := range m.queues {
+        q.draining.Store(true)
+        q.tasks.setPaused(true) // Queued tasks stay queued
+    }
+    slog.Info("Waiting for running tasks to finish", "running", m.inFlight())
+
+    drained := m.waitIdle(ctx)
+    if !drained {
+        m.tasks.Range(func(key, value interface{}) bool {
+            value.(*Task).interrupt()
+            return true
+        })
+        slog.Warn("Drain period over, interrupting running tasks", "running", m.inFlight())
+        m.waitIdle(context.Background())
+    }
+
+    flushCtx, cancel := context.WithTimeout(context.Background(), eventFlushTimeout)
+    m.events.close(flushCtx)
+    cancel()
+
+    now := time.Now()
+    if err := m.stats.flush(); err != nil {
+        slog.Error("Failed to save task stats", "error", err)
+    }
+    if err := m.usage.flush(now); err != nil {
+        slog.Error("Failed to save usage", "error", err)
+    }
+    if err := m.billing.flush(); err != nil {
+        slog.Error("Failed to save billing line items", "error", err)
+    }
+    var saveErr error
+    if !m.IsStandby() { // Its task store is the primary's
+        saveErr = m.taskStore.save(m)
+    }
+
+    report := m.shutdownReport(now, drained)
+    slog.Info("Shutdown report", "drained", report.Drained, "queued", reportedIDs(report.Queued),
+        "interrupted", reportedIDs(report.Interrupted), "files", len(report.Files), "file_bytes", report.FileBytes)
+    if path := m.cfg.ShutdownReportFile; path != "" {
+        if err := writeShutdownReport(path, report); err != nil {
+            slog.Error("Failed to write shutdown report", "path", path, "error", err)
+        }
+    }
+    return saveErr
+}
+
+// shutdownReport collects the unfinished tasks and the files left on disk.
+func (m *Manager) shutdownReport(now time.Time, drained bool) *ShutdownReport {
+    r := &ShutdownReport{At: now, Drained: drained, Queued: []ReportedTask{}, Interrupted: []ReportedTask{}, Files: []ReportedFile{}}
+    m.tasks.Range(func(key, value interface{}) bool {
+        t := value.(*Task)
+        status := t.status()
+        rt := ReportedTask{ID: t.ID, Status: status, Queue: t.Queue, Owner: t.Owner, BatchID: t.BatchID, CreatedAt: t.CreatedAt}
+        switch {
+        case status == StatusQueued || status == StatusWaiting || status == StatusWaitingResources || status == StatusScheduled:
+            r.Queued = append(r.Queued, rt)
+        case status == StatusInterrupted && t.isInterrupted():
+            r.Interrupted = append(r.Interrupted, rt)
+        }
+        return true
+    })
+    byCreation := func(tasks []ReportedTask) func(i, j int) bool {
+        return func(i, j int) bool { return tasks[i].CreatedAt.Before(tasks[j].CreatedAt) }
+    }
+    sort.Slice(r.Queued, byCreation(r.Queued))
+    sort.Slice(r.Interrupted, byCreation(r.Interrupted))
+
+    root := FilesRoot(m.cfg)
+    filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+        if err != nil || d.IsDir() {
+            return nil // A missing or unreadable directory just isn't reported
+        }
+        info, err := d.Info()
+        if err != nil {
+            return nil
+        }
+        rel, _ := filepath.Rel(root, path)
+        r.Files = append(r.Files, ReportedFile{Path: filepath.ToSlash(rel), Bytes: info.Size()})
+        r.FileBytes += info.Size()
+        return nil
+    })
+    return r
+}
+
+func reportedIDs(tasks []ReportedTask) []string {
+    ids := make([]string, len(tasks))
+    for i, t := range tasks {
+        ids[i] = t.ID
+    }
+    return ids
+}
+
+func writeShutdownReport(path string, r *ShutdownReport) error {
+    data, err := json.MarshalIndent(r, "", "  ")
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+        return err
+    }
+    return os.WriteFile(path, data, 0o600)
+}
+
+// inFlight counts the tasks being processed, in queues and the fast lane.
+func (m *Manager) inFlight() int {
+    n := len(m.fastSem)
+    for _, q := range m.queues {
+        n += int(q.running.Load())
+    }
+    return n
+}
+
+// waitIdle waits until no task is processing, or reports false once ctx is done.
+func (m *Manager) waitIdle(ctx context.Context) bool {
+    ticker := time.NewTicker(drainPollInterval)
+    defer ticker.Stop()
+    for m.inFlight() > 0 {
+        select {
+        case <-ctx.Done():
+            return false
+        case <-ticker.C:
+        }
+    }
+    return true
+}
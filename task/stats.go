@@ -0,0 +1,283 @@
+package task
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+    "time"
+
+    "ffwebapi/config"
+)
+
+// Stats intervals.
+const (
+    StatsHourly = "hour"
+    StatsDaily  = "day"
+)
+
+// maxStatsBuckets bounds the size of a stats report.
+const maxStatsBuckets = 10000
+
+// persistInterval is how often changed stats, usage and billing line items
+// are written to DATA_DIR.
+const persistInterval = time.Minute
+
+// StatsBucket aggregates the tasks that finished within one interval.
+type StatsBucket struct {
+    Start          time.Time `json:"start"`
+    Jobs           int       `json:"jobs"`           // Finished tasks, whatever their outcome
+    Completed      int       `json:"completed"`
+    Failed         int       `json:"failed"`
+    Canceled       int       `json:"canceled"`
+    MediaMinutes   float64   `json:"mediaMinutes"`   // Media processed by completed tasks
+    AvgWaitSeconds float64   `json:"avgWaitSeconds"` // Mean time from queueing to the first attempt
+    FailureRate    float64   `json:"failureRate"`    // Failed / jobs
+}
+
+// StatsReport is the history of a time range, bucketed by hour or day.
+type StatsReport struct {
+    Interval string        `json:"interval"`
+    From     time.Time     `json:"from"`
+    To       time.Time     `json:"to"`
+    Buckets  []StatsBucket `json:"buckets"` // Oldest first, including empty ones
+    Total    StatsBucket   `json:"total"`
+}
+
+// statsCounts are the sums of one hour, from which buckets are derived.
+type statsCounts struct {
+    Completed    int     `json:"completed"`
+    Failed       int     `json:"failed"`
+    Canceled     int     `json:"canceled"`
+    MediaSeconds float64 `json:"mediaSeconds"`
+    Waited       int     `json:"waited"`      // Tasks whose queue wait is part of WaitSeconds
+    WaitSeconds  float64 `json:"waitSeconds"`
+}
+
+func (c *statsCounts) add(o *statsCounts) {
+    c.Completed += o.Completed
+    c.Failed += o.Failed
+    c.Canceled += o.Canceled
+    c.MediaSeconds += o.MediaSeconds
+    c.Waited += o.Waited
+    c.WaitSeconds += o.WaitSeconds
+}
+
+func (c *statsCounts) bucket(start time.Time) StatsBucket {
+    b := StatsBucket{
+        Start:        start,
+        Jobs:         c.Completed + c.Failed + c.Canceled,
+        Completed:    c.Completed,
+        Failed:       c.Failed,
+        Canceled:     c.Canceled,
+        MediaMinutes: c.MediaSeconds / 60,
+    }
+    if c.Waited > 0 {
+        b.AvgWaitSeconds = c.WaitSeconds / float64(c.Waited)
+    }
+    if b.Jobs > 0 {
+        b.FailureRate = float64(c.Failed) / float64(b.Jobs)
+    }
+    return b
+}
+
+// storedHour is the on-disk form of one hour of stats.
+type storedHour struct {
+    Start time.Time `json:"start"`
+    statsCounts
+}
+
+// statsTracker aggregates finished tasks into hourly counts, so capacity can
+// be planned from the API without an external metrics stack. The counts are
+// persisted in DATA_DIR and kept for STATS_RETENTION.
+type statsTracker struct {
+    path      string // File the stats are persisted to; empty to keep them in memory only
+    retention time.Duration
+    mu        sync.Mutex
+    hours     map[int64]*statsCounts // By start of the hour, in Unix seconds
+    dirty     bool                   // Changed since the last flush
+}
+
+func newStatsTracker(cfg *config.Config) (*statsTracker, error) {
+    st := &statsTracker{retention: cfg.StatsRetention, hours: make(map[int64]*statsCounts)}
+    if cfg.DataDir == "" {
+        return st, nil
+    }
+    st.path = filepath.Join(cfg.DataDir, "stats.json")
+    data, err := os.ReadFile(st.path)
+    if errors.Is(err, os.ErrNotExist) {
+        return st, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    var stored []storedHour
+    if err := json.Unmarshal(data, &stored); err != nil {
+        return nil, fmt.Errorf("could not read %s: %w", st.path, err)
+    }
+    for i := range stored {
+        st.hours[stored[i].Start.Unix()] = &stored[i].statsCounts
+    }
+    return st, nil
+}
+
+// record counts a terminal task in the hour it finished. Skipped tasks never
+// ran and are not counted.
+func (st *statsTracker) record(t *Task) {
+    finished := t.CompletedAt
+    if finished.IsZero() {
+        finished = time.Now() // Canceled while queued
+    }
+    var c statsCounts
+    switch {
+    case t.Status.Succeeded():
+        c.Completed = 1
+        c.MediaSeconds = t.MediaDuration.Seconds()
+    case t.Status == StatusFailed:
+        c.Failed = 1
+    case t.Status == StatusCanceled:
+        c.Canceled = 1
+    default:
+        return
+    }
+    if t.Attempt > 0 {
+        c.Waited = 1
+        c.WaitSeconds = t.queueWait.Seconds()
+    }
+
+    hour := finished.Truncate(time.Hour).Unix()
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    if st.hours[hour] == nil {
+        st.hours[hour] = &statsCounts{}
+    }
+    st.hours[hour].add(&c)
+    st.dirty = true
+}
+
+// report buckets the hours in [from, to).
+func (st *statsTracker) report(from, to time.Time, interval string) (*StatsReport, error) {
+    var step time.Duration
+    switch interval {
+    case StatsHourly:
+        step = time.Hour
+    case StatsDaily:
+        step = 24 * time.Hour // Truncating to it yields UTC midnight
+    default:
+        return nil, fmt.Errorf("interval must be %q or %q", StatsHourly, StatsDaily)
+    }
+    from, to = from.UTC().Truncate(step), to.UTC()
+    if !from.Before(to) {
+        return nil, fmt.Errorf("from must be before to")
+    }
+    n := int((to.Sub(from) + step - 1) / step)
+    if n > maxStatsBuckets {
+        return nil, fmt.Errorf("time range spans more than %d buckets", maxStatsBuckets)
+    }
+
+    counts := make([]statsCounts, n)
+    var total statsCounts
+    st.mu.Lock()
+    for hour, c := range st.hours {
+        start := time.Unix(hour, 0)
+        if start.Before(from) || !start.Before(to) {
+            continue
+        }
+        counts[start.Sub(from)/step].add(c)
+        total.add(c)
+    }
+    st.mu.Unlock()
+
+    report := &StatsReport{Interval: interval, From: from, To: to, Buckets: make([]StatsBucket, n), Total: total.bucket(from)}
+    for i := range counts {
+        report.Buckets[i] = counts[i].bucket(from.Add(time.Duration(i) * step))
+    }
+    return report, nil
+}
+
+// prune drops the hours older than the retention.
+func (st *statsTracker) prune(now time.Time) {
+    if st.retention <= 0 {
+        return
+    }
+    cutoff := now.Add(-st.retention).Unix()
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    for hour := range st.hours {
+        if hour < cutoff {
+            delete(st.hours, hour)
+            st.dirty = true
+        }
+    }
+}
+
+// flush writes the stats to disk if they changed.
+func (st *statsTracker) flush() error {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    if st.path == "" || !st.dirty {
+        return nil
+    }
+    stored := make([]storedHour, 0, len(st.hours))
+    for hour, c := range st.hours {
+        stored = append(stored, storedHour{Start: time.Unix(hour, 0).UTC(), statsCounts: *c})
+    }
+    sort.Slice(stored, func(i, j int) bool { return stored[i].Start.Before(stored[j].Start) })
+    data, err := json.Marshal(stored)
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(st.path), 0o700); err != nil {
+        return err
+    }
+    // Write then rename, so a crash never leaves a truncated stats file.
+    tmp := st.path + ".tmp"
+    if err := os.WriteFile(tmp, data, 0o600); err != nil {
+        return err
+    }
+    if err := os.Rename(tmp, st.path); err != nil {
+        return err
+    }
+    st.dirty = false
+    return nil
+}
+
+// persistLoop prunes and persists the stats, usage and billing line items
+// periodically and on shutdown.
+func (m *Manager) persistLoop(ctx context.Context) {
+    ticker := time.NewTicker(persistInterval)
+    defer ticker.Stop()
+
+    for {
+        now := time.Now()
+        select {
+        case <-ctx.Done():
+        case now = <-ticker.C:
+            m.stats.prune(now)
+            m.billing.prune(now)
+        }
+        if err := m.stats.flush(); err != nil {
+            slog.Error("Failed to save task stats", "error", err)
+        }
+        if err := m.usage.flush(now); err != nil {
+            slog.Error("Failed to save usage", "error", err)
+        }
+        if err := m.billing.flush(); err != nil {
+            slog.Error("Failed to save billing line items", "error", err)
+        }
+        if ctx.Err() != nil {
+            return
+        }
+    }
+}
+
+// Stats reports the tasks that finished in [from, to), bucketed by interval
+// (StatsHourly or StatsDaily). Buckets start at UTC hour or day boundaries.
+func (m *Manager) Stats(from, to time.Time, interval string) (*StatsReport, error) {
+    return m.stats.report(from, to, interval)
+}
@@ -0,0 +1,255 @@
+package task
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+
+    "ffwebapi/config"
+    "go.opentelemetry.io/otel/trace"
+)
+
+// taskStoreInterval is how often unfinished tasks are written to DATA_DIR,
+// bounding what a crash loses.
+const taskStoreInterval = 5 * time.Second
+
+// savedTask is a task as written to DATA_DIR, including what is needed to run
+// it again but hidden from API clients.
+type savedTask struct {
+    *Task
+    Command       string            `json:"command"`
+    InputMedia    string            `json:"inputMedia"`
+    ExtraInputs   []string          `json:"extraInputs,omitempty"`
+    OutputExt     string            `json:"outputExt"`
+    OutputExts    []string          `json:"outputExts,omitempty"`
+    OutputEntry   string            `json:"outputEntry,omitempty"`
+    ExtraFiles    map[string][]byte `json:"extraFiles,omitempty"`
+    SubtitlesOnly bool              `json:"subtitlesOnly,omitempty"`
+    ArtifactKind  string            `json:"artifactKind,omitempty"`
+    Concat        bool              `json:"concat,omitempty"`
+    RetryBackoff  time.Duration     `json:"retryBackoff,omitempty"`
+    OutputTTL     time.Duration     `json:"outputTtl,omitempty"`
+    InputFrom     string            `json:"inputFrom,omitempty"`
+    MaxRunning    int               `json:"maxRunning,omitempty"`
+    UploadURL     string            `json:"uploadUrl,omitempty"`
+    UploadHeaders map[string]string `json:"uploadHeaders,omitempty"`
+    NotifySlack   string            `json:"notifySlack,omitempty"`
+    NotifyDiscord string            `json:"notifyDiscord,omitempty"`
+    PipeCommand   string            `json:"pipeCommand,omitempty"`
+    RequestHash   string            `json:"requestHash,omitempty"` // Of the request that used the idempotency key
+}
+
+// taskStore keeps the tasks that did not finish yet in
+// DATA_DIR/unfinished_tasks.json, so they survive a restart or crash.
+type taskStore struct {
+    path    string // Empty to keep tasks in memory only
+    mu      sync.Mutex
+    last    []byte        // Last written content, to skip unchanged writes
+    version int64         // Time of the last write in ns, for standbys
+    changed chan struct{} // Closed and replaced on every write
+}
+
+func newTaskStore(cfg *config.Config) *taskStore {
+    if cfg.DataDir == "" {
+        return &taskStore{changed: make(chan struct{})}
+    }
+    return &taskStore{path: filepath.Join(cfg.DataDir, "unfinished_tasks.json"), changed: make(chan struct{})}
+}
+
+// load reads the tasks saved by a previous process.
+func (s *taskStore) load() ([]savedTask, error) {
+    if s.path == "" {
+        return nil, nil
+    }
+    data, err := os.ReadFile(s.path)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    var saved []savedTask
+    if err := json.Unmarshal(data, &saved); err != nil {
+        return nil, fmt.Errorf("could not read %s: %w", s.path, err)
+    }
+    return saved, nil
+}
+
+// save writes the unfinished tasks of m.
+func (s *taskStore) save(m *Manager) error {
+    if s.path == "" {
+        return nil
+    }
+    saved := []savedTask{}
+    m.tasks.Range(func(key, value interface{}) bool {
+        t := value.(*Task)
+        status := t.status()
+        // Tasks restored as interrupted are not carried over another restart.
+        if status == StatusQueued || status == StatusProcessing || status == StatusWaiting || status == StatusWaitingResources || status == StatusScheduled || (status == StatusInterrupted && t.isInterrupted()) {
+            s := savedTask{
+                Task: t.Snapshot(), Command: t.Command, InputMedia: t.InputMedia, ExtraInputs: t.ExtraInputs, OutputExt: t.OutputExt, OutputExts: t.OutputExts,
+                OutputEntry: t.OutputEntry, ExtraFiles: t.ExtraFiles, SubtitlesOnly: t.SubtitlesOnly, ArtifactKind: t.ArtifactKind,
+                Concat: t.Concat, RetryBackoff: t.RetryBackoff, OutputTTL: t.OutputTTL, InputFrom: t.inputFrom, MaxRunning: t.maxRunning,
+            }
+            if t.OutputUpload != nil {
+                s.UploadURL, s.UploadHeaders = t.OutputUpload.URL, t.OutputUpload.Headers
+            }
+            if t.Notify != nil {
+                s.NotifySlack, s.NotifyDiscord = t.Notify.Slack, t.Notify.Discord
+            }
+            if t.Pipe != nil {
+                s.PipeCommand = t.Pipe.Command
+            }
+            s.RequestHash = t.idempotencyHash
+            saved = append(saved, s)
+        }
+        return true
+    })
+    data, err := json.Marshal(saved)
+    if err != nil {
+        return err
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if bytes.Equal(data, s.last) {
+        return nil
+    }
+    return s.write(data, time.Now().UnixNano())
+}
+
+// write replaces the task file with data and wakes the standbys waiting for
+// a new version. The caller holds s.mu.
+func (s *taskStore) write(data []byte, version int64) error {
+    if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+        return err
+    }
+    // Write then rename, so a crash never leaves a truncated task file.
+    tmp := s.path + ".tmp"
+    if err := os.WriteFile(tmp, data, 0o600); err != nil {
+        return err
+    }
+    if err := os.Rename(tmp, s.path); err != nil {
+        return err
+    }
+    s.last, s.version = data, version
+    close(s.changed)
+    s.changed = make(chan struct{})
+    return nil
+}
+
+// taskStoreLoop periodically saves the unfinished tasks.
+func (m *Manager) taskStoreLoop(ctx context.Context) {
+    ticker := time.NewTicker(taskStoreInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return // Shutdown saves them once the running tasks are done
+        case <-ticker.C:
+            if err := m.taskStore.save(m); err != nil {
+                slog.Error("Failed to save unfinished tasks", "error", err)
+            }
+        }
+    }
+}
+
+// restoreTasks brings back the tasks a previous process did not finish. They
+// are marked interrupted, or queued again with REQUEUE_INTERRUPTED. Waiting
+// tasks keep waiting if their dependencies are requeued as well.
+func (m *Manager) restoreTasks() error {
+    saved, err := m.taskStore.load()
+    if err != nil || len(saved) == 0 {
+        return err
+    }
+
+    var restored []*Task
+    for _, s := range saved {
+        t := s.Task
+        t.Command, t.InputMedia, t.ExtraInputs, t.OutputExt, t.OutputExts = s.Command, s.InputMedia, s.ExtraInputs, s.OutputExt, s.OutputExts
+        t.OutputEntry, t.ExtraFiles, t.SubtitlesOnly, t.ArtifactKind, t.Concat = s.OutputEntry, s.ExtraFiles, s.SubtitlesOnly, s.ArtifactKind, s.Concat
+        t.RetryBackoff, t.OutputTTL, t.inputFrom, t.maxRunning = s.RetryBackoff, s.OutputTTL, s.InputFrom, s.MaxRunning
+        if t.OutputUpload != nil {
+            t.OutputUpload.URL, t.OutputUpload.Headers = s.UploadURL, s.UploadHeaders
+        }
+        if t.Notify != nil {
+            t.Notify.Slack, t.Notify.Discord = s.NotifySlack, s.NotifyDiscord
+        }
+        if t.Pipe != nil {
+            t.Pipe.Command = s.PipeCommand
+        }
+        t.idempotencyHash = s.RequestHash
+        m.idemKeys.remember(t)
+        t.done = make(chan struct{})
+        t.metrics.Store(t.Metrics)
+        t.startTrace(trace.SpanContext{})
+        if _, err := m.queueFor(t.Queue); err != nil {
+            // Removed from the config: the default queue takes its tasks, so
+            // they can still be requeued, canceled and located.
+            slog.Warn("Queue of restored task no longer exists, using the default queue", "task_id", t.ID, "queue", t.Queue)
+            t.Queue = DefaultQueue
+        }
+
+        requeue := m.cfg.RequeueInterrupted
+        switch {
+        case t.Status == StatusWaiting && requeue:
+            // Released once its dependencies complete
+        case t.Status == StatusScheduled && requeue && t.ScheduledFor.After(time.Now()):
+            // Queued once due
+        case requeue:
+            t.Status, t.Error = StatusQueued, ""
+        default:
+            t.Status, t.Error = StatusInterrupted, "Interrupted by a server restart"
+        }
+        m.tasks.Store(t.ID, t)
+        restored = append(restored, t)
+    }
+
+    // Dependencies that finished before the restart are gone with their
+    // outputs, so tasks waiting on them (or on interrupted ones) cannot run.
+    for changed := true; changed; {
+        changed = false
+        for _, t := range restored {
+            if t.Status == StatusWaiting && !m.dependenciesPending(t) {
+                t.Status, t.Error = StatusInterrupted, "Interrupted by a server restart"
+                changed = true
+            }
+        }
+    }
+
+    for _, t := range restored {
+        switch t.Status {
+        case StatusQueued:
+            m.enqueue(t)
+            t.logger().Info("Requeued task after restart", "queue", t.Queue)
+        case StatusScheduled:
+            m.schedule(t)
+        case StatusInterrupted:
+            t.CompletedAt = time.Now()
+            m.adoptLog(t)
+            t.markDone()
+        }
+    }
+    slog.Info("Restored unfinished tasks", "tasks", len(restored), "requeue", m.cfg.RequeueInterrupted)
+    return nil
+}
+
+// dependenciesPending reports whether all dependencies of a restored task are
+// still to run.
+func (m *Manager) dependenciesPending(t *Task) bool {
+    for _, id := range t.DependsOn {
+        dep, ok := m.Get(id)
+        if !ok || (dep.Status != StatusQueued && dep.Status != StatusWaiting) {
+            return false
+        }
+    }
+    return true
+}
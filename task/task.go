@@ -2,32 +2,459 @@ package task
 
 import (
     "context"
+    "log/slog"
+    "reflect"
+    "sync"
+    "sync/atomic"
     "time"
+
+    "go.opentelemetry.io/otel/trace"
 )
 
 type Status string
 
 const (
-    StatusQueued     Status = "queued"
-    StatusProcessing Status = "processing"
-    StatusCompleted  Status = "completed"
-    StatusFailed     Status = "failed"
-    StatusCanceled   Status = "canceled"
+    StatusQueued                Status = "queued"
+    StatusProcessing            Status = "processing"
+    StatusCompleted             Status = "completed"
+    StatusCompletedWithWarnings Status = "completed_with_warnings" // Output produced but failed QC in QCModeWarn
+    StatusFailed                Status = "failed"
+    StatusCanceled              Status = "canceled"
+    StatusScheduled             Status = "scheduled"         // Held back until its scheduledFor time
+    StatusWaiting               Status = "waiting"           // Blocked until its dependencies complete
+    StatusWaitingResources      Status = "waiting_resources" // Turned away by the THROTTLE_* check; queued again after a backoff
+    StatusSkipped               Status = "skipped"           // Never ran because a dependency did not complete
+    StatusInterrupted           Status = "interrupted"       // Stopped by a server shutdown; saved for requeue
+)
+
+// IsTerminal reports whether a task in this state will not change anymore.
+func (s Status) IsTerminal() bool {
+    switch s {
+    case StatusCompleted, StatusCompletedWithWarnings, StatusFailed, StatusCanceled, StatusSkipped, StatusInterrupted:
+        return true
+    }
+    return false
+}
+
+// Succeeded reports whether a task in this state produced its outputs.
+func (s Status) Succeeded() bool {
+    return s == StatusCompleted || s == StatusCompletedWithWarnings
+}
+
+// Output modes: a single file (default) or a directory with an entry file
+// plus related files, e.g. an HLS playlist and its segments.
+const (
+    OutputModeFile      = "file"
+    OutputModeDirectory = "directory"
+)
+
+// Error codes of failed tasks, shown as "errorCode".
+const (
+    ErrorCodeOutputTooLarge = "output_too_large" // The outputs grew beyond the task's maximum output size
+)
+
+// CodedError is a task failure with a machine-readable code. Such failures
+// would recur on another attempt, so they are not retried.
+type CodedError struct {
+    Code string
+    Err  error
+}
+
+func (e *CodedError) Error() string { return e.Err.Error() }
+
+func (e *CodedError) Unwrap() error { return e.Err }
+
+// ProcessMetrics is a sample of the process tree of a running task: ffmpeg
+// and its children. CPUPercent is relative to one core.
+type ProcessMetrics struct {
+    SampledAt    time.Time `json:"sampledAt"`
+    Processes    int       `json:"processes"`
+    CPUPercent   float64   `json:"cpuPercent"`
+    RSSBytes     int64     `json:"rssBytes"`
+    PeakRSSBytes int64     `json:"peakRssBytes"`         // Highest RSSBytes of any sample so far
+    ReadBytes    int64     `json:"readBytes,omitempty"`  // Storage I/O; missing if the tree runs as another user
+    WriteBytes   int64     `json:"writeBytes,omitempty"`
+}
+
+// RenditionProgress reports how far one rendition of an adaptive bitrate task got.
+type RenditionProgress struct {
+    Name            string        `json:"name"`
+    Segments        int           `json:"segments"` // Media segments written so far
+    Progress        float64       `json:"progress"` // 0..1; estimated from the input duration
+    SegmentPattern  string        `json:"-"`        // Glob of the rendition's segments, relative to the output directory
+    SegmentDuration time.Duration `json:"-"`
+}
+
+// QC modes: verify outputs with ffprobe and either flag (warn) or fail tasks
+// whose output looks broken.
+const (
+    QCModeWarn = "warn"
+    QCModeFail = "fail"
+)
+
+// QCReport is the result of verifying a task's primary output.
+type QCReport struct {
+    Passed         bool     `json:"passed"`
+    Issues         []string `json:"issues,omitempty"`
+    InputDuration  float64  `json:"inputDuration,omitempty"`  // Seconds
+    OutputDuration float64  `json:"outputDuration,omitempty"` // Seconds
+    OutputStreams  []string `json:"outputStreams,omitempty"`  // Stream types, e.g. ["video", "audio"]
+    OutputBitrate  int64    `json:"outputBitrate,omitempty"`  // Bits per second
+    Target         string   `json:"target,omitempty"`         // Conformance target the output was checked against
+    Loudness       float64  `json:"loudness,omitempty"`       // Integrated LUFS; measured for targets with a loudness
+    TruePeak       float64  `json:"truePeak,omitempty"`       // dBTP; measured for targets with a true peak
+}
+
+// ConformanceTarget is what a delivery target, e.g. a social network or a
+// broadcaster, requires of an output. Outputs of tasks submitted for a target
+// are checked against it in QC; zero fields are not checked.
+type ConformanceTarget struct {
+    Name           string   `json:"name"`
+    VideoCodec     string   `json:"videoCodec,omitempty"`     // ffprobe codec name, e.g. "h264"
+    Profiles       []string `json:"profiles,omitempty"`       // Accepted ffprobe profiles, e.g. ["High", "Main"]
+    MaxLevel       int      `json:"maxLevel,omitempty"`       // ffprobe level, e.g. 41 for H.264 level 4.1
+    PixelFormat    string   `json:"pixelFormat,omitempty"`    // e.g. "yuv420p"
+    ColorPrimaries string   `json:"colorPrimaries,omitempty"` // e.g. "bt709"
+    Width          int      `json:"width,omitempty"`          // Exact frame size
+    Height         int      `json:"height,omitempty"`
+    MaxWidth       int      `json:"maxWidth,omitempty"`
+    MaxHeight      int      `json:"maxHeight,omitempty"`
+    FrameRate      float64  `json:"frameRate,omitempty"`      // Frames per second
+    AudioCodec     string   `json:"audioCodec,omitempty"`     // ffprobe codec name, e.g. "aac"
+    SampleRate     int      `json:"sampleRate,omitempty"`     // Hz
+    Channels       int      `json:"channels,omitempty"`
+    Loudness       float64  `json:"loudness,omitempty"`       // Integrated loudness in LUFS, within 1 LU
+    TruePeak       float64  `json:"truePeak,omitempty"`       // Maximum true peak in dBTP
+    MaxDuration    float64  `json:"maxDuration,omitempty"`    // Seconds
+}
+
+// Upload states of an OutputUpload.
+const (
+    UploadPending  = "pending"
+    UploadUploaded = "uploaded"
+    UploadFailed   = "failed"
 )
 
+// OutputUpload sends the primary output to the caller's storage, e.g. a
+// presigned S3 URL, once the task succeeded. URL and headers may carry
+// credentials, so only the outcome is shown to clients.
+type OutputUpload struct {
+    URL        string            `json:"-"`
+    Method     string            `json:"method"` // PUT or POST
+    Headers    map[string]string `json:"-"`
+    Status     string            `json:"status"` // UploadPending, UploadUploaded or UploadFailed
+    StatusCode int               `json:"statusCode,omitempty"`
+    Bytes      int64             `json:"bytes,omitempty"`
+    Error      string            `json:"error,omitempty"`
+    UploadedAt time.Time         `json:"uploadedAt,omitempty"`
+}
+
+// Pipe is the second stage of a piped task: another ffmpeg invocation that
+// reads what the task's command writes to stdout, e.g. to decode an exotic
+// input with one ffmpeg build and encode it with another.
+type Pipe struct {
+    Command    string `json:"-"`                    // Reads the first stage's output at ${INPUT_MEDIA} and writes the task's output
+    Build      string `json:"build,omitempty"`      // FF_BUILDS entry running the second stage; FF_BIN if empty
+    FirstBuild string `json:"firstBuild,omitempty"` // FF_BUILDS entry running the task's command; FF_BIN if empty
+}
+
+// Warning is a known ffmpeg warning found in a task's log, so clients can flag
+// suspect outputs without reading the raw log.
+type Warning struct {
+    Code    string `json:"code"`    // e.g. "non_monotonic_dts"
+    Message string `json:"message"` // First matching log line
+    Count   int    `json:"count"`   // Number of matching lines
+}
+
+// CodecSubstitution records an encoder the local ffmpeg lacked and the
+// fallback that was used instead.
+type CodecSubstitution struct {
+    Requested string `json:"requested"` // e.g. "libsvtav1"
+    Used      string `json:"used"`      // e.g. "libaom-av1"
+}
+
+// StreamLabel overrides the language, title or dispositions of an output
+// stream. Stream is an output stream specifier: an index ("1") or a type and
+// an index within it ("a:0", "s:1").
+type StreamLabel struct {
+    Stream   string `json:"stream"`
+    Language string `json:"language,omitempty"` // ISO 639-2 code, e.g. "eng"
+    Title    string `json:"title,omitempty"`
+    Default  *bool  `json:"default,omitempty"`
+    Forced   *bool  `json:"forced,omitempty"`
+}
+
 type Task struct {
-    ID           string    `json:"id"`
-    Status       Status    `json:"status"`
-    Command      string    `json:"-"` // Don't expose raw command
-    OutputExt    string    `json:"-"`
-    InputMedia   string    `json:"-"`
-    InputPath    string    `json:"-"` // Path to local temp input file
-    OutputPath   string    `json:"outputPath,omitempty"`
-    DownloadURL  string    `json:"downloadUrl,omitempty"`
-    Error        string    `json:"error,omitempty"`
-    CreatedAt    time.Time `json:"createdAt"`
-    StartedAt    time.Time `json:"startedAt,omitempty"`
-    CompletedAt  time.Time `json:"completedAt,omitempty"`
-    FFMpegOutput string    `json:"ffmpegOutput,omitempty"` // Stderr from ffmpeg
-    cancelFunc   context.CancelFunc
+    ID               string              `json:"id"`
+    Status           Status              `json:"status"`
+    Priority         Priority            `json:"priority"`
+    Queue            string              `json:"queue"`                   // Named queue the task runs in
+    QueuePosition    int                 `json:"queuePosition,omitempty"` // Filled in on status requests while queued
+    Tool             string              `json:"tool,omitempty"`          // Binary the command runs; ffmpeg if empty
+    ResourceClass    string              `json:"resourceClass,omitempty"` // RESOURCE_CLASSES entry capping the command's CPU and memory
+    Requires         []string            `json:"requires,omitempty"`      // Node labels the task needs
+    Command          string              `json:"-"`                       // Don't expose raw command
+    OutputExt        string              `json:"-"`
+    InputID          string              `json:"inputId,omitempty"`       // Set for tasks reading an uploaded input
+    InputMedia       string              `json:"-"`
+    InputPath        string              `json:"-"`                       // Path to local temp input file
+    InputDigest      string              `json:"inputDigest,omitempty"`   // SHA-256 of the input's content; set with DEDUPE_TASKS
+    Concat           bool                `json:"-"`                       // Joins the input and ExtraInputs in order; the runner picks the method
+    ConcatMethod     string              `json:"concatMethod,omitempty"`  // "demuxer" (stream copy) or "filter" (re-encode); set once a concat task ran
+    ExtraInputs      []string            `json:"-"`                       // Further inputs of server-built commands, at ${INPUT_MEDIA_1} and up
+    OutputExts       []string            `json:"-"`                       // Set for multi-output tasks (${OUTPUT_n})
+    OutputMode       string              `json:"outputMode,omitempty"`
+    OutputDir        string              `json:"-"`                       // Published output directory in directory mode
+    OutputEntry      string              `json:"-"`                       // Main file of a directory output; index.<ext> if empty
+    Renditions       []RenditionProgress `json:"renditions,omitempty"`    // Per-rendition progress of ABR tasks
+    Metrics          *ProcessMetrics     `json:"metrics,omitempty"`       // Latest process tree sample as of a snapshot or the end of an attempt; kept after the task ends
+    Percent          float64             `json:"percent,omitempty"`       // 0..100 of the media processed; set while processing if the input's duration is known
+    ETA              float64             `json:"eta,omitempty"`           // Estimated seconds until processing finishes
+    ExtraFiles       map[string][]byte   `json:"-"`                       // Written into the output directory once ffmpeg succeeded
+    CallbackURL      string              `json:"callbackUrl,omitempty"`
+    OutputUpload     *OutputUpload       `json:"outputUpload,omitempty"`  // Where the output is sent once ffmpeg succeeded
+    Notify           *Notify             `json:"notify,omitempty"`        // Chat and email notifications; the NOTIFY_* settings if nil
+    OutputStore      string              `json:"outputStore,omitempty"`   // Registered output store the files are put into; empty for the local one
+    Pipe             *Pipe               `json:"pipe,omitempty"`          // Second stage fed by the command's stdout
+    MaxOutputSize    int64               `json:"maxOutputSize,omitempty"` // Bytes the outputs may grow to before ffmpeg is killed; MAX_OUTPUT_SIZE if 0
+    StreamLabels     []StreamLabel       `json:"streamLabels,omitempty"`  // Applied after the labels carried over from the input
+    SkipStreamLabels bool                `json:"skipStreamLabels,omitempty"` // Input stream labels are left to ffmpeg's defaults
+    OutputName       string              `json:"outputName,omitempty"`    // File name offered to downloaders
+    Subtitles        string              `json:"subtitles,omitempty"`     // "srt" or "vtt" if subtitles are generated via speech-to-text
+    SubtitleLanguage string              `json:"subtitleLanguage,omitempty"`
+    SubtitlesOnly    bool                `json:"-"`                       // The subtitles are the primary output
+    SubtitlePath     string              `json:"-"`
+    SubtitleURL      string              `json:"subtitleUrl,omitempty"`
+    BatchID          string              `json:"batchId,omitempty"`       // Set for tasks enqueued by a manifest import or submitted as a graph
+    IdempotencyKey   string              `json:"idempotencyKey,omitempty"` // Idempotency-Key the task was submitted with
+    idempotencyHash  string              // Identifies the request that submitted it
+    ScheduleID       string              `json:"scheduleId,omitempty"`    // Set for the runs of a recurring schedule
+    Preset           string              `json:"preset,omitempty"`        // Preset the command was built from
+    Billing          bool                `json:"billing,omitempty"`       // Recorded as a billing line item once finished
+    Owner            string              `json:"owner,omitempty"`         // API key that submitted the task; "jwt:<sub>" for JWTs
+    Artifacts        []*Artifact         `json:"artifacts,omitempty"`
+    RequestID        string              `json:"requestId,omitempty"`     // X-Request-ID of the submitting request
+    TraceID          string              `json:"traceId,omitempty"`
+    ArtifactKind     string              `json:"-"`                       // Kind of the output artifacts; "output" if empty
+    OutputPath       string              `json:"outputPath,omitempty"`    // Path of the primary output artifact
+    DownloadURL      string              `json:"downloadUrl,omitempty"`
+    InlineResult     bool                `json:"inlineResult,omitempty"`
+    ResultData       string              `json:"resultData,omitempty"`    // Primary output as a data: URI if InlineResult is set and it is small enough
+    OutputPaths      []string            `json:"outputPaths,omitempty"`   // One per entry of OutputExts
+    DownloadURLs     []string            `json:"downloadUrls,omitempty"`
+    Error            string              `json:"error,omitempty"`
+    ErrorCode        string              `json:"errorCode,omitempty"`     // Machine-readable cause of some failures, e.g. ErrorCodeOutputTooLarge
+    QC               string              `json:"qc,omitempty"`            // QCModeWarn or QCModeFail to verify the output
+    QCReport         *QCReport           `json:"qcReport,omitempty"`
+    Target           *ConformanceTarget  `json:"target,omitempty"`        // Checked in QC
+    Warnings         []Warning           `json:"warnings,omitempty"`      // Known ffmpeg warnings of a completed run
+    CodecSubstitutions []CodecSubstitution `json:"codecSubstitutions,omitempty"` // Encoders replaced by the hardware profile or because ffmpeg lacks them
+    HWProfile        string              `json:"hwProfile,omitempty"`     // HW_PROFILES entry applied to the command, e.g. "nvidia"
+    MediaDuration    time.Duration       `json:"-"`                       // Media time ffmpeg processed, for stats
+    CPUSeconds       float64             `json:"cpuSeconds,omitempty"`    // ffmpeg CPU time over all attempts
+    Attempt          int                 `json:"attempt"`                 // Number of times the task has been started
+    MaxRetries       int                 `json:"maxRetries,omitempty"`
+    RetryBackoff     time.Duration       `json:"-"`
+    LastError        string              `json:"lastError,omitempty"`     // Error of the most recent failed attempt
+    CreatedAt        time.Time           `json:"createdAt"`
+    ScheduledFor     time.Time           `json:"scheduledFor,omitempty"`  // Not queued before then (runAt or delay)
+    StartedAt        time.Time           `json:"startedAt,omitempty"`
+    CompletedAt      time.Time           `json:"completedAt,omitempty"`
+    OutputTTL        time.Duration       `json:"-"`                       // Retention of all artifacts, overriding the configured ones if set
+    ExpiresAt        time.Time           `json:"expiresAt,omitempty"`     // When the primary output is deleted; set once the task is terminal
+    FFMpegOutput     string              `json:"ffmpegOutput,omitempty"`  // Last LOG_BUFFER_SIZE of ffmpeg's stderr; the whole log is the "log" artifact
+    DedupedFrom      string              `json:"dedupedFrom,omitempty"`   // Identical task whose output was reused instead of running ffmpeg
+    PipeOutput       string              `json:"pipeOutput,omitempty"`    // Same for the second stage of a piped task, whose log is "pipe_log"
+    Lane             string              `json:"lane,omitempty"`          // "fast" for sync calls served by the low-latency pool
+    PipelineID       string              `json:"pipelineId,omitempty"`
+    RetryOf          string              `json:"retryOf,omitempty"`       // Task this one runs again, for tasks submitted by a retry
+    DependsOn        []string            `json:"dependsOn,omitempty"`     // Tasks that must complete before this one is queued
+    inputFrom        string              // Task whose output becomes this task's input
+    dedupe           *dedupeIndex        // Set while processing with DEDUPE_TASKS
+    dedupeKey        string              // Set by FindDuplicate
+    cancelFunc       context.CancelFunc
+    queuedAt         time.Time           // First time the task entered the queue
+    queueWait        time.Duration       // Time until its first attempt started
+    maxRunning       int                 // Owner's MaxRunning quota
+    cpuBooked        float64             // Part of CPUSeconds already counted as usage
+    interrupted      bool                // Canceled by Shutdown rather than by the user
+    resourceWaits    int                 // Times the THROTTLE_* check turned the task away in a row
+    resourceWaitSince time.Time           // When it first did
+    metrics          atomic.Pointer[ProcessMetrics] // Latest process tree sample, replaced while the task runs
+    mu               sync.Mutex          // Held to change the exported fields, cancelFunc and interrupted once the task is shared
+    done             chan struct{}       // Closed once the task reaches a terminal state
+    doneOnce         sync.Once
+    span             trace.Span          // Root span, ended in markDone
+    queueSpan        trace.Span
+}
+
+// Done returns a channel that is closed when the task finishes, fails or is canceled.
+func (t *Task) Done() <-chan struct{} {
+    return t.done
+}
+
+// status returns the task's state. Workers, cancellations and the scheduler
+// change it concurrently, so goroutines other than the one running the task
+// read it through here.
+func (t *Task) status() Status {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    return t.Status
+}
+
+// setStatus moves the task to a state.
+func (t *Task) setStatus(s Status) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.Status = s
+}
+
+// transition moves the task from one state to another, and reports false if
+// it was not in the first, e.g. because it was canceled meanwhile.
+func (t *Task) transition(from, to Status) bool {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if t.Status != from {
+        return false
+    }
+    t.Status = to
+    return true
+}
+
+// start moves the task to processing for a new attempt, which cancel stops,
+// and reports false if it was canceled while queued.
+func (t *Task) start(cancel context.CancelFunc) bool {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if t.Status == StatusCanceled {
+        return false
+    }
+    t.Status = StatusProcessing
+    t.cancelFunc = cancel
+    t.Attempt++
+    t.StartedAt = time.Now()
+    return true
+}
+
+// hold moves the task to a state after an attempt failed with err. The
+// attempt counts towards MaxRetries unless it never got to run.
+func (t *Task) hold(s Status, err error, counted bool) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.Status = s
+    t.LastError = err.Error()
+    if !counted {
+        t.Attempt--
+    }
+}
+
+// interrupt stops the task if it is processing, marking it as interrupted by a
+// shutdown rather than canceled by the user.
+func (t *Task) interrupt() {
+    t.mu.Lock()
+    cancel := t.cancelFunc
+    if t.Status != StatusProcessing || cancel == nil {
+        t.mu.Unlock()
+        return
+    }
+    t.interrupted = true
+    t.mu.Unlock()
+    cancel()
+}
+
+// isInterrupted reports whether a shutdown stopped the task.
+func (t *Task) isInterrupted() bool {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    return t.interrupted
+}
+
+// finish puts the task in a terminal state. A non-empty reason becomes its
+// Error, and the error of a failed last attempt, if any, its LastError.
+func (t *Task) finish(s Status, reason string, err error) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.Status = s
+    if reason != "" {
+        t.Error = reason
+    }
+    if err != nil {
+        t.LastError = err.Error()
+    }
+    t.CompletedAt = time.Now()
+}
+
+// Update runs f with the task's lock held. Runners change the exported fields
+// of a running task in f, as handlers take snapshots of it meanwhile; the
+// goroutine running the task may read them without the lock. f must not call
+// methods of the task that take the lock themselves.
+func (t *Task) Update(f func()) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    f()
+}
+
+// Snapshot returns a copy of the task's exported fields as they are now, for
+// responses that fill in request-specific fields such as download URLs. What
+// runners change in place, the artifacts, renditions and output upload, is
+// copied as well, and Metrics is the latest sample.
+func (t *Task) Snapshot() *Task {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    c := &Task{}
+    src, dst := reflect.ValueOf(t).Elem(), reflect.ValueOf(c).Elem()
+    for i := 0; i < src.NumField(); i++ {
+        if src.Type().Field(i).IsExported() {
+            dst.Field(i).Set(src.Field(i))
+        }
+    }
+    c.Artifacts = nil
+    for _, a := range t.Artifacts {
+        copied := *a
+        c.Artifacts = append(c.Artifacts, &copied)
+    }
+    c.Renditions = append([]RenditionProgress(nil), t.Renditions...)
+    if t.OutputUpload != nil {
+        upload := *t.OutputUpload
+        c.OutputUpload = &upload
+    }
+    c.Metrics = t.metrics.Load()
+    c.done = t.done
+    return c
+}
+
+// SetMetrics replaces the latest process tree sample. Runners sample from
+// their own goroutine while handlers read the task, so it is kept in an
+// atomic pointer and reaches Metrics through Snapshot and keepMetrics.
+func (t *Task) SetMetrics(m *ProcessMetrics) {
+    t.metrics.Store(m)
+}
+
+// LatestMetrics returns the latest process tree sample, or nil if there is none.
+func (t *Task) LatestMetrics() *ProcessMetrics {
+    return t.metrics.Load()
+}
+
+// keepMetrics copies the latest sample into Metrics once an attempt ended,
+// so events, the task store and the history carry it.
+func (t *Task) keepMetrics() {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.Metrics = t.metrics.Load()
+}
+
+// logger returns a logger tagging lines with the task (and submitting request).
+func (t *Task) logger() *slog.Logger {
+    logger := slog.Default().With("task_id", t.ID)
+    if t.RequestID != "" {
+        logger = logger.With("request_id", t.RequestID)
+    }
+    return logger
+}
+
+func (t *Task) markDone() {
+    t.doneOnce.Do(func() {
+        t.endTrace()
+        close(t.done)
+    })
 }
@@ -0,0 +1,64 @@
+package task
+
+import (
+    "context"
+    "time"
+
+    "ffwebapi/tracing"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/trace"
+)
+
+// startTrace opens the root span of a task, as a child of the submitting
+// request's span if there is one. It ends in markDone.
+func (t *Task) startTrace(parent trace.SpanContext) {
+    ctx := trace.ContextWithSpanContext(context.Background(), parent)
+    _, t.span = tracing.Tracer().Start(ctx, "task", trace.WithAttributes(
+        attribute.String("task.id", t.ID),
+        attribute.String("task.priority", string(t.Priority)),
+        attribute.String("task.output_ext", t.OutputExt),
+    ))
+    t.TraceID = tracing.TraceID(t.span.SpanContext())
+}
+
+// SpanContext returns the span context of the task's root span.
+func (t *Task) SpanContext() trace.SpanContext {
+    if t.span == nil {
+        return trace.SpanContext{}
+    }
+    return t.span.SpanContext()
+}
+
+// endTrace closes the task's spans with its final status.
+func (t *Task) endTrace() {
+    t.endQueueWait()
+    if t.span == nil {
+        return
+    }
+    t.span.SetAttributes(attribute.String("task.status", string(t.Status)), attribute.Int("task.attempts", t.Attempt))
+    if !t.Status.Succeeded() {
+        t.span.SetStatus(codes.Error, t.Error)
+    }
+    t.span.End()
+}
+
+func (t *Task) endQueueWait() {
+    if t.queueSpan != nil {
+        t.queueSpan.End()
+        t.queueSpan = nil
+    }
+}
+
+// enqueue puts a task into the queue; the time until a worker picks it up is
+// traced as queue wait.
+func (m *Manager) enqueue(t *Task) {
+    if t.queuedAt.IsZero() {
+        t.queuedAt = time.Now()
+    }
+    if t.span != nil {
+        ctx := trace.ContextWithSpan(context.Background(), t.span)
+        _, t.queueSpan = tracing.Tracer().Start(ctx, "queue.wait", trace.WithAttributes(attribute.Int("task.attempt", t.Attempt+1)))
+    }
+    m.queues[t.Queue].tasks.push(t)
+}
@@ -0,0 +1,188 @@
+package task
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "io"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+    "time"
+)
+
+// TransformResult is the outcome of a read-through transform.
+type TransformResult struct {
+    Key    string // Identifies the result, e.g. for ETags
+    Path   string // Output file to serve; empty if the task failed or is still running
+    Cached bool   // Served from the cache without running ffmpeg
+    Task   *Task  // Task that produced (or is producing) the result; nil for cache hits
+}
+
+// transformCall is a transform in progress, shared by concurrent requests for
+// the same result.
+type transformCall struct {
+    done chan struct{}
+    task *Task
+    path string // Cached output once done; empty on failure
+}
+
+// transformCache keeps the outputs of read-through transforms in
+// TempDir/transform_cache, named after a hash of the command, source and
+// output extension.
+type transformCache struct {
+    mu       sync.Mutex
+    inflight map[string]*transformCall // By key
+}
+
+func newTransformCache() *transformCache {
+    return &transformCache{inflight: make(map[string]*transformCall)}
+}
+
+func transformKey(command, src, outputExt string) string {
+    sum := sha256.Sum256([]byte(command + "\x00" + src + "\x00" + outputExt))
+    return hex.EncodeToString(sum[:16])
+}
+
+// transformDir is where results are cached; empty if caching is off.
+func (m *Manager) transformDir() string {
+    if m.cfg.TransformCacheTTL <= 0 || m.cfg.TempDir == "" {
+        return ""
+    }
+    return filepath.Join(m.cfg.TempDir, "transform_cache")
+}
+
+// Transform runs command on src like RunSync, reusing a result cached within
+// TRANSFORM_CACHE_TTL. Concurrent calls for the same result share one task.
+// If ctx is done first the task keeps running and its result is cached for
+// later calls.
+func (m *Manager) Transform(ctx context.Context, command, src, outputExt string, opts SubmitOptions) (*TransformResult, error) {
+    key := transformKey(command, src, outputExt)
+    dir := m.transformDir()
+    path := filepath.Join(dir, key+"."+outputExt)
+    if dir != "" {
+        if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) < m.cfg.TransformCacheTTL {
+            return &TransformResult{Key: key, Path: path, Cached: true}, nil
+        }
+    }
+
+    tc := m.transforms
+    tc.mu.Lock()
+    call, running := tc.inflight[key]
+    if !running {
+        call = &transformCall{done: make(chan struct{})}
+        tc.inflight[key] = call
+    }
+    tc.mu.Unlock()
+
+    if !running {
+        t, err := m.RunSync(ctx, command, src, outputExt, opts)
+        if err != nil {
+            tc.mu.Lock()
+            delete(tc.inflight, key)
+            tc.mu.Unlock()
+            close(call.done)
+            return nil, err
+        }
+        tc.mu.Lock()
+        call.task = t
+        tc.mu.Unlock()
+        go m.finishTransform(key, dir, path, call)
+    }
+
+    select {
+    case <-call.done:
+    case <-ctx.Done():
+    }
+    tc.mu.Lock()
+    defer tc.mu.Unlock()
+    return &TransformResult{Key: key, Path: call.path, Task: call.task}, nil
+}
+
+// finishTransform waits for a transform's task and caches its output.
+func (m *Manager) finishTransform(key, dir, path string, call *transformCall) {
+    <-call.task.Done()
+    result := ""
+    if call.task.Status.Succeeded() && call.task.OutputPath != "" {
+        result = call.task.OutputPath
+        if dir != "" {
+            if err := copyFile(call.task.OutputPath, path); err != nil {
+                slog.Error("Failed to cache transform result", "task_id", call.task.ID, "error", err)
+            } else {
+                result = path
+            }
+        }
+    }
+
+    m.transforms.mu.Lock()
+    call.path = result
+    delete(m.transforms.inflight, key)
+    m.transforms.mu.Unlock()
+    close(call.done)
+}
+
+// copyFile copies src to dst through a temporary file, so readers never see a
+// partial result.
+func copyFile(src, dst string) error {
+    if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+        return err
+    }
+    in, err := os.Open(src)
+    if err != nil {
+        return err
+    }
+    defer in.Close()
+    tmp, err := os.CreateTemp(filepath.Dir(dst), ".tmp_*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    if _, err := io.Copy(tmp, in); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), dst)
+}
+
+// pruneTransforms removes cached results older than TRANSFORM_CACHE_TTL, then
+// the oldest ones until the cache fits TRANSFORM_CACHE_MAX_SIZE.
+func (m *Manager) pruneTransforms(now time.Time) {
+    dir := m.transformDir()
+    if dir == "" {
+        return
+    }
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        return
+    }
+    var kept []os.FileInfo
+    var size int64
+    for _, e := range entries {
+        info, err := e.Info()
+        if err != nil || info.IsDir() {
+            continue
+        }
+        if now.Sub(info.ModTime()) >= m.cfg.TransformCacheTTL {
+            os.Remove(filepath.Join(dir, e.Name()))
+            continue
+        }
+        kept = append(kept, info)
+        size += info.Size()
+    }
+    if m.cfg.TransformCacheSize <= 0 {
+        return
+    }
+    sort.Slice(kept, func(i, j int) bool { return kept[i].ModTime().Before(kept[j].ModTime()) })
+    for _, info := range kept {
+        if size <= m.cfg.TransformCacheSize {
+            break
+        }
+        os.Remove(filepath.Join(dir, info.Name()))
+        size -= info.Size()
+    }
+}
@@ -0,0 +1,160 @@
+package task
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+
+    "ffwebapi/config"
+)
+
+// Usage is what one owner (API key) currently uses, for quota checks.
+type Usage struct {
+    Owner        string  `json:"owner"`
+    Running      int     `json:"running"`      // Tasks processing right now
+    Queued       int     `json:"queued"`       // Tasks waiting in a queue, for a dependency or for their scheduled time
+    StorageBytes int64   `json:"storageBytes"` // Outputs held on the server
+    Month        string  `json:"month"`        // e.g. "2024-05" (UTC)
+    CPUSeconds   float64 `json:"cpuSeconds"`   // ffmpeg CPU time consumed this month
+}
+
+// usageTracker counts the running tasks and monthly CPU time of each owner.
+// CPU time is persisted in DATA_DIR, so it survives restarts.
+type usageTracker struct {
+    path    string // File CPU time is persisted to; empty to keep it in memory only
+    mu      sync.Mutex
+    running map[string]int                // By owner
+    cpu     map[string]map[string]float64 // Seconds by month, then owner
+    dirty   bool                          // CPU time changed since the last flush
+}
+
+func newUsageTracker(cfg *config.Config) (*usageTracker, error) {
+    u := &usageTracker{running: make(map[string]int), cpu: make(map[string]map[string]float64)}
+    if cfg.DataDir == "" {
+        return u, nil
+    }
+    u.path = filepath.Join(cfg.DataDir, "usage.json")
+    data, err := os.ReadFile(u.path)
+    if errors.Is(err, os.ErrNotExist) {
+        return u, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    if err := json.Unmarshal(data, &u.cpu); err != nil {
+        return nil, fmt.Errorf("could not read %s: %w", u.path, err)
+    }
+    return u, nil
+}
+
+func monthOf(t time.Time) string {
+    return t.UTC().Format("2006-01")
+}
+
+// tryClaim counts a task as running unless its owner already runs
+// t.maxRunning tasks.
+func (u *usageTracker) tryClaim(t *Task) bool {
+    u.mu.Lock()
+    defer u.mu.Unlock()
+    if t.maxRunning > 0 && u.running[t.Owner] >= t.maxRunning {
+        return false
+    }
+    u.running[t.Owner]++
+    return true
+}
+
+// release ends a claim once an attempt is over and books the CPU time the
+// attempt added.
+func (u *usageTracker) release(t *Task, now time.Time) {
+    u.mu.Lock()
+    defer u.mu.Unlock()
+    if u.running[t.Owner]--; u.running[t.Owner] <= 0 {
+        delete(u.running, t.Owner)
+    }
+    if spent := t.CPUSeconds - t.cpuBooked; spent > 0 {
+        month := monthOf(now)
+        if u.cpu[month] == nil {
+            u.cpu[month] = make(map[string]float64)
+        }
+        u.cpu[month][t.Owner] += spent
+        t.cpuBooked = t.CPUSeconds
+        u.dirty = true
+    }
+}
+
+// flush writes the CPU time to disk if it changed. Only the current and the
+// previous month are kept.
+func (u *usageTracker) flush(now time.Time) error {
+    u.mu.Lock()
+    defer u.mu.Unlock()
+    for month := range u.cpu {
+        if month != monthOf(now) && month != monthOf(now.AddDate(0, 0, -now.Day())) {
+            delete(u.cpu, month)
+            u.dirty = true
+        }
+    }
+    if u.path == "" || !u.dirty {
+        return nil
+    }
+    data, err := json.Marshal(u.cpu)
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(u.path), 0o700); err != nil {
+        return err
+    }
+    // Write then rename, so a crash never leaves a truncated usage file.
+    tmp := u.path + ".tmp"
+    if err := os.WriteFile(tmp, data, 0o600); err != nil {
+        return err
+    }
+    if err := os.Rename(tmp, u.path); err != nil {
+        return err
+    }
+    u.dirty = false
+    return nil
+}
+
+// Usage reports the running and queued tasks, held output storage and this
+// month's CPU time of an owner.
+func (m *Manager) Usage(owner string, now time.Time) Usage {
+    usage := Usage{Owner: owner, Month: monthOf(now)}
+    m.usage.mu.Lock()
+    usage.Running = m.usage.running[owner]
+    usage.CPUSeconds = m.usage.cpu[usage.Month][owner]
+    m.usage.mu.Unlock()
+
+    m.tasks.Range(func(key, value interface{}) bool {
+        t := value.(*Task)
+        if t.Owner != owner {
+            return true
+        }
+        if status := t.status(); status == StatusQueued || status == StatusWaiting || status == StatusWaitingResources || status == StatusScheduled {
+            usage.Queued++
+        }
+        usage.StorageBytes += storedBytes(t)
+        return true
+    })
+    return usage
+}
+
+// storedBytes is the size of the outputs a task holds on the server.
+func storedBytes(t *Task) int64 {
+    var size int64
+    // Artifacts of a directory output share (and each report) its size.
+    dirs := make(map[string]bool)
+    for _, a := range t.Artifacts {
+        if a.Dir != "" {
+            if dirs[a.Dir] {
+                continue
+            }
+            dirs[a.Dir] = true
+        }
+        size += a.Size
+    }
+    return size
+}
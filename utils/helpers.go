@@ -1,5 +1,55 @@
+// Package utils holds small helpers shared across packages that don't
+// warrant a dependency of their own.
 package utils
 
-// This package is reserved for utility functions.
-// For this project, we used external libraries for tasks like
-// generating short UUIDs, so this file is currently empty.
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// MediaKind is the coarse family of a media file, derived from its extension.
+type MediaKind string
+
+const (
+	MediaKindVideo MediaKind = "video"
+	MediaKindAudio MediaKind = "audio"
+	MediaKindImage MediaKind = "image"
+)
+
+var mediaKinds = map[string]MediaKind{
+	"mp3": MediaKindAudio, "aac": MediaKindAudio, "m4a": MediaKindAudio, "opus": MediaKindAudio,
+	"ogg": MediaKindAudio, "oga": MediaKindAudio, "flac": MediaKindAudio, "wav": MediaKindAudio,
+	"jpg": MediaKindImage, "jpeg": MediaKindImage, "png": MediaKindImage, "webp": MediaKindImage,
+	"gif": MediaKindImage, "bmp": MediaKindImage, "avif": MediaKindImage,
+}
+
+// MediaKindOf classifies an output extension (with or without a leading dot).
+// Anything not known to be audio or an image is treated as video.
+func MediaKindOf(ext string) MediaKind {
+	if kind, ok := mediaKinds[strings.ToLower(strings.TrimPrefix(ext, "."))]; ok {
+		return kind
+	}
+	return MediaKindVideo
+}
+
+// mediaContentTypes covers streaming and subtitle formats that Go's MIME table
+// doesn't know (or maps to something players reject, like .ts as TypeScript).
+var mediaContentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".m4s":  "video/iso.segment",
+	".mpd":  "application/dash+xml",
+	".vtt":  "text/vtt; charset=utf-8",
+	".srt":  "application/x-subrip; charset=utf-8",
+	".log":  "text/plain; charset=utf-8",
+}
+
+// ContentTypeOf returns the MIME type of a file by its extension, or "" if unknown.
+func ContentTypeOf(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ct, ok := mediaContentTypes[ext]; ok {
+		return ct
+	}
+	return mime.TypeByExtension(ext)
+}
@@ -0,0 +1,241 @@
+// Package watch turns folders into submission queues: media files dropped
+// into a WATCH_FOLDERS folder are submitted with the folder's preset, and the
+// source moved aside once the task is done.
+package watch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"ffwebapi/config"
+	"ffwebapi/preset"
+	"ffwebapi/task"
+)
+
+// Subfolders of a watch folder.
+const (
+	ProcessedDir = "processed" // Sources of completed tasks
+	FailedDir    = "failed"    // Sources of failed tasks, each with a .error.txt
+	OutputDir    = "output"    // Copies of the outputs
+)
+
+// Watcher scans the watch folders and submits the files that appeared there.
+type Watcher struct {
+	tm       *task.Manager
+	interval time.Duration
+	folders  []*folder
+
+	mu      sync.Mutex
+	pending map[string]fileState // Files seen in the last scan, not yet submitted
+	running map[string]bool      // Files whose task has not finished yet
+}
+
+type folder struct {
+	dir    string
+	preset preset.Preset
+}
+
+// fileState tells whether a file is still being written.
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// New checks WATCH_FOLDERS and creates the subfolders of each folder. It
+// returns nil if no folders are configured.
+func New(cfg *config.Config, tm *task.Manager) (*Watcher, error) {
+	if len(cfg.WatchFolders) == 0 {
+		return nil, nil
+	}
+	w := &Watcher{tm: tm, interval: cfg.WatchInterval, pending: map[string]fileState{}, running: map[string]bool{}}
+	if w.interval <= 0 {
+		w.interval = 10 * time.Second
+	}
+	for name, dir := range cfg.WatchFolders {
+		p, ok := preset.Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("WATCH_FOLDERS: unknown preset %q", name)
+		}
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, fmt.Errorf("WATCH_FOLDERS: %w", err)
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("WATCH_FOLDERS: %s is not a directory", dir)
+		}
+		for _, sub := range []string{ProcessedDir, FailedDir, OutputDir} {
+			if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+				return nil, fmt.Errorf("WATCH_FOLDERS: %w", err)
+			}
+		}
+		w.folders = append(w.folders, &folder{dir: dir, preset: p})
+	}
+	return w, nil
+}
+
+// Run scans the folders every WATCH_INTERVAL until ctx is done. A standby
+// leaves the folders to its primary.
+func (w *Watcher) Run(ctx context.Context) {
+	for _, f := range w.folders {
+		slog.Info("Watching folder", "dir", f.dir, "preset", f.preset.Name)
+	}
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !w.tm.IsStandby() {
+			w.scan(ctx)
+		}
+	}
+}
+
+// scan submits the files of every folder that did not change since the
+// previous scan, so files still being copied in are left alone.
+func (w *Watcher) scan(ctx context.Context) {
+	seen := map[string]fileState{}
+	for _, f := range w.folders {
+		entries, err := os.ReadDir(f.dir)
+		if err != nil {
+			slog.Error("Could not read watch folder", "dir", f.dir, "error", err)
+			continue
+		}
+		for _, e := range entries {
+			// Subfolders and hidden files, e.g. of a copy in progress, are skipped.
+			if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			path := filepath.Join(f.dir, e.Name())
+			state := fileState{size: info.Size(), modTime: info.ModTime()}
+			w.mu.Lock()
+			last, known := w.pending[path]
+			running := w.running[path]
+			w.mu.Unlock()
+			switch {
+			case running:
+			case known && last.size == state.size && last.modTime.Equal(state.modTime):
+				w.submit(ctx, f, path)
+			default:
+				seen[path] = state
+			}
+		}
+	}
+	w.mu.Lock()
+	w.pending = seen
+	w.mu.Unlock()
+}
+
+// submit runs the folder's preset on a file and files the result away once
+// the task finishes.
+func (w *Watcher) submit(ctx context.Context, f *folder, path string) {
+	name := filepath.Base(path)
+	outputName := strings.TrimSuffix(name, filepath.Ext(name)) + "." + f.preset.OutputExt
+	t, err := w.tm.SubmitWithOptions(f.preset.Command, path, f.preset.OutputExt, task.SubmitOptions{Preset: f.preset.Name, OutputName: outputName})
+	if err != nil {
+		// Submission errors such as a full queue are retried at the next scan.
+		slog.Warn("Could not submit watched file", "path", path, "error", err)
+		return
+	}
+	slog.Info("Watched file submitted", "path", path, "task_id", t.ID, "preset", f.preset.Name)
+	w.mu.Lock()
+	w.running[path] = true
+	w.mu.Unlock()
+
+	go func() {
+		select {
+		case <-t.Done():
+		case <-ctx.Done():
+			return // Left in the folder, so it is submitted again at the next start
+		}
+		w.finish(f, path, outputName, t)
+		w.mu.Lock()
+		delete(w.running, path)
+		w.mu.Unlock()
+	}()
+}
+
+// finish copies the output of a finished task into the output folder and
+// moves the source to processed/, or to failed/ with the reason.
+func (w *Watcher) finish(f *folder, path, outputName string, t *task.Task) {
+	name := filepath.Base(path)
+	logger := slog.With("path", path, "task_id", t.ID)
+	failure := t.Error
+	if t.Status.Succeeded() {
+		if err := copyFile(t.OutputPath, freeName(filepath.Join(f.dir, OutputDir), outputName)); err != nil {
+			failure = fmt.Sprintf("could not copy output: %v", err)
+		}
+	} else if failure == "" {
+		failure = "task " + string(t.Status)
+	}
+
+	dest := ProcessedDir
+	if failure != "" {
+		dest = FailedDir
+	}
+	target := freeName(filepath.Join(f.dir, dest), name)
+	if err := os.Rename(path, target); err != nil {
+		logger.Error("Could not move watched file", "error", err)
+		return
+	}
+	if failure != "" {
+		if err := os.WriteFile(target+".error.txt", []byte(failure+"\n"), 0o644); err != nil {
+			logger.Error("Could not write error file", "error", err)
+		}
+		logger.Warn("Watched file failed", "error", failure)
+		return
+	}
+	logger.Info("Watched file processed")
+}
+
+// copyFile copies src to dst via a temporary file, so the output folder
+// never shows a partial copy.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".part")
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// freeName returns a path for name in dir that is not taken yet, numbering
+// it like "clip (2).mp4" if needed.
+func freeName(dir, name string) string {
+	path := filepath.Join(dir, name)
+	ext := filepath.Ext(name)
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext))
+	}
+}
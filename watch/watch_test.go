@@ -0,0 +1,92 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ffwebapi/config"
+	"ffwebapi/task"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fileRunner writes the input's name as the output, and fails on inputs
+// named "broken".
+type fileRunner struct {
+	dir string
+}
+
+func (r *fileRunner) Run(ctx context.Context, t *task.Task) (string, error) {
+	if strings.Contains(t.InputMedia, "broken") {
+		return "", errors.New("invalid data found when processing input")
+	}
+	t.OutputPath = filepath.Join(r.dir, t.ID+"."+t.OutputExt)
+	return "", os.WriteFile(t.OutputPath, []byte(filepath.Base(t.InputMedia)), 0o600)
+}
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{MaxConcurrency: 1, FFTimeout: 10 * time.Second, WatchFolders: map[string]string{"h264-720p": dir}}
+	tm, err := task.NewManager(cfg, &fileRunner{dir: t.TempDir()})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tm.Start(ctx)
+	w, err := New(cfg, tm)
+	require.NoError(t, err)
+	require.NotNil(t, w)
+	assert.DirExists(t, filepath.Join(dir, ProcessedDir))
+
+	for _, name := range []string{"clip.mov", "broken.mov", ".partial.mov"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("media"), 0o600))
+	}
+	w.scan(ctx)
+	assert.Empty(t, tm.List(), "files are only picked up once they stopped changing")
+	w.scan(ctx)
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(dir, ProcessedDir, "clip.mov"))
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(dir, FailedDir, "broken.mov.error.txt"))
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+
+	output, err := os.ReadFile(filepath.Join(dir, OutputDir, "clip.mp4"))
+	require.NoError(t, err)
+	assert.Equal(t, "clip.mov", string(output))
+	reason, err := os.ReadFile(filepath.Join(dir, FailedDir, "broken.mov.error.txt"))
+	require.NoError(t, err)
+	assert.Contains(t, string(reason), "invalid data")
+	assert.FileExists(t, filepath.Join(dir, ".partial.mov"))
+	for _, tk := range tm.List() {
+		assert.Equal(t, "h264-720p", tk.Preset)
+	}
+
+	// A second file of the same name does not overwrite the first.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "clip.mov"), []byte("media"), 0o600))
+	w.scan(ctx)
+	w.scan(ctx)
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(dir, ProcessedDir, "clip (2).mov"))
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.FileExists(t, filepath.Join(dir, OutputDir, "clip (2).mp4"))
+}
+
+func TestNew(t *testing.T) {
+	w, err := New(&config.Config{}, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, w)
+
+	_, err = New(&config.Config{WatchFolders: map[string]string{"no-such-preset": t.TempDir()}}, nil)
+	assert.ErrorContains(t, err, "unknown preset")
+	_, err = New(&config.Config{WatchFolders: map[string]string{"mp3-192k": filepath.Join(t.TempDir(), "missing")}}, nil)
+	assert.ErrorContains(t, err, "not a directory")
+}